@@ -11,6 +11,7 @@ import (
 	statsservice "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/errors"
 	"github.com/xtls/xray-core/common/serial"
+	natservice "github.com/xtls/xray-core/proxy/nat/command"
 )
 
 type APIConfig struct {
@@ -39,6 +40,8 @@ func (c *APIConfig) Build() (*commander.Config, error) {
 			services = append(services, serial.ToTypedMessage(&observatoryservice.Config{}))
 		case "routingservice":
 			services = append(services, serial.ToTypedMessage(&routerservice.Config{}))
+		case "natservice":
+			services = append(services, serial.ToTypedMessage(&natservice.Config{}))
 		}
 	}
 
@@ -1,42 +1,858 @@
 package conf
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pelletier/go-toml"
 	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/main/confloader"
 	"github.com/xtls/xray-core/proxy/nat"
 	"google.golang.org/protobuf/proto"
 )
 
+func init() {
+	nat.RegisterRulesDecoder(decodeNATRules)
+}
+
+// decodeNATRules parses a NAT rules file, either {"rules": [...]} or a bare array of rules
+func decodeNATRules(data []byte, format string) ([]*nat.NATRule, error) {
+	rules, err := parseNATRules(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	natRules := make([]*nat.NATRule, 0, len(rules))
+	for _, rule := range rules {
+		natRule, err := rule.Build()
+		if err != nil {
+			return nil, err
+		}
+		natRules = append(natRules, natRule)
+	}
+	return natRules, nil
+}
+
+// parseNATRules parses the JSON rules of a rules file in the given format, "json", "yaml" or
+// "toml". TOML has no top level arrays, so TOML files give the rules as [[rules]] tables.
+func parseNATRules(data []byte, format string) ([]*NATRule, error) {
+	switch format {
+	case "yaml":
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, errors.New("failed to convert yaml to json").Base(err)
+		}
+		data = converted
+	case "toml":
+		tree := make(map[string]interface{})
+		if err := toml.Unmarshal(data, &tree); err != nil {
+			return nil, errors.New("failed to convert toml to map").Base(err)
+		}
+		converted, err := json.Marshal(tree)
+		if err != nil {
+			return nil, errors.New("failed to convert map to json").Base(err)
+		}
+		data = converted
+	}
+
+	var rules []*NATRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		var file struct {
+			Rules []*NATRule `json:"rules"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		rules = file.Rules
+	}
+	return rules, nil
+}
+
+// includedNATRule is a rule of the configuration or of a file of rulesInclude, with its
+// location for errors
+type includedNATRule struct {
+	rule     *NATRule
+	location string
+}
+
+// includeNATRules loads the rules of the files of rulesInclude after those of the configuration.
+// Patterns are expanded in order and the files of a glob in lexical order, so the merged rules
+// keep a stable order across loads; a file matched by several patterns is loaded once.
+func includeNATRules(rules []*NATRule, patterns []string) ([]includedNATRule, error) {
+	included := make([]includedNATRule, 0, len(rules))
+	for i, rule := range rules {
+		included = append(included, includedNATRule{rule: rule, location: fmt.Sprint("rules[", i, "]")})
+	}
+
+	loaded := make(map[string]bool)
+	for _, pattern := range patterns {
+		files := []string{pattern}
+		if !strings.Contains(pattern, "://") && strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, errors.New("invalid rulesInclude pattern ", pattern).Base(err)
+			}
+			files = matches
+		}
+		for _, file := range files {
+			if loaded[file] {
+				continue
+			}
+			loaded[file] = true
+			fileRules, err := loadNATRulesInclude(file)
+			if err != nil {
+				return nil, err
+			}
+			for i, rule := range fileRules {
+				included = append(included, includedNATRule{rule: rule, location: fmt.Sprint(file, ": rules[", i, "]")})
+			}
+		}
+	}
+	return included, nil
+}
+
+// loadNATRulesInclude reads a file of rulesInclude through the config loader of Xray, so files
+// may also be fetched over HTTP like config files
+func loadNATRulesInclude(file string) ([]*NATRule, error) {
+	var data []byte
+	var err error
+	if confloader.EffectiveConfigFileLoader == nil {
+		data, err = os.ReadFile(file)
+	} else {
+		var reader io.Reader
+		if reader, err = confloader.LoadConfig(file); err == nil {
+			data, err = io.ReadAll(reader)
+		}
+	}
+	if err != nil {
+		return nil, errors.New("failed to read NAT rules include ", file).Base(err)
+	}
+
+	rules, err := parseNATRules(data, nat.RulesFormat(file))
+	if err != nil {
+		return nil, errors.New("failed to parse NAT rules include ", file).Base(err)
+	}
+	return rules, nil
+}
+
 // NATOutboundConfig represents the JSON configuration for NAT outbound proxy
 type NATOutboundConfig struct {
-	SiteID        string           `json:"siteId"`
-	VirtualRanges []*VirtualRange `json:"virtualRanges"`
-	Rules         []*NATRule      `json:"rules"`
+	SiteID         string          `json:"siteId"`
+	UserLevel      uint32          `json:"userLevel"`
+	NAT64Prefix    string          `json:"nat64Prefix"`
+	VirtualRanges  []*VirtualRange `json:"virtualRanges"`
+	Rules          []*NATRule      `json:"rules"`
+	Objects        NATObjects      `json:"objects"`
+	RulesInclude   []string        `json:"rulesInclude"`
 	SessionTimeout *SessionTimeout `json:"sessionTimeout"`
 	ResourceLimits *ResourceLimits `json:"resourceLimits"`
+
+	PortBlockAllocation *PortBlockAllocation `json:"portBlockAllocation"`
+	EnableHairpin       bool                 `json:"enableHairpin"`
+	StaticMappings      []*StaticMapping     `json:"staticMappings"`
+	SessionLog          *SessionLog          `json:"sessionLog"`
+	AuditLog            *SessionLog          `json:"auditLog"`
+	FlowExport          *FlowExport          `json:"flowExport"`
+	SessionWebhook      *SessionWebhook      `json:"sessionWebhook"`
+	RulesFile           string               `json:"rulesFile"`
+	RuleTemplates       []*NATRuleTemplate   `json:"ruleTemplates"`
+	MatchStrategy       string               `json:"matchStrategy"`
+	ComplianceMode      string               `json:"complianceMode"`
+	SessionPersistence  *SessionPersistence  `json:"sessionPersistence"`
+	Replication         *NATReplication      `json:"replication"`
+	SharedSessions      *SharedSessions      `json:"sharedSessions"`
+	Cluster             *NATCluster          `json:"cluster"`
+	PortControl         *NATPortControl      `json:"portControl"`
+	Stun                *NATStun             `json:"stun"`
+	RuleDistribution    *NATRuleDistribution `json:"ruleDistribution"`
+	AddressPool         *NATAddressPool      `json:"addressPool"`
+	CLAT                *NATCLAT             `json:"clat"`
+	MapRules            []*NATMapRule        `json:"mapRules"`
+	FakeDNS             bool                 `json:"fakeDns"`
+	DisableSplice       bool                 `json:"disableSplice"`
+	UDPBatching         bool                 `json:"udpBatching"`
+	KernelOffload       *NATKernelOffload    `json:"kernelOffload"`
+	PerSourceLimits     *PerSourceLimits     `json:"perSourceLimits"`
+}
+
+// NATObjects are named lists of addresses, networks, ports and port ranges that rules reference
+// as "@name" instead of repeating the literals
+type NATObjects map[string]*StringList
+
+// natObjectPrefix marks a reference to an object
+const natObjectPrefix = "@"
+
+// Validate checks that every object has entries and references no other object
+func (o NATObjects) Validate() error {
+	for name, entries := range o {
+		if name == "" || strings.ContainsAny(name, ", ") {
+			return errors.New("invalid object name ", strconv.Quote(name))
+		}
+		if entries == nil || len(*entries) == 0 {
+			return errors.New("object ", name, " is empty")
+		}
+		for _, entry := range *entries {
+			if entry = strings.TrimSpace(entry); entry == "" || strings.HasPrefix(entry, natObjectPrefix) {
+				return errors.New("object ", name, ": invalid entry ", strconv.Quote(entry), ", objects hold literals only")
+			}
+		}
+	}
+	return nil
+}
+
+// resolveList replaces the references of values by the entries of their objects
+func (o NATObjects) resolveList(values []string) ([]string, error) {
+	resolved := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		name, found := strings.CutPrefix(value, natObjectPrefix)
+		if !found {
+			resolved = append(resolved, value)
+			continue
+		}
+		entries, ok := o[name]
+		if !ok {
+			return nil, errors.New("unknown object ", name)
+		}
+		for _, entry := range *entries {
+			resolved = append(resolved, strings.TrimSpace(entry))
+		}
+	}
+	return resolved, nil
+}
+
+// resolveString replaces the references of a comma-separated list, such as a port list
+func (o NATObjects) resolveString(value string) (string, error) {
+	if !strings.Contains(value, natObjectPrefix) {
+		return value, nil
+	}
+	resolved, err := o.resolveList(strings.Split(value, ","))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(resolved, ","), nil
+}
+
+// resolveRule returns rule with its references to objects resolved. A virtualDestination
+// referencing an object of several entries yields a rule for each, sharing the ruleId and
+// with it the statistics and limits of the rule.
+func (o NATObjects) resolveRule(rule *NATRule) ([]*NATRule, error) {
+	resolved := *rule
+	var err error
+	if resolved.Ports, err = o.resolveString(rule.Ports); err != nil {
+		return nil, errors.New("invalid ports").Base(err)
+	}
+	if resolved.SourcePorts, err = o.resolveString(rule.SourcePorts); err != nil {
+		return nil, errors.New("invalid sourcePorts").Base(err)
+	}
+	realDestination, err := o.resolveString(string(rule.RealDestination))
+	if err != nil {
+		return nil, errors.New("invalid realDestination").Base(err)
+	}
+	resolved.RealDestination = NATDestination(realDestination)
+	if rule.SourceAddresses != nil {
+		addresses, err := o.resolveList(*rule.SourceAddresses)
+		if err != nil {
+			return nil, errors.New("invalid sourceAddresses").Base(err)
+		}
+		resolved.SourceAddresses = NewStringList(addresses)
+	}
+
+	virtualDestinations, err := o.resolveList([]string{rule.VirtualDestination})
+	if err != nil {
+		return nil, errors.New("invalid virtualDestination").Base(err)
+	}
+	rules := make([]*NATRule, len(virtualDestinations))
+	for i, virtualDestination := range virtualDestinations {
+		expanded := resolved
+		expanded.VirtualDestination = virtualDestination
+		rules[i] = &expanded
+	}
+	return rules, nil
+}
+
+// unresolvedObject returns the first reference to an object left in a rule, "" without any
+func (r *NATRule) unresolvedObject() string {
+	values := []string{r.VirtualDestination}
+	values = append(values, strings.Split(string(r.RealDestination), ",")...)
+	values = append(values, strings.Split(r.Ports, ",")...)
+	values = append(values, strings.Split(r.SourcePorts, ",")...)
+	if r.SourceAddresses != nil {
+		values = append(values, *r.SourceAddresses...)
+	}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); strings.HasPrefix(value, natObjectPrefix) {
+			return value
+		}
+	}
+	return ""
+}
+
+// NATRuleTemplate is a rule whose string values hold numeric ranges such as "240.2.2.[1-100]".
+// It expands into one rule per number, every range of the template giving its n-th number to
+// the n-th rule, so ranges must be of the same length. A range starting with 0, such as
+// [001-100], pads the numbers to its width.
+type NATRuleTemplate struct {
+	json.RawMessage
+}
+
+// natTemplateRange matches a range of a rule template. JSON numbers and arrays never match, so
+// only ranges within strings are expanded.
+var natTemplateRange = regexp.MustCompile(`\[(\d+)-(\d+)\]`)
+
+// maxNATTemplateRules bounds the rules a template expands into
+const maxNATTemplateRules = 65536
+
+// Expand returns the rules of the template in the order of the numbers of its ranges
+func (t *NATRuleTemplate) Expand() ([]*NATRule, error) {
+	type numberRange struct {
+		from, width int
+		start, end  uint64
+	}
+	matches := natTemplateRange.FindAllSubmatchIndex(t.RawMessage, -1)
+	if len(matches) == 0 {
+		return nil, errors.New("no range such as [1-100] to expand")
+	}
+	ranges := make([]numberRange, len(matches))
+	var count uint64
+	for i, m := range matches {
+		literal := string(t.RawMessage[m[2]:m[3]])
+		start, err1 := strconv.ParseUint(literal, 10, 32)
+		end, err2 := strconv.ParseUint(string(t.RawMessage[m[4]:m[5]]), 10, 32)
+		if err1 != nil || err2 != nil || end < start {
+			return nil, errors.New("invalid range ", string(t.RawMessage[m[0]:m[1]]))
+		}
+		ranges[i] = numberRange{from: m[0], start: start, end: end}
+		if len(literal) > 1 && literal[0] == '0' {
+			ranges[i].width = len(literal)
+		}
+		switch n := end - start + 1; {
+		case i == 0:
+			count = n
+		case n != count:
+			return nil, errors.New("range ", string(t.RawMessage[m[0]:m[1]]), " expands into ", n, " numbers, ", string(t.RawMessage[matches[0][0]:matches[0][1]]), " into ", count)
+		}
+	}
+	if count > maxNATTemplateRules {
+		return nil, errors.New("template expands into ", count, " rules, more than ", maxNATTemplateRules)
+	}
+
+	rules := make([]*NATRule, 0, count)
+	for n := uint64(0); n < count; n++ {
+		var expanded []byte
+		last := 0
+		for i, m := range matches {
+			expanded = append(expanded, t.RawMessage[last:ranges[i].from]...)
+			expanded = append(expanded, fmt.Sprintf("%0*d", ranges[i].width, ranges[i].start+n)...)
+			last = m[1]
+		}
+		expanded = append(expanded, t.RawMessage[last:]...)
+		rule := new(NATRule)
+		if err := json.Unmarshal(expanded, rule); err != nil {
+			return nil, errors.New("invalid rule ", n+1).Base(err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// NATAddressPool defines the virtual addresses leased to real hosts through the API
+type NATAddressPool struct {
+	Network   string `json:"network"`
+	LeaseTime uint32 `json:"leaseTime"`
+	LeaseFile string `json:"leaseFile"`
+}
+
+// NATPortControl defines the PCP, NAT-PMP and UPnP servers clients request inbound mappings from
+type NATPortControl struct {
+	Listen               string `json:"listen"`
+	MappingAddress       string `json:"mappingAddress"`
+	ExternalAddress      string `json:"externalAddress"`
+	Ports                string `json:"ports"`
+	MaxLifetime          uint32 `json:"maxLifetime"`
+	MaxMappingsPerClient uint32 `json:"maxMappingsPerClient"`
+	UPnPListen           string `json:"upnpListen"`
+}
+
+// NATStun defines the STUN binding responder on the virtual side
+type NATStun struct {
+	Address          string `json:"address"`
+	AlternateAddress string `json:"alternateAddress"`
+	NATBehavior      string `json:"natBehavior"`
+	ExternalAddress  string `json:"externalAddress"`
+}
+
+// NATCLAT defines the customer-side translator of 464XLAT
+type NATCLAT struct {
+	PLATPrefix   string      `json:"platPrefix"`
+	SourcePrefix string      `json:"sourcePrefix"`
+	Networks     *StringList `json:"networks"`
+}
+
+// NATMapRule defines a MAP-T / MAP-E basic mapping rule
+type NATMapRule struct {
+	RuleID       string `json:"ruleId"`
+	IPv4Prefix   string `json:"ipv4Prefix"`
+	IPv6Prefix   string `json:"ipv6Prefix"`
+	EABitsLength uint32 `json:"eaBitsLength"`
+	PSIDOffset   uint32 `json:"psidOffset"`
+}
+
+// NATCluster defines partitioning of the virtual addresses among several nodes
+type NATCluster struct {
+	NodeID   string            `json:"nodeId"`
+	Nodes    []*NATClusterNode `json:"nodes"`
+	Replicas uint32            `json:"replicas"`
+}
+
+// NATClusterNode defines a node of a NAT cluster
+type NATClusterNode struct {
+	ID          string `json:"id"`
+	OutboundTag string `json:"outboundTag"`
+}
+
+// NATReplication defines session state replication between HA peers
+type NATReplication struct {
+	Listen       string `json:"listen"`
+	Peer         string `json:"peer"`
+	Secret       string `json:"secret"`
+	SyncInterval uint32 `json:"syncInterval"`
+}
+
+// NATRuleDistribution defines the distribution of the rule set from a controller to member sites
+type NATRuleDistribution struct {
+	Listen     string `json:"listen"`
+	Controller string `json:"controller"`
+	Secret     string `json:"secret"`
+}
+
+// SessionPersistence defines checkpointing of the session table to a file or Redis
+type SessionPersistence struct {
+	Path          string `json:"path"`
+	RedisAddress  string `json:"redisAddress"`
+	RedisPassword string `json:"redisPassword"`
+	RedisKey      string `json:"redisKey"`
+	Interval      uint32 `json:"interval"`
+}
+
+// SharedSessions defines the sessions gateways share through Redis
+type SharedSessions struct {
+	RedisAddress  string `json:"redisAddress"`
+	RedisPassword string `json:"redisPassword"`
+	KeyPrefix     string `json:"keyPrefix"`
+	SyncInterval  uint32 `json:"syncInterval"`
+}
+
+// NATKernelOffload defines the translations programmed into the Linux kernel with nftables
+type NATKernelOffload struct {
+	Table   string `json:"table"`
+	NftPath string `json:"nftPath"`
+	Mark    uint32 `json:"mark"`
+}
+
+// FlowExport defines IPFIX export of NAT session events
+type FlowExport struct {
+	Collector               string `json:"collector"`
+	ObservationDomainID     uint32 `json:"observationDomainId"`
+	TemplateRefreshInterval uint32 `json:"templateRefreshInterval"`
+	FlushInterval           uint32 `json:"flushInterval"`
+}
+
+// SessionWebhook defines the webhook the session events are POSTed to in batches
+type SessionWebhook struct {
+	URL           string `json:"url"`
+	BatchSize     uint32 `json:"batchSize"`
+	FlushInterval uint32 `json:"flushInterval"`
+	MaxRetries    uint32 `json:"maxRetries"`
+	Authorization string `json:"authorization"`
+}
+
+// SessionLog defines the per-session connection log
+type SessionLog struct {
+	Sink          string `json:"sink"`
+	Path          string `json:"path"`
+	MaxSizeMB     uint32 `json:"maxSizeMB"`
+	MaxBackups    uint32 `json:"maxBackups"`
+	SyslogAddress string `json:"syslogAddress"`
+}
+
+// build validates the log configured as field, nil when none is
+func (sl *SessionLog) build(field string) (*nat.SessionLog, error) {
+	if sl == nil {
+		return nil, nil
+	}
+	switch sl.Sink {
+	case "", "log", "syslog":
+	case "file":
+		if sl.Path == "" {
+			return nil, errors.New("NAT configuration: ", field, " with file sink requires a path")
+		}
+	default:
+		return nil, errors.New("NAT configuration: unknown ", field, " sink ", sl.Sink)
+	}
+	return &nat.SessionLog{
+		Sink:          sl.Sink,
+		Path:          sl.Path,
+		MaxSizeMb:     sl.MaxSizeMB,
+		MaxBackups:    sl.MaxBackups,
+		SyslogAddress: sl.SyslogAddress,
+	}, nil
+}
+
+// StaticMapping defines a one-to-one mapping between a virtual and a real address
+type StaticMapping struct {
+	VirtualAddress string `json:"virtualAddress"`
+	RealAddress    string `json:"realAddress"`
 }
 
 // VirtualRange defines a virtual IP range configuration
 type VirtualRange struct {
 	VirtualNetwork string `json:"virtualNetwork"`
 	RealNetwork    string `json:"realNetwork"`
-	IPv6Enabled   bool   `json:"ipv6Enabled"`
-	IPv6Prefix    string `json:"ipv6Prefix"`
+	IPv6Enabled    bool   `json:"ipv6Enabled"`
+	IPv6Prefix     string `json:"ipv6Prefix"`
+	IPv4To6Prefix  string `json:"ipv4To6Prefix"`
+
+	NPTv6VirtualPrefix string `json:"npTv6VirtualPrefix"`
+	NPTv6RealPrefix    string `json:"npTv6RealPrefix"`
+
+	SendThrough string `json:"sendThrough"`
+
+	SourceSite         string `json:"sourceSite"`
+	Symmetric          bool   `json:"symmetric"`
+	PeerSite           string `json:"peerSite"`
+	PeerVirtualNetwork string `json:"peerVirtualNetwork"`
+	PeerRealNetwork    string `json:"peerRealNetwork"`
+
+	Masquerade        bool        `json:"masquerade"`
+	MasqueradePorts   string      `json:"masqueradePorts"`
+	MasqueradePool    *StringList `json:"masqueradePool"`
+	MasqueradePooling string      `json:"masqueradePooling"`
+	MaxSessions       uint32      `json:"maxSessions"`
+
+	TOS         uint32 `json:"tos"`
+	PreserveTOS bool   `json:"preserveTos"`
+	FlowLabel   string `json:"flowLabel"`
+
+	Tags    *StringList `json:"tags"`
+	Enabled *bool       `json:"enabled"`
 }
 
 // NATRule defines a NAT translation rule
 type NATRule struct {
-	RuleID            string      `json:"ruleId"`
-	SourceSite        string      `json:"sourceSite"`
-	VirtualDestination string      `json:"virtualDestination"`
-	RealDestination   string      `json:"realDestination"`
-	Protocol          string      `json:"protocol"`
-	PortMapping       *PortMapping `json:"portMapping"`
+	RuleID             string         `json:"ruleId"`
+	SourceSite         string         `json:"sourceSite"`
+	VirtualDestination string         `json:"virtualDestination"`
+	RealDestination    NATDestination `json:"realDestination"`
+	Protocol           string         `json:"protocol"`
+	PortMapping        *PortMapping   `json:"portMapping"`
+	PortOffset         int32          `json:"portOffset"`
+	NATBehavior        string         `json:"natBehavior"`
+	Strategy           string         `json:"strategy"`
+	Priority           int32          `json:"priority"`
+	Action             string         `json:"action"`
+	Ports              string         `json:"ports"`
+	DomainStrategy     string         `json:"domainStrategy"`
+	SourceGeoIP        *StringList    `json:"sourceGeoIP"`
+	DestGeoIP          *StringList    `json:"destGeoIP"`
+	DestGeoSite        *StringList    `json:"destGeoSite"`
+	Schedule           *NATSchedule   `json:"schedule"`
+	SourceAddresses    *StringList    `json:"sourceAddresses"`
+	SourcePorts        string         `json:"sourcePorts"`
+	Users              *StringList    `json:"users"`
+	UserLevels         []uint32       `json:"userLevels"`
+	ALG                *StringList    `json:"alg"`
+	ReuseConnections   bool           `json:"reuseConnections"`
+	Sockopt            *NATSockopt    `json:"sockopt"`
+	ForwardTag         string         `json:"forwardTag"`
+	Domains            *StringList    `json:"domains"`
+	RateLimit          *NATRateLimit  `json:"rateLimit"`
+	MaxSessions        uint32         `json:"maxSessions"`
+	SessionClass       string         `json:"sessionClass"`
+	TCPMSSClamp        uint32         `json:"tcpMssClamp"`
+	MTU                uint32         `json:"mtu"`
+	MTUPolicy          string         `json:"mtuPolicy"`
+	Tags               *StringList    `json:"tags"`
+	Enabled            *bool          `json:"enabled"`
+	ExpiresAt          string         `json:"expiresAt"`
+	TTL                uint32         `json:"ttl"`
+}
+
+// NATRateLimit defines the bandwidth of each flow of a rule
+type NATRateLimit struct {
+	UplinkBytesPerSecond   uint64 `json:"uplinkBytesPerSecond"`
+	DownlinkBytesPerSecond uint64 `json:"downlinkBytesPerSecond"`
+	BurstBytes             uint64 `json:"burstBytes"`
+}
+
+// NATSockopt is the socket options the real destinations of a rule are dialed with: those of
+// the sockopt of streamSettings, the TOS byte of the packets and the IPv6 flow label
+type NATSockopt struct {
+	SocketConfig
+	TOS         uint32 `json:"tos"`
+	PreserveTOS bool   `json:"preserveTos"`
+	FlowLabel   string `json:"flowLabel"`
+}
+
+// NATSchedule defines the time window in which a rule is active
+type NATSchedule struct {
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
+}
+
+// NATDestination is a real destination. It is given as a string, where a comma-separated
+// list forms a backend pool, or as a JSON array of addresses and CIDR pools.
+type NATDestination string
+
+// UnmarshalJSON implements encoding/json.Unmarshaler.UnmarshalJSON
+func (d *NATDestination) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*d = NATDestination(strings.Join(list, ","))
+		return nil
+	}
+
+	var destination string
+	if err := json.Unmarshal(data, &destination); err != nil {
+		return errors.New("unknown format of a NAT destination: ", string(data))
+	}
+	*d = NATDestination(destination)
+	return nil
+}
+
+// Build converts the JSON rule into its protobuf form
+func (r *NATRule) Build() (*nat.NATRule, error) {
+	if r.VirtualDestination == "" {
+		return nil, errors.New("NAT rule: virtualDestination is required")
+	}
+	if reference := r.unresolvedObject(); reference != "" {
+		return nil, errors.New("NAT rule ", r.RuleID, ": ", reference, " references an object, only rules of the NAT configuration can use objects")
+	}
+	if err := nat.ValidateDestinationPattern(r.VirtualDestination); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid virtualDestination").Base(err)
+	}
+	if err := validateNATBehavior(r.NATBehavior); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid natBehavior").Base(err)
+	}
+	if err := nat.ValidateRuleAction(r.Action); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid action").Base(err)
+	}
+	if err := nat.ValidateProtocol(r.Protocol); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid protocol").Base(err)
+	}
+	if err := nat.ValidatePorts(r.Ports); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid ports").Base(err)
+	}
+	if err := nat.ValidateDomainStrategy(r.DomainStrategy); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid domainStrategy").Base(err)
+	}
+	if err := nat.ValidateSessionClass(r.SessionClass); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid sessionClass").Base(err)
+	}
+
+	selectsOnly := r.Action == "bypass" || r.Action == "deny" || r.Action == "reject"
+	if selectsOnly && (r.RealDestination != "" || r.PortMapping != nil || r.PortOffset != 0 || r.ALG != nil || r.ReuseConnections || r.Sockopt != nil || r.ForwardTag != "" || r.RateLimit != nil || r.MaxSessions != 0 ||
+		r.SessionClass != "" || r.TCPMSSClamp != 0 || r.MTU != 0 || r.MTUPolicy != "") {
+		return nil, errors.New("NAT rule ", r.RuleID, ": ", r.Action, " rules take no realDestination, portMapping, portOffset, alg, reuseConnections, sockopt, forwardTag, rateLimit, maxSessions, sessionClass, tcpMssClamp, mtu or mtuPolicy")
+	}
+	if r.PortOffset != 0 && r.PortMapping != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": portMapping and portOffset are mutually exclusive")
+	}
+	if err := nat.ValidatePortOffset(r.PortOffset, r.Ports); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid portOffset").Base(err)
+	}
+	if r.ForwardTag != "" && r.Sockopt != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": forwardTag and sockopt are mutually exclusive")
+	}
+	if r.ForwardTag != "" && (r.TCPMSSClamp != 0 || r.MTU != 0) {
+		return nil, errors.New("NAT rule ", r.RuleID, ": forwardTag takes no tcpMssClamp or mtu, the forwarding outbound owns the sockets")
+	}
+	if err := nat.ValidateMTU(r.TCPMSSClamp, r.MTU, r.MTUPolicy); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid mtu").Base(err)
+	}
+	if r.ReuseConnections {
+		if err := nat.ValidateReuseConnections(r.Protocol); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid reuseConnections").Base(err)
+		}
+	}
+
+	natRule := &nat.NATRule{
+		RuleId:             r.RuleID,
+		VirtualDestination: r.VirtualDestination,
+		RealDestination:    string(r.RealDestination),
+		Protocol:           r.Protocol,
+		SourceSite:         r.SourceSite,
+		NatBehavior:        r.NATBehavior,
+		Priority:           r.Priority,
+		Action:             r.Action,
+		Ports:              r.Ports,
+		PortOffset:         r.PortOffset,
+		DomainStrategy:     r.DomainStrategy,
+		ReuseConnections:   r.ReuseConnections,
+		ForwardTag:         r.ForwardTag,
+		MaxSessions:        r.MaxSessions,
+		SessionClass:       r.SessionClass,
+		TcpMssClamp:        r.TCPMSSClamp,
+		Mtu:                r.MTU,
+		MtuPolicy:          r.MTUPolicy,
+	}
+
+	if r.SourceAddresses != nil {
+		if err := nat.ValidateSourceAddresses(*r.SourceAddresses); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid sourceAddresses").Base(err)
+		}
+		natRule.SourceAddresses = *r.SourceAddresses
+	}
+	if err := nat.ValidatePorts(r.SourcePorts); err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid sourcePorts").Base(err)
+	}
+	natRule.SourcePorts = r.SourcePorts
+	if r.Users != nil {
+		natRule.Users = *r.Users
+	}
+	if r.Domains != nil {
+		if err := nat.ValidateDomainPatterns(*r.Domains); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid domains").Base(err)
+		}
+		natRule.Domains = *r.Domains
+	}
+	natRule.UserLevels = r.UserLevels
+	tags, err := natTags(r.Tags)
+	if err != nil {
+		return nil, errors.New("NAT rule ", r.RuleID, ": invalid tags").Base(err)
+	}
+	natRule.Tags = tags
+	natRule.Disabled = r.Enabled != nil && !*r.Enabled
+	if r.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, r.ExpiresAt)
+		if err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid expiresAt, expected an RFC 3339 time such as 2006-01-02T15:04:05Z").Base(err)
+		}
+		natRule.ExpiresAt = expiresAt.Unix()
+	}
+	natRule.Ttl = r.TTL
+	if rl := r.RateLimit; rl != nil {
+		if rl.UplinkBytesPerSecond == 0 && rl.DownlinkBytesPerSecond == 0 {
+			return nil, errors.New("NAT rule ", r.RuleID, ": rateLimit needs uplinkBytesPerSecond or downlinkBytesPerSecond")
+		}
+		natRule.RateLimit = &nat.RateLimit{
+			UplinkBytesPerSecond:   rl.UplinkBytesPerSecond,
+			DownlinkBytesPerSecond: rl.DownlinkBytesPerSecond,
+			BurstBytes:             rl.BurstBytes,
+		}
+	}
+
+	if r.Schedule != nil {
+		natRule.Schedule = &nat.Schedule{
+			Days:     r.Schedule.Days,
+			Start:    r.Schedule.Start,
+			End:      r.Schedule.End,
+			Timezone: r.Schedule.Timezone,
+		}
+		if err := nat.ValidateSchedule(natRule.Schedule); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid schedule").Base(err)
+		}
+	}
+
+	// Geo conditions reuse the geoip and geosite loaders of routing rules
+	if r.SourceGeoIP != nil {
+		geoips, err := ToCidrList(*r.SourceGeoIP)
+		if err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid sourceGeoIP").Base(err)
+		}
+		natRule.SourceGeoip = geoips
+	}
+	if r.DestGeoIP != nil {
+		geoips, err := ToCidrList(*r.DestGeoIP)
+		if err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid destGeoIP").Base(err)
+		}
+		natRule.DestGeoip = geoips
+	}
+	if r.DestGeoSite != nil {
+		for _, site := range *r.DestGeoSite {
+			domains, err := parseDomainRule(site)
+			if err != nil {
+				return nil, errors.New("NAT rule ", r.RuleID, ": invalid destGeoSite ", site).Base(err)
+			}
+			natRule.DestGeosite = append(natRule.DestGeosite, domains...)
+		}
+	}
+
+	// Bypass, deny and reject rules only select flows, which leave untranslated or are blocked
+	if selectsOnly {
+		return natRule, nil
+	}
+
+	// A list of real destinations or a strategy makes the rule balance over a backend pool
+	if destinations := strings.Split(string(r.RealDestination), ","); len(destinations) > 1 || r.Strategy != "" {
+		if err := nat.ValidateBackendPool(destinations, r.Strategy); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid realDestination pool").Base(err)
+		}
+		natRule.RealDestination = ""
+		natRule.RealDestinations = destinations
+		natRule.Strategy = r.Strategy
+	}
+
+	if r.Sockopt != nil {
+		if r.Sockopt.DialerProxy != "" {
+			return nil, errors.New("NAT rule ", r.RuleID, ": sockopt takes no dialerProxy")
+		}
+		if r.Sockopt.TOS > 255 {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid sockopt tos ", r.Sockopt.TOS)
+		}
+		if err := nat.ValidateFlowLabel(r.Sockopt.FlowLabel); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid sockopt").Base(err)
+		}
+		sockopt, err := r.Sockopt.SocketConfig.Build()
+		if err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid sockopt").Base(err)
+		}
+		natRule.Sockopt = sockopt
+		natRule.Tos = r.Sockopt.TOS
+		natRule.PreserveTos = r.Sockopt.PreserveTOS
+		natRule.FlowLabel = r.Sockopt.FlowLabel
+	}
+
+	if r.ALG != nil {
+		if err := nat.ValidateALGs(*r.ALG); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid alg").Base(err)
+		}
+		natRule.Alg = *r.ALG
+	}
+
+	// Add port mapping if specified
+	if r.PortMapping != nil {
+		natRule.PortMapping = &nat.PortMapping{
+			OriginalPort:   r.PortMapping.OriginalPort,
+			TranslatedPort: r.PortMapping.TranslatedPort,
+			ProtocolPorts:  r.PortMapping.ProtocolPorts,
+		}
+		if err := natRule.PortMapping.Validate(); err != nil {
+			return nil, errors.New("NAT rule ", r.RuleID, ": invalid portMapping").Base(err)
+		}
+	}
+
+	return natRule, nil
 }
 
 // PortMapping defines port mapping configuration
 type PortMapping struct {
-	OriginalPort    string `json:"originalPort"`
-	TranslatedPort  string `json:"translatedPort"`
+	OriginalPort   string            `json:"originalPort"`
+	TranslatedPort string            `json:"translatedPort"`
+	ProtocolPorts  map[string]string `json:"protocolPorts"`
 }
 
 // SessionTimeout defines session timeout configuration
@@ -44,102 +860,779 @@ type SessionTimeout struct {
 	TCPTimeout      uint32 `json:"tcpTimeout"`
 	UDPTimeout      uint32 `json:"udpTimeout"`
 	CleanupInterval uint32 `json:"cleanupInterval"`
+
+	ICMPTimeout           uint32 `json:"icmpTimeout"`
+	EstablishedTCPTimeout uint32 `json:"establishedTcpTimeout"`
+	TransitoryTCPTimeout  uint32 `json:"transitoryTcpTimeout"`
+	SCTPTimeout           uint32 `json:"sctpTimeout"`
 }
 
 // ResourceLimits defines resource limits configuration
 type ResourceLimits struct {
-	MaxSessions      uint32  `json:"maxSessions"`
-	MaxMemoryMB     uint32  `json:"maxMemoryMB"`
-	CleanupThreshold float32 `json:"cleanupThreshold"`
+	MaxSessions          uint32  `json:"maxSessions"`
+	MaxMemoryMB          uint32  `json:"maxMemoryMB"`
+	CleanupThreshold     float32 `json:"cleanupThreshold"`
+	DropNoisiestSources  bool    `json:"dropNoisiestSources"`
+	NewSessionsPerSecond uint32  `json:"newSessionsPerSecond"`
+	NewSessionBurst      uint32  `json:"newSessionBurst"`
+}
+
+// PerSourceLimits defines the session quotas and new session rate limits of each virtual source address
+type PerSourceLimits struct {
+	MaxSessions          uint32 `json:"maxSessions"`
+	NewSessionsPerSecond uint32 `json:"newSessionsPerSecond"`
+	Burst                uint32 `json:"burst"`
+}
+
+// PortBlockAllocation defines carrier-grade NAT port block allocation
+type PortBlockAllocation struct {
+	PublicAddress          string `json:"publicAddress"`
+	PortRangeStart         uint32 `json:"portRangeStart"`
+	PortRangeEnd           uint32 `json:"portRangeEnd"`
+	BlockSize              uint32 `json:"blockSize"`
+	MaxBlocksPerSubscriber uint32 `json:"maxBlocksPerSubscriber"`
+	Deterministic          bool   `json:"deterministic"`
+	SubscriberNetwork      string `json:"subscriberNetwork"`
 }
 
 // Build implements Buildable interface for NAT outbound configuration
 func (c *NATOutboundConfig) Build() (proto.Message, error) {
 	config := &nat.Config{
-		SiteId: c.SiteID,
+		SiteId:         c.SiteID,
+		UserLevel:      c.UserLevel,
+		Nat64Prefix:    c.NAT64Prefix,
+		EnableHairpin:  c.EnableHairpin,
+		RulesFile:      c.RulesFile,
+		MatchStrategy:  c.MatchStrategy,
+		FakeDns:        c.FakeDNS,
+		ComplianceMode: c.ComplianceMode,
+		DisableSplice:  c.DisableSplice,
+		UdpBatching:    c.UDPBatching,
 	}
 
 	// Validate basic configuration
 	if c.SiteID == "" {
 		return nil, errors.New("NAT configuration: siteId is required")
 	}
+	if err := nat.ValidateMatchStrategy(c.MatchStrategy); err != nil {
+		return nil, errors.New("NAT configuration: invalid matchStrategy").Base(err)
+	}
+	if err := nat.ValidateComplianceMode(c.ComplianceMode); err != nil {
+		return nil, errors.New("NAT configuration: invalid complianceMode").Base(err)
+	}
+	if ip := net.ParseIP(c.NAT64Prefix); c.NAT64Prefix != "" && (ip == nil || ip.To4() != nil) {
+		return nil, errors.New("NAT configuration: invalid nat64Prefix ", c.NAT64Prefix)
+	}
 
 	// Process virtual IP ranges
 	if len(c.VirtualRanges) > 0 {
 		config.VirtualRanges = make([]*nat.VirtualIPRange, len(c.VirtualRanges))
 		for i, vr := range c.VirtualRanges {
-			if vr.VirtualNetwork == "" || vr.RealNetwork == "" {
-				return nil, errors.New("NAT virtual range: both virtualNetwork and realNetwork are required")
+			location := fmt.Sprint("NAT configuration: virtualRanges[", i, "]")
+			isNPTv6 := vr.NPTv6VirtualPrefix != "" || vr.NPTv6RealPrefix != ""
+			if isNPTv6 {
+				if err := validateNPTv6Prefixes(vr.NPTv6VirtualPrefix, vr.NPTv6RealPrefix); err != nil {
+					return nil, errors.New(location, ": invalid NPTv6 prefixes").Base(err)
+				}
+			} else if vr.VirtualNetwork == "" || vr.RealNetwork == "" {
+				return nil, errors.New(location, ": both virtualNetwork and realNetwork are required")
+			} else if err := validateRangeNetworks(vr.VirtualNetwork, vr.RealNetwork, vr.Masquerade); err != nil {
+				return nil, errors.New(location, ": invalid networks").Base(err)
+			}
+			var masqueradePool []string
+			if vr.Masquerade {
+				if isNPTv6 || vr.IPv4To6Prefix != "" || vr.Symmetric {
+					return nil, errors.New(location, ": masquerade cannot be combined with NPTv6, ipv4To6Prefix or symmetric")
+				}
+				if strings.EqualFold(strings.TrimSpace(vr.MasqueradePorts), "any") {
+					return nil, errors.New(location, ": masqueradePorts cannot be any")
+				}
+				if err := nat.ValidatePorts(vr.MasqueradePorts); err != nil {
+					return nil, errors.New(location, ": invalid masqueradePorts").Base(err)
+				}
+				if vr.MasqueradePool != nil {
+					masqueradePool = *vr.MasqueradePool
+				}
+				if err := nat.ValidateMasqueradePool(masqueradePool, vr.MasqueradePooling); err != nil {
+					return nil, errors.New(location, ": invalid masqueradePool").Base(err)
+				}
+			} else if vr.MasqueradePorts != "" || vr.MasqueradePool != nil || vr.MasqueradePooling != "" {
+				return nil, errors.New(location, ": masqueradePorts, masqueradePool and masqueradePooling require masquerade")
+			}
+			if vr.IPv6Prefix != "" {
+				if err := validateNAT46Prefix(vr.IPv6Prefix); err != nil {
+					return nil, errors.New(location, ": invalid ipv6Prefix").Base(err)
+				}
+			}
+			if vr.IPv4To6Prefix != "" {
+				if err := validateNAT46Prefix(vr.IPv4To6Prefix); err != nil {
+					return nil, errors.New(location, ": invalid ipv4To6Prefix").Base(err)
+				}
+			}
+			if vr.SendThrough != "" && net.ParseIP(vr.SendThrough) == nil {
+				return nil, errors.New(location, ": invalid sendThrough ", vr.SendThrough)
+			}
+			if vr.TOS > 255 {
+				return nil, errors.New(location, ": invalid tos ", vr.TOS)
+			}
+			if err := nat.ValidateFlowLabel(vr.FlowLabel); err != nil {
+				return nil, errors.New(location, ": invalid flowLabel").Base(err)
+			}
+			tags, err := natTags(vr.Tags)
+			if err != nil {
+				return nil, errors.New(location, ": invalid tags").Base(err)
+			}
+			if vr.Symmetric {
+				if vr.PeerSite == "" {
+					return nil, errors.New(location, ": symmetric requires peerSite")
+				}
+				if isNPTv6 || vr.IPv4To6Prefix != "" {
+					return nil, errors.New(location, ": symmetric cannot be combined with NPTv6 or ipv4To6Prefix")
+				}
+				for _, network := range []string{vr.PeerVirtualNetwork, vr.PeerRealNetwork} {
+					if _, _, err := net.ParseCIDR(network); network != "" && err != nil {
+						return nil, errors.New(location, ": invalid peer network ", network).Base(err)
+					}
+				}
+			} else if vr.PeerSite != "" || vr.PeerVirtualNetwork != "" || vr.PeerRealNetwork != "" {
+				return nil, errors.New(location, ": peerSite and peer networks require symmetric")
 			}
 
 			config.VirtualRanges[i] = &nat.VirtualIPRange{
-				VirtualNetwork: vr.VirtualNetwork,
-				RealNetwork:    vr.RealNetwork,
-				Ipv6Enabled:   vr.IPv6Enabled,
-				Ipv6VirtualPrefix: vr.IPv6Prefix,
+				VirtualNetwork:     vr.VirtualNetwork,
+				RealNetwork:        vr.RealNetwork,
+				Ipv6Enabled:        vr.IPv6Enabled,
+				Ipv6VirtualPrefix:  vr.IPv6Prefix,
+				Ipv4To6Prefix:      vr.IPv4To6Prefix,
+				NpTv6VirtualPrefix: vr.NPTv6VirtualPrefix,
+				NpTv6RealPrefix:    vr.NPTv6RealPrefix,
+				SendThrough:        vr.SendThrough,
+				SourceSite:         vr.SourceSite,
+				Symmetric:          vr.Symmetric,
+				PeerSite:           vr.PeerSite,
+				PeerVirtualNetwork: vr.PeerVirtualNetwork,
+				PeerRealNetwork:    vr.PeerRealNetwork,
+				Masquerade:         vr.Masquerade,
+				MasqueradePorts:    vr.MasqueradePorts,
+				MasqueradePool:     masqueradePool,
+				MasqueradePooling:  vr.MasqueradePooling,
+				MaxSessions:        vr.MaxSessions,
+				Tos:                vr.TOS,
+				PreserveTos:        vr.PreserveTOS,
+				FlowLabel:          vr.FlowLabel,
+				Tags:               tags,
+				Disabled:           vr.Enabled != nil && !*vr.Enabled,
+			}
+		}
+		if err := validateRangeOverlaps(c.VirtualRanges); err != nil {
+			return nil, errors.New("NAT configuration: overlapping virtualRanges").Base(err)
+		}
+	}
+
+	// Process NAT rules, with their references to objects resolved
+	if err := c.Objects.Validate(); err != nil {
+		return nil, errors.New("NAT configuration: invalid objects").Base(err)
+	}
+	rules, err := includeNATRules(c.Rules, c.RulesInclude)
+	if err != nil {
+		return nil, errors.New("NAT configuration: invalid rulesInclude").Base(err)
+	}
+	for i, template := range c.RuleTemplates {
+		expanded, err := template.Expand()
+		if err != nil {
+			return nil, errors.New("NAT configuration: invalid ruleTemplates[", i, "]").Base(err)
+		}
+		for n, rule := range expanded {
+			rules = append(rules, includedNATRule{rule: rule, location: fmt.Sprint("ruleTemplates[", i, "] rule ", n+1)})
+		}
+	}
+	if len(rules) > 0 {
+		config.Rules = make([]*nat.NATRule, 0, len(rules))
+		ruleLocation := make(map[string]string, len(rules))
+		for _, included := range rules {
+			rule, location := included.rule, included.location
+			if rule.TTL != 0 || rule.ExpiresAt != "" {
+				return nil, errors.New("NAT configuration: ", location, ": ttl and expiresAt are only supported by the rules of rulesFile")
+			}
+			resolved, err := c.Objects.resolveRule(rule)
+			if err != nil {
+				return nil, errors.New("NAT configuration: ", location, ": NAT rule ", rule.RuleID).Base(err)
+			}
+			for _, expanded := range resolved {
+				natRule, err := expanded.Build()
+				if err != nil {
+					return nil, errors.New("NAT configuration: ", location).Base(err)
+				}
+				config.Rules = append(config.Rules, natRule)
+			}
+			if rule.RuleID != "" {
+				if first, found := ruleLocation[rule.RuleID]; found {
+					return nil, errors.New("NAT configuration: ", location, ": ruleId ", rule.RuleID, " is already used by ", first)
+				}
+				ruleLocation[rule.RuleID] = location
+			}
+		}
+	}
+
+	// Process static 1:1 mappings
+	for _, mapping := range c.StaticMappings {
+		config.StaticMappings = append(config.StaticMappings, &nat.StaticMapping{
+			VirtualAddress: mapping.VirtualAddress,
+			RealAddress:    mapping.RealAddress,
+		})
+	}
+	if err := nat.ValidateStaticMappings(config.StaticMappings); err != nil {
+		return nil, errors.New("NAT configuration: invalid staticMappings").Base(err)
+	}
+
+	// Process session and audit log configuration
+	if config.SessionLog, err = c.SessionLog.build("sessionLog"); err != nil {
+		return nil, err
+	}
+	if config.AuditLog, err = c.AuditLog.build("auditLog"); err != nil {
+		return nil, err
+	}
+
+	// Process IPFIX flow export configuration
+	if fe := c.FlowExport; fe != nil {
+		if _, _, err := net.SplitHostPort(fe.Collector); err != nil {
+			return nil, errors.New("NAT configuration: invalid flowExport collector ", fe.Collector).Base(err)
+		}
+		config.FlowExport = &nat.FlowExport{
+			Collector:               fe.Collector,
+			ObservationDomainId:     fe.ObservationDomainID,
+			TemplateRefreshInterval: fe.TemplateRefreshInterval,
+			FlushInterval:           fe.FlushInterval,
+		}
+	}
+
+	// Process session webhook configuration
+	if sw := c.SessionWebhook; sw != nil {
+		if target, err := url.Parse(sw.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return nil, errors.New("NAT configuration: sessionWebhook requires an http or https url, got ", sw.URL)
+		}
+		config.SessionWebhook = &nat.SessionWebhook{
+			Url:           sw.URL,
+			BatchSize:     sw.BatchSize,
+			FlushInterval: sw.FlushInterval,
+			MaxRetries:    sw.MaxRetries,
+			Authorization: sw.Authorization,
+		}
+	}
+
+	// Process session persistence configuration
+	if sp := c.SessionPersistence; sp != nil {
+		if sp.Path == "" && sp.RedisAddress == "" {
+			return nil, errors.New("NAT configuration: sessionPersistence needs a path or a redisAddress")
+		}
+		if sp.Path == "" {
+			if _, _, err := net.SplitHostPort(sp.RedisAddress); err != nil {
+				return nil, errors.New("NAT configuration: invalid sessionPersistence redisAddress ", sp.RedisAddress).Base(err)
 			}
 		}
+		config.SessionPersistence = &nat.SessionPersistence{
+			Path:          sp.Path,
+			RedisAddress:  sp.RedisAddress,
+			RedisPassword: sp.RedisPassword,
+			RedisKey:      sp.RedisKey,
+			Interval:      sp.Interval,
+		}
 	}
 
-	// Process NAT rules
-	if len(c.Rules) > 0 {
-		config.Rules = make([]*nat.NATRule, len(c.Rules))
-		for i, rule := range c.Rules {
-			if rule.VirtualDestination == "" {
-				return nil, errors.New("NAT rule: virtualDestination is required")
+	// Process session replication configuration
+	if rc := c.Replication; rc != nil {
+		if rc.Listen == "" && rc.Peer == "" {
+			return nil, errors.New("NAT configuration: replication needs a listen or a peer address")
+		}
+		for _, address := range []string{rc.Listen, rc.Peer} {
+			if address == "" {
+				continue
 			}
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return nil, errors.New("NAT configuration: invalid replication address ", address).Base(err)
+			}
+		}
+		config.Replication = &nat.Replication{
+			Listen:       rc.Listen,
+			Peer:         rc.Peer,
+			Secret:       rc.Secret,
+			SyncInterval: rc.SyncInterval,
+		}
+	}
+
+	// Process session sharing configuration
+	if ss := c.SharedSessions; ss != nil {
+		if _, _, err := net.SplitHostPort(ss.RedisAddress); err != nil {
+			return nil, errors.New("NAT configuration: invalid sharedSessions redisAddress ", ss.RedisAddress).Base(err)
+		}
+		config.SharedSessions = &nat.SharedSessions{
+			RedisAddress:  ss.RedisAddress,
+			RedisPassword: ss.RedisPassword,
+			KeyPrefix:     ss.KeyPrefix,
+			SyncInterval:  ss.SyncInterval,
+		}
+	}
+
+	// Process kernel offload configuration
+	if ko := c.KernelOffload; ko != nil {
+		if c.MatchStrategy != "" && c.MatchStrategy != "firstMatch" {
+			return nil, errors.New("NAT configuration: kernelOffload needs the firstMatch matchStrategy")
+		}
+		config.KernelOffload = &nat.KernelOffload{
+			Table:   ko.Table,
+			NftPath: ko.NftPath,
+			Mark:    ko.Mark,
+		}
+	}
 
-			natRule := &nat.NATRule{
-				RuleId:            rule.RuleID,
-				VirtualDestination: rule.VirtualDestination,
-				RealDestination:   rule.RealDestination,
-				Protocol:          rule.Protocol,
-				SourceSite:        rule.SourceSite,
+	// Process rule distribution configuration
+	if rd := c.RuleDistribution; rd != nil {
+		if (rd.Listen == "") == (rd.Controller == "") {
+			return nil, errors.New("NAT configuration: ruleDistribution needs either a listen or a controller address")
+		}
+		for _, address := range []string{rd.Listen, rd.Controller} {
+			if address == "" {
+				continue
 			}
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return nil, errors.New("NAT configuration: invalid ruleDistribution address ", address).Base(err)
+			}
+		}
+		if rd.Controller != "" && c.RulesFile != "" {
+			return nil, errors.New("NAT configuration: members of a rule controller cannot use a rulesFile")
+		}
+		config.RuleDistribution = &nat.RuleDistribution{
+			Listen:     rd.Listen,
+			Controller: rd.Controller,
+			Secret:     rd.Secret,
+		}
+	}
 
-			// Add port mapping if specified
-			if rule.PortMapping != nil {
-				natRule.PortMapping = &nat.PortMapping{
-					OriginalPort:    rule.PortMapping.OriginalPort,
-					TranslatedPort:  rule.PortMapping.TranslatedPort,
-				}
+	// Process cluster configuration
+	if cc := c.Cluster; cc != nil {
+		config.Cluster = &nat.Cluster{
+			NodeId:   cc.NodeID,
+			Replicas: cc.Replicas,
+		}
+		for _, node := range cc.Nodes {
+			config.Cluster.Nodes = append(config.Cluster.Nodes, &nat.ClusterNode{
+				Id:          node.ID,
+				OutboundTag: node.OutboundTag,
+			})
+		}
+		if err := nat.ValidateCluster(config.Cluster); err != nil {
+			return nil, errors.New("NAT configuration: invalid cluster").Base(err)
+		}
+	}
+
+	// Process port control configuration
+	if pc := c.PortControl; pc != nil {
+		for _, address := range []string{pc.MappingAddress, pc.ExternalAddress} {
+			if address != "" && net.ParseIP(address) == nil {
+				return nil, errors.New("NAT configuration: invalid port control address ", address)
+			}
+		}
+		if pc.UPnPListen != "" {
+			if _, _, err := net.SplitHostPort(pc.UPnPListen); err != nil {
+				return nil, errors.New("NAT configuration: invalid UPnP listen address ", pc.UPnPListen).Base(err)
 			}
+		}
+		if pc.Ports != "" {
+			if err := nat.ValidatePorts(pc.Ports); err != nil {
+				return nil, errors.New("NAT configuration: invalid port control ports").Base(err)
+			}
+		}
+		config.PortControl = &nat.PortControl{
+			Listen:               pc.Listen,
+			MappingAddress:       pc.MappingAddress,
+			ExternalAddress:      pc.ExternalAddress,
+			Ports:                pc.Ports,
+			MaxLifetime:          pc.MaxLifetime,
+			MaxMappingsPerClient: pc.MaxMappingsPerClient,
+			UpnpListen:           pc.UPnPListen,
+		}
+	}
 
-			config.Rules[i] = natRule
+	// Process STUN responder configuration
+	if st := c.Stun; st != nil {
+		if net.ParseIP(st.Address) == nil {
+			return nil, errors.New("NAT configuration: invalid STUN address ", st.Address)
+		}
+		for _, address := range []string{st.AlternateAddress, st.ExternalAddress} {
+			if address != "" && net.ParseIP(address) == nil {
+				return nil, errors.New("NAT configuration: invalid STUN address ", address)
+			}
+		}
+		if err := validateNATBehavior(st.NATBehavior); err != nil {
+			return nil, errors.New("NAT configuration: invalid STUN natBehavior").Base(err)
+		}
+		config.Stun = &nat.Stun{
+			Address:          st.Address,
+			AlternateAddress: st.AlternateAddress,
+			NatBehavior:      st.NATBehavior,
+			ExternalAddress:  st.ExternalAddress,
+		}
+	}
+
+	// Process CLAT configuration
+	if cl := c.CLAT; cl != nil {
+		config.Clat = &nat.Clat{
+			PlatPrefix:   cl.PLATPrefix,
+			SourcePrefix: cl.SourcePrefix,
+		}
+		if cl.Networks != nil {
+			config.Clat.Networks = *cl.Networks
+		}
+		if err := nat.ValidateCLAT(config.Clat); err != nil {
+			return nil, errors.New("NAT configuration: invalid clat").Base(err)
+		}
+	}
+
+	// Process MAP rules; their flows hold no session and are told apart by ruleId
+	for i, mr := range c.MapRules {
+		mapRule := &nat.MapRule{
+			RuleId:       mr.RuleID,
+			Ipv4Prefix:   mr.IPv4Prefix,
+			Ipv6Prefix:   mr.IPv6Prefix,
+			EaBitsLength: mr.EABitsLength,
+			PsidOffset:   mr.PSIDOffset,
+		}
+		if err := nat.ValidateMapRule(mapRule); err != nil {
+			return nil, errors.New("NAT configuration: invalid mapRules[", i, "]").Base(err)
+		}
+		id := mapRule.RuleId
+		if id == "" {
+			id = "map-" + mapRule.Ipv4Prefix
+		}
+		for _, rule := range config.Rules {
+			if rule.RuleId == id {
+				return nil, errors.New("NAT configuration: mapRules[", i, "] shares the ruleId ", id, " of a rule")
+			}
+		}
+		config.MapRules = append(config.MapRules, mapRule)
+	}
+
+	// Process address pool configuration
+	if ap := c.AddressPool; ap != nil {
+		if _, _, err := net.ParseCIDR(ap.Network); err != nil {
+			return nil, errors.New("NAT configuration: invalid addressPool network ", ap.Network).Base(err)
+		}
+		config.AddressPool = &nat.AddressPool{
+			Network:   ap.Network,
+			LeaseTime: ap.LeaseTime,
+			LeaseFile: ap.LeaseFile,
 		}
 	}
 
 	// Process session timeout configuration
 	if c.SessionTimeout != nil {
 		config.SessionTimeout = &nat.SessionTimeout{
-			TcpTimeout:       c.SessionTimeout.TCPTimeout,
-			UdpTimeout:       c.SessionTimeout.UDPTimeout,
-			CleanupInterval:  c.SessionTimeout.CleanupInterval,
+			TcpTimeout:      c.SessionTimeout.TCPTimeout,
+			UdpTimeout:      c.SessionTimeout.UDPTimeout,
+			CleanupInterval: c.SessionTimeout.CleanupInterval,
+
+			IcmpTimeout:           c.SessionTimeout.ICMPTimeout,
+			EstablishedTcpTimeout: c.SessionTimeout.EstablishedTCPTimeout,
+			TransitoryTcpTimeout:  c.SessionTimeout.TransitoryTCPTimeout,
+			SctpTimeout:           c.SessionTimeout.SCTPTimeout,
 		}
 	} else {
 		// Set default timeouts
 		config.SessionTimeout = &nat.SessionTimeout{
-			TcpTimeout:      300,  // 5 minutes
-			UdpTimeout:      60,   // 1 minute
-			CleanupInterval: 30,   // 30 seconds
+			TcpTimeout:      300, // 5 minutes
+			UdpTimeout:      60,  // 1 minute
+			CleanupInterval: 30,  // 30 seconds
+			IcmpTimeout:     60,  // 1 minute
+		}
+		if c.ComplianceMode != "" {
+			// The defaults RFC 4787 and RFC 5382 recommend
+			config.SessionTimeout.UdpTimeout = 300
+			config.SessionTimeout.EstablishedTcpTimeout = 7440
+			config.SessionTimeout.TransitoryTcpTimeout = 240
 		}
 	}
 
 	// Process resource limits
 	if c.ResourceLimits != nil {
+		if c.ResourceLimits.NewSessionBurst > 0 && c.ResourceLimits.NewSessionsPerSecond == 0 {
+			return nil, errors.New("NAT configuration: resourceLimits.newSessionBurst requires newSessionsPerSecond")
+		}
+		if t := c.ResourceLimits.CleanupThreshold; t < 0 || t > 1 {
+			return nil, errors.New("NAT configuration: resourceLimits.cleanupThreshold must be between 0 and 1, got ", t)
+		}
 		config.Limits = &nat.ResourceLimits{
-			MaxSessions:      c.ResourceLimits.MaxSessions,
-			MaxMemoryMb:     c.ResourceLimits.MaxMemoryMB,
-			CleanupThreshold: c.ResourceLimits.CleanupThreshold,
+			MaxSessions:          c.ResourceLimits.MaxSessions,
+			MaxMemoryMb:          c.ResourceLimits.MaxMemoryMB,
+			CleanupThreshold:     c.ResourceLimits.CleanupThreshold,
+			DropNoisiestSources:  c.ResourceLimits.DropNoisiestSources,
+			NewSessionsPerSecond: c.ResourceLimits.NewSessionsPerSecond,
+			NewSessionBurst:      c.ResourceLimits.NewSessionBurst,
 		}
 	} else {
 		// Set default limits
 		config.Limits = &nat.ResourceLimits{
 			MaxSessions:      10000,
-			MaxMemoryMb:     100,
+			MaxMemoryMb:      100,
 			CleanupThreshold: 0.8,
 		}
 	}
 
+	if psl := c.PerSourceLimits; psl != nil {
+		if psl.Burst > 0 && psl.NewSessionsPerSecond == 0 {
+			return nil, errors.New("NAT configuration: perSourceLimits.burst requires newSessionsPerSecond")
+		}
+		config.PerSourceLimits = &nat.PerSourceLimits{
+			MaxSessions:          psl.MaxSessions,
+			NewSessionsPerSecond: psl.NewSessionsPerSecond,
+			Burst:                psl.Burst,
+		}
+	}
+
+	// Process CGNAT port block allocation
+	if pba := c.PortBlockAllocation; pba != nil {
+		if err := validatePortBlockAllocation(pba); err != nil {
+			return nil, errors.New("NAT configuration: invalid portBlockAllocation").Base(err)
+		}
+		config.PortBlockAllocation = &nat.PortBlockAllocation{
+			PublicAddress:          pba.PublicAddress,
+			PortRangeStart:         pba.PortRangeStart,
+			PortRangeEnd:           pba.PortRangeEnd,
+			BlockSize:              pba.BlockSize,
+			MaxBlocksPerSubscriber: pba.MaxBlocksPerSubscriber,
+			Deterministic:          pba.Deterministic,
+			SubscriberNetwork:      pba.SubscriberNetwork,
+		}
+	}
+
+	if err := validateCompliance(c.SessionTimeout, config); err != nil {
+		return nil, errors.New("NAT configuration: complianceMode ", c.ComplianceMode).Base(err)
+	}
 	return config, nil
-}
\ No newline at end of file
+}
+
+// natTags checks the tags of a rule or range, which must not be empty
+func natTags(tags *StringList) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	for _, tag := range *tags {
+		if strings.TrimSpace(tag) == "" {
+			return nil, errors.New("empty tag")
+		}
+	}
+	return *tags, nil
+}
+
+// validateRangeNetworks checks that the virtual and real networks of a range are CIDRs or
+// addresses of the same family and that the real network holds every host of the virtual one.
+// The real network of a masquerade range is the single address every virtual address maps to.
+func validateRangeNetworks(virtualNetwork, realNetwork string, masquerade bool) error {
+	virtual, err := parseRangeNetwork(virtualNetwork)
+	if err != nil {
+		return errors.New("virtualNetwork").Base(err)
+	}
+	real, err := parseRangeNetwork(realNetwork)
+	if err != nil {
+		return errors.New("realNetwork").Base(err)
+	}
+	virtualOnes, virtualBits := virtual.Mask.Size()
+	realOnes, realBits := real.Mask.Size()
+	if virtualBits != realBits {
+		return errors.New(virtualNetwork, " and ", realNetwork, " are of different address families")
+	}
+	if masquerade {
+		if strings.Contains(realNetwork, "/") {
+			return errors.New("realNetwork ", realNetwork, " of a masquerade range must be a single address")
+		}
+		return nil
+	}
+	if realOnes > virtualOnes {
+		return errors.New("realNetwork ", realNetwork, " is smaller than virtualNetwork ", virtualNetwork)
+	}
+	return nil
+}
+
+// parseRangeNetwork parses a CIDR, or an address as a host network
+func parseRangeNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.New(s, " is neither a CIDR nor an IP address")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// validateRangeOverlaps checks that no two ranges translate overlapping virtual networks at the
+// same site. Ranges limited to disjoint source sites never apply together and may overlap.
+func validateRangeOverlaps(ranges []*VirtualRange) error {
+	networks := make([]*net.IPNet, len(ranges))
+	for i, vr := range ranges {
+		network := vr.VirtualNetwork
+		if network == "" {
+			network = vr.NPTv6VirtualPrefix
+		}
+		networks[i], _ = parseRangeNetwork(network)
+	}
+	for i := range ranges {
+		for j := 0; j < i; j++ {
+			if networks[i] == nil || networks[j] == nil || !networks[i].Contains(networks[j].IP) && !networks[j].Contains(networks[i].IP) {
+				continue
+			}
+			if !sitesShared(ranges[i].SourceSite, ranges[j].SourceSite) {
+				continue
+			}
+			return errors.New("virtualRanges[", i, "] (", networks[i], ") overlaps virtualRanges[", j, "] (", networks[j], ")")
+		}
+	}
+	return nil
+}
+
+// sitesShared reports whether two comma-separated source site lists, empty for every site, have a site in common
+func sitesShared(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+	for _, site := range strings.Split(a, ",") {
+		for _, other := range strings.Split(b, ",") {
+			if strings.EqualFold(strings.TrimSpace(site), strings.TrimSpace(other)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateNAT46Prefix checks that prefix is an IPv6 CIDR with an RFC 6052 prefix length
+func validateNAT46Prefix(prefix string) error {
+	ip, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return err
+	}
+	if ip.To4() != nil {
+		return errors.New(prefix, " is not an IPv6 prefix")
+	}
+	switch ones, _ := network.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+		return nil
+	default:
+		return errors.New("prefix length /", ones, " is not one of /32, /40, /48, /56, /64, /96")
+	}
+}
+
+// validateNPTv6Prefixes checks that both NPTv6 prefixes are IPv6 CIDRs of the same length, at most /64
+func validateNPTv6Prefixes(virtualPrefix, realPrefix string) error {
+	if virtualPrefix == "" || realPrefix == "" {
+		return errors.New("both npTv6VirtualPrefix and npTv6RealPrefix are required")
+	}
+
+	var lengths [2]int
+	for i, prefix := range []string{virtualPrefix, realPrefix} {
+		ip, network, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return err
+		}
+		if ip.To4() != nil {
+			return errors.New(prefix, " is not an IPv6 prefix")
+		}
+		lengths[i], _ = network.Mask.Size()
+	}
+
+	if lengths[0] != lengths[1] {
+		return errors.New("prefix lengths differ: /", lengths[0], " and /", lengths[1])
+	}
+	if lengths[0] > 64 {
+		return errors.New("prefix length /", lengths[0], " is longer than /64")
+	}
+	return nil
+}
+
+// validateNATBehavior checks that behavior is empty or one of the supported UDP NAT behaviors
+func validateNATBehavior(behavior string) error {
+	switch behavior {
+	case "", "full-cone", "restricted-cone", "port-restricted", "symmetric":
+		return nil
+	default:
+		return errors.New(behavior, " is not one of full-cone, restricted-cone, port-restricted, symmetric")
+	}
+}
+
+// validateCompliance checks that a configuration of the rfc4787 compliance mode sets no timeout
+// shorter than the RFCs allow and no symmetric UDP mapping. Timeouts left unset are raised to
+// the shortest allowed when the outbound starts.
+func validateCompliance(timeouts *SessionTimeout, config *nat.Config) error {
+	if config.ComplianceMode == "" {
+		return nil
+	}
+	if timeouts != nil {
+		for _, timeout := range []struct {
+			name         string
+			value, least uint32
+		}{
+			{"udpTimeout", timeouts.UDPTimeout, 120},
+			{"icmpTimeout", timeouts.ICMPTimeout, 60},
+			{"establishedTcpTimeout", timeouts.EstablishedTCPTimeout, 7440},
+			{"transitoryTcpTimeout", timeouts.TransitoryTCPTimeout, 240},
+		} {
+			if timeout.value != 0 && timeout.value < timeout.least {
+				return errors.New("sessionTimeout.", timeout.name, " must be at least ", timeout.least, " seconds")
+			}
+		}
+	}
+	for _, rule := range config.Rules {
+		if rule.NatBehavior == "symmetric" {
+			return errors.New("rule ", rule.RuleId, " has the symmetric natBehavior, whose mappings are not endpoint-independent")
+		}
+	}
+	if config.Stun != nil && config.Stun.NatBehavior == "symmetric" {
+		return errors.New("stun has the symmetric natBehavior, whose mappings are not endpoint-independent")
+	}
+	return nil
+}
+
+// validatePortBlockAllocation checks the public address, the port range and, for deterministic
+// allocation, that the range holds the blocks of every subscriber address
+func validatePortBlockAllocation(pba *PortBlockAllocation) error {
+	if net.ParseIP(pba.PublicAddress) == nil {
+		return errors.New("publicAddress ", pba.PublicAddress, " is not an IP address")
+	}
+
+	start, end, size, maxBlocks := pba.PortRangeStart, pba.PortRangeEnd, pba.BlockSize, pba.MaxBlocksPerSubscriber
+	if start == 0 {
+		start = 1024
+	}
+	if end == 0 {
+		end = 65535
+	}
+	if size == 0 {
+		size = 512
+	}
+	if maxBlocks == 0 {
+		maxBlocks = 1
+	}
+	if end > 65535 || start > end {
+		return errors.New("invalid port range ", start, "-", end)
+	}
+	blocks := (end - start + 1) / size
+	if blocks == 0 {
+		return errors.New("port range ", start, "-", end, " is smaller than one block of ", size, " ports")
+	}
+
+	if !pba.Deterministic {
+		return nil
+	}
+	_, network, err := net.ParseCIDR(pba.SubscriberNetwork)
+	if err != nil {
+		return errors.New("deterministic allocation requires a subscriberNetwork").Base(err)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 24 || uint64(1)<<(bits-ones)*uint64(maxBlocks) > uint64(blocks) {
+		return errors.New("port range holds ", blocks, " blocks, not enough for ", maxBlocks, " blocks per address of ", network)
+	}
+	return nil
+}
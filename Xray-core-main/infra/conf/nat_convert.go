@@ -0,0 +1,562 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/nat"
+	"github.com/xtls/xray-core/transport/internet"
+	"google.golang.org/protobuf/proto"
+)
+
+// NATOutboundConfigFromProto converts a NAT configuration, as built from JSON or loaded from
+// protobuf, back into its JSON form, so that building it again yields the same configuration.
+// The defaults Build injects are kept explicit. Rules expanded from an object of virtual
+// destinations get the object back, and geo conditions become the CIDRs and domains they were
+// loaded into, geoip.dat countries being kept as references.
+func NATOutboundConfigFromProto(config *nat.Config) (*NATOutboundConfig, error) {
+	c := &NATOutboundConfig{
+		SiteID:         config.SiteId,
+		UserLevel:      config.UserLevel,
+		NAT64Prefix:    config.Nat64Prefix,
+		EnableHairpin:  config.EnableHairpin,
+		RulesFile:      config.RulesFile,
+		MatchStrategy:  config.MatchStrategy,
+		FakeDNS:        config.FakeDns,
+		ComplianceMode: config.ComplianceMode,
+		DisableSplice:  config.DisableSplice,
+		UDPBatching:    config.UdpBatching,
+	}
+
+	for _, vr := range config.VirtualRanges {
+		virtualRange := &VirtualRange{
+			VirtualNetwork:     vr.VirtualNetwork,
+			RealNetwork:        vr.RealNetwork,
+			IPv6Enabled:        vr.Ipv6Enabled,
+			IPv6Prefix:         vr.Ipv6VirtualPrefix,
+			IPv4To6Prefix:      vr.Ipv4To6Prefix,
+			NPTv6VirtualPrefix: vr.NpTv6VirtualPrefix,
+			NPTv6RealPrefix:    vr.NpTv6RealPrefix,
+			SendThrough:        vr.SendThrough,
+			SourceSite:         vr.SourceSite,
+			Symmetric:          vr.Symmetric,
+			PeerSite:           vr.PeerSite,
+			PeerVirtualNetwork: vr.PeerVirtualNetwork,
+			PeerRealNetwork:    vr.PeerRealNetwork,
+			Masquerade:         vr.Masquerade,
+			MasqueradePorts:    vr.MasqueradePorts,
+			MasqueradePooling:  vr.MasqueradePooling,
+			MaxSessions:        vr.MaxSessions,
+			TOS:                vr.Tos,
+			PreserveTOS:        vr.PreserveTos,
+			FlowLabel:          vr.FlowLabel,
+		}
+		if len(vr.MasqueradePool) > 0 {
+			virtualRange.MasqueradePool = NewStringList(vr.MasqueradePool)
+		}
+		if len(vr.Tags) > 0 {
+			virtualRange.Tags = NewStringList(vr.Tags)
+		}
+		if vr.Disabled {
+			virtualRange.Enabled = new(bool)
+		}
+		c.VirtualRanges = append(c.VirtualRanges, virtualRange)
+	}
+
+	// Rules of an object of virtual destinations are consecutive and share their ruleId
+	seen := make(map[string]bool, len(config.Rules))
+	for i := 0; i < len(config.Rules); {
+		first := config.Rules[i]
+		end := i + 1
+		for first.RuleId != "" && end < len(config.Rules) && config.Rules[end].RuleId == first.RuleId {
+			end++
+		}
+		if first.RuleId != "" {
+			if seen[first.RuleId] {
+				return nil, errors.New("NAT rule ", first.RuleId, ": rules sharing a ruleId must be consecutive")
+			}
+			seen[first.RuleId] = true
+		}
+
+		rule, err := natRuleFromProto(first)
+		if err != nil {
+			return nil, errors.New("NAT rule ", first.RuleId).Base(err)
+		}
+		if end-i > 1 {
+			virtualDestinations := make([]string, 0, end-i)
+			for _, expanded := range config.Rules[i:end] {
+				if !sameRuleButVirtualDestination(first, expanded) {
+					return nil, errors.New("NAT rule ", first.RuleId, ": rules sharing a ruleId may only differ in virtualDestination")
+				}
+				virtualDestinations = append(virtualDestinations, expanded.VirtualDestination)
+			}
+			name := strings.NewReplacer(",", "-", " ", "-").Replace(first.RuleId)
+			if _, used := c.Objects[name]; used {
+				name = fmt.Sprint(name, "-", i)
+			}
+			if c.Objects == nil {
+				c.Objects = make(NATObjects)
+			}
+			c.Objects[name] = NewStringList(virtualDestinations)
+			rule.VirtualDestination = natObjectPrefix + name
+		}
+		c.Rules = append(c.Rules, rule)
+		i = end
+	}
+
+	for _, mapping := range config.StaticMappings {
+		c.StaticMappings = append(c.StaticMappings, &StaticMapping{
+			VirtualAddress: mapping.VirtualAddress,
+			RealAddress:    mapping.RealAddress,
+		})
+	}
+	c.SessionLog = sessionLogFromProto(config.SessionLog)
+	c.AuditLog = sessionLogFromProto(config.AuditLog)
+
+	if fe := config.FlowExport; fe != nil {
+		c.FlowExport = &FlowExport{
+			Collector:               fe.Collector,
+			ObservationDomainID:     fe.ObservationDomainId,
+			TemplateRefreshInterval: fe.TemplateRefreshInterval,
+			FlushInterval:           fe.FlushInterval,
+		}
+	}
+	if sw := config.SessionWebhook; sw != nil {
+		c.SessionWebhook = &SessionWebhook{
+			URL:           sw.Url,
+			BatchSize:     sw.BatchSize,
+			FlushInterval: sw.FlushInterval,
+			MaxRetries:    sw.MaxRetries,
+			Authorization: sw.Authorization,
+		}
+	}
+	if sp := config.SessionPersistence; sp != nil {
+		c.SessionPersistence = &SessionPersistence{
+			Path:          sp.Path,
+			RedisAddress:  sp.RedisAddress,
+			RedisPassword: sp.RedisPassword,
+			RedisKey:      sp.RedisKey,
+			Interval:      sp.Interval,
+		}
+	}
+	if rc := config.Replication; rc != nil {
+		c.Replication = &NATReplication{
+			Listen:       rc.Listen,
+			Peer:         rc.Peer,
+			Secret:       rc.Secret,
+			SyncInterval: rc.SyncInterval,
+		}
+	}
+	if ss := config.SharedSessions; ss != nil {
+		c.SharedSessions = &SharedSessions{
+			RedisAddress:  ss.RedisAddress,
+			RedisPassword: ss.RedisPassword,
+			KeyPrefix:     ss.KeyPrefix,
+			SyncInterval:  ss.SyncInterval,
+		}
+	}
+	if ko := config.KernelOffload; ko != nil {
+		c.KernelOffload = &NATKernelOffload{
+			Table:   ko.Table,
+			NftPath: ko.NftPath,
+			Mark:    ko.Mark,
+		}
+	}
+	if rd := config.RuleDistribution; rd != nil {
+		c.RuleDistribution = &NATRuleDistribution{
+			Listen:     rd.Listen,
+			Controller: rd.Controller,
+			Secret:     rd.Secret,
+		}
+	}
+	if cc := config.Cluster; cc != nil {
+		c.Cluster = &NATCluster{
+			NodeID:   cc.NodeId,
+			Replicas: cc.Replicas,
+		}
+		for _, node := range cc.Nodes {
+			c.Cluster.Nodes = append(c.Cluster.Nodes, &NATClusterNode{
+				ID:          node.Id,
+				OutboundTag: node.OutboundTag,
+			})
+		}
+	}
+	if pc := config.PortControl; pc != nil {
+		c.PortControl = &NATPortControl{
+			Listen:               pc.Listen,
+			MappingAddress:       pc.MappingAddress,
+			ExternalAddress:      pc.ExternalAddress,
+			Ports:                pc.Ports,
+			MaxLifetime:          pc.MaxLifetime,
+			MaxMappingsPerClient: pc.MaxMappingsPerClient,
+			UPnPListen:           pc.UpnpListen,
+		}
+	}
+	if st := config.Stun; st != nil {
+		c.Stun = &NATStun{
+			Address:          st.Address,
+			AlternateAddress: st.AlternateAddress,
+			NATBehavior:      st.NatBehavior,
+			ExternalAddress:  st.ExternalAddress,
+		}
+	}
+	if cl := config.Clat; cl != nil {
+		c.CLAT = &NATCLAT{
+			PLATPrefix:   cl.PlatPrefix,
+			SourcePrefix: cl.SourcePrefix,
+		}
+		if len(cl.Networks) > 0 {
+			c.CLAT.Networks = NewStringList(cl.Networks)
+		}
+	}
+	for _, mr := range config.MapRules {
+		c.MapRules = append(c.MapRules, &NATMapRule{
+			RuleID:       mr.RuleId,
+			IPv4Prefix:   mr.Ipv4Prefix,
+			IPv6Prefix:   mr.Ipv6Prefix,
+			EABitsLength: mr.EaBitsLength,
+			PSIDOffset:   mr.PsidOffset,
+		})
+	}
+	if ap := config.AddressPool; ap != nil {
+		c.AddressPool = &NATAddressPool{
+			Network:   ap.Network,
+			LeaseTime: ap.LeaseTime,
+			LeaseFile: ap.LeaseFile,
+		}
+	}
+	if st := config.SessionTimeout; st != nil {
+		c.SessionTimeout = &SessionTimeout{
+			TCPTimeout:      st.TcpTimeout,
+			UDPTimeout:      st.UdpTimeout,
+			CleanupInterval: st.CleanupInterval,
+
+			ICMPTimeout:           st.IcmpTimeout,
+			EstablishedTCPTimeout: st.EstablishedTcpTimeout,
+			TransitoryTCPTimeout:  st.TransitoryTcpTimeout,
+			SCTPTimeout:           st.SctpTimeout,
+		}
+	}
+	if rl := config.Limits; rl != nil {
+		c.ResourceLimits = &ResourceLimits{
+			MaxSessions:          rl.MaxSessions,
+			MaxMemoryMB:          rl.MaxMemoryMb,
+			CleanupThreshold:     rl.CleanupThreshold,
+			DropNoisiestSources:  rl.DropNoisiestSources,
+			NewSessionsPerSecond: rl.NewSessionsPerSecond,
+			NewSessionBurst:      rl.NewSessionBurst,
+		}
+	}
+	if psl := config.PerSourceLimits; psl != nil {
+		c.PerSourceLimits = &PerSourceLimits{
+			MaxSessions:          psl.MaxSessions,
+			NewSessionsPerSecond: psl.NewSessionsPerSecond,
+			Burst:                psl.Burst,
+		}
+	}
+	if pba := config.PortBlockAllocation; pba != nil {
+		c.PortBlockAllocation = &PortBlockAllocation{
+			PublicAddress:          pba.PublicAddress,
+			PortRangeStart:         pba.PortRangeStart,
+			PortRangeEnd:           pba.PortRangeEnd,
+			BlockSize:              pba.BlockSize,
+			MaxBlocksPerSubscriber: pba.MaxBlocksPerSubscriber,
+			Deterministic:          pba.Deterministic,
+			SubscriberNetwork:      pba.SubscriberNetwork,
+		}
+	}
+	return c, nil
+}
+
+// MarshalJSON implements encoding/json.Marshaler.MarshalJSON, leaving out unset fields, so
+// only empty objects, whose presence matters, are kept
+func (c NATOutboundConfig) MarshalJSON() ([]byte, error) {
+	type plain NATOutboundConfig
+	data, err := json.Marshal(plain(c))
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	pruned, _ := pruneJSON(value)
+	return json.Marshal(pruned)
+}
+
+// pruneJSON removes nulls, zero values and empty arrays from a decoded JSON value, reporting
+// whether the value itself is kept. Array elements are always kept, and so are enabled and the
+// settings of happyEyeballs, whose absent fields default to values other than zero.
+func pruneJSON(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if (key == "happyEyeballs" || key == "enabled") && field != nil {
+				continue
+			}
+			if pruned, keep := pruneJSON(field); keep {
+				v[key] = pruned
+			} else {
+				delete(v, key)
+			}
+		}
+		return v, true
+	case []interface{}:
+		for i, element := range v {
+			v[i], _ = pruneJSON(element)
+		}
+		return v, len(v) > 0
+	case json.Number:
+		f, err := v.Float64()
+		return v, err != nil || f != 0
+	case string:
+		return v, v != ""
+	case bool:
+		return v, v
+	default:
+		return v, v != nil
+	}
+}
+
+// natRuleFromProto converts a rule back into its JSON form
+func natRuleFromProto(r *nat.NATRule) (*NATRule, error) {
+	rule := &NATRule{
+		RuleID:             r.RuleId,
+		SourceSite:         r.SourceSite,
+		VirtualDestination: r.VirtualDestination,
+		RealDestination:    NATDestination(r.RealDestination),
+		Protocol:           r.Protocol,
+		NATBehavior:        r.NatBehavior,
+		Strategy:           r.Strategy,
+		Priority:           r.Priority,
+		Action:             r.Action,
+		Ports:              r.Ports,
+		PortOffset:         r.PortOffset,
+		DomainStrategy:     r.DomainStrategy,
+		SourcePorts:        r.SourcePorts,
+		UserLevels:         r.UserLevels,
+		ReuseConnections:   r.ReuseConnections,
+		ForwardTag:         r.ForwardTag,
+		MaxSessions:        r.MaxSessions,
+		SessionClass:       r.SessionClass,
+		TCPMSSClamp:        r.TcpMssClamp,
+		MTU:                r.Mtu,
+		MTUPolicy:          r.MtuPolicy,
+	}
+	if len(r.RealDestinations) > 0 {
+		rule.RealDestination = NATDestination(strings.Join(r.RealDestinations, ","))
+	}
+	if r.PortMapping != nil {
+		rule.PortMapping = &PortMapping{
+			OriginalPort:   r.PortMapping.OriginalPort,
+			TranslatedPort: r.PortMapping.TranslatedPort,
+			ProtocolPorts:  r.PortMapping.ProtocolPorts,
+		}
+	}
+	if r.Schedule != nil {
+		rule.Schedule = &NATSchedule{
+			Days:     r.Schedule.Days,
+			Start:    r.Schedule.Start,
+			End:      r.Schedule.End,
+			Timezone: r.Schedule.Timezone,
+		}
+	}
+	if r.RateLimit != nil {
+		rule.RateLimit = &NATRateLimit{
+			UplinkBytesPerSecond:   r.RateLimit.UplinkBytesPerSecond,
+			DownlinkBytesPerSecond: r.RateLimit.DownlinkBytesPerSecond,
+			BurstBytes:             r.RateLimit.BurstBytes,
+		}
+	}
+	if r.Disabled {
+		rule.Enabled = new(bool)
+	}
+	for _, list := range []struct {
+		values []string
+		field  **StringList
+	}{
+		{r.SourceAddresses, &rule.SourceAddresses},
+		{r.Users, &rule.Users},
+		{r.Alg, &rule.ALG},
+		{r.Domains, &rule.Domains},
+		{r.Tags, &rule.Tags},
+	} {
+		if len(list.values) > 0 {
+			*list.field = NewStringList(list.values)
+		}
+	}
+
+	var err error
+	if rule.SourceGeoIP, err = geoIPListFromProto(r.SourceGeoip); err != nil {
+		return nil, errors.New("invalid sourceGeoIP").Base(err)
+	}
+	if rule.DestGeoIP, err = geoIPListFromProto(r.DestGeoip); err != nil {
+		return nil, errors.New("invalid destGeoIP").Base(err)
+	}
+	if len(r.DestGeosite) > 0 {
+		sites := make([]string, 0, len(r.DestGeosite))
+		for _, domain := range r.DestGeosite {
+			site, err := domainFromProto(domain)
+			if err != nil {
+				return nil, errors.New("invalid destGeoSite").Base(err)
+			}
+			sites = append(sites, site)
+		}
+		rule.DestGeoSite = NewStringList(sites)
+	}
+
+	if r.Sockopt != nil || r.Tos != 0 || r.PreserveTos || r.FlowLabel != "" {
+		rule.Sockopt = &NATSockopt{
+			TOS:         r.Tos,
+			PreserveTOS: r.PreserveTos,
+			FlowLabel:   r.FlowLabel,
+		}
+		if r.Sockopt != nil {
+			rule.Sockopt.SocketConfig = socketConfigFromProto(r.Sockopt)
+		}
+	}
+	return rule, nil
+}
+
+// sameRuleButVirtualDestination reports whether two rules only differ in their virtual destination
+func sameRuleButVirtualDestination(a, b *nat.NATRule) bool {
+	a, b = proto.Clone(a).(*nat.NATRule), proto.Clone(b).(*nat.NATRule)
+	a.VirtualDestination, b.VirtualDestination = "", ""
+	return proto.Equal(a, b)
+}
+
+// sessionLogFromProto converts a session or audit log back into its JSON form
+func sessionLogFromProto(sl *nat.SessionLog) *SessionLog {
+	if sl == nil {
+		return nil
+	}
+	return &SessionLog{
+		Sink:          sl.Sink,
+		Path:          sl.Path,
+		MaxSizeMB:     sl.MaxSizeMb,
+		MaxBackups:    sl.MaxBackups,
+		SyslogAddress: sl.SyslogAddress,
+	}
+}
+
+// geoIPListFromProto converts geoip conditions back into a list of CIDRs, with the countries of
+// geoip.dat as references. Those of other files become the CIDRs they were loaded into.
+func geoIPListFromProto(geoips []*router.GeoIP) (*StringList, error) {
+	if len(geoips) == 0 {
+		return nil, nil
+	}
+	var list []string
+	for _, geoip := range geoips {
+		if geoip.CountryCode != "" && !strings.Contains(geoip.CountryCode, "_") {
+			reverse := ""
+			if geoip.ReverseMatch {
+				reverse = "!"
+			}
+			list = append(list, "geoip:"+reverse+strings.ToLower(geoip.CountryCode))
+			continue
+		}
+		if geoip.ReverseMatch {
+			return nil, errors.New("reverse match of ", geoip.CountryCode, " has no CIDR form")
+		}
+		for _, cidr := range geoip.Cidr {
+			list = append(list, net.IP(cidr.Ip).String()+"/"+strconv.Itoa(int(cidr.Prefix)))
+		}
+	}
+	return NewStringList(list), nil
+}
+
+// domainFromProto converts a geosite domain back into its prefixed form
+func domainFromProto(domain *router.Domain) (string, error) {
+	switch domain.Type {
+	case router.Domain_Plain:
+		return "keyword:" + domain.Value, nil
+	case router.Domain_Regex:
+		return "regexp:" + domain.Value, nil
+	case router.Domain_Domain:
+		return "domain:" + domain.Value, nil
+	case router.Domain_Full:
+		return "full:" + domain.Value, nil
+	default:
+		return "", errors.New("unknown domain type ", domain.Type)
+	}
+}
+
+// socketConfigFromProto converts the socket options of a rule back into the sockopt of
+// streamSettings. The happy eyeballs settings are left out when they are the defaults.
+func socketConfigFromProto(sc *internet.SocketConfig) SocketConfig {
+	config := SocketConfig{
+		Mark:                 sc.Mark,
+		AcceptProxyProtocol:  sc.AcceptProxyProtocol,
+		DialerProxy:          sc.DialerProxy,
+		TCPKeepAliveInterval: sc.TcpKeepAliveInterval,
+		TCPKeepAliveIdle:     sc.TcpKeepAliveIdle,
+		TCPCongestion:        sc.TcpCongestion,
+		TCPWindowClamp:       sc.TcpWindowClamp,
+		TCPMaxSeg:            sc.TcpMaxSeg,
+		Penetrate:            sc.Penetrate,
+		TCPUserTimeout:       sc.TcpUserTimeout,
+		V6only:               sc.V6Only,
+		Interface:            sc.Interface,
+		TcpMptcp:             sc.TcpMptcp,
+	}
+	switch sc.Tfo {
+	case 0:
+	case 256:
+		config.TFO = true
+	default:
+		config.TFO = sc.Tfo
+	}
+	switch sc.Tproxy {
+	case internet.SocketConfig_TProxy:
+		config.TProxy = "tproxy"
+	case internet.SocketConfig_Redirect:
+		config.TProxy = "redirect"
+	}
+	config.DomainStrategy = map[internet.DomainStrategy]string{
+		internet.DomainStrategy_USE_IP:     "UseIP",
+		internet.DomainStrategy_USE_IP4:    "UseIPv4",
+		internet.DomainStrategy_USE_IP6:    "UseIPv6",
+		internet.DomainStrategy_USE_IP46:   "UseIPv4v6",
+		internet.DomainStrategy_USE_IP64:   "UseIPv6v4",
+		internet.DomainStrategy_FORCE_IP:   "ForceIP",
+		internet.DomainStrategy_FORCE_IP4:  "ForceIPv4",
+		internet.DomainStrategy_FORCE_IP6:  "ForceIPv6",
+		internet.DomainStrategy_FORCE_IP46: "ForceIPv4v6",
+		internet.DomainStrategy_FORCE_IP64: "ForceIPv6v4",
+	}[sc.DomainStrategy]
+	config.AddressPortStrategy = map[internet.AddressPortStrategy]string{
+		internet.AddressPortStrategy_SrvPortOnly:       "SrvPortOnly",
+		internet.AddressPortStrategy_SrvAddressOnly:    "SrvAddressOnly",
+		internet.AddressPortStrategy_SrvPortAndAddress: "SrvPortAndAddress",
+		internet.AddressPortStrategy_TxtPortOnly:       "TxtPortOnly",
+		internet.AddressPortStrategy_TxtAddressOnly:    "TxtAddressOnly",
+		internet.AddressPortStrategy_TxtPortAndAddress: "TxtPortAndAddress",
+	}[sc.AddressPortStrategy]
+	for _, option := range sc.CustomSockopt {
+		config.CustomSockopt = append(config.CustomSockopt, &CustomSockoptConfig{
+			Syetem:  option.System,
+			Network: option.Network,
+			Level:   option.Level,
+			Opt:     option.Opt,
+			Value:   option.Value,
+			Type:    option.Type,
+		})
+	}
+	if he := sc.HappyEyeballs; he != nil && !proto.Equal(he, &internet.HappyEyeballsConfig{Interleave: 1, MaxConcurrentTry: 4}) {
+		config.HappyEyeballsSettings = &HappyEyeballsConfig{
+			PrioritizeIPv6:   he.PrioritizeIpv6,
+			TryDelayMs:       he.TryDelayMs,
+			Interleave:       he.Interleave,
+			MaxConcurrentTry: he.MaxConcurrentTry,
+		}
+	}
+	return config
+}
@@ -2,9 +2,13 @@ package conf
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/xtls/xray-core/proxy/nat"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestNATOutboundConfig_Build(t *testing.T) {
@@ -15,16 +19,16 @@ func TestNATOutboundConfig_Build(t *testing.T) {
 			{
 				VirtualNetwork: "240.2.2.0/24",
 				RealNetwork:    "192.168.1.0/24",
-				IPv6Enabled:   true,
-				IPv6Prefix:    "64:FF9B:2222::/96",
+				IPv6Enabled:    true,
+				IPv6Prefix:     "64:FF9B:2222::/96",
 			},
 		},
 		Rules: []*NATRule{
 			{
-				RuleID:            "rule-1",
+				RuleID:             "rule-1",
 				VirtualDestination: "240.2.2.20",
-				RealDestination:   "192.168.1.20",
-				Protocol:          "tcp",
+				RealDestination:    "192.168.1.20",
+				Protocol:           "tcp",
 			},
 		},
 	}
@@ -143,19 +147,154 @@ func TestNATOutboundConfig_Defaults(t *testing.T) {
 	}
 }
 
+func TestNATOutboundConfig_Protocol(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [
+			{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "protocol": "web,quic"},
+			{"ruleId": "all", "virtualDestination": "240.2.2.21", "realDestination": "192.168.1.21", "protocol": "any"}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if protocol := protoConfig.(*nat.Config).Rules[0].Protocol; protocol != "web,quic" {
+		t.Errorf("Unexpected protocol %q", protocol)
+	}
+
+	config.Rules[1].Protocol = "tcp,dccp"
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "dccp") {
+		t.Errorf("Expected error for an unknown protocol, got %v", err)
+	}
+
+	// SCTP rides on UDP
+	config.Rules[1].Protocol = "tcp,sctp"
+	config.SessionTimeout = &SessionTimeout{SCTPTimeout: 900}
+	protoConfig, err = config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if timeout := protoConfig.(*nat.Config).SessionTimeout.SctpTimeout; timeout != 900 {
+		t.Errorf("Unexpected SCTP timeout %d", timeout)
+	}
+	config.Rules[1].Protocol = "udp,sctp"
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "sctp is carried over udp") {
+		t.Errorf("Expected error for sctp next to udp, got %v", err)
+	}
+}
+
+func TestNATOutboundConfig_Objects(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"objects": {
+			"branch-servers": ["240.2.2.20", "240.2.3.0/24"],
+			"db-backends": ["192.168.1.20", "192.168.1.21"],
+			"admins": ["10.0.0.0/24", "10.9.9.9"],
+			"admin-ports": "22,3389,8000-8100"
+		},
+		"rules": [{
+			"ruleId": "branch",
+			"virtualDestination": "@branch-servers",
+			"realDestination": "@db-backends",
+			"ports": "@admin-ports,443",
+			"sourceAddresses": ["@admins", "172.16.0.1"]
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rules := protoConfig.(*nat.Config).Rules
+	if len(rules) != 2 || rules[0].VirtualDestination != "240.2.2.20" || rules[1].VirtualDestination != "240.2.3.0/24" {
+		t.Fatalf("Expected a rule for each virtual destination of the object, got %v", rules)
+	}
+	for _, rule := range rules {
+		if rule.RuleId != "branch" || len(rule.RealDestinations) != 2 || rule.Ports != "22,3389,8000-8100,443" || len(rule.SourceAddresses) != 3 {
+			t.Errorf("Unexpected resolved rule %v", rule)
+		}
+	}
+	if config.Rules[0].VirtualDestination != "@branch-servers" {
+		t.Error("The rules of the configuration must not be modified")
+	}
+
+	config.Rules[0].Ports = "@web-ports"
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "web-ports") {
+		t.Errorf("Expected error for an unknown object, got %v", err)
+	}
+	config.Rules[0].Ports = "@admin-ports"
+	config.Objects["admins"] = NewStringList([]string{"@branch-servers"})
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an object referencing another")
+	}
+
+	// Rules built on their own, such as those of a rules file, have no objects
+	if _, err := (&NATRule{VirtualDestination: "@branch-servers", RealDestination: "10.0.0.1"}).Build(); err == nil {
+		t.Error("Expected error for a reference outside the NAT configuration")
+	}
+}
+
+func TestNATOutboundConfig_RulesInclude(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"b-site.json": `[{"ruleId": "b", "virtualDestination": "@branch", "realDestination": "192.168.2.20"}]`,
+		"a-site.json": `{"rules": [{"ruleId": "a", "virtualDestination": "240.1.1.20", "realDestination": "192.168.1.20"}]}`,
+		"c-site.yaml": "- ruleId: c\n  virtualDestination: 240.3.3.20\n  realDestination: 192.168.3.20\n",
+		"d-site.toml": "[[rules]]\nruleId = \"d\"\nvirtualDestination = \"240.4.4.20\"\nrealDestination = \"192.168.4.20\"\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &NATOutboundConfig{
+		SiteID:       "site-a",
+		Objects:      NATObjects{"branch": NewStringList([]string{"240.2.2.20"})},
+		Rules:        []*NATRule{{RuleID: "local", VirtualDestination: "240.0.0.20", RealDestination: "192.168.0.20"}},
+		RulesInclude: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yaml"), filepath.Join(dir, "*.toml"), filepath.Join(dir, "a-site.json")},
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	var ids []string
+	for _, rule := range protoConfig.(*nat.Config).Rules {
+		ids = append(ids, rule.RuleId)
+	}
+	if strings.Join(ids, ",") != "local,a,b,c,d" {
+		t.Errorf("Expected the configured rules followed by the included ones in file order, got %v", ids)
+	}
+
+	config.Rules[0].RuleID = "b"
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "b-site.json: rules[0]: ruleId b is already used by rules[0]") {
+		t.Errorf("Expected error for a ruleId of an included file already used, got %v", err)
+	}
+	config.Rules[0].RuleID = "local"
+	config.RulesInclude = append(config.RulesInclude, filepath.Join(dir, "missing.json"))
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a missing rules include")
+	}
+}
+
 func TestNATOutboundConfig_PortMapping(t *testing.T) {
 	// Test port mapping configuration
 	config := &NATOutboundConfig{
 		SiteID: "test-site",
 		Rules: []*NATRule{
 			{
-				RuleID:            "rule-1",
+				RuleID:             "rule-1",
 				VirtualDestination: "240.2.2.20",
-				RealDestination:   "192.168.1.20",
-				Protocol:          "tcp",
+				RealDestination:    "192.168.1.20",
+				Protocol:           "tcp",
 				PortMapping: &PortMapping{
-					OriginalPort:    "8080",
-					TranslatedPort:  "80",
+					OriginalPort:   "8080",
+					TranslatedPort: "80",
 				},
 			},
 		},
@@ -192,22 +331,23 @@ func TestNATOutboundConfig_JSONSerialization(t *testing.T) {
 			{
 				VirtualNetwork: "240.2.2.0/24",
 				RealNetwork:    "192.168.1.0/24",
-				IPv6Enabled:   true,
-				IPv6Prefix:    "64:FF9B:2222::/96",
+				IPv6Enabled:    true,
+				IPv6Prefix:     "64:FF9B:2222::/96",
 			},
 		},
 		Rules: []*NATRule{
 			{
-				RuleID:            "rule-1",
+				RuleID:             "rule-1",
 				VirtualDestination: "240.2.2.20",
-				RealDestination:   "192.168.1.20",
-				Protocol:          "tcp",
+				RealDestination:    "192.168.1.20",
+				Protocol:           "tcp",
 			},
 		},
 		ResourceLimits: &ResourceLimits{
-			MaxSessions:      5000,
-			MaxMemoryMB:     50,
-			CleanupThreshold: 0.7,
+			MaxSessions:         5000,
+			MaxMemoryMB:         50,
+			CleanupThreshold:    0.7,
+			DropNoisiestSources: true,
 		},
 	}
 
@@ -249,5 +389,1841 @@ func TestNATOutboundConfig_JSONSerialization(t *testing.T) {
 	if decodedConfig.ResourceLimits.CleanupThreshold != 0.7 {
 		t.Errorf("Expected cleanup threshold 0.7, got %f", decodedConfig.ResourceLimits.CleanupThreshold)
 	}
+	if !decodedConfig.ResourceLimits.DropNoisiestSources {
+		t.Error("Expected dropping the noisiest sources to be enabled")
+	}
+
+	built, err := decodedConfig.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if !built.(*nat.Config).Limits.DropNoisiestSources {
+		t.Error("Expected dropping the noisiest sources to be built into the limits")
+	}
+}
+
+func TestNATOutboundConfig_NAT46Prefix(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "test-site",
+		VirtualRanges: []*VirtualRange{
+			{
+				VirtualNetwork: "240.3.3.0/24",
+				RealNetwork:    "192.168.1.0/24",
+				IPv4To6Prefix:  "64:FF9B::/96",
+			},
+		},
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+
+	natConfig := protoConfig.(*nat.Config)
+	if natConfig.VirtualRanges[0].Ipv4To6Prefix != "64:FF9B::/96" {
+		t.Errorf("Expected ipv4To6Prefix '64:FF9B::/96', got '%s'", natConfig.VirtualRanges[0].Ipv4To6Prefix)
+	}
+
+	config.VirtualRanges[0].IPv4To6Prefix = "64:FF9B::/80"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for unsupported NAT46 prefix length")
+	}
+
+	config.VirtualRanges[0].IPv4To6Prefix = "10.0.0.0/8"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for IPv4 NAT46 prefix")
+	}
+}
+
+func TestNATOutboundConfig_NPTv6Prefixes(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "test-site",
+		VirtualRanges: []*VirtualRange{
+			{
+				NPTv6VirtualPrefix: "fd01:203:405::/48",
+				NPTv6RealPrefix:    "2001:db8:1::/48",
+			},
+		},
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+
+	vrange := protoConfig.(*nat.Config).VirtualRanges[0]
+	if vrange.NpTv6VirtualPrefix != "fd01:203:405::/48" || vrange.NpTv6RealPrefix != "2001:db8:1::/48" {
+		t.Errorf("Unexpected NPTv6 prefixes: %s -> %s", vrange.NpTv6VirtualPrefix, vrange.NpTv6RealPrefix)
+	}
+
+	config.VirtualRanges[0].NPTv6RealPrefix = "2001:db8:1::/56"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for mismatched NPTv6 prefix lengths")
+	}
+
+	config.VirtualRanges[0].NPTv6RealPrefix = ""
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for missing NPTv6 real prefix")
+	}
+}
+
+func TestNATOutboundConfig_SendThrough(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "test-site",
+		VirtualRanges: []*VirtualRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", SendThrough: "10.0.0.1"},
+		},
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if sendThrough := protoConfig.(*nat.Config).VirtualRanges[0].SendThrough; sendThrough != "10.0.0.1" {
+		t.Errorf("Unexpected sendThrough %q", sendThrough)
+	}
+
+	config.VirtualRanges[0].SendThrough = "eth0"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a sendThrough that is not an IP address")
+	}
+}
+
+func TestNATOutboundConfig_Symmetric(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "site-a",
+		VirtualRanges: []*VirtualRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", SourceSite: "site-a", Symmetric: true, PeerSite: "site-b", PeerRealNetwork: "192.168.2.0/24"},
+		},
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	vrange := protoConfig.(*nat.Config).VirtualRanges[0]
+	if !vrange.Symmetric || vrange.SourceSite != "site-a" || vrange.PeerSite != "site-b" || vrange.PeerRealNetwork != "192.168.2.0/24" {
+		t.Errorf("Unexpected symmetric range %v", vrange)
+	}
+
+	config.VirtualRanges[0].PeerRealNetwork = "192.168.2.0"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid peer network")
+	}
+
+	config.VirtualRanges[0].PeerRealNetwork = ""
+	config.VirtualRanges[0].PeerSite = ""
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a symmetric range without peerSite")
+	}
+
+	config.VirtualRanges[0].Symmetric = false
+	config.VirtualRanges[0].PeerVirtualNetwork = "240.3.3.0/24"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for peer networks without symmetric")
+	}
 }
 
+func TestNATOutboundConfig_Masquerade(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "site-a",
+		VirtualRanges: []*VirtualRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePorts: "20000-29999", SendThrough: "10.0.0.1"},
+		},
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	vrange := protoConfig.(*nat.Config).VirtualRanges[0]
+	if !vrange.Masquerade || vrange.MasqueradePorts != "20000-29999" || vrange.RealNetwork != "192.168.1.10" {
+		t.Errorf("Unexpected masquerade range %v", vrange)
+	}
+
+	config.VirtualRanges[0].RealNetwork = "192.168.1.0/24"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a masquerade range into a network")
+	}
+	config.VirtualRanges[0].RealNetwork = "192.168.1.10"
+	config.VirtualRanges[0].MasqueradePorts = "any"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for any masquerade ports")
+	}
+	config.VirtualRanges[0].MasqueradePorts = "20000-29999"
+	config.VirtualRanges[0].MasqueradePool = &StringList{"10.0.0.0/16"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a masquerade pool larger than 256 addresses")
+	}
+	config.VirtualRanges[0].MasqueradePool = &StringList{"10.0.0.0/29", "10.0.1.1"}
+	config.VirtualRanges[0].MasqueradePooling = "hashed"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown masquerade pooling")
+	}
+	config.VirtualRanges[0].MasqueradePooling = "arbitrary"
+	protoConfig, err = config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	vrange = protoConfig.(*nat.Config).VirtualRanges[0]
+	if len(vrange.MasqueradePool) != 2 || vrange.MasqueradePooling != "arbitrary" {
+		t.Errorf("Unexpected masquerade pool %v", vrange)
+	}
+	config.VirtualRanges[0].Masquerade = false
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for masqueradePorts without masquerade")
+	}
+}
+
+func TestNATOutboundConfig_SessionTimeouts(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "test-site",
+		"sessionTimeout": {
+			"tcpTimeout": 300,
+			"udpTimeout": 120,
+			"icmpTimeout": 30,
+			"establishedTcpTimeout": 7440,
+			"transitoryTcpTimeout": 240,
+			"cleanupInterval": 10
+		}
+	}`), &config); err != nil {
+		t.Fatalf("Failed to parse NAT config: %v", err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+
+	timeouts := protoConfig.(*nat.Config).SessionTimeout
+	if timeouts.UdpTimeout != 120 || timeouts.IcmpTimeout != 30 {
+		t.Errorf("Unexpected UDP/ICMP timeouts: %d/%d", timeouts.UdpTimeout, timeouts.IcmpTimeout)
+	}
+	if timeouts.EstablishedTcpTimeout != 7440 || timeouts.TransitoryTcpTimeout != 240 {
+		t.Errorf("Unexpected TCP timeouts: %d/%d", timeouts.EstablishedTcpTimeout, timeouts.TransitoryTcpTimeout)
+	}
+}
+
+func TestNATOutboundConfig_NATBehavior(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "test-site",
+		Rules: []*NATRule{
+			{
+				RuleID:             "game",
+				VirtualDestination: "240.2.2.20",
+				RealDestination:    "192.168.1.20",
+				Protocol:           "udp",
+				NATBehavior:        "full-cone",
+			},
+		},
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if behavior := protoConfig.(*nat.Config).Rules[0].NatBehavior; behavior != "full-cone" {
+		t.Errorf("Expected natBehavior full-cone, got %s", behavior)
+	}
+
+	config.Rules[0].NATBehavior = "cone"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for unknown natBehavior")
+	}
+}
+
+func TestNATOutboundConfig_PortBlockAllocation(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "cgnat",
+		"portBlockAllocation": {
+			"publicAddress": "203.0.113.1",
+			"blockSize": 512,
+			"maxBlocksPerSubscriber": 2,
+			"deterministic": true,
+			"subscriberNetwork": "100.64.0.0/27"
+		}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	pba := protoConfig.(*nat.Config).PortBlockAllocation
+	if pba.PublicAddress != "203.0.113.1" || pba.BlockSize != 512 || pba.MaxBlocksPerSubscriber != 2 || !pba.Deterministic {
+		t.Errorf("Unexpected port block allocation %v", pba)
+	}
+
+	config.PortBlockAllocation.SubscriberNetwork = "100.64.0.0/24"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error when the port range cannot hold every subscriber's blocks")
+	}
+
+	config.PortBlockAllocation.PublicAddress = "gateway"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a non-IP public address")
+	}
+}
+
+func TestNATOutboundConfig_PortRangeMapping(t *testing.T) {
+	config := &NATOutboundConfig{
+		SiteID: "test-site",
+		Rules: []*NATRule{
+			{
+				RuleID:             "range",
+				VirtualDestination: "240.2.2.20",
+				RealDestination:    "192.168.1.20",
+				PortMapping: &PortMapping{
+					OriginalPort:   "8000-8100",
+					TranslatedPort: "9000-9100",
+				},
+			},
+		},
+	}
+	if _, err := config.Build(); err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+
+	config.Rules[0].PortMapping.TranslatedPort = "9000-9050"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for port ranges of different lengths")
+	}
+}
+
+func TestNATOutboundConfig_EnableHairpin(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{"siteId": "test-site", "enableHairpin": true}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if !protoConfig.(*nat.Config).EnableHairpin {
+		t.Error("Expected enableHairpin to be set")
+	}
+}
+
+func TestNATOutboundConfig_StaticMappings(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "test-site",
+		"staticMappings": [
+			{"virtualAddress": "240.2.2.20", "realAddress": "192.168.1.20"},
+			{"virtualAddress": "240.2.2.21", "realAddress": "192.168.1.21"}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if mappings := protoConfig.(*nat.Config).StaticMappings; len(mappings) != 2 || mappings[1].RealAddress != "192.168.1.21" {
+		t.Errorf("Unexpected static mappings %v", mappings)
+	}
+
+	config.StaticMappings[1].RealAddress = "192.168.1.20"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for overlapping static mappings")
+	}
+}
+
+func TestNATOutboundConfig_BackendPool(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "test-site",
+		"rules": [
+			{
+				"ruleId": "web",
+				"virtualDestination": "240.2.2.80",
+				"realDestination": ["192.168.1.10", "192.168.2.0/29"],
+				"strategy": "consistent-hash"
+			},
+			{
+				"ruleId": "single",
+				"virtualDestination": "240.2.2.20",
+				"realDestination": "192.168.1.20"
+			}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rules := protoConfig.(*nat.Config).Rules
+	if len(rules[0].RealDestinations) != 2 || rules[0].Strategy != "consistent-hash" || rules[0].RealDestination != "" {
+		t.Errorf("Unexpected pool rule %v", rules[0])
+	}
+	if rules[1].RealDestination != "192.168.1.20" || len(rules[1].RealDestinations) != 0 {
+		t.Errorf("Unexpected single destination rule %v", rules[1])
+	}
+
+	config.Rules[0].Strategy = "fastest"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown pool strategy")
+	}
+}
+
+func TestNATOutboundConfig_SessionLog(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "cgnat",
+		"sessionLog": {"sink": "file", "path": "/var/log/xray/nat.log", "maxSizeMB": 100, "maxBackups": 10}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	sl := protoConfig.(*nat.Config).SessionLog
+	if sl.Sink != "file" || sl.Path != "/var/log/xray/nat.log" || sl.MaxSizeMb != 100 || sl.MaxBackups != 10 {
+		t.Errorf("Unexpected session log %v", sl)
+	}
+
+	config.SessionLog.Path = ""
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a file sink without path")
+	}
+	config.SessionLog.Sink = "kafka"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown sink")
+	}
+}
+
+func TestNATOutboundConfig_AuditLog(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "cgnat",
+		"sessionLog": {"sink": "syslog", "syslogAddress": "192.0.2.20:514"},
+		"auditLog": {"sink": "file", "path": "/var/log/xray/nat-audit.log"}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	natConfig := protoConfig.(*nat.Config)
+	if al := natConfig.AuditLog; al.Sink != "file" || al.Path != "/var/log/xray/nat-audit.log" {
+		t.Errorf("Unexpected audit log %v", al)
+	}
+	if natConfig.SessionLog.Sink != "syslog" {
+		t.Errorf("Expected the session log to be kept apart from the audit log, got %v", natConfig.SessionLog)
+	}
+
+	config.AuditLog.Path = ""
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an audit log file sink without path")
+	}
+}
+
+func TestNATOutboundConfig_FlowExport(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "cgnat",
+		"flowExport": {"collector": "192.0.2.10:4739", "observationDomainId": 42, "templateRefreshInterval": 300, "flushInterval": 500}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	fe := protoConfig.(*nat.Config).FlowExport
+	if fe.Collector != "192.0.2.10:4739" || fe.ObservationDomainId != 42 || fe.TemplateRefreshInterval != 300 || fe.FlushInterval != 500 {
+		t.Errorf("Unexpected flow export %v", fe)
+	}
+
+	config.FlowExport.Collector = "192.0.2.10"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a collector without port")
+	}
+}
+
+func TestNATOutboundConfig_RulesFile(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{"siteId": "site-a", "rulesFile": "/etc/xray/nat-rules.yaml"}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if path := protoConfig.(*nat.Config).RulesFile; path != "/etc/xray/nat-rules.yaml" {
+		t.Errorf("Unexpected rules file %q", path)
+	}
+
+	rules, err := decodeNATRules([]byte(`{"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": ["192.168.1.20", "192.168.1.21"]}]}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].RuleId != "web" || len(rules[0].RealDestinations) != 2 {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+
+	rules, err = decodeNATRules([]byte("- ruleId: db\n  virtualDestination: 240.2.2.30\n  realDestination: 192.168.1.30\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].RuleId != "db" || rules[0].RealDestination != "192.168.1.30" {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+
+	rules, err = decodeNATRules([]byte(`
+[[rules]]
+ruleId = "mail"
+virtualDestination = "240.2.2.40"
+realDestination = ["192.168.1.40", "192.168.1.41"]
+ports = "25,587"
+`), "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].RuleId != "mail" || len(rules[0].RealDestinations) != 2 || rules[0].Ports != "25,587" {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+
+	if _, err := decodeNATRules([]byte(`[{"ruleId": "broken"}]`), "json"); err == nil {
+		t.Error("Expected error for a rule without virtualDestination")
+	}
+}
+
+func TestNATOutboundConfig_RuleTemplates(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.200", "realDestination": "192.168.1.200"}],
+		"ruleTemplates": [
+			{"ruleId": "host-[001-100]", "virtualDestination": "240.2.2.[1-100]", "realDestination": "192.168.1.[101-200]", "maxSessions": 100},
+			{"ruleId": "ssh-[1-3]", "virtualDestination": "240.2.3.1", "realDestination": "192.168.2.[10-12]", "portMapping": {"originalPort": "[2201-2203]", "translatedPort": "22"}}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rules := protoConfig.(*nat.Config).Rules
+	if len(rules) != 104 || rules[0].RuleId != "web" {
+		t.Fatalf("Expected the templates to expand after the rules, got %d rules", len(rules))
+	}
+	if host := rules[5]; host.RuleId != "host-005" || host.VirtualDestination != "240.2.2.5" || host.RealDestination != "192.168.1.105" || host.MaxSessions != 100 {
+		t.Errorf("Unexpected expanded rule %v", host)
+	}
+	if ssh := rules[103]; ssh.RuleId != "ssh-3" || ssh.RealDestination != "192.168.2.12" || ssh.PortMapping.OriginalPort != "2203" || ssh.PortMapping.TranslatedPort != "22" {
+		t.Errorf("Unexpected expanded rule %v", ssh)
+	}
+
+	for _, test := range []struct {
+		template string
+		err      string
+	}{
+		{`{"ruleId": "host", "virtualDestination": "240.2.2.1", "realDestination": "192.168.1.1"}`, "no range"},
+		{`{"ruleId": "host-[1-10]", "virtualDestination": "240.2.2.[1-10]", "realDestination": "192.168.1.[1-20]"}`, "expands into 20 numbers"},
+		{`{"ruleId": "host-[10-1]", "virtualDestination": "240.2.2.[10-1]", "realDestination": "192.168.1.1"}`, "invalid range [10-1]"},
+		{`{"ruleId": "port-[1-3]", "virtualDestination": "240.2.2.1", "realDestination": "192.168.1.1", "ports": "[65534-65536]"}`, "ruleTemplates[0] rule 3"},
+		{`{"ruleId": "host", "virtualDestination": "240.2.2.[1-2]", "realDestination": "192.168.1.[1-2]"}`, "ruleTemplates[0] rule 2: ruleId host is already used by ruleTemplates[0] rule 1"},
+	} {
+		var config NATOutboundConfig
+		if err := json.Unmarshal([]byte(`{"siteId": "site-a", "ruleTemplates": [`+test.template+`]}`), &config); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected error %q for %s, got %v", test.err, test.template, err)
+		}
+	}
+}
+
+func TestNATOutboundConfig_RuleExpiry(t *testing.T) {
+	rules, err := decodeNATRules([]byte(`[
+		{"ruleId": "maintenance", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "ttl": 3600},
+		{"ruleId": "forward", "virtualDestination": "240.2.2.30", "realDestination": "192.168.1.30", "expiresAt": "2026-10-15T02:00:00+08:00"}
+	]`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules[0].Ttl != 3600 || rules[0].ExpiresAt != 0 || rules[1].ExpiresAt != 1792000800 {
+		t.Errorf("Unexpected rule expiry %v", rules)
+	}
+	if _, err := decodeNATRules([]byte(`[{"ruleId": "forward", "virtualDestination": "240.2.2.30", "realDestination": "192.168.1.30", "expiresAt": "tomorrow"}]`), "json"); err == nil {
+		t.Error("Expected error for an expiresAt that is not an RFC 3339 time")
+	}
+
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{"ruleId": "maintenance", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "ttl": 3600}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "rules[0]: ttl and expiresAt") {
+		t.Errorf("Expected error for a ttl on a configured rule, got %v", err)
+	}
+}
+
+func TestNATOutboundConfig_MatchStrategy(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"matchStrategy": "highestPriority",
+		"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "priority": 10}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	natConfig := protoConfig.(*nat.Config)
+	if natConfig.MatchStrategy != "highestPriority" || natConfig.Rules[0].Priority != 10 {
+		t.Errorf("Unexpected match strategy %q and priority %d", natConfig.MatchStrategy, natConfig.Rules[0].Priority)
+	}
+
+	config.MatchStrategy = "bestMatch"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown matchStrategy")
+	}
+}
+
+func TestNATOutboundConfig_BypassRules(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"virtualRanges": [{"virtualNetwork": "240.2.2.0/24", "realNetwork": "192.168.1.0/24"}],
+		"rules": [{"ruleId": "no-nat-ssh", "virtualDestination": "240.2.2.0/24", "ports": "22", "action": "bypass"}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rule := protoConfig.(*nat.Config).Rules[0]
+	if rule.Action != "bypass" || rule.Ports != "22" {
+		t.Errorf("Unexpected bypass rule %v", rule)
+	}
+
+	config.Rules[0].RealDestination = "192.168.1.1"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a bypass rule with a realDestination")
+	}
+	config.Rules[0].RealDestination = ""
+	config.Rules[0].Ports = "22-"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for invalid ports")
+	}
+	config.Rules[0].Ports = ""
+	config.Rules[0].Action = "drop"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown action")
+	}
+}
+
+func TestNATOutboundConfig_BlockingRules(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"virtualRanges": [{"virtualNetwork": "240.2.2.0/24", "realNetwork": "192.168.1.0/24"}],
+		"rules": [
+			{"ruleId": "no-telnet", "virtualDestination": "240.2.2.0/24", "ports": "23", "action": "deny"},
+			{"ruleId": "no-smb", "virtualDestination": "240.2.2.0/24", "ports": "445", "action": "reject"},
+			{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "action": "allow"}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rules := protoConfig.(*nat.Config).Rules
+	if rules[0].Action != "deny" || rules[1].Action != "reject" || rules[2].Action != "allow" || rules[2].RealDestination != "192.168.1.20" {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+
+	config.Rules[1].ForwardTag = "proxy"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a reject rule with a forwardTag")
+	}
+}
+
+func TestNATOutboundConfig_DomainStrategy(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{"ruleId": "ddns", "virtualDestination": "240.2.2.40", "realDestination": "backend.dyndns.example", "domainStrategy": "ForceIPv4"}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rule := protoConfig.(*nat.Config).Rules[0]
+	if rule.RealDestination != "backend.dyndns.example" || rule.DomainStrategy != "ForceIPv4" {
+		t.Errorf("Unexpected rule %v", rule)
+	}
+
+	config.Rules[0].DomainStrategy = "PreferIPv4"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown domainStrategy")
+	}
+}
+
+func TestNATOutboundConfig_GeoConditions(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "branch",
+			"virtualDestination": "240.2.2.20",
+			"realDestination": "192.168.1.20",
+			"sourceGeoIP": ["10.1.0.0/16", "10.2.0.0/16"],
+			"destGeoIP": "240.2.2.0/24",
+			"destGeoSite": ["domain:corp.example", "full:portal.example"]
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rule := protoConfig.(*nat.Config).Rules[0]
+	if len(rule.SourceGeoip) != 1 || len(rule.SourceGeoip[0].Cidr) != 2 || len(rule.DestGeoip) != 1 {
+		t.Errorf("Unexpected geoip conditions %v, %v", rule.SourceGeoip, rule.DestGeoip)
+	}
+	if len(rule.DestGeosite) != 2 || rule.DestGeosite[0].Value != "corp.example" {
+		t.Errorf("Unexpected geosite conditions %v", rule.DestGeosite)
+	}
+
+	config.Rules[0].SourceGeoIP = &StringList{"10.1.0.0/33"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid sourceGeoIP")
+	}
+}
+
+func TestNATOutboundConfig_Schedule(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "office-hours",
+			"virtualDestination": "240.2.2.20",
+			"realDestination": "192.168.1.20",
+			"schedule": {"days": ["mon", "tue", "wed", "thu", "fri"], "start": "08:00", "end": "18:00", "timezone": "UTC"}
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	schedule := protoConfig.(*nat.Config).Rules[0].Schedule
+	if len(schedule.Days) != 5 || schedule.Start != "08:00" || schedule.End != "18:00" || schedule.Timezone != "UTC" {
+		t.Errorf("Unexpected schedule %v", schedule)
+	}
+
+	config.Rules[0].Schedule.End = "25:00"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid end time")
+	}
+}
+
+func TestNATOutboundConfig_SourceConditions(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "engineering",
+			"virtualDestination": "240.2.2.20",
+			"realDestination": "192.168.1.20",
+			"sourceAddresses": ["10.1.0.0/16", "10.9.9.9"],
+			"sourcePorts": "1024-65535",
+			"users": "alice@corp",
+			"userLevels": [1, 2]
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rule := protoConfig.(*nat.Config).Rules[0]
+	if len(rule.SourceAddresses) != 2 || rule.SourcePorts != "1024-65535" {
+		t.Errorf("Unexpected source conditions %v, %q", rule.SourceAddresses, rule.SourcePorts)
+	}
+
+	if len(rule.Users) != 1 || rule.Users[0] != "alice@corp" || len(rule.UserLevels) != 2 {
+		t.Errorf("Unexpected user conditions %v, %v", rule.Users, rule.UserLevels)
+	}
+
+	config.Rules[0].SourceAddresses = &StringList{"10.1.0.0/40"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid source network")
+	}
+}
+
+func TestNATOutboundConfig_ALG(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "ftp",
+			"virtualDestination": "240.2.2.21",
+			"realDestination": "192.168.1.21",
+			"ports": "21",
+			"alg": ["ftp"]
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if alg := protoConfig.(*nat.Config).Rules[0].Alg; len(alg) != 1 || alg[0] != "ftp" {
+		t.Errorf("Unexpected alg %v", alg)
+	}
+
+	config.Rules[0].ALG = &StringList{"gopher"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown alg")
+	}
+}
+
+func TestNATOutboundConfig_ReuseConnections(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "api",
+			"virtualDestination": "240.2.2.30",
+			"realDestination": "192.168.1.30",
+			"reuseConnections": true
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if !protoConfig.(*nat.Config).Rules[0].ReuseConnections {
+		t.Error("Expected the rule to reuse connections")
+	}
+
+	for _, protocol := range []string{"dns", "UDP,web", "TCP"} {
+		config.Rules[0].Protocol = protocol
+		if _, err := config.Build(); err != nil {
+			t.Errorf("Expected reuseConnections on a %s rule: %v", protocol, err)
+		}
+	}
+	for _, protocol := range []string{"udp", "UDP", "quic", "ntp,quic", "sctp"} {
+		config.Rules[0].Protocol = protocol
+		if _, err := config.Build(); err == nil {
+			t.Errorf("Expected error for reuseConnections on a %s rule", protocol)
+		}
+	}
+}
+
+func TestNATOutboundConfig_Sockopt(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "branch-b",
+			"virtualDestination": "240.3.0.0/16",
+			"realDestination": "10.3.0.0",
+			"sockopt": {"interface": "wg1", "mark": 3, "tos": 184}
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rule := protoConfig.(*nat.Config).Rules[0]
+	if rule.Sockopt.GetInterface() != "wg1" || rule.Sockopt.GetMark() != 3 || rule.Tos != 184 {
+		t.Errorf("Unexpected sockopt %v, tos %d", rule.Sockopt, rule.Tos)
+	}
+
+	config.Rules[0].Sockopt.TOS = 256
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a tos above 255")
+	}
+	config.Rules[0].Sockopt.TOS = 0
+	config.Rules[0].Sockopt.FlowLabel = "0x12345"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown flowLabel")
+	}
+	config.Rules[0].Sockopt.FlowLabel = ""
+	config.Rules[0].Sockopt.DialerProxy = "tunnel"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a dialerProxy in the sockopt of a rule")
+	}
+}
+
+func TestNATOutboundConfig_FlowQoS(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"virtualRanges": [{
+			"npTv6VirtualPrefix": "fd01:203:405:1::/64",
+			"npTv6RealPrefix": "2001:db8:1:1::/64",
+			"tos": 184,
+			"preserveTos": true,
+			"flowLabel": "auto"
+		}],
+		"rules": [{
+			"ruleId": "nat64",
+			"virtualDestination": "240.3.0.0/16",
+			"realDestination": "10.3.0.0",
+			"sockopt": {"preserveTos": true, "flowLabel": "none"}
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	natConfig := protoConfig.(*nat.Config)
+	if rule := natConfig.Rules[0]; !rule.PreserveTos || rule.FlowLabel != "none" {
+		t.Errorf("Unexpected preserveTos %v, flowLabel %q", rule.PreserveTos, rule.FlowLabel)
+	}
+	if vr := natConfig.VirtualRanges[0]; vr.Tos != 184 || !vr.PreserveTos || vr.FlowLabel != "auto" {
+		t.Errorf("Unexpected range tos %d, preserveTos %v, flowLabel %q", vr.Tos, vr.PreserveTos, vr.FlowLabel)
+	}
+
+	config.VirtualRanges[0].TOS = 256
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a range tos above 255")
+	}
+	config.VirtualRanges[0].TOS = 0
+	config.VirtualRanges[0].FlowLabel = "random"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an unknown range flowLabel")
+	}
+}
+
+func TestNATOutboundConfig_ForwardTag(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "site-b",
+			"virtualDestination": "240.3.0.0/16",
+			"realDestination": "10.3.0.0",
+			"forwardTag": "vless-site-b"
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if tag := protoConfig.(*nat.Config).Rules[0].ForwardTag; tag != "vless-site-b" {
+		t.Errorf("Unexpected forwardTag %q", tag)
+	}
+
+	config.Rules[0].Sockopt = &NATSockopt{TOS: 184}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a rule with both forwardTag and sockopt")
+	}
+}
+
+func TestNATOutboundConfig_Domains(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"virtualDestination": "240.2.2.0/24",
+			"realDestination": "192.168.1.20",
+			"domains": ["*.internal.corp", "wiki.corp"]
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if domains := protoConfig.(*nat.Config).Rules[0].Domains; len(domains) != 2 || domains[0] != "*.internal.corp" {
+		t.Errorf("Unexpected domains %v", domains)
+	}
+
+	*config.Rules[0].Domains = StringList{"db.*.corp"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid domain pattern")
+	}
+}
+
+func TestNATOutboundConfig_RateLimit(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{
+			"ruleId": "backup",
+			"virtualDestination": "240.3.0.10",
+			"realDestination": "10.3.0.10",
+			"rateLimit": {"uplinkBytesPerSecond": 1250000, "downlinkBytesPerSecond": 2500000, "burstBytes": 65536}
+		}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	limit := protoConfig.(*nat.Config).Rules[0].RateLimit
+	if limit.UplinkBytesPerSecond != 1250000 || limit.DownlinkBytesPerSecond != 2500000 || limit.BurstBytes != 65536 {
+		t.Errorf("Unexpected rateLimit %v", limit)
+	}
+
+	config.Rules[0].RateLimit = &NATRateLimit{BurstBytes: 65536}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a rateLimit without a rate")
+	}
+}
+
+func TestNATOutboundConfig_RuleMaxSessions(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"virtualRanges": [{"virtualNetwork": "240.4.0.0/24", "realNetwork": "10.4.0.0/24", "maxSessions": 500}],
+		"rules": [{"ruleId": "backup", "virtualDestination": "240.3.0.10", "realDestination": "10.3.0.10", "maxSessions": 20}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	natConfig := protoConfig.(*nat.Config)
+	if natConfig.Rules[0].MaxSessions != 20 || natConfig.VirtualRanges[0].MaxSessions != 500 {
+		t.Errorf("Unexpected maxSessions %d and %d", natConfig.Rules[0].MaxSessions, natConfig.VirtualRanges[0].MaxSessions)
+	}
+
+	config.Rules[0].RealDestination = ""
+	config.Rules[0].Action = "deny"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for maxSessions on a deny rule")
+	}
+}
+
+func TestNATOutboundConfig_RuleMTU(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{"ruleId": "tunnel", "virtualDestination": "240.3.0.10", "realDestination": "10.3.0.10", "tcpMssClamp": 1360, "mtu": 1400, "mtuPolicy": "drop"}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	rule := protoConfig.(*nat.Config).Rules[0]
+	if rule.TcpMssClamp != 1360 || rule.Mtu != 1400 || rule.MtuPolicy != "drop" {
+		t.Errorf("Unexpected tcpMssClamp %d, mtu %d and mtuPolicy %q", rule.TcpMssClamp, rule.Mtu, rule.MtuPolicy)
+	}
+
+	for _, invalid := range []NATRule{
+		{RuleID: "small", VirtualDestination: "240.3.0.10", MTU: 500},
+		{RuleID: "policy", VirtualDestination: "240.3.0.10", MTUPolicy: "drop"},
+		{RuleID: "unknown", VirtualDestination: "240.3.0.10", MTU: 1400, MTUPolicy: "truncate"},
+		{RuleID: "forward", VirtualDestination: "240.3.0.10", MTU: 1400, ForwardTag: "tunnel"},
+		{RuleID: "deny", VirtualDestination: "240.3.0.10", TCPMSSClamp: 1360, Action: "deny"},
+	} {
+		if _, err := invalid.Build(); err == nil {
+			t.Errorf("Expected error for rule %s", invalid.RuleID)
+		}
+	}
+}
+
+func TestNATOutboundConfig_SessionWebhook(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"sessionWebhook": {"url": "https://billing.example.com/nat", "batchSize": 500, "flushInterval": 2000, "maxRetries": 5, "authorization": "Bearer token"}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	webhook := protoConfig.(*nat.Config).SessionWebhook
+	if webhook.Url != "https://billing.example.com/nat" || webhook.BatchSize != 500 || webhook.FlushInterval != 2000 || webhook.MaxRetries != 5 || webhook.Authorization != "Bearer token" {
+		t.Errorf("Unexpected session webhook %v", webhook)
+	}
+
+	config.SessionWebhook = &SessionWebhook{URL: "billing.example.com"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a webhook without a scheme")
+	}
+}
+
+func TestNATOutboundConfig_SessionPersistence(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"sessionPersistence": {"redisAddress": "127.0.0.1:6379", "redisKey": "nat:site-a", "interval": 30}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	persistence := protoConfig.(*nat.Config).SessionPersistence
+	if persistence.RedisAddress != "127.0.0.1:6379" || persistence.RedisKey != "nat:site-a" || persistence.Interval != 30 {
+		t.Errorf("Unexpected session persistence %v", persistence)
+	}
+
+	config.SessionPersistence = &SessionPersistence{}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for session persistence without a store")
+	}
+	config.SessionPersistence = &SessionPersistence{RedisAddress: "localhost"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a Redis address without a port")
+	}
+}
+
+func TestNATOutboundConfig_SharedSessions(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "anycast",
+		"sharedSessions": {"redisAddress": "10.0.0.10:6379", "redisPassword": "shared", "keyPrefix": "nat:anycast:", "syncInterval": 5}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	shared := protoConfig.(*nat.Config).SharedSessions
+	if shared.RedisAddress != "10.0.0.10:6379" || shared.RedisPassword != "shared" || shared.KeyPrefix != "nat:anycast:" || shared.SyncInterval != 5 {
+		t.Errorf("Unexpected shared sessions %v", shared)
+	}
+
+	config.SharedSessions = &SharedSessions{}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for shared sessions without a Redis address")
+	}
+}
+
+func TestNATOutboundConfig_KernelOffload(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "edge",
+		"kernelOffload": {"table": "edge_nat", "nftPath": "/usr/sbin/nft", "mark": 16}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	offload := protoConfig.(*nat.Config).KernelOffload
+	if offload.Table != "edge_nat" || offload.NftPath != "/usr/sbin/nft" || offload.Mark != 16 {
+		t.Errorf("Unexpected kernel offload %v", offload)
+	}
+
+	config.MatchStrategy = "longestPrefix"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for kernel offload with the longestPrefix strategy")
+	}
+}
+
+func TestNATOutboundConfig_Replication(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"replication": {"listen": "0.0.0.0:9901", "peer": "10.0.0.2:9901", "secret": "shared", "syncInterval": 5}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	replication := protoConfig.(*nat.Config).Replication
+	if replication.Listen != "0.0.0.0:9901" || replication.Peer != "10.0.0.2:9901" || replication.Secret != "shared" || replication.SyncInterval != 5 {
+		t.Errorf("Unexpected replication %v", replication)
+	}
+
+	config.Replication = &NATReplication{}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for replication without addresses")
+	}
+	config.Replication = &NATReplication{Peer: "10.0.0.2"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a peer without a port")
+	}
+}
+
+func TestNATOutboundConfig_RuleDistribution(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-b",
+		"ruleDistribution": {"controller": "10.0.0.1:9902", "secret": "shared"}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	distribution := protoConfig.(*nat.Config).RuleDistribution
+	if distribution.Controller != "10.0.0.1:9902" || distribution.Listen != "" || distribution.Secret != "shared" {
+		t.Errorf("Unexpected rule distribution %v", distribution)
+	}
+
+	config.RulesFile = "rules.json"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a member with a rules file")
+	}
+	config.RulesFile = ""
+	config.RuleDistribution = &NATRuleDistribution{Listen: "0.0.0.0:9902", Controller: "10.0.0.1:9902"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for both a listen and a controller address")
+	}
+	config.RuleDistribution = &NATRuleDistribution{Listen: "9902"}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a listen address without a host")
+	}
+}
+
+func TestNATOutboundConfig_AddressPool(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"addressPool": {"network": "240.5.5.0/24", "leaseTime": 600, "leaseFile": "leases.json"}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if pool := protoConfig.(*nat.Config).AddressPool; pool.Network != "240.5.5.0/24" || pool.LeaseTime != 600 || pool.LeaseFile != "leases.json" {
+		t.Errorf("Unexpected address pool %v", pool)
+	}
+
+	config.AddressPool.Network = "240.5.5.1"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an address pool network without a prefix length")
+	}
+}
+
+func TestNATOutboundConfig_FakeDNS(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"fakeDns": true,
+		"rules": [{"virtualDestination": "db.corp", "realDestination": "192.168.1.20"}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if !protoConfig.(*nat.Config).FakeDns {
+		t.Error("Expected fakeDns to be enabled")
+	}
+}
+
+func TestNATOutboundConfig_DisableSplice(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{"siteId": "site-a", "disableSplice": true}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if !protoConfig.(*nat.Config).DisableSplice {
+		t.Error("Expected splice to be disabled")
+	}
+}
+
+func TestNATOutboundConfig_UDPBatching(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{"siteId": "site-a", "udpBatching": true}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if !protoConfig.(*nat.Config).UdpBatching {
+		t.Error("Expected UDP batching to be enabled")
+	}
+}
+
+func TestNATOutboundConfig_PerSourceLimits(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"perSourceLimits": {"maxSessions": 200, "newSessionsPerSecond": 20, "burst": 50}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	limits := protoConfig.(*nat.Config).PerSourceLimits
+	if limits.GetMaxSessions() != 200 || limits.GetNewSessionsPerSecond() != 20 || limits.GetBurst() != 50 {
+		t.Errorf("Unexpected per-source limits %v", limits)
+	}
+
+	config.PerSourceLimits.NewSessionsPerSecond = 0
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected a burst without a rate to be rejected")
+	}
+}
+
+func TestNATOutboundConfig_NewSessionRate(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"resourceLimits": {"maxSessions": 10000, "newSessionsPerSecond": 500, "newSessionBurst": 2000}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if limits := protoConfig.(*nat.Config).Limits; limits.NewSessionsPerSecond != 500 || limits.NewSessionBurst != 2000 {
+		t.Errorf("Unexpected resource limits %v", limits)
+	}
+
+	config.ResourceLimits.NewSessionsPerSecond = 0
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected a new session burst without a rate to be rejected")
+	}
+}
+
+func TestNATOutboundConfig_CleanupThreshold(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"resourceLimits": {"maxMemoryMB": 64, "cleanupThreshold": 0.9}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if limits := protoConfig.(*nat.Config).Limits; limits.MaxMemoryMb != 64 || limits.CleanupThreshold != 0.9 {
+		t.Errorf("Unexpected resource limits %v", limits)
+	}
+
+	config.ResourceLimits.CleanupThreshold = 1.5
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected a cleanup threshold above 1 to be rejected")
+	}
+}
+
+func TestNATOutboundConfig_Cluster(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"cluster": {
+			"nodeId": "node-a",
+			"nodes": [{"id": "node-a"}, {"id": "node-b", "outboundTag": "to-node-b"}]
+		}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	cluster := protoConfig.(*nat.Config).Cluster
+	if cluster.NodeId != "node-a" || len(cluster.Nodes) != 2 || cluster.Nodes[1].OutboundTag != "to-node-b" {
+		t.Errorf("Unexpected cluster %v", cluster)
+	}
+
+	config.Cluster.NodeID = "node-c"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a node ID outside the node list")
+	}
+}
+
+func TestNATOutboundConfig_PortControl(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"portControl": {
+			"listen": "240.2.2.1:5351",
+			"externalAddress": "203.0.113.1",
+			"ports": "20000-29999",
+			"maxLifetime": 3600,
+			"upnpListen": "240.2.2.1:5000"
+		}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	pc := protoConfig.(*nat.Config).PortControl
+	if pc.Listen != "240.2.2.1:5351" || pc.ExternalAddress != "203.0.113.1" || pc.Ports != "20000-29999" || pc.MaxLifetime != 3600 || pc.UpnpListen != "240.2.2.1:5000" {
+		t.Errorf("Unexpected port control %v", pc)
+	}
+
+	config.PortControl.Ports = "30000-20000"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid port list")
+	}
+	config.PortControl.Ports = ""
+	config.PortControl.ExternalAddress = "gateway"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an external address that is not an IP")
+	}
+	config.PortControl.ExternalAddress = ""
+	config.PortControl.UPnPListen = "240.2.2.1"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a UPnP listen address without a port")
+	}
+}
+
+func TestNATOutboundConfig_Stun(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"stun": {
+			"address": "240.2.2.3",
+			"alternateAddress": "240.2.2.4",
+			"natBehavior": "full-cone"
+		}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	st := protoConfig.(*nat.Config).Stun
+	if st.Address != "240.2.2.3" || st.AlternateAddress != "240.2.2.4" || st.NatBehavior != "full-cone" {
+		t.Errorf("Unexpected STUN responder %v", st)
+	}
+
+	config.Stun.NATBehavior = "cone"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an invalid NAT behavior")
+	}
+	config.Stun.NATBehavior = ""
+	config.Stun.Address = ""
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a missing address")
+	}
+}
+
+func TestNATOutboundConfig_CLAT(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"clat": {"platPrefix": "2001:db8:64::/96", "sourcePrefix": "2001:db8:46::/96", "networks": ["198.51.100.0/24"]}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	cl := protoConfig.(*nat.Config).Clat
+	if cl.PlatPrefix != "2001:db8:64::/96" || cl.SourcePrefix != "2001:db8:46::/96" || len(cl.Networks) != 1 || cl.Networks[0] != "198.51.100.0/24" {
+		t.Errorf("Unexpected CLAT %v", cl)
+	}
+
+	config.CLAT.SourcePrefix = "2001:db8:64::/96"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a sourcePrefix overlapping the platPrefix")
+	}
+	config.CLAT.SourcePrefix = ""
+	config.CLAT.PLATPrefix = "2001:db8:64::/80"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for a platPrefix of an invalid length")
+	}
+	config.CLAT = &NATCLAT{Networks: NewStringList([]string{"2001:db8::/32"})}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an IPv6 network")
+	}
+}
+
+func TestNATOutboundConfig_MapRules(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20"}],
+		"mapRules": [{"ruleId": "isp", "ipv4Prefix": "192.0.2.0/24", "ipv6Prefix": "2001:db8::/40", "eaBitsLength": 16, "psidOffset": 4}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	mr := protoConfig.(*nat.Config).MapRules
+	if len(mr) != 1 || mr[0].RuleId != "isp" || mr[0].Ipv6Prefix != "2001:db8::/40" || mr[0].EaBitsLength != 16 || mr[0].PsidOffset != 4 {
+		t.Errorf("Unexpected MAP rules %v", mr)
+	}
+
+	config.MapRules[0].RuleID = "web"
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "mapRules[0] shares the ruleId web of a rule") {
+		t.Errorf("Expected the shared ruleId to be rejected, got %v", err)
+	}
+	config.MapRules[0].RuleID = ""
+	config.MapRules[0].EABitsLength = 30
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "invalid mapRules[0]") {
+		t.Errorf("Expected too many EA bits to be rejected, got %v", err)
+	}
+}
+
+func TestNATOutboundConfig_Tags(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"virtualRanges": [{"virtualNetwork": "240.3.3.0/24", "realNetwork": "192.168.3.0/24", "tags": "backup-window"}],
+		"rules": [{"ruleId": "backup", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "tags": ["backup-window", "storage"]}]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	natConfig := protoConfig.(*nat.Config)
+	if tags := natConfig.Rules[0].Tags; len(tags) != 2 || tags[0] != "backup-window" || tags[1] != "storage" {
+		t.Errorf("Unexpected tags of the rule %v", tags)
+	}
+	if tags := natConfig.VirtualRanges[0].Tags; len(tags) != 1 || tags[0] != "backup-window" {
+		t.Errorf("Unexpected tags of the range %v", tags)
+	}
+
+	config.Rules[0].Tags = &StringList{"storage", " "}
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected error for an empty tag")
+	}
+}
+
+func TestNATOutboundConfig_Enabled(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"virtualRanges": [{"virtualNetwork": "240.3.3.0/24", "realNetwork": "192.168.3.0/24", "enabled": false}],
+		"rules": [
+			{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "enabled": true},
+			{"ruleId": "db", "virtualDestination": "240.2.2.30", "realDestination": "192.168.1.30", "enabled": false},
+			{"ruleId": "ssh", "virtualDestination": "240.2.2.40", "realDestination": "192.168.1.40"}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	natConfig := protoConfig.(*nat.Config)
+	if rules := natConfig.Rules; rules[0].Disabled || !rules[1].Disabled || rules[2].Disabled {
+		t.Errorf("Expected only rule db to be disabled, got %v", rules)
+	}
+	if !natConfig.VirtualRanges[0].Disabled {
+		t.Error("Expected the range to be disabled")
+	}
+}
+
+func TestNATOutboundConfig_DestinationPatterns(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"rules": [
+			{"ruleId": "web", "virtualDestination": "240.2.*.20", "realDestination": "192.168.1.20"},
+			{"ruleId": "db", "virtualDestination": "regexp:^240\\.3\\.[0-9]+\\.10$", "realDestination": "192.168.1.30"}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if rules := protoConfig.(*nat.Config).Rules; rules[0].VirtualDestination != "240.2.*.20" || rules[1].VirtualDestination != `regexp:^240\.3\.[0-9]+\.10$` {
+		t.Errorf("Unexpected virtual destinations %v", rules)
+	}
+}
+
+func TestNATOutboundConfig_ComplianceMode(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"complianceMode": "rfc4787",
+		"sessionTimeout": {"tcpTimeout": 300, "udpTimeout": 300, "establishedTcpTimeout": 7440},
+		"rules": [
+			{"ruleId": "game", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "natBehavior": "full-cone"},
+			{"ruleId": "dns", "virtualDestination": "240.2.2.53", "realDestination": "192.168.1.53"}
+		]
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	protoConfig, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if mode := protoConfig.(*nat.Config).ComplianceMode; mode != "rfc4787" {
+		t.Errorf("Unexpected compliance mode %q", mode)
+	}
+
+	config.SessionTimeout.UDPTimeout = 60
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "sessionTimeout.udpTimeout must be at least 120 seconds") {
+		t.Errorf("Expected the short UDP timeout to be rejected, got %v", err)
+	}
+	config.SessionTimeout.UDPTimeout = 0
+	config.Rules[0].NATBehavior = "symmetric"
+	if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), "rule game has the symmetric natBehavior") {
+		t.Errorf("Expected the symmetric rule to be rejected, got %v", err)
+	}
+	config.Rules[0].NATBehavior = ""
+	config.SessionTimeout = nil
+	protoConfig, err = config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	if timeouts := protoConfig.(*nat.Config).SessionTimeout; timeouts.UdpTimeout != 300 || timeouts.EstablishedTcpTimeout != 7440 || timeouts.TransitoryTcpTimeout != 240 {
+		t.Errorf("Expected the default timeouts the RFCs recommend, got %v", timeouts)
+	}
+	config.ComplianceMode = "rfc5382"
+	if _, err := config.Build(); err == nil {
+		t.Error("Expected an unknown compliance mode to be rejected")
+	}
+}
+
+func TestNATOutboundConfig_Validation(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   string
+		location string
+	}{
+		{
+			name:     "overlapping virtual ranges",
+			config:   `"virtualRanges": [{"virtualNetwork": "240.2.2.0/24", "realNetwork": "192.168.1.0/24"}, {"virtualNetwork": "240.2.0.0/16", "realNetwork": "192.168.0.0/16"}]`,
+			location: "virtualRanges[1] (240.2.0.0/16) overlaps virtualRanges[0] (240.2.2.0/24)",
+		},
+		{
+			name:     "real network smaller than the virtual one",
+			config:   `"virtualRanges": [{"virtualNetwork": "240.2.0.0/16", "realNetwork": "192.168.1.0/24"}]`,
+			location: "virtualRanges[0]",
+		},
+		{
+			name:     "unparsable CIDR",
+			config:   `"virtualRanges": [{"virtualNetwork": "240.2.2.0/33", "realNetwork": "192.168.1.0/24"}]`,
+			location: "virtualRanges[0]",
+		},
+		{
+			name:     "mixed address families",
+			config:   `"virtualRanges": [{"virtualNetwork": "240.2.2.0/24", "realNetwork": "fd00::/120"}]`,
+			location: "virtualRanges[0]",
+		},
+		{
+			name:     "IPv6 prefix length",
+			config:   `"virtualRanges": [{"virtualNetwork": "240.2.2.0/24", "realNetwork": "192.168.1.0/24", "ipv6Prefix": "64:ff9b::/100"}]`,
+			location: "virtualRanges[0]: invalid ipv6Prefix",
+		},
+		{
+			name:     "port range length mismatch",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "portMapping": {"originalPort": "8000-8009", "translatedPort": "9000-9004"}}]`,
+			location: "rules[0]",
+		},
+		{
+			name:     "port mapping without original ports",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "portMapping": {"translatedPort": "80"}}]`,
+			location: `originalPort is empty, "any" maps every port`,
+		},
+		{
+			name:     "protocol ports of another protocol",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "portMapping": {"originalPort": "any", "protocolPorts": {"icmp": "80"}}}]`,
+			location: "protocolPorts has icmp, not tcp or udp",
+		},
+		{
+			name:     "port offset past 65535",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "ports": "8000-8100,65000", "portOffset": 1000}]`,
+			location: "portOffset 1000 shifts port 65000 to 66000, past 65535",
+		},
+		{
+			name:     "port offset of any port",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "portOffset": -1000}]`,
+			location: "NAT rule web: invalid portOffset",
+		},
+		{
+			name:     "port offset and port mapping",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "ports": "8080", "portOffset": 1, "portMapping": {"originalPort": "8080", "translatedPort": "80"}}]`,
+			location: "NAT rule web: portMapping and portOffset are mutually exclusive",
+		},
+		{
+			name:     "unknown session class",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20", "sessionClass": "batch"}]`,
+			location: "NAT rule web: invalid sessionClass",
+		},
+		{
+			name:     "session class of a bypass rule",
+			config:   `"rules": [{"ruleId": "direct", "virtualDestination": "240.2.2.20", "action": "bypass", "sessionClass": "bulk"}]`,
+			location: "bypass rules take no realDestination",
+		},
+		{
+			name:     "duplicate ruleId",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.2.20", "realDestination": "192.168.1.20"}, {"ruleId": "web", "virtualDestination": "240.2.2.21", "realDestination": "192.168.1.21"}]`,
+			location: "rules[1]: ruleId web is already used by rules[0]",
+		},
+		{
+			name:     "wildcard octet",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "240.2.*.256", "realDestination": "192.168.1.20"}]`,
+			location: "NAT rule web: invalid virtualDestination",
+		},
+		{
+			name:     "regular expression",
+			config:   `"rules": [{"ruleId": "web", "virtualDestination": "regexp:240.(2", "realDestination": "192.168.1.20"}]`,
+			location: "NAT rule web: invalid virtualDestination",
+		},
+	} {
+		var config NATOutboundConfig
+		if err := json.Unmarshal([]byte(`{"siteId": "site-a", `+test.config+`}`), &config); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.Build(); err == nil || !strings.Contains(err.Error(), test.location) {
+			t.Errorf("Expected error at %s for %s, got %v", test.location, test.name, err)
+		}
+	}
+
+	// Overlapping ranges of disjoint source sites never apply together
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{"siteId": "site-a", "virtualRanges": [
+		{"virtualNetwork": "240.2.2.0/24", "realNetwork": "192.168.1.0/24", "sourceSite": "site-a"},
+		{"virtualNetwork": "240.2.2.0/24", "realNetwork": "192.168.2.0/24", "sourceSite": "site-b,site-c"},
+		{"virtualNetwork": "240.3.3.0/24", "realNetwork": "10.0.0.0/16"}
+	]}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Build(); err != nil {
+		t.Errorf("Failed to build NAT config: %v", err)
+	}
+}
+
+func TestNATOutboundConfig_FromProto(t *testing.T) {
+	var config NATOutboundConfig
+	if err := json.Unmarshal([]byte(`{
+		"siteId": "site-a",
+		"userLevel": 1,
+		"nat64Prefix": "64:ff9b::",
+		"complianceMode": "rfc4787",
+		"objects": {"branch-servers": ["240.2.2.20", "240.2.3.0/24"]},
+		"virtualRanges": [
+			{"virtualNetwork": "240.3.3.0/24", "realNetwork": "192.168.3.0/24", "tos": 184, "flowLabel": "auto", "tags": ["branch"]},
+			{"virtualNetwork": "240.4.4.0/24", "realNetwork": "192.168.4.10", "masquerade": true, "masqueradePool": ["192.168.4.10", "192.168.4.11"]}
+		],
+		"rules": [
+			{
+				"ruleId": "branch",
+				"virtualDestination": "@branch-servers",
+				"realDestination": ["192.168.1.20", "192.168.1.21"],
+				"strategy": "least-sessions",
+				"userLevels": [0, 2],
+				"tags": ["branch", "backup-window"],
+				"schedule": {"days": ["mon", "fri"], "start": "08:00", "end": "18:00"}
+			},
+			{
+				"ruleId": "tuned",
+				"virtualDestination": "240.2.2.30",
+				"realDestination": "192.168.1.30",
+				"destGeoIP": ["10.0.0.0/8", "fd00::/8"],
+				"destGeoSite": ["domain:example.com", "full:www.example.org", "keyword:intranet"],
+				"sockopt": {"mark": 255, "tcpFastOpen": false, "domainStrategy": "UseIPv4", "customSockopt": [{"level": "6", "opt": "13", "value": "1"}], "tos": 46, "preserveTos": true}
+			},
+			{"ruleId": "blocked", "virtualDestination": "240.2.2.40", "action": "deny", "enabled": false},
+			{"ruleId": "shifted", "virtualDestination": "240.2.2.50", "realDestination": "192.168.1.50", "ports": "8000-8100", "portOffset": 1000, "sessionClass": "bulk"},
+			{"ruleId": "dns", "virtualDestination": "240.2.2.53", "realDestination": "192.168.1.53", "portMapping": {"originalPort": "53", "translatedPort": "5353", "protocolPorts": {"udp": "5454"}}}
+		],
+		"clat": {"sourcePrefix": "2001:db8:46::/96", "networks": ["198.51.100.0/24"]},
+		"mapRules": [{"ipv4Prefix": "192.0.2.0/24", "ipv6Prefix": "2001:db8::/40", "eaBitsLength": 16}],
+		"sessionLog": {"sink": "file", "path": "/var/log/nat.log"}
+	}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	built, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build NAT config: %v", err)
+	}
+	// The configuration survives protobuf the same way as xray convert pb
+	data, err := proto.Marshal(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protoConfig := new(nat.Config)
+	if err := proto.Unmarshal(data, protoConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	converted, err := NATOutboundConfigFromProto(protoConfig)
+	if err != nil {
+		t.Fatalf("Failed to convert NAT config: %v", err)
+	}
+	jsonData, err := json.Marshal(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"tcpTimeout":300`, `"maxSessions":10000`, `"branch":["240.2.2.20","240.2.3.0/24"]`, `"virtualDestination":"@branch"`, `"tcpFastOpen":-1`, `"userLevels":[0,2]`, `"enabled":false`, `"networks":["198.51.100.0/24"]`, `"eaBitsLength":16`, `"sessionClass":"bulk"`} {
+		if !strings.Contains(string(jsonData), expected) {
+			t.Errorf("Expected %s in %s", expected, jsonData)
+		}
+	}
+	for _, unexpected := range []string{`null`, `"enableHairpin"`, `"happyEyeballs"`, `""`} {
+		if strings.Contains(string(jsonData), unexpected) {
+			t.Errorf("Expected no %s in %s", unexpected, jsonData)
+		}
+	}
+
+	var decoded NATOutboundConfig
+	if err := json.Unmarshal(jsonData, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := decoded.Build()
+	if err != nil {
+		t.Fatalf("Failed to build the converted NAT config: %v", err)
+	}
+	if !proto.Equal(rebuilt, protoConfig) {
+		t.Errorf("Expected the converted configuration to build the same, got %v, want %v", rebuilt, protoConfig)
+	}
+
+	// Rules sharing a ruleId come from one rule with an object of virtual destinations
+	protoConfig.Rules[1].Strategy = "random"
+	if _, err := NATOutboundConfigFromProto(protoConfig); err == nil {
+		t.Error("Expected error for rules sharing a ruleId with other differences")
+	}
+}
@@ -2,10 +2,13 @@ package serial_test
 
 import (
 	"bytes"
+	"io"
 	"strings"
 	"testing"
 
+	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/infra/conf/serial"
+	"github.com/xtls/xray-core/proxy/nat"
 )
 
 func TestLoaderError(t *testing.T) {
@@ -61,3 +64,70 @@ func TestLoaderError(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadNATOutboundFormats(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Load   func(io.Reader) (*core.Config, error)
+		Config string
+	}{
+		{
+			Name: "yaml",
+			Load: serial.LoadYAMLConfig,
+			Config: `
+outbounds:
+  - protocol: nat
+    settings:
+      siteId: site-b
+      virtualRanges:
+        - virtualNetwork: 240.2.2.0/24
+          realNetwork: 192.168.1.0/24
+      rules:
+        - ruleId: web
+          virtualDestination: 240.2.2.20
+          realDestination: [192.168.1.20, 192.168.1.21]
+          protocol: web
+          ports: "443"
+`,
+		},
+		{
+			Name: "toml",
+			Load: serial.LoadTOMLConfig,
+			Config: `
+[[outbounds]]
+protocol = "nat"
+
+[outbounds.settings]
+siteId = "site-b"
+
+[[outbounds.settings.virtualRanges]]
+virtualNetwork = "240.2.2.0/24"
+realNetwork = "192.168.1.0/24"
+
+[[outbounds.settings.rules]]
+ruleId = "web"
+virtualDestination = "240.2.2.20"
+realDestination = ["192.168.1.20", "192.168.1.21"]
+protocol = "web"
+ports = "443"
+`,
+		},
+	}
+	for _, testCase := range testCases {
+		config, err := testCase.Load(strings.NewReader(testCase.Config))
+		if err != nil {
+			t.Fatalf("%s: failed to load NAT outbound: %v", testCase.Name, err)
+		}
+		settings, err := config.Outbound[0].ProxySettings.GetInstance()
+		if err != nil {
+			t.Fatal(err)
+		}
+		natConfig := settings.(*nat.Config)
+		if natConfig.SiteId != "site-b" || len(natConfig.VirtualRanges) != 1 || len(natConfig.Rules) != 1 {
+			t.Fatalf("%s: unexpected NAT config %v", testCase.Name, natConfig)
+		}
+		if rule := natConfig.Rules[0]; rule.RuleId != "web" || len(rule.RealDestinations) != 2 || rule.Protocol != "web" || rule.Ports != "443" {
+			t.Errorf("%s: unexpected NAT rule %v", testCase.Name, rule)
+		}
+	}
+}
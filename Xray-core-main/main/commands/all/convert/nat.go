@@ -0,0 +1,84 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/xtls/xray-core/common/cmdarg"
+	creflect "github.com/xtls/xray-core/common/reflect"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/nat"
+)
+
+var cmdNAT = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} convert nat [config file] [config file] ...",
+	Short:       "Convert the nat outbounds of configs to json",
+	Long: `
+Convert the nat outbounds of multiple configs to JSON settings that build
+the same configuration again. JSON, YAML, TOML and ProtoBuf (eg. mix.pb)
+can be used.
+
+The defaults injected while building, such as sessionTimeout and
+resourceLimits, are written out explicitly. Rules expanded from an object
+of virtual destinations get the object back. Geo conditions become the
+CIDRs and domains they were loaded into, geoip.dat countries being kept
+as references.
+
+Examples:
+
+    {{.Exec}} convert nat config.json
+    {{.Exec}} convert nat mix.pb
+	`,
+	Run: executeConvertNAT,
+}
+
+type natOutbound struct {
+	Tag      string                  `json:"tag,omitempty"`
+	Protocol string                  `json:"protocol"`
+	Settings *conf.NATOutboundConfig `json:"settings"`
+}
+
+func executeConvertNAT(cmd *base.Command, args []string) {
+	cmd.Flag.Parse(args)
+
+	unnamedArgs := cmdarg.Arg{}
+	for _, v := range cmd.Flag.Args() {
+		unnamedArgs.Set(v)
+	}
+	if len(unnamedArgs) < 1 {
+		base.Fatalf("invalid config list length: %d", len(unnamedArgs))
+	}
+
+	pbConfig, err := core.LoadConfig("auto", unnamedArgs)
+	if err != nil {
+		base.Fatalf("failed to load config: %s", err)
+	}
+
+	outbounds := make([]natOutbound, 0)
+	for _, outbound := range pbConfig.Outbound {
+		if outbound.ProxySettings == nil {
+			continue
+		}
+		instance, err := outbound.ProxySettings.GetInstance()
+		if err != nil {
+			base.Fatalf("failed to load settings of outbound %s: %s", outbound.Tag, err)
+		}
+		natConfig, ok := instance.(*nat.Config)
+		if !ok {
+			continue
+		}
+		settings, err := conf.NATOutboundConfigFromProto(natConfig)
+		if err != nil {
+			base.Fatalf("failed to convert nat outbound %s: %s", outbound.Tag, err)
+		}
+		outbounds = append(outbounds, natOutbound{Tag: outbound.Tag, Protocol: "nat", Settings: settings})
+	}
+
+	j, err := creflect.JSONMarshalWithoutEscape(map[string]interface{}{"outbounds": outbounds})
+	if err != nil {
+		base.Fatalf("failed to marshal nat outbounds to json: %s", err)
+	}
+	fmt.Print(string(j))
+}
@@ -0,0 +1,148 @@
+package nat
+
+import (
+	"github.com/xtls/xray-core/main/commands/base"
+	natService "github.com/xtls/xray-core/proxy/nat/command"
+)
+
+var cmdDrain = &base.Command{
+	UsageLine: "{{.Exec}} nat drain",
+	Short:     "Drain NAT outbounds for maintenance",
+	Long: `{{.Exec}} {{.LongName}} stops NAT outbounds from creating sessions for new flows while the
+existing sessions finish, reports how many are left, and resumes the translation of new flows.
+`,
+	Commands: []*base.Command{
+		cmdDrainStart,
+		cmdDrainStatus,
+		cmdDrainResume,
+	},
+}
+
+var cmdDrainStart = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat drain start [--server=127.0.0.1:8080] [-tag nat] [-action reject] [-deadline seconds]",
+	Short:       "Start draining NAT outbounds",
+	Long: `
+Stop creating sessions for new flows to virtual destinations, while the existing sessions
+finish. Flows from the real side and echo queries are refused.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only this NAT outbound.
+
+	-action <reject|bypass>
+		Close new flows at once, or leave them untranslated. Default reject
+
+	-deadline <seconds>
+		Close the sessions left after this many seconds. Default 0, to let them finish
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag nat -deadline 600
+`,
+	Run: executeDrainStart,
+}
+
+func executeDrainStart(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	action := cmd.Flag.String("action", "reject", "")
+	deadline := cmd.Flag.Uint("deadline", 0, "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.Drain(ctx, &natService.DrainRequest{Tag: outboundTag, Action: *action, Deadline: uint32(*deadline)})
+	if err != nil {
+		base.Fatalf("failed to drain: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdDrainStatus = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat drain status [--server=127.0.0.1:8080] [-tag nat]",
+	Short:       "Show the drain of NAT outbounds",
+	Long: `
+Show whether NAT outbounds drain, since when, their deadline and how many sessions are left.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only this NAT outbound.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag nat
+`,
+	Run: executeDrainStatus,
+}
+
+func executeDrainStatus(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.GetDrainStatus(ctx, &natService.GetDrainStatusRequest{Tag: outboundTag})
+	if err != nil {
+		base.Fatalf("failed to get drain status: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdDrainResume = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat drain resume [--server=127.0.0.1:8080] [-tag nat]",
+	Short:       "Stop draining NAT outbounds",
+	Long: `
+End the drain of NAT outbounds; new flows are translated again.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only this NAT outbound.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag nat
+`,
+	Run: executeDrainResume,
+}
+
+func executeDrainResume(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.Resume(ctx, &natService.ResumeRequest{Tag: outboundTag})
+	if err != nil {
+		base.Fatalf("failed to resume: %s", err)
+	}
+	showJSONResponse(resp)
+}
@@ -0,0 +1,67 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	creflect "github.com/xtls/xray-core/common/reflect"
+	"github.com/xtls/xray-core/main/commands/base"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// CmdNAT holds the sub commands managing the NAT outbounds of an Xray process
+var CmdNAT = &base.Command{
+	UsageLine: "{{.Exec}} nat",
+	Short:     "Manage NAT sessions and rules",
+	Long: `{{.Exec}} {{.LongName}} inspects the sessions and rules of NAT outbounds through the NATService API.
+
+> Ensure that the "NATService" is properly configured under "config.api.services" in the server configuration.
+`,
+	Commands: []*base.Command{
+		cmdSessions,
+		cmdRules,
+		cmdDrain,
+		cmdSimulate,
+		cmdSelfTest,
+	},
+}
+
+var (
+	apiServerAddr string
+	apiTimeout    int
+	outboundTag   string
+)
+
+func setSharedFlags(cmd *base.Command) {
+	cmd.Flag.StringVar(&apiServerAddr, "s", "127.0.0.1:8080", "")
+	cmd.Flag.StringVar(&apiServerAddr, "server", "127.0.0.1:8080", "")
+	cmd.Flag.IntVar(&apiTimeout, "t", 3, "")
+	cmd.Flag.IntVar(&apiTimeout, "timeout", 3, "")
+	cmd.Flag.StringVar(&outboundTag, "tag", "", "")
+}
+
+func dialAPIServer() (conn *grpc.ClientConn, ctx context.Context, close func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(apiTimeout)*time.Second)
+	conn, err := grpc.DialContext(ctx, apiServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		base.Fatalf("failed to dial %s", apiServerAddr)
+	}
+	close = func() {
+		cancel()
+		conn.Close()
+	}
+	return
+}
+
+func showJSONResponse(m proto.Message) {
+	if j, ok := creflect.MarshalToJson(m, true); ok {
+		fmt.Println(j)
+	} else {
+		fmt.Fprintf(os.Stdout, "%v\n", m)
+		base.Fatalf("error encode json")
+	}
+}
@@ -0,0 +1,296 @@
+package nat
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/main/commands/base"
+	natService "github.com/xtls/xray-core/proxy/nat/command"
+)
+
+var cmdRules = &base.Command{
+	UsageLine: "{{.Exec}} nat rules",
+	Short:     "Inspect NAT rules",
+	Long: `{{.Exec}} {{.LongName}} lists the rules of NAT outbounds, tests which rule a flow matches,
+disables and enables rules by ruleId or tag, and adds rules.
+`,
+	Commands: []*base.Command{
+		cmdListRules,
+		cmdTestRules,
+		cmdDisableRules,
+		cmdEnableRules,
+		cmdAddRule,
+	},
+}
+
+var cmdListRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat rules list [--server=127.0.0.1:8080] [-tag nat] [-ruletag tag] [-expired]",
+	Short:       "List NAT rules",
+	Long: `
+List the active rules of NAT outbounds in match order, with when the rules carrying a ttl
+or expiresAt expire.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the rules of this NAT outbound.
+
+	-ruletag <tag>
+		Only the rules carrying this tag.
+
+	-expired
+		List the rules of the rules file or the controller that expired instead.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -expired
+`,
+	Run: executeListRules,
+}
+
+func executeListRules(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	ruleTag := cmd.Flag.String("ruletag", "", "")
+	expired := cmd.Flag.Bool("expired", false, "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.ListRules(ctx, &natService.ListRulesRequest{Tag: outboundTag, RuleTag: *ruleTag, Expired: *expired})
+	if err != nil {
+		base.Fatalf("failed to list rules: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdTestRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat rules test [--server=127.0.0.1:8080] [-tag nat] [-network udp] [-source ip:port] <ip>:<port>",
+	Short:       "Test which NAT rule a destination matches",
+	Long: `
+Show which rule a flow to the destination would match and what it would be translated to,
+without creating a session.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		The NAT outbound to test, may be omitted when there is only one.
+
+	-network <tcp|udp>
+		Network of the flow. Default tcp
+
+	-source <ip:port>
+		Source of the flow, for rules with source conditions.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -network udp 240.2.2.20:53
+`,
+	Run: executeTestRules,
+}
+
+func executeTestRules(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	network := cmd.Flag.String("network", "tcp", "")
+	source := cmd.Flag.String("source", "", "")
+	cmd.Flag.Parse(args)
+
+	if cmd.Flag.NArg() != 1 {
+		base.Fatalf("a destination <ip>:<port> is required")
+	}
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.TestTranslation(ctx, &natService.TestTranslationRequest{
+		Tag:         outboundTag,
+		Network:     *network,
+		Source:      *source,
+		Destination: cmd.Flag.Arg(0),
+	})
+	if err != nil {
+		base.Fatalf("failed to test the destination: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdDisableRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat rules disable [--server=127.0.0.1:8080] [-tag nat] <-rule ruleId | -ruletag tag>",
+	Short:       "Disable NAT rules",
+	Long: `
+Disable a rule, or every rule and virtual range carrying a tag, including those a reload adds
+later. Flows are matched as if the disabled rules did not exist; their sessions are kept, flush
+them with "nat sessions flush".
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the rules of this NAT outbound.
+
+	-rule <ruleId>
+		The rule to disable.
+
+	-ruletag <tag>
+		Disable the rules and virtual ranges carrying this tag.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -ruletag backup-window
+`,
+	Run: executeDisableRules,
+}
+
+func executeDisableRules(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rule := cmd.Flag.String("rule", "", "")
+	ruleTag := cmd.Flag.String("ruletag", "", "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.DisableRules(ctx, &natService.DisableRulesRequest{Tag: outboundTag, RuleId: *rule, RuleTag: *ruleTag})
+	if err != nil {
+		base.Fatalf("failed to disable rules: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdEnableRules = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat rules enable [--server=127.0.0.1:8080] [-tag nat] <-rule ruleId | -ruletag tag>",
+	Short:       "Enable NAT rules",
+	Long: `
+Enable a rule, or the rules and virtual ranges carrying a tag, disabled by "nat rules disable".
+Rules stay disabled while their ruleId or another of their tags is. A rule disabled in the
+configuration with "enabled": false is enabled by its ruleId.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the rules of this NAT outbound.
+
+	-rule <ruleId>
+		The rule to enable.
+
+	-ruletag <tag>
+		Enable the rules and virtual ranges carrying this tag.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -ruletag backup-window
+`,
+	Run: executeEnableRules,
+}
+
+func executeEnableRules(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rule := cmd.Flag.String("rule", "", "")
+	ruleTag := cmd.Flag.String("ruletag", "", "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.EnableRules(ctx, &natService.EnableRulesRequest{Tag: outboundTag, RuleId: *rule, RuleTag: *ruleTag})
+	if err != nil {
+		base.Fatalf("failed to enable rules: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdAddRule = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat rules add [--server=127.0.0.1:8080] [-tag nat] <rule.json>",
+	Short:       "Add a NAT rule",
+	Long: `
+Add a rule, given as the JSON of a rule of the NAT configuration, after the active rules.
+A rule matching the same virtual destination as an active rule and translating it
+differently, or shadowing or shadowed by an active rule, is not added: the conflicts are
+printed and the command exits with status 1. Added rules stay after the rules of the rules
+file or the controller across their reloads, until their ttl or expiresAt passes or Xray
+restarts.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		The NAT outbound to add the rule to, required with several NAT outbounds.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 db.json
+`,
+	Run: executeAddRule,
+}
+
+func executeAddRule(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	cmd.Flag.Parse(args)
+	if cmd.Flag.NArg() != 1 {
+		base.Fatalf("a rule file is required")
+	}
+	data, err := os.ReadFile(cmd.Flag.Arg(0))
+	if err != nil {
+		base.Fatalf("failed to read the rule: %s", err)
+	}
+	rule := new(conf.NATRule)
+	if err := json.Unmarshal(data, rule); err != nil {
+		base.Fatalf("failed to parse the rule: %s", err)
+	}
+	natRule, err := rule.Build()
+	if err != nil {
+		base.Fatalf("invalid rule: %s", err)
+	}
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.AddRule(ctx, &natService.AddRuleRequest{Tag: outboundTag, Rule: natRule})
+	if err != nil {
+		base.Fatalf("failed to add the rule: %s", err)
+	}
+	showJSONResponse(resp)
+	if !resp.Added {
+		base.SetExitStatus(1)
+	}
+}
@@ -0,0 +1,54 @@
+package nat
+
+import (
+	"github.com/xtls/xray-core/main/commands/base"
+	natService "github.com/xtls/xray-core/proxy/nat/command"
+)
+
+var cmdSelfTest = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat selftest [--server=127.0.0.1:8080] [-tag nat]",
+	Short:       "Check NAT outbounds against RFC 4787, RFC 5382 and RFC 5508",
+	Long: `
+Check the running behavior of NAT outbounds against the NAT requirements of RFC 4787,
+RFC 5382 and RFC 5508: endpoint-independent UDP mapping, filtering, hairpinning and the
+UDP, TCP and ICMP timeouts. The UDP mappings are probed through loopback servers. The
+command exits with status 1 when a check fails.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only this NAT outbound.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag nat
+`,
+	Run: executeSelfTest,
+}
+
+func executeSelfTest(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.SelfTest(ctx, &natService.SelfTestRequest{Tag: outboundTag})
+	if err != nil {
+		base.Fatalf("failed to run the self-test: %s", err)
+	}
+	showJSONResponse(resp)
+	for _, result := range resp.Results {
+		if !result.Passed {
+			base.SetExitStatus(1)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	natService "github.com/xtls/xray-core/proxy/nat/command"
+)
+
+var cmdSessions = &base.Command{
+	UsageLine: "{{.Exec}} nat sessions",
+	Short:     "Manage NAT sessions",
+	Long: `{{.Exec}} {{.LongName}} lists, exports and flushes the active sessions of NAT outbounds, and
+shows how much of the per-source limits each virtual source address uses.
+`,
+	Commands: []*base.Command{
+		cmdListSessions,
+		cmdExportSessions,
+		cmdDiffSessions,
+		cmdWatchSessions,
+		cmdFlushSessions,
+		cmdListSources,
+	},
+}
+
+var cmdListSessions = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat sessions list [--server=127.0.0.1:8080] [-tag nat] [-rule ruleId]",
+	Short:       "List NAT sessions",
+	Long: `
+List the active sessions of NAT outbounds.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the sessions of this NAT outbound.
+
+	-rule <ruleId>
+		Only the sessions of this rule.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -rule web
+`,
+	Run: executeListSessions,
+}
+
+func executeListSessions(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rule := cmd.Flag.String("rule", "", "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.ListSessions(ctx, &natService.ListSessionsRequest{Tag: outboundTag, RuleId: *rule})
+	if err != nil {
+		base.Fatalf("failed to list sessions: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdExportSessions = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat sessions export [--server=127.0.0.1:8080] [-tag nat] [-rule ruleId] [-network tcp] [-minage 3600] [-format csv] [-baseline snapshot.json] [-o file]",
+	Short:       "Export NAT sessions",
+	Long: `
+Export a snapshot of the session table of NAT outbounds in JSON or CSV, to analyze it offline.
+With a baseline, a snapshot exported in JSON earlier, the sessions added and removed since and
+those that relayed traffic since are exported instead, kept sessions with the bytes relayed
+since the baseline.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the sessions of this NAT outbound.
+
+	-rule <ruleId>
+		Only the sessions of this rule.
+
+	-network <network>
+		Only the sessions of this network, tcp, udp or icmp.
+
+	-minage <seconds>
+		Only the sessions created at least this many seconds ago.
+
+	-format <format>
+		json or csv. Default json
+
+	-baseline <file>
+		Export the differences from this snapshot.
+
+	-o <file>
+		Write to this file instead of the standard output.
+
+Example:
+
+	{{.Exec}} {{.LongName}} -o before.json
+	{{.Exec}} {{.LongName}} -baseline before.json -format csv
+`,
+	Run: executeExportSessions,
+}
+
+func executeExportSessions(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rule := cmd.Flag.String("rule", "", "")
+	network := cmd.Flag.String("network", "", "")
+	minAge := cmd.Flag.Uint("minage", 0, "")
+	format := cmd.Flag.String("format", "json", "")
+	baselineFile := cmd.Flag.String("baseline", "", "")
+	output := cmd.Flag.String("o", "", "")
+	cmd.Flag.Parse(args)
+
+	var baseline []byte
+	if *baselineFile != "" {
+		var err error
+		if baseline, err = os.ReadFile(*baselineFile); err != nil {
+			base.Fatalf("failed to read baseline: %s", err)
+		}
+	}
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.ExportSessions(ctx, &natService.ExportSessionsRequest{
+		Tag:      outboundTag,
+		RuleId:   *rule,
+		Network:  *network,
+		MinAge:   uint32(*minAge),
+		Format:   *format,
+		Baseline: baseline,
+	})
+	if err != nil {
+		base.Fatalf("failed to export sessions: %s", err)
+	}
+	writeOutput(*output, resp.Data)
+}
+
+var cmdDiffSessions = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat sessions diff [-format csv] [-o file] <before.json> <after.json>",
+	Short:       "Compare NAT session snapshots",
+	Long: `
+Compare two snapshots exported in JSON by "nat sessions export", without an API server. The
+sessions added and removed between them and those that relayed traffic in between are listed,
+kept sessions with the bytes relayed in between.
+
+Arguments:
+
+	-format <format>
+		json or csv. Default json
+
+	-o <file>
+		Write to this file instead of the standard output.
+
+Example:
+
+	{{.Exec}} {{.LongName}} -format csv before.json after.json
+`,
+	Run: executeDiffSessions,
+}
+
+func executeDiffSessions(cmd *base.Command, args []string) {
+	format := cmd.Flag.String("format", "json", "")
+	output := cmd.Flag.String("o", "", "")
+	cmd.Flag.Parse(args)
+	if cmd.Flag.NArg() != 2 {
+		base.Fatalf("two snapshots are required")
+	}
+
+	var snapshots [2]*natService.SessionSnapshot
+	for i, file := range cmd.Flag.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			base.Fatalf("failed to read snapshot: %s", err)
+		}
+		if snapshots[i], err = natService.ParseSessionSnapshot(data); err != nil {
+			base.Fatalf("%s: %s", file, err)
+		}
+	}
+	data, err := snapshots[1].Diff(snapshots[0]).Encode(*format)
+	if err != nil {
+		base.Fatalf("failed to encode the diff: %s", err)
+	}
+	writeOutput(*output, data)
+}
+
+// writeOutput writes data to file, or to the standard output when file is empty
+func writeOutput(file string, data []byte) {
+	if file == "" {
+		os.Stdout.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			os.Stdout.Write([]byte("\n"))
+		}
+		return
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		base.Fatalf("failed to write %s: %s", file, err)
+	}
+}
+
+var cmdWatchSessions = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat sessions watch [--server=127.0.0.1:8080] [-tag nat] [-rule ruleId]",
+	Short:       "Watch NAT session events",
+	Long: `
+Print the create and teardown events of the sessions of NAT outbounds as they happen, until
+interrupted. Teardown events tell whether the session was closed, expired, evicted or flushed.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for connecting to the API. Default 3
+
+	-tag <tag>
+		Only the events of this NAT outbound.
+
+	-rule <ruleId>
+		Only the events of the sessions of this rule.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -rule web
+`,
+	Run: executeWatchSessions,
+}
+
+func executeWatchSessions(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rule := cmd.Flag.String("rule", "", "")
+	cmd.Flag.Parse(args)
+
+	conn, _, close := dialAPIServer()
+	defer close()
+
+	// The timeout only bounds connecting, the watch lasts until interrupted
+	client := natService.NewNATServiceClient(conn)
+	stream, err := client.WatchSessionEvents(context.Background(), &natService.WatchSessionEventsRequest{Tag: outboundTag, RuleId: *rule})
+	if err != nil {
+		base.Fatalf("failed to watch session events: %s", err)
+	}
+	for {
+		events, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			base.Fatalf("failed to watch session events: %s", err)
+		}
+		for _, event := range events.Events {
+			showJSONResponse(event)
+		}
+	}
+}
+
+var cmdFlushSessions = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat sessions flush [--server=127.0.0.1:8080] [-tag nat] [-rule ruleId] [-ruletag tag]",
+	Short:       "Flush NAT sessions",
+	Long: `
+Drop the active sessions of NAT outbounds and close the connections relaying them.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the sessions of this NAT outbound.
+
+	-rule <ruleId>
+		Only the sessions of this rule.
+
+	-ruletag <tag>
+		Only the sessions of the rules and virtual ranges carrying this tag.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag nat -rule web
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -ruletag backup-window
+`,
+	Run: executeFlushSessions,
+}
+
+func executeFlushSessions(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rule := cmd.Flag.String("rule", "", "")
+	ruleTag := cmd.Flag.String("ruletag", "", "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.FlushSessions(ctx, &natService.FlushSessionsRequest{Tag: outboundTag, RuleId: *rule, RuleTag: *ruleTag})
+	if err != nil {
+		base.Fatalf("failed to flush sessions: %s", err)
+	}
+	showJSONResponse(resp)
+}
+
+var cmdListSources = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat sessions sources [--server=127.0.0.1:8080] [-tag nat]",
+	Short:       "List NAT per-source usage",
+	Long: `
+List the sessions and new session tokens each virtual source address holds against the
+per-source limits of NAT outbounds, and how many of its flows were refused.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		Only the sources of this NAT outbound.
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag nat
+`,
+	Run: executeListSources,
+}
+
+func executeListSources(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.ListSourceUsage(ctx, &natService.ListSourceUsageRequest{Tag: outboundTag})
+	if err != nil {
+		base.Fatalf("failed to list source usage: %s", err)
+	}
+	showJSONResponse(resp)
+}
@@ -0,0 +1,98 @@
+package nat
+
+import (
+	"os"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/nat"
+	natService "github.com/xtls/xray-core/proxy/nat/command"
+)
+
+var cmdSimulate = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} nat simulate [--server=127.0.0.1:8080] [-tag nat] [-changed] -rules <rules.json> <sample.json>",
+	Short:       "Replay a traffic sample against candidate NAT rules",
+	Long: `
+Replay the flows of a traffic sample against candidate rules in place of the active rules,
+without creating sessions or changing the rules, to validate a change before applying it.
+Each flow is listed with the rule that would take it and its outcome: translated, bypassed
+by a bypass rule, blocked by a deny or reject rule, or unmatched, along with the other rules
+matching it differently and whether the active rules treat it differently. Each candidate
+rule is listed with the flows it takes and its conflicts with the rules before it. The
+command exits with status 1 when candidate rules conflict.
+
+The traffic sample is JSON: a list of flows such as
+[{"network": "tcp", "source": "10.0.0.5:40000", "destination": "240.2.2.20:443"}],
+a session snapshot of "nat sessions export", or packets of a capture dissected by
+"tshark -T json".
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag <tag>
+		The NAT outbound to simulate, required with several NAT outbounds.
+
+	-rules <file>
+		The candidate rules, in the format of a rules file (rulesFile).
+
+	-changed
+		Only list the flows the candidate rules treat differently.
+
+Example:
+
+	tshark -r capture.pcap -T json > sample.json
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -rules rules.new.json sample.json
+`,
+	Run: executeSimulate,
+}
+
+func executeSimulate(cmd *base.Command, args []string) {
+	setSharedFlags(cmd)
+	rulesFile := cmd.Flag.String("rules", "", "")
+	changed := cmd.Flag.Bool("changed", false, "")
+	cmd.Flag.Parse(args)
+	if *rulesFile == "" || cmd.Flag.NArg() != 1 {
+		base.Fatalf("candidate rules and a traffic sample are required")
+	}
+	rules, err := nat.LoadRulesFile(*rulesFile)
+	if err != nil {
+		base.Fatalf("failed to load the candidate rules: %s", err)
+	}
+	data, err := os.ReadFile(cmd.Flag.Arg(0))
+	if err != nil {
+		base.Fatalf("failed to read the traffic sample: %s", err)
+	}
+	flows, err := natService.ParseTrafficSample(data)
+	if err != nil {
+		base.Fatalf("failed to parse the traffic sample: %s", err)
+	}
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := natService.NewNATServiceClient(conn)
+	resp, err := client.Simulate(ctx, &natService.SimulateRequest{Tag: outboundTag, Rules: rules, Flows: flows})
+	if err != nil {
+		base.Fatalf("failed to simulate: %s", err)
+	}
+	if *changed {
+		kept := resp.Flows[:0]
+		for _, flow := range resp.Flows {
+			if flow.Changed {
+				kept = append(kept, flow)
+			}
+		}
+		resp.Flows = kept
+	}
+	showJSONResponse(resp)
+	for _, rule := range resp.Rules {
+		if len(rule.Conflicts) > 0 {
+			base.SetExitStatus(1)
+		}
+	}
+}
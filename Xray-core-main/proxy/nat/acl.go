@@ -0,0 +1,42 @@
+package nat
+
+import (
+	"context"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/transport"
+)
+
+// blockFlow ends a flow matched by a deny or reject rule, counted as a hit of the rule. Reject
+// closes the flow at once. Deny drops it like a firewall: what the client sends is discarded and
+// nothing is answered, until the client gives up or the flow idles out.
+func (h *Handler) blockFlow(ctx context.Context, link *transport.Link, destination xnet.Destination, rule *NATRule) error {
+	h.ruleMetricsOf(rule.RuleId, destination.Network).hit(time.Now())
+	defer common.Interrupt(link.Writer)
+
+	if rule.Action == actionReject {
+		common.Interrupt(link.Reader)
+		return errors.New("flow to ", destination, " rejected by NAT rule ", rule.RuleId)
+	}
+
+	errors.LogInfo(ctx, "NAT: flow to ", destination, " dropped by rule ", rule.RuleId)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := signal.CancelAfterInactivity(ctx, cancel, h.policy().Timeouts.ConnectionIdle)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		buf.Copy(link.Reader, buf.Discard, buf.UpdateActivity(timer))
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		common.Interrupt(link.Reader)
+	}
+	return nil
+}
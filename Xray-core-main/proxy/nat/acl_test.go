@@ -0,0 +1,107 @@
+package nat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestRuleActions(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+		},
+		Rules: []*NATRule{
+			{RuleId: "ssh", VirtualDestination: "240.2.2.0/24", Ports: "22", Action: "deny"},
+			{RuleId: "smtp", VirtualDestination: "240.2.2.0/24", Ports: "25", Action: "reject"},
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Action: "allow"},
+			{RuleId: "quarantine", VirtualDestination: "240.2.2.99", Action: "reject"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	start := func(destination xnet.Destination) (context.Context, *pipe.Writer, *pipe.Reader, chan error) {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)})
+		ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: destination}})
+		uplinkReader, uplinkWriter := pipe.New()
+		downlinkReader, downlinkWriter := pipe.New()
+		done := make(chan error, 1)
+		go func() {
+			done <- handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, externalDialer{})
+		}()
+		return ctx, uplinkWriter, downlinkReader, done
+	}
+
+	// Reject closes the flow at once
+	_, _, downlinkReader, done := start(xnet.TCPDestination(xnet.ParseAddress("240.2.2.30"), 25))
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the flow to be rejected")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the rejected flow to end at once")
+	}
+	if _, err := downlinkReader.ReadMultiBuffer(); err == nil {
+		t.Error("Expected the downlink of a rejected flow to be closed")
+	}
+
+	// Deny swallows the flow without answering until the client gives up
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 22)
+	ctx, uplinkWriter, downlinkReader, done := start(destination)
+	if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{buf.New()}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the denied flow to be held, it ended with %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	uplinkWriter.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the denied flow to end quietly, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the denied flow to end once the client closed it")
+	}
+	if mb, err := downlinkReader.ReadMultiBuffer(); err == nil || !mb.IsEmpty() {
+		t.Error("Expected nothing to be answered on a denied flow")
+	}
+
+	// Blocking rules take precedence over the range covering the destination and count as hits
+	if rule, ok := handler.shouldApplyNAT(ctx, destination); !ok || rule.RuleId != "ssh" {
+		t.Errorf("Expected the deny rule to match, got %v", rule)
+	}
+	if translation, err := handler.TestTranslation(xnet.Destination{}, destination); err != nil || translation.Rule.GetRuleId() != "ssh" || translation.RealDestination.Address != nil {
+		t.Errorf("Unexpected translation of a denied flow %+v, %v", translation, err)
+	}
+	stats := handler.RuleStats()
+	if len(stats) != 4 || stats[0].Hits != 1 || stats[1].Hits != 1 {
+		t.Errorf("Expected a hit of each blocking rule, got %+v", stats)
+	}
+
+	// Echo queries are blocked too
+	echoSource := xnet.Destination{Address: xnet.ParseAddress("10.0.0.5"), Port: 7}
+	if _, err := handler.icmpSession(context.Background(), echoSource, xnet.Destination{Address: xnet.ParseAddress("240.2.2.99")}); err == nil {
+		t.Error("Expected the echo query to be blocked")
+	}
+	if _, err := handler.icmpSession(context.Background(), echoSource, xnet.Destination{Address: xnet.ParseAddress("240.2.2.30")}); err != nil {
+		t.Errorf("Expected an echo query not to match the port-restricted rules, got %v", err)
+	}
+
+	if err := ValidateRuleAction("drop"); err == nil {
+		t.Error("Expected an unknown action to be rejected")
+	}
+}
@@ -0,0 +1,357 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+const (
+	// maxAddressPoolSize bounds the addresses of a pool, so large IPv6 networks stay cheap to scan
+	maxAddressPoolSize = 1 << 16
+	// defaultLeaseTime is how long a lease lasts unless the pool or the request sets it
+	defaultLeaseTime = time.Hour
+)
+
+// AddressLease is a virtual address of the address pool held for a real host. Expires is zero
+// for leases that never expire, which only come from the lease file.
+type AddressLease struct {
+	Virtual net.IP
+	Real    net.IP
+	Expires time.Time
+}
+
+type addressLease struct {
+	AddressLease
+	rule *NATRule // Translates flows to the virtual address, kept for the whole lease
+}
+
+// addressPool leases the virtual addresses of a network to real hosts on demand, so new hosts
+// are reachable without editing rules. A lease ends when it is released or not renewed in time;
+// sessions created while it was held keep their translation.
+type addressPool struct {
+	sync.Mutex
+	network   *net.IPNet
+	leaseTime time.Duration
+	first     uint64 // Offsets of the first and last address leased out
+	last      uint64
+	cursor    uint64 // Next offset to try, so released addresses are not reused immediately
+	byVirtual map[string]*addressLease
+	byReal    map[string]*addressLease
+
+	file         *fileCheckpoint // Lease file, nil unless configured
+	exportAccess sync.Mutex      // Serializes exports, so the last one holds the latest leases
+}
+
+func newAddressPool(config *AddressPool) (*addressPool, error) {
+	_, network, err := net.ParseCIDR(config.Network)
+	if err != nil {
+		return nil, errors.New("invalid address pool network ", config.Network).Base(err)
+	}
+	ones, bits := network.Mask.Size()
+	size := uint64(maxAddressPoolSize)
+	if bits-ones < 16 {
+		size = 1 << (bits - ones)
+	}
+	p := &addressPool{
+		network:   network,
+		leaseTime: time.Duration(config.LeaseTime) * time.Second,
+		last:      size - 1,
+		byVirtual: make(map[string]*addressLease),
+		byReal:    make(map[string]*addressLease),
+	}
+	if p.leaseTime == 0 {
+		p.leaseTime = defaultLeaseTime
+	}
+	// Skip the network address, and the broadcast address of IPv4 networks
+	if size >= 4 {
+		p.first = 1
+		if bits == 32 {
+			p.last = size - 2
+		}
+	}
+	p.cursor = p.first
+	if config.LeaseFile != "" {
+		p.file = &fileCheckpoint{path: config.LeaseFile}
+	}
+	return p, nil
+}
+
+// address returns the address at an offset into the network
+func (p *addressPool) address(offset uint64) net.IP {
+	ip := make(net.IP, len(p.network.IP))
+	copy(ip, p.network.IP)
+	tail := ip
+	if len(ip) == net.IPv6len {
+		tail = ip[8:]
+	}
+	var value uint64
+	for _, b := range tail {
+		value = value<<8 | uint64(b)
+	}
+	value += offset
+	for i := len(tail) - 1; i >= 0; i-- {
+		tail[i] = byte(value)
+		value >>= 8
+	}
+	return ip
+}
+
+// offset returns the offset of an address into the network, if it is one leased out
+func (p *addressPool) offset(ip net.IP) (uint64, bool) {
+	if ip = normalizeIP(ip, p.network.Mask); ip == nil || !p.network.Contains(ip) {
+		return 0, false
+	}
+	var offset uint64
+	if len(ip) == net.IPv4len {
+		offset = uint64(binary.BigEndian.Uint32(ip) - binary.BigEndian.Uint32(p.network.IP))
+	} else {
+		if !bytes.Equal(ip[:8], p.network.IP[:8]) {
+			return 0, false
+		}
+		offset = binary.BigEndian.Uint64(ip[8:]) - binary.BigEndian.Uint64(p.network.IP[8:])
+	}
+	return offset, offset >= p.first && offset <= p.last
+}
+
+func (p *addressPool) ttl(requested time.Duration) time.Duration {
+	if requested > 0 {
+		return requested
+	}
+	return p.leaseTime
+}
+
+// Allocate leases a virtual address to a real host: the requested one, or the next free one when
+// virtual is nil. A host that already holds a lease has it renewed.
+func (p *addressPool) Allocate(real, virtual net.IP, ttl time.Duration, now time.Time) (AddressLease, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if lease, found := p.byReal[real.String()]; found {
+		if virtual != nil && !virtual.Equal(lease.Virtual) {
+			return AddressLease{}, errors.New(real, " already holds ", lease.Virtual)
+		}
+		p.renew(lease, ttl, now)
+		return lease.AddressLease, nil
+	}
+	return p.lease(real, virtual, now.Add(p.ttl(ttl)))
+}
+
+// restore adds a lease of the lease file
+func (p *addressPool) restore(lease AddressLease) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if held, found := p.byReal[lease.Real.String()]; found {
+		return errors.New(lease.Real, " already holds ", held.Virtual)
+	}
+	_, err := p.lease(lease.Real, lease.Virtual, lease.Expires)
+	return err
+}
+
+// lease leases a virtual address, the next free one when virtual is nil, to a real host that
+// holds none. The lease never expires when expires is zero.
+func (p *addressPool) lease(real, virtual net.IP, expires time.Time) (AddressLease, error) {
+	if real == nil {
+		return AddressLease{}, errors.New("no real address to lease a virtual address to")
+	}
+	if (real.To4() == nil) != (len(p.network.IP) == net.IPv6len) {
+		return AddressLease{}, errors.New("real address ", real, " is not of the family of the pool ", p.network)
+	}
+
+	if virtual != nil {
+		if _, ok := p.offset(virtual); !ok {
+			return AddressLease{}, errors.New(virtual, " is not an address of the pool ", p.network)
+		}
+		virtual = normalizeIP(virtual, p.network.Mask)
+		if _, found := p.byVirtual[virtual.String()]; found {
+			return AddressLease{}, errors.New(virtual, " is already leased")
+		}
+	} else {
+		size := p.last - p.first + 1
+		for i := uint64(0); i < size; i++ {
+			offset := p.first + (p.cursor-p.first+i)%size
+			if candidate := p.address(offset); p.byVirtual[candidate.String()] == nil {
+				virtual = candidate
+				p.cursor = offset + 1
+				break
+			}
+		}
+		if virtual == nil {
+			return AddressLease{}, errors.New("address pool ", p.network, " is exhausted")
+		}
+	}
+
+	lease := &addressLease{
+		AddressLease: AddressLease{Virtual: virtual, Real: real, Expires: expires},
+		rule: &NATRule{
+			RuleId:             "lease-" + virtual.String(),
+			VirtualDestination: virtual.String(),
+			RealDestination:    real.String(),
+			Protocol:           "tcp,udp",
+		},
+	}
+	p.byVirtual[virtual.String()] = lease
+	p.byReal[real.String()] = lease
+	return lease.AddressLease, nil
+}
+
+// renew extends a lease; leases that never expire are left as they are
+func (p *addressPool) renew(lease *addressLease, ttl time.Duration, now time.Time) {
+	if !lease.Expires.IsZero() {
+		lease.Expires = now.Add(p.ttl(ttl))
+	}
+}
+
+// Renew extends the lease of a virtual address
+func (p *addressPool) Renew(virtual net.IP, ttl time.Duration, now time.Time) (AddressLease, error) {
+	p.Lock()
+	defer p.Unlock()
+	lease, found := p.byVirtual[virtual.String()]
+	if !found {
+		return AddressLease{}, errors.New(virtual, " is not leased")
+	}
+	p.renew(lease, ttl, now)
+	return lease.AddressLease, nil
+}
+
+// Release ends the lease of a virtual address
+func (p *addressPool) Release(virtual net.IP) (AddressLease, error) {
+	p.Lock()
+	defer p.Unlock()
+	lease, found := p.byVirtual[virtual.String()]
+	if !found {
+		return AddressLease{}, errors.New(virtual, " is not leased")
+	}
+	p.remove(lease)
+	return lease.AddressLease, nil
+}
+
+func (p *addressPool) remove(lease *addressLease) {
+	delete(p.byVirtual, lease.Virtual.String())
+	delete(p.byReal, lease.Real.String())
+}
+
+// expire ends the leases that were not renewed in time and returns them
+func (p *addressPool) expire(now time.Time) []AddressLease {
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	var expired []AddressLease
+	for _, lease := range p.byVirtual {
+		if !lease.Expires.IsZero() && !now.Before(lease.Expires) {
+			p.remove(lease)
+			expired = append(expired, lease.AddressLease)
+		}
+	}
+	return expired
+}
+
+// Leases returns the leases held, ordered by virtual address
+func (p *addressPool) Leases() []AddressLease {
+	p.Lock()
+	defer p.Unlock()
+	leases := make([]AddressLease, 0, len(p.byVirtual))
+	for _, lease := range p.byVirtual {
+		leases = append(leases, lease.AddressLease)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return bytes.Compare(leases[i].Virtual, leases[j].Virtual) < 0
+	})
+	return leases
+}
+
+// rule returns the rule translating flows to a leased virtual address
+func (p *addressPool) rule(destination xnet.Destination) (*NATRule, bool) {
+	if p == nil || !destination.Address.Family().IsIP() {
+		return nil, false
+	}
+	ip := normalizeIP(destination.Address.IP(), p.network.Mask)
+	p.Lock()
+	defer p.Unlock()
+	if lease, found := p.byVirtual[ip.String()]; found {
+		return lease.rule, true
+	}
+	return nil, false
+}
+
+// virtualAddressOf returns the virtual address leased to a real address
+func (p *addressPool) virtualAddressOf(ip net.IP) (net.IP, bool) {
+	if p == nil {
+		return nil, false
+	}
+	p.Lock()
+	defer p.Unlock()
+	if lease, found := p.byReal[ip.String()]; found {
+		return lease.Virtual, true
+	}
+	return nil, false
+}
+
+// AllocateAddress leases a virtual address of the address pool to a real host: the requested one,
+// or the next free one when virtual is nil. The lease lasts ttl, or the lease time of the pool
+// when ttl is 0.
+func (h *Handler) AllocateAddress(real, virtual net.IP, ttl time.Duration) (AddressLease, error) {
+	if h.addresses == nil {
+		return AddressLease{}, errors.New("no address pool is configured")
+	}
+	lease, err := h.addresses.Allocate(real, virtual, ttl, time.Now())
+	if err == nil {
+		errors.LogInfo(context.Background(), "NAT: leased ", lease.Virtual, " to ", lease.Real)
+		h.exportLeases()
+	}
+	return lease, err
+}
+
+// RenewLease extends the lease of a virtual address by ttl, or the lease time of the pool when ttl is 0
+func (h *Handler) RenewLease(virtual net.IP, ttl time.Duration) (AddressLease, error) {
+	if h.addresses == nil {
+		return AddressLease{}, errors.New("no address pool is configured")
+	}
+	lease, err := h.addresses.Renew(virtual, ttl, time.Now())
+	if err == nil {
+		h.exportLeases()
+	}
+	return lease, err
+}
+
+// ReleaseAddress ends the lease of a virtual address
+func (h *Handler) ReleaseAddress(virtual net.IP) error {
+	if h.addresses == nil {
+		return errors.New("no address pool is configured")
+	}
+	lease, err := h.addresses.Release(virtual)
+	if err == nil {
+		errors.LogInfo(context.Background(), "NAT: released ", lease.Virtual, " of ", lease.Real)
+		h.exportLeases()
+	}
+	return err
+}
+
+// AddressLeases returns the leases of the address pool
+func (h *Handler) AddressLeases() []AddressLease {
+	if h.addresses == nil {
+		return nil
+	}
+	return h.addresses.Leases()
+}
+
+// expireLeases ends the leases of the address pool that were not renewed in time
+func (h *Handler) expireLeases() {
+	expired := h.addresses.expire(time.Now())
+	for _, lease := range expired {
+		errors.LogInfo(context.Background(), "NAT: lease of ", lease.Virtual, " to ", lease.Real, " expired")
+	}
+	if len(expired) > 0 {
+		h.exportLeases()
+	}
+}
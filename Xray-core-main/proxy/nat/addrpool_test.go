@@ -0,0 +1,93 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestAddressPool(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:      "test-site",
+		AddressPool: &AddressPool{Network: "240.5.5.0/30", LeaseTime: 60},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Addresses are handed out in order, skipping the network address
+	first, err := handler.AllocateAddress(net.ParseIP("192.168.1.20"), nil, 0)
+	if err != nil || first.Virtual.String() != "240.5.5.1" {
+		t.Fatalf("Expected 240.5.5.1 to be leased, got %v, %v", first.Virtual, err)
+	}
+	if remaining := time.Until(first.Expires); remaining < 59*time.Second || remaining > time.Minute {
+		t.Errorf("Expected the lease time of the pool, got %v", remaining)
+	}
+	again, err := handler.AllocateAddress(net.ParseIP("192.168.1.20"), nil, time.Hour)
+	if err != nil || !again.Virtual.Equal(first.Virtual) || !again.Expires.After(first.Expires) {
+		t.Errorf("Expected the lease of the host to be renewed, got %v, %v", again, err)
+	}
+	second, err := handler.AllocateAddress(net.ParseIP("192.168.1.21"), nil, 0)
+	if err != nil || second.Virtual.String() != "240.5.5.2" {
+		t.Fatalf("Expected 240.5.5.2 to be leased, got %v, %v", second.Virtual, err)
+	}
+	if _, err := handler.AllocateAddress(net.ParseIP("192.168.1.22"), nil, 0); err == nil {
+		t.Error("Expected error for an exhausted pool")
+	}
+	if _, err := handler.AllocateAddress(net.ParseIP("2001:db8::1"), nil, 0); err == nil {
+		t.Error("Expected error for a real address of another family")
+	}
+
+	// Leased addresses translate to their host, and back
+	rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress("240.5.5.2"), 80))
+	if !ok || rule.RealDestination != "192.168.1.21" {
+		t.Errorf("Expected the leased address to translate to its host, got %v", rule)
+	}
+	if virtual, ok := handler.virtualAddressOf(net.ParseIP("192.168.1.21")); !ok || virtual.String() != "240.5.5.2" {
+		t.Errorf("Expected the virtual address of the host, got %v", virtual)
+	}
+
+	if err := handler.ReleaseAddress(second.Virtual); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress("240.5.5.2"), 80)); ok {
+		t.Error("Expected a released address to no longer translate")
+	}
+	if err := handler.ReleaseAddress(second.Virtual); err == nil {
+		t.Error("Expected error for an address that is not leased")
+	}
+	if lease, err := handler.AllocateAddress(net.ParseIP("192.168.1.22"), net.ParseIP("240.5.5.2"), 0); err != nil || lease.Virtual.String() != "240.5.5.2" {
+		t.Errorf("Expected the requested address to be leased, got %v, %v", lease.Virtual, err)
+	}
+	if _, err := handler.AllocateAddress(net.ParseIP("192.168.1.23"), net.ParseIP("240.5.5.3"), 0); err == nil {
+		t.Error("Expected error for the broadcast address")
+	}
+}
+
+func TestAddressPoolExpiry(t *testing.T) {
+	pool, err := newAddressPool(&AddressPool{Network: "fd00:5::/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	lease, err := pool.Allocate(net.ParseIP("2001:db8::20"), nil, 0, now)
+	if err != nil || lease.Virtual.String() != "fd00:5::1" {
+		t.Fatalf("Expected fd00:5::1 to be leased, got %v, %v", lease.Virtual, err)
+	}
+	if _, err := pool.Allocate(net.ParseIP("2001:db8::21"), net.ParseIP("fd00:5::1:0"), 0, now); err == nil {
+		t.Error("Expected error for an address beyond the size of the pool")
+	}
+	if _, err := pool.Renew(lease.Virtual, 2*time.Hour, now); err != nil {
+		t.Fatal(err)
+	}
+	if expired := pool.expire(now.Add(time.Hour)); len(expired) != 0 {
+		t.Errorf("Expected the renewed lease to be kept, got %v", expired)
+	}
+	if expired := pool.expire(now.Add(2 * time.Hour)); len(expired) != 1 || len(pool.Leases()) != 0 {
+		t.Errorf("Expected the lease to expire, got %v", expired)
+	}
+}
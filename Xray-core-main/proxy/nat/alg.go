@@ -0,0 +1,451 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// Built-in application level gateways
+const (
+	algFTP   = "ftp"
+	algSIP   = "sip"
+	algDNS   = "dns"
+	algDNS64 = "dns64"
+	algTFTP  = "tftp"
+	algRTSP  = "rtsp"
+)
+
+const (
+	// maxALGLine bounds the line an ALG buffers; longer lines pass through unchanged
+	maxALGLine = 4096
+	// maxALGMessage bounds the message an ALG buffers on a stream; larger ones pass through unchanged
+	maxALGMessage = 64 * 1024
+)
+
+// ALG is an application level gateway rewriting the addresses an application protocol carries
+// in its messages. Rules enable gateways by the name they are registered with. One value serves
+// all flows; the state of a flow is kept in ALGFlow.State. The two directions of a flow are
+// rewritten concurrently.
+type ALG interface {
+	// Match reports whether the gateway handles the flow, such as by its network, and may set
+	// the state of the flow
+	Match(flow *ALGFlow) bool
+	// RewriteOutbound rewrites a message of the client towards the real destination
+	RewriteOutbound(flow *ALGFlow, message []byte) []byte
+	// RewriteInbound rewrites a message of the real destination towards the client
+	RewriteInbound(flow *ALGFlow, message []byte) []byte
+	// ExpectSessions returns the related flows a message announces, which are translated when
+	// the client opens them. It sees each message before it is rewritten.
+	ExpectSessions(flow *ALGFlow, message []byte, outbound bool) []ALGExpectation
+}
+
+// ALGFramer is implemented by gateways delimiting the messages of streams themselves. Frame
+// returns the length of the message at the start of data, or -1 while it is incomplete. The
+// messages of gateways without it are lines.
+type ALGFramer interface {
+	Frame(data []byte) int
+}
+
+// ALGConnWrapper is implemented by gateways following the datagrams of a flow on the connection
+// towards the real destination rather than rewriting messages
+type ALGConnWrapper interface {
+	WrapConn(flow *ALGFlow, conn stat.Connection) stat.Connection
+}
+
+// ALGFlow is a translated flow a gateway handles
+type ALGFlow struct {
+	Context context.Context
+	Rule    *NATRule
+	Session *NATSession
+	Conn    stat.Connection // Connection towards the real destination
+	State   any             // Set by the gateway
+
+	handler *Handler
+}
+
+// Stream reports whether the messages of the flow are carried on a stream
+func (f *ALGFlow) Stream() bool {
+	return f.Session.RealDest.Network == xnet.Network_TCP
+}
+
+// LocalIP returns the address of the flow towards the real destination
+func (f *ALGFlow) LocalIP() net.IP {
+	return localAddressTowards(f.Conn, f.Session.RealDest.Address)
+}
+
+// OpenPinholePair opens adjacent RTP/RTCP pinholes on localIP relaying the datagrams of the
+// first peer sending to them to target and the port after it
+func (f *ALGFlow) OpenPinholePair(localIP net.IP, target xnet.Destination) (*net.UDPAddr, error) {
+	return f.handler.openPinholePair(f.Context, localIP, target, f.Session.RuleID)
+}
+
+// ALGExpectation is a related flow to virtualDest a gateway saw announced, translated to RealDest
+type ALGExpectation struct {
+	VirtualDest xnet.Destination
+	RealDest    xnet.Destination
+}
+
+var (
+	algsAccess sync.RWMutex
+	algs       = make(map[string]ALG)
+)
+
+// RegisterALG registers a gateway rules enable by name. Names are case insensitive and cannot
+// be registered twice.
+func RegisterALG(name string, alg ALG) error {
+	name = strings.ToLower(name)
+	algsAccess.Lock()
+	defer algsAccess.Unlock()
+	if _, found := algs[name]; found {
+		return errors.New("ALG ", name, " already registered")
+	}
+	algs[name] = alg
+	return nil
+}
+
+func getALG(name string) (ALG, bool) {
+	algsAccess.RLock()
+	defer algsAccess.RUnlock()
+	alg, found := algs[strings.ToLower(name)]
+	return alg, found
+}
+
+// ValidateALGs checks the application level gateways enabled on a rule
+func ValidateALGs(names []string) error {
+	for _, name := range names {
+		if _, found := getALG(name); !found {
+			return errors.New(name, " is not a registered ALG")
+		}
+	}
+	return nil
+}
+
+func hasALG(rule *NATRule, name string) bool {
+	for _, alg := range rule.Alg {
+		if strings.EqualFold(alg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapALG puts the gateways of the rule, in the order of the rule, around conn, the connection
+// towards the real destination, and between the two directions of the flow. It returns the
+// connection to write the uplink to and the uplink and downlink readers.
+func (h *Handler) wrapALG(ctx context.Context, rule *NATRule, natSession *NATSession, conn stat.Connection, uplink buf.Reader) (stat.Connection, buf.Reader, buf.Reader) {
+	var flows []*ALGFlow
+	var gateways []ALG
+	seen := make(map[string]bool)
+	for _, name := range rule.Alg {
+		alg, found := getALG(name)
+		if !found || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		flow := &ALGFlow{Context: ctx, Rule: rule, Session: natSession, Conn: conn, handler: h}
+		if !alg.Match(flow) {
+			continue
+		}
+		if wrapper, ok := alg.(ALGConnWrapper); ok {
+			conn = wrapper.WrapConn(flow, conn)
+		}
+		h.chargeSession(natSession, int64(unsafe.Sizeof(*flow)))
+		flows = append(flows, flow)
+		gateways = append(gateways, alg)
+	}
+
+	downlink := buf.NewReader(conn)
+	for i, alg := range gateways {
+		if _, ok := alg.(ALGConnWrapper); ok {
+			continue
+		}
+		uplink = h.algReader(flows[i], alg, uplink, true)
+		downlink = h.algReader(flows[i], alg, downlink, false)
+	}
+	return conn, uplink, downlink
+}
+
+// algReader rewrites the messages of one direction of a flow with alg
+func (h *Handler) algReader(flow *ALGFlow, alg ALG, reader buf.Reader, outbound bool) buf.Reader {
+	rewrite := func(message []byte) []byte {
+		for _, expectation := range alg.ExpectSessions(flow, message, outbound) {
+			h.expect(flow.Session, expectation.VirtualDest, expectation.RealDest)
+		}
+		if outbound {
+			return alg.RewriteOutbound(flow, message)
+		}
+		return alg.RewriteInbound(flow, message)
+	}
+	held := heldData{account: func(delta int64) {
+		h.chargeSession(flow.Session, delta)
+	}}
+	if framer, ok := alg.(ALGFramer); ok || !flow.Stream() {
+		var frame func([]byte) int
+		if ok {
+			frame = framer.Frame
+		}
+		r := newMessageReader(reader, flow.Stream(), frame, rewrite)
+		r.held = held
+		r.held.account(int64(unsafe.Sizeof(*r)))
+		return r
+	}
+	r := &lineReader{reader: reader, rewrite: rewrite, held: held}
+	r.held.account(int64(unsafe.Sizeof(*r)))
+	return r
+}
+
+// heldData charges the data a reader holds back to the session of its flow as it grows and
+// shrinks
+type heldData struct {
+	account func(delta int64) // Nil when not accounted
+	charged int64
+}
+
+// update charges the capacity of pending
+func (d *heldData) update(pending []byte) {
+	if d.account == nil {
+		return
+	}
+	if size := int64(cap(pending)); size != d.charged {
+		d.account(size - d.charged)
+		d.charged = size
+	}
+}
+
+// lineReader rewrites a line based stream, such as an FTP control connection, line by line.
+// Incomplete lines are held back until their end arrives.
+type lineReader struct {
+	reader  buf.Reader
+	rewrite func(line []byte) []byte
+	pending []byte
+	held    heldData
+}
+
+// ReadMultiBuffer implements buf.Reader
+func (r *lineReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	mb, err := r.reader.ReadMultiBuffer()
+	if !mb.IsEmpty() {
+		data := make([]byte, mb.Len())
+		mb.Copy(data)
+		buf.ReleaseMulti(mb)
+		r.pending = append(r.pending, data...)
+	}
+
+	var out []byte
+	for {
+		i := bytes.IndexByte(r.pending, '\n')
+		if i < 0 {
+			break
+		}
+		out = append(out, r.rewrite(r.pending[:i+1])...)
+		r.pending = r.pending[i+1:]
+	}
+	if err != nil || len(r.pending) > maxALGLine {
+		out = append(out, r.pending...)
+		r.pending = nil
+	}
+	if len(r.pending) == 0 {
+		r.pending = nil
+	}
+	r.held.update(r.pending)
+	return buf.MergeBytes(nil, out), err
+}
+
+// messageReader rewrites the messages of a flow. Each datagram carries one message; on streams
+// messages are delimited by frame, which returns the length of the message at the start of
+// the data or -1 while it is incomplete.
+type messageReader struct {
+	reader  buf.Reader
+	frame   func(data []byte) int // nil for datagrams
+	rewrite func(message []byte) []byte
+	pending []byte
+	held    heldData
+}
+
+func newMessageReader(reader buf.Reader, stream bool, frame func([]byte) int, rewrite func([]byte) []byte) *messageReader {
+	r := &messageReader{reader: reader, rewrite: rewrite}
+	if stream {
+		r.frame = frame
+	}
+	return r
+}
+
+// ReadMultiBuffer implements buf.Reader
+func (r *messageReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	mb, err := r.reader.ReadMultiBuffer()
+	if r.frame == nil {
+		for i, b := range mb {
+			rewritten := r.rewrite(b.Bytes())
+			if len(rewritten) > buf.Size {
+				continue
+			}
+			replacement := buf.New()
+			replacement.Write(rewritten)
+			replacement.UDP = b.UDP
+			b.Release()
+			mb[i] = replacement
+		}
+		return mb, err
+	}
+
+	if !mb.IsEmpty() {
+		data := make([]byte, mb.Len())
+		mb.Copy(data)
+		buf.ReleaseMulti(mb)
+		r.pending = append(r.pending, data...)
+	}
+	var out []byte
+	for len(r.pending) > 0 {
+		length := r.frame(r.pending)
+		if length <= 0 || length > len(r.pending) {
+			break
+		}
+		out = append(out, r.rewrite(r.pending[:length])...)
+		r.pending = r.pending[length:]
+	}
+	if err != nil || len(r.pending) > maxALGMessage {
+		out = append(out, r.pending...)
+		r.pending = nil
+	}
+	r.held.update(r.pending)
+	return buf.MergeBytes(nil, out), err
+}
+
+// expect holds a data connection an ALG saw announced on a control connection, so the flow to
+// virtualDest is translated to realDest when it opens. It is held as a session with the
+// "expected" direction, which expires with the timeout of its protocol; for TCP the
+// transitory timeout.
+func (h *Handler) expect(control *NATSession, virtualDest, realDest xnet.Destination) {
+	source := xnet.Destination{Network: virtualDest.Network, Address: control.VirtualSource.Address}
+	expected := h.createNATSession(source, virtualDest, realDest, "expected", control.class)
+	expected.RuleID = control.RuleID
+	if previous, loaded := h.expectations.Swap(virtualDest.String(), expected); loaded {
+		h.removeSession(previous.(*NATSession).SessionID)
+	}
+	errors.LogDebug(context.Background(), "NAT: expecting ", virtualDest, " -> ", realDest, " for rule ", control.RuleID)
+}
+
+// claimExpectation returns the rule translating an expected data connection to destination and
+// removes the expectation. Only the source of the control connection may open it.
+func (h *Handler) claimExpectation(ctx context.Context, destination xnet.Destination) (*NATRule, bool) {
+	value, found := h.expectations.Load(destination.String())
+	if !found {
+		return nil, false
+	}
+	expected := value.(*NATSession)
+	if allowed := expected.VirtualSource.Address; allowed != nil {
+		inbound := session.InboundFromContext(ctx)
+		if inbound == nil || inbound.Source.Address == nil || inbound.Source.Address.String() != allowed.String() {
+			return nil, false
+		}
+	}
+	if !h.expectations.CompareAndDelete(destination.String(), expected) {
+		return nil, false
+	}
+	h.removeSession(expected.SessionID)
+
+	rule := &NATRule{
+		RuleId:             expected.RuleID,
+		VirtualDestination: destination.Address.String(),
+		RealDestination:    addressString(expected.RealDest.Address),
+	}
+	if expected.RealDest.Port != destination.Port {
+		rule.PortMapping = &PortMapping{
+			OriginalPort:   strconv.Itoa(int(destination.Port)),
+			TranslatedPort: strconv.Itoa(int(expected.RealDest.Port)),
+		}
+	}
+	return rule, true
+}
+
+// expectationTimeout is how long an announced data connection is waited for, the transitory
+// TCP timeout
+func (h *Handler) expectationTimeout() time.Duration {
+	return h.sessionTimeout(&NATSession{Protocol: "tcp"})
+}
+
+// forgetExpectation drops the expectation of a removed expected session
+func (h *Handler) forgetExpectation(natSession *NATSession) {
+	if natSession.Direction == "expected" {
+		h.expectations.CompareAndDelete(natSession.VirtualDest.String(), natSession)
+	}
+}
+
+// addressString formats an address as rules give it, with IPv6 addresses unbracketed
+func addressString(address xnet.Address) string {
+	if address.Family().IsIP() {
+		return address.IP().String()
+	}
+	return address.String()
+}
+
+// localAddressTowards returns the local address of conn, or the address the system would use
+// towards remote when conn is bound to the unspecified address, as unconnected UDP sockets are
+func localAddressTowards(conn net.Conn, remote xnet.Address) net.IP {
+	var local net.IP
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		local = addr.IP
+	case *net.UDPAddr:
+		local = addr.IP
+	}
+	if (local == nil || local.IsUnspecified()) && remote.Family().IsIP() {
+		// Connecting a UDP socket sends nothing but selects the route
+		if probe, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: remote.IP(), Port: 9}); err == nil {
+			local = probe.LocalAddr().(*net.UDPAddr).IP
+			probe.Close()
+		}
+	}
+	return local
+}
+
+// openPinhole opens a UDP port on localIP relaying the datagrams of the first peer sending to
+// it to target, and the replies of target back to the peer. port 0 picks a free port. The
+// pinhole is tracked as an inbound session and closes after the UDP timeout without traffic.
+func (h *Handler) openPinhole(ctx context.Context, localIP net.IP, port int, target xnet.Destination, ruleID string) (*net.UDPAddr, error) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	go h.relayInboundUDP(ctx, listener, target, ruleID, true)
+	return listener.LocalAddr().(*net.UDPAddr), nil
+}
+
+// openPinholePair opens pinholes on two adjacent ports, for RTP and RTCP, relaying to target
+// and the port after it
+func (h *Handler) openPinholePair(ctx context.Context, localIP net.IP, target xnet.Destination, ruleID string) (*net.UDPAddr, error) {
+	for tries := 0; tries < 16; tries++ {
+		probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+		if err != nil {
+			return nil, err
+		}
+		port := probe.LocalAddr().(*net.UDPAddr).Port &^ 1 // RTP takes the even port
+		probe.Close()
+		if port == 0 || port+1 > 65535 {
+			continue
+		}
+		rtp, err := h.openPinhole(ctx, localIP, port, target, ruleID)
+		if err != nil {
+			continue
+		}
+		rtcpTarget := xnet.UDPDestination(target.Address, target.Port+1)
+		if _, err := h.openPinhole(ctx, localIP, port+1, rtcpTarget, ruleID); err != nil {
+			// The RTP pinhole expires unused
+			continue
+		}
+		return rtp, nil
+	}
+	return nil, errors.New("no adjacent free ports for RTP and RTCP")
+}
@@ -0,0 +1,98 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// upperGateway upper-cases the lines of the client and expects the port a line of the server names
+type upperGateway struct{}
+
+func (upperGateway) Match(flow *ALGFlow) bool {
+	return flow.Stream()
+}
+
+func (upperGateway) RewriteOutbound(flow *ALGFlow, line []byte) []byte {
+	return []byte(strings.ToUpper(string(line)))
+}
+
+func (upperGateway) RewriteInbound(flow *ALGFlow, line []byte) []byte {
+	return line
+}
+
+func (upperGateway) ExpectSessions(flow *ALGFlow, line []byte, outbound bool) []ALGExpectation {
+	port, err := xnet.PortFromString(strings.TrimSpace(string(line)))
+	if outbound || err != nil {
+		return nil
+	}
+	return []ALGExpectation{{
+		VirtualDest: xnet.TCPDestination(flow.Session.VirtualDest.Address, port),
+		RealDest:    xnet.TCPDestination(flow.Session.RealDest.Address, port),
+	}}
+}
+
+func init() {
+	common.Must(RegisterALG("Test-Upper", upperGateway{}))
+}
+
+func TestRegisterALG(t *testing.T) {
+	if err := RegisterALG("test-upper", upperGateway{}); err == nil {
+		t.Error("Expected a name to be registered only once")
+	}
+	if err := RegisterALG(algFTP, upperGateway{}); err == nil {
+		t.Error("Expected built-in gateways to stay registered")
+	}
+	if err := ValidateALGs([]string{"ftp", "TEST-UPPER"}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateALGs([]string{"gopher"}); err == nil {
+		t.Error("Expected an unregistered gateway to be rejected")
+	}
+
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	natSession := handler.createNATSession(
+		xnet.TCPDestination(xnet.ParseAddress("127.0.0.1"), 40000),
+		xnet.TCPDestination(xnet.ParseAddress("240.2.2.25"), 7000),
+		xnet.TCPDestination(xnet.ParseAddress("192.168.2.25"), 7000),
+		"outbound", classInteractive)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	uplinkReader, uplinkWriter := pipe.New()
+	defer uplinkWriter.Close()
+	rule := &NATRule{RuleId: "custom", Alg: []string{"test-upper"}}
+	_, uplink, downlink := handler.wrapALG(context.Background(), rule, natSession, local, uplinkReader)
+
+	uplinkWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("hello\r\n")))
+	mb, err := uplink.ReadMultiBuffer()
+	if err != nil || mb.String() != "HELLO\r\n" {
+		t.Errorf("Expected the line of the client to be rewritten, got %q: %v", mb.String(), err)
+	}
+	buf.ReleaseMulti(mb)
+
+	go func() {
+		remote.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		remote.Write([]byte("7001\r\n"))
+	}()
+	mb, err = downlink.ReadMultiBuffer()
+	if err != nil || mb.String() != "7001\r\n" {
+		t.Errorf("Unexpected line of the server %q: %v", mb.String(), err)
+	}
+	buf.ReleaseMulti(mb)
+	if _, ok := handler.expectations.Load(xnet.TCPDestination(xnet.ParseAddress("240.2.2.25"), 7001).String()); !ok {
+		t.Error("Expected the announced port to be expected")
+	}
+}
@@ -0,0 +1,113 @@
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Audited actions
+const (
+	AuditRulesReload     = "rulesReload"
+	AuditRulesExpire     = "rulesExpire"
+	AuditFlushSessions   = "flushSessions"
+	AuditAllocateAddress = "allocateAddress"
+	AuditRenewLease      = "renewLease"
+	AuditReleaseAddress  = "releaseAddress"
+	AuditDrain           = "drain"
+	AuditResume          = "resume"
+	AuditDisableRules    = "disableRules"
+	AuditEnableRules     = "enableRules"
+	AuditAddRule         = "addRule"
+)
+
+// AuditEvent is one entry of the NAT audit log
+type AuditEvent struct {
+	Time    time.Time         `json:"time"`
+	SiteID  string            `json:"siteId"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`            // Who: the rules file, the controller or the API client
+	Target  string            `json:"target,omitempty"` // Rule or address acted on, if any
+	Detail  string            `json:"detail,omitempty"`
+	Changes []AuditRuleChange `json:"changes,omitempty"`
+	Error   string            `json:"error,omitempty"` // Why the action failed, empty when it succeeded
+}
+
+// AuditRuleChange is a rule added, removed or changed by a rules reload. Before is empty for
+// added rules and After for removed ones.
+type AuditRuleChange struct {
+	Rule   string          `json:"rule"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// String formats the event as space separated key=value pairs
+func (e *AuditEvent) String() string {
+	line := fmt.Sprintf("NAT audit %s actor=%s", e.Action, e.Actor)
+	if e.Target != "" {
+		line += " target=" + e.Target
+	}
+	if e.Detail != "" {
+		line += " " + e.Detail
+	}
+	var added, removed, changed []string
+	for _, change := range e.Changes {
+		switch {
+		case change.Before == nil:
+			added = append(added, change.Rule)
+		case change.After == nil:
+			removed = append(removed, change.Rule)
+		default:
+			changed = append(changed, change.Rule)
+		}
+	}
+	if len(e.Changes) > 0 {
+		line += fmt.Sprintf(" added=[%s] removed=[%s] changed=[%s]",
+			strings.Join(added, ","), strings.Join(removed, ","), strings.Join(changed, ","))
+	}
+	if e.Error != "" {
+		line += " error=" + e.Error
+	}
+	return line
+}
+
+// Audit records an administrative action in the audit log, when one is configured
+func (h *Handler) Audit(event *AuditEvent) {
+	if h.auditLog == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.SiteID = h.siteID()
+	if err := h.auditLog.Write(event); err != nil {
+		errors.LogWarningInner(context.Background(), err, "failed to write NAT audit log")
+	}
+}
+
+// auditRulesReload records the rules a reload from source added, removed and changed
+func (h *Handler) auditRulesReload(source string, changes []AuditRuleChange) {
+	if h.auditLog == nil {
+		return
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Rule < changes[j].Rule
+	})
+	h.Audit(&AuditEvent{Action: AuditRulesReload, Actor: source, Changes: changes})
+}
+
+// auditedRule is the JSON form of a rule in the audit log, nil for no rule
+func auditedRule(rule *NATRule) json.RawMessage {
+	if rule == nil {
+		return nil
+	}
+	data, err := protojson.Marshal(rule)
+	if err != nil {
+		return nil
+	}
+	return data
+}
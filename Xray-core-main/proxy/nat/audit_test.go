@@ -0,0 +1,73 @@
+package nat
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditRulesReload(t *testing.T) {
+	previous := rulesDecoder
+	RegisterRulesDecoder(lineRulesDecoder)
+	defer RegisterRulesDecoder(previous)
+
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "rules.json")
+	auditPath := filepath.Join(dir, "audit.log")
+	if err := os.WriteFile(rulesPath, []byte("db 240.2.2.30 192.168.1.30\nweb 240.2.2.20 192.168.1.20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:    "test-site",
+		RulesFile: rulesPath,
+		AuditLog:  &SessionLog{Sink: "file", Path: auditPath},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rulesPath, []byte("web 240.2.2.20 192.168.1.21\nmail 240.2.2.25 192.168.1.25\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	// Reloading unchanged rules is not audited
+	if err := handler.reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the initial load and one reload to be audited, got %q", lines)
+	}
+	var event AuditEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != AuditRulesReload || event.Actor != rulesPath || event.SiteID != "test-site" || len(event.Changes) != 3 {
+		t.Fatalf("Unexpected audit event %+v", event)
+	}
+
+	// Changes are ordered by rule and carry the rules before and after
+	db, mail, web := event.Changes[0], event.Changes[1], event.Changes[2]
+	if db.Rule != "db" || db.Before == nil || db.After != nil {
+		t.Errorf("Expected db to be removed, got %+v", db)
+	}
+	if mail.Rule != "mail" || mail.Before != nil || !strings.Contains(string(mail.After), "192.168.1.25") {
+		t.Errorf("Expected mail to be added, got %+v", mail)
+	}
+	if web.Rule != "web" || !strings.Contains(string(web.Before), "192.168.1.20") || !strings.Contains(string(web.After), "192.168.1.21") {
+		t.Errorf("Expected web to be changed, got %+v", web)
+	}
+	if line := event.String(); !strings.Contains(line, "added=[mail] removed=[db] changed=[web]") {
+		t.Errorf("Unexpected log line %q", line)
+	}
+}
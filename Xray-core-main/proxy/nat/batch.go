@@ -0,0 +1,151 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/transport/internet"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// udpBatchSize is the most datagrams a UDP mapping reads or writes in one system call when UDP
+// batching is enabled
+const udpBatchSize = 16
+
+// batchConn reads and writes datagrams in batches, with recvmmsg and sendmmsg on Linux and one
+// datagram per system call elsewhere
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchedPacketConn is a socket of a UDP mapping read and written in batches
+type batchedPacketConn struct {
+	packetConn
+	batch batchConn
+}
+
+// batched returns conn reading and writing in batches when UDP batching is enabled and conn is
+// a plain UDP socket, and conn itself otherwise
+func (h *Handler) batched(conn packetConn) packetConn {
+	if !h.config.GetUdpBatching() {
+		return conn
+	}
+	wrapper, ok := conn.(*internet.PacketConnWrapper)
+	if !ok {
+		return conn
+	}
+	udpConn, ok := wrapper.Conn.(*net.UDPConn)
+	if !ok {
+		return conn
+	}
+	if local, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && local.IP.To4() != nil {
+		return &batchedPacketConn{packetConn: conn, batch: ipv4.NewPacketConn(udpConn)}
+	}
+	return &batchedPacketConn{packetConn: conn, batch: ipv6.NewPacketConn(udpConn)}
+}
+
+// datagramBatch collects the datagrams of an uplink MultiBuffer sent through one socket, so they
+// leave in as few system calls as possible
+type datagramBatch struct {
+	conn     *batchedPacketConn
+	messages [udpBatchSize]ipv4.Message
+	payloads [udpBatchSize][1][]byte
+	size     int
+}
+
+// add queues a datagram for addr on conn, sending what is queued first when conn differs or the
+// batch is full
+func (b *datagramBatch) add(ctx context.Context, conn *batchedPacketConn, payload []byte, addr net.Addr) {
+	if b.conn != conn || b.size == udpBatchSize {
+		b.flush(ctx)
+		b.conn = conn
+	}
+	b.payloads[b.size][0] = payload
+	b.messages[b.size] = ipv4.Message{Buffers: b.payloads[b.size][:], Addr: addr}
+	b.size++
+}
+
+// flush sends the queued datagrams
+func (b *datagramBatch) flush(ctx context.Context) {
+	messages := b.messages[:b.size]
+	for len(messages) > 0 {
+		n, err := b.conn.batch.WriteBatch(messages, 0)
+		if err != nil {
+			errors.LogInfoInner(ctx, err, "failed to send ", len(messages), " UDP datagrams")
+			break
+		}
+		messages = messages[n:]
+	}
+	clear(b.messages[:b.size])
+	b.size = 0
+}
+
+// readBatches relays return traffic of a batched socket to the client, every datagram read in
+// one system call forwarded as one MultiBuffer. Buffers of datagrams dropped by the filtering
+// behavior are read into again.
+func (m *udpMapping) readBatches(conn *batchedPacketConn) {
+	var messages [udpBatchSize]ipv4.Message
+	var buffers [udpBatchSize]*buf.Buffer
+	var payloads [udpBatchSize][1][]byte
+	defer func() {
+		for _, b := range buffers {
+			if b != nil {
+				b.Release()
+			}
+		}
+	}()
+
+	for {
+		for i, b := range buffers {
+			if b == nil {
+				b = buf.New()
+				b.Resize(0, buf.Size)
+				buffers[i] = b
+				payloads[i][0] = b.Bytes()
+				messages[i].Buffers = payloads[i][:]
+			}
+		}
+		n, err := conn.batch.ReadBatch(messages[:], 0)
+		if err != nil {
+			return
+		}
+
+		flow := m.flow.Load()
+		if flow == nil {
+			return
+		}
+		mb := make(buf.MultiBuffer, 0, n)
+		var bytes int64
+		for i := range n {
+			remote, ok := messages[i].Addr.(*net.UDPAddr)
+			if !ok || !m.accepts(remote) {
+				continue
+			}
+			if !m.shapers.downlink.allow(int64(messages[i].N)) {
+				m.handler.shapingDrop(flow.session)
+				continue
+			}
+			b := buffers[i]
+			buffers[i] = nil
+			b.Resize(0, int32(messages[i].N))
+			source := m.virtualSource(remote)
+			b.UDP = &source
+			mb = append(mb, b)
+			bytes += int64(messages[i].N)
+		}
+		if mb.IsEmpty() {
+			continue
+		}
+		flow.session.record(false, bytes, int64(len(mb)))
+		atomic.AddInt64(&m.handler.totalBytes, bytes)
+		flow.timer.Update()
+		if err := flow.output.WriteMultiBuffer(mb); err != nil && m.flow.Load() == flow {
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestUDPBatching(t *testing.T) {
+	server := listenUDP(t)
+	rule := &NATRule{RuleId: "game", VirtualDestination: "240.2.2.20", RealDestination: "127.0.0.1", Protocol: "udp", NatBehavior: natBehaviorFullCone}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{rule}, UdpBatching: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	link := &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}
+	virtual := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(server.LocalAddr().(*net.UDPAddr).Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), link, virtual, udpTestDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	const count = udpBatchSize + 4
+	mb := make(buf.MultiBuffer, 0, count)
+	for i := range count {
+		b := buf.New()
+		b.WriteString(fmt.Sprint("datagram ", i))
+		b.UDP = &virtual
+		mb = append(mb, b)
+	}
+	if err := uplinkWriter.WriteMultiBuffer(mb); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every datagram arrives in order, then the replies come back through the batched socket
+	payload := make([]byte, 64)
+	var mapped *net.UDPAddr
+	for i := range count {
+		server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := server.ReadFromUDP(payload)
+		if err != nil || string(payload[:n]) != fmt.Sprint("datagram ", i) {
+			t.Fatalf("Expected datagram %d, got %q: %v", i, payload[:n], err)
+		}
+		mapped = from
+	}
+	for i := range count {
+		if _, err := server.WriteToUDP([]byte(fmt.Sprint("reply ", i)), mapped); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for received := 0; received < count; {
+		replies, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+		if err != nil {
+			t.Fatalf("Expected %d replies, got %d: %v", count, received, err)
+		}
+		for _, reply := range replies {
+			if reply.String() != fmt.Sprint("reply ", received) || reply.UDP == nil || reply.UDP.NetAddr() != virtual.NetAddr() {
+				t.Errorf("Unexpected reply %q from %v", reply.String(), reply.UDP)
+			}
+			received++
+		}
+		buf.ReleaseMulti(replies)
+	}
+
+	var stats SessionStats
+	handler.sessions.Range(func(s *NATSession) bool {
+		stats = s.Stats()
+		return false
+	})
+	if stats.UplinkPackets != count || stats.DownlinkPackets != count {
+		t.Errorf("Expected %d datagrams each way, got %+v", count, stats)
+	}
+}
@@ -0,0 +1,343 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// PortBlock is a contiguous range of source ports on the public address held by one subscriber
+type PortBlock struct {
+	Start xnet.Port
+	End   xnet.Port
+}
+
+// portBlock tracks the ports of one allocated block
+type portBlock struct {
+	index  int
+	start  int
+	used   []bool
+	inUse  int
+	cursor int // Next port to try, so released ports are not reused immediately
+}
+
+func (b *portBlock) take() (xnet.Port, bool) {
+	for i := 0; i < len(b.used); i++ {
+		offset := (b.cursor + i) % len(b.used)
+		if !b.used[offset] {
+			b.used[offset] = true
+			b.inUse++
+			b.cursor = offset + 1
+			return xnet.Port(b.start + offset), true
+		}
+	}
+	return 0, false
+}
+
+func (b *portBlock) contains(port xnet.Port) bool {
+	return int(port) >= b.start && int(port) < b.start+len(b.used)
+}
+
+func (b *portBlock) block() PortBlock {
+	return PortBlock{Start: xnet.Port(b.start), End: xnet.Port(b.start + len(b.used) - 1)}
+}
+
+// portBlockAllocator hands out source ports on a shared public address in per-subscriber blocks.
+// In dynamic mode blocks come from a free list and every block allocation and release is logged,
+// which is all that is needed to attribute a public port to a subscriber. In deterministic mode
+// (RFC 7422) the blocks of a subscriber are computed from its address, so nothing needs logging.
+type portBlockAllocator struct {
+	sync.Mutex
+	publicAddress xnet.Address
+	portStart     int
+	blockSize     int
+	blockCount    int
+	maxBlocks     int
+	deterministic bool
+	subscribers   *net.IPNet
+	free          []int                   // Free block indices of dynamic mode, oldest release first
+	blocks        map[string][]*portBlock // Subscriber address -> held blocks
+}
+
+func newPortBlockAllocator(config *PortBlockAllocation) (*portBlockAllocator, error) {
+	publicAddress := net.ParseIP(config.PublicAddress)
+	if publicAddress == nil {
+		return nil, errors.New("invalid CGNAT public address ", config.PublicAddress)
+	}
+
+	a := &portBlockAllocator{
+		publicAddress: xnet.IPAddress(publicAddress),
+		portStart:     int(config.PortRangeStart),
+		blockSize:     int(config.BlockSize),
+		maxBlocks:     int(config.MaxBlocksPerSubscriber),
+		deterministic: config.Deterministic,
+		blocks:        make(map[string][]*portBlock),
+	}
+	portEnd := int(config.PortRangeEnd)
+	if a.portStart == 0 {
+		a.portStart = 1024
+	}
+	if portEnd == 0 {
+		portEnd = 65535
+	}
+	if a.blockSize == 0 {
+		a.blockSize = 512
+	}
+	if a.maxBlocks == 0 {
+		a.maxBlocks = 1
+	}
+	if portEnd > 65535 || a.portStart > portEnd {
+		return nil, errors.New("invalid CGNAT port range ", a.portStart, "-", portEnd)
+	}
+	a.blockCount = (portEnd - a.portStart + 1) / a.blockSize
+	if a.blockCount == 0 {
+		return nil, errors.New("CGNAT port range ", a.portStart, "-", portEnd, " is smaller than one block of ", a.blockSize, " ports")
+	}
+
+	if a.deterministic {
+		network, err := parseNetworkOrIP(config.SubscriberNetwork)
+		if err != nil {
+			return nil, errors.New("invalid CGNAT subscriber network").Base(err)
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones > 24 || (1<<(bits-ones))*a.maxBlocks > a.blockCount {
+			return nil, errors.New("CGNAT port range holds ", a.blockCount, " blocks, not enough for ", a.maxBlocks, " blocks per address of ", network)
+		}
+		a.subscribers = network
+	} else {
+		a.free = make([]int, a.blockCount)
+		for i := range a.free {
+			a.free[i] = i
+		}
+	}
+
+	return a, nil
+}
+
+// subscriberOffset returns the position of a subscriber address in the deterministic subscriber network
+func (a *portBlockAllocator) subscriberOffset(subscriber net.IP) (int, bool) {
+	ip := normalizeIP(subscriber, a.subscribers.Mask)
+	if len(ip) != len(a.subscribers.Mask) || !a.subscribers.Contains(ip) {
+		return 0, false
+	}
+	offset := 0
+	for i := range ip {
+		offset = offset<<8 | int(ip[i]&^a.subscribers.Mask[i])
+	}
+	return offset, true
+}
+
+// nextBlockIndex picks the index of a new block for a subscriber that holds the given blocks
+func (a *portBlockAllocator) nextBlockIndex(subscriber net.IP, held []*portBlock) (int, error) {
+	if !a.deterministic {
+		if len(a.free) == 0 {
+			return 0, errors.New("CGNAT port blocks exhausted").Base(ErrExhausted)
+		}
+		index := a.free[0]
+		a.free = a.free[1:]
+		return index, nil
+	}
+
+	offset, ok := a.subscriberOffset(subscriber)
+	if !ok {
+		return 0, errors.New(subscriber, " is not in the CGNAT subscriber network ", a.subscribers)
+	}
+next:
+	for k := 0; k < a.maxBlocks; k++ {
+		index := offset*a.maxBlocks + k
+		for _, block := range held {
+			if block.index == index {
+				continue next
+			}
+		}
+		return index, nil
+	}
+	return 0, errors.New("no deterministic CGNAT block left for ", subscriber).Base(ErrExhausted)
+}
+
+// Allocate returns a free source port from the blocks of subscriber, allocating a new block when needed
+func (a *portBlockAllocator) Allocate(ctx context.Context, subscriber net.IP) (xnet.Port, error) {
+	key := subscriber.String()
+
+	a.Lock()
+	defer a.Unlock()
+
+	held := a.blocks[key]
+	for _, block := range held {
+		if port, ok := block.take(); ok {
+			return port, nil
+		}
+	}
+	if len(held) >= a.maxBlocks {
+		return 0, errors.New("subscriber ", key, " has exhausted its ", a.maxBlocks, " CGNAT port blocks").Base(ErrExhausted)
+	}
+
+	index, err := a.nextBlockIndex(subscriber, held)
+	if err != nil {
+		return 0, err
+	}
+	block := &portBlock{
+		index: index,
+		start: a.portStart + index*a.blockSize,
+		used:  make([]bool, a.blockSize),
+	}
+	a.blocks[key] = append(held, block)
+	if !a.deterministic {
+		errors.LogInfo(ctx, "CGNAT: allocated ports ", block.block().Start, "-", block.block().End, " on ", a.publicAddress, " to ", key)
+	}
+
+	port, _ := block.take()
+	return port, nil
+}
+
+// Release returns a port of subscriber; blocks without ports in use are released for reuse
+func (a *portBlockAllocator) Release(subscriber net.IP, port xnet.Port) {
+	key := subscriber.String()
+
+	a.Lock()
+	defer a.Unlock()
+
+	held := a.blocks[key]
+	for i, block := range held {
+		if !block.contains(port) {
+			continue
+		}
+		offset := int(port) - block.start
+		if !block.used[offset] {
+			return
+		}
+		block.used[offset] = false
+		block.inUse--
+		if block.inUse > 0 {
+			return
+		}
+
+		held = append(held[:i], held[i+1:]...)
+		if len(held) == 0 {
+			delete(a.blocks, key)
+		} else {
+			a.blocks[key] = held
+		}
+		if !a.deterministic {
+			a.free = append(a.free, block.index)
+			errors.LogInfo(context.Background(), "CGNAT: released ports ", block.block().Start, "-", block.block().End, " on ", a.publicAddress, " from ", key)
+		}
+		return
+	}
+}
+
+// Reserve marks a specific port of subscriber as in use, taking the block that holds it when the
+// subscriber does not hold it yet. It restores the allocations of persisted sessions.
+func (a *portBlockAllocator) Reserve(subscriber net.IP, port xnet.Port) error {
+	key := subscriber.String()
+
+	a.Lock()
+	defer a.Unlock()
+
+	held := a.blocks[key]
+	var block *portBlock
+	for _, candidate := range held {
+		if candidate.contains(port) {
+			block = candidate
+			break
+		}
+	}
+	if block == nil {
+		index := (int(port) - a.portStart) / a.blockSize
+		if int(port) < a.portStart || index >= a.blockCount {
+			return errors.New("port ", port, " is outside the CGNAT port range")
+		}
+		if len(held) >= a.maxBlocks {
+			return errors.New("subscriber ", key, " has exhausted its ", a.maxBlocks, " CGNAT port blocks")
+		}
+		if a.deterministic {
+			if offset, ok := a.subscriberOffset(subscriber); !ok || index/a.maxBlocks != offset {
+				return errors.New("port ", port, " is not in a deterministic CGNAT block of ", key)
+			}
+		} else if !a.takeFree(index) {
+			return errors.New("the CGNAT block of port ", port, " is held by another subscriber")
+		}
+		block = &portBlock{
+			index: index,
+			start: a.portStart + index*a.blockSize,
+			used:  make([]bool, a.blockSize),
+		}
+		a.blocks[key] = append(held, block)
+	}
+
+	offset := int(port) - block.start
+	if block.used[offset] {
+		return errors.New("CGNAT port ", port, " of ", key, " is already in use")
+	}
+	block.used[offset] = true
+	block.inUse++
+	block.cursor = offset + 1
+	return nil
+}
+
+// takeFree removes a block index from the free list of dynamic mode
+func (a *portBlockAllocator) takeFree(index int) bool {
+	for i, free := range a.free {
+		if free == index {
+			a.free = append(a.free[:i], a.free[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks returns the port blocks currently held by subscriber
+func (a *portBlockAllocator) Blocks(subscriber net.IP) []PortBlock {
+	a.Lock()
+	defer a.Unlock()
+
+	held := a.blocks[subscriber.String()]
+	blocks := make([]PortBlock, 0, len(held))
+	for _, block := range held {
+		blocks = append(blocks, block.block())
+	}
+	return blocks
+}
+
+// allocateSourcePort assigns the translated source endpoint of a session from the subscriber's
+// port blocks and makes the outbound dial from the shared public address.
+func (h *Handler) allocateSourcePort(ctx context.Context, natSession *NATSession) error {
+	if h.portBlocks == nil || natSession.VirtualSource.Address == nil || !natSession.VirtualSource.Address.Family().IsIP() {
+		return nil
+	}
+
+	port, err := h.portBlocks.Allocate(ctx, natSession.VirtualSource.Address.IP())
+	if err != nil {
+		return err
+	}
+	natSession.RealSource = xnet.Destination{
+		Network: natSession.VirtualDest.Network,
+		Address: h.portBlocks.publicAddress,
+		Port:    port,
+	}
+
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		outbounds[len(outbounds)-1].Gateway = h.portBlocks.publicAddress
+	}
+	return nil
+}
+
+// releaseSourcePort returns the source port of a removed session to its subscriber's blocks
+func (h *Handler) releaseSourcePort(natSession *NATSession) {
+	if h.portBlocks == nil || natSession.RealSource.Port == 0 {
+		return
+	}
+	h.portBlocks.Release(natSession.VirtualSource.Address.IP(), natSession.RealSource.Port)
+}
+
+// PortBlocks returns the CGNAT port blocks held by a subscriber address
+func (h *Handler) PortBlocks(subscriber net.IP) []PortBlock {
+	if h.portBlocks == nil {
+		return nil
+	}
+	return h.portBlocks.Blocks(subscriber)
+}
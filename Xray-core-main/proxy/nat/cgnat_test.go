@@ -0,0 +1,211 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestPortBlockAllocatorDynamic(t *testing.T) {
+	allocator, err := newPortBlockAllocator(&PortBlockAllocation{
+		PublicAddress:          "203.0.113.1",
+		PortRangeStart:         10000,
+		PortRangeEnd:           10007,
+		BlockSize:              4,
+		MaxBlocksPerSubscriber: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := net.ParseIP("100.64.0.1")
+	bob := net.ParseIP("100.64.0.2")
+	carol := net.ParseIP("100.64.0.3")
+	ctx := context.Background()
+
+	var alicePorts []xnet.Port
+	for i := 0; i < 4; i++ {
+		port, err := allocator.Allocate(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alicePorts = append(alicePorts, port)
+	}
+	if alicePorts[0] != 10000 || alicePorts[3] != 10003 {
+		t.Errorf("Expected ports 10000-10003 from the first block, got %v", alicePorts)
+	}
+	if _, err := allocator.Allocate(ctx, alice); err == nil {
+		t.Error("Expected the per-subscriber block limit to be enforced")
+	}
+
+	if port, err := allocator.Allocate(ctx, bob); err != nil || port != 10004 {
+		t.Errorf("Expected bob to get port 10004 from the second block, got %d, %v", port, err)
+	}
+	if _, err := allocator.Allocate(ctx, carol); err == nil {
+		t.Error("Expected allocation to fail when all blocks are in use")
+	}
+
+	// Releasing every port of alice returns her block for reuse
+	for _, port := range alicePorts {
+		allocator.Release(alice, port)
+	}
+	if blocks := allocator.Blocks(alice); len(blocks) != 0 {
+		t.Errorf("Expected alice to hold no blocks, got %v", blocks)
+	}
+	if port, err := allocator.Allocate(ctx, carol); err != nil || port != 10000 {
+		t.Errorf("Expected carol to reuse the released block, got %d, %v", port, err)
+	}
+}
+
+func TestPortBlockAllocatorDeterministic(t *testing.T) {
+	allocator, err := newPortBlockAllocator(&PortBlockAllocation{
+		PublicAddress:          "203.0.113.1",
+		PortRangeStart:         1024,
+		PortRangeEnd:           65535,
+		BlockSize:              512,
+		MaxBlocksPerSubscriber: 2,
+		Deterministic:          true,
+		SubscriberNetwork:      "100.64.0.0/27",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := allocator.Allocate(context.Background(), net.ParseIP("100.64.0.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subscriber offset 5 owns blocks 10 and 11
+	if port != xnet.Port(1024+10*512) {
+		t.Errorf("Expected port %d, got %d", 1024+10*512, port)
+	}
+
+	if _, err := allocator.Allocate(context.Background(), net.ParseIP("100.64.1.5")); err == nil {
+		t.Error("Expected subscribers outside the subscriber network to be rejected")
+	}
+
+	if _, err := newPortBlockAllocator(&PortBlockAllocation{
+		PublicAddress:     "203.0.113.1",
+		Deterministic:     true,
+		SubscriberNetwork: "100.64.0.0/24",
+	}); err == nil {
+		t.Error("Expected an error when the port range cannot hold a block for every subscriber")
+	}
+}
+
+func TestSessionSourcePortAllocation(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		PortBlockAllocation: &PortBlockAllocation{
+			PublicAddress: "203.0.113.1",
+			BlockSize:     512,
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.UDPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	dest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53)
+	natSession := handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+	if err := handler.allocateSourcePort(context.Background(), natSession); err != nil {
+		t.Fatal(err)
+	}
+	if natSession.RealSource.Address.String() != "203.0.113.1" || natSession.RealSource.Port != 1024 {
+		t.Errorf("Unexpected translated source %v", natSession.RealSource)
+	}
+	if blocks := handler.PortBlocks(net.ParseIP("100.64.0.1")); len(blocks) != 1 || blocks[0].End != 1535 {
+		t.Errorf("Expected one block 1024-1535, got %v", blocks)
+	}
+
+	handler.removeSession(natSession.SessionID)
+	if blocks := handler.PortBlocks(net.ParseIP("100.64.0.1")); len(blocks) != 0 {
+		t.Errorf("Expected the block to be released with the session, got %v", blocks)
+	}
+}
+
+// sourceSeenByServer runs a TCP flow from 100.64.0.1 to 240.2.2.1 through handler with rule, to a
+// local server, and returns the source address the server saw, or the error the flow failed with
+func sourceSeenByServer(t *testing.T, handler *Handler, rule *NATRule) (*net.TCPAddr, error) {
+	listener := listenTCP(t)
+	seen := make(chan *net.TCPAddr, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		seen <- conn.RemoteAddr().(*net.TCPAddr)
+		io.Copy(io.Discard, conn)
+	}()
+
+	source := xnet.TCPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+	uplinkReader, uplinkWriter := pipe.New()
+	_, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		// The outbound transport refuses every flow; the flow must leave from its own endpoint
+		done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, externalDialer{}, rule)
+	}()
+
+	select {
+	case addr := <-seen:
+		uplinkWriter.Close()
+		<-done
+		return addr, nil
+	case err := <-done:
+		return nil, err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Flow neither reached the server nor failed")
+		return nil, nil
+	}
+}
+
+func TestSessionSourcePortOnTheWire(t *testing.T) {
+	held := listenTCP(t)
+	port := held.Addr().(*net.TCPAddr).Port
+	rule := &NATRule{RuleId: "local", VirtualDestination: "240.2.2.1", RealDestination: "127.0.0.1"}
+	newHandler := func() *Handler {
+		handler := New()
+		t.Cleanup(func() { handler.Close() })
+		if err := handler.Init(&Config{
+			SiteId:        "test-site",
+			VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "127.0.0.0/24"}},
+			Rules:         []*NATRule{rule},
+			PortBlockAllocation: &PortBlockAllocation{
+				PublicAddress:  "127.0.0.1",
+				PortRangeStart: uint32(port),
+				PortRangeEnd:   uint32(port),
+				BlockSize:      1,
+			},
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+
+	// The only port of the range is taken, so the flow fails rather than leave from another one
+	if addr, err := sourceSeenByServer(t, newHandler(), rule); err == nil {
+		t.Errorf("Expected the flow to fail when its source port cannot be bound, it left from %v", addr)
+	}
+
+	held.Close()
+	handler := newHandler()
+	addr, err := sourceSeenByServer(t, handler, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.Port != port || !addr.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected the server to see the allocated source 127.0.0.1:%d, got %v", port, addr)
+	}
+}
@@ -0,0 +1,133 @@
+package nat
+
+import (
+	"context"
+	"net"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// clatRuleID is the ruleId of the rules, and so of the sessions, the CLAT creates
+const clatRuleID = "clat"
+
+// defaultPLATPrefix is the Well-Known Prefix of RFC 6052
+const defaultPLATPrefix = "64:ff9b::/96"
+
+// clatTranslator is the customer-side translator of 464XLAT (RFC 6877). IPv4 flows reach
+// their destinations through the provider-side translator, at the destination embedded into
+// the PLAT prefix, optionally from the source embedded into the CLAT prefix; the addresses of
+// the PLAT prefix map back to the IPv4 addresses they embed.
+type clatTranslator struct {
+	platPrefix   *net.IPNet
+	sourcePrefix *net.IPNet   // Nil when the source is left to the dialer
+	networks     []*net.IPNet // IPv4 destinations translated, all of them when empty
+}
+
+func newCLAT(config *Clat) (*clatTranslator, error) {
+	platPrefix := config.PlatPrefix
+	if platPrefix == "" {
+		platPrefix = defaultPLATPrefix
+	}
+	c := &clatTranslator{}
+	var err error
+	if c.platPrefix, err = embeddingPrefix(platPrefix); err != nil {
+		return nil, errors.New("invalid platPrefix").Base(err)
+	}
+	if config.SourcePrefix != "" {
+		if c.sourcePrefix, err = embeddingPrefix(config.SourcePrefix); err != nil {
+			return nil, errors.New("invalid sourcePrefix").Base(err)
+		}
+		if c.sourcePrefix.Contains(c.platPrefix.IP) || c.platPrefix.Contains(c.sourcePrefix.IP) {
+			return nil, errors.New("sourcePrefix ", config.SourcePrefix, " overlaps platPrefix ", platPrefix)
+		}
+	}
+	for _, network := range config.Networks {
+		ipNet, err := parseNetworkOrIP(network)
+		if err != nil || ipNet.IP.To4() == nil {
+			return nil, errors.New("network ", network, " is not an IPv4 network")
+		}
+		c.networks = append(c.networks, ipNet)
+	}
+	return c, nil
+}
+
+// ValidateCLAT checks the prefixes and networks of a CLAT
+func ValidateCLAT(config *Clat) error {
+	_, err := newCLAT(config)
+	return err
+}
+
+// embeddingPrefix parses an IPv6 prefix of one of the lengths RFC 6052 embeds IPv4 addresses into
+func embeddingPrefix(prefix string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := embedIPv4(ipNet, net.IPv4zero); err != nil {
+		return nil, err
+	}
+	return ipNet, nil
+}
+
+// rule returns the dynamic rule translating an IPv4 destination through the PLAT
+func (c *clatTranslator) rule(destination xnet.Destination) (*NATRule, bool) {
+	if c == nil || !destination.Address.Family().IsIPv4() {
+		return nil, false
+	}
+	ip := destination.Address.IP()
+	if len(c.networks) > 0 {
+		found := false
+		for _, network := range c.networks {
+			if network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	realIP, err := embedIPv4(c.platPrefix, ip)
+	if err != nil {
+		return nil, false
+	}
+	return &NATRule{
+		RuleId:             clatRuleID,
+		VirtualDestination: ip.String(),
+		RealDestination:    realIP.String(),
+		Protocol:           "tcp,udp",
+	}, true
+}
+
+// virtualAddressOf returns the IPv4 address an address of the PLAT prefix embeds
+func (c *clatTranslator) virtualAddressOf(ip net.IP) (net.IP, bool) {
+	if c == nil {
+		return nil, false
+	}
+	return extractIPv4(c.platPrefix, ip)
+}
+
+// sourceOf returns the IPv6 source of a flow from source to an address of the PLAT prefix,
+// nil when the source is left to the dialer
+func (c *clatTranslator) sourceOf(source xnet.Address, realDest xnet.Address) net.IP {
+	if c == nil || c.sourcePrefix == nil || source == nil || !source.Family().IsIPv4() {
+		return nil
+	}
+	if realDest == nil || !realDest.Family().IsIP() || !c.platPrefix.Contains(realDest.IP()) {
+		return nil
+	}
+	ip, err := embedIPv4(c.sourcePrefix, source.IP())
+	if err != nil {
+		return nil
+	}
+	return ip
+}
+
+// withCLATSource makes a flow from source to realDest leave from its address of the CLAT prefix
+func (h *Handler) withCLATSource(ctx context.Context, source xnet.Destination, realDest xnet.Destination) context.Context {
+	if ip := h.clat.sourceOf(source.Address, realDest.Address); ip != nil {
+		return withGateway(ctx, realDest, ip)
+	}
+	return ctx
+}
@@ -0,0 +1,113 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+func TestCLAT(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		Rules:         []*NATRule{{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"}},
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.3.3.0/24", RealNetwork: "192.168.3.0/24"}},
+		Clat:          &Clat{SourcePrefix: "2001:db8:46::/96"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	matched := func(ip string) *NATRule {
+		rule, _ := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(ip), 443))
+		return rule
+	}
+
+	// Rules and ranges keep translating their destinations
+	if rule := matched("240.2.2.20"); rule == nil || rule.RuleId != "web" {
+		t.Errorf("Expected rule web, got %v", rule)
+	}
+	if rule := matched("240.3.3.7"); rule == nil || rule.RuleId != "dynamic-range-240.3.3.0/24" {
+		t.Errorf("Expected the range, got %v", rule)
+	}
+	rule := matched("198.51.100.7")
+	if rule == nil || rule.RuleId != clatRuleID || rule.RealDestination != "64:ff9b::c633:6407" {
+		t.Fatalf("Expected the destination to be embedded into the PLAT prefix, got %v", rule)
+	}
+	if rule := matched("2001:db8::1"); rule != nil {
+		t.Errorf("Expected IPv6 destinations to be left alone, got %v", rule)
+	}
+
+	// The reverse direction
+	if ip, ok := handler.virtualAddressOf(net.ParseIP("64:ff9b::c633:6407")); !ok || !ip.Equal(net.ParseIP("198.51.100.7")) {
+		t.Errorf("Expected the PLAT address to map back to 198.51.100.7, got %v", ip)
+	}
+
+	// Flows leave from their source embedded into the CLAT prefix
+	source := xnet.TCPDestination(xnet.ParseAddress("192.168.1.5"), 40000)
+	translation, err := handler.TestTranslation(source, xnet.TCPDestination(xnet.ParseAddress("198.51.100.7"), 443))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.RealDestination.NetAddr() != "[64:ff9b::c633:6407]:443" || translation.RealSource.Address.String() != "[2001:db8:46::c0a8:105]" {
+		t.Errorf("Unexpected translation %+v", translation)
+	}
+	ctx := handler.withCLATSource(context.Background(), source, translation.RealDestination)
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) != 1 || outbounds[0].Gateway.String() != "[2001:db8:46::c0a8:105]" {
+		t.Errorf("Expected the flow to leave from the CLAT address, got %v", outbounds)
+	}
+	if _, hairpin := handler.hairpinSource(xnet.TCPDestination(xnet.ParseAddress("192.168.3.5"), 40000), translation.RealDestination); hairpin {
+		t.Error("Expected flows through the PLAT not to hairpin")
+	}
+}
+
+func TestCLATNetworks(t *testing.T) {
+	clat, err := newCLAT(&Clat{PlatPrefix: "2001:db8:64::/64", Networks: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := clat.rule(xnet.TCPDestination(xnet.ParseAddress("198.51.100.7"), 443)); ok {
+		t.Error("Expected destinations outside the networks to be left alone")
+	}
+	rule, ok := clat.rule(xnet.TCPDestination(xnet.ParseAddress("10.1.2.3"), 443))
+	if !ok || rule.RealDestination != "2001:db8:64:0:a:102:300:0" {
+		t.Errorf("Expected the /64 embedding to skip the u octet, got %v", rule)
+	}
+	if ip, ok := clat.virtualAddressOf(net.ParseIP(rule.RealDestination)); !ok || !ip.Equal(net.ParseIP("10.1.2.3")) {
+		t.Errorf("Expected the embedded address back, got %v", ip)
+	}
+	if clat.sourceOf(xnet.ParseAddress("192.168.1.5"), xnet.ParseAddress(rule.RealDestination)) != nil {
+		t.Error("Expected the source to be left to the dialer without a source prefix")
+	}
+
+	for _, config := range []*Clat{
+		{PlatPrefix: "64:ff9b::/80"},
+		{PlatPrefix: "192.0.2.0/24"},
+		{SourcePrefix: "64:ff9b::/96"},
+		{Networks: []string{"2001:db8::/32"}},
+	} {
+		if err := ValidateCLAT(config); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
+
+func TestExtractIPv4(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.33")
+	for _, prefix := range []string{"2001:db8::/32", "2001:db8:100::/40", "2001:db8:122::/48", "2001:db8:122:300::/56", "2001:db8:122:344::/64", "64:ff9b::/96"} {
+		_, ipNet, _ := net.ParseCIDR(prefix)
+		embedded, err := embedIPv4(ipNet, ip4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if extracted, ok := extractIPv4(ipNet, embedded); !ok || !extracted.Equal(ip4) {
+			t.Errorf("Expected %s to give %s back under %s, got %v", embedded, ip4, prefix, extracted)
+		}
+	}
+	_, wellKnown, _ := net.ParseCIDR("64:ff9b::/96")
+	if _, ok := extractIPv4(wellKnown, net.ParseIP("2001:db8::1")); ok {
+		t.Error("Expected an address outside the prefix not to embed an IPv4 address")
+	}
+}
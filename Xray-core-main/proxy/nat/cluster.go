@@ -0,0 +1,146 @@
+package nat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+)
+
+// defaultClusterReplicas is the number of ring points of a node; more points spread the
+// virtual addresses more evenly
+const defaultClusterReplicas = 100
+
+// ringHash places a key on the hash ring. Addresses differ in few bits, so a hash with good
+// avalanche is needed to spread them.
+func ringHash(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// hashRing assigns keys to nodes by consistent hashing, so adding or removing a node only
+// moves the keys of that node
+type hashRing struct {
+	points []uint32 // Sorted ring positions
+	owners []string // Node of each position
+}
+
+func newHashRing(nodes []string, replicas int) *hashRing {
+	type point struct {
+		hash uint32
+		node string
+	}
+	points := make([]point, 0, len(nodes)*replicas)
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{ringHash(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].node < points[j].node
+	})
+
+	r := &hashRing{points: make([]uint32, len(points)), owners: make([]string, len(points))}
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.hash, p.node
+	}
+	return r
+}
+
+// owner returns the node owning key: the first one clockwise from its hash
+func (r *hashRing) owner(key string) string {
+	hash := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// cluster is the compiled cluster configuration of a node
+type cluster struct {
+	nodeID    string
+	ring      *hashRing
+	outbounds map[string]string // Node ID -> outbound tag towards it
+}
+
+func newCluster(config *Cluster) (*cluster, error) {
+	if config.NodeId == "" {
+		return nil, errors.New("cluster node ID is required")
+	}
+	c := &cluster{nodeID: config.NodeId, outbounds: make(map[string]string, len(config.Nodes))}
+	nodes := make([]string, 0, len(config.Nodes))
+	self := false
+	for _, node := range config.Nodes {
+		if node.Id == "" {
+			return nil, errors.New("cluster node without ID")
+		}
+		if _, duplicate := c.outbounds[node.Id]; duplicate {
+			return nil, errors.New("duplicate cluster node ", node.Id)
+		}
+		if node.Id == config.NodeId {
+			self = true
+		} else if node.OutboundTag == "" {
+			return nil, errors.New("cluster node ", node.Id, " needs an outboundTag")
+		}
+		c.outbounds[node.Id] = node.OutboundTag
+		nodes = append(nodes, node.Id)
+	}
+	if !self {
+		return nil, errors.New("cluster node ", config.NodeId, " is not one of the nodes")
+	}
+
+	replicas := int(config.Replicas)
+	if replicas == 0 {
+		replicas = defaultClusterReplicas
+	}
+	c.ring = newHashRing(nodes, replicas)
+	return c, nil
+}
+
+// ValidateCluster checks the node list of a cluster configuration
+func ValidateCluster(config *Cluster) error {
+	_, err := newCluster(config)
+	return err
+}
+
+// clusterOwner returns the node owning the mappings of a virtual destination and whether
+// it is this node. Every node is its own owner when clustering is disabled.
+func (h *Handler) clusterOwner(destination xnet.Destination) (string, bool) {
+	if h.cluster == nil {
+		return "", true
+	}
+	owner := h.cluster.ring.owner(destination.Address.String())
+	return owner, owner == h.cluster.nodeID
+}
+
+// forwardToNode hands a flow over to the outbound towards the node owning its virtual
+// destination, which translates it. The destination is left untranslated.
+func (h *Handler) forwardToNode(ctx context.Context, link *transport.Link, destination xnet.Destination, node string) error {
+	tag := h.cluster.outbounds[node]
+	if h.outboundManager == nil {
+		return errors.New("no outbound manager to forward to cluster node ", node)
+	}
+	handler := h.outboundManager.GetHandler(tag)
+	if handler == nil {
+		return errors.New("failed to get outbound handler with tag ", tag, " of cluster node ", node)
+	}
+
+	errors.LogDebug(ctx, "NAT: forwarding ", destination, " to cluster node ", node, " via ", tag)
+	outbounds := session.OutboundsFromContext(ctx)
+	ctx = session.ContextWithOutbounds(ctx, append(outbounds, &session.Outbound{
+		Target: destination,
+		Tag:    tag,
+	}))
+	handler.Dispatch(ctx, link)
+	return nil
+}
@@ -0,0 +1,114 @@
+package nat
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/transport"
+)
+
+// fakeOutbounds serves recording outbound handlers by tag
+type fakeOutbounds struct {
+	outbound.Manager
+	handlers map[string]*recordingOutbound
+}
+
+func (m *fakeOutbounds) GetHandler(tag string) outbound.Handler {
+	if handler, found := m.handlers[tag]; found {
+		return handler
+	}
+	return nil
+}
+
+type recordingOutbound struct {
+	outbound.Handler
+	targets []xnet.Destination
+}
+
+func (o *recordingOutbound) Dispatch(ctx context.Context, link *transport.Link) {
+	outbounds := session.OutboundsFromContext(ctx)
+	o.targets = append(o.targets, outbounds[len(outbounds)-1].Target)
+}
+
+func TestHashRing(t *testing.T) {
+	keys := make([]string, 3000)
+	for i := range keys {
+		keys[i] = "240.0." + strconv.Itoa(i/250) + "." + strconv.Itoa(i%250)
+	}
+
+	ring := newHashRing([]string{"a", "b", "c"}, defaultClusterReplicas)
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[ring.owner(key)]++
+	}
+	for node, count := range counts {
+		if count < len(keys)/5 || count > len(keys)/2 {
+			t.Errorf("Node %s owns %d of %d keys", node, count, len(keys))
+		}
+	}
+
+	// Removing a node only moves its own keys
+	smaller := newHashRing([]string{"a", "b"}, defaultClusterReplicas)
+	for _, key := range keys {
+		if before := ring.owner(key); before != "c" && smaller.owner(key) != before {
+			t.Fatalf("Key %s moved from %s without its node leaving", key, before)
+		}
+	}
+}
+
+func TestClusterForwarding(t *testing.T) {
+	nodeB := &recordingOutbound{}
+	handler := New()
+	defer handler.Close()
+	handler.outboundManager = &fakeOutbounds{handlers: map[string]*recordingOutbound{"to-b": nodeB}}
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.0.0.0/8", RealNetwork: "192.168.0.0/16"}},
+		Cluster: &Cluster{
+			NodeId: "a",
+			Nodes:  []*ClusterNode{{Id: "a"}, {Id: "b", OutboundTag: "to-b"}},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var remote xnet.Destination
+	for i := 1; i < 255; i++ {
+		dest := xnet.TCPDestination(xnet.ParseAddress("240.0.0."+strconv.Itoa(i)), 80)
+		if node, local := handler.clusterOwner(dest); !local && node == "b" {
+			remote = dest
+			break
+		}
+	}
+	if remote.Address == nil {
+		t.Fatal("Expected node b to own some addresses")
+	}
+
+	ctx := session.ContextWithOutbounds(context.Background(), []*session.Outbound{{Target: remote}})
+	if err := handler.Process(ctx, &transport.Link{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodeB.targets) != 1 || nodeB.targets[0] != remote {
+		t.Errorf("Expected the flow to be forwarded untranslated to node b, got %v", nodeB.targets)
+	}
+	if handler.sessions.Len() != 0 {
+		t.Error("Forwarded flows must not create sessions on this node")
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	for _, config := range []*Cluster{
+		{Nodes: []*ClusterNode{{Id: "a"}}},
+		{NodeId: "a", Nodes: []*ClusterNode{{Id: "b", OutboundTag: "to-b"}}},
+		{NodeId: "a", Nodes: []*ClusterNode{{Id: "a"}, {Id: "b"}}},
+		{NodeId: "a", Nodes: []*ClusterNode{{Id: "a"}, {Id: "a"}}},
+	} {
+		if ValidateCluster(config) == nil {
+			t.Errorf("Expected cluster %v to be invalid", config)
+		}
+	}
+}
@@ -0,0 +1,841 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/nat"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	status "google.golang.org/grpc/status"
+)
+
+// natServer is an implementation of NATService.
+type natServer struct {
+	ohm outbound.Manager
+}
+
+func NewNATServer(ohm outbound.Manager) NATServiceServer {
+	return &natServer{ohm: ohm}
+}
+
+// natHandler returns the NAT outbound of an outbound handler, if it is one
+func natHandler(handler outbound.Handler) (*nat.Handler, bool) {
+	getOutbound, ok := handler.(proxy.GetOutbound)
+	if !ok {
+		return nil, false
+	}
+	natOutbound, ok := getOutbound.GetOutbound().(*nat.Handler)
+	return natOutbound, ok
+}
+
+// natHandlers returns the NAT outbounds by tag, the one of tag when it is not empty
+func (s *natServer) natHandlers(ctx context.Context, tag string) (map[string]*nat.Handler, error) {
+	handlers := make(map[string]*nat.Handler)
+	if tag != "" {
+		handler := s.ohm.GetHandler(tag)
+		if handler == nil {
+			return nil, status.Error(codes.NotFound, tag+" not found.")
+		}
+		natOutbound, ok := natHandler(handler)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, tag+" is not a NAT outbound.")
+		}
+		handlers[tag] = natOutbound
+		return handlers, nil
+	}
+	for _, handler := range s.ohm.ListHandlers(ctx) {
+		if natOutbound, ok := natHandler(handler); ok {
+			handlers[handler.Tag()] = natOutbound
+		}
+	}
+	return handlers, nil
+}
+
+// sortedTags returns the tags of handlers in order, so responses list the outbounds stably
+func sortedTags(handlers map[string]*nat.Handler) []string {
+	tags := make([]string, 0, len(handlers))
+	for tag := range handlers {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (s *natServer) ListMappings(ctx context.Context, request *ListMappingsRequest) (*ListMappingsResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &ListMappingsResponse{}
+	for _, tag := range sortedTags(handlers) {
+		for _, m := range handlers[tag].Mappings() {
+			response.Mappings = append(response.Mappings, &Mapping{
+				Tag:       tag,
+				SessionId: m.SessionID,
+				Protocol:  m.Protocol,
+				Network:   m.Internal.Network.SystemString(),
+				Internal:  m.Internal.NetAddr(),
+				External:  m.External.NetAddr(),
+				Expires:   m.Expires.Unix(),
+			})
+		}
+	}
+	return response, nil
+}
+
+// natHandlerOf returns the NAT outbound of tag, or the only NAT outbound when tag is empty
+func (s *natServer) natHandlerOf(ctx context.Context, tag string) (string, *nat.Handler, error) {
+	handlers, err := s.natHandlers(ctx, tag)
+	if err != nil {
+		return "", nil, err
+	}
+	switch len(handlers) {
+	case 0:
+		return "", nil, status.Error(codes.NotFound, "no NAT outbound")
+	case 1:
+		for tag, handler := range handlers {
+			return tag, handler, nil
+		}
+	}
+	return "", nil, status.Error(codes.InvalidArgument, "a tag is required with several NAT outbounds")
+}
+
+// parseIP parses an address of a request; it is required unless optional is set
+func parseIP(field, address string, optional bool) (net.IP, error) {
+	if address == "" && optional {
+		return nil, nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid "+field+" "+address)
+	}
+	return ip, nil
+}
+
+// actorOf names the client of a request in the audit log
+func actorOf(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return "api " + p.Addr.String()
+	}
+	return "api"
+}
+
+// errorOf is the message of err for the audit log, empty when nil
+func errorOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func leaseOf(tag string, lease nat.AddressLease) *Lease {
+	l := &Lease{
+		Tag:            tag,
+		VirtualAddress: lease.Virtual.String(),
+		RealAddress:    lease.Real.String(),
+	}
+	if !lease.Expires.IsZero() {
+		l.Expires = lease.Expires.Unix()
+	}
+	return l
+}
+
+func (s *natServer) AllocateAddress(ctx context.Context, request *AllocateAddressRequest) (*AllocateAddressResponse, error) {
+	tag, handler, err := s.natHandlerOf(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	real, err := parseIP("real address", request.RealAddress, false)
+	if err != nil {
+		return nil, err
+	}
+	virtual, err := parseIP("virtual address", request.VirtualAddress, true)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := handler.AllocateAddress(real, virtual, time.Duration(request.Ttl)*time.Second)
+	leased := request.VirtualAddress
+	if err == nil {
+		leased = lease.Virtual.String()
+	}
+	handler.Audit(&nat.AuditEvent{
+		Action: nat.AuditAllocateAddress,
+		Actor:  actorOf(ctx),
+		Target: request.RealAddress,
+		Detail: fmt.Sprint("virtual=", leased, " ttl=", request.Ttl),
+		Error:  errorOf(err),
+	})
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &AllocateAddressResponse{Lease: leaseOf(tag, lease)}, nil
+}
+
+func (s *natServer) RenewLease(ctx context.Context, request *RenewLeaseRequest) (*RenewLeaseResponse, error) {
+	tag, handler, err := s.natHandlerOf(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	virtual, err := parseIP("virtual address", request.VirtualAddress, false)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := handler.RenewLease(virtual, time.Duration(request.Ttl)*time.Second)
+	handler.Audit(&nat.AuditEvent{
+		Action: nat.AuditRenewLease,
+		Actor:  actorOf(ctx),
+		Target: request.VirtualAddress,
+		Detail: fmt.Sprint("ttl=", request.Ttl),
+		Error:  errorOf(err),
+	})
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &RenewLeaseResponse{Lease: leaseOf(tag, lease)}, nil
+}
+
+func (s *natServer) ReleaseAddress(ctx context.Context, request *ReleaseAddressRequest) (*ReleaseAddressResponse, error) {
+	_, handler, err := s.natHandlerOf(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	virtual, err := parseIP("virtual address", request.VirtualAddress, false)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.ReleaseAddress(virtual)
+	handler.Audit(&nat.AuditEvent{Action: nat.AuditReleaseAddress, Actor: actorOf(ctx), Target: request.VirtualAddress, Error: errorOf(err)})
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &ReleaseAddressResponse{}, nil
+}
+
+func (s *natServer) ListLeases(ctx context.Context, request *ListLeasesRequest) (*ListLeasesResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &ListLeasesResponse{}
+	for _, tag := range sortedTags(handlers) {
+		for _, lease := range handlers[tag].AddressLeases() {
+			response.Leases = append(response.Leases, leaseOf(tag, lease))
+		}
+	}
+	return response, nil
+}
+
+func (s *natServer) GetRuleStats(ctx context.Context, request *GetRuleStatsRequest) (*GetRuleStatsResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &GetRuleStatsResponse{}
+	for _, tag := range sortedTags(handlers) {
+		var tagged map[string]bool
+		if request.RuleTag != "" {
+			tagged = handlers[tag].TaggedRuleIDs(request.RuleTag)
+		}
+		for _, rule := range handlers[tag].RuleStats() {
+			if tagged != nil && !tagged[rule.RuleID] {
+				continue
+			}
+			stats := &RuleStats{
+				Tag:            tag,
+				RuleId:         rule.RuleID,
+				Tags:           rule.Tags,
+				Hits:           rule.Hits,
+				ActiveSessions: rule.ActiveSessions,
+				Bytes:          rule.Bytes,
+				ShapingDrops:   rule.ShapingDrops,
+				CapRefused:     rule.CapRefused,
+				OversizeDrops:  rule.OversizeDrops,
+			}
+			if !rule.LastHit.IsZero() {
+				stats.LastHit = rule.LastHit.Unix()
+			}
+			response.Stats = append(response.Stats, stats)
+		}
+	}
+	return response, nil
+}
+
+func endpointOf(destination xnet.Destination) string {
+	if destination.Address == nil {
+		return ""
+	}
+	return destination.NetAddr()
+}
+
+func (s *natServer) ListSessions(ctx context.Context, request *ListSessionsRequest) (*ListSessionsResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &ListSessionsResponse{}
+	for _, tag := range sortedTags(handlers) {
+		for _, session := range handlers[tag].Sessions() {
+			if request.RuleId != "" && session.RuleID != request.RuleId {
+				continue
+			}
+			stats := session.Stats()
+			response.Sessions = append(response.Sessions, &Session{
+				Tag:                tag,
+				SessionId:          session.SessionID,
+				RuleId:             session.RuleID,
+				Network:            strings.ToLower(session.Protocol),
+				Direction:          session.Direction,
+				VirtualSource:      endpointOf(session.VirtualSource),
+				VirtualDestination: endpointOf(session.VirtualDest),
+				RealSource:         endpointOf(session.RealSource),
+				RealDestination:    endpointOf(session.RealDest),
+				Domain:             session.Domain,
+				Created:            session.CreatedAt.Unix(),
+				UplinkBytes:        stats.UplinkBytes,
+				DownlinkBytes:      stats.DownlinkBytes,
+			})
+		}
+	}
+	return response, nil
+}
+
+func (s *natServer) ExportSessions(ctx context.Context, request *ExportSessionsRequest) (*ExportSessionsResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	selected := func(record *SessionRecord) bool {
+		return (request.RuleId == "" || record.RuleID == request.RuleId) &&
+			(request.Network == "" || record.Network == request.Network) &&
+			now.Unix()-record.Created >= int64(request.MinAge)
+	}
+	snapshot := &SessionSnapshot{Taken: now.Unix(), Sessions: []*SessionRecord{}}
+	for _, tag := range sortedTags(handlers) {
+		for _, session := range handlers[tag].Sessions() {
+			stats := session.Stats()
+			record := &SessionRecord{
+				Tag:                tag,
+				SessionID:          session.SessionID,
+				RuleID:             session.RuleID,
+				Network:            strings.ToLower(session.Protocol),
+				Direction:          session.Direction,
+				VirtualSource:      endpointOf(session.VirtualSource),
+				VirtualDestination: endpointOf(session.VirtualDest),
+				RealSource:         endpointOf(session.RealSource),
+				RealDestination:    endpointOf(session.RealDest),
+				Domain:             session.Domain,
+				Created:            session.CreatedAt.Unix(),
+				UplinkBytes:        stats.UplinkBytes,
+				DownlinkBytes:      stats.DownlinkBytes,
+			}
+			if selected(record) {
+				snapshot.Sessions = append(snapshot.Sessions, record)
+			}
+		}
+	}
+
+	if len(request.Baseline) > 0 {
+		baseline, err := ParseSessionSnapshot(request.Baseline)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		// The baseline was exported with the same filter, or takes it now
+		kept := baseline.Sessions[:0]
+		for _, record := range baseline.Sessions {
+			if (request.Tag == "" || record.Tag == request.Tag) && selected(record) {
+				kept = append(kept, record)
+			}
+		}
+		baseline.Sessions = kept
+		snapshot = snapshot.Diff(baseline)
+	}
+	data, err := snapshot.Encode(request.Format)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &ExportSessionsResponse{Data: data, Sessions: int64(len(snapshot.Sessions))}, nil
+}
+
+// watchBatch is the most events of a SessionEvents message, and how many a watch buffers
+const watchBatch = 100
+
+func sessionEventOf(tag string, event *nat.SessionEvent) *SessionEvent {
+	return &SessionEvent{
+		Tag:                tag,
+		Event:              event.Event,
+		Reason:             event.Reason,
+		Time:               event.Time.UnixMilli(),
+		SessionId:          event.SessionID,
+		RuleId:             event.RuleID,
+		Network:            event.Protocol,
+		VirtualSource:      event.VirtualSource,
+		VirtualDestination: event.VirtualDest,
+		RealSource:         event.RealSource,
+		RealDestination:    event.RealDest,
+		Domain:             event.Domain,
+		UplinkBytes:        event.UplinkBytes,
+		DownlinkBytes:      event.DownlinkBytes,
+		Duration:           event.Duration,
+	}
+}
+
+func (s *natServer) WatchSessionEvents(request *WatchSessionEventsRequest, stream grpc.ServerStreamingServer[SessionEvents]) error {
+	ctx := stream.Context()
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return err
+	}
+	events := make(chan *SessionEvent, watchBatch)
+	var wg sync.WaitGroup
+	for tag, handler := range handlers {
+		watch, stop := handler.WatchSessionEvents(watchBatch)
+		defer stop()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range watch {
+				if request.RuleId != "" && event.RuleID != request.RuleId {
+					continue
+				}
+				select {
+				case events <- sessionEventOf(tag, event):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	// The stream ends once every watched outbound closed
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	for {
+		var batch []*SessionEvent
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			batch = append(batch, event)
+		case <-ctx.Done():
+			return nil
+		}
+	collect:
+		for len(batch) < watchBatch {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, event)
+			default:
+				break collect
+			}
+		}
+		if err := stream.Send(&SessionEvents{Events: batch}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *natServer) FlushSessions(ctx context.Context, request *FlushSessionsRequest) (*FlushSessionsResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	if request.RuleId != "" && request.RuleTag != "" {
+		return nil, status.Error(codes.InvalidArgument, "rule_id and rule_tag are mutually exclusive")
+	}
+	response := &FlushSessionsResponse{}
+	for _, handler := range handlers {
+		var flushed int
+		target := request.RuleId
+		if request.RuleTag != "" {
+			flushed, target = handler.FlushTaggedSessions(request.RuleTag), "tag:"+request.RuleTag
+		} else {
+			flushed = handler.FlushSessions(request.RuleId)
+		}
+		handler.Audit(&nat.AuditEvent{Action: nat.AuditFlushSessions, Actor: actorOf(ctx), Target: target, Detail: fmt.Sprint("flushed=", flushed)})
+		response.Flushed += int64(flushed)
+	}
+	return response, nil
+}
+
+func (s *natServer) ListSourceUsage(ctx context.Context, request *ListSourceUsageRequest) (*ListSourceUsageResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &ListSourceUsageResponse{}
+	for _, tag := range sortedTags(handlers) {
+		for _, usage := range handlers[tag].SourceUsage() {
+			response.Sources = append(response.Sources, &SourceUsage{
+				Tag:      tag,
+				Source:   usage.Source,
+				Sessions: uint32(usage.Sessions),
+				Tokens:   usage.Tokens,
+				Refused:  usage.Refused,
+			})
+		}
+	}
+	return response, nil
+}
+
+func (s *natServer) ListRules(ctx context.Context, request *ListRulesRequest) (*ListRulesResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &ListRulesResponse{}
+	for _, tag := range sortedTags(handlers) {
+		handler := handlers[tag]
+		rules, expiry := handler.Rules(), handler.RuleExpiry
+		if request.Expired {
+			expiredAt := make(map[*nat.NATRule]time.Time)
+			rules = nil
+			for _, expired := range handler.ExpiredRules() {
+				rules = append(rules, expired.Rule)
+				expiredAt[expired.Rule] = expired.ExpiredAt
+			}
+			expiry = func(rule *nat.NATRule) time.Time { return expiredAt[rule] }
+		}
+		for _, rule := range rules {
+			if request.RuleTag != "" && !slices.Contains(rule.Tags, request.RuleTag) {
+				continue
+			}
+			realDestinations := rule.RealDestinations
+			if len(realDestinations) == 0 && rule.RealDestination != "" {
+				realDestinations = []string{rule.RealDestination}
+			}
+			r := &Rule{
+				Tag:                tag,
+				RuleId:             rule.RuleId,
+				SourceSite:         rule.SourceSite,
+				VirtualDestination: rule.VirtualDestination,
+				RealDestinations:   realDestinations,
+				Protocol:           rule.Protocol,
+				Ports:              rule.Ports,
+				Action:             rule.Action,
+				Tags:               rule.Tags,
+				Disabled:           handler.RuleDisabled(rule),
+			}
+			if expires := expiry(rule); !expires.IsZero() {
+				r.ExpiresAt = expires.Unix()
+			}
+			response.Rules = append(response.Rules, r)
+		}
+	}
+	return response, nil
+}
+
+// parseEndpoint parses a host:port endpoint of a request; it is required unless optional is set
+func parseEndpoint(field string, network xnet.Network, endpoint string, optional bool) (xnet.Destination, error) {
+	if endpoint == "" && optional {
+		return xnet.Destination{}, nil
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return xnet.Destination{}, status.Error(codes.InvalidArgument, "invalid "+field+" "+endpoint)
+	}
+	p, err := xnet.PortFromString(port)
+	if err != nil {
+		return xnet.Destination{}, status.Error(codes.InvalidArgument, "invalid "+field+" port "+port)
+	}
+	return xnet.Destination{Network: network, Address: xnet.ParseAddress(host), Port: p}, nil
+}
+
+// parseNetwork parses the network of a flow, tcp when empty
+func parseNetwork(network string) (xnet.Network, error) {
+	switch strings.ToLower(network) {
+	case "", "tcp":
+		return xnet.Network_TCP, nil
+	case "udp":
+		return xnet.Network_UDP, nil
+	default:
+		return 0, status.Error(codes.InvalidArgument, "invalid network "+network)
+	}
+}
+
+func (s *natServer) TestTranslation(ctx context.Context, request *TestTranslationRequest) (*TestTranslationResponse, error) {
+	_, handler, err := s.natHandlerOf(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	network, err := parseNetwork(request.Network)
+	if err != nil {
+		return nil, err
+	}
+	source, err := parseEndpoint("source", network, request.Source, true)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseEndpoint("destination", network, request.Destination, false)
+	if err != nil {
+		return nil, err
+	}
+
+	translation, err := handler.TestTranslation(source, destination)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	response := &TestTranslationResponse{VirtualDestination: endpointOf(translation.VirtualDestination)}
+	if translation.Rule != nil {
+		response.Matched = true
+		response.RuleId = translation.Rule.RuleId
+		response.RealDestination = endpointOf(translation.RealDestination)
+		response.RealSource = endpointOf(translation.RealSource)
+		response.Hairpin = translation.Hairpin
+		response.Action = translation.Rule.Action
+	}
+	if mapping := translation.PortMapping; mapping != nil {
+		response.OriginalPort = mapping.OriginalPort
+		response.TranslatedPort = mapping.TranslatedPort
+	}
+	return response, nil
+}
+
+func (s *natServer) Simulate(ctx context.Context, request *SimulateRequest) (*SimulateResponse, error) {
+	_, handler, err := s.natHandlerOf(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	flows := make([]nat.SimulatedFlow, len(request.Flows))
+	for i, flow := range request.Flows {
+		network, err := parseNetwork(flow.Network)
+		if err != nil {
+			return nil, err
+		}
+		if flows[i].Source, err = parseEndpoint("source", network, flow.Source, true); err != nil {
+			return nil, err
+		}
+		if flows[i].Destination, err = parseEndpoint("destination", network, flow.Destination, false); err != nil {
+			return nil, err
+		}
+	}
+
+	simulation, err := handler.Simulate(request.Rules, flows)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	response := &SimulateResponse{}
+	for i, result := range simulation.Flows {
+		response.Flows = append(response.Flows, &FlowSimulation{
+			Flow:            request.Flows[i],
+			Outcome:         result.Outcome,
+			RuleId:          result.RuleID,
+			RealDestination: endpointOf(result.Translation.RealDestination),
+			RealSource:      endpointOf(result.Translation.RealSource),
+			Contested:       result.Contested,
+			ActiveOutcome:   result.ActiveOutcome,
+			ActiveRuleId:    result.ActiveRuleID,
+			Changed:         result.Changed,
+			Error:           errorOf(result.Err),
+		})
+	}
+	for _, rule := range simulation.Rules {
+		response.Rules = append(response.Rules, &RuleSimulation{RuleId: rule.RuleID, Flows: int64(rule.Flows), Conflicts: conflictsOf(rule.Conflicts)})
+	}
+	return response, nil
+}
+
+func drainStatusOf(tag string, drain nat.DrainStatus) *DrainStatus {
+	status := &DrainStatus{
+		Tag:      tag,
+		Draining: drain.Draining,
+		Action:   drain.Action,
+		Sessions: drain.Sessions,
+	}
+	if drain.Draining {
+		status.Since = drain.Since.Unix()
+	}
+	if !drain.Deadline.IsZero() {
+		status.Deadline = drain.Deadline.Unix()
+	}
+	return status
+}
+
+func (s *natServer) Drain(ctx context.Context, request *DrainRequest) (*DrainResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	action := request.Action
+	if action == "" {
+		action = nat.DrainReject
+	}
+	response := &DrainResponse{}
+	for _, tag := range sortedTags(handlers) {
+		drain, err := handlers[tag].Drain(action, time.Duration(request.Deadline)*time.Second)
+		handlers[tag].Audit(&nat.AuditEvent{
+			Action: nat.AuditDrain,
+			Actor:  actorOf(ctx),
+			Detail: fmt.Sprint("action=", action, " deadline=", request.Deadline, " sessions=", drain.Sessions),
+			Error:  errorOf(err),
+		})
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		response.Status = append(response.Status, drainStatusOf(tag, drain))
+	}
+	return response, nil
+}
+
+func (s *natServer) Resume(ctx context.Context, request *ResumeRequest) (*ResumeResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &ResumeResponse{}
+	for _, tag := range sortedTags(handlers) {
+		drain := handlers[tag].Resume()
+		handlers[tag].Audit(&nat.AuditEvent{Action: nat.AuditResume, Actor: actorOf(ctx), Detail: fmt.Sprint("sessions=", drain.Sessions)})
+		response.Status = append(response.Status, drainStatusOf(tag, drain))
+	}
+	return response, nil
+}
+
+func (s *natServer) GetDrainStatus(ctx context.Context, request *GetDrainStatusRequest) (*GetDrainStatusResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &GetDrainStatusResponse{}
+	for _, tag := range sortedTags(handlers) {
+		response.Status = append(response.Status, drainStatusOf(tag, handlers[tag].DrainStatus()))
+	}
+	return response, nil
+}
+
+// setRulesEnabled disables or enables the rules of a DisableRules or EnableRules request
+func (s *natServer) setRulesEnabled(ctx context.Context, outboundTag, ruleID, ruleTag string, enable bool) (int64, error) {
+	handlers, err := s.natHandlers(ctx, outboundTag)
+	if err != nil {
+		return 0, err
+	}
+	action, target := nat.AuditDisableRules, ruleID
+	if enable {
+		action = nat.AuditEnableRules
+	}
+	if ruleTag != "" {
+		target = "tag:" + ruleTag
+	}
+	var rules int64
+	for _, tag := range sortedTags(handlers) {
+		set := handlers[tag].DisableRules
+		if enable {
+			set = handlers[tag].EnableRules
+		}
+		matched, err := set(ruleID, ruleTag)
+		handlers[tag].Audit(&nat.AuditEvent{Action: action, Actor: actorOf(ctx), Target: target, Detail: fmt.Sprint("rules=", matched), Error: errorOf(err)})
+		if err != nil {
+			return 0, status.Error(codes.InvalidArgument, err.Error())
+		}
+		rules += int64(matched)
+	}
+	return rules, nil
+}
+
+func (s *natServer) DisableRules(ctx context.Context, request *DisableRulesRequest) (*DisableRulesResponse, error) {
+	rules, err := s.setRulesEnabled(ctx, request.Tag, request.RuleId, request.RuleTag, false)
+	if err != nil {
+		return nil, err
+	}
+	return &DisableRulesResponse{Rules: rules}, nil
+}
+
+func (s *natServer) EnableRules(ctx context.Context, request *EnableRulesRequest) (*EnableRulesResponse, error) {
+	rules, err := s.setRulesEnabled(ctx, request.Tag, request.RuleId, request.RuleTag, true)
+	if err != nil {
+		return nil, err
+	}
+	return &EnableRulesResponse{Rules: rules}, nil
+}
+
+func conflictsOf(conflicts []nat.RuleConflict) []*RuleConflict {
+	result := make([]*RuleConflict, len(conflicts))
+	for i, conflict := range conflicts {
+		result[i] = &RuleConflict{Kind: conflict.Kind, RuleId: conflict.RuleID, Detail: conflict.Detail}
+	}
+	return result
+}
+
+func (s *natServer) AddRule(ctx context.Context, request *AddRuleRequest) (*AddRuleResponse, error) {
+	if request.Rule == nil {
+		return nil, status.Error(codes.InvalidArgument, "a rule is required")
+	}
+	_, handler, err := s.natHandlerOf(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	err = handler.AddRule(request.Rule)
+	handler.Audit(&nat.AuditEvent{Action: nat.AuditAddRule, Actor: actorOf(ctx), Target: request.Rule.RuleId, Error: errorOf(err)})
+	var conflict *nat.RuleConflictError
+	switch {
+	case errors.As(err, &conflict):
+		return &AddRuleResponse{Conflicts: conflictsOf(conflict.Conflicts)}, nil
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &AddRuleResponse{Added: true}, nil
+}
+
+func (s *natServer) SelfTest(ctx context.Context, request *SelfTestRequest) (*SelfTestResponse, error) {
+	handlers, err := s.natHandlers(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &SelfTestResponse{}
+	for _, tag := range sortedTags(handlers) {
+		result := &SelfTestResult{Tag: tag, ComplianceMode: handlers[tag].ComplianceMode(), Passed: true}
+		for _, check := range handlers[tag].SelfTest(ctx) {
+			result.Passed = result.Passed && check.Passed
+			result.Checks = append(result.Checks, &ComplianceCheck{
+				Requirement: check.Requirement,
+				Description: check.Description,
+				Passed:      check.Passed,
+				Detail:      check.Detail,
+			})
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response, nil
+}
+
+func (s *natServer) mustEmbedUnimplementedNATServiceServer() {}
+
+type service struct {
+	ohm outbound.Manager
+}
+
+func (s *service) Register(server *grpc.Server) {
+	RegisterNATServiceServer(server, NewNATServer(s.ohm))
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, cfg interface{}) (interface{}, error) {
+		s := new(service)
+
+		core.RequireFeatures(ctx, func(om outbound.Manager) {
+			s.ohm = om
+		})
+
+		return s, nil
+	}))
+}
@@ -0,0 +1,4102 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v6.33.0
+// source: command.proto
+
+package command
+
+import (
+	nat "github.com/xtls/xray-core/proxy/nat"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ListMappingsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMappingsRequest) Reset() {
+	*x = ListMappingsRequest{}
+	mi := &file_command_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMappingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMappingsRequest) ProtoMessage() {}
+
+func (x *ListMappingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMappingsRequest.ProtoReflect.Descriptor instead.
+func (*ListMappingsRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ListMappingsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type Mapping struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound holding the mapping.
+	Tag       string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	SessionId string `protobuf:"bytes,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Port control protocol that opened the mapping: pcp, natpmp or upnp.
+	Protocol string `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// tcp or udp.
+	Network string `protobuf:"bytes,4,opt,name=network,proto3" json:"network,omitempty"`
+	// Endpoint of the client behind the NAT.
+	Internal string `protobuf:"bytes,5,opt,name=internal,proto3" json:"internal,omitempty"`
+	// External endpoint relayed to the client.
+	External string `protobuf:"bytes,6,opt,name=external,proto3" json:"external,omitempty"`
+	// Unix time in seconds the mapping expires at unless refreshed.
+	Expires       int64 `protobuf:"varint,7,opt,name=expires,proto3" json:"expires,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Mapping) Reset() {
+	*x = Mapping{}
+	mi := &file_command_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Mapping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Mapping) ProtoMessage() {}
+
+func (x *Mapping) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Mapping.ProtoReflect.Descriptor instead.
+func (*Mapping) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Mapping) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *Mapping) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *Mapping) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Mapping) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *Mapping) GetInternal() string {
+	if x != nil {
+		return x.Internal
+	}
+	return ""
+}
+
+func (x *Mapping) GetExternal() string {
+	if x != nil {
+		return x.External
+	}
+	return ""
+}
+
+func (x *Mapping) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+type ListMappingsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mappings      []*Mapping             `protobuf:"bytes,1,rep,name=mappings,proto3" json:"mappings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMappingsResponse) Reset() {
+	*x = ListMappingsResponse{}
+	mi := &file_command_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMappingsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMappingsResponse) ProtoMessage() {}
+
+func (x *ListMappingsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMappingsResponse.ProtoReflect.Descriptor instead.
+func (*ListMappingsResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListMappingsResponse) GetMappings() []*Mapping {
+	if x != nil {
+		return x.Mappings
+	}
+	return nil
+}
+
+type Lease struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound holding the lease.
+	Tag            string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	VirtualAddress string `protobuf:"bytes,2,opt,name=virtual_address,json=virtualAddress,proto3" json:"virtual_address,omitempty"`
+	RealAddress    string `protobuf:"bytes,3,opt,name=real_address,json=realAddress,proto3" json:"real_address,omitempty"`
+	// Unix time in seconds the lease expires at unless renewed, 0 when it never expires.
+	Expires       int64 `protobuf:"varint,4,opt,name=expires,proto3" json:"expires,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Lease) Reset() {
+	*x = Lease{}
+	mi := &file_command_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Lease) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Lease) ProtoMessage() {}
+
+func (x *Lease) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Lease.ProtoReflect.Descriptor instead.
+func (*Lease) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Lease) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *Lease) GetVirtualAddress() string {
+	if x != nil {
+		return x.VirtualAddress
+	}
+	return ""
+}
+
+func (x *Lease) GetRealAddress() string {
+	if x != nil {
+		return x.RealAddress
+	}
+	return ""
+}
+
+func (x *Lease) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+type AllocateAddressRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, may be empty when there is only one.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Real host the virtual address is leased to.
+	RealAddress string `protobuf:"bytes,2,opt,name=real_address,json=realAddress,proto3" json:"real_address,omitempty"`
+	// Virtual address requested, the next free one of the pool when empty.
+	VirtualAddress string `protobuf:"bytes,3,opt,name=virtual_address,json=virtualAddress,proto3" json:"virtual_address,omitempty"`
+	// Seconds the lease lasts, the lease time of the pool when 0.
+	Ttl           uint32 `protobuf:"varint,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AllocateAddressRequest) Reset() {
+	*x = AllocateAddressRequest{}
+	mi := &file_command_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AllocateAddressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AllocateAddressRequest) ProtoMessage() {}
+
+func (x *AllocateAddressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AllocateAddressRequest.ProtoReflect.Descriptor instead.
+func (*AllocateAddressRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AllocateAddressRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *AllocateAddressRequest) GetRealAddress() string {
+	if x != nil {
+		return x.RealAddress
+	}
+	return ""
+}
+
+func (x *AllocateAddressRequest) GetVirtualAddress() string {
+	if x != nil {
+		return x.VirtualAddress
+	}
+	return ""
+}
+
+func (x *AllocateAddressRequest) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+type AllocateAddressResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lease         *Lease                 `protobuf:"bytes,1,opt,name=lease,proto3" json:"lease,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AllocateAddressResponse) Reset() {
+	*x = AllocateAddressResponse{}
+	mi := &file_command_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AllocateAddressResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AllocateAddressResponse) ProtoMessage() {}
+
+func (x *AllocateAddressResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AllocateAddressResponse.ProtoReflect.Descriptor instead.
+func (*AllocateAddressResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AllocateAddressResponse) GetLease() *Lease {
+	if x != nil {
+		return x.Lease
+	}
+	return nil
+}
+
+type RenewLeaseRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Tag            string                 `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	VirtualAddress string                 `protobuf:"bytes,2,opt,name=virtual_address,json=virtualAddress,proto3" json:"virtual_address,omitempty"`
+	Ttl            uint32                 `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *RenewLeaseRequest) Reset() {
+	*x = RenewLeaseRequest{}
+	mi := &file_command_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenewLeaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewLeaseRequest) ProtoMessage() {}
+
+func (x *RenewLeaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewLeaseRequest.ProtoReflect.Descriptor instead.
+func (*RenewLeaseRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RenewLeaseRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *RenewLeaseRequest) GetVirtualAddress() string {
+	if x != nil {
+		return x.VirtualAddress
+	}
+	return ""
+}
+
+func (x *RenewLeaseRequest) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+type RenewLeaseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lease         *Lease                 `protobuf:"bytes,1,opt,name=lease,proto3" json:"lease,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenewLeaseResponse) Reset() {
+	*x = RenewLeaseResponse{}
+	mi := &file_command_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenewLeaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewLeaseResponse) ProtoMessage() {}
+
+func (x *RenewLeaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewLeaseResponse.ProtoReflect.Descriptor instead.
+func (*RenewLeaseResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RenewLeaseResponse) GetLease() *Lease {
+	if x != nil {
+		return x.Lease
+	}
+	return nil
+}
+
+type ReleaseAddressRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Tag            string                 `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	VirtualAddress string                 `protobuf:"bytes,2,opt,name=virtual_address,json=virtualAddress,proto3" json:"virtual_address,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ReleaseAddressRequest) Reset() {
+	*x = ReleaseAddressRequest{}
+	mi := &file_command_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseAddressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseAddressRequest) ProtoMessage() {}
+
+func (x *ReleaseAddressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseAddressRequest.ProtoReflect.Descriptor instead.
+func (*ReleaseAddressRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ReleaseAddressRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ReleaseAddressRequest) GetVirtualAddress() string {
+	if x != nil {
+		return x.VirtualAddress
+	}
+	return ""
+}
+
+type ReleaseAddressResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseAddressResponse) Reset() {
+	*x = ReleaseAddressResponse{}
+	mi := &file_command_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseAddressResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseAddressResponse) ProtoMessage() {}
+
+func (x *ReleaseAddressResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseAddressResponse.ProtoReflect.Descriptor instead.
+func (*ReleaseAddressResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{9}
+}
+
+type ListLeasesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLeasesRequest) Reset() {
+	*x = ListLeasesRequest{}
+	mi := &file_command_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLeasesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLeasesRequest) ProtoMessage() {}
+
+func (x *ListLeasesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLeasesRequest.ProtoReflect.Descriptor instead.
+func (*ListLeasesRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListLeasesRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type ListLeasesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Leases        []*Lease               `protobuf:"bytes,1,rep,name=leases,proto3" json:"leases,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLeasesResponse) Reset() {
+	*x = ListLeasesResponse{}
+	mi := &file_command_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLeasesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLeasesResponse) ProtoMessage() {}
+
+func (x *ListLeasesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLeasesResponse.ProtoReflect.Descriptor instead.
+func (*ListLeasesResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListLeasesResponse) GetLeases() []*Lease {
+	if x != nil {
+		return x.Leases
+	}
+	return nil
+}
+
+type GetRuleStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Only the rules and virtual ranges carrying this tag when not empty.
+	RuleTag       string `protobuf:"bytes,2,opt,name=rule_tag,json=ruleTag,proto3" json:"rule_tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRuleStatsRequest) Reset() {
+	*x = GetRuleStatsRequest{}
+	mi := &file_command_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRuleStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRuleStatsRequest) ProtoMessage() {}
+
+func (x *GetRuleStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRuleStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetRuleStatsRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetRuleStatsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *GetRuleStatsRequest) GetRuleTag() string {
+	if x != nil {
+		return x.RuleTag
+	}
+	return ""
+}
+
+type RuleStats struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound the rule belongs to.
+	Tag    string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	RuleId string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Flows translated by the rule.
+	Hits           int64 `protobuf:"varint,3,opt,name=hits,proto3" json:"hits,omitempty"`
+	ActiveSessions int64 `protobuf:"varint,4,opt,name=active_sessions,json=activeSessions,proto3" json:"active_sessions,omitempty"`
+	Bytes          int64 `protobuf:"varint,5,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	// Unix time in seconds of the last flow translated, 0 when the rule never matched.
+	LastHit int64 `protobuf:"varint,6,opt,name=last_hit,json=lastHit,proto3" json:"last_hit,omitempty"`
+	// Datagrams dropped over the rate limit of the rule.
+	ShapingDrops int64 `protobuf:"varint,7,opt,name=shaping_drops,json=shapingDrops,proto3" json:"shaping_drops,omitempty"`
+	// Flows refused over the maxSessions of the rule.
+	CapRefused int64 `protobuf:"varint,8,opt,name=cap_refused,json=capRefused,proto3" json:"cap_refused,omitempty"`
+	// Datagrams dropped over the mtu of the rule.
+	OversizeDrops int64 `protobuf:"varint,9,opt,name=oversize_drops,json=oversizeDrops,proto3" json:"oversize_drops,omitempty"`
+	// Tags of the rule or virtual range.
+	Tags          []string `protobuf:"bytes,10,rep,name=tags,proto3" json:"tags,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleStats) Reset() {
+	*x = RuleStats{}
+	mi := &file_command_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleStats) ProtoMessage() {}
+
+func (x *RuleStats) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleStats.ProtoReflect.Descriptor instead.
+func (*RuleStats) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RuleStats) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *RuleStats) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *RuleStats) GetHits() int64 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *RuleStats) GetActiveSessions() int64 {
+	if x != nil {
+		return x.ActiveSessions
+	}
+	return 0
+}
+
+func (x *RuleStats) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *RuleStats) GetLastHit() int64 {
+	if x != nil {
+		return x.LastHit
+	}
+	return 0
+}
+
+func (x *RuleStats) GetShapingDrops() int64 {
+	if x != nil {
+		return x.ShapingDrops
+	}
+	return 0
+}
+
+func (x *RuleStats) GetCapRefused() int64 {
+	if x != nil {
+		return x.CapRefused
+	}
+	return 0
+}
+
+func (x *RuleStats) GetOversizeDrops() int64 {
+	if x != nil {
+		return x.OversizeDrops
+	}
+	return 0
+}
+
+func (x *RuleStats) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type GetRuleStatsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Active rules in match order, followed by other rules that translated flows.
+	Stats         []*RuleStats `protobuf:"bytes,1,rep,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRuleStatsResponse) Reset() {
+	*x = GetRuleStatsResponse{}
+	mi := &file_command_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRuleStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRuleStatsResponse) ProtoMessage() {}
+
+func (x *GetRuleStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRuleStatsResponse.ProtoReflect.Descriptor instead.
+func (*GetRuleStatsResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *GetRuleStatsResponse) GetStats() []*RuleStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type ListSessionsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Only the sessions of this rule when not empty.
+	RuleId        string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSessionsRequest) Reset() {
+	*x = ListSessionsRequest{}
+	mi := &file_command_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSessionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSessionsRequest) ProtoMessage() {}
+
+func (x *ListSessionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSessionsRequest.ProtoReflect.Descriptor instead.
+func (*ListSessionsRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListSessionsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ListSessionsRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+type Session struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound holding the session.
+	Tag       string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	SessionId string `protobuf:"bytes,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	RuleId    string `protobuf:"bytes,3,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// tcp, udp or icmp.
+	Network string `protobuf:"bytes,4,opt,name=network,proto3" json:"network,omitempty"`
+	// inbound, outbound, hairpin, expected or mapping.
+	Direction          string `protobuf:"bytes,5,opt,name=direction,proto3" json:"direction,omitempty"`
+	VirtualSource      string `protobuf:"bytes,6,opt,name=virtual_source,json=virtualSource,proto3" json:"virtual_source,omitempty"`
+	VirtualDestination string `protobuf:"bytes,7,opt,name=virtual_destination,json=virtualDestination,proto3" json:"virtual_destination,omitempty"`
+	RealSource         string `protobuf:"bytes,8,opt,name=real_source,json=realSource,proto3" json:"real_source,omitempty"`
+	RealDestination    string `protobuf:"bytes,9,opt,name=real_destination,json=realDestination,proto3" json:"real_destination,omitempty"`
+	// Domain sniffed from the flow, if any.
+	Domain string `protobuf:"bytes,10,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Unix time in seconds the session was created at.
+	Created       int64 `protobuf:"varint,11,opt,name=created,proto3" json:"created,omitempty"`
+	UplinkBytes   int64 `protobuf:"varint,12,opt,name=uplink_bytes,json=uplinkBytes,proto3" json:"uplink_bytes,omitempty"`
+	DownlinkBytes int64 `protobuf:"varint,13,opt,name=downlink_bytes,json=downlinkBytes,proto3" json:"downlink_bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Session) Reset() {
+	*x = Session{}
+	mi := &file_command_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Session) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Session) ProtoMessage() {}
+
+func (x *Session) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Session.ProtoReflect.Descriptor instead.
+func (*Session) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *Session) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *Session) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *Session) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *Session) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *Session) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *Session) GetVirtualSource() string {
+	if x != nil {
+		return x.VirtualSource
+	}
+	return ""
+}
+
+func (x *Session) GetVirtualDestination() string {
+	if x != nil {
+		return x.VirtualDestination
+	}
+	return ""
+}
+
+func (x *Session) GetRealSource() string {
+	if x != nil {
+		return x.RealSource
+	}
+	return ""
+}
+
+func (x *Session) GetRealDestination() string {
+	if x != nil {
+		return x.RealDestination
+	}
+	return ""
+}
+
+func (x *Session) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *Session) GetCreated() int64 {
+	if x != nil {
+		return x.Created
+	}
+	return 0
+}
+
+func (x *Session) GetUplinkBytes() int64 {
+	if x != nil {
+		return x.UplinkBytes
+	}
+	return 0
+}
+
+func (x *Session) GetDownlinkBytes() int64 {
+	if x != nil {
+		return x.DownlinkBytes
+	}
+	return 0
+}
+
+type ListSessionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sessions      []*Session             `protobuf:"bytes,1,rep,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSessionsResponse) Reset() {
+	*x = ListSessionsResponse{}
+	mi := &file_command_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSessionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSessionsResponse) ProtoMessage() {}
+
+func (x *ListSessionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSessionsResponse.ProtoReflect.Descriptor instead.
+func (*ListSessionsResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *ListSessionsResponse) GetSessions() []*Session {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
+type ExportSessionsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Only the sessions of this rule when not empty.
+	RuleId string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Only the sessions of this network, tcp, udp or icmp, when not empty.
+	Network string `protobuf:"bytes,3,opt,name=network,proto3" json:"network,omitempty"`
+	// Only the sessions created at least this many seconds ago.
+	MinAge uint32 `protobuf:"varint,4,opt,name=min_age,json=minAge,proto3" json:"min_age,omitempty"`
+	// json (default) or csv.
+	Format string `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`
+	// Snapshot exported in JSON earlier. When set, the differences of the session table since
+	// are exported instead.
+	Baseline      []byte `protobuf:"bytes,6,opt,name=baseline,proto3" json:"baseline,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportSessionsRequest) Reset() {
+	*x = ExportSessionsRequest{}
+	mi := &file_command_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportSessionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportSessionsRequest) ProtoMessage() {}
+
+func (x *ExportSessionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportSessionsRequest.ProtoReflect.Descriptor instead.
+func (*ExportSessionsRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ExportSessionsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ExportSessionsRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *ExportSessionsRequest) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *ExportSessionsRequest) GetMinAge() uint32 {
+	if x != nil {
+		return x.MinAge
+	}
+	return 0
+}
+
+func (x *ExportSessionsRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ExportSessionsRequest) GetBaseline() []byte {
+	if x != nil {
+		return x.Baseline
+	}
+	return nil
+}
+
+type ExportSessionsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Snapshot or differences in the requested format.
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// Number of sessions, or of differences, exported.
+	Sessions      int64 `protobuf:"varint,2,opt,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportSessionsResponse) Reset() {
+	*x = ExportSessionsResponse{}
+	mi := &file_command_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportSessionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportSessionsResponse) ProtoMessage() {}
+
+func (x *ExportSessionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportSessionsResponse.ProtoReflect.Descriptor instead.
+func (*ExportSessionsResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ExportSessionsResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ExportSessionsResponse) GetSessions() int64 {
+	if x != nil {
+		return x.Sessions
+	}
+	return 0
+}
+
+type WatchSessionEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Only the events of the sessions of this rule when not empty.
+	RuleId        string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchSessionEventsRequest) Reset() {
+	*x = WatchSessionEventsRequest{}
+	mi := &file_command_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchSessionEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchSessionEventsRequest) ProtoMessage() {}
+
+func (x *WatchSessionEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchSessionEventsRequest.ProtoReflect.Descriptor instead.
+func (*WatchSessionEventsRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *WatchSessionEventsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *WatchSessionEventsRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+type SessionEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound holding the session.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// create or teardown.
+	Event string `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	// Why the session was torn down: closed, expired, evicted or flushed.
+	Reason string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Unix time in milliseconds of the event.
+	Time      int64  `protobuf:"varint,4,opt,name=time,proto3" json:"time,omitempty"`
+	SessionId string `protobuf:"bytes,5,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	RuleId    string `protobuf:"bytes,6,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// tcp, udp or icmp.
+	Network            string `protobuf:"bytes,7,opt,name=network,proto3" json:"network,omitempty"`
+	VirtualSource      string `protobuf:"bytes,8,opt,name=virtual_source,json=virtualSource,proto3" json:"virtual_source,omitempty"`
+	VirtualDestination string `protobuf:"bytes,9,opt,name=virtual_destination,json=virtualDestination,proto3" json:"virtual_destination,omitempty"`
+	RealSource         string `protobuf:"bytes,10,opt,name=real_source,json=realSource,proto3" json:"real_source,omitempty"`
+	RealDestination    string `protobuf:"bytes,11,opt,name=real_destination,json=realDestination,proto3" json:"real_destination,omitempty"`
+	// Domain sniffed from the flow, if any.
+	Domain        string `protobuf:"bytes,12,opt,name=domain,proto3" json:"domain,omitempty"`
+	UplinkBytes   int64  `protobuf:"varint,13,opt,name=uplink_bytes,json=uplinkBytes,proto3" json:"uplink_bytes,omitempty"`
+	DownlinkBytes int64  `protobuf:"varint,14,opt,name=downlink_bytes,json=downlinkBytes,proto3" json:"downlink_bytes,omitempty"`
+	// Seconds since the session was created.
+	Duration      float64 `protobuf:"fixed64,15,opt,name=duration,proto3" json:"duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionEvent) Reset() {
+	*x = SessionEvent{}
+	mi := &file_command_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionEvent) ProtoMessage() {}
+
+func (x *SessionEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionEvent.ProtoReflect.Descriptor instead.
+func (*SessionEvent) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *SessionEvent) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *SessionEvent) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetVirtualSource() string {
+	if x != nil {
+		return x.VirtualSource
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetVirtualDestination() string {
+	if x != nil {
+		return x.VirtualDestination
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetRealSource() string {
+	if x != nil {
+		return x.RealSource
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetRealDestination() string {
+	if x != nil {
+		return x.RealDestination
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *SessionEvent) GetUplinkBytes() int64 {
+	if x != nil {
+		return x.UplinkBytes
+	}
+	return 0
+}
+
+func (x *SessionEvent) GetDownlinkBytes() int64 {
+	if x != nil {
+		return x.DownlinkBytes
+	}
+	return 0
+}
+
+func (x *SessionEvent) GetDuration() float64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+type SessionEvents struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Events that happened since the previous message, oldest first.
+	Events        []*SessionEvent `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionEvents) Reset() {
+	*x = SessionEvents{}
+	mi := &file_command_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionEvents) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionEvents) ProtoMessage() {}
+
+func (x *SessionEvents) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionEvents.ProtoReflect.Descriptor instead.
+func (*SessionEvents) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *SessionEvents) GetEvents() []*SessionEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type FlushSessionsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Only the sessions of this rule when not empty.
+	RuleId string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Only the sessions of the rules and virtual ranges carrying this tag when not empty.
+	RuleTag       string `protobuf:"bytes,3,opt,name=rule_tag,json=ruleTag,proto3" json:"rule_tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushSessionsRequest) Reset() {
+	*x = FlushSessionsRequest{}
+	mi := &file_command_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushSessionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushSessionsRequest) ProtoMessage() {}
+
+func (x *FlushSessionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushSessionsRequest.ProtoReflect.Descriptor instead.
+func (*FlushSessionsRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *FlushSessionsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *FlushSessionsRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *FlushSessionsRequest) GetRuleTag() string {
+	if x != nil {
+		return x.RuleTag
+	}
+	return ""
+}
+
+type FlushSessionsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of sessions dropped.
+	Flushed       int64 `protobuf:"varint,1,opt,name=flushed,proto3" json:"flushed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushSessionsResponse) Reset() {
+	*x = FlushSessionsResponse{}
+	mi := &file_command_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushSessionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushSessionsResponse) ProtoMessage() {}
+
+func (x *FlushSessionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushSessionsResponse.ProtoReflect.Descriptor instead.
+func (*FlushSessionsResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *FlushSessionsResponse) GetFlushed() int64 {
+	if x != nil {
+		return x.Flushed
+	}
+	return 0
+}
+
+type ListSourceUsageRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSourceUsageRequest) Reset() {
+	*x = ListSourceUsageRequest{}
+	mi := &file_command_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSourceUsageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSourceUsageRequest) ProtoMessage() {}
+
+func (x *ListSourceUsageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSourceUsageRequest.ProtoReflect.Descriptor instead.
+func (*ListSourceUsageRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *ListSourceUsageRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type SourceUsage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound whose per-source limits the address counts against.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Virtual source address.
+	Source string `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"`
+	// Concurrent sessions held.
+	Sessions uint32 `protobuf:"varint,3,opt,name=sessions,proto3" json:"sessions,omitempty"`
+	// New sessions the address may create right now, 0 when the rate is unlimited.
+	Tokens float64 `protobuf:"fixed64,4,opt,name=tokens,proto3" json:"tokens,omitempty"`
+	// New flows refused for exceeding the limits.
+	Refused       uint64 `protobuf:"varint,5,opt,name=refused,proto3" json:"refused,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SourceUsage) Reset() {
+	*x = SourceUsage{}
+	mi := &file_command_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SourceUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SourceUsage) ProtoMessage() {}
+
+func (x *SourceUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SourceUsage.ProtoReflect.Descriptor instead.
+func (*SourceUsage) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *SourceUsage) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *SourceUsage) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *SourceUsage) GetSessions() uint32 {
+	if x != nil {
+		return x.Sessions
+	}
+	return 0
+}
+
+func (x *SourceUsage) GetTokens() float64 {
+	if x != nil {
+		return x.Tokens
+	}
+	return 0
+}
+
+func (x *SourceUsage) GetRefused() uint64 {
+	if x != nil {
+		return x.Refused
+	}
+	return 0
+}
+
+type ListSourceUsageResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Addresses counting against the limits, the most sessions first.
+	Sources       []*SourceUsage `protobuf:"bytes,1,rep,name=sources,proto3" json:"sources,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSourceUsageResponse) Reset() {
+	*x = ListSourceUsageResponse{}
+	mi := &file_command_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSourceUsageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSourceUsageResponse) ProtoMessage() {}
+
+func (x *ListSourceUsageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSourceUsageResponse.ProtoReflect.Descriptor instead.
+func (*ListSourceUsageResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ListSourceUsageResponse) GetSources() []*SourceUsage {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+type ListRulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Only the rules carrying this tag when not empty.
+	RuleTag string `protobuf:"bytes,2,opt,name=rule_tag,json=ruleTag,proto3" json:"rule_tag,omitempty"`
+	// Lists the rules of the rules file or the controller that expired, while they are still
+	// listed unchanged, instead of the active rules.
+	Expired       bool `protobuf:"varint,3,opt,name=expired,proto3" json:"expired,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesRequest) Reset() {
+	*x = ListRulesRequest{}
+	mi := &file_command_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesRequest) ProtoMessage() {}
+
+func (x *ListRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesRequest.ProtoReflect.Descriptor instead.
+func (*ListRulesRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *ListRulesRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ListRulesRequest) GetRuleTag() string {
+	if x != nil {
+		return x.RuleTag
+	}
+	return ""
+}
+
+func (x *ListRulesRequest) GetExpired() bool {
+	if x != nil {
+		return x.Expired
+	}
+	return false
+}
+
+type Rule struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound the rule belongs to.
+	Tag                string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	RuleId             string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	SourceSite         string `protobuf:"bytes,3,opt,name=source_site,json=sourceSite,proto3" json:"source_site,omitempty"`
+	VirtualDestination string `protobuf:"bytes,4,opt,name=virtual_destination,json=virtualDestination,proto3" json:"virtual_destination,omitempty"`
+	// Real destination, or the backends of a pool.
+	RealDestinations []string `protobuf:"bytes,5,rep,name=real_destinations,json=realDestinations,proto3" json:"real_destinations,omitempty"`
+	Protocol         string   `protobuf:"bytes,6,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Ports            string   `protobuf:"bytes,7,opt,name=ports,proto3" json:"ports,omitempty"`
+	// translate or bypass.
+	Action string   `protobuf:"bytes,8,opt,name=action,proto3" json:"action,omitempty"`
+	Tags   []string `protobuf:"bytes,9,rep,name=tags,proto3" json:"tags,omitempty"`
+	// Whether the rule is disabled, in the configuration or through DisableRules.
+	Disabled bool `protobuf:"varint,10,opt,name=disabled,proto3" json:"disabled,omitempty"`
+	// Unix time in seconds the rule expires at through its ttl or expires_at, or expired at; 0
+	// when it does not expire.
+	ExpiresAt     int64 `protobuf:"varint,11,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rule) Reset() {
+	*x = Rule{}
+	mi := &file_command_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rule) ProtoMessage() {}
+
+func (x *Rule) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rule.ProtoReflect.Descriptor instead.
+func (*Rule) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *Rule) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *Rule) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *Rule) GetSourceSite() string {
+	if x != nil {
+		return x.SourceSite
+	}
+	return ""
+}
+
+func (x *Rule) GetVirtualDestination() string {
+	if x != nil {
+		return x.VirtualDestination
+	}
+	return ""
+}
+
+func (x *Rule) GetRealDestinations() []string {
+	if x != nil {
+		return x.RealDestinations
+	}
+	return nil
+}
+
+func (x *Rule) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Rule) GetPorts() string {
+	if x != nil {
+		return x.Ports
+	}
+	return ""
+}
+
+func (x *Rule) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Rule) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Rule) GetDisabled() bool {
+	if x != nil {
+		return x.Disabled
+	}
+	return false
+}
+
+func (x *Rule) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type ListRulesResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Active rules in match order.
+	Rules         []*Rule `protobuf:"bytes,1,rep,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesResponse) Reset() {
+	*x = ListRulesResponse{}
+	mi := &file_command_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesResponse) ProtoMessage() {}
+
+func (x *ListRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesResponse.ProtoReflect.Descriptor instead.
+func (*ListRulesResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ListRulesResponse) GetRules() []*Rule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+type TestTranslationRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, may be empty when there is only one.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// tcp or udp, tcp when empty.
+	Network string `protobuf:"bytes,2,opt,name=network,proto3" json:"network,omitempty"`
+	// Source endpoint of the flow, as host:port; optional.
+	Source string `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	// Destination endpoint of the flow, as host:port.
+	Destination   string `protobuf:"bytes,4,opt,name=destination,proto3" json:"destination,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TestTranslationRequest) Reset() {
+	*x = TestTranslationRequest{}
+	mi := &file_command_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TestTranslationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TestTranslationRequest) ProtoMessage() {}
+
+func (x *TestTranslationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TestTranslationRequest.ProtoReflect.Descriptor instead.
+func (*TestTranslationRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *TestTranslationRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *TestTranslationRequest) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *TestTranslationRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *TestTranslationRequest) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+type TestTranslationResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the flow would be translated.
+	Matched bool   `protobuf:"varint,1,opt,name=matched,proto3" json:"matched,omitempty"`
+	RuleId  string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Endpoint the flow would be translated to, empty for rules balancing over a pool.
+	RealDestination string `protobuf:"bytes,3,opt,name=real_destination,json=realDestination,proto3" json:"real_destination,omitempty"`
+	// Destination the rules matched, the domain of a FakeDNS fake IP.
+	VirtualDestination string `protobuf:"bytes,4,opt,name=virtual_destination,json=virtualDestination,proto3" json:"virtual_destination,omitempty"`
+	// Source the flow would be translated to, empty when it is left to the dialer. The port is 0
+	// when it is only allocated with the session.
+	RealSource string `protobuf:"bytes,5,opt,name=real_source,json=realSource,proto3" json:"real_source,omitempty"`
+	Hairpin    bool   `protobuf:"varint,6,opt,name=hairpin,proto3" json:"hairpin,omitempty"`
+	// Port mapping of the rule that translated the destination port, if any.
+	OriginalPort   string `protobuf:"bytes,7,opt,name=original_port,json=originalPort,proto3" json:"original_port,omitempty"`
+	TranslatedPort string `protobuf:"bytes,8,opt,name=translated_port,json=translatedPort,proto3" json:"translated_port,omitempty"`
+	// Action of the matched rule; deny and reject rules block the flow instead of translating it.
+	Action        string `protobuf:"bytes,9,opt,name=action,proto3" json:"action,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TestTranslationResponse) Reset() {
+	*x = TestTranslationResponse{}
+	mi := &file_command_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TestTranslationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TestTranslationResponse) ProtoMessage() {}
+
+func (x *TestTranslationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TestTranslationResponse.ProtoReflect.Descriptor instead.
+func (*TestTranslationResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *TestTranslationResponse) GetMatched() bool {
+	if x != nil {
+		return x.Matched
+	}
+	return false
+}
+
+func (x *TestTranslationResponse) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *TestTranslationResponse) GetRealDestination() string {
+	if x != nil {
+		return x.RealDestination
+	}
+	return ""
+}
+
+func (x *TestTranslationResponse) GetVirtualDestination() string {
+	if x != nil {
+		return x.VirtualDestination
+	}
+	return ""
+}
+
+func (x *TestTranslationResponse) GetRealSource() string {
+	if x != nil {
+		return x.RealSource
+	}
+	return ""
+}
+
+func (x *TestTranslationResponse) GetHairpin() bool {
+	if x != nil {
+		return x.Hairpin
+	}
+	return false
+}
+
+func (x *TestTranslationResponse) GetOriginalPort() string {
+	if x != nil {
+		return x.OriginalPort
+	}
+	return ""
+}
+
+func (x *TestTranslationResponse) GetTranslatedPort() string {
+	if x != nil {
+		return x.TranslatedPort
+	}
+	return ""
+}
+
+func (x *TestTranslationResponse) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+type DrainRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// What happens to new flows: reject, the default, or bypass to leave them untranslated.
+	Action string `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	// Seconds after which the sessions left are closed, 0 to let them finish.
+	Deadline      uint32 `protobuf:"varint,3,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainRequest) Reset() {
+	*x = DrainRequest{}
+	mi := &file_command_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainRequest) ProtoMessage() {}
+
+func (x *DrainRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainRequest.ProtoReflect.Descriptor instead.
+func (*DrainRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *DrainRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *DrainRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *DrainRequest) GetDeadline() uint32 {
+	if x != nil {
+		return x.Deadline
+	}
+	return 0
+}
+
+type DrainStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound.
+	Tag      string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	Draining bool   `protobuf:"varint,2,opt,name=draining,proto3" json:"draining,omitempty"`
+	// reject or bypass.
+	Action string `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	// Unix time in seconds the drain started at.
+	Since int64 `protobuf:"varint,4,opt,name=since,proto3" json:"since,omitempty"`
+	// Unix time in seconds the sessions left are closed at, 0 without a deadline.
+	Deadline int64 `protobuf:"varint,5,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	// Sessions left.
+	Sessions      int64 `protobuf:"varint,6,opt,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainStatus) Reset() {
+	*x = DrainStatus{}
+	mi := &file_command_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainStatus) ProtoMessage() {}
+
+func (x *DrainStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainStatus.ProtoReflect.Descriptor instead.
+func (*DrainStatus) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *DrainStatus) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *DrainStatus) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *DrainStatus) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *DrainStatus) GetSince() int64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *DrainStatus) GetDeadline() int64 {
+	if x != nil {
+		return x.Deadline
+	}
+	return 0
+}
+
+func (x *DrainStatus) GetSessions() int64 {
+	if x != nil {
+		return x.Sessions
+	}
+	return 0
+}
+
+type DrainResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        []*DrainStatus         `protobuf:"bytes,1,rep,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainResponse) Reset() {
+	*x = DrainResponse{}
+	mi := &file_command_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainResponse) ProtoMessage() {}
+
+func (x *DrainResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainResponse.ProtoReflect.Descriptor instead.
+func (*DrainResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *DrainResponse) GetStatus() []*DrainStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type ResumeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeRequest) Reset() {
+	*x = ResumeRequest{}
+	mi := &file_command_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeRequest) ProtoMessage() {}
+
+func (x *ResumeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeRequest.ProtoReflect.Descriptor instead.
+func (*ResumeRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *ResumeRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type ResumeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        []*DrainStatus         `protobuf:"bytes,1,rep,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeResponse) Reset() {
+	*x = ResumeResponse{}
+	mi := &file_command_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeResponse) ProtoMessage() {}
+
+func (x *ResumeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeResponse.ProtoReflect.Descriptor instead.
+func (*ResumeResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *ResumeResponse) GetStatus() []*DrainStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type GetDrainStatusRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDrainStatusRequest) Reset() {
+	*x = GetDrainStatusRequest{}
+	mi := &file_command_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDrainStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDrainStatusRequest) ProtoMessage() {}
+
+func (x *GetDrainStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDrainStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetDrainStatusRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *GetDrainStatusRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type GetDrainStatusResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        []*DrainStatus         `protobuf:"bytes,1,rep,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDrainStatusResponse) Reset() {
+	*x = GetDrainStatusResponse{}
+	mi := &file_command_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDrainStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDrainStatusResponse) ProtoMessage() {}
+
+func (x *GetDrainStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDrainStatusResponse.ProtoReflect.Descriptor instead.
+func (*GetDrainStatusResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *GetDrainStatusResponse) GetStatus() []*DrainStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type DisableRulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Rule to disable; either rule_id or rule_tag is required.
+	RuleId string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Disables every rule and virtual range carrying this tag, including those added later.
+	RuleTag       string `protobuf:"bytes,3,opt,name=rule_tag,json=ruleTag,proto3" json:"rule_tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisableRulesRequest) Reset() {
+	*x = DisableRulesRequest{}
+	mi := &file_command_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisableRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisableRulesRequest) ProtoMessage() {}
+
+func (x *DisableRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisableRulesRequest.ProtoReflect.Descriptor instead.
+func (*DisableRulesRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *DisableRulesRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *DisableRulesRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *DisableRulesRequest) GetRuleTag() string {
+	if x != nil {
+		return x.RuleTag
+	}
+	return ""
+}
+
+type DisableRulesResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Active rules and virtual ranges disabled.
+	Rules         int64 `protobuf:"varint,1,opt,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisableRulesResponse) Reset() {
+	*x = DisableRulesResponse{}
+	mi := &file_command_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisableRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisableRulesResponse) ProtoMessage() {}
+
+func (x *DisableRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisableRulesResponse.ProtoReflect.Descriptor instead.
+func (*DisableRulesResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *DisableRulesResponse) GetRules() int64 {
+	if x != nil {
+		return x.Rules
+	}
+	return 0
+}
+
+type EnableRulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Rule to enable again, or a rule disabled in the configuration ("enabled": false) to enable;
+	// either rule_id or rule_tag is required.
+	RuleId string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Enables the rules and virtual ranges carrying this tag again.
+	RuleTag       string `protobuf:"bytes,3,opt,name=rule_tag,json=ruleTag,proto3" json:"rule_tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnableRulesRequest) Reset() {
+	*x = EnableRulesRequest{}
+	mi := &file_command_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnableRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnableRulesRequest) ProtoMessage() {}
+
+func (x *EnableRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnableRulesRequest.ProtoReflect.Descriptor instead.
+func (*EnableRulesRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *EnableRulesRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *EnableRulesRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *EnableRulesRequest) GetRuleTag() string {
+	if x != nil {
+		return x.RuleTag
+	}
+	return ""
+}
+
+type EnableRulesResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Active rules and virtual ranges enabled.
+	Rules         int64 `protobuf:"varint,1,opt,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnableRulesResponse) Reset() {
+	*x = EnableRulesResponse{}
+	mi := &file_command_proto_msgTypes[43]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnableRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnableRulesResponse) ProtoMessage() {}
+
+func (x *EnableRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[43]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnableRulesResponse.ProtoReflect.Descriptor instead.
+func (*EnableRulesResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{43}
+}
+
+func (x *EnableRulesResponse) GetRules() int64 {
+	if x != nil {
+		return x.Rules
+	}
+	return 0
+}
+
+type AddRuleRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, may be empty when there is only one.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Rule to add after the active rules; rule_id and virtual_destination are required, ttl and
+	// expires_at are not supported.
+	Rule          *nat.NATRule `protobuf:"bytes,2,opt,name=rule,proto3" json:"rule,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddRuleRequest) Reset() {
+	*x = AddRuleRequest{}
+	mi := &file_command_proto_msgTypes[44]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddRuleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddRuleRequest) ProtoMessage() {}
+
+func (x *AddRuleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[44]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddRuleRequest.ProtoReflect.Descriptor instead.
+func (*AddRuleRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{44}
+}
+
+func (x *AddRuleRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *AddRuleRequest) GetRule() *nat.NATRule {
+	if x != nil {
+		return x.Rule
+	}
+	return nil
+}
+
+type RuleConflict struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// contradicts: the active rule matches the same virtual tuple and translates it differently;
+	// shadowed: the active rule matches every flow of the rule first; shadows: the rule would
+	// match every flow of the active rule first.
+	Kind string `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	// Active rule the rule conflicts with.
+	RuleId        string `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	Detail        string `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleConflict) Reset() {
+	*x = RuleConflict{}
+	mi := &file_command_proto_msgTypes[45]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleConflict) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleConflict) ProtoMessage() {}
+
+func (x *RuleConflict) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[45]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleConflict.ProtoReflect.Descriptor instead.
+func (*RuleConflict) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{45}
+}
+
+func (x *RuleConflict) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *RuleConflict) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *RuleConflict) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+type AddRuleResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the rule was added, false when it conflicts with active rules.
+	Added         bool            `protobuf:"varint,1,opt,name=added,proto3" json:"added,omitempty"`
+	Conflicts     []*RuleConflict `protobuf:"bytes,2,rep,name=conflicts,proto3" json:"conflicts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddRuleResponse) Reset() {
+	*x = AddRuleResponse{}
+	mi := &file_command_proto_msgTypes[46]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddRuleResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddRuleResponse) ProtoMessage() {}
+
+func (x *AddRuleResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[46]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddRuleResponse.ProtoReflect.Descriptor instead.
+func (*AddRuleResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{46}
+}
+
+func (x *AddRuleResponse) GetAdded() bool {
+	if x != nil {
+		return x.Added
+	}
+	return false
+}
+
+func (x *AddRuleResponse) GetConflicts() []*RuleConflict {
+	if x != nil {
+		return x.Conflicts
+	}
+	return nil
+}
+
+type SimulatedFlow struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// tcp or udp, tcp when empty.
+	Network string `protobuf:"bytes,1,opt,name=network,proto3" json:"network,omitempty"`
+	// Source endpoint of the flow, as host:port; optional.
+	Source string `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"`
+	// Destination endpoint of the flow, as host:port.
+	Destination   string `protobuf:"bytes,3,opt,name=destination,proto3" json:"destination,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SimulatedFlow) Reset() {
+	*x = SimulatedFlow{}
+	mi := &file_command_proto_msgTypes[47]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SimulatedFlow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimulatedFlow) ProtoMessage() {}
+
+func (x *SimulatedFlow) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[47]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimulatedFlow.ProtoReflect.Descriptor instead.
+func (*SimulatedFlow) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{47}
+}
+
+func (x *SimulatedFlow) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *SimulatedFlow) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *SimulatedFlow) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+type SimulateRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, may be empty when there is only one.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Candidate rules in match order, taking the place of the active rules.
+	Rules []*nat.NATRule `protobuf:"bytes,2,rep,name=rules,proto3" json:"rules,omitempty"`
+	// Flows of the traffic sample.
+	Flows         []*SimulatedFlow `protobuf:"bytes,3,rep,name=flows,proto3" json:"flows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SimulateRequest) Reset() {
+	*x = SimulateRequest{}
+	mi := &file_command_proto_msgTypes[48]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SimulateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimulateRequest) ProtoMessage() {}
+
+func (x *SimulateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[48]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimulateRequest.ProtoReflect.Descriptor instead.
+func (*SimulateRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{48}
+}
+
+func (x *SimulateRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *SimulateRequest) GetRules() []*nat.NATRule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+func (x *SimulateRequest) GetFlows() []*SimulatedFlow {
+	if x != nil {
+		return x.Flows
+	}
+	return nil
+}
+
+type FlowSimulation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Flow  *SimulatedFlow         `protobuf:"bytes,1,opt,name=flow,proto3" json:"flow,omitempty"`
+	// translated, bypassed (a bypass rule), blocked (a deny or reject rule) or unmatched.
+	Outcome string `protobuf:"bytes,2,opt,name=outcome,proto3" json:"outcome,omitempty"`
+	// Rule taking the flow, empty when unmatched.
+	RuleId string `protobuf:"bytes,3,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Endpoint the flow would be translated to, empty for rules balancing over a pool.
+	RealDestination string `protobuf:"bytes,4,opt,name=real_destination,json=realDestination,proto3" json:"real_destination,omitempty"`
+	// Source the flow would be translated to, empty when it is left to the dialer.
+	RealSource string `protobuf:"bytes,5,opt,name=real_source,json=realSource,proto3" json:"real_source,omitempty"`
+	// Other candidate rules matching the flow and treating it differently, left out by match
+	// order alone.
+	Contested []string `protobuf:"bytes,6,rep,name=contested,proto3" json:"contested,omitempty"`
+	// Outcome and rule of the flow under the active rules.
+	ActiveOutcome string `protobuf:"bytes,7,opt,name=active_outcome,json=activeOutcome,proto3" json:"active_outcome,omitempty"`
+	ActiveRuleId  string `protobuf:"bytes,8,opt,name=active_rule_id,json=activeRuleId,proto3" json:"active_rule_id,omitempty"`
+	// Whether the candidate rules treat the flow differently from the active rules.
+	Changed bool `protobuf:"varint,9,opt,name=changed,proto3" json:"changed,omitempty"`
+	// Why the translation failed, such as a real destination that does not resolve.
+	Error         string `protobuf:"bytes,10,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlowSimulation) Reset() {
+	*x = FlowSimulation{}
+	mi := &file_command_proto_msgTypes[49]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlowSimulation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowSimulation) ProtoMessage() {}
+
+func (x *FlowSimulation) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[49]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowSimulation.ProtoReflect.Descriptor instead.
+func (*FlowSimulation) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{49}
+}
+
+func (x *FlowSimulation) GetFlow() *SimulatedFlow {
+	if x != nil {
+		return x.Flow
+	}
+	return nil
+}
+
+func (x *FlowSimulation) GetOutcome() string {
+	if x != nil {
+		return x.Outcome
+	}
+	return ""
+}
+
+func (x *FlowSimulation) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *FlowSimulation) GetRealDestination() string {
+	if x != nil {
+		return x.RealDestination
+	}
+	return ""
+}
+
+func (x *FlowSimulation) GetRealSource() string {
+	if x != nil {
+		return x.RealSource
+	}
+	return ""
+}
+
+func (x *FlowSimulation) GetContested() []string {
+	if x != nil {
+		return x.Contested
+	}
+	return nil
+}
+
+func (x *FlowSimulation) GetActiveOutcome() string {
+	if x != nil {
+		return x.ActiveOutcome
+	}
+	return ""
+}
+
+func (x *FlowSimulation) GetActiveRuleId() string {
+	if x != nil {
+		return x.ActiveRuleId
+	}
+	return ""
+}
+
+func (x *FlowSimulation) GetChanged() bool {
+	if x != nil {
+		return x.Changed
+	}
+	return false
+}
+
+func (x *FlowSimulation) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type RuleSimulation struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	RuleId string                 `protobuf:"bytes,1,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Flows of the sample the rule takes; rules taking none are unused by the sample.
+	Flows int64 `protobuf:"varint,2,opt,name=flows,proto3" json:"flows,omitempty"`
+	// Conflicts with the candidate rules before it.
+	Conflicts     []*RuleConflict `protobuf:"bytes,3,rep,name=conflicts,proto3" json:"conflicts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleSimulation) Reset() {
+	*x = RuleSimulation{}
+	mi := &file_command_proto_msgTypes[50]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleSimulation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleSimulation) ProtoMessage() {}
+
+func (x *RuleSimulation) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[50]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleSimulation.ProtoReflect.Descriptor instead.
+func (*RuleSimulation) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{50}
+}
+
+func (x *RuleSimulation) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *RuleSimulation) GetFlows() int64 {
+	if x != nil {
+		return x.Flows
+	}
+	return 0
+}
+
+func (x *RuleSimulation) GetConflicts() []*RuleConflict {
+	if x != nil {
+		return x.Conflicts
+	}
+	return nil
+}
+
+type SimulateResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Flows []*FlowSimulation      `protobuf:"bytes,1,rep,name=flows,proto3" json:"flows,omitempty"`
+	// Candidate rules in match order.
+	Rules         []*RuleSimulation `protobuf:"bytes,2,rep,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SimulateResponse) Reset() {
+	*x = SimulateResponse{}
+	mi := &file_command_proto_msgTypes[51]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SimulateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimulateResponse) ProtoMessage() {}
+
+func (x *SimulateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[51]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimulateResponse.ProtoReflect.Descriptor instead.
+func (*SimulateResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{51}
+}
+
+func (x *SimulateResponse) GetFlows() []*FlowSimulation {
+	if x != nil {
+		return x.Flows
+	}
+	return nil
+}
+
+func (x *SimulateResponse) GetRules() []*RuleSimulation {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+type SelfTestRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound, all NAT outbounds when empty.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelfTestRequest) Reset() {
+	*x = SelfTestRequest{}
+	mi := &file_command_proto_msgTypes[52]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelfTestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelfTestRequest) ProtoMessage() {}
+
+func (x *SelfTestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[52]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelfTestRequest.ProtoReflect.Descriptor instead.
+func (*SelfTestRequest) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{52}
+}
+
+func (x *SelfTestRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type ComplianceCheck struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Such as "RFC 4787 REQ-1".
+	Requirement string `protobuf:"bytes,1,opt,name=requirement,proto3" json:"requirement,omitempty"`
+	Description string `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Passed      bool   `protobuf:"varint,3,opt,name=passed,proto3" json:"passed,omitempty"`
+	// What was observed, such as the mapping behavior of each group of rules or a timeout.
+	Detail        string `protobuf:"bytes,4,opt,name=detail,proto3" json:"detail,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ComplianceCheck) Reset() {
+	*x = ComplianceCheck{}
+	mi := &file_command_proto_msgTypes[53]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ComplianceCheck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ComplianceCheck) ProtoMessage() {}
+
+func (x *ComplianceCheck) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[53]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ComplianceCheck.ProtoReflect.Descriptor instead.
+func (*ComplianceCheck) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{53}
+}
+
+func (x *ComplianceCheck) GetRequirement() string {
+	if x != nil {
+		return x.Requirement
+	}
+	return ""
+}
+
+func (x *ComplianceCheck) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *ComplianceCheck) GetPassed() bool {
+	if x != nil {
+		return x.Passed
+	}
+	return false
+}
+
+func (x *ComplianceCheck) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+type SelfTestResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the NAT outbound.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// complianceMode of the outbound, empty when off.
+	ComplianceMode string `protobuf:"bytes,2,opt,name=compliance_mode,json=complianceMode,proto3" json:"compliance_mode,omitempty"`
+	// Whether every check passed.
+	Passed        bool               `protobuf:"varint,3,opt,name=passed,proto3" json:"passed,omitempty"`
+	Checks        []*ComplianceCheck `protobuf:"bytes,4,rep,name=checks,proto3" json:"checks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelfTestResult) Reset() {
+	*x = SelfTestResult{}
+	mi := &file_command_proto_msgTypes[54]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelfTestResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelfTestResult) ProtoMessage() {}
+
+func (x *SelfTestResult) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[54]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelfTestResult.ProtoReflect.Descriptor instead.
+func (*SelfTestResult) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{54}
+}
+
+func (x *SelfTestResult) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *SelfTestResult) GetComplianceMode() string {
+	if x != nil {
+		return x.ComplianceMode
+	}
+	return ""
+}
+
+func (x *SelfTestResult) GetPassed() bool {
+	if x != nil {
+		return x.Passed
+	}
+	return false
+}
+
+func (x *SelfTestResult) GetChecks() []*ComplianceCheck {
+	if x != nil {
+		return x.Checks
+	}
+	return nil
+}
+
+type SelfTestResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*SelfTestResult      `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelfTestResponse) Reset() {
+	*x = SelfTestResponse{}
+	mi := &file_command_proto_msgTypes[55]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelfTestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelfTestResponse) ProtoMessage() {}
+
+func (x *SelfTestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[55]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelfTestResponse.ProtoReflect.Descriptor instead.
+func (*SelfTestResponse) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{55}
+}
+
+func (x *SelfTestResponse) GetResults() []*SelfTestResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type Config struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Config) Reset() {
+	*x = Config{}
+	mi := &file_command_proto_msgTypes[56]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Config) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config) ProtoMessage() {}
+
+func (x *Config) ProtoReflect() protoreflect.Message {
+	mi := &file_command_proto_msgTypes[56]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config.ProtoReflect.Descriptor instead.
+func (*Config) Descriptor() ([]byte, []int) {
+	return file_command_proto_rawDescGZIP(), []int{56}
+}
+
+var File_command_proto protoreflect.FileDescriptor
+
+const file_command_proto_rawDesc = "" +
+	"\n" +
+	"\rcommand.proto\x12\x16xray.proxy.nat.command\x1a\x16proxy/nat/config.proto\"'\n" +
+	"\x13ListMappingsRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"\xc2\x01\n" +
+	"\aMapping\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\tR\tsessionId\x12\x1a\n" +
+	"\bprotocol\x18\x03 \x01(\tR\bprotocol\x12\x18\n" +
+	"\anetwork\x18\x04 \x01(\tR\anetwork\x12\x1a\n" +
+	"\binternal\x18\x05 \x01(\tR\binternal\x12\x1a\n" +
+	"\bexternal\x18\x06 \x01(\tR\bexternal\x12\x18\n" +
+	"\aexpires\x18\a \x01(\x03R\aexpires\"S\n" +
+	"\x14ListMappingsResponse\x12;\n" +
+	"\bmappings\x18\x01 \x03(\v2\x1f.xray.proxy.nat.command.MappingR\bmappings\"\x7f\n" +
+	"\x05Lease\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12'\n" +
+	"\x0fvirtual_address\x18\x02 \x01(\tR\x0evirtualAddress\x12!\n" +
+	"\freal_address\x18\x03 \x01(\tR\vrealAddress\x12\x18\n" +
+	"\aexpires\x18\x04 \x01(\x03R\aexpires\"\x88\x01\n" +
+	"\x16AllocateAddressRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12!\n" +
+	"\freal_address\x18\x02 \x01(\tR\vrealAddress\x12'\n" +
+	"\x0fvirtual_address\x18\x03 \x01(\tR\x0evirtualAddress\x12\x10\n" +
+	"\x03ttl\x18\x04 \x01(\rR\x03ttl\"N\n" +
+	"\x17AllocateAddressResponse\x123\n" +
+	"\x05lease\x18\x01 \x01(\v2\x1d.xray.proxy.nat.command.LeaseR\x05lease\"`\n" +
+	"\x11RenewLeaseRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12'\n" +
+	"\x0fvirtual_address\x18\x02 \x01(\tR\x0evirtualAddress\x12\x10\n" +
+	"\x03ttl\x18\x03 \x01(\rR\x03ttl\"I\n" +
+	"\x12RenewLeaseResponse\x123\n" +
+	"\x05lease\x18\x01 \x01(\v2\x1d.xray.proxy.nat.command.LeaseR\x05lease\"R\n" +
+	"\x15ReleaseAddressRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12'\n" +
+	"\x0fvirtual_address\x18\x02 \x01(\tR\x0evirtualAddress\"\x18\n" +
+	"\x16ReleaseAddressResponse\"%\n" +
+	"\x11ListLeasesRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"K\n" +
+	"\x12ListLeasesResponse\x125\n" +
+	"\x06leases\x18\x01 \x03(\v2\x1d.xray.proxy.nat.command.LeaseR\x06leases\"B\n" +
+	"\x13GetRuleStatsRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x19\n" +
+	"\brule_tag\x18\x02 \x01(\tR\aruleTag\"\xa5\x02\n" +
+	"\tRuleStats\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x12\n" +
+	"\x04hits\x18\x03 \x01(\x03R\x04hits\x12'\n" +
+	"\x0factive_sessions\x18\x04 \x01(\x03R\x0eactiveSessions\x12\x14\n" +
+	"\x05bytes\x18\x05 \x01(\x03R\x05bytes\x12\x19\n" +
+	"\blast_hit\x18\x06 \x01(\x03R\alastHit\x12#\n" +
+	"\rshaping_drops\x18\a \x01(\x03R\fshapingDrops\x12\x1f\n" +
+	"\vcap_refused\x18\b \x01(\x03R\n" +
+	"capRefused\x12%\n" +
+	"\x0eoversize_drops\x18\t \x01(\x03R\roversizeDrops\x12\x12\n" +
+	"\x04tags\x18\n" +
+	" \x03(\tR\x04tags\"O\n" +
+	"\x14GetRuleStatsResponse\x127\n" +
+	"\x05stats\x18\x01 \x03(\v2!.xray.proxy.nat.command.RuleStatsR\x05stats\"@\n" +
+	"\x13ListSessionsRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\"\xab\x03\n" +
+	"\aSession\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\tR\tsessionId\x12\x17\n" +
+	"\arule_id\x18\x03 \x01(\tR\x06ruleId\x12\x18\n" +
+	"\anetwork\x18\x04 \x01(\tR\anetwork\x12\x1c\n" +
+	"\tdirection\x18\x05 \x01(\tR\tdirection\x12%\n" +
+	"\x0evirtual_source\x18\x06 \x01(\tR\rvirtualSource\x12/\n" +
+	"\x13virtual_destination\x18\a \x01(\tR\x12virtualDestination\x12\x1f\n" +
+	"\vreal_source\x18\b \x01(\tR\n" +
+	"realSource\x12)\n" +
+	"\x10real_destination\x18\t \x01(\tR\x0frealDestination\x12\x16\n" +
+	"\x06domain\x18\n" +
+	" \x01(\tR\x06domain\x12\x18\n" +
+	"\acreated\x18\v \x01(\x03R\acreated\x12!\n" +
+	"\fuplink_bytes\x18\f \x01(\x03R\vuplinkBytes\x12%\n" +
+	"\x0edownlink_bytes\x18\r \x01(\x03R\rdownlinkBytes\"S\n" +
+	"\x14ListSessionsResponse\x12;\n" +
+	"\bsessions\x18\x01 \x03(\v2\x1f.xray.proxy.nat.command.SessionR\bsessions\"\xa9\x01\n" +
+	"\x15ExportSessionsRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x18\n" +
+	"\anetwork\x18\x03 \x01(\tR\anetwork\x12\x17\n" +
+	"\amin_age\x18\x04 \x01(\rR\x06minAge\x12\x16\n" +
+	"\x06format\x18\x05 \x01(\tR\x06format\x12\x1a\n" +
+	"\bbaseline\x18\x06 \x01(\fR\bbaseline\"H\n" +
+	"\x16ExportSessionsResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x1a\n" +
+	"\bsessions\x18\x02 \x01(\x03R\bsessions\"F\n" +
+	"\x19WatchSessionEventsRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\"\xd6\x03\n" +
+	"\fSessionEvent\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\x12\x12\n" +
+	"\x04time\x18\x04 \x01(\x03R\x04time\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x05 \x01(\tR\tsessionId\x12\x17\n" +
+	"\arule_id\x18\x06 \x01(\tR\x06ruleId\x12\x18\n" +
+	"\anetwork\x18\a \x01(\tR\anetwork\x12%\n" +
+	"\x0evirtual_source\x18\b \x01(\tR\rvirtualSource\x12/\n" +
+	"\x13virtual_destination\x18\t \x01(\tR\x12virtualDestination\x12\x1f\n" +
+	"\vreal_source\x18\n" +
+	" \x01(\tR\n" +
+	"realSource\x12)\n" +
+	"\x10real_destination\x18\v \x01(\tR\x0frealDestination\x12\x16\n" +
+	"\x06domain\x18\f \x01(\tR\x06domain\x12!\n" +
+	"\fuplink_bytes\x18\r \x01(\x03R\vuplinkBytes\x12%\n" +
+	"\x0edownlink_bytes\x18\x0e \x01(\x03R\rdownlinkBytes\x12\x1a\n" +
+	"\bduration\x18\x0f \x01(\x01R\bduration\"M\n" +
+	"\rSessionEvents\x12<\n" +
+	"\x06events\x18\x01 \x03(\v2$.xray.proxy.nat.command.SessionEventR\x06events\"\\\n" +
+	"\x14FlushSessionsRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x19\n" +
+	"\brule_tag\x18\x03 \x01(\tR\aruleTag\"1\n" +
+	"\x15FlushSessionsResponse\x12\x18\n" +
+	"\aflushed\x18\x01 \x01(\x03R\aflushed\"*\n" +
+	"\x16ListSourceUsageRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"\x85\x01\n" +
+	"\vSourceUsage\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x16\n" +
+	"\x06source\x18\x02 \x01(\tR\x06source\x12\x1a\n" +
+	"\bsessions\x18\x03 \x01(\rR\bsessions\x12\x16\n" +
+	"\x06tokens\x18\x04 \x01(\x01R\x06tokens\x12\x18\n" +
+	"\arefused\x18\x05 \x01(\x04R\arefused\"X\n" +
+	"\x17ListSourceUsageResponse\x12=\n" +
+	"\asources\x18\x01 \x03(\v2#.xray.proxy.nat.command.SourceUsageR\asources\"Y\n" +
+	"\x10ListRulesRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x19\n" +
+	"\brule_tag\x18\x02 \x01(\tR\aruleTag\x12\x18\n" +
+	"\aexpired\x18\x03 \x01(\bR\aexpired\"\xc9\x02\n" +
+	"\x04Rule\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x1f\n" +
+	"\vsource_site\x18\x03 \x01(\tR\n" +
+	"sourceSite\x12/\n" +
+	"\x13virtual_destination\x18\x04 \x01(\tR\x12virtualDestination\x12+\n" +
+	"\x11real_destinations\x18\x05 \x03(\tR\x10realDestinations\x12\x1a\n" +
+	"\bprotocol\x18\x06 \x01(\tR\bprotocol\x12\x14\n" +
+	"\x05ports\x18\a \x01(\tR\x05ports\x12\x16\n" +
+	"\x06action\x18\b \x01(\tR\x06action\x12\x12\n" +
+	"\x04tags\x18\t \x03(\tR\x04tags\x12\x1a\n" +
+	"\bdisabled\x18\n" +
+	" \x01(\bR\bdisabled\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\v \x01(\x03R\texpiresAt\"G\n" +
+	"\x11ListRulesResponse\x122\n" +
+	"\x05rules\x18\x01 \x03(\v2\x1c.xray.proxy.nat.command.RuleR\x05rules\"~\n" +
+	"\x16TestTranslationRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x18\n" +
+	"\anetwork\x18\x02 \x01(\tR\anetwork\x12\x16\n" +
+	"\x06source\x18\x03 \x01(\tR\x06source\x12 \n" +
+	"\vdestination\x18\x04 \x01(\tR\vdestination\"\xc9\x02\n" +
+	"\x17TestTranslationResponse\x12\x18\n" +
+	"\amatched\x18\x01 \x01(\bR\amatched\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12)\n" +
+	"\x10real_destination\x18\x03 \x01(\tR\x0frealDestination\x12/\n" +
+	"\x13virtual_destination\x18\x04 \x01(\tR\x12virtualDestination\x12\x1f\n" +
+	"\vreal_source\x18\x05 \x01(\tR\n" +
+	"realSource\x12\x18\n" +
+	"\ahairpin\x18\x06 \x01(\bR\ahairpin\x12#\n" +
+	"\roriginal_port\x18\a \x01(\tR\foriginalPort\x12'\n" +
+	"\x0ftranslated_port\x18\b \x01(\tR\x0etranslatedPort\x12\x16\n" +
+	"\x06action\x18\t \x01(\tR\x06action\"T\n" +
+	"\fDrainRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12\x1a\n" +
+	"\bdeadline\x18\x03 \x01(\rR\bdeadline\"\xa1\x01\n" +
+	"\vDrainStatus\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x1a\n" +
+	"\bdraining\x18\x02 \x01(\bR\bdraining\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\x12\x14\n" +
+	"\x05since\x18\x04 \x01(\x03R\x05since\x12\x1a\n" +
+	"\bdeadline\x18\x05 \x01(\x03R\bdeadline\x12\x1a\n" +
+	"\bsessions\x18\x06 \x01(\x03R\bsessions\"L\n" +
+	"\rDrainResponse\x12;\n" +
+	"\x06status\x18\x01 \x03(\v2#.xray.proxy.nat.command.DrainStatusR\x06status\"!\n" +
+	"\rResumeRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"M\n" +
+	"\x0eResumeResponse\x12;\n" +
+	"\x06status\x18\x01 \x03(\v2#.xray.proxy.nat.command.DrainStatusR\x06status\")\n" +
+	"\x15GetDrainStatusRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"U\n" +
+	"\x16GetDrainStatusResponse\x12;\n" +
+	"\x06status\x18\x01 \x03(\v2#.xray.proxy.nat.command.DrainStatusR\x06status\"[\n" +
+	"\x13DisableRulesRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x19\n" +
+	"\brule_tag\x18\x03 \x01(\tR\aruleTag\",\n" +
+	"\x14DisableRulesResponse\x12\x14\n" +
+	"\x05rules\x18\x01 \x01(\x03R\x05rules\"Z\n" +
+	"\x12EnableRulesRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x19\n" +
+	"\brule_tag\x18\x03 \x01(\tR\aruleTag\"+\n" +
+	"\x13EnableRulesResponse\x12\x14\n" +
+	"\x05rules\x18\x01 \x01(\x03R\x05rules\"O\n" +
+	"\x0eAddRuleRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12+\n" +
+	"\x04rule\x18\x02 \x01(\v2\x17.xray.proxy.nat.NATRuleR\x04rule\"S\n" +
+	"\fRuleConflict\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x16\n" +
+	"\x06detail\x18\x03 \x01(\tR\x06detail\"k\n" +
+	"\x0fAddRuleResponse\x12\x14\n" +
+	"\x05added\x18\x01 \x01(\bR\x05added\x12B\n" +
+	"\tconflicts\x18\x02 \x03(\v2$.xray.proxy.nat.command.RuleConflictR\tconflicts\"c\n" +
+	"\rSimulatedFlow\x12\x18\n" +
+	"\anetwork\x18\x01 \x01(\tR\anetwork\x12\x16\n" +
+	"\x06source\x18\x02 \x01(\tR\x06source\x12 \n" +
+	"\vdestination\x18\x03 \x01(\tR\vdestination\"\x8f\x01\n" +
+	"\x0fSimulateRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12-\n" +
+	"\x05rules\x18\x02 \x03(\v2\x17.xray.proxy.nat.NATRuleR\x05rules\x12;\n" +
+	"\x05flows\x18\x03 \x03(\v2%.xray.proxy.nat.command.SimulatedFlowR\x05flows\"\xe5\x02\n" +
+	"\x0eFlowSimulation\x129\n" +
+	"\x04flow\x18\x01 \x01(\v2%.xray.proxy.nat.command.SimulatedFlowR\x04flow\x12\x18\n" +
+	"\aoutcome\x18\x02 \x01(\tR\aoutcome\x12\x17\n" +
+	"\arule_id\x18\x03 \x01(\tR\x06ruleId\x12)\n" +
+	"\x10real_destination\x18\x04 \x01(\tR\x0frealDestination\x12\x1f\n" +
+	"\vreal_source\x18\x05 \x01(\tR\n" +
+	"realSource\x12\x1c\n" +
+	"\tcontested\x18\x06 \x03(\tR\tcontested\x12%\n" +
+	"\x0eactive_outcome\x18\a \x01(\tR\ractiveOutcome\x12$\n" +
+	"\x0eactive_rule_id\x18\b \x01(\tR\factiveRuleId\x12\x18\n" +
+	"\achanged\x18\t \x01(\bR\achanged\x12\x14\n" +
+	"\x05error\x18\n" +
+	" \x01(\tR\x05error\"\x83\x01\n" +
+	"\x0eRuleSimulation\x12\x17\n" +
+	"\arule_id\x18\x01 \x01(\tR\x06ruleId\x12\x14\n" +
+	"\x05flows\x18\x02 \x01(\x03R\x05flows\x12B\n" +
+	"\tconflicts\x18\x03 \x03(\v2$.xray.proxy.nat.command.RuleConflictR\tconflicts\"\x8e\x01\n" +
+	"\x10SimulateResponse\x12<\n" +
+	"\x05flows\x18\x01 \x03(\v2&.xray.proxy.nat.command.FlowSimulationR\x05flows\x12<\n" +
+	"\x05rules\x18\x02 \x03(\v2&.xray.proxy.nat.command.RuleSimulationR\x05rules\"#\n" +
+	"\x0fSelfTestRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"\x85\x01\n" +
+	"\x0fComplianceCheck\x12 \n" +
+	"\vrequirement\x18\x01 \x01(\tR\vrequirement\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x12\x16\n" +
+	"\x06passed\x18\x03 \x01(\bR\x06passed\x12\x16\n" +
+	"\x06detail\x18\x04 \x01(\tR\x06detail\"\xa4\x01\n" +
+	"\x0eSelfTestResult\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12'\n" +
+	"\x0fcompliance_mode\x18\x02 \x01(\tR\x0ecomplianceMode\x12\x16\n" +
+	"\x06passed\x18\x03 \x01(\bR\x06passed\x12?\n" +
+	"\x06checks\x18\x04 \x03(\v2'.xray.proxy.nat.command.ComplianceCheckR\x06checks\"T\n" +
+	"\x10SelfTestResponse\x12@\n" +
+	"\aresults\x18\x01 \x03(\v2&.xray.proxy.nat.command.SelfTestResultR\aresults\"\b\n" +
+	"\x06Config2\xce\x11\n" +
+	"\n" +
+	"NATService\x12k\n" +
+	"\fListMappings\x12+.xray.proxy.nat.command.ListMappingsRequest\x1a,.xray.proxy.nat.command.ListMappingsResponse\"\x00\x12t\n" +
+	"\x0fAllocateAddress\x12..xray.proxy.nat.command.AllocateAddressRequest\x1a/.xray.proxy.nat.command.AllocateAddressResponse\"\x00\x12e\n" +
+	"\n" +
+	"RenewLease\x12).xray.proxy.nat.command.RenewLeaseRequest\x1a*.xray.proxy.nat.command.RenewLeaseResponse\"\x00\x12q\n" +
+	"\x0eReleaseAddress\x12-.xray.proxy.nat.command.ReleaseAddressRequest\x1a..xray.proxy.nat.command.ReleaseAddressResponse\"\x00\x12e\n" +
+	"\n" +
+	"ListLeases\x12).xray.proxy.nat.command.ListLeasesRequest\x1a*.xray.proxy.nat.command.ListLeasesResponse\"\x00\x12k\n" +
+	"\fGetRuleStats\x12+.xray.proxy.nat.command.GetRuleStatsRequest\x1a,.xray.proxy.nat.command.GetRuleStatsResponse\"\x00\x12k\n" +
+	"\fListSessions\x12+.xray.proxy.nat.command.ListSessionsRequest\x1a,.xray.proxy.nat.command.ListSessionsResponse\"\x00\x12q\n" +
+	"\x0eExportSessions\x12-.xray.proxy.nat.command.ExportSessionsRequest\x1a..xray.proxy.nat.command.ExportSessionsResponse\"\x00\x12r\n" +
+	"\x12WatchSessionEvents\x121.xray.proxy.nat.command.WatchSessionEventsRequest\x1a%.xray.proxy.nat.command.SessionEvents\"\x000\x01\x12n\n" +
+	"\rFlushSessions\x12,.xray.proxy.nat.command.FlushSessionsRequest\x1a-.xray.proxy.nat.command.FlushSessionsResponse\"\x00\x12t\n" +
+	"\x0fListSourceUsage\x12..xray.proxy.nat.command.ListSourceUsageRequest\x1a/.xray.proxy.nat.command.ListSourceUsageResponse\"\x00\x12b\n" +
+	"\tListRules\x12(.xray.proxy.nat.command.ListRulesRequest\x1a).xray.proxy.nat.command.ListRulesResponse\"\x00\x12t\n" +
+	"\x0fTestTranslation\x12..xray.proxy.nat.command.TestTranslationRequest\x1a/.xray.proxy.nat.command.TestTranslationResponse\"\x00\x12V\n" +
+	"\x05Drain\x12$.xray.proxy.nat.command.DrainRequest\x1a%.xray.proxy.nat.command.DrainResponse\"\x00\x12Y\n" +
+	"\x06Resume\x12%.xray.proxy.nat.command.ResumeRequest\x1a&.xray.proxy.nat.command.ResumeResponse\"\x00\x12q\n" +
+	"\x0eGetDrainStatus\x12-.xray.proxy.nat.command.GetDrainStatusRequest\x1a..xray.proxy.nat.command.GetDrainStatusResponse\"\x00\x12k\n" +
+	"\fDisableRules\x12+.xray.proxy.nat.command.DisableRulesRequest\x1a,.xray.proxy.nat.command.DisableRulesResponse\"\x00\x12h\n" +
+	"\vEnableRules\x12*.xray.proxy.nat.command.EnableRulesRequest\x1a+.xray.proxy.nat.command.EnableRulesResponse\"\x00\x12\\\n" +
+	"\aAddRule\x12&.xray.proxy.nat.command.AddRuleRequest\x1a'.xray.proxy.nat.command.AddRuleResponse\"\x00\x12_\n" +
+	"\bSimulate\x12'.xray.proxy.nat.command.SimulateRequest\x1a(.xray.proxy.nat.command.SimulateResponse\"\x00\x12_\n" +
+	"\bSelfTest\x12'.xray.proxy.nat.command.SelfTestRequest\x1a(.xray.proxy.nat.command.SelfTestResponse\"\x00Bd\n" +
+	"\x1acom.xray.proxy.nat.commandP\x01Z+github.com/xtls/xray-core/proxy/nat/command\xaa\x02\x16Xray.Proxy.Nat.Commandb\x06proto3"
+
+var (
+	file_command_proto_rawDescOnce sync.Once
+	file_command_proto_rawDescData []byte
+)
+
+func file_command_proto_rawDescGZIP() []byte {
+	file_command_proto_rawDescOnce.Do(func() {
+		file_command_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_command_proto_rawDesc), len(file_command_proto_rawDesc)))
+	})
+	return file_command_proto_rawDescData
+}
+
+var file_command_proto_msgTypes = make([]protoimpl.MessageInfo, 57)
+var file_command_proto_goTypes = []any{
+	(*ListMappingsRequest)(nil),       // 0: xray.proxy.nat.command.ListMappingsRequest
+	(*Mapping)(nil),                   // 1: xray.proxy.nat.command.Mapping
+	(*ListMappingsResponse)(nil),      // 2: xray.proxy.nat.command.ListMappingsResponse
+	(*Lease)(nil),                     // 3: xray.proxy.nat.command.Lease
+	(*AllocateAddressRequest)(nil),    // 4: xray.proxy.nat.command.AllocateAddressRequest
+	(*AllocateAddressResponse)(nil),   // 5: xray.proxy.nat.command.AllocateAddressResponse
+	(*RenewLeaseRequest)(nil),         // 6: xray.proxy.nat.command.RenewLeaseRequest
+	(*RenewLeaseResponse)(nil),        // 7: xray.proxy.nat.command.RenewLeaseResponse
+	(*ReleaseAddressRequest)(nil),     // 8: xray.proxy.nat.command.ReleaseAddressRequest
+	(*ReleaseAddressResponse)(nil),    // 9: xray.proxy.nat.command.ReleaseAddressResponse
+	(*ListLeasesRequest)(nil),         // 10: xray.proxy.nat.command.ListLeasesRequest
+	(*ListLeasesResponse)(nil),        // 11: xray.proxy.nat.command.ListLeasesResponse
+	(*GetRuleStatsRequest)(nil),       // 12: xray.proxy.nat.command.GetRuleStatsRequest
+	(*RuleStats)(nil),                 // 13: xray.proxy.nat.command.RuleStats
+	(*GetRuleStatsResponse)(nil),      // 14: xray.proxy.nat.command.GetRuleStatsResponse
+	(*ListSessionsRequest)(nil),       // 15: xray.proxy.nat.command.ListSessionsRequest
+	(*Session)(nil),                   // 16: xray.proxy.nat.command.Session
+	(*ListSessionsResponse)(nil),      // 17: xray.proxy.nat.command.ListSessionsResponse
+	(*ExportSessionsRequest)(nil),     // 18: xray.proxy.nat.command.ExportSessionsRequest
+	(*ExportSessionsResponse)(nil),    // 19: xray.proxy.nat.command.ExportSessionsResponse
+	(*WatchSessionEventsRequest)(nil), // 20: xray.proxy.nat.command.WatchSessionEventsRequest
+	(*SessionEvent)(nil),              // 21: xray.proxy.nat.command.SessionEvent
+	(*SessionEvents)(nil),             // 22: xray.proxy.nat.command.SessionEvents
+	(*FlushSessionsRequest)(nil),      // 23: xray.proxy.nat.command.FlushSessionsRequest
+	(*FlushSessionsResponse)(nil),     // 24: xray.proxy.nat.command.FlushSessionsResponse
+	(*ListSourceUsageRequest)(nil),    // 25: xray.proxy.nat.command.ListSourceUsageRequest
+	(*SourceUsage)(nil),               // 26: xray.proxy.nat.command.SourceUsage
+	(*ListSourceUsageResponse)(nil),   // 27: xray.proxy.nat.command.ListSourceUsageResponse
+	(*ListRulesRequest)(nil),          // 28: xray.proxy.nat.command.ListRulesRequest
+	(*Rule)(nil),                      // 29: xray.proxy.nat.command.Rule
+	(*ListRulesResponse)(nil),         // 30: xray.proxy.nat.command.ListRulesResponse
+	(*TestTranslationRequest)(nil),    // 31: xray.proxy.nat.command.TestTranslationRequest
+	(*TestTranslationResponse)(nil),   // 32: xray.proxy.nat.command.TestTranslationResponse
+	(*DrainRequest)(nil),              // 33: xray.proxy.nat.command.DrainRequest
+	(*DrainStatus)(nil),               // 34: xray.proxy.nat.command.DrainStatus
+	(*DrainResponse)(nil),             // 35: xray.proxy.nat.command.DrainResponse
+	(*ResumeRequest)(nil),             // 36: xray.proxy.nat.command.ResumeRequest
+	(*ResumeResponse)(nil),            // 37: xray.proxy.nat.command.ResumeResponse
+	(*GetDrainStatusRequest)(nil),     // 38: xray.proxy.nat.command.GetDrainStatusRequest
+	(*GetDrainStatusResponse)(nil),    // 39: xray.proxy.nat.command.GetDrainStatusResponse
+	(*DisableRulesRequest)(nil),       // 40: xray.proxy.nat.command.DisableRulesRequest
+	(*DisableRulesResponse)(nil),      // 41: xray.proxy.nat.command.DisableRulesResponse
+	(*EnableRulesRequest)(nil),        // 42: xray.proxy.nat.command.EnableRulesRequest
+	(*EnableRulesResponse)(nil),       // 43: xray.proxy.nat.command.EnableRulesResponse
+	(*AddRuleRequest)(nil),            // 44: xray.proxy.nat.command.AddRuleRequest
+	(*RuleConflict)(nil),              // 45: xray.proxy.nat.command.RuleConflict
+	(*AddRuleResponse)(nil),           // 46: xray.proxy.nat.command.AddRuleResponse
+	(*SimulatedFlow)(nil),             // 47: xray.proxy.nat.command.SimulatedFlow
+	(*SimulateRequest)(nil),           // 48: xray.proxy.nat.command.SimulateRequest
+	(*FlowSimulation)(nil),            // 49: xray.proxy.nat.command.FlowSimulation
+	(*RuleSimulation)(nil),            // 50: xray.proxy.nat.command.RuleSimulation
+	(*SimulateResponse)(nil),          // 51: xray.proxy.nat.command.SimulateResponse
+	(*SelfTestRequest)(nil),           // 52: xray.proxy.nat.command.SelfTestRequest
+	(*ComplianceCheck)(nil),           // 53: xray.proxy.nat.command.ComplianceCheck
+	(*SelfTestResult)(nil),            // 54: xray.proxy.nat.command.SelfTestResult
+	(*SelfTestResponse)(nil),          // 55: xray.proxy.nat.command.SelfTestResponse
+	(*Config)(nil),                    // 56: xray.proxy.nat.command.Config
+	(*nat.NATRule)(nil),               // 57: xray.proxy.nat.NATRule
+}
+var file_command_proto_depIdxs = []int32{
+	1,  // 0: xray.proxy.nat.command.ListMappingsResponse.mappings:type_name -> xray.proxy.nat.command.Mapping
+	3,  // 1: xray.proxy.nat.command.AllocateAddressResponse.lease:type_name -> xray.proxy.nat.command.Lease
+	3,  // 2: xray.proxy.nat.command.RenewLeaseResponse.lease:type_name -> xray.proxy.nat.command.Lease
+	3,  // 3: xray.proxy.nat.command.ListLeasesResponse.leases:type_name -> xray.proxy.nat.command.Lease
+	13, // 4: xray.proxy.nat.command.GetRuleStatsResponse.stats:type_name -> xray.proxy.nat.command.RuleStats
+	16, // 5: xray.proxy.nat.command.ListSessionsResponse.sessions:type_name -> xray.proxy.nat.command.Session
+	21, // 6: xray.proxy.nat.command.SessionEvents.events:type_name -> xray.proxy.nat.command.SessionEvent
+	26, // 7: xray.proxy.nat.command.ListSourceUsageResponse.sources:type_name -> xray.proxy.nat.command.SourceUsage
+	29, // 8: xray.proxy.nat.command.ListRulesResponse.rules:type_name -> xray.proxy.nat.command.Rule
+	34, // 9: xray.proxy.nat.command.DrainResponse.status:type_name -> xray.proxy.nat.command.DrainStatus
+	34, // 10: xray.proxy.nat.command.ResumeResponse.status:type_name -> xray.proxy.nat.command.DrainStatus
+	34, // 11: xray.proxy.nat.command.GetDrainStatusResponse.status:type_name -> xray.proxy.nat.command.DrainStatus
+	57, // 12: xray.proxy.nat.command.AddRuleRequest.rule:type_name -> xray.proxy.nat.NATRule
+	45, // 13: xray.proxy.nat.command.AddRuleResponse.conflicts:type_name -> xray.proxy.nat.command.RuleConflict
+	57, // 14: xray.proxy.nat.command.SimulateRequest.rules:type_name -> xray.proxy.nat.NATRule
+	47, // 15: xray.proxy.nat.command.SimulateRequest.flows:type_name -> xray.proxy.nat.command.SimulatedFlow
+	47, // 16: xray.proxy.nat.command.FlowSimulation.flow:type_name -> xray.proxy.nat.command.SimulatedFlow
+	45, // 17: xray.proxy.nat.command.RuleSimulation.conflicts:type_name -> xray.proxy.nat.command.RuleConflict
+	49, // 18: xray.proxy.nat.command.SimulateResponse.flows:type_name -> xray.proxy.nat.command.FlowSimulation
+	50, // 19: xray.proxy.nat.command.SimulateResponse.rules:type_name -> xray.proxy.nat.command.RuleSimulation
+	53, // 20: xray.proxy.nat.command.SelfTestResult.checks:type_name -> xray.proxy.nat.command.ComplianceCheck
+	54, // 21: xray.proxy.nat.command.SelfTestResponse.results:type_name -> xray.proxy.nat.command.SelfTestResult
+	0,  // 22: xray.proxy.nat.command.NATService.ListMappings:input_type -> xray.proxy.nat.command.ListMappingsRequest
+	4,  // 23: xray.proxy.nat.command.NATService.AllocateAddress:input_type -> xray.proxy.nat.command.AllocateAddressRequest
+	6,  // 24: xray.proxy.nat.command.NATService.RenewLease:input_type -> xray.proxy.nat.command.RenewLeaseRequest
+	8,  // 25: xray.proxy.nat.command.NATService.ReleaseAddress:input_type -> xray.proxy.nat.command.ReleaseAddressRequest
+	10, // 26: xray.proxy.nat.command.NATService.ListLeases:input_type -> xray.proxy.nat.command.ListLeasesRequest
+	12, // 27: xray.proxy.nat.command.NATService.GetRuleStats:input_type -> xray.proxy.nat.command.GetRuleStatsRequest
+	15, // 28: xray.proxy.nat.command.NATService.ListSessions:input_type -> xray.proxy.nat.command.ListSessionsRequest
+	18, // 29: xray.proxy.nat.command.NATService.ExportSessions:input_type -> xray.proxy.nat.command.ExportSessionsRequest
+	20, // 30: xray.proxy.nat.command.NATService.WatchSessionEvents:input_type -> xray.proxy.nat.command.WatchSessionEventsRequest
+	23, // 31: xray.proxy.nat.command.NATService.FlushSessions:input_type -> xray.proxy.nat.command.FlushSessionsRequest
+	25, // 32: xray.proxy.nat.command.NATService.ListSourceUsage:input_type -> xray.proxy.nat.command.ListSourceUsageRequest
+	28, // 33: xray.proxy.nat.command.NATService.ListRules:input_type -> xray.proxy.nat.command.ListRulesRequest
+	31, // 34: xray.proxy.nat.command.NATService.TestTranslation:input_type -> xray.proxy.nat.command.TestTranslationRequest
+	33, // 35: xray.proxy.nat.command.NATService.Drain:input_type -> xray.proxy.nat.command.DrainRequest
+	36, // 36: xray.proxy.nat.command.NATService.Resume:input_type -> xray.proxy.nat.command.ResumeRequest
+	38, // 37: xray.proxy.nat.command.NATService.GetDrainStatus:input_type -> xray.proxy.nat.command.GetDrainStatusRequest
+	40, // 38: xray.proxy.nat.command.NATService.DisableRules:input_type -> xray.proxy.nat.command.DisableRulesRequest
+	42, // 39: xray.proxy.nat.command.NATService.EnableRules:input_type -> xray.proxy.nat.command.EnableRulesRequest
+	44, // 40: xray.proxy.nat.command.NATService.AddRule:input_type -> xray.proxy.nat.command.AddRuleRequest
+	48, // 41: xray.proxy.nat.command.NATService.Simulate:input_type -> xray.proxy.nat.command.SimulateRequest
+	52, // 42: xray.proxy.nat.command.NATService.SelfTest:input_type -> xray.proxy.nat.command.SelfTestRequest
+	2,  // 43: xray.proxy.nat.command.NATService.ListMappings:output_type -> xray.proxy.nat.command.ListMappingsResponse
+	5,  // 44: xray.proxy.nat.command.NATService.AllocateAddress:output_type -> xray.proxy.nat.command.AllocateAddressResponse
+	7,  // 45: xray.proxy.nat.command.NATService.RenewLease:output_type -> xray.proxy.nat.command.RenewLeaseResponse
+	9,  // 46: xray.proxy.nat.command.NATService.ReleaseAddress:output_type -> xray.proxy.nat.command.ReleaseAddressResponse
+	11, // 47: xray.proxy.nat.command.NATService.ListLeases:output_type -> xray.proxy.nat.command.ListLeasesResponse
+	14, // 48: xray.proxy.nat.command.NATService.GetRuleStats:output_type -> xray.proxy.nat.command.GetRuleStatsResponse
+	17, // 49: xray.proxy.nat.command.NATService.ListSessions:output_type -> xray.proxy.nat.command.ListSessionsResponse
+	19, // 50: xray.proxy.nat.command.NATService.ExportSessions:output_type -> xray.proxy.nat.command.ExportSessionsResponse
+	22, // 51: xray.proxy.nat.command.NATService.WatchSessionEvents:output_type -> xray.proxy.nat.command.SessionEvents
+	24, // 52: xray.proxy.nat.command.NATService.FlushSessions:output_type -> xray.proxy.nat.command.FlushSessionsResponse
+	27, // 53: xray.proxy.nat.command.NATService.ListSourceUsage:output_type -> xray.proxy.nat.command.ListSourceUsageResponse
+	30, // 54: xray.proxy.nat.command.NATService.ListRules:output_type -> xray.proxy.nat.command.ListRulesResponse
+	32, // 55: xray.proxy.nat.command.NATService.TestTranslation:output_type -> xray.proxy.nat.command.TestTranslationResponse
+	35, // 56: xray.proxy.nat.command.NATService.Drain:output_type -> xray.proxy.nat.command.DrainResponse
+	37, // 57: xray.proxy.nat.command.NATService.Resume:output_type -> xray.proxy.nat.command.ResumeResponse
+	39, // 58: xray.proxy.nat.command.NATService.GetDrainStatus:output_type -> xray.proxy.nat.command.GetDrainStatusResponse
+	41, // 59: xray.proxy.nat.command.NATService.DisableRules:output_type -> xray.proxy.nat.command.DisableRulesResponse
+	43, // 60: xray.proxy.nat.command.NATService.EnableRules:output_type -> xray.proxy.nat.command.EnableRulesResponse
+	46, // 61: xray.proxy.nat.command.NATService.AddRule:output_type -> xray.proxy.nat.command.AddRuleResponse
+	51, // 62: xray.proxy.nat.command.NATService.Simulate:output_type -> xray.proxy.nat.command.SimulateResponse
+	55, // 63: xray.proxy.nat.command.NATService.SelfTest:output_type -> xray.proxy.nat.command.SelfTestResponse
+	43, // [43:64] is the sub-list for method output_type
+	22, // [22:43] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
+}
+
+func init() { file_command_proto_init() }
+func file_command_proto_init() {
+	if File_command_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_command_proto_rawDesc), len(file_command_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   57,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_command_proto_goTypes,
+		DependencyIndexes: file_command_proto_depIdxs,
+		MessageInfos:      file_command_proto_msgTypes,
+	}.Build()
+	File_command_proto = out.File
+	file_command_proto_goTypes = nil
+	file_command_proto_depIdxs = nil
+}
@@ -0,0 +1,921 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.33.0
+// source: command.proto
+
+package command
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	NATService_ListMappings_FullMethodName       = "/xray.proxy.nat.command.NATService/ListMappings"
+	NATService_AllocateAddress_FullMethodName    = "/xray.proxy.nat.command.NATService/AllocateAddress"
+	NATService_RenewLease_FullMethodName         = "/xray.proxy.nat.command.NATService/RenewLease"
+	NATService_ReleaseAddress_FullMethodName     = "/xray.proxy.nat.command.NATService/ReleaseAddress"
+	NATService_ListLeases_FullMethodName         = "/xray.proxy.nat.command.NATService/ListLeases"
+	NATService_GetRuleStats_FullMethodName       = "/xray.proxy.nat.command.NATService/GetRuleStats"
+	NATService_ListSessions_FullMethodName       = "/xray.proxy.nat.command.NATService/ListSessions"
+	NATService_ExportSessions_FullMethodName     = "/xray.proxy.nat.command.NATService/ExportSessions"
+	NATService_WatchSessionEvents_FullMethodName = "/xray.proxy.nat.command.NATService/WatchSessionEvents"
+	NATService_FlushSessions_FullMethodName      = "/xray.proxy.nat.command.NATService/FlushSessions"
+	NATService_ListSourceUsage_FullMethodName    = "/xray.proxy.nat.command.NATService/ListSourceUsage"
+	NATService_ListRules_FullMethodName          = "/xray.proxy.nat.command.NATService/ListRules"
+	NATService_TestTranslation_FullMethodName    = "/xray.proxy.nat.command.NATService/TestTranslation"
+	NATService_Drain_FullMethodName              = "/xray.proxy.nat.command.NATService/Drain"
+	NATService_Resume_FullMethodName             = "/xray.proxy.nat.command.NATService/Resume"
+	NATService_GetDrainStatus_FullMethodName     = "/xray.proxy.nat.command.NATService/GetDrainStatus"
+	NATService_DisableRules_FullMethodName       = "/xray.proxy.nat.command.NATService/DisableRules"
+	NATService_EnableRules_FullMethodName        = "/xray.proxy.nat.command.NATService/EnableRules"
+	NATService_AddRule_FullMethodName            = "/xray.proxy.nat.command.NATService/AddRule"
+	NATService_Simulate_FullMethodName           = "/xray.proxy.nat.command.NATService/Simulate"
+	NATService_SelfTest_FullMethodName           = "/xray.proxy.nat.command.NATService/SelfTest"
+)
+
+// NATServiceClient is the client API for NATService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NATServiceClient interface {
+	ListMappings(ctx context.Context, in *ListMappingsRequest, opts ...grpc.CallOption) (*ListMappingsResponse, error)
+	// Virtual addresses of the address pool leased to real hosts.
+	AllocateAddress(ctx context.Context, in *AllocateAddressRequest, opts ...grpc.CallOption) (*AllocateAddressResponse, error)
+	RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*RenewLeaseResponse, error)
+	ReleaseAddress(ctx context.Context, in *ReleaseAddressRequest, opts ...grpc.CallOption) (*ReleaseAddressResponse, error)
+	ListLeases(ctx context.Context, in *ListLeasesRequest, opts ...grpc.CallOption) (*ListLeasesResponse, error)
+	// Counters of the rules, to find rules that never match and the busiest ones.
+	GetRuleStats(ctx context.Context, in *GetRuleStatsRequest, opts ...grpc.CallOption) (*GetRuleStatsResponse, error)
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	// Exports a snapshot of the session table, or its differences from an earlier snapshot.
+	ExportSessions(ctx context.Context, in *ExportSessionsRequest, opts ...grpc.CallOption) (*ExportSessionsResponse, error)
+	// Streams the session create and teardown events from now on, in batches.
+	WatchSessionEvents(ctx context.Context, in *WatchSessionEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SessionEvents], error)
+	// Drops sessions and closes the connections relaying them.
+	FlushSessions(ctx context.Context, in *FlushSessionsRequest, opts ...grpc.CallOption) (*FlushSessionsResponse, error)
+	// Usage of the per-source session quotas and rate limits.
+	ListSourceUsage(ctx context.Context, in *ListSourceUsageRequest, opts ...grpc.CallOption) (*ListSourceUsageResponse, error)
+	ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*ListRulesResponse, error)
+	// Reports how a flow would be translated, without creating a session.
+	TestTranslation(ctx context.Context, in *TestTranslationRequest, opts ...grpc.CallOption) (*TestTranslationResponse, error)
+	// Stops creating sessions for new flows while the existing ones finish, for maintenance.
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error)
+	Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error)
+	GetDrainStatus(ctx context.Context, in *GetDrainStatusRequest, opts ...grpc.CallOption) (*GetDrainStatusResponse, error)
+	// Rules disabled, through the API or in the configuration, are matched as if they did not
+	// exist while keeping their sessions and statistics, until enabled again.
+	DisableRules(ctx context.Context, in *DisableRulesRequest, opts ...grpc.CallOption) (*DisableRulesResponse, error)
+	EnableRules(ctx context.Context, in *EnableRulesRequest, opts ...grpc.CallOption) (*EnableRulesResponse, error)
+	// Adds a rule after the active rules, kept after those of the rules file or the controller
+	// across their reloads. A rule conflicting with active rules is not added and the conflicts
+	// are returned.
+	AddRule(ctx context.Context, in *AddRuleRequest, opts ...grpc.CallOption) (*AddRuleResponse, error)
+	// Replays the flows of a traffic sample against candidate rules, without sessions and leaving
+	// the active rules unchanged, reporting the rule taking each flow, the flows bypassed, blocked
+	// or treated differently from the active rules and the conflicts between candidate rules.
+	Simulate(ctx context.Context, in *SimulateRequest, opts ...grpc.CallOption) (*SimulateResponse, error)
+	// Checks the running behavior against RFC 4787, RFC 5382 and RFC 5508, probing the UDP
+	// mappings through loopback servers.
+	SelfTest(ctx context.Context, in *SelfTestRequest, opts ...grpc.CallOption) (*SelfTestResponse, error)
+}
+
+type nATServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNATServiceClient(cc grpc.ClientConnInterface) NATServiceClient {
+	return &nATServiceClient{cc}
+}
+
+func (c *nATServiceClient) ListMappings(ctx context.Context, in *ListMappingsRequest, opts ...grpc.CallOption) (*ListMappingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMappingsResponse)
+	err := c.cc.Invoke(ctx, NATService_ListMappings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) AllocateAddress(ctx context.Context, in *AllocateAddressRequest, opts ...grpc.CallOption) (*AllocateAddressResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AllocateAddressResponse)
+	err := c.cc.Invoke(ctx, NATService_AllocateAddress_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*RenewLeaseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RenewLeaseResponse)
+	err := c.cc.Invoke(ctx, NATService_RenewLease_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) ReleaseAddress(ctx context.Context, in *ReleaseAddressRequest, opts ...grpc.CallOption) (*ReleaseAddressResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseAddressResponse)
+	err := c.cc.Invoke(ctx, NATService_ReleaseAddress_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) ListLeases(ctx context.Context, in *ListLeasesRequest, opts ...grpc.CallOption) (*ListLeasesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListLeasesResponse)
+	err := c.cc.Invoke(ctx, NATService_ListLeases_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) GetRuleStats(ctx context.Context, in *GetRuleStatsRequest, opts ...grpc.CallOption) (*GetRuleStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetRuleStatsResponse)
+	err := c.cc.Invoke(ctx, NATService_GetRuleStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, NATService_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) ExportSessions(ctx context.Context, in *ExportSessionsRequest, opts ...grpc.CallOption) (*ExportSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExportSessionsResponse)
+	err := c.cc.Invoke(ctx, NATService_ExportSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) WatchSessionEvents(ctx context.Context, in *WatchSessionEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SessionEvents], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &NATService_ServiceDesc.Streams[0], NATService_WatchSessionEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchSessionEventsRequest, SessionEvents]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type NATService_WatchSessionEventsClient = grpc.ServerStreamingClient[SessionEvents]
+
+func (c *nATServiceClient) FlushSessions(ctx context.Context, in *FlushSessionsRequest, opts ...grpc.CallOption) (*FlushSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlushSessionsResponse)
+	err := c.cc.Invoke(ctx, NATService_FlushSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) ListSourceUsage(ctx context.Context, in *ListSourceUsageRequest, opts ...grpc.CallOption) (*ListSourceUsageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSourceUsageResponse)
+	err := c.cc.Invoke(ctx, NATService_ListSourceUsage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*ListRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRulesResponse)
+	err := c.cc.Invoke(ctx, NATService_ListRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) TestTranslation(ctx context.Context, in *TestTranslationRequest, opts ...grpc.CallOption) (*TestTranslationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TestTranslationResponse)
+	err := c.cc.Invoke(ctx, NATService_TestTranslation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DrainResponse)
+	err := c.cc.Invoke(ctx, NATService_Drain_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResumeResponse)
+	err := c.cc.Invoke(ctx, NATService_Resume_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) GetDrainStatus(ctx context.Context, in *GetDrainStatusRequest, opts ...grpc.CallOption) (*GetDrainStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDrainStatusResponse)
+	err := c.cc.Invoke(ctx, NATService_GetDrainStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) DisableRules(ctx context.Context, in *DisableRulesRequest, opts ...grpc.CallOption) (*DisableRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DisableRulesResponse)
+	err := c.cc.Invoke(ctx, NATService_DisableRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) EnableRules(ctx context.Context, in *EnableRulesRequest, opts ...grpc.CallOption) (*EnableRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnableRulesResponse)
+	err := c.cc.Invoke(ctx, NATService_EnableRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) AddRule(ctx context.Context, in *AddRuleRequest, opts ...grpc.CallOption) (*AddRuleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddRuleResponse)
+	err := c.cc.Invoke(ctx, NATService_AddRule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) Simulate(ctx context.Context, in *SimulateRequest, opts ...grpc.CallOption) (*SimulateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SimulateResponse)
+	err := c.cc.Invoke(ctx, NATService_Simulate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nATServiceClient) SelfTest(ctx context.Context, in *SelfTestRequest, opts ...grpc.CallOption) (*SelfTestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SelfTestResponse)
+	err := c.cc.Invoke(ctx, NATService_SelfTest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NATServiceServer is the server API for NATService service.
+// All implementations must embed UnimplementedNATServiceServer
+// for forward compatibility.
+type NATServiceServer interface {
+	ListMappings(context.Context, *ListMappingsRequest) (*ListMappingsResponse, error)
+	// Virtual addresses of the address pool leased to real hosts.
+	AllocateAddress(context.Context, *AllocateAddressRequest) (*AllocateAddressResponse, error)
+	RenewLease(context.Context, *RenewLeaseRequest) (*RenewLeaseResponse, error)
+	ReleaseAddress(context.Context, *ReleaseAddressRequest) (*ReleaseAddressResponse, error)
+	ListLeases(context.Context, *ListLeasesRequest) (*ListLeasesResponse, error)
+	// Counters of the rules, to find rules that never match and the busiest ones.
+	GetRuleStats(context.Context, *GetRuleStatsRequest) (*GetRuleStatsResponse, error)
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	// Exports a snapshot of the session table, or its differences from an earlier snapshot.
+	ExportSessions(context.Context, *ExportSessionsRequest) (*ExportSessionsResponse, error)
+	// Streams the session create and teardown events from now on, in batches.
+	WatchSessionEvents(*WatchSessionEventsRequest, grpc.ServerStreamingServer[SessionEvents]) error
+	// Drops sessions and closes the connections relaying them.
+	FlushSessions(context.Context, *FlushSessionsRequest) (*FlushSessionsResponse, error)
+	// Usage of the per-source session quotas and rate limits.
+	ListSourceUsage(context.Context, *ListSourceUsageRequest) (*ListSourceUsageResponse, error)
+	ListRules(context.Context, *ListRulesRequest) (*ListRulesResponse, error)
+	// Reports how a flow would be translated, without creating a session.
+	TestTranslation(context.Context, *TestTranslationRequest) (*TestTranslationResponse, error)
+	// Stops creating sessions for new flows while the existing ones finish, for maintenance.
+	Drain(context.Context, *DrainRequest) (*DrainResponse, error)
+	Resume(context.Context, *ResumeRequest) (*ResumeResponse, error)
+	GetDrainStatus(context.Context, *GetDrainStatusRequest) (*GetDrainStatusResponse, error)
+	// Rules disabled, through the API or in the configuration, are matched as if they did not
+	// exist while keeping their sessions and statistics, until enabled again.
+	DisableRules(context.Context, *DisableRulesRequest) (*DisableRulesResponse, error)
+	EnableRules(context.Context, *EnableRulesRequest) (*EnableRulesResponse, error)
+	// Adds a rule after the active rules, kept after those of the rules file or the controller
+	// across their reloads. A rule conflicting with active rules is not added and the conflicts
+	// are returned.
+	AddRule(context.Context, *AddRuleRequest) (*AddRuleResponse, error)
+	// Replays the flows of a traffic sample against candidate rules, without sessions and leaving
+	// the active rules unchanged, reporting the rule taking each flow, the flows bypassed, blocked
+	// or treated differently from the active rules and the conflicts between candidate rules.
+	Simulate(context.Context, *SimulateRequest) (*SimulateResponse, error)
+	// Checks the running behavior against RFC 4787, RFC 5382 and RFC 5508, probing the UDP
+	// mappings through loopback servers.
+	SelfTest(context.Context, *SelfTestRequest) (*SelfTestResponse, error)
+	mustEmbedUnimplementedNATServiceServer()
+}
+
+// UnimplementedNATServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedNATServiceServer struct{}
+
+func (UnimplementedNATServiceServer) ListMappings(context.Context, *ListMappingsRequest) (*ListMappingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMappings not implemented")
+}
+func (UnimplementedNATServiceServer) AllocateAddress(context.Context, *AllocateAddressRequest) (*AllocateAddressResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AllocateAddress not implemented")
+}
+func (UnimplementedNATServiceServer) RenewLease(context.Context, *RenewLeaseRequest) (*RenewLeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewLease not implemented")
+}
+func (UnimplementedNATServiceServer) ReleaseAddress(context.Context, *ReleaseAddressRequest) (*ReleaseAddressResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseAddress not implemented")
+}
+func (UnimplementedNATServiceServer) ListLeases(context.Context, *ListLeasesRequest) (*ListLeasesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLeases not implemented")
+}
+func (UnimplementedNATServiceServer) GetRuleStats(context.Context, *GetRuleStatsRequest) (*GetRuleStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRuleStats not implemented")
+}
+func (UnimplementedNATServiceServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedNATServiceServer) ExportSessions(context.Context, *ExportSessionsRequest) (*ExportSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportSessions not implemented")
+}
+func (UnimplementedNATServiceServer) WatchSessionEvents(*WatchSessionEventsRequest, grpc.ServerStreamingServer[SessionEvents]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchSessionEvents not implemented")
+}
+func (UnimplementedNATServiceServer) FlushSessions(context.Context, *FlushSessionsRequest) (*FlushSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FlushSessions not implemented")
+}
+func (UnimplementedNATServiceServer) ListSourceUsage(context.Context, *ListSourceUsageRequest) (*ListSourceUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSourceUsage not implemented")
+}
+func (UnimplementedNATServiceServer) ListRules(context.Context, *ListRulesRequest) (*ListRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRules not implemented")
+}
+func (UnimplementedNATServiceServer) TestTranslation(context.Context, *TestTranslationRequest) (*TestTranslationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TestTranslation not implemented")
+}
+func (UnimplementedNATServiceServer) Drain(context.Context, *DrainRequest) (*DrainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedNATServiceServer) Resume(context.Context, *ResumeRequest) (*ResumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
+}
+func (UnimplementedNATServiceServer) GetDrainStatus(context.Context, *GetDrainStatusRequest) (*GetDrainStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDrainStatus not implemented")
+}
+func (UnimplementedNATServiceServer) DisableRules(context.Context, *DisableRulesRequest) (*DisableRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableRules not implemented")
+}
+func (UnimplementedNATServiceServer) EnableRules(context.Context, *EnableRulesRequest) (*EnableRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnableRules not implemented")
+}
+func (UnimplementedNATServiceServer) AddRule(context.Context, *AddRuleRequest) (*AddRuleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddRule not implemented")
+}
+func (UnimplementedNATServiceServer) Simulate(context.Context, *SimulateRequest) (*SimulateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Simulate not implemented")
+}
+func (UnimplementedNATServiceServer) SelfTest(context.Context, *SelfTestRequest) (*SelfTestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SelfTest not implemented")
+}
+func (UnimplementedNATServiceServer) mustEmbedUnimplementedNATServiceServer() {}
+func (UnimplementedNATServiceServer) testEmbeddedByValue()                    {}
+
+// UnsafeNATServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NATServiceServer will
+// result in compilation errors.
+type UnsafeNATServiceServer interface {
+	mustEmbedUnimplementedNATServiceServer()
+}
+
+func RegisterNATServiceServer(s grpc.ServiceRegistrar, srv NATServiceServer) {
+	// If the following call pancis, it indicates UnimplementedNATServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&NATService_ServiceDesc, srv)
+}
+
+func _NATService_ListMappings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMappingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ListMappings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ListMappings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ListMappings(ctx, req.(*ListMappingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_AllocateAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllocateAddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).AllocateAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_AllocateAddress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).AllocateAddress(ctx, req.(*AllocateAddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_RenewLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).RenewLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_RenewLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).RenewLease(ctx, req.(*RenewLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_ReleaseAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseAddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ReleaseAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ReleaseAddress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ReleaseAddress(ctx, req.(*ReleaseAddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_ListLeases_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLeasesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ListLeases(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ListLeases_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ListLeases(ctx, req.(*ListLeasesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_GetRuleStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRuleStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).GetRuleStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_GetRuleStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).GetRuleStats(ctx, req.(*GetRuleStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_ExportSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ExportSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ExportSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ExportSessions(ctx, req.(*ExportSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_WatchSessionEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchSessionEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NATServiceServer).WatchSessionEvents(m, &grpc.GenericServerStream[WatchSessionEventsRequest, SessionEvents]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type NATService_WatchSessionEventsServer = grpc.ServerStreamingServer[SessionEvents]
+
+func _NATService_FlushSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FlushSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).FlushSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_FlushSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).FlushSessions(ctx, req.(*FlushSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_ListSourceUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSourceUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ListSourceUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ListSourceUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ListSourceUsage(ctx, req.(*ListSourceUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_ListRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).ListRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_ListRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).ListRules(ctx, req.(*ListRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_TestTranslation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TestTranslationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).TestTranslation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_TestTranslation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).TestTranslation(ctx, req.(*TestTranslationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_Drain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_Resume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_Resume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).Resume(ctx, req.(*ResumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_GetDrainStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDrainStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).GetDrainStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_GetDrainStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).GetDrainStatus(ctx, req.(*GetDrainStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_DisableRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).DisableRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_DisableRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).DisableRules(ctx, req.(*DisableRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_EnableRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnableRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).EnableRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_EnableRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).EnableRules(ctx, req.(*EnableRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_AddRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRuleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).AddRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_AddRule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).AddRule(ctx, req.(*AddRuleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_Simulate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SimulateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).Simulate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_Simulate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).Simulate(ctx, req.(*SimulateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NATService_SelfTest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SelfTestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NATServiceServer).SelfTest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NATService_SelfTest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NATServiceServer).SelfTest(ctx, req.(*SelfTestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NATService_ServiceDesc is the grpc.ServiceDesc for NATService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var NATService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "xray.proxy.nat.command.NATService",
+	HandlerType: (*NATServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMappings",
+			Handler:    _NATService_ListMappings_Handler,
+		},
+		{
+			MethodName: "AllocateAddress",
+			Handler:    _NATService_AllocateAddress_Handler,
+		},
+		{
+			MethodName: "RenewLease",
+			Handler:    _NATService_RenewLease_Handler,
+		},
+		{
+			MethodName: "ReleaseAddress",
+			Handler:    _NATService_ReleaseAddress_Handler,
+		},
+		{
+			MethodName: "ListLeases",
+			Handler:    _NATService_ListLeases_Handler,
+		},
+		{
+			MethodName: "GetRuleStats",
+			Handler:    _NATService_GetRuleStats_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _NATService_ListSessions_Handler,
+		},
+		{
+			MethodName: "ExportSessions",
+			Handler:    _NATService_ExportSessions_Handler,
+		},
+		{
+			MethodName: "FlushSessions",
+			Handler:    _NATService_FlushSessions_Handler,
+		},
+		{
+			MethodName: "ListSourceUsage",
+			Handler:    _NATService_ListSourceUsage_Handler,
+		},
+		{
+			MethodName: "ListRules",
+			Handler:    _NATService_ListRules_Handler,
+		},
+		{
+			MethodName: "TestTranslation",
+			Handler:    _NATService_TestTranslation_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _NATService_Drain_Handler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    _NATService_Resume_Handler,
+		},
+		{
+			MethodName: "GetDrainStatus",
+			Handler:    _NATService_GetDrainStatus_Handler,
+		},
+		{
+			MethodName: "DisableRules",
+			Handler:    _NATService_DisableRules_Handler,
+		},
+		{
+			MethodName: "EnableRules",
+			Handler:    _NATService_EnableRules_Handler,
+		},
+		{
+			MethodName: "AddRule",
+			Handler:    _NATService_AddRule_Handler,
+		},
+		{
+			MethodName: "Simulate",
+			Handler:    _NATService_Simulate_Handler,
+		},
+		{
+			MethodName: "SelfTest",
+			Handler:    _NATService_SelfTest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchSessionEvents",
+			Handler:       _NATService_WatchSessionEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "command.proto",
+}
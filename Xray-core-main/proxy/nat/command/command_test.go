@@ -0,0 +1,571 @@
+package command_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/nat"
+	. "github.com/xtls/xray-core/proxy/nat/command"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type testHandler struct {
+	outbound.Handler
+	tag   string
+	proxy proxy.Outbound
+}
+
+func (h *testHandler) Tag() string                 { return h.tag }
+func (h *testHandler) GetOutbound() proxy.Outbound { return h.proxy }
+
+type testManager struct {
+	outbound.Manager
+	handlers []outbound.Handler
+}
+
+func (m *testManager) GetHandler(tag string) outbound.Handler {
+	for _, handler := range m.handlers {
+		if handler.Tag() == tag {
+			return handler
+		}
+	}
+	return nil
+}
+
+func (m *testManager) ListHandlers(ctx context.Context) []outbound.Handler {
+	return m.handlers
+}
+
+func TestListMappings(t *testing.T) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	common.Must(err)
+	listen := probe.LocalAddr().String()
+	probe.Close()
+
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId:      "test-site",
+		PortControl: &nat.PortControl{Listen: listen, MappingAddress: "127.0.0.1"},
+	}, nil))
+	defer handler.Close()
+
+	// Open a mapping with NAT-PMP
+	client, err := net.Dial("udp", listen)
+	common.Must(err)
+	defer client.Close()
+	request := make([]byte, 12)
+	request[1] = 1
+	binary.BigEndian.PutUint16(request[4:6], 5000)
+	binary.BigEndian.PutUint32(request[8:12], 600)
+	_, err = client.Write(request)
+	common.Must(err)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, 16)
+	_, err = client.Read(response)
+	common.Must(err)
+	external := binary.BigEndian.Uint16(response[10:12])
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{
+		&testHandler{tag: "nat", proxy: handler},
+		&testHandler{tag: "direct"},
+	}})
+
+	resp, err := s.ListMappings(context.Background(), &ListMappingsRequest{})
+	common.Must(err)
+	if len(resp.Mappings) != 1 {
+		t.Fatalf("Expected one mapping, got %v", resp.Mappings)
+	}
+	m := resp.Mappings[0]
+	if m.Tag != "nat" || m.Protocol != "natpmp" || m.Network != "udp" || m.Internal != "127.0.0.1:5000" ||
+		m.External != net.JoinHostPort("127.0.0.1", strconv.Itoa(int(external))) || m.Expires <= time.Now().Unix() {
+		t.Errorf("Unexpected mapping %v", m)
+	}
+
+	if _, err := s.ListMappings(context.Background(), &ListMappingsRequest{Tag: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+	if _, err := s.ListMappings(context.Background(), &ListMappingsRequest{Tag: "direct"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestAddressLeases(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId:      "test-site",
+		AddressPool: &nat.AddressPool{Network: "240.5.5.0/24"},
+		AuditLog:    &nat.SessionLog{Sink: "file", Path: auditPath},
+	}, nil))
+	defer handler.Close()
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{
+		&testHandler{tag: "nat", proxy: handler},
+		&testHandler{tag: "direct"},
+	}})
+
+	client := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 50000}})
+	allocated, err := s.AllocateAddress(client, &AllocateAddressRequest{RealAddress: "192.168.1.20", Ttl: 60})
+	common.Must(err)
+	if lease := allocated.Lease; lease.Tag != "nat" || lease.VirtualAddress != "240.5.5.1" || lease.RealAddress != "192.168.1.20" {
+		t.Errorf("Unexpected lease %v", lease)
+	}
+	renewed, err := s.RenewLease(context.Background(), &RenewLeaseRequest{VirtualAddress: "240.5.5.1", Ttl: 600})
+	common.Must(err)
+	if renewed.Lease.Expires <= allocated.Lease.Expires {
+		t.Errorf("Expected the lease to be extended, got %v", renewed.Lease)
+	}
+
+	listed, err := s.ListLeases(context.Background(), &ListLeasesRequest{})
+	common.Must(err)
+	if len(listed.Leases) != 1 || listed.Leases[0].VirtualAddress != "240.5.5.1" {
+		t.Errorf("Unexpected leases %v", listed.Leases)
+	}
+
+	_, err = s.ReleaseAddress(context.Background(), &ReleaseAddressRequest{VirtualAddress: "240.5.5.1"})
+	common.Must(err)
+	if _, err := s.ReleaseAddress(context.Background(), &ReleaseAddressRequest{VirtualAddress: "240.5.5.1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+	if _, err := s.AllocateAddress(context.Background(), &AllocateAddressRequest{RealAddress: "host"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+	if _, err := s.AllocateAddress(context.Background(), &AllocateAddressRequest{Tag: "direct", RealAddress: "192.168.1.20"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+
+	// Every action that reached the NAT outbound is audited, failed ones with their error
+	data, err := os.ReadFile(auditPath)
+	common.Must(err)
+	var actions []string
+	var events []nat.AuditEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event nat.AuditEvent
+		common.Must(json.Unmarshal([]byte(line), &event))
+		actions = append(actions, event.Action)
+		events = append(events, event)
+	}
+	if strings.Join(actions, ",") != "allocateAddress,renewLease,releaseAddress,releaseAddress" {
+		t.Fatalf("Unexpected audited actions %v", actions)
+	}
+	if allocate := events[0]; allocate.Actor != "api 198.51.100.7:50000" || allocate.Target != "192.168.1.20" || allocate.Detail != "virtual=240.5.5.1 ttl=60" || allocate.SiteID != "test-site" {
+		t.Errorf("Unexpected audit of the allocation %+v", allocate)
+	}
+	if events[2].Error != "" || events[3].Error == "" {
+		t.Errorf("Expected only the second release to fail, got %+v and %+v", events[2], events[3])
+	}
+}
+
+func TestGetRuleStats(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId: "test-site",
+		Rules: []*nat.NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+			{RuleId: "db", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30"},
+		},
+	}, nil))
+	defer handler.Close()
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+	response, err := s.GetRuleStats(context.Background(), &GetRuleStatsRequest{})
+	common.Must(err)
+	if len(response.Stats) != 2 || response.Stats[0].RuleId != "web" || response.Stats[1].RuleId != "db" {
+		t.Fatalf("Expected the stats of both rules in order, got %v", response.Stats)
+	}
+	if stats := response.Stats[1]; stats.Tag != "nat" || stats.Hits != 0 || stats.LastHit != 0 {
+		t.Errorf("Expected a rule that never matched, got %v", stats)
+	}
+}
+
+func TestRulesAndTranslation(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId: "test-site",
+		Rules: []*nat.NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Protocol: "tcp"},
+			{RuleId: "dns", VirtualDestination: "240.2.2.53", RealDestinations: []string{"192.168.1.53", "192.168.1.54"}, Protocol: "udp"},
+			{RuleId: "ssh", VirtualDestination: "240.2.2.0/24", Ports: "22", Action: "deny"},
+		},
+	}, nil))
+	defer handler.Close()
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+
+	rules, err := s.ListRules(context.Background(), &ListRulesRequest{})
+	common.Must(err)
+	if len(rules.Rules) != 3 || rules.Rules[0].RuleId != "web" || len(rules.Rules[1].RealDestinations) != 2 || rules.Rules[2].Action != "deny" {
+		t.Errorf("Unexpected rules %v", rules.Rules)
+	}
+
+	translation, err := s.TestTranslation(context.Background(), &TestTranslationRequest{Destination: "240.2.2.20:80"})
+	common.Must(err)
+	if !translation.Matched || translation.RuleId != "web" || translation.VirtualDestination != "240.2.2.20:80" || translation.RealDestination != "192.168.1.20:80" || translation.RealSource != "" {
+		t.Errorf("Unexpected translation %v", translation)
+	}
+	translation, err = s.TestTranslation(context.Background(), &TestTranslationRequest{Network: "udp", Destination: "240.2.2.53:53"})
+	common.Must(err)
+	if !translation.Matched || translation.RuleId != "dns" {
+		t.Errorf("Unexpected translation %v", translation)
+	}
+	translation, err = s.TestTranslation(context.Background(), &TestTranslationRequest{Destination: "240.2.2.30:22"})
+	common.Must(err)
+	if !translation.Matched || translation.RuleId != "ssh" || translation.Action != "deny" || translation.RealDestination != "" {
+		t.Errorf("Expected the flow to be denied, got %v", translation)
+	}
+	if _, err := s.TestTranslation(context.Background(), &TestTranslationRequest{Destination: "240.2.2.20"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+
+	sessions, err := s.ListSessions(context.Background(), &ListSessionsRequest{})
+	common.Must(err)
+	flushed, err := s.FlushSessions(context.Background(), &FlushSessionsRequest{Tag: "nat"})
+	common.Must(err)
+	if len(sessions.Sessions) != 0 || flushed.Flushed != 0 {
+		t.Errorf("Expected no sessions, got %v and %d flushed", sessions.Sessions, flushed.Flushed)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{SiteId: "test-site"}, nil))
+	defer handler.Close()
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+	response, err := s.Drain(context.Background(), &DrainRequest{Action: "bypass", Deadline: 600})
+	common.Must(err)
+	if len(response.Status) != 1 {
+		t.Fatalf("Expected the status of the outbound, got %v", response.Status)
+	}
+	if drain := response.Status[0]; drain.Tag != "nat" || !drain.Draining || drain.Action != "bypass" || drain.Since == 0 || drain.Deadline < drain.Since+600 {
+		t.Errorf("Unexpected drain status %v", drain)
+	}
+	if _, err := s.Drain(context.Background(), &DrainRequest{Action: "drop"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an unknown action to be an invalid argument, got %v", err)
+	}
+
+	statusResponse, err := s.GetDrainStatus(context.Background(), &GetDrainStatusRequest{Tag: "nat"})
+	common.Must(err)
+	if drain := statusResponse.Status[0]; !drain.Draining || drain.Sessions != 0 {
+		t.Errorf("Expected the outbound to drain with no sessions left, got %v", drain)
+	}
+
+	resumeResponse, err := s.Resume(context.Background(), &ResumeRequest{})
+	common.Must(err)
+	if drain := resumeResponse.Status[0]; drain.Draining || drain.Since != 0 || drain.Deadline != 0 {
+		t.Errorf("Expected the drain to end, got %v", drain)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	compliant := nat.New()
+	common.Must(compliant.Init(&nat.Config{SiteId: "test-site", ComplianceMode: "rfc4787"}, nil))
+	defer compliant.Close()
+	plain := nat.New()
+	common.Must(plain.Init(&nat.Config{SiteId: "test-site"}, nil))
+	defer plain.Close()
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{
+		&testHandler{tag: "nat", proxy: compliant},
+		&testHandler{tag: "legacy", proxy: plain},
+	}})
+	response, err := s.SelfTest(context.Background(), &SelfTestRequest{})
+	common.Must(err)
+	if len(response.Results) != 2 {
+		t.Fatalf("Expected the results of both outbounds, got %v", response.Results)
+	}
+	if legacy := response.Results[0]; legacy.Tag != "legacy" || legacy.Passed || legacy.ComplianceMode != "" || len(legacy.Checks) == 0 {
+		t.Errorf("Expected the outbound without the compliance mode to fail, got %v", legacy)
+	}
+	if result := response.Results[1]; result.Tag != "nat" || !result.Passed || result.ComplianceMode != "rfc4787" {
+		t.Errorf("Expected the compliant outbound to pass, got %v", result)
+	}
+}
+
+func TestRuleTags(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId: "test-site",
+		Rules: []*nat.NATRule{
+			{RuleId: "backup", VirtualDestination: "240.2.2.20", RealDestination: "192.168.9.20", Tags: []string{"backup-window"}},
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+		},
+	}, nil))
+	defer handler.Close()
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+
+	rules, err := s.ListRules(context.Background(), &ListRulesRequest{RuleTag: "backup-window"})
+	common.Must(err)
+	if len(rules.Rules) != 1 || rules.Rules[0].RuleId != "backup" || len(rules.Rules[0].Tags) != 1 || rules.Rules[0].Disabled {
+		t.Errorf("Unexpected rules of tag backup-window %v", rules.Rules)
+	}
+	stats, err := s.GetRuleStats(context.Background(), &GetRuleStatsRequest{RuleTag: "backup-window"})
+	common.Must(err)
+	if len(stats.Stats) != 1 || stats.Stats[0].RuleId != "backup" || stats.Stats[0].Tags[0] != "backup-window" {
+		t.Errorf("Unexpected stats of tag backup-window %v", stats.Stats)
+	}
+
+	if _, err := s.DisableRules(context.Background(), &DisableRulesRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a selection of no rules to be an invalid argument, got %v", err)
+	}
+	if _, err := s.FlushSessions(context.Background(), &FlushSessionsRequest{RuleId: "web", RuleTag: "backup-window"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a selection by both ruleId and tag to be an invalid argument, got %v", err)
+	}
+
+	disabled, err := s.DisableRules(context.Background(), &DisableRulesRequest{RuleTag: "backup-window"})
+	common.Must(err)
+	if disabled.Rules != 1 {
+		t.Errorf("Expected a rule to be disabled, got %d", disabled.Rules)
+	}
+	translation, err := s.TestTranslation(context.Background(), &TestTranslationRequest{Destination: "240.2.2.20:80"})
+	common.Must(err)
+	if translation.RuleId != "web" {
+		t.Errorf("Expected the flow to match rule web, got %v", translation)
+	}
+	rules, err = s.ListRules(context.Background(), &ListRulesRequest{})
+	common.Must(err)
+	if !rules.Rules[0].Disabled || rules.Rules[1].Disabled {
+		t.Errorf("Expected rule backup to be listed disabled, got %v", rules.Rules)
+	}
+
+	enabled, err := s.EnableRules(context.Background(), &EnableRulesRequest{RuleTag: "backup-window"})
+	common.Must(err)
+	if enabled.Rules != 1 {
+		t.Errorf("Expected a rule to be enabled, got %d", enabled.Rules)
+	}
+	flushed, err := s.FlushSessions(context.Background(), &FlushSessionsRequest{RuleTag: "backup-window"})
+	common.Must(err)
+	if flushed.Flushed != 0 {
+		t.Errorf("Expected no sessions flushed, got %d", flushed.Flushed)
+	}
+}
+
+func TestAddRule(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId: "test-site",
+		Rules:  []*nat.NATRule{{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"}},
+	}, nil))
+	defer handler.Close()
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+
+	response, err := s.AddRule(context.Background(), &AddRuleRequest{Rule: &nat.NATRule{RuleId: "moved", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21"}})
+	common.Must(err)
+	if response.Added || len(response.Conflicts) != 1 || response.Conflicts[0].Kind != nat.ConflictContradicts || response.Conflicts[0].RuleId != "web" {
+		t.Errorf("Expected the rule to contradict rule web, got %v", response)
+	}
+	if _, err := s.AddRule(context.Background(), &AddRuleRequest{Rule: &nat.NATRule{RuleId: "web", VirtualDestination: "240.2.2.21"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a ruleId in use to be an invalid argument, got %v", err)
+	}
+
+	response, err = s.AddRule(context.Background(), &AddRuleRequest{Rule: &nat.NATRule{RuleId: "db", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30"}})
+	common.Must(err)
+	if !response.Added || len(response.Conflicts) != 0 {
+		t.Errorf("Expected the rule to be added, got %v", response)
+	}
+	translation, err := s.TestTranslation(context.Background(), &TestTranslationRequest{Destination: "240.2.2.30:5432"})
+	common.Must(err)
+	if translation.RuleId != "db" || translation.RealDestination != "192.168.1.30:5432" {
+		t.Errorf("Expected the flow to match rule db, got %v", translation)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{
+		SiteId: "test-site",
+		Rules:  []*nat.NATRule{{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"}},
+	}, nil))
+	defer handler.Close()
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+
+	response, err := s.Simulate(context.Background(), &SimulateRequest{
+		Rules: []*nat.NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21"},
+			{RuleId: "web-v2", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.22", Ports: "443"},
+		},
+		Flows: []*SimulatedFlow{
+			{Source: "10.0.0.5:40000", Destination: "240.2.2.20:443"},
+			{Network: "udp", Destination: "240.2.2.30:53"},
+		},
+	})
+	common.Must(err)
+	if len(response.Flows) != 2 {
+		t.Fatalf("Expected every flow to be simulated, got %v", response.Flows)
+	}
+	web := response.Flows[0]
+	if web.Outcome != nat.SimulationTranslated || web.RuleId != "web" || web.RealDestination != "192.168.1.21:443" ||
+		len(web.Contested) != 1 || web.Contested[0] != "web-v2" || web.ActiveRuleId != "web" || !web.Changed {
+		t.Errorf("Unexpected simulation of the web flow %v", web)
+	}
+	if other := response.Flows[1]; other.Outcome != nat.SimulationUnmatched || other.Changed || other.Flow.Network != "udp" {
+		t.Errorf("Unexpected simulation of the other flow %v", other)
+	}
+	if len(response.Rules) != 2 || response.Rules[0].Flows != 1 || response.Rules[1].Flows != 0 ||
+		len(response.Rules[1].Conflicts) != 1 || response.Rules[1].Conflicts[0].RuleId != "web" {
+		t.Errorf("Unexpected simulation of the rules %v", response.Rules)
+	}
+
+	if _, err := s.Simulate(context.Background(), &SimulateRequest{Flows: []*SimulatedFlow{{Destination: "240.2.2.20"}}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a destination without a port to be an invalid argument, got %v", err)
+	}
+}
+
+func TestParseTrafficSample(t *testing.T) {
+	for name, sample := range map[string]string{
+		"flows": `[{"network": "tcp", "source": "10.0.0.5:40000", "destination": "240.2.2.20:443"},
+			{"network": "udp", "source": "10.0.0.5:5353", "destination": "240.2.2.53:53"}]`,
+		"sessions": `{"taken": 1700000000, "sessions": [
+			{"network": "tcp", "direction": "outbound", "virtualSource": "10.0.0.5:40000", "virtualDestination": "240.2.2.20:443"},
+			{"network": "tcp", "direction": "inbound", "virtualSource": "203.0.113.9:60000", "virtualDestination": "10.0.0.5:8080"},
+			{"network": "udp", "direction": "outbound", "virtualSource": "10.0.0.5:5353", "virtualDestination": "240.2.2.53:53"}]}`,
+		// tshark -T json -e ip.src -e ip.dst -e tcp.srcport -e tcp.dstport -e udp.srcport -e udp.dstport
+		"tshark fields": `[
+			{"_source": {"layers": {"ip.src": ["10.0.0.5"], "ip.dst": ["240.2.2.20"], "tcp.srcport": ["40000"], "tcp.dstport": ["443"]}}},
+			{"_source": {"layers": {"ip.src": ["240.2.2.20"], "ip.dst": ["10.0.0.5"], "tcp.srcport": ["443"], "tcp.dstport": ["40000"]}}},
+			{"_source": {"layers": {"ip.src": ["10.0.0.5"], "ip.dst": ["240.2.2.53"], "udp.srcport": ["5353"], "udp.dstport": ["53"]}}}]`,
+		// tshark -T json
+		"tshark trees": `[
+			{"_source": {"layers": {"frame": {"frame.number": "1"}, "ip": {"ip.src": "10.0.0.5", "ip.dst": "240.2.2.20"}, "tcp": {"tcp.srcport": "40000", "tcp.dstport": "443"}}}},
+			{"_source": {"layers": {"ip": {"ip.src": "10.0.0.9", "ip.dst": "10.0.0.5"}, "icmp": {"ip": {"ip.src": "10.0.0.5"}, "udp": {"udp.dstport": "33434"}}}}},
+			{"_source": {"layers": {"ip": {"ip.src": "10.0.0.5", "ip.dst": "240.2.2.53"}, "udp": {"udp.srcport": "5353", "udp.dstport": "53"}}}}]`,
+	} {
+		flows, err := ParseTrafficSample([]byte(sample))
+		if err != nil {
+			t.Errorf("Failed to parse the %s sample: %v", name, err)
+			continue
+		}
+		if len(flows) != 2 ||
+			flows[0].Network != "tcp" || flows[0].Source != "10.0.0.5:40000" || flows[0].Destination != "240.2.2.20:443" ||
+			flows[1].Network != "udp" || flows[1].Source != "10.0.0.5:5353" || flows[1].Destination != "240.2.2.53:53" {
+			t.Errorf("Unexpected flows of the %s sample: %v", name, flows)
+		}
+	}
+	if _, err := ParseTrafficSample([]byte(`{"taken": 1700000000}`)); err == nil {
+		t.Error("Expected a sample without flows to be rejected")
+	}
+}
+
+func TestSessionSnapshotDiff(t *testing.T) {
+	record := func(id string, uplink int64) *SessionRecord {
+		return &SessionRecord{Tag: "nat", SessionID: id, RuleID: "web", Network: "tcp", Created: 1700000000, UplinkBytes: uplink}
+	}
+	baseline := &SessionSnapshot{Taken: 1700000100, Sessions: []*SessionRecord{record("closed", 10), record("idle", 20), record("busy", 30)}}
+	current := &SessionSnapshot{Taken: 1700000200, Sessions: []*SessionRecord{record("idle", 20), record("busy", 80), record("new", 5)}}
+
+	diff := current.Diff(baseline)
+	var changes []string
+	for _, r := range diff.Sessions {
+		changes = append(changes, r.Change+" "+r.SessionID+" "+strconv.FormatInt(r.UplinkBytes, 10))
+	}
+	if strings.Join(changes, ", ") != "active busy 50, added new 5, removed closed 10" || diff.Baseline != 1700000100 {
+		t.Errorf("Unexpected diff %v", changes)
+	}
+
+	data, err := diff.Encode("csv")
+	common.Must(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "change,tag,session_id,") || !strings.HasPrefix(lines[1], "active,nat,busy,web,tcp,") {
+		t.Errorf("Unexpected CSV %q", data)
+	}
+	data, err = diff.Encode("json")
+	common.Must(err)
+	if _, err := ParseSessionSnapshot(data); err == nil {
+		t.Error("Expected a diff not to parse as a snapshot")
+	}
+	if _, err := current.Encode("xml"); err == nil {
+		t.Error("Expected error for an unknown format")
+	}
+}
+
+func TestExportSessions(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{SiteId: "test-site"}, nil))
+	defer handler.Close()
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+	response, err := s.ExportSessions(context.Background(), &ExportSessionsRequest{})
+	common.Must(err)
+	snapshot, err := ParseSessionSnapshot(response.Data)
+	common.Must(err)
+	if snapshot.Taken == 0 || len(snapshot.Sessions) != 0 || response.Sessions != 0 {
+		t.Errorf("Expected an empty snapshot, got %s", response.Data)
+	}
+
+	// Sessions of the baseline outside the filter are not reported as removed
+	baseline, err := json.Marshal(&SessionSnapshot{Taken: snapshot.Taken - 60, Sessions: []*SessionRecord{
+		{Tag: "nat", SessionID: "a", RuleID: "web", Network: "tcp"},
+		{Tag: "nat", SessionID: "b", RuleID: "db", Network: "tcp"},
+	}})
+	common.Must(err)
+	response, err = s.ExportSessions(context.Background(), &ExportSessionsRequest{RuleId: "web", Format: "csv", Baseline: baseline})
+	common.Must(err)
+	if lines := strings.Split(strings.TrimSpace(string(response.Data)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "removed,nat,a,web,") || response.Sessions != 1 {
+		t.Errorf("Expected the removal of the session of the rule, got %q", response.Data)
+	}
+
+	if _, err := s.ExportSessions(context.Background(), &ExportSessionsRequest{Format: "xml"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an unknown format to be an invalid argument, got %v", err)
+	}
+	if _, err := s.ExportSessions(context.Background(), &ExportSessionsRequest{Baseline: []byte("{")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid baseline to be an invalid argument, got %v", err)
+	}
+}
+
+type testEventStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*SessionEvents
+}
+
+func (s *testEventStream) Context() context.Context { return s.ctx }
+
+func (s *testEventStream) Send(events *SessionEvents) error {
+	s.events = append(s.events, events)
+	return nil
+}
+
+func TestWatchSessionEvents(t *testing.T) {
+	handler := nat.New()
+	common.Must(handler.Init(&nat.Config{SiteId: "test-site"}, nil))
+
+	s := NewNATServer(&testManager{handlers: []outbound.Handler{&testHandler{tag: "nat", proxy: handler}}})
+	if err := s.WatchSessionEvents(&WatchSessionEventsRequest{Tag: "missing"}, &testEventStream{ctx: context.Background()}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+
+	// The watch ends with the client, or with the outbound
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.WatchSessionEvents(&WatchSessionEventsRequest{}, &testEventStream{ctx: ctx}); err != nil {
+		t.Errorf("Expected the watch to end with the client, got %v", err)
+	}
+	done := make(chan error, 1)
+	stream := &testEventStream{ctx: context.Background()}
+	go func() {
+		done <- s.WatchSessionEvents(&WatchSessionEventsRequest{RuleId: "web"}, stream)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	handler.Close()
+	select {
+	case err := <-done:
+		if err != nil || len(stream.events) != 0 {
+			t.Errorf("Expected the watch to end without events, got %v and %v", err, stream.events)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the watch to end with the outbound")
+	}
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// Changes of the sessions of a diff
+const (
+	SessionAdded   = "added"
+	SessionRemoved = "removed"
+	SessionActive  = "active"
+)
+
+// SessionSnapshot is the session table of NAT outbounds at a point in time as ExportSessions
+// exports it, or the differences between two snapshots
+type SessionSnapshot struct {
+	// Unix time in seconds the snapshot was taken at
+	Taken int64 `json:"taken"`
+	// Time the baseline of a diff was taken at, 0 for a snapshot
+	Baseline int64            `json:"baseline,omitempty"`
+	Sessions []*SessionRecord `json:"sessions"`
+}
+
+// SessionRecord is a session of a snapshot. In a diff the sessions kept since the baseline
+// hold the bytes relayed since then.
+type SessionRecord struct {
+	// added, removed or active in a diff
+	Change             string `json:"change,omitempty"`
+	Tag                string `json:"tag"`
+	SessionID          string `json:"sessionId"`
+	RuleID             string `json:"ruleId"`
+	Network            string `json:"network"`
+	Direction          string `json:"direction"`
+	VirtualSource      string `json:"virtualSource"`
+	VirtualDestination string `json:"virtualDestination"`
+	RealSource         string `json:"realSource"`
+	RealDestination    string `json:"realDestination"`
+	Domain             string `json:"domain,omitempty"`
+	Created            int64  `json:"created"`
+	UplinkBytes        int64  `json:"uplinkBytes"`
+	DownlinkBytes      int64  `json:"downlinkBytes"`
+}
+
+func (r *SessionRecord) key() string {
+	return r.Tag + "/" + r.SessionID
+}
+
+// ParseSessionSnapshot parses a snapshot exported in JSON
+func ParseSessionSnapshot(data []byte) (*SessionSnapshot, error) {
+	snapshot := new(SessionSnapshot)
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.New("invalid session snapshot").Base(err)
+	}
+	if snapshot.Baseline != 0 {
+		return nil, errors.New("session snapshot is a diff")
+	}
+	return snapshot, nil
+}
+
+// Diff returns the sessions added to s since baseline, those removed, and those kept that
+// relayed traffic since. Kept sessions without traffic are left out.
+func (s *SessionSnapshot) Diff(baseline *SessionSnapshot) *SessionSnapshot {
+	diff := &SessionSnapshot{Taken: s.Taken, Baseline: baseline.Taken, Sessions: []*SessionRecord{}}
+	previous := make(map[string]*SessionRecord, len(baseline.Sessions))
+	for _, record := range baseline.Sessions {
+		previous[record.key()] = record
+	}
+	for _, record := range s.Sessions {
+		change := *record
+		old, kept := previous[record.key()]
+		delete(previous, record.key())
+		if !kept {
+			change.Change = SessionAdded
+		} else {
+			change.Change = SessionActive
+			change.UplinkBytes -= old.UplinkBytes
+			change.DownlinkBytes -= old.DownlinkBytes
+			if change.UplinkBytes <= 0 && change.DownlinkBytes <= 0 {
+				continue
+			}
+		}
+		diff.Sessions = append(diff.Sessions, &change)
+	}
+	for _, record := range baseline.Sessions {
+		if _, removed := previous[record.key()]; removed {
+			change := *record
+			change.Change = SessionRemoved
+			diff.Sessions = append(diff.Sessions, &change)
+		}
+	}
+	return diff
+}
+
+// Encode encodes s in format, json or csv. The CSV of a diff starts with the change column.
+func (s *SessionSnapshot) Encode(format string) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return json.MarshalIndent(s, "", "  ")
+	case "csv":
+	default:
+		return nil, errors.New("unknown format ", format)
+	}
+
+	var out bytes.Buffer
+	w := csv.NewWriter(&out)
+	header := []string{"tag", "session_id", "rule_id", "network", "direction", "virtual_source", "virtual_destination",
+		"real_source", "real_destination", "domain", "created", "uplink_bytes", "downlink_bytes"}
+	if s.Baseline != 0 {
+		header = append([]string{"change"}, header...)
+	}
+	w.Write(header)
+	for _, r := range s.Sessions {
+		row := []string{r.Tag, r.SessionID, r.RuleID, r.Network, r.Direction, r.VirtualSource, r.VirtualDestination,
+			r.RealSource, r.RealDestination, r.Domain, strconv.FormatInt(r.Created, 10),
+			strconv.FormatInt(r.UplinkBytes, 10), strconv.FormatInt(r.DownlinkBytes, 10)}
+		if s.Baseline != 0 {
+			row = append([]string{r.Change}, row...)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return out.Bytes(), w.Error()
+}
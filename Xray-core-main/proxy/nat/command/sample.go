@@ -0,0 +1,145 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// ParseTrafficSample parses the flows of a traffic sample for Simulate, given in JSON as
+//   - a list of flows, bare or as {"flows": [...]}, each with network, source and destination
+//   - a session snapshot exported by ExportSessions, whose outbound and hairpin sessions are
+//     replayed from their virtual source to their virtual destination
+//   - packets dissected by tshark -T json, the first packet of each TCP or UDP 5-tuple seen
+//     giving the direction of its flow
+func ParseTrafficSample(data []byte) ([]*SimulatedFlow, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			return nil, errors.New("invalid traffic sample").Base(err)
+		}
+		if len(elements) > 0 && bytes.Contains(elements[0], []byte(`"_source"`)) {
+			return parseTsharkPackets(data)
+		}
+		return parseFlows(data)
+	}
+
+	var sample struct {
+		Flows    json.RawMessage  `json:"flows"`
+		Sessions []*SessionRecord `json:"sessions"`
+	}
+	if err := json.Unmarshal(data, &sample); err != nil {
+		return nil, errors.New("invalid traffic sample").Base(err)
+	}
+	switch {
+	case sample.Flows != nil:
+		return parseFlows(sample.Flows)
+	case sample.Sessions != nil:
+		var flows []*SimulatedFlow
+		for _, session := range sample.Sessions {
+			if session.Change == SessionRemoved || session.Direction != "outbound" && session.Direction != "hairpin" {
+				continue
+			}
+			flows = append(flows, &SimulatedFlow{Network: session.Network, Source: session.VirtualSource, Destination: session.VirtualDestination})
+		}
+		return flows, nil
+	default:
+		return nil, errors.New("traffic sample has neither flows nor sessions")
+	}
+}
+
+func parseFlows(data []byte) ([]*SimulatedFlow, error) {
+	var sample []struct {
+		Network     string `json:"network"`
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+	}
+	if err := json.Unmarshal(data, &sample); err != nil {
+		return nil, errors.New("invalid flows").Base(err)
+	}
+	flows := make([]*SimulatedFlow, len(sample))
+	for i, flow := range sample {
+		if flow.Destination == "" {
+			return nil, errors.New("flow ", i, " has no destination")
+		}
+		flows[i] = &SimulatedFlow{Network: flow.Network, Source: flow.Source, Destination: flow.Destination}
+	}
+	return flows, nil
+}
+
+// parseTsharkPackets returns the flows of the TCP and UDP packets tshark dissected, with their
+// fields either in protocol trees or selected by -e
+func parseTsharkPackets(data []byte) ([]*SimulatedFlow, error) {
+	var packets []struct {
+		Source struct {
+			Layers map[string]any `json:"layers"`
+		} `json:"_source"`
+	}
+	if err := json.Unmarshal(data, &packets); err != nil {
+		return nil, errors.New("invalid tshark packets").Base(err)
+	}
+
+	var flows []*SimulatedFlow
+	seen := make(map[string]bool)
+	for _, packet := range packets {
+		// ICMP errors quote the headers of the packet they report
+		if _, found := packet.Source.Layers["icmp"]; found {
+			continue
+		}
+		if _, found := packet.Source.Layers["icmpv6"]; found {
+			continue
+		}
+		fields := make(map[string]string)
+		flattenTsharkFields(packet.Source.Layers, fields)
+		source, destination := fields["ip.src"], fields["ip.dst"]
+		if source == "" {
+			source, destination = fields["ipv6.src"], fields["ipv6.dst"]
+		}
+		network := "tcp"
+		if fields["tcp.dstport"] == "" {
+			if fields["udp.dstport"] == "" {
+				continue
+			}
+			network = "udp"
+		}
+		source = net.JoinHostPort(source, fields[network+".srcport"])
+		destination = net.JoinHostPort(destination, fields[network+".dstport"])
+		if seen[network+" "+source+" "+destination] || seen[network+" "+destination+" "+source] {
+			continue
+		}
+		seen[network+" "+source+" "+destination] = true
+		flows = append(flows, &SimulatedFlow{Network: network, Source: source, Destination: destination})
+	}
+	return flows, nil
+}
+
+// flattenTsharkFields collects the fields of address and port of a tshark protocol tree, the
+// first value of fields of several
+func flattenTsharkFields(tree map[string]any, fields map[string]string) {
+	for name, value := range tree {
+		switch value := value.(type) {
+		case map[string]any:
+			flattenTsharkFields(value, fields)
+		case []any:
+			if len(value) == 0 || !isTsharkField(name) {
+				continue
+			}
+			if s, ok := value[0].(string); ok {
+				fields[name] = s
+			}
+		case string:
+			if isTsharkField(name) {
+				fields[name] = value
+			}
+		}
+	}
+}
+
+func isTsharkField(name string) bool {
+	return strings.HasSuffix(name, ".src") || strings.HasSuffix(name, ".dst") ||
+		strings.HasSuffix(name, ".srcport") || strings.HasSuffix(name, ".dstport")
+}
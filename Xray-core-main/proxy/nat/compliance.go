@@ -0,0 +1,268 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// complianceRFC4787 enforces the NAT behavioral requirements of RFC 4787 (UDP), RFC 5382 (TCP)
+// and RFC 5508 (ICMP)
+const complianceRFC4787 = "rfc4787"
+
+// Shortest timeouts the RFCs allow
+const (
+	complianceUDPTimeout            = 2 * time.Minute             // RFC 4787 REQ-5
+	complianceEstablishedTCPTimeout = 2*time.Hour + 4*time.Minute // RFC 5382 REQ-5
+	complianceTransitoryTCPTimeout  = 4 * time.Minute             // RFC 5382 REQ-5
+	complianceICMPTimeout           = time.Minute                 // RFC 5508 REQ-1
+)
+
+// ValidateComplianceMode checks a compliance mode
+func ValidateComplianceMode(mode string) error {
+	switch mode {
+	case "", complianceRFC4787:
+		return nil
+	default:
+		return errors.New(mode, " is not rfc4787")
+	}
+}
+
+// compliant reports whether the RFC 4787 compliance mode is on
+func (h *Handler) compliant() bool {
+	return h.config != nil && h.config.ComplianceMode == complianceRFC4787
+}
+
+// natBehavior returns the behavior of the UDP mappings of a rule. Rules without one are
+// symmetric; the compliance mode makes those and symmetric rules restricted cones, keeping
+// the mapping endpoint-independent and filtering by address as RFC 4787 REQ-8 recommends.
+func (h *Handler) natBehavior(rule *NATRule) string {
+	behavior := rule.NatBehavior
+	if behavior == "" || behavior == natBehaviorSymmetric {
+		if h.compliant() {
+			return natBehaviorRestrictedCone
+		}
+		return natBehaviorSymmetric
+	}
+	return behavior
+}
+
+// hairpinEnabled reports whether flows between two hosts behind the NAT are hairpinned
+func (h *Handler) hairpinEnabled() bool {
+	return h.config != nil && (h.config.EnableHairpin || h.config.ComplianceMode == complianceRFC4787)
+}
+
+// complianceTimeout raises a session timeout to the shortest the compliance mode allows
+func (h *Handler) complianceTimeout(session *NATSession, timeout time.Duration) time.Duration {
+	if !h.compliant() {
+		return timeout
+	}
+	var floor time.Duration
+	switch strings.ToLower(session.Protocol) {
+	case "udp":
+		floor = complianceUDPTimeout
+	case "icmp":
+		floor = complianceICMPTimeout
+	default:
+		floor = complianceTransitoryTCPTimeout
+		if tcpState(session.tcpState.Load()) == tcpStateEstablished {
+			floor = complianceEstablishedTCPTimeout
+		}
+	}
+	return max(timeout, floor)
+}
+
+// ComplianceMode returns the compliance mode in effect, empty when off
+func (h *Handler) ComplianceMode() string {
+	return h.config.GetComplianceMode()
+}
+
+// ComplianceCheck is the outcome of one requirement of the self-test
+type ComplianceCheck struct {
+	Requirement string // Such as "RFC 4787 REQ-1"
+	Description string
+	Passed      bool
+	Detail      string
+}
+
+// carriesUDP reports whether a rule translates UDP flows through mappings
+func carriesUDP(rule *NATRule) bool {
+	if blocksFlows(rule) || rule.Action == actionBypass {
+		return false
+	}
+	tokens := protocolTokens(rule.Protocol)
+	if len(tokens) == 0 || slices.Contains(tokens, "udp") || slices.Contains(tokens, protocolAny) {
+		return true
+	}
+	for _, token := range tokens {
+		if group, ok := protocolGroups[token]; ok && slices.Contains(group.networks, "udp") {
+			return true
+		}
+	}
+	return false
+}
+
+// SelfTest checks the running behavior against the requirements of RFC 4787, RFC 5382 and
+// RFC 5508, whether or not the compliance mode is on. The mapping behavior of the UDP rules is
+// probed through mappings to loopback servers, opened by a separate handler with the timeouts
+// and compliance mode of this one; timeouts and hairpinning are checked from the settings in
+// effect.
+func (h *Handler) SelfTest(ctx context.Context) []ComplianceCheck {
+	// Rules relaying UDP as a connection map every flow to a port of its own
+	var connected []string
+	behaviors := make(map[string][]string)
+	for _, rule := range h.activeRules() {
+		if !carriesUDP(rule) || h.RuleDisabled(rule) {
+			continue
+		}
+		if rule.NatBehavior == "" && len(rule.Alg) > 0 {
+			connected = append(connected, rule.RuleId)
+			continue
+		}
+		behavior := h.natBehavior(rule)
+		behaviors[behavior] = append(behaviors[behavior], rule.RuleId)
+	}
+	if len(behaviors) == 0 {
+		behaviors[h.natBehavior(&NATRule{})] = nil
+	}
+
+	mapping := ComplianceCheck{Requirement: "RFC 4787 REQ-1", Description: "UDP mappings are endpoint-independent", Passed: len(connected) == 0}
+	filtering := ComplianceCheck{Requirement: "RFC 4787 REQ-8", Description: "UDP filtering is endpoint-independent or address-dependent", Passed: true}
+	var mappingDetails, filteringDetails []string
+	if len(connected) > 0 {
+		mappingDetails = append(mappingDetails, "rules "+strings.Join(connected, ", ")+" relay UDP as connections for their ALGs")
+	}
+	for _, behavior := range slices.Sorted(maps.Keys(behaviors)) {
+		rules := ""
+		if ids := behaviors[behavior]; len(ids) > 0 {
+			rules = " of rules " + strings.Join(ids, ", ")
+		}
+		independent, err := probeMapping(ctx, h.config, behavior)
+		switch {
+		case err != nil:
+			mapping.Passed = false
+			mappingDetails = append(mappingDetails, behavior+rules+": probe failed: "+err.Error())
+		case !independent:
+			mapping.Passed = false
+			mappingDetails = append(mappingDetails, behavior+rules+": address and port-dependent")
+		default:
+			mappingDetails = append(mappingDetails, behavior+rules+": endpoint-independent")
+		}
+		if behavior == natBehaviorPortRestricted || behavior == natBehaviorSymmetric {
+			filtering.Passed = false
+			filteringDetails = append(filteringDetails, behavior+rules+": address and port-dependent")
+		}
+	}
+	mapping.Detail = strings.Join(mappingDetails, "; ")
+	filtering.Detail = strings.Join(filteringDetails, "; ")
+
+	established := &NATSession{Protocol: "tcp"}
+	established.tcpState.Store(int32(tcpStateEstablished))
+	timeout := func(requirement, description string, session *NATSession, floor time.Duration) ComplianceCheck {
+		actual := h.sessionTimeout(session)
+		return ComplianceCheck{
+			Requirement: requirement,
+			Description: description,
+			Passed:      actual >= floor,
+			Detail:      fmt.Sprintf("%v, at least %v", actual, floor),
+		}
+	}
+	hairpin := ComplianceCheck{Requirement: "RFC 4787 REQ-9", Description: "Hairpinning is supported", Passed: h.hairpinEnabled()}
+	if len(h.config.VirtualRanges) == 0 && len(h.config.StaticMappings) == 0 {
+		hairpin.Detail = "no virtual ranges or static mappings to hairpin between"
+	}
+	return []ComplianceCheck{
+		mapping,
+		filtering,
+		timeout("RFC 4787 REQ-5", "UDP mapping timeout", &NATSession{Protocol: "udp"}, complianceUDPTimeout),
+		hairpin,
+		timeout("RFC 5382 REQ-5", "Established TCP idle timeout", established, complianceEstablishedTCPTimeout),
+		timeout("RFC 5382 REQ-5", "Transitory TCP idle timeout", &NATSession{Protocol: "tcp"}, complianceTransitoryTCPTimeout),
+		timeout("RFC 5508 REQ-1", "ICMP query timeout", &NATSession{Protocol: "icmp"}, complianceICMPTimeout),
+	}
+}
+
+// probeMapping sends a datagram to each of two loopback servers through one UDP mapping of
+// behavior, opened by a handler of the timeouts and compliance mode of config, and reports
+// whether both servers saw the same source endpoint
+func probeMapping(ctx context.Context, config *Config, behavior string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var servers [2]*net.UDPConn
+	for i := range servers {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return false, errors.New("failed to listen on loopback").Base(err)
+		}
+		defer conn.Close()
+		servers[i] = conn
+	}
+
+	rule := &NATRule{
+		RuleId:             "self-test",
+		VirtualDestination: "192.0.2.1",
+		RealDestination:    "127.0.0.1",
+		Protocol:           "udp",
+		NatBehavior:        behavior,
+	}
+	probe := New()
+	defer probe.Close()
+	if err := probe.Init(&Config{
+		SiteId:         config.SiteId,
+		SessionTimeout: config.SessionTimeout,
+		ComplianceMode: config.ComplianceMode,
+		Rules:          []*NATRule{rule},
+	}, nil); err != nil {
+		return false, err
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	_, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	var virtual [2]xnet.Destination
+	for i, server := range servers {
+		virtual[i] = xnet.UDPDestination(xnet.ParseAddress(rule.VirtualDestination), xnet.Port(server.LocalAddr().(*net.UDPAddr).Port))
+	}
+	go func() {
+		done <- probe.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, virtual[0], hairpinDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	var sources [2]*net.UDPAddr
+	for i, server := range servers {
+		b := buf.New()
+		b.WriteString("NAT self-test")
+		b.UDP = &virtual[i]
+		if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+			return false, err
+		}
+		deadline, _ := ctx.Deadline()
+		server.SetReadDeadline(deadline)
+		_, source, err := server.ReadFromUDP(make([]byte, 64))
+		if err != nil {
+			select {
+			case err := <-done:
+				done <- err
+				return false, errors.New("UDP mapping failed").Base(err)
+			default:
+			}
+			return false, errors.New("no datagram reached the loopback server").Base(err)
+		}
+		sources[i] = source
+	}
+	return sources[0].Port == sources[1].Port, nil
+}
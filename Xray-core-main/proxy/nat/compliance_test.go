@@ -0,0 +1,100 @@
+package nat
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestComplianceMode(t *testing.T) {
+	config := &Config{
+		SiteId:         "test-site",
+		SessionTimeout: &SessionTimeout{TcpTimeout: 300, UdpTimeout: 30, IcmpTimeout: 10},
+		VirtualRanges:  []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"}},
+		Rules: []*NATRule{
+			{RuleId: "dns", VirtualDestination: "240.2.3.53", RealDestination: "192.168.1.53", Protocol: "udp"},
+			{RuleId: "game", VirtualDestination: "240.2.3.20", RealDestination: "192.168.1.20", Protocol: "udp", NatBehavior: natBehaviorSymmetric},
+			{RuleId: "voice", VirtualDestination: "240.2.3.30", RealDestination: "192.168.1.30", NatBehavior: natBehaviorFullCone},
+			{RuleId: "web", VirtualDestination: "240.2.3.80", RealDestination: "192.168.1.80", Protocol: "tcp"},
+		},
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(config, nil); err != nil {
+		t.Fatal(err)
+	}
+	established := &NATSession{Protocol: "tcp"}
+	established.tcpState.Store(int32(tcpStateEstablished))
+
+	// Without the compliance mode the self-test reports what falls short
+	failed := make(map[string]bool)
+	for _, check := range handler.SelfTest(context.Background()) {
+		if !check.Passed {
+			failed[check.Requirement+" "+check.Description] = true
+		}
+	}
+	for _, requirement := range []string{
+		"RFC 4787 REQ-1 UDP mappings are endpoint-independent",
+		"RFC 4787 REQ-8 UDP filtering is endpoint-independent or address-dependent",
+		"RFC 4787 REQ-5 UDP mapping timeout",
+		"RFC 4787 REQ-9 Hairpinning is supported",
+		"RFC 5382 REQ-5 Established TCP idle timeout",
+		"RFC 5508 REQ-1 ICMP query timeout",
+	} {
+		if !failed[requirement] {
+			t.Errorf("Expected %s to fail without the compliance mode", requirement)
+		}
+	}
+	if handler.natBehavior(config.Rules[0]) != natBehaviorSymmetric {
+		t.Error("Expected rules without a behavior to stay symmetric")
+	}
+
+	config.ComplianceMode = complianceRFC4787
+	for _, rule := range config.Rules[:2] {
+		if behavior := handler.natBehavior(rule); behavior != natBehaviorRestrictedCone {
+			t.Errorf("Expected rule %s to be a restricted cone, got %s", rule.RuleId, behavior)
+		}
+	}
+	if behavior := handler.natBehavior(config.Rules[2]); behavior != natBehaviorFullCone {
+		t.Errorf("Expected the full cone to be kept, got %s", behavior)
+	}
+	if !handler.hairpinEnabled() {
+		t.Error("Expected hairpinning to be enabled")
+	}
+	for _, test := range []struct {
+		session  *NATSession
+		expected time.Duration
+	}{
+		{&NATSession{Protocol: "udp"}, 2 * time.Minute},
+		{&NATSession{Protocol: "icmp"}, time.Minute},
+		{&NATSession{Protocol: "tcp"}, 5 * time.Minute},
+		{established, 2*time.Hour + 4*time.Minute},
+	} {
+		if timeout := handler.sessionTimeout(test.session); timeout != test.expected {
+			t.Errorf("Expected the %s timeout to be raised to %v, got %v", test.session.Protocol, test.expected, timeout)
+		}
+	}
+
+	checks := handler.SelfTest(context.Background())
+	if len(checks) != 7 {
+		t.Fatalf("Unexpected checks %+v", checks)
+	}
+	for _, check := range checks {
+		if !check.Passed {
+			t.Errorf("Expected %s %s to pass in the compliance mode: %s", check.Requirement, check.Description, check.Detail)
+		}
+	}
+	if detail := checks[0].Detail; detail != "full-cone of rules voice: endpoint-independent; restricted-cone of rules dns, game: endpoint-independent" {
+		t.Errorf("Unexpected mapping detail %q", detail)
+	}
+}
+
+func TestProbeMapping(t *testing.T) {
+	config := &Config{SiteId: "test-site"}
+	if independent, err := probeMapping(context.Background(), config, natBehaviorSymmetric); err != nil || independent {
+		t.Errorf("Expected the symmetric mapping to depend on the endpoint, got %v, %v", independent, err)
+	}
+	if independent, err := probeMapping(context.Background(), config, natBehaviorPortRestricted); err != nil || !independent {
+		t.Errorf("Expected the port-restricted mapping to be endpoint-independent, got %v, %v", independent, err)
+	}
+}
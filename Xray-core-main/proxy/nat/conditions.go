@@ -0,0 +1,238 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// ruleConditions are the compiled matching conditions of a rule beyond its destination, protocol and ports
+type ruleConditions struct {
+	err         error // Set when the conditions could not be compiled; the rule then never matches
+	sourceGeoIP []*router.GeoIPMatcher
+	destGeoIP   []*router.GeoIPMatcher
+	destGeoSite *router.DomainMatcher
+	schedule    *schedule
+	sourceNets  []*net.IPNet
+	sourcePorts portList
+	users       map[string]bool
+	userLevels  map[uint32]bool
+	domains     []string
+}
+
+// parseSourceAddresses parses addresses and CIDRs into networks
+func parseSourceAddresses(addresses []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(addresses))
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if !strings.Contains(address, "/") {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, errors.New("invalid source address ", address)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, errors.New("invalid source network ", address).Base(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ValidateSourceAddresses checks the source addresses and CIDRs of a rule
+func ValidateSourceAddresses(addresses []string) error {
+	_, err := parseSourceAddresses(addresses)
+	return err
+}
+
+func compileGeoIP(geoips []*router.GeoIP) ([]*router.GeoIPMatcher, error) {
+	matchers := make([]*router.GeoIPMatcher, 0, len(geoips))
+	for _, geoip := range geoips {
+		matcher, err := router.GlobalGeoIPContainer.Add(geoip)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func newRuleConditions(rule *NATRule) *ruleConditions {
+	c := new(ruleConditions)
+	var err error
+	if c.sourceGeoIP, err = compileGeoIP(rule.SourceGeoip); err != nil {
+		c.err = errors.New("invalid sourceGeoIP").Base(err)
+		return c
+	}
+	if c.destGeoIP, err = compileGeoIP(rule.DestGeoip); err != nil {
+		c.err = errors.New("invalid destGeoIP").Base(err)
+		return c
+	}
+	if len(rule.DestGeosite) > 0 {
+		if c.destGeoSite, err = router.NewMphMatcherGroup(rule.DestGeosite); err != nil {
+			c.err = errors.New("invalid destGeoSite").Base(err)
+			return c
+		}
+	}
+	if rule.Schedule != nil {
+		if c.schedule, err = newSchedule(rule.Schedule); err != nil {
+			c.err = errors.New("invalid schedule").Base(err)
+			return c
+		}
+	}
+	if c.sourceNets, err = parseSourceAddresses(rule.SourceAddresses); err != nil {
+		c.err = err
+		return c
+	}
+	if c.sourcePorts, err = parsePortList(rule.SourcePorts); err != nil {
+		c.err = errors.New("invalid sourcePorts").Base(err)
+		return c
+	}
+	if len(rule.Users) > 0 {
+		c.users = make(map[string]bool, len(rule.Users))
+		for _, email := range rule.Users {
+			c.users[strings.ToLower(strings.TrimSpace(email))] = true
+		}
+	}
+	if err = ValidateDomainPatterns(rule.Domains); err != nil {
+		c.err = errors.New("invalid domains").Base(err)
+		return c
+	}
+	c.domains = rule.Domains
+	if len(rule.UserLevels) > 0 {
+		c.userLevels = make(map[uint32]bool, len(rule.UserLevels))
+		for _, level := range rule.UserLevels {
+			c.userLevels[level] = true
+		}
+	}
+	return c
+}
+
+// conditionsOf returns the compiled conditions of a rule, compiling them on first use
+func (h *Handler) conditionsOf(rule *NATRule) *ruleConditions {
+	if c, found := h.conditions.Load(rule); found {
+		return c.(*ruleConditions)
+	}
+	c := newRuleConditions(rule)
+	if c.err != nil {
+		errors.LogWarningInner(context.Background(), c.err, "NAT rule ", rule.RuleId, " is disabled")
+	}
+	actual, _ := h.conditions.LoadOrStore(rule, c)
+	return actual.(*ruleConditions)
+}
+
+// matchesGeoIP checks if address is an IP in any of the lists; an empty set of lists matches everything
+func matchesGeoIP(matchers []*router.GeoIPMatcher, address xnet.Address) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+	if address == nil || !address.Family().IsIP() {
+		return false
+	}
+	for _, matcher := range matchers {
+		if matcher.Match(address.IP()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasConditions reports whether a rule has conditions beyond its destination, protocol and ports
+func hasConditions(rule *NATRule) bool {
+	return len(rule.SourceGeoip) > 0 || len(rule.DestGeoip) > 0 || len(rule.DestGeosite) > 0 ||
+		rule.Schedule != nil || len(rule.SourceAddresses) > 0 || rule.SourcePorts != "" ||
+		len(rule.Users) > 0 || len(rule.UserLevels) > 0 || len(rule.Domains) > 0
+}
+
+// matchesConditions checks the source, user, geo, domain and schedule conditions of a rule against a flow,
+// taking the source and user from the inbound of the session. Schedules are evaluated per flow, so rules start and
+// stop matching as their windows open and close.
+func (h *Handler) matchesConditions(ctx context.Context, destination xnet.Destination, rule *NATRule) bool {
+	if !hasConditions(rule) {
+		return true
+	}
+	c := h.conditionsOf(rule)
+	if c.err != nil {
+		return false
+	}
+	if c.schedule != nil && !c.schedule.active(time.Now()) {
+		return false
+	}
+
+	var source xnet.Destination
+	var user *protocol.MemoryUser
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		source, user = inbound.Source, inbound.User
+	}
+	if !c.matchesUser(user) {
+		return false
+	}
+	if !matchesGeoIP(c.sourceGeoIP, source.Address) || !matchesGeoIP(c.destGeoIP, destination.Address) {
+		return false
+	}
+	if len(c.sourceNets) > 0 && !matchesNetworks(c.sourceNets, source.Address) {
+		return false
+	}
+	if len(c.sourcePorts) > 0 {
+		if _, ok := c.sourcePorts.indexOf(source.Port); !ok {
+			return false
+		}
+	}
+	if c.destGeoSite != nil {
+		if !destination.Address.Family().IsDomain() || !c.destGeoSite.ApplyDomain(destination.Address.Domain()) {
+			return false
+		}
+	}
+	if len(c.domains) > 0 {
+		domain := flowDomain(ctx, destination)
+		if domain == "" || !slices.ContainsFunc(c.domains, func(pattern string) bool { return matchesDomainPattern(domain, pattern) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesUser checks the user conditions against the inbound user. A user is selected by email when
+// the rule lists users, and by level otherwise or when the user has no email; anonymous flows never
+// match user conditions.
+func (c *ruleConditions) matchesUser(user *protocol.MemoryUser) bool {
+	if c.users == nil && c.userLevels == nil {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	if email := strings.ToLower(user.Email); email != "" && c.users != nil {
+		return c.users[email]
+	}
+	return c.userLevels[user.Level]
+}
+
+// matchesNetworks checks if address is an IP within any of the networks
+func matchesNetworks(networks []*net.IPNet, address xnet.Address) bool {
+	if address == nil || !address.Family().IsIP() {
+		return false
+	}
+	ip := address.IP()
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/session"
+)
+
+func TestGeoConditions(t *testing.T) {
+	handler := &Handler{config: &Config{Rules: []*NATRule{
+		{
+			RuleId:             "branch",
+			VirtualDestination: "240.2.2.20",
+			RealDestination:    "192.168.1.20",
+			SourceGeoip:        []*router.GeoIP{{Cidr: []*router.CIDR{{Ip: []byte{10, 1, 0, 0}, Prefix: 16}}}},
+			DestGeoip:          []*router.GeoIP{{Cidr: []*router.CIDR{{Ip: []byte{240, 2, 2, 0}, Prefix: 24}}}},
+		},
+		{
+			RuleId:             "corp-sites",
+			VirtualDestination: "portal.corp.example",
+			RealDestination:    "192.168.1.30",
+			DestGeosite:        []*router.Domain{{Type: router.Domain_Domain, Value: "corp.example"}},
+		},
+	}}}
+	match := func(source string, destination xnet.Address) bool {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{
+			Source: xnet.TCPDestination(xnet.ParseAddress(source), 40000),
+		})
+		_, ok := handler.shouldApplyNAT(ctx, xnet.TCPDestination(destination, 443))
+		return ok
+	}
+
+	if !match("10.1.2.3", xnet.ParseAddress("240.2.2.20")) {
+		t.Error("Expected flows from the source network to be translated")
+	}
+	if match("10.2.2.3", xnet.ParseAddress("240.2.2.20")) {
+		t.Error("Flows from other sources must not be translated")
+	}
+	if !match("10.2.2.3", xnet.DomainAddress("portal.corp.example")) {
+		t.Error("Expected domains of the geosite list to be translated")
+	}
+}
+
+func TestInvalidConditionsDisableRule(t *testing.T) {
+	handler := &Handler{config: &Config{Rules: []*NATRule{{
+		RuleId:             "broken",
+		VirtualDestination: "broken.example",
+		DestGeosite:        []*router.Domain{{Type: router.Domain_Regex, Value: "("}},
+	}}}}
+	if _, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.DomainAddress("broken.example"), 80)); ok {
+		t.Error("Rules with invalid conditions must not match")
+	}
+}
+
+func TestSourceConditions(t *testing.T) {
+	handler := &Handler{config: &Config{Rules: []*NATRule{
+		{RuleId: "engineering", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", SourceAddresses: []string{"10.1.0.0/16", "10.9.9.9"}},
+		{RuleId: "legacy-clients", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21", SourcePorts: "1-1023"},
+		{RuleId: "default", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.22"},
+	}}}
+	match := func(source xnet.Destination) string {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+		rule, ok := handler.shouldApplyNAT(ctx, xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	for source, expected := range map[xnet.Destination]string{
+		xnet.TCPDestination(xnet.ParseAddress("10.1.5.5"), 40000): "engineering",
+		xnet.TCPDestination(xnet.ParseAddress("10.9.9.9"), 40000): "engineering",
+		xnet.TCPDestination(xnet.ParseAddress("10.2.5.5"), 512):   "legacy-clients",
+		xnet.TCPDestination(xnet.ParseAddress("10.2.5.5"), 40000): "default",
+	} {
+		if id := match(source); id != expected {
+			t.Errorf("Source %v: expected rule %q, got %q", source, expected, id)
+		}
+	}
+	if id := match(xnet.Destination{}); id != "default" {
+		t.Errorf("Flows without a known source must skip source rules, got %q", id)
+	}
+
+	if ValidateSourceAddresses([]string{"10.0.0.0/8", "2001:db8::1"}) != nil || ValidateSourceAddresses([]string{"10.0.0.300"}) == nil {
+		t.Error("Unexpected source address validation")
+	}
+}
+
+func TestDomainConditions(t *testing.T) {
+	handler := &Handler{config: &Config{Rules: []*NATRule{
+		{RuleId: "internal", VirtualDestination: "240.2.2.0/24", RealDestination: "192.168.1.20", Domains: []string{"*.internal.corp", "wiki.corp"}},
+		{RuleId: "default", VirtualDestination: "240.2.2.0/24", RealDestination: "192.168.1.30"},
+	}}}
+	match := func(sniffed string) string {
+		destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 443)
+		outbound := &session.Outbound{Target: destination}
+		if sniffed != "" {
+			outbound.RouteTarget = xnet.TCPDestination(xnet.DomainAddress(sniffed), 443)
+		}
+		rule, ok := handler.shouldApplyNAT(session.ContextWithOutbounds(context.Background(), []*session.Outbound{outbound}), destination)
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	for sniffed, expected := range map[string]string{
+		"db.internal.corp": "internal",
+		"WIKI.corp":        "internal",
+		"internal.corp":    "default",
+		"www.example.com":  "default",
+		"":                 "default",
+	} {
+		if id := match(sniffed); id != expected {
+			t.Errorf("Sniffed domain %q: expected rule %q, got %q", sniffed, expected, id)
+		}
+	}
+
+	if ValidateDomainPatterns([]string{"*.internal.corp", "wiki.corp"}) != nil || ValidateDomainPatterns([]string{"db.*.corp"}) == nil || ValidateDomainPatterns([]string{"10.0.0.1"}) == nil {
+		t.Error("Unexpected domain validation")
+	}
+}
+
+func TestUserConditions(t *testing.T) {
+	handler := &Handler{config: &Config{Rules: []*NATRule{
+		{RuleId: "alice", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Users: []string{"Alice@corp"}},
+		{RuleId: "staff", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21", UserLevels: []uint32{1}},
+	}}}
+	match := func(user *protocol.MemoryUser) string {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{User: user})
+		rule, ok := handler.shouldApplyNAT(ctx, xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	for _, test := range []struct {
+		user     *protocol.MemoryUser
+		expected string
+	}{
+		{&protocol.MemoryUser{Email: "alice@corp", Level: 1}, "alice"},
+		{&protocol.MemoryUser{Email: "bob@corp", Level: 1}, "staff"},
+		{&protocol.MemoryUser{Level: 1}, "staff"},
+		{&protocol.MemoryUser{Email: "bob@corp"}, ""},
+		{nil, ""},
+	} {
+		if id := match(test.user); id != test.expected {
+			t.Errorf("User %v: expected rule %q, got %q", test.user, test.expected, id)
+		}
+	}
+}
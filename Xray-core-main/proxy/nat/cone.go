@@ -0,0 +1,435 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/common/task"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// NAT behaviors of UDP mappings as classified by RFC 4787
+const (
+	// natBehaviorFullCone uses endpoint-independent mapping and filtering
+	natBehaviorFullCone = "full-cone"
+	// natBehaviorRestrictedCone uses endpoint-independent mapping and address-dependent filtering
+	natBehaviorRestrictedCone = "restricted-cone"
+	// natBehaviorPortRestricted uses endpoint-independent mapping and address and port-dependent filtering
+	natBehaviorPortRestricted = "port-restricted"
+	// natBehaviorSymmetric uses address and port-dependent mapping and filtering
+	natBehaviorSymmetric = "symmetric"
+)
+
+// packetConn is the datagram socket of a UDP mapping
+type packetConn interface {
+	ReadFrom(p []byte) (int, net.Addr, error)
+	WriteTo(p []byte, addr net.Addr) (int, error)
+	Close() error
+}
+
+// connectedPacketConn adapts a connection that can only talk to its remote address,
+// such as one returned by a proxying dialer, to packetConn.
+type connectedPacketConn struct {
+	net.Conn
+}
+
+func (c *connectedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Conn.Read(p)
+	return n, c.Conn.RemoteAddr(), err
+}
+
+func (c *connectedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr.String() != c.Conn.RemoteAddr().String() {
+		return 0, errors.New("connection to ", c.Conn.RemoteAddr(), " cannot send to ", addr)
+	}
+	return c.Conn.Write(p)
+}
+
+func newPacketConn(conn net.Conn) packetConn {
+	if statConn, ok := conn.(*stat.CounterConnection); ok {
+		conn = statConn.Connection
+	}
+	if c, ok := conn.(*internet.PacketConnWrapper); ok {
+		return c
+	}
+	return &connectedPacketConn{Conn: conn}
+}
+
+// udpFlow is the flow of a client a UDP mapping relays: the session, the link return traffic
+// is written to and the inactivity timer of the flow
+type udpFlow struct {
+	ctx     context.Context
+	session *NATSession
+	output  buf.Writer
+	timer   *signal.ActivityTimer
+}
+
+// udpMapping is the state of one UDP NAT mapping: the real-side sockets, the remote endpoints
+// that were contacted (used for filtering) and the real-to-virtual endpoint translations.
+type udpMapping struct {
+	handler  *Handler
+	dialer   internet.Dialer
+	behavior string
+	shapers  flowShapers
+	rule     *NATRule // Rule of the mapping, whose mtu policy applies to the uplink datagrams
+	done     chan struct{}
+	flow     atomic.Pointer[udpFlow] // Flow relayed, handed over when a WireGuard peer roams; nil once closed
+
+	sync.Mutex
+	conns     map[string]packetConn       // Real destination key ("" for cone behaviors) -> socket
+	permitted map[netip.AddrPort]bool     // Contacted remote endpoints, and their addresses with port 0
+	virtualOf map[string]xnet.Destination // Real endpoint -> virtual endpoint
+	realOf    map[string]xnet.Destination // Virtual endpoint -> real endpoint
+	readers   sync.WaitGroup
+	untrack   []func()       // Forget the sockets tracked for ICMP errors
+	pins      []wireGuardPin // WireGuard tunnels pinned to the mapping, oldest first
+
+	writing sync.Mutex    // Serializes the uplinks of the flows a mapping is handed over between
+	batch   datagramBatch // Uplink datagrams queued for a batched socket, guarded by writing
+}
+
+// context returns the context of the flow relayed
+func (m *udpMapping) context() context.Context {
+	if flow := m.flow.Load(); flow != nil {
+		return flow.ctx
+	}
+	return context.Background()
+}
+
+// handleUDPMapping relays UDP traffic datagram by datagram, applying DNAT to the destination of
+// every datagram and the configured RFC 4787 mapping and filtering behavior to return traffic.
+// Without a configured behavior the mapping is symmetric, as a connection per flow would be.
+// The mapping closes once no datagram passed either way for the UDP timeout.
+func (h *Handler) handleUDPMapping(ctx context.Context, link *transport.Link, destination, transformedDest xnet.Destination, dialer internet.Dialer, rule *NATRule, natSession *NATSession, chosen *backend, shapers flowShapers) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := signal.CancelAfterInactivity(ctx, cancel, h.sessionTimeout(natSession))
+
+	flow := &udpFlow{ctx: ctx, session: natSession, output: link.Writer, timer: timer}
+	m := &udpMapping{
+		handler:   h,
+		dialer:    dialer,
+		behavior:  h.natBehavior(rule),
+		shapers:   shapers,
+		rule:      rule,
+		done:      make(chan struct{}),
+		conns:     make(map[string]packetConn),
+		permitted: make(map[netip.AddrPort]bool),
+		virtualOf: make(map[string]xnet.Destination),
+		realOf:    make(map[string]xnet.Destination),
+	}
+	m.flow.Store(flow)
+	m.remember(destination, transformedDest)
+
+	// Open the mapping eagerly so dial failures surface before any traffic is relayed
+	if _, err := m.connFor(transformedDest); err != nil {
+		if chosen != nil {
+			chosen.markUnhealthy()
+		}
+		return errors.New("failed to establish NAT connection").Base(err)
+	}
+
+	uplink := &udpUplink{flow: flow}
+	uplink.mapping.Store(m)
+	requestDone := func() error {
+		defer uplink.release()
+		return buf.Copy(shapers.uplink.police(h, natSession, newCountingReader(link.Reader, h, natSession, true)), uplink, buf.UpdateActivity(timer))
+	}
+
+	err := task.Run(ctx, requestDone)
+	uplink.release()
+	common.Close(link.Writer)
+	if err != nil {
+		return errors.New("UDP mapping ends").Base(err)
+	}
+	return nil
+}
+
+// remember records the translation between a virtual and a real endpoint
+func (m *udpMapping) remember(virtual, real xnet.Destination) {
+	m.Lock()
+	defer m.Unlock()
+	m.realOf[virtual.NetAddr()] = real
+	m.virtualOf[real.NetAddr()] = virtual
+}
+
+// translate returns the real endpoint of a datagram sent to the virtual destination, or false
+// when a deny or reject rule blocks the destination
+func (m *udpMapping) translate(virtual xnet.Destination) (xnet.Destination, bool) {
+	m.Lock()
+	real, found := m.realOf[virtual.NetAddr()]
+	m.Unlock()
+	if found {
+		return real, true
+	}
+
+	real = virtual
+	if rule, ok := m.handler.shouldApplyNAT(m.context(), virtual); ok {
+		if blocksFlows(rule) {
+			return xnet.Destination{}, false
+		}
+		if transformed, err := m.handler.applyDNAT(virtual, rule); err == nil {
+			real = transformed
+		}
+	}
+	m.remember(virtual, real)
+	return real, true
+}
+
+// connFor returns the socket used to reach dest, opening it if needed. Cone behaviors share
+// one socket for every destination (endpoint-independent mapping), symmetric NAT uses one per destination.
+func (m *udpMapping) connFor(dest xnet.Destination) (packetConn, error) {
+	key := ""
+	if m.behavior == natBehaviorSymmetric {
+		key = dest.NetAddr()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	select {
+	case <-m.done:
+		return nil, errors.New("UDP mapping closed")
+	default:
+	}
+	if conn, found := m.conns[key]; found {
+		return conn, nil
+	}
+
+	flow := m.flow.Load()
+	if flow == nil {
+		return nil, errors.New("UDP mapping closed")
+	}
+	rawConn, err := m.dialer.Dial(flow.ctx, dest)
+	if err != nil {
+		atomic.AddInt64(&m.handler.totalErrors, 1)
+		atomic.AddInt64(&m.handler.dialFailures, 1)
+		return nil, err
+	}
+	conn := m.handler.batched(newPacketConn(rawConn))
+	m.conns[key] = conn
+	m.untrack = append(m.untrack, m.handler.trackRealFlow(xnet.Network_UDP, rawConn.LocalAddr(), nil, flow.session, m.virtualOfRemote))
+
+	m.readers.Add(1)
+	go m.readLoop(conn)
+	return conn, nil
+}
+
+// udpUplink writes the uplink of a flow into its UDP mapping, or into the mapping of the
+// WireGuard tunnel the flow continues from a new source
+type udpUplink struct {
+	flow    *udpFlow
+	mapping atomic.Pointer[udpMapping]
+	checked bool // Whether the first datagram was checked for a roamed WireGuard peer
+}
+
+// WriteMultiBuffer implements buf.Writer
+func (u *udpUplink) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	m := u.mapping.Load()
+	if !u.checked {
+		u.checked = true
+		if pinned := m.roamedFrom(u.flow, mb); pinned != nil && pinned.handOver(u.flow) {
+			if m.flow.CompareAndSwap(u.flow, nil) {
+				m.Close()
+			}
+			u.mapping.Store(pinned)
+			m = pinned
+		}
+	}
+	if m.flow.Load() != u.flow {
+		buf.ReleaseMulti(mb)
+		return errors.New("UDP mapping handed over to the new source of its WireGuard peer")
+	}
+	return m.WriteMultiBuffer(mb)
+}
+
+// release closes the mapping of the flow, unless it was handed over to another flow
+func (u *udpUplink) release() {
+	if m := u.mapping.Load(); m.flow.CompareAndSwap(u.flow, nil) {
+		m.Close()
+		m.readers.Wait()
+	}
+}
+
+// WriteMultiBuffer implements buf.Writer for the uplink direction
+func (m *udpMapping) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	defer buf.ReleaseMulti(mb)
+	flow := m.flow.Load()
+	if flow == nil {
+		return errors.New("UDP mapping closed")
+	}
+	m.writing.Lock()
+	defer m.writing.Unlock()
+
+	batch := &m.batch
+	defer batch.flush(flow.ctx)
+	for _, b := range mb {
+		virtual := flow.session.VirtualDest
+		if b.UDP != nil {
+			virtual = *b.UDP
+		}
+		real, allowed := m.translate(virtual)
+		if !allowed {
+			errors.LogDebug(flow.ctx, "dropping UDP datagram to ", virtual, ", blocked by a NAT rule")
+			continue
+		}
+		addr, err := udpAddrOf(real)
+		if err != nil {
+			errors.LogInfoInner(flow.ctx, err, "dropping UDP datagram to ", real)
+			continue
+		}
+		if dropsDatagram(m.rule, int(b.Len()), addr.IP.To4() == nil) {
+			errors.LogDebug(flow.ctx, "dropping UDP datagram of ", b.Len(), " bytes to ", real, ", over the mtu of NAT rule ", m.rule.RuleId)
+			m.handler.oversizeDrop(flow.session.rule)
+			continue
+		}
+		m.pinWireGuard(flow, b.Bytes(), real)
+
+		conn, err := m.connFor(real)
+		if err != nil {
+			return err
+		}
+
+		endpoint := endpointOf(addr)
+		m.Lock()
+		m.permitted[netip.AddrPortFrom(endpoint.Addr(), 0)] = true
+		m.permitted[endpoint] = true
+		m.Unlock()
+
+		if batched, ok := conn.(*batchedPacketConn); ok {
+			batch.add(flow.ctx, batched, b.Bytes(), addr)
+			continue
+		}
+		if _, err := conn.WriteTo(b.Bytes(), addr); err != nil {
+			errors.LogInfoInner(flow.ctx, err, "failed to send UDP datagram to ", real)
+		}
+	}
+	return nil
+}
+
+// accepts applies the filtering behavior to a datagram received from remote
+func (m *udpMapping) accepts(remote *net.UDPAddr) bool {
+	switch m.behavior {
+	case natBehaviorFullCone:
+		return true
+	case natBehaviorRestrictedCone:
+		m.Lock()
+		defer m.Unlock()
+		return m.permitted[netip.AddrPortFrom(endpointOf(remote).Addr(), 0)]
+	default:
+		m.Lock()
+		defer m.Unlock()
+		return m.permitted[endpointOf(remote)]
+	}
+}
+
+// endpointOf returns the endpoint of addr, with IPv4-mapped IPv6 addresses as IPv4
+func endpointOf(addr *net.UDPAddr) netip.AddrPort {
+	endpoint := addr.AddrPort()
+	return netip.AddrPortFrom(endpoint.Addr().Unmap(), endpoint.Port())
+}
+
+// udpAddrOf returns the socket address of a real endpoint, resolving it only when it is a domain
+func udpAddrOf(dest xnet.Destination) (*net.UDPAddr, error) {
+	if dest.Address.Family().IsIP() {
+		return &net.UDPAddr{IP: dest.Address.IP(), Port: int(dest.Port)}, nil
+	}
+	return net.ResolveUDPAddr("udp", dest.NetAddr())
+}
+
+// virtualSource returns the virtual endpoint presented to the client for a real remote endpoint
+func (m *udpMapping) virtualSource(remote *net.UDPAddr) xnet.Destination {
+	real := xnet.UDPDestination(xnet.IPAddress(remote.IP), xnet.Port(remote.Port))
+	m.Lock()
+	virtual, found := m.virtualOf[real.NetAddr()]
+	m.Unlock()
+	if found {
+		return virtual
+	}
+
+	if ip, ok := m.handler.virtualAddressOf(remote.IP); ok {
+		return xnet.UDPDestination(xnet.IPAddress(ip), xnet.Port(remote.Port))
+	}
+	return real
+}
+
+// virtualOfRemote is virtualSource for the remote endpoint of an ICMP error
+func (m *udpMapping) virtualOfRemote(remote netip.AddrPort) xnet.Destination {
+	return m.virtualSource(net.UDPAddrFromAddrPort(remote))
+}
+
+// readLoop relays return traffic of one socket to the client
+func (m *udpMapping) readLoop(conn packetConn) {
+	defer m.readers.Done()
+	if batched, ok := conn.(*batchedPacketConn); ok {
+		m.readBatches(batched)
+		return
+	}
+
+	for {
+		b := buf.New()
+		b.Resize(0, buf.Size)
+		n, addr, err := conn.ReadFrom(b.Bytes())
+		if err != nil {
+			b.Release()
+			return
+		}
+		b.Resize(0, int32(n))
+
+		remote, ok := addr.(*net.UDPAddr)
+		if !ok || !m.accepts(remote) {
+			b.Release()
+			continue
+		}
+		flow := m.flow.Load()
+		if flow == nil {
+			b.Release()
+			return
+		}
+		if !m.shapers.downlink.allow(int64(n)) {
+			b.Release()
+			m.handler.shapingDrop(flow.session)
+			continue
+		}
+
+		source := m.virtualSource(remote)
+		b.UDP = &source
+		flow.session.record(false, int64(n), 1)
+		atomic.AddInt64(&m.handler.totalBytes, int64(n))
+		flow.timer.Update()
+		if err := flow.output.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil && m.flow.Load() == flow {
+			return
+		}
+	}
+}
+
+// Close closes all sockets of the mapping
+func (m *udpMapping) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	select {
+	case <-m.done:
+		return nil
+	default:
+	}
+	close(m.done)
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+	for _, pin := range m.pins {
+		m.handler.wireGuardPins.CompareAndDelete(pin, m)
+	}
+	for _, untrack := range m.untrack {
+		untrack()
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// udpTestDialer opens unconnected loopback UDP sockets like the system dialer does for UDP
+type udpTestDialer struct{}
+
+func (udpTestDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", dest.NetAddr())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &internet.PacketConnWrapper{Conn: conn, Dest: addr}, nil
+}
+
+func (udpTestDialer) DestIpAddress() net.IP { return nil }
+
+func (udpTestDialer) SetOutboundGateway(ctx context.Context, ob *session.Outbound) {}
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// runUDPMapping sends one datagram from a client to server A through a mapping with the given behavior,
+// lets server B and then server A answer the mapped address, and returns the first datagram the client receives.
+func runUDPMapping(t *testing.T, behavior string) *buf.Buffer {
+	serverA := listenUDP(t)
+	serverB := listenUDP(t)
+
+	rule := &NATRule{
+		RuleId:             "game",
+		VirtualDestination: "240.2.2.20",
+		RealDestination:    "127.0.0.1",
+		Protocol:           "udp",
+		NatBehavior:        behavior,
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{rule}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	link := &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}
+
+	portA := xnet.Port(serverA.LocalAddr().(*net.UDPAddr).Port)
+	virtualA := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), portA)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), link, virtualA, udpTestDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	request := buf.New()
+	request.WriteString("hello")
+	request.UDP = &virtualA
+	if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request}); err != nil {
+		t.Fatal(err)
+	}
+
+	serverA.SetReadDeadline(time.Now().Add(2 * time.Second))
+	payload := make([]byte, 64)
+	_, mapped, err := serverA.ReadFromUDP(payload)
+	if err != nil {
+		t.Fatalf("Server A did not receive the datagram: %v", err)
+	}
+
+	// The never-contacted server B answers first
+	if _, err := serverB.WriteToUDP([]byte("from-b"), mapped); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := serverA.WriteToUDP([]byte("from-a"), mapped); err != nil {
+		t.Fatal(err)
+	}
+
+	mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil || mb.IsEmpty() {
+		t.Fatalf("Client did not receive a reply: %v", err)
+	}
+	reply := mb[0]
+	buf.ReleaseMulti(mb[1:])
+	return reply
+}
+
+func TestUDPMappingFullCone(t *testing.T) {
+	reply := runUDPMapping(t, natBehaviorFullCone)
+	defer reply.Release()
+
+	if reply.String() != "from-b" {
+		t.Fatalf("Expected the datagram of the uncontacted host to pass a full-cone mapping, got %q", reply.String())
+	}
+	if reply.UDP == nil || reply.UDP.Address.String() != "240.2.2.20" {
+		t.Errorf("Expected the reply source to be translated back to the virtual address, got %v", reply.UDP)
+	}
+}
+
+func TestUDPMappingPortRestricted(t *testing.T) {
+	reply := runUDPMapping(t, natBehaviorPortRestricted)
+	defer reply.Release()
+
+	if reply.String() != "from-a" {
+		t.Fatalf("Expected only the contacted endpoint to pass a port-restricted mapping, got %q", reply.String())
+	}
+	if reply.UDP == nil || reply.UDP.Address.String() != "240.2.2.20" {
+		t.Errorf("Expected the reply source to be the virtual destination, got %v", reply.UDP)
+	}
+}
+
+func TestUDPMappingRestrictedConeFiltering(t *testing.T) {
+	m := &udpMapping{
+		behavior:  natBehaviorRestrictedCone,
+		permitted: map[netip.AddrPort]bool{netip.MustParseAddrPort("192.168.1.20:0"): true, netip.MustParseAddrPort("192.168.1.20:53"): true},
+	}
+	if !m.accepts(&net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}) {
+		t.Error("Restricted cone should accept any port of a contacted address")
+	}
+	if m.accepts(&net.UDPAddr{IP: net.ParseIP("192.168.1.21"), Port: 53}) {
+		t.Error("Restricted cone should reject uncontacted addresses")
+	}
+
+	m.behavior = natBehaviorSymmetric
+	if m.accepts(&net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}) {
+		t.Error("Symmetric NAT should reject other ports of a contacted address")
+	}
+}
+
+func TestUDPDatagramNAT(t *testing.T) {
+	serverA := listenUDP(t)
+	serverB := listenUDP(t)
+
+	// Without a NAT behavior every datagram still reaches its own destination
+	rule := &NATRule{RuleId: "game", VirtualDestination: "240.2.2.20", RealDestination: "127.0.0.1", Protocol: "udp"}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:         "test-site",
+		Rules:          []*NATRule{rule},
+		SessionTimeout: &SessionTimeout{UdpTimeout: 1},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	defer uplinkWriter.Close()
+	link := &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}
+
+	virtualA := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(serverA.LocalAddr().(*net.UDPAddr).Port))
+	virtualB := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(serverB.LocalAddr().(*net.UDPAddr).Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), link, virtualA, udpTestDialer{}, rule)
+	}()
+
+	payload := make([]byte, 64)
+	for _, tt := range []struct {
+		server  *net.UDPConn
+		virtual xnet.Destination
+	}{{serverA, virtualA}, {serverB, virtualB}} {
+		request := buf.New()
+		request.WriteString("to " + tt.virtual.NetAddr())
+		request.UDP = &tt.virtual
+		if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request}); err != nil {
+			t.Fatal(err)
+		}
+
+		tt.server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, mapped, err := tt.server.ReadFromUDP(payload)
+		if err != nil || string(payload[:n]) != "to "+tt.virtual.NetAddr() {
+			t.Fatalf("Expected the datagram at %v, got %q: %v", tt.virtual, payload[:n], err)
+		}
+		if _, err := tt.server.WriteToUDP([]byte("reply"), mapped); err != nil {
+			t.Fatal(err)
+		}
+		mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+		if err != nil || mb.IsEmpty() {
+			t.Fatalf("Client did not receive the reply of %v: %v", tt.virtual, err)
+		}
+		if mb[0].UDP == nil || mb[0].UDP.NetAddr() != tt.virtual.NetAddr() {
+			t.Errorf("Expected the reply from %v, got %v", tt.virtual, mb[0].UDP)
+		}
+		buf.ReleaseMulti(mb)
+	}
+
+	// The mapping closes after the UDP timeout without traffic
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the idle mapping to close after the UDP timeout")
+	}
+}
+
+func TestVirtualAddressOf(t *testing.T) {
+	handler := &Handler{config: &Config{
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+			{NpTv6VirtualPrefix: "fd01:203:405::/48", NpTv6RealPrefix: "2001:db8:1::/48"},
+		},
+	}}
+
+	tests := []struct {
+		real    string
+		virtual string
+	}{
+		{"192.168.1.37", "240.2.2.37"},
+		{"2001:db8:1:d550::1234", "fd01:203:405:1::1234"},
+	}
+	for _, tt := range tests {
+		ip, ok := handler.virtualAddressOf(net.ParseIP(tt.real))
+		if !ok || !ip.Equal(net.ParseIP(tt.virtual)) {
+			t.Errorf("virtualAddressOf(%s) = %v, %v; want %s", tt.real, ip, ok, tt.virtual)
+		}
+	}
+
+	if _, ok := handler.virtualAddressOf(net.ParseIP("10.0.0.1")); ok {
+		t.Error("Expected no virtual address outside the configured ranges")
+	}
+}
@@ -1,7 +1,13 @@
 package nat
 
 import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 
 	"github.com/xtls/xray-core/common/protocol"
 )
@@ -16,27 +22,153 @@ func (c *Config) Equals(another protocol.Account) bool {
 		return false
 	}
 
-	// Compare basic configuration
-	if c.SiteId != thatNat.SiteId || c.UserLevel != thatNat.UserLevel {
-		return false
+	// Every field counts, down to the rules, ranges, timeouts and limits
+	return proto.Equal(c, thatNat)
+}
+
+func (c *Config) ToProto() proto.Message {
+	return c // Return the config itself as proto message
+}
+
+// Diff returns the changes from c to another, one human-readable line per changed field, in
+// the order of the fields of the configuration. Rules are told apart by their ruleId, or their
+// virtualDestination without one, and virtual ranges by their virtual network, so that changes
+// of a rule or range are listed under it rather than as changes of its position.
+func (c *Config) Diff(another *Config) []string {
+	var changes []string
+	from, to := c.ProtoReflect(), another.ProtoReflect()
+	fields := from.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		switch field.Name() {
+		case "rules":
+			changes = append(changes, diffKeyed("rules", c.GetRules(), another.GetRules(), ruleKey)...)
+		case "virtual_ranges":
+			changes = append(changes, diffKeyed("virtualRanges", c.GetVirtualRanges(), another.GetVirtualRanges(), rangeKey)...)
+		default:
+			changes = append(changes, diffField(field.JSONName(), field, from, to)...)
+		}
 	}
+	return changes
+}
 
-	// Compare virtual ranges
-	if len(c.VirtualRanges) != len(thatNat.VirtualRanges) {
-		return false
+// diffRule returns the changes from one version of a rule to another
+func diffRule(from, to *NATRule) []string {
+	return diffMessages("", from.ProtoReflect(), to.ProtoReflect())
+}
+
+// rangeKey identifies a virtual range by its virtual network or NPTv6 prefix
+func rangeKey(vr *VirtualIPRange) string {
+	if vr.NpTv6VirtualPrefix != "" {
+		return vr.NpTv6VirtualPrefix
 	}
+	return vr.VirtualNetwork
+}
 
-	// Compare rules
-	if len(c.Rules) != len(thatNat.Rules) {
-		return false
+// diffKeyed lists the entries of a list added, removed or changed, pairing entries by key.
+// Entries sharing a key, such as the rules expanded from an object, pair in their order.
+func diffKeyed[T proto.Message](name string, from, to []T, key func(T) string) []string {
+	label := func(entries []T) []string {
+		labels := make([]string, len(entries))
+		seen := make(map[string]int)
+		for i, entry := range entries {
+			k := key(entry)
+			if seen[k]++; seen[k] > 1 {
+				k += "#" + strconv.Itoa(seen[k])
+			}
+			labels[i] = name + "[" + k + "]"
+		}
+		return labels
+	}
+	fromLabels, toLabels := label(from), label(to)
+	previous := make(map[string]T, len(from))
+	for i, entry := range from {
+		previous[fromLabels[i]] = entry
 	}
 
-	// TODO: Implement detailed comparison of virtual ranges and rules
-	// For now, just check counts
+	var changes, removed []string
+	current := make(map[string]bool, len(to))
+	for i, entry := range to {
+		current[toLabels[i]] = true
+		old, found := previous[toLabels[i]]
+		if !found {
+			changes = append(changes, toLabels[i]+": added")
+			continue
+		}
+		changes = append(changes, diffMessages(toLabels[i]+".", old.ProtoReflect(), entry.ProtoReflect())...)
+	}
+	for _, l := range fromLabels {
+		if !current[l] {
+			removed = append(removed, l+": removed")
+		}
+	}
+	return append(changes, removed...)
+}
 
-	return true
+// diffMessages returns the changes of every field from one message to another, prefixing
+// their names with path
+func diffMessages(path string, from, to protoreflect.Message) []string {
+	var changes []string
+	fields := from.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		changes = append(changes, diffField(path+field.JSONName(), field, from, to)...)
+	}
+	return changes
 }
 
-func (c *Config) ToProto() proto.Message {
-	return c // Return the config itself as proto message
-}
\ No newline at end of file
+// diffField returns the changes of a field from one message to another: its old and new
+// values for scalars and lists, or those of its own fields for messages
+func diffField(name string, field protoreflect.FieldDescriptor, from, to protoreflect.Message) []string {
+	a, b := from.Get(field), to.Get(field)
+	switch {
+	case field.IsList() || field.IsMap():
+		if a.Equal(b) {
+			return nil
+		}
+		if field.IsList() && field.Message() == nil {
+			return []string{name + ": " + formatList(field, a.List()) + " -> " + formatList(field, b.List())}
+		}
+		return []string{name + ": changed"}
+	case field.Message() != nil:
+		switch has := from.Has(field); {
+		case has != to.Has(field) && has:
+			return []string{name + ": removed"}
+		case has != to.Has(field):
+			return []string{name + ": added"}
+		case !has:
+			return nil
+		}
+		return diffMessages(name+".", a.Message(), b.Message())
+	case a.Equal(b):
+		return nil
+	default:
+		return []string{name + ": " + formatValue(field, a) + " -> " + formatValue(field, b)}
+	}
+}
+
+// formatList formats a list of scalars
+func formatList(field protoreflect.FieldDescriptor, list protoreflect.List) string {
+	values := make([]string, list.Len())
+	for i := range values {
+		values[i] = formatValue(field, list.Get(i))
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+// formatValue formats a scalar, quoting strings so that empty ones show
+func formatValue(field protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		return strconv.Quote(value.String())
+	case protoreflect.BytesKind:
+		return hex.EncodeToString(value.Bytes())
+	case protoreflect.EnumKind:
+		if enum := field.Enum().Values().ByNumber(value.Enum()); enum != nil {
+			return string(enum.Name())
+		}
+		return strconv.Itoa(int(value.Enum()))
+	default:
+		return fmt.Sprint(value.Interface())
+	}
+}
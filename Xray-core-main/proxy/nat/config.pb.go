@@ -7,6 +7,8 @@
 package nat
 
 import (
+	router "github.com/xtls/xray-core/app/router"
+	internet "github.com/xtls/xray-core/transport/internet"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -39,7 +41,70 @@ type Config struct {
 	// Performance and memory limits
 	Limits *ResourceLimits `protobuf:"bytes,8,opt,name=limits,proto3" json:"limits,omitempty"`
 	// NAT64 prefix (e.g., "64:FF9B::/96" or "64:FF9B:1111::")
-	Nat64Prefix   string `protobuf:"bytes,9,opt,name=nat64_prefix,json=nat64Prefix,proto3" json:"nat64_prefix,omitempty"`
+	Nat64Prefix string `protobuf:"bytes,9,opt,name=nat64_prefix,json=nat64Prefix,proto3" json:"nat64_prefix,omitempty"`
+	// Carrier-grade NAT port block allocation (optional)
+	PortBlockAllocation *PortBlockAllocation `protobuf:"bytes,10,opt,name=port_block_allocation,json=portBlockAllocation,proto3" json:"port_block_allocation,omitempty"`
+	// Loop flows between two hosts behind the NAT back with both DNAT and SNAT applied
+	EnableHairpin bool `protobuf:"varint,11,opt,name=enable_hairpin,json=enableHairpin,proto3" json:"enable_hairpin,omitempty"`
+	// One-to-one mappings between virtual and real addresses
+	StaticMappings []*StaticMapping `protobuf:"bytes,12,rep,name=static_mappings,json=staticMappings,proto3" json:"static_mappings,omitempty"`
+	// Per-session create and teardown log (optional)
+	SessionLog *SessionLog `protobuf:"bytes,13,opt,name=session_log,json=sessionLog,proto3" json:"session_log,omitempty"`
+	// IPFIX export of NAT session events (optional)
+	FlowExport *FlowExport `protobuf:"bytes,14,opt,name=flow_export,json=flowExport,proto3" json:"flow_export,omitempty"`
+	// JSON or YAML file of additional rules, reloaded when it changes
+	RulesFile string `protobuf:"bytes,15,opt,name=rules_file,json=rulesFile,proto3" json:"rules_file,omitempty"`
+	// How overlapping rules and ranges are resolved: firstMatch (default),
+	// longestPrefix or highestPriority
+	MatchStrategy string `protobuf:"bytes,16,opt,name=match_strategy,json=matchStrategy,proto3" json:"match_strategy,omitempty"`
+	// Periodic checkpoint of the session table, restored on startup (optional)
+	SessionPersistence *SessionPersistence `protobuf:"bytes,17,opt,name=session_persistence,json=sessionPersistence,proto3" json:"session_persistence,omitempty"`
+	// Session state replication between active and standby peers (optional)
+	Replication *Replication `protobuf:"bytes,18,opt,name=replication,proto3" json:"replication,omitempty"`
+	// Partitioning of the virtual addresses among several nodes (optional)
+	Cluster *Cluster `protobuf:"bytes,19,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	// Port Control Protocol (RFC 6887) server for clients behind the NAT (optional)
+	PortControl *PortControl `protobuf:"bytes,20,opt,name=port_control,json=portControl,proto3" json:"port_control,omitempty"`
+	// STUN binding responder for discovering the mapped address and the NAT behavior
+	Stun *Stun `protobuf:"bytes,21,opt,name=stun,proto3" json:"stun,omitempty"`
+	// Distribution of the rule set from a controller to member sites (optional)
+	RuleDistribution *RuleDistribution `protobuf:"bytes,22,opt,name=rule_distribution,json=ruleDistribution,proto3" json:"rule_distribution,omitempty"`
+	// Virtual addresses leased to real hosts on demand through the NAT control API (optional)
+	AddressPool *AddressPool `protobuf:"bytes,23,opt,name=address_pool,json=addressPool,proto3" json:"address_pool,omitempty"`
+	// Match flows to FakeDNS fake IPs by the domain the fake IP stands for, so rules can be
+	// written by hostname
+	FakeDns bool `protobuf:"varint,24,opt,name=fake_dns,json=fakeDns,proto3" json:"fake_dns,omitempty"`
+	// Session quotas and new session rate limits of each virtual source address (optional)
+	PerSourceLimits *PerSourceLimits `protobuf:"bytes,25,opt,name=per_source_limits,json=perSourceLimits,proto3" json:"per_source_limits,omitempty"`
+	// Append-only audit log of rule changes and administrative actions, written to the sinks
+	// of the session log (optional)
+	AuditLog *SessionLog `protobuf:"bytes,26,opt,name=audit_log,json=auditLog,proto3" json:"audit_log,omitempty"`
+	// Sessions shared with other gateways through a Redis server, so a flow that anycast or ECMP
+	// moves to another gateway keeps its mapping (optional)
+	SharedSessions *SharedSessions `protobuf:"bytes,27,opt,name=shared_sessions,json=sharedSessions,proto3" json:"shared_sessions,omitempty"`
+	// Translation of matched flows in the Linux kernel by nftables DNAT rules programmed from the
+	// rules, instead of relaying them through Xray (optional)
+	KernelOffload *KernelOffload `protobuf:"bytes,28,opt,name=kernel_offload,json=kernelOffload,proto3" json:"kernel_offload,omitempty"`
+	// The downlink of plain TCP sessions of rules without ALGs is relayed with splice(2) on Linux,
+	// from the real connection straight into the inbound connection when the inbound allows it, and
+	// read with readv otherwise; set to copy every flow through Xray buffers instead
+	DisableSplice bool `protobuf:"varint,29,opt,name=disable_splice,json=disableSplice,proto3" json:"disable_splice,omitempty"`
+	// Read and write the datagrams of plain UDP sockets of mappings in batches of up to 16, with
+	// recvmmsg and sendmmsg on Linux, cutting system calls for flows of many small datagrams; each
+	// mapping then holds 16 receive buffers instead of one
+	UdpBatching bool `protobuf:"varint,30,opt,name=udp_batching,json=udpBatching,proto3" json:"udp_batching,omitempty"`
+	// Webhook the session create and teardown events are POSTed to in batches, for billing or
+	// SIEM systems consuming them in near real time (optional)
+	SessionWebhook *SessionWebhook `protobuf:"bytes,31,opt,name=session_webhook,json=sessionWebhook,proto3" json:"session_webhook,omitempty"`
+	// rfc4787 enforces the behavioral requirements of RFC 4787, RFC 5382 and RFC 5508:
+	// endpoint-independent UDP mappings, hairpinning and the shortest timeouts they allow
+	ComplianceMode string `protobuf:"bytes,32,opt,name=compliance_mode,json=complianceMode,proto3" json:"compliance_mode,omitempty"`
+	// Customer-side translator of 464XLAT (RFC 6877), carrying IPv4 flows across IPv6-only
+	// transport through the provider-side translator (optional)
+	Clat *Clat `protobuf:"bytes,33,opt,name=clat,proto3" json:"clat,omitempty"`
+	// Algorithmic address and port mapping rules of MAP-T / MAP-E (RFC 7597, RFC 7599), translating
+	// blocks of virtual IPv4 space to IPv6 real destinations without per-flow state
+	MapRules      []*MapRule `protobuf:"bytes,34,rep,name=map_rules,json=mapRules,proto3" json:"map_rules,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -137,111 +202,2116 @@ func (x *Config) GetNat64Prefix() string {
 	return ""
 }
 
+func (x *Config) GetPortBlockAllocation() *PortBlockAllocation {
+	if x != nil {
+		return x.PortBlockAllocation
+	}
+	return nil
+}
+
+func (x *Config) GetEnableHairpin() bool {
+	if x != nil {
+		return x.EnableHairpin
+	}
+	return false
+}
+
+func (x *Config) GetStaticMappings() []*StaticMapping {
+	if x != nil {
+		return x.StaticMappings
+	}
+	return nil
+}
+
+func (x *Config) GetSessionLog() *SessionLog {
+	if x != nil {
+		return x.SessionLog
+	}
+	return nil
+}
+
+func (x *Config) GetFlowExport() *FlowExport {
+	if x != nil {
+		return x.FlowExport
+	}
+	return nil
+}
+
+func (x *Config) GetRulesFile() string {
+	if x != nil {
+		return x.RulesFile
+	}
+	return ""
+}
+
+func (x *Config) GetMatchStrategy() string {
+	if x != nil {
+		return x.MatchStrategy
+	}
+	return ""
+}
+
+func (x *Config) GetSessionPersistence() *SessionPersistence {
+	if x != nil {
+		return x.SessionPersistence
+	}
+	return nil
+}
+
+func (x *Config) GetReplication() *Replication {
+	if x != nil {
+		return x.Replication
+	}
+	return nil
+}
+
+func (x *Config) GetCluster() *Cluster {
+	if x != nil {
+		return x.Cluster
+	}
+	return nil
+}
+
+func (x *Config) GetPortControl() *PortControl {
+	if x != nil {
+		return x.PortControl
+	}
+	return nil
+}
+
+func (x *Config) GetStun() *Stun {
+	if x != nil {
+		return x.Stun
+	}
+	return nil
+}
+
+func (x *Config) GetRuleDistribution() *RuleDistribution {
+	if x != nil {
+		return x.RuleDistribution
+	}
+	return nil
+}
+
+func (x *Config) GetAddressPool() *AddressPool {
+	if x != nil {
+		return x.AddressPool
+	}
+	return nil
+}
+
+func (x *Config) GetFakeDns() bool {
+	if x != nil {
+		return x.FakeDns
+	}
+	return false
+}
+
+func (x *Config) GetPerSourceLimits() *PerSourceLimits {
+	if x != nil {
+		return x.PerSourceLimits
+	}
+	return nil
+}
+
+func (x *Config) GetAuditLog() *SessionLog {
+	if x != nil {
+		return x.AuditLog
+	}
+	return nil
+}
+
+func (x *Config) GetSharedSessions() *SharedSessions {
+	if x != nil {
+		return x.SharedSessions
+	}
+	return nil
+}
+
+func (x *Config) GetKernelOffload() *KernelOffload {
+	if x != nil {
+		return x.KernelOffload
+	}
+	return nil
+}
+
+func (x *Config) GetDisableSplice() bool {
+	if x != nil {
+		return x.DisableSplice
+	}
+	return false
+}
+
+func (x *Config) GetUdpBatching() bool {
+	if x != nil {
+		return x.UdpBatching
+	}
+	return false
+}
+
+func (x *Config) GetSessionWebhook() *SessionWebhook {
+	if x != nil {
+		return x.SessionWebhook
+	}
+	return nil
+}
+
+func (x *Config) GetComplianceMode() string {
+	if x != nil {
+		return x.ComplianceMode
+	}
+	return ""
+}
+
+func (x *Config) GetClat() *Clat {
+	if x != nil {
+		return x.Clat
+	}
+	return nil
+}
+
+func (x *Config) GetMapRules() []*MapRule {
+	if x != nil {
+		return x.MapRules
+	}
+	return nil
+}
+
+type MapRule struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Id the flows of the rule are accounted under, map-<ipv4Prefix> when empty
+	RuleId string `protobuf:"bytes,1,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Virtual IPv4 prefix of the rule (e.g., "240.10.0.0/16")
+	Ipv4Prefix string `protobuf:"bytes,2,opt,name=ipv4_prefix,json=ipv4Prefix,proto3" json:"ipv4_prefix,omitempty"`
+	// IPv6 prefix of the rule the EA bits are appended to (e.g., "2001:db8::/40")
+	Ipv6Prefix string `protobuf:"bytes,3,opt,name=ipv6_prefix,json=ipv6Prefix,proto3" json:"ipv6_prefix,omitempty"`
+	// Length of the embedded address bits: the IPv4 suffix bits followed by the port set id
+	// (PSID) bits, at most 48 and at least the IPv4 suffix length
+	EaBitsLength uint32 `protobuf:"varint,4,opt,name=ea_bits_length,json=eaBitsLength,proto3" json:"ea_bits_length,omitempty"`
+	// Offset of the PSID in the destination port, 6 when zero; ports whose offset bits are all
+	// zero are outside every port set and left to the other rules
+	PsidOffset    uint32 `protobuf:"varint,5,opt,name=psid_offset,json=psidOffset,proto3" json:"psid_offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MapRule) Reset() {
+	*x = MapRule{}
+	mi := &file_config_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MapRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MapRule) ProtoMessage() {}
+
+func (x *MapRule) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MapRule.ProtoReflect.Descriptor instead.
+func (*MapRule) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MapRule) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *MapRule) GetIpv4Prefix() string {
+	if x != nil {
+		return x.Ipv4Prefix
+	}
+	return ""
+}
+
+func (x *MapRule) GetIpv6Prefix() string {
+	if x != nil {
+		return x.Ipv6Prefix
+	}
+	return ""
+}
+
+func (x *MapRule) GetEaBitsLength() uint32 {
+	if x != nil {
+		return x.EaBitsLength
+	}
+	return 0
+}
+
+func (x *MapRule) GetPsidOffset() uint32 {
+	if x != nil {
+		return x.PsidOffset
+	}
+	return 0
+}
+
+type AddressPool struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Virtual network the addresses are leased from (e.g., "240.5.5.0/24")
+	Network string `protobuf:"bytes,1,opt,name=network,proto3" json:"network,omitempty"`
+	// Seconds a lease lasts unless renewed, when not requested otherwise; defaults to 3600
+	LeaseTime uint32 `protobuf:"varint,2,opt,name=lease_time,json=leaseTime,proto3" json:"lease_time,omitempty"`
+	// JSON file the leases are imported from at startup and exported to whenever they change
+	// (optional)
+	LeaseFile     string `protobuf:"bytes,3,opt,name=lease_file,json=leaseFile,proto3" json:"lease_file,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddressPool) Reset() {
+	*x = AddressPool{}
+	mi := &file_config_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddressPool) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddressPool) ProtoMessage() {}
+
+func (x *AddressPool) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddressPool.ProtoReflect.Descriptor instead.
+func (*AddressPool) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AddressPool) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *AddressPool) GetLeaseTime() uint32 {
+	if x != nil {
+		return x.LeaseTime
+	}
+	return 0
+}
+
+func (x *AddressPool) GetLeaseFile() string {
+	if x != nil {
+		return x.LeaseFile
+	}
+	return ""
+}
+
+type RuleDistribution struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Address the controller serves its rule set on; set on the controller
+	Listen string `protobuf:"bytes,1,opt,name=listen,proto3" json:"listen,omitempty"`
+	// Address of the controller the rules of this site are subscribed from; set on members
+	Controller string `protobuf:"bytes,2,opt,name=controller,proto3" json:"controller,omitempty"`
+	// Shared secret of the controller and its members (optional)
+	Secret        string `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleDistribution) Reset() {
+	*x = RuleDistribution{}
+	mi := &file_config_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleDistribution) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleDistribution) ProtoMessage() {}
+
+func (x *RuleDistribution) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleDistribution.ProtoReflect.Descriptor instead.
+func (*RuleDistribution) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RuleDistribution) GetListen() string {
+	if x != nil {
+		return x.Listen
+	}
+	return ""
+}
+
+func (x *RuleDistribution) GetController() string {
+	if x != nil {
+		return x.Controller
+	}
+	return ""
+}
+
+func (x *RuleDistribution) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+type Clat struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// NAT64 prefix of the provider-side translator (PLAT) IPv4 destinations are embedded into
+	// (RFC 6052), 64:ff9b::/96 when empty
+	PlatPrefix string `protobuf:"bytes,1,opt,name=plat_prefix,json=platPrefix,proto3" json:"plat_prefix,omitempty"`
+	// IPv6 prefix of the CLAT the IPv4 source of a flow is embedded into, as the source of the
+	// IPv6 connection; the addresses must be local, such as of a prefix routed to the loopback
+	// interface. The source is left to the dialer when empty.
+	SourcePrefix string `protobuf:"bytes,2,opt,name=source_prefix,json=sourcePrefix,proto3" json:"source_prefix,omitempty"`
+	// IPv4 networks translated; every IPv4 destination that no rule, static mapping, lease or
+	// virtual range translates when empty
+	Networks      []string `protobuf:"bytes,3,rep,name=networks,proto3" json:"networks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Clat) Reset() {
+	*x = Clat{}
+	mi := &file_config_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Clat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Clat) ProtoMessage() {}
+
+func (x *Clat) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Clat.ProtoReflect.Descriptor instead.
+func (*Clat) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Clat) GetPlatPrefix() string {
+	if x != nil {
+		return x.PlatPrefix
+	}
+	return ""
+}
+
+func (x *Clat) GetSourcePrefix() string {
+	if x != nil {
+		return x.SourcePrefix
+	}
+	return ""
+}
+
+func (x *Clat) GetNetworks() []string {
+	if x != nil {
+		return x.Networks
+	}
+	return nil
+}
+
+type Stun struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Virtual address binding requests are answered on, on UDP ports 3478 and 3479
+	Address string `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	// Second virtual address, announced as OTHER-ADDRESS so clients can discover the
+	// NAT behavior (RFC 5780); behavior discovery is unavailable when empty
+	AlternateAddress string `protobuf:"bytes,2,opt,name=alternate_address,json=alternateAddress,proto3" json:"alternate_address,omitempty"`
+	// NAT behavior of flows to the responder, as of rules
+	NatBehavior string `protobuf:"bytes,3,opt,name=nat_behavior,json=natBehavior,proto3" json:"nat_behavior,omitempty"`
+	// Address answered as the mapped address, defaults to the address of the
+	// default route; the mapped port is the source port the NAT sent from
+	ExternalAddress string `protobuf:"bytes,4,opt,name=external_address,json=externalAddress,proto3" json:"external_address,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Stun) Reset() {
+	*x = Stun{}
+	mi := &file_config_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stun) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stun) ProtoMessage() {}
+
+func (x *Stun) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stun.ProtoReflect.Descriptor instead.
+func (*Stun) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Stun) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Stun) GetAlternateAddress() string {
+	if x != nil {
+		return x.AlternateAddress
+	}
+	return ""
+}
+
+func (x *Stun) GetNatBehavior() string {
+	if x != nil {
+		return x.NatBehavior
+	}
+	return ""
+}
+
+func (x *Stun) GetExternalAddress() string {
+	if x != nil {
+		return x.ExternalAddress
+	}
+	return ""
+}
+
+type PortControl struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Address the PCP server listens on, on the virtual network side
+	// (e.g. "240.2.2.1:5351", port 5351 when omitted)
+	Listen string `protobuf:"bytes,1,opt,name=listen,proto3" json:"listen,omitempty"`
+	// Local address inbound mappings are opened on, all addresses when empty
+	MappingAddress string `protobuf:"bytes,2,opt,name=mapping_address,json=mappingAddress,proto3" json:"mapping_address,omitempty"`
+	// External address announced to clients, defaults to mapping_address or the
+	// address of the default route
+	ExternalAddress string `protobuf:"bytes,3,opt,name=external_address,json=externalAddress,proto3" json:"external_address,omitempty"`
+	// External ports clients may map, as a port list (default 1024-65535)
+	Ports string `protobuf:"bytes,4,opt,name=ports,proto3" json:"ports,omitempty"`
+	// Longest lifetime granted to a mapping in seconds (default 7200)
+	MaxLifetime uint32 `protobuf:"varint,5,opt,name=max_lifetime,json=maxLifetime,proto3" json:"max_lifetime,omitempty"`
+	// Mappings a single client may hold (default 64)
+	MaxMappingsPerClient uint32 `protobuf:"varint,6,opt,name=max_mappings_per_client,json=maxMappingsPerClient,proto3" json:"max_mappings_per_client,omitempty"`
+	// Address the UPnP IGD description and control server listens on, on the
+	// virtual network side (e.g. "240.2.2.1:5000"); UPnP is disabled when empty
+	UpnpListen    string `protobuf:"bytes,7,opt,name=upnp_listen,json=upnpListen,proto3" json:"upnp_listen,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PortControl) Reset() {
+	*x = PortControl{}
+	mi := &file_config_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortControl) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortControl) ProtoMessage() {}
+
+func (x *PortControl) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortControl.ProtoReflect.Descriptor instead.
+func (*PortControl) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PortControl) GetListen() string {
+	if x != nil {
+		return x.Listen
+	}
+	return ""
+}
+
+func (x *PortControl) GetMappingAddress() string {
+	if x != nil {
+		return x.MappingAddress
+	}
+	return ""
+}
+
+func (x *PortControl) GetExternalAddress() string {
+	if x != nil {
+		return x.ExternalAddress
+	}
+	return ""
+}
+
+func (x *PortControl) GetPorts() string {
+	if x != nil {
+		return x.Ports
+	}
+	return ""
+}
+
+func (x *PortControl) GetMaxLifetime() uint32 {
+	if x != nil {
+		return x.MaxLifetime
+	}
+	return 0
+}
+
+func (x *PortControl) GetMaxMappingsPerClient() uint32 {
+	if x != nil {
+		return x.MaxMappingsPerClient
+	}
+	return 0
+}
+
+func (x *PortControl) GetUpnpListen() string {
+	if x != nil {
+		return x.UpnpListen
+	}
+	return ""
+}
+
+type Cluster struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Identifier of this node, one of nodes
+	NodeId string `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	// All nodes of the cluster, listed identically on every node
+	Nodes []*ClusterNode `protobuf:"bytes,2,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	// Points of every node on the hash ring, defaults to 100
+	Replicas      uint32 `protobuf:"varint,3,opt,name=replicas,proto3" json:"replicas,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Cluster) Reset() {
+	*x = Cluster{}
+	mi := &file_config_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Cluster) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cluster) ProtoMessage() {}
+
+func (x *Cluster) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cluster.ProtoReflect.Descriptor instead.
+func (*Cluster) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Cluster) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *Cluster) GetNodes() []*ClusterNode {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *Cluster) GetReplicas() uint32 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
+type ClusterNode struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Outbound that carries flows owned by the node to it; unused for this node
+	OutboundTag   string `protobuf:"bytes,2,opt,name=outbound_tag,json=outboundTag,proto3" json:"outbound_tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClusterNode) Reset() {
+	*x = ClusterNode{}
+	mi := &file_config_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterNode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterNode) ProtoMessage() {}
+
+func (x *ClusterNode) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterNode.ProtoReflect.Descriptor instead.
+func (*ClusterNode) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ClusterNode) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ClusterNode) GetOutboundTag() string {
+	if x != nil {
+		return x.OutboundTag
+	}
+	return ""
+}
+
+type Replication struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Address the replication service listens on for events of the peer
+	Listen string `protobuf:"bytes,1,opt,name=listen,proto3" json:"listen,omitempty"`
+	// Address of the peer's replication service that session events are streamed to
+	Peer string `protobuf:"bytes,2,opt,name=peer,proto3" json:"peer,omitempty"`
+	// Shared secret both peers present (optional)
+	Secret string `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	// Seconds between activity updates of replicated sessions, defaults to 10
+	SyncInterval  uint32 `protobuf:"varint,4,opt,name=sync_interval,json=syncInterval,proto3" json:"sync_interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Replication) Reset() {
+	*x = Replication{}
+	mi := &file_config_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Replication) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Replication) ProtoMessage() {}
+
+func (x *Replication) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Replication.ProtoReflect.Descriptor instead.
+func (*Replication) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Replication) GetListen() string {
+	if x != nil {
+		return x.Listen
+	}
+	return ""
+}
+
+func (x *Replication) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *Replication) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+func (x *Replication) GetSyncInterval() uint32 {
+	if x != nil {
+		return x.SyncInterval
+	}
+	return 0
+}
+
+type SessionPersistence struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// File the checkpoint is written to; takes precedence over redis_address
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// Redis server, as host:port, the checkpoint is stored on
+	RedisAddress  string `protobuf:"bytes,2,opt,name=redis_address,json=redisAddress,proto3" json:"redis_address,omitempty"`
+	RedisPassword string `protobuf:"bytes,3,opt,name=redis_password,json=redisPassword,proto3" json:"redis_password,omitempty"`
+	// Redis key of the checkpoint, defaults to xray:nat:<site_id>
+	RedisKey string `protobuf:"bytes,4,opt,name=redis_key,json=redisKey,proto3" json:"redis_key,omitempty"`
+	// Seconds between checkpoints, defaults to 60
+	Interval      uint32 `protobuf:"varint,5,opt,name=interval,proto3" json:"interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionPersistence) Reset() {
+	*x = SessionPersistence{}
+	mi := &file_config_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionPersistence) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionPersistence) ProtoMessage() {}
+
+func (x *SessionPersistence) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionPersistence.ProtoReflect.Descriptor instead.
+func (*SessionPersistence) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SessionPersistence) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SessionPersistence) GetRedisAddress() string {
+	if x != nil {
+		return x.RedisAddress
+	}
+	return ""
+}
+
+func (x *SessionPersistence) GetRedisPassword() string {
+	if x != nil {
+		return x.RedisPassword
+	}
+	return ""
+}
+
+func (x *SessionPersistence) GetRedisKey() string {
+	if x != nil {
+		return x.RedisKey
+	}
+	return ""
+}
+
+func (x *SessionPersistence) GetInterval() uint32 {
+	if x != nil {
+		return x.Interval
+	}
+	return 0
+}
+
+type KernelOffload struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// nftables table of the inet family the translations are programmed into, replaced as a whole
+	// whenever the rules change; defaults to xray_nat
+	Table string `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	// Path of the nft binary, defaults to nft on the PATH
+	NftPath string `protobuf:"bytes,2,opt,name=nft_path,json=nftPath,proto3" json:"nft_path,omitempty"`
+	// Conntrack mark of the flows translated by the kernel, which are masqueraded on their way out;
+	// defaults to 0x584e
+	Mark          uint32 `protobuf:"varint,3,opt,name=mark,proto3" json:"mark,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KernelOffload) Reset() {
+	*x = KernelOffload{}
+	mi := &file_config_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KernelOffload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KernelOffload) ProtoMessage() {}
+
+func (x *KernelOffload) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KernelOffload.ProtoReflect.Descriptor instead.
+func (*KernelOffload) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *KernelOffload) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *KernelOffload) GetNftPath() string {
+	if x != nil {
+		return x.NftPath
+	}
+	return ""
+}
+
+func (x *KernelOffload) GetMark() uint32 {
+	if x != nil {
+		return x.Mark
+	}
+	return 0
+}
+
+type SharedSessions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Redis server, as host:port, the sessions are shared on
+	RedisAddress  string `protobuf:"bytes,1,opt,name=redis_address,json=redisAddress,proto3" json:"redis_address,omitempty"`
+	RedisPassword string `protobuf:"bytes,2,opt,name=redis_password,json=redisPassword,proto3" json:"redis_password,omitempty"`
+	// Prefix of the Redis keys of the sessions, defaults to xray:nat:sessions:
+	KeyPrefix string `protobuf:"bytes,3,opt,name=key_prefix,json=keyPrefix,proto3" json:"key_prefix,omitempty"`
+	// Seconds between expiry updates of the shared sessions, defaults to 10
+	SyncInterval  uint32 `protobuf:"varint,4,opt,name=sync_interval,json=syncInterval,proto3" json:"sync_interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SharedSessions) Reset() {
+	*x = SharedSessions{}
+	mi := &file_config_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SharedSessions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SharedSessions) ProtoMessage() {}
+
+func (x *SharedSessions) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SharedSessions.ProtoReflect.Descriptor instead.
+func (*SharedSessions) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SharedSessions) GetRedisAddress() string {
+	if x != nil {
+		return x.RedisAddress
+	}
+	return ""
+}
+
+func (x *SharedSessions) GetRedisPassword() string {
+	if x != nil {
+		return x.RedisPassword
+	}
+	return ""
+}
+
+func (x *SharedSessions) GetKeyPrefix() string {
+	if x != nil {
+		return x.KeyPrefix
+	}
+	return ""
+}
+
+func (x *SharedSessions) GetSyncInterval() uint32 {
+	if x != nil {
+		return x.SyncInterval
+	}
+	return 0
+}
+
+type FlowExport struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// IPFIX collector as "host:port", reached over UDP
+	Collector string `protobuf:"bytes,1,opt,name=collector,proto3" json:"collector,omitempty"`
+	// Observation domain ID of the exported messages
+	ObservationDomainId uint32 `protobuf:"varint,2,opt,name=observation_domain_id,json=observationDomainId,proto3" json:"observation_domain_id,omitempty"`
+	// Seconds between template retransmissions (default 600)
+	TemplateRefreshInterval uint32 `protobuf:"varint,3,opt,name=template_refresh_interval,json=templateRefreshInterval,proto3" json:"template_refresh_interval,omitempty"`
+	// Milliseconds events are batched before a message is sent (default 1000)
+	FlushInterval uint32 `protobuf:"varint,4,opt,name=flush_interval,json=flushInterval,proto3" json:"flush_interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlowExport) Reset() {
+	*x = FlowExport{}
+	mi := &file_config_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlowExport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowExport) ProtoMessage() {}
+
+func (x *FlowExport) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowExport.ProtoReflect.Descriptor instead.
+func (*FlowExport) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *FlowExport) GetCollector() string {
+	if x != nil {
+		return x.Collector
+	}
+	return ""
+}
+
+func (x *FlowExport) GetObservationDomainId() uint32 {
+	if x != nil {
+		return x.ObservationDomainId
+	}
+	return 0
+}
+
+func (x *FlowExport) GetTemplateRefreshInterval() uint32 {
+	if x != nil {
+		return x.TemplateRefreshInterval
+	}
+	return 0
+}
+
+func (x *FlowExport) GetFlushInterval() uint32 {
+	if x != nil {
+		return x.FlushInterval
+	}
+	return 0
+}
+
+type SessionLog struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sink of session events: "log" (xray log, default), "file" or "syslog"
+	Sink string `protobuf:"bytes,1,opt,name=sink,proto3" json:"sink,omitempty"`
+	// File sink: path of the log file, rotated when it exceeds max_size_mb
+	Path      string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	MaxSizeMb uint32 `protobuf:"varint,3,opt,name=max_size_mb,json=maxSizeMb,proto3" json:"max_size_mb,omitempty"`
+	// File sink: number of rotated files to keep (default 5)
+	MaxBackups uint32 `protobuf:"varint,4,opt,name=max_backups,json=maxBackups,proto3" json:"max_backups,omitempty"`
+	// Syslog sink: remote syslog server as "udp:host:port" or "tcp:host:port",
+	// empty for the local syslog daemon
+	SyslogAddress string `protobuf:"bytes,5,opt,name=syslog_address,json=syslogAddress,proto3" json:"syslog_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionLog) Reset() {
+	*x = SessionLog{}
+	mi := &file_config_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionLog) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionLog) ProtoMessage() {}
+
+func (x *SessionLog) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionLog.ProtoReflect.Descriptor instead.
+func (*SessionLog) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *SessionLog) GetSink() string {
+	if x != nil {
+		return x.Sink
+	}
+	return ""
+}
+
+func (x *SessionLog) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SessionLog) GetMaxSizeMb() uint32 {
+	if x != nil {
+		return x.MaxSizeMb
+	}
+	return 0
+}
+
+func (x *SessionLog) GetMaxBackups() uint32 {
+	if x != nil {
+		return x.MaxBackups
+	}
+	return 0
+}
+
+func (x *SessionLog) GetSyslogAddress() string {
+	if x != nil {
+		return x.SyslogAddress
+	}
+	return ""
+}
+
+type SessionWebhook struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// http or https URL the events are POSTed to as a JSON array
+	Url string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	// Most events of a request (default 100)
+	BatchSize uint32 `protobuf:"varint,2,opt,name=batch_size,json=batchSize,proto3" json:"batch_size,omitempty"`
+	// Milliseconds events are batched before a request is sent (default 1000)
+	FlushInterval uint32 `protobuf:"varint,3,opt,name=flush_interval,json=flushInterval,proto3" json:"flush_interval,omitempty"`
+	// Retries of a failed request, with a doubling delay, before its events are dropped (default 3)
+	MaxRetries uint32 `protobuf:"varint,4,opt,name=max_retries,json=maxRetries,proto3" json:"max_retries,omitempty"`
+	// Value of the Authorization header of the requests, if any
+	Authorization string `protobuf:"bytes,5,opt,name=authorization,proto3" json:"authorization,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionWebhook) Reset() {
+	*x = SessionWebhook{}
+	mi := &file_config_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionWebhook) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionWebhook) ProtoMessage() {}
+
+func (x *SessionWebhook) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionWebhook.ProtoReflect.Descriptor instead.
+func (*SessionWebhook) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *SessionWebhook) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *SessionWebhook) GetBatchSize() uint32 {
+	if x != nil {
+		return x.BatchSize
+	}
+	return 0
+}
+
+func (x *SessionWebhook) GetFlushInterval() uint32 {
+	if x != nil {
+		return x.FlushInterval
+	}
+	return 0
+}
+
+func (x *SessionWebhook) GetMaxRetries() uint32 {
+	if x != nil {
+		return x.MaxRetries
+	}
+	return 0
+}
+
+func (x *SessionWebhook) GetAuthorization() string {
+	if x != nil {
+		return x.Authorization
+	}
+	return ""
+}
+
+type StaticMapping struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Virtual address (e.g., "240.2.2.20")
+	VirtualAddress string `protobuf:"bytes,1,opt,name=virtual_address,json=virtualAddress,proto3" json:"virtual_address,omitempty"`
+	// Real address the virtual address stands for (e.g., "192.168.1.20")
+	RealAddress   string `protobuf:"bytes,2,opt,name=real_address,json=realAddress,proto3" json:"real_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StaticMapping) Reset() {
+	*x = StaticMapping{}
+	mi := &file_config_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StaticMapping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StaticMapping) ProtoMessage() {}
+
+func (x *StaticMapping) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StaticMapping.ProtoReflect.Descriptor instead.
+func (*StaticMapping) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *StaticMapping) GetVirtualAddress() string {
+	if x != nil {
+		return x.VirtualAddress
+	}
+	return ""
+}
+
+func (x *StaticMapping) GetRealAddress() string {
+	if x != nil {
+		return x.RealAddress
+	}
+	return ""
+}
+
+type PortBlockAllocation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Shared public address subscribers are translated to
+	PublicAddress string `protobuf:"bytes,1,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	// Source port range handed out in blocks (default 1024-65535)
+	PortRangeStart uint32 `protobuf:"varint,2,opt,name=port_range_start,json=portRangeStart,proto3" json:"port_range_start,omitempty"`
+	PortRangeEnd   uint32 `protobuf:"varint,3,opt,name=port_range_end,json=portRangeEnd,proto3" json:"port_range_end,omitempty"`
+	// Number of contiguous ports per block (default 512)
+	BlockSize uint32 `protobuf:"varint,4,opt,name=block_size,json=blockSize,proto3" json:"block_size,omitempty"`
+	// Maximum number of blocks a subscriber may hold (default 1)
+	MaxBlocksPerSubscriber uint32 `protobuf:"varint,5,opt,name=max_blocks_per_subscriber,json=maxBlocksPerSubscriber,proto3" json:"max_blocks_per_subscriber,omitempty"`
+	// Deterministic allocation (RFC 7422): every address of subscriber_network
+	// owns fixed blocks derived from its offset in the network
+	Deterministic     bool   `protobuf:"varint,6,opt,name=deterministic,proto3" json:"deterministic,omitempty"`
+	SubscriberNetwork string `protobuf:"bytes,7,opt,name=subscriber_network,json=subscriberNetwork,proto3" json:"subscriber_network,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *PortBlockAllocation) Reset() {
+	*x = PortBlockAllocation{}
+	mi := &file_config_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortBlockAllocation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortBlockAllocation) ProtoMessage() {}
+
+func (x *PortBlockAllocation) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortBlockAllocation.ProtoReflect.Descriptor instead.
+func (*PortBlockAllocation) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *PortBlockAllocation) GetPublicAddress() string {
+	if x != nil {
+		return x.PublicAddress
+	}
+	return ""
+}
+
+func (x *PortBlockAllocation) GetPortRangeStart() uint32 {
+	if x != nil {
+		return x.PortRangeStart
+	}
+	return 0
+}
+
+func (x *PortBlockAllocation) GetPortRangeEnd() uint32 {
+	if x != nil {
+		return x.PortRangeEnd
+	}
+	return 0
+}
+
+func (x *PortBlockAllocation) GetBlockSize() uint32 {
+	if x != nil {
+		return x.BlockSize
+	}
+	return 0
+}
+
+func (x *PortBlockAllocation) GetMaxBlocksPerSubscriber() uint32 {
+	if x != nil {
+		return x.MaxBlocksPerSubscriber
+	}
+	return 0
+}
+
+func (x *PortBlockAllocation) GetDeterministic() bool {
+	if x != nil {
+		return x.Deterministic
+	}
+	return false
+}
+
+func (x *PortBlockAllocation) GetSubscriberNetwork() string {
+	if x != nil {
+		return x.SubscriberNetwork
+	}
+	return ""
+}
+
 type VirtualIPRange struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// Virtual IP range (e.g., "240.2.2.0/24")
-	VirtualNetwork string `protobuf:"bytes,1,opt,name=virtual_network,json=virtualNetwork,proto3" json:"virtual_network,omitempty"`
-	// Corresponding real network (e.g., "192.168.1.0/24")
-	RealNetwork string `protobuf:"bytes,2,opt,name=real_network,json=realNetwork,proto3" json:"real_network,omitempty"`
-	// IPv6 support
-	Ipv6Enabled bool `protobuf:"varint,3,opt,name=ipv6_enabled,json=ipv6Enabled,proto3" json:"ipv6_enabled,omitempty"`
-	// IPv6 virtual prefix
-	Ipv6VirtualPrefix string `protobuf:"bytes,4,opt,name=ipv6_virtual_prefix,json=ipv6VirtualPrefix,proto3" json:"ipv6_virtual_prefix,omitempty"`
-	unknownFields     protoimpl.UnknownFields
-	sizeCache         protoimpl.SizeCache
+	// Virtual IP range (e.g., "240.2.2.0/24")
+	VirtualNetwork string `protobuf:"bytes,1,opt,name=virtual_network,json=virtualNetwork,proto3" json:"virtual_network,omitempty"`
+	// Corresponding real network (e.g., "192.168.1.0/24")
+	RealNetwork string `protobuf:"bytes,2,opt,name=real_network,json=realNetwork,proto3" json:"real_network,omitempty"`
+	// IPv6 support
+	Ipv6Enabled bool `protobuf:"varint,3,opt,name=ipv6_enabled,json=ipv6Enabled,proto3" json:"ipv6_enabled,omitempty"`
+	// IPv6 virtual prefix
+	Ipv6VirtualPrefix string `protobuf:"bytes,4,opt,name=ipv6_virtual_prefix,json=ipv6VirtualPrefix,proto3" json:"ipv6_virtual_prefix,omitempty"`
+	// NAT46 prefix (e.g., "64:FF9B::/96"). When set, IPv4 virtual destinations
+	// are mapped into real_network and embedded into this prefix (RFC 6052)
+	Ipv4To6Prefix string `protobuf:"bytes,5,opt,name=ipv4_to6_prefix,json=ipv4To6Prefix,proto3" json:"ipv4_to6_prefix,omitempty"`
+	// NPTv6 (RFC 6296) virtual and real prefixes (e.g., "FD01:203:405::/48").
+	// Both must be set and have the same length, at most /64
+	NpTv6VirtualPrefix string `protobuf:"bytes,6,opt,name=np_tv6_virtual_prefix,json=npTv6VirtualPrefix,proto3" json:"np_tv6_virtual_prefix,omitempty"`
+	NpTv6RealPrefix    string `protobuf:"bytes,7,opt,name=np_tv6_real_prefix,json=npTv6RealPrefix,proto3" json:"np_tv6_real_prefix,omitempty"`
+	// Local address flows translated into the real network are dialed from, for gateways
+	// routing each real network by source address (optional)
+	SendThrough string `protobuf:"bytes,8,opt,name=send_through,json=sendThrough,proto3" json:"send_through,omitempty"`
+	// Sites using the range, comma separated (optional, all sites by default)
+	SourceSite string `protobuf:"bytes,9,opt,name=source_site,json=sourceSite,proto3" json:"source_site,omitempty"`
+	// Also maps the peer direction: peer_site reaches the network of source_site at
+	// peer_virtual_network, translated into peer_real_network. Both default to the networks
+	// of the range, for sites sharing the same addressing plan
+	Symmetric          bool   `protobuf:"varint,10,opt,name=symmetric,proto3" json:"symmetric,omitempty"`
+	PeerSite           string `protobuf:"bytes,11,opt,name=peer_site,json=peerSite,proto3" json:"peer_site,omitempty"`
+	PeerVirtualNetwork string `protobuf:"bytes,12,opt,name=peer_virtual_network,json=peerVirtualNetwork,proto3" json:"peer_virtual_network,omitempty"`
+	PeerRealNetwork    string `protobuf:"bytes,13,opt,name=peer_real_network,json=peerRealNetwork,proto3" json:"peer_real_network,omitempty"`
+	// Translates every address of virtual_network into real_network, a single address, and
+	// gives each session a source port of its own from masquerade_ports, per protocol, so flows
+	// of one source to several virtual addresses stay apart on the real side
+	Masquerade bool `protobuf:"varint,14,opt,name=masquerade,proto3" json:"masquerade,omitempty"`
+	// Source ports of masquerade sessions, e.g. "20000-29999" (optional, 1024-65535 by default)
+	MasqueradePorts string `protobuf:"bytes,15,opt,name=masquerade_ports,json=masqueradePorts,proto3" json:"masquerade_ports,omitempty"`
+	// Real source addresses of masquerade sessions, addresses or small networks, picked by a hash
+	// of the virtual source (optional, send_through by default)
+	MasqueradePool []string `protobuf:"bytes,16,rep,name=masquerade_pool,json=masqueradePool,proto3" json:"masquerade_pool,omitempty"`
+	// How sources use the pool (RFC 4787 section 4.1): "paired" keeps every session of a virtual
+	// source on one address while it holds sessions (default), "arbitrary" picks per session
+	MasqueradePooling string `protobuf:"bytes,17,opt,name=masquerade_pooling,json=masqueradePooling,proto3" json:"masquerade_pooling,omitempty"`
+	// Maximum concurrent sessions translated through the range, new flows over it are refused;
+	// unlimited when 0
+	MaxSessions uint32 `protobuf:"varint,18,opt,name=max_sessions,json=maxSessions,proto3" json:"max_sessions,omitempty"`
+	// TOS byte (DSCP and ECN) of the packets of the flows translated through the range, on Linux,
+	// as tos of NATRule (optional)
+	Tos uint32 `protobuf:"varint,19,opt,name=tos,proto3" json:"tos,omitempty"`
+	// preserve_tos and flow_label of NATRule for the flows translated through the range
+	PreserveTos bool   `protobuf:"varint,20,opt,name=preserve_tos,json=preserveTos,proto3" json:"preserve_tos,omitempty"`
+	FlowLabel   string `protobuf:"bytes,21,opt,name=flow_label,json=flowLabel,proto3" json:"flow_label,omitempty"`
+	// Free-form labels the API addresses groups of rules and ranges by
+	Tags []string `protobuf:"bytes,22,rep,name=tags,proto3" json:"tags,omitempty"`
+	// Switched off: no new flows are translated through the range, which keeps its sessions and
+	// statistics, until the API enables it
+	Disabled      bool `protobuf:"varint,23,opt,name=disabled,proto3" json:"disabled,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VirtualIPRange) Reset() {
+	*x = VirtualIPRange{}
+	mi := &file_config_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VirtualIPRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VirtualIPRange) ProtoMessage() {}
+
+func (x *VirtualIPRange) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VirtualIPRange.ProtoReflect.Descriptor instead.
+func (*VirtualIPRange) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *VirtualIPRange) GetVirtualNetwork() string {
+	if x != nil {
+		return x.VirtualNetwork
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetRealNetwork() string {
+	if x != nil {
+		return x.RealNetwork
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetIpv6Enabled() bool {
+	if x != nil {
+		return x.Ipv6Enabled
+	}
+	return false
+}
+
+func (x *VirtualIPRange) GetIpv6VirtualPrefix() string {
+	if x != nil {
+		return x.Ipv6VirtualPrefix
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetIpv4To6Prefix() string {
+	if x != nil {
+		return x.Ipv4To6Prefix
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetNpTv6VirtualPrefix() string {
+	if x != nil {
+		return x.NpTv6VirtualPrefix
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetNpTv6RealPrefix() string {
+	if x != nil {
+		return x.NpTv6RealPrefix
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetSendThrough() string {
+	if x != nil {
+		return x.SendThrough
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetSourceSite() string {
+	if x != nil {
+		return x.SourceSite
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetSymmetric() bool {
+	if x != nil {
+		return x.Symmetric
+	}
+	return false
+}
+
+func (x *VirtualIPRange) GetPeerSite() string {
+	if x != nil {
+		return x.PeerSite
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetPeerVirtualNetwork() string {
+	if x != nil {
+		return x.PeerVirtualNetwork
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetPeerRealNetwork() string {
+	if x != nil {
+		return x.PeerRealNetwork
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetMasquerade() bool {
+	if x != nil {
+		return x.Masquerade
+	}
+	return false
+}
+
+func (x *VirtualIPRange) GetMasqueradePorts() string {
+	if x != nil {
+		return x.MasqueradePorts
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetMasqueradePool() []string {
+	if x != nil {
+		return x.MasqueradePool
+	}
+	return nil
+}
+
+func (x *VirtualIPRange) GetMasqueradePooling() string {
+	if x != nil {
+		return x.MasqueradePooling
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetMaxSessions() uint32 {
+	if x != nil {
+		return x.MaxSessions
+	}
+	return 0
+}
+
+func (x *VirtualIPRange) GetTos() uint32 {
+	if x != nil {
+		return x.Tos
+	}
+	return 0
+}
+
+func (x *VirtualIPRange) GetPreserveTos() bool {
+	if x != nil {
+		return x.PreserveTos
+	}
+	return false
+}
+
+func (x *VirtualIPRange) GetFlowLabel() string {
+	if x != nil {
+		return x.FlowLabel
+	}
+	return ""
+}
+
+func (x *VirtualIPRange) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *VirtualIPRange) GetDisabled() bool {
+	if x != nil {
+		return x.Disabled
+	}
+	return false
+}
+
+type NATRule struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Rule identifier
+	RuleId string `protobuf:"bytes,1,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	// Source site filter (optional)
+	SourceSite string `protobuf:"bytes,2,opt,name=source_site,json=sourceSite,proto3" json:"source_site,omitempty"`
+	// Virtual IP destination to match
+	VirtualDestination string `protobuf:"bytes,3,opt,name=virtual_destination,json=virtualDestination,proto3" json:"virtual_destination,omitempty"`
+	// Real destination to translate to
+	RealDestination string `protobuf:"bytes,4,opt,name=real_destination,json=realDestination,proto3" json:"real_destination,omitempty"`
+	// Protocol filtering (tcp, udp, or both)
+	Protocol string `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// Port mapping (optional)
+	PortMapping *PortMapping `protobuf:"bytes,6,opt,name=port_mapping,json=portMapping,proto3" json:"port_mapping,omitempty"`
+	// Added to the destination port of the rule's flows, e.g. 1000 to shift 8000-8100 to
+	// 9000-9100; excludes port_mapping (optional)
+	PortOffset int32 `protobuf:"varint,39,opt,name=port_offset,json=portOffset,proto3" json:"port_offset,omitempty"`
+	// UDP NAT behavior: full-cone, restricted-cone, port-restricted or symmetric (optional)
+	NatBehavior string `protobuf:"bytes,7,opt,name=nat_behavior,json=natBehavior,proto3" json:"nat_behavior,omitempty"`
+	// Backend pool of real destinations: addresses or CIDR pools (optional).
+	// When set, each session is balanced onto one backend instead of real_destination.
+	RealDestinations []string `protobuf:"bytes,8,rep,name=real_destinations,json=realDestinations,proto3" json:"real_destinations,omitempty"`
+	// Pool strategy: round-robin (default), random, least-sessions or consistent-hash
+	Strategy string `protobuf:"bytes,9,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	// Rule priority for the highestPriority match strategy; higher values win
+	Priority int32 `protobuf:"varint,10,opt,name=priority,proto3" json:"priority,omitempty"`
+	// translate (default) or allow applies the rule; bypass exempts matching flows from
+	// translation so they are relayed as normal outbound traffic; deny silently drops
+	// matching flows and reject closes them at once
+	Action string `protobuf:"bytes,11,opt,name=action,proto3" json:"action,omitempty"`
+	// Destination ports the rule applies to, as a port list (optional, all ports when empty)
+	Ports string `protobuf:"bytes,12,opt,name=ports,proto3" json:"ports,omitempty"`
+	// Resolution of real destinations given as hostnames, as the domainStrategy of
+	// freedom: AsIs (default) leaves it to the dialer, UseIP/UseIPv4/UseIPv6/... and
+	// ForceIP/ForceIPv4/ForceIPv6/... resolve through xray DNS when the session is set up
+	DomainStrategy string `protobuf:"bytes,13,opt,name=domain_strategy,json=domainStrategy,proto3" json:"domain_strategy,omitempty"`
+	// Only translate flows from sources in these geoip lists and CIDRs (optional)
+	SourceGeoip []*router.GeoIP `protobuf:"bytes,14,rep,name=source_geoip,json=sourceGeoip,proto3" json:"source_geoip,omitempty"`
+	// Only translate flows to destinations in these geoip lists and CIDRs (optional)
+	DestGeoip []*router.GeoIP `protobuf:"bytes,15,rep,name=dest_geoip,json=destGeoip,proto3" json:"dest_geoip,omitempty"`
+	// Only translate flows to domains of these geosite lists and patterns (optional)
+	DestGeosite []*router.Domain `protobuf:"bytes,16,rep,name=dest_geosite,json=destGeosite,proto3" json:"dest_geosite,omitempty"`
+	// Time windows in which the rule is active (optional, always active when unset)
+	Schedule *Schedule `protobuf:"bytes,17,opt,name=schedule,proto3" json:"schedule,omitempty"`
+	// Only translate flows from these source addresses and CIDRs (optional)
+	SourceAddresses []string `protobuf:"bytes,18,rep,name=source_addresses,json=sourceAddresses,proto3" json:"source_addresses,omitempty"`
+	// Only translate flows from these source ports, as a port list (optional)
+	SourcePorts string `protobuf:"bytes,19,opt,name=source_ports,json=sourcePorts,proto3" json:"source_ports,omitempty"`
+	// Only translate flows of inbound users with these emails (optional)
+	Users []string `protobuf:"bytes,20,rep,name=users,proto3" json:"users,omitempty"`
+	// Only translate flows of inbound users with these levels; selects users without an email (optional)
+	UserLevels []uint32 `protobuf:"varint,21,rep,packed,name=user_levels,json=userLevels,proto3" json:"user_levels,omitempty"`
+	// Application level gateways inspecting the flows of the rule, e.g. ftp (optional)
+	Alg []string `protobuf:"bytes,22,rep,name=alg,proto3" json:"alg,omitempty"`
+	// Keep a few idle TCP connections to each real destination of the rule, dialed ahead and
+	// handed to new flows to save the handshake (optional)
+	ReuseConnections bool `protobuf:"varint,23,opt,name=reuse_connections,json=reuseConnections,proto3" json:"reuse_connections,omitempty"`
+	// Socket options the real destinations of the rule are dialed with through the system
+	// dialer, instead of the transport of the outbound (optional)
+	Sockopt *internet.SocketConfig `protobuf:"bytes,24,opt,name=sockopt,proto3" json:"sockopt,omitempty"`
+	// TOS byte (DSCP and ECN) of the packets of the rule's flows, on Linux (optional)
+	Tos uint32 `protobuf:"varint,25,opt,name=tos,proto3" json:"tos,omitempty"`
+	// Outbound the translated flows are dispatched through instead of being dialed directly,
+	// e.g. a tunnel to the site of the real network (optional)
+	ForwardTag string `protobuf:"bytes,26,opt,name=forward_tag,json=forwardTag,proto3" json:"forward_tag,omitempty"`
+	// Only translate flows to these domains, matched against the destination or the domain sniffed
+	// from the flow when its destination is an address; "*.example.com" matches the subdomains of
+	// example.com (optional)
+	Domains []string `protobuf:"bytes,27,rep,name=domains,proto3" json:"domains,omitempty"`
+	// Bandwidth of each flow of the rule (optional)
+	RateLimit *RateLimit `protobuf:"bytes,28,opt,name=rate_limit,json=rateLimit,proto3" json:"rate_limit,omitempty"`
+	// Maximum concurrent sessions of the rule, new flows over it are refused; unlimited when 0
+	MaxSessions uint32 `protobuf:"varint,29,opt,name=max_sessions,json=maxSessions,proto3" json:"max_sessions,omitempty"`
+	// Eviction class of the rule's sessions: controlPlane, interactive or bulk. When the session
+	// or memory limits are hit, bulk sessions are evicted first and controlPlane sessions last,
+	// the least recently active first within a class; interactive when empty
+	SessionClass string `protobuf:"bytes,40,opt,name=session_class,json=sessionClass,proto3" json:"session_class,omitempty"`
+	// Largest MSS the rule's TCP flows advertise towards the real network, on Linux; derived from
+	// mtu when 0 (optional)
+	TcpMssClamp uint32 `protobuf:"varint,30,opt,name=tcp_mss_clamp,json=tcpMssClamp,proto3" json:"tcp_mss_clamp,omitempty"`
+	// MTU of the path to the real network, e.g. over a tunnel (optional)
+	Mtu uint32 `protobuf:"varint,31,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	// Handling of UDP datagrams over mtu: "fragment" (default), "drop" or "pass"
+	MtuPolicy string `protobuf:"bytes,32,opt,name=mtu_policy,json=mtuPolicy,proto3" json:"mtu_policy,omitempty"`
+	// Dial the real destination with the TOS byte of the client's connection, on Linux; tos
+	// applies when it is unknown
+	PreserveTos bool `protobuf:"varint,33,opt,name=preserve_tos,json=preserveTos,proto3" json:"preserve_tos,omitempty"`
+	// IPv6 flow label of the real-side connections, on Linux: "auto" for a label per flow or
+	// "none" (optional, the system setting by default)
+	FlowLabel string `protobuf:"bytes,34,opt,name=flow_label,json=flowLabel,proto3" json:"flow_label,omitempty"`
+	// Free-form labels the API addresses groups of rules and ranges by, such as "backup-window"
+	Tags []string `protobuf:"bytes,35,rep,name=tags,proto3" json:"tags,omitempty"`
+	// Switched off: flows are matched as if the rule did not exist, while it keeps its sessions
+	// and statistics, until the API enables it
+	Disabled bool `protobuf:"varint,36,opt,name=disabled,proto3" json:"disabled,omitempty"`
+	// Unix time in seconds at which a rule of the rules file or the controller is removed
+	// (optional)
+	ExpiresAt int64 `protobuf:"varint,37,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// Seconds after it is added that a rule of the rules file or the controller is removed; a
+	// changed rule is added again (optional)
+	Ttl           uint32 `protobuf:"varint,38,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NATRule) Reset() {
+	*x = NATRule{}
+	mi := &file_config_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NATRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NATRule) ProtoMessage() {}
+
+func (x *NATRule) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NATRule.ProtoReflect.Descriptor instead.
+func (*NATRule) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *NATRule) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *NATRule) GetSourceSite() string {
+	if x != nil {
+		return x.SourceSite
+	}
+	return ""
+}
+
+func (x *NATRule) GetVirtualDestination() string {
+	if x != nil {
+		return x.VirtualDestination
+	}
+	return ""
+}
+
+func (x *NATRule) GetRealDestination() string {
+	if x != nil {
+		return x.RealDestination
+	}
+	return ""
+}
+
+func (x *NATRule) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *NATRule) GetPortMapping() *PortMapping {
+	if x != nil {
+		return x.PortMapping
+	}
+	return nil
+}
+
+func (x *NATRule) GetPortOffset() int32 {
+	if x != nil {
+		return x.PortOffset
+	}
+	return 0
+}
+
+func (x *NATRule) GetNatBehavior() string {
+	if x != nil {
+		return x.NatBehavior
+	}
+	return ""
+}
+
+func (x *NATRule) GetRealDestinations() []string {
+	if x != nil {
+		return x.RealDestinations
+	}
+	return nil
+}
+
+func (x *NATRule) GetStrategy() string {
+	if x != nil {
+		return x.Strategy
+	}
+	return ""
+}
+
+func (x *NATRule) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *NATRule) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *NATRule) GetPorts() string {
+	if x != nil {
+		return x.Ports
+	}
+	return ""
+}
+
+func (x *NATRule) GetDomainStrategy() string {
+	if x != nil {
+		return x.DomainStrategy
+	}
+	return ""
+}
+
+func (x *NATRule) GetSourceGeoip() []*router.GeoIP {
+	if x != nil {
+		return x.SourceGeoip
+	}
+	return nil
+}
+
+func (x *NATRule) GetDestGeoip() []*router.GeoIP {
+	if x != nil {
+		return x.DestGeoip
+	}
+	return nil
+}
+
+func (x *NATRule) GetDestGeosite() []*router.Domain {
+	if x != nil {
+		return x.DestGeosite
+	}
+	return nil
+}
+
+func (x *NATRule) GetSchedule() *Schedule {
+	if x != nil {
+		return x.Schedule
+	}
+	return nil
+}
+
+func (x *NATRule) GetSourceAddresses() []string {
+	if x != nil {
+		return x.SourceAddresses
+	}
+	return nil
+}
+
+func (x *NATRule) GetSourcePorts() string {
+	if x != nil {
+		return x.SourcePorts
+	}
+	return ""
+}
+
+func (x *NATRule) GetUsers() []string {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *NATRule) GetUserLevels() []uint32 {
+	if x != nil {
+		return x.UserLevels
+	}
+	return nil
+}
+
+func (x *NATRule) GetAlg() []string {
+	if x != nil {
+		return x.Alg
+	}
+	return nil
+}
+
+func (x *NATRule) GetReuseConnections() bool {
+	if x != nil {
+		return x.ReuseConnections
+	}
+	return false
+}
+
+func (x *NATRule) GetSockopt() *internet.SocketConfig {
+	if x != nil {
+		return x.Sockopt
+	}
+	return nil
+}
+
+func (x *NATRule) GetTos() uint32 {
+	if x != nil {
+		return x.Tos
+	}
+	return 0
+}
+
+func (x *NATRule) GetForwardTag() string {
+	if x != nil {
+		return x.ForwardTag
+	}
+	return ""
 }
 
-func (x *VirtualIPRange) Reset() {
-	*x = VirtualIPRange{}
-	mi := &file_config_proto_msgTypes[1]
-	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-	ms.StoreMessageInfo(mi)
+func (x *NATRule) GetDomains() []string {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
 }
 
-func (x *VirtualIPRange) String() string {
-	return protoimpl.X.MessageStringOf(x)
+func (x *NATRule) GetRateLimit() *RateLimit {
+	if x != nil {
+		return x.RateLimit
+	}
+	return nil
 }
 
-func (*VirtualIPRange) ProtoMessage() {}
+func (x *NATRule) GetMaxSessions() uint32 {
+	if x != nil {
+		return x.MaxSessions
+	}
+	return 0
+}
 
-func (x *VirtualIPRange) ProtoReflect() protoreflect.Message {
-	mi := &file_config_proto_msgTypes[1]
+func (x *NATRule) GetSessionClass() string {
 	if x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
+		return x.SessionClass
 	}
-	return mi.MessageOf(x)
+	return ""
 }
 
-// Deprecated: Use VirtualIPRange.ProtoReflect.Descriptor instead.
-func (*VirtualIPRange) Descriptor() ([]byte, []int) {
-	return file_config_proto_rawDescGZIP(), []int{1}
+func (x *NATRule) GetTcpMssClamp() uint32 {
+	if x != nil {
+		return x.TcpMssClamp
+	}
+	return 0
 }
 
-func (x *VirtualIPRange) GetVirtualNetwork() string {
+func (x *NATRule) GetMtu() uint32 {
 	if x != nil {
-		return x.VirtualNetwork
+		return x.Mtu
 	}
-	return ""
+	return 0
 }
 
-func (x *VirtualIPRange) GetRealNetwork() string {
+func (x *NATRule) GetMtuPolicy() string {
 	if x != nil {
-		return x.RealNetwork
+		return x.MtuPolicy
 	}
 	return ""
 }
 
-func (x *VirtualIPRange) GetIpv6Enabled() bool {
+func (x *NATRule) GetPreserveTos() bool {
 	if x != nil {
-		return x.Ipv6Enabled
+		return x.PreserveTos
 	}
 	return false
 }
 
-func (x *VirtualIPRange) GetIpv6VirtualPrefix() string {
+func (x *NATRule) GetFlowLabel() string {
 	if x != nil {
-		return x.Ipv6VirtualPrefix
+		return x.FlowLabel
 	}
 	return ""
 }
 
-type NATRule struct {
+func (x *NATRule) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *NATRule) GetDisabled() bool {
+	if x != nil {
+		return x.Disabled
+	}
+	return false
+}
+
+func (x *NATRule) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+func (x *NATRule) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+type RateLimit struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// Rule identifier
-	RuleId string `protobuf:"bytes,1,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
-	// Source site filter (optional)
-	SourceSite string `protobuf:"bytes,2,opt,name=source_site,json=sourceSite,proto3" json:"source_site,omitempty"`
-	// Virtual IP destination to match
-	VirtualDestination string `protobuf:"bytes,3,opt,name=virtual_destination,json=virtualDestination,proto3" json:"virtual_destination,omitempty"`
-	// Real destination to translate to
-	RealDestination string `protobuf:"bytes,4,opt,name=real_destination,json=realDestination,proto3" json:"real_destination,omitempty"`
-	// Protocol filtering (tcp, udp, or both)
-	Protocol string `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`
-	// Port mapping (optional)
-	PortMapping   *PortMapping `protobuf:"bytes,6,opt,name=port_mapping,json=portMapping,proto3" json:"port_mapping,omitempty"`
+	// Bytes per second from the client to the real destination, unlimited when 0
+	UplinkBytesPerSecond uint64 `protobuf:"varint,1,opt,name=uplink_bytes_per_second,json=uplinkBytesPerSecond,proto3" json:"uplink_bytes_per_second,omitempty"`
+	// Bytes per second from the real destination to the client, unlimited when 0
+	DownlinkBytesPerSecond uint64 `protobuf:"varint,2,opt,name=downlink_bytes_per_second,json=downlinkBytesPerSecond,proto3" json:"downlink_bytes_per_second,omitempty"`
+	// Bytes a direction may send at once before the rate applies, one second of its rate when 0
+	BurstBytes    uint64 `protobuf:"varint,3,opt,name=burst_bytes,json=burstBytes,proto3" json:"burst_bytes,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *NATRule) Reset() {
-	*x = NATRule{}
-	mi := &file_config_proto_msgTypes[2]
+func (x *RateLimit) Reset() {
+	*x = RateLimit{}
+	mi := &file_config_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *NATRule) String() string {
+func (x *RateLimit) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*NATRule) ProtoMessage() {}
+func (*RateLimit) ProtoMessage() {}
 
-func (x *NATRule) ProtoReflect() protoreflect.Message {
-	mi := &file_config_proto_msgTypes[2]
+func (x *RateLimit) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -252,66 +2322,123 @@ func (x *NATRule) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use NATRule.ProtoReflect.Descriptor instead.
-func (*NATRule) Descriptor() ([]byte, []int) {
-	return file_config_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use RateLimit.ProtoReflect.Descriptor instead.
+func (*RateLimit) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{20}
 }
 
-func (x *NATRule) GetRuleId() string {
+func (x *RateLimit) GetUplinkBytesPerSecond() uint64 {
 	if x != nil {
-		return x.RuleId
+		return x.UplinkBytesPerSecond
 	}
-	return ""
+	return 0
 }
 
-func (x *NATRule) GetSourceSite() string {
+func (x *RateLimit) GetDownlinkBytesPerSecond() uint64 {
 	if x != nil {
-		return x.SourceSite
+		return x.DownlinkBytesPerSecond
 	}
-	return ""
+	return 0
 }
 
-func (x *NATRule) GetVirtualDestination() string {
+func (x *RateLimit) GetBurstBytes() uint64 {
 	if x != nil {
-		return x.VirtualDestination
+		return x.BurstBytes
 	}
-	return ""
+	return 0
 }
 
-func (x *NATRule) GetRealDestination() string {
+type Schedule struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Days of the week the window opens on: mon, tue, wed, thu, fri, sat, sun
+	// (every day when empty)
+	Days []string `protobuf:"bytes,1,rep,name=days,proto3" json:"days,omitempty"`
+	// Window start and end as "HH:MM"; an end before the start closes the window
+	// on the next day, and an empty or equal pair covers the whole day
+	Start string `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End   string `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	// IANA time zone of the window, e.g. "Europe/Berlin" (local time when empty)
+	Timezone      string `protobuf:"bytes,4,opt,name=timezone,proto3" json:"timezone,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Schedule) Reset() {
+	*x = Schedule{}
+	mi := &file_config_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Schedule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Schedule) ProtoMessage() {}
+
+func (x *Schedule) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[21]
 	if x != nil {
-		return x.RealDestination
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Schedule.ProtoReflect.Descriptor instead.
+func (*Schedule) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *Schedule) GetDays() []string {
+	if x != nil {
+		return x.Days
+	}
+	return nil
+}
+
+func (x *Schedule) GetStart() string {
+	if x != nil {
+		return x.Start
 	}
 	return ""
 }
 
-func (x *NATRule) GetProtocol() string {
+func (x *Schedule) GetEnd() string {
 	if x != nil {
-		return x.Protocol
+		return x.End
 	}
 	return ""
 }
 
-func (x *NATRule) GetPortMapping() *PortMapping {
+func (x *Schedule) GetTimezone() string {
 	if x != nil {
-		return x.PortMapping
+		return x.Timezone
 	}
-	return nil
+	return ""
 }
 
 type PortMapping struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// Original port or range
+	// Original ports: a comma-separated list of ports and ranges
+	// (e.g. "80,8000-8100"), or "any" for every port; empty maps no port
 	OriginalPort string `protobuf:"bytes,1,opt,name=original_port,json=originalPort,proto3" json:"original_port,omitempty"`
-	// Translated port or range
+	// Translated ports: the same number of ports as original_port (offsets are
+	// preserved), a single port, or a list used round-robin when original_port is "any"
 	TranslatedPort string `protobuf:"bytes,2,opt,name=translated_port,json=translatedPort,proto3" json:"translated_port,omitempty"`
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	// Translated ports of the flows of a protocol, "tcp" or "udp", in place of
+	// translated_port (optional)
+	ProtocolPorts map[string]string `protobuf:"bytes,3,rep,name=protocol_ports,json=protocolPorts,proto3" json:"protocol_ports,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PortMapping) Reset() {
 	*x = PortMapping{}
-	mi := &file_config_proto_msgTypes[3]
+	mi := &file_config_proto_msgTypes[22]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -323,7 +2450,7 @@ func (x *PortMapping) String() string {
 func (*PortMapping) ProtoMessage() {}
 
 func (x *PortMapping) ProtoReflect() protoreflect.Message {
-	mi := &file_config_proto_msgTypes[3]
+	mi := &file_config_proto_msgTypes[22]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -336,7 +2463,7 @@ func (x *PortMapping) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PortMapping.ProtoReflect.Descriptor instead.
 func (*PortMapping) Descriptor() ([]byte, []int) {
-	return file_config_proto_rawDescGZIP(), []int{3}
+	return file_config_proto_rawDescGZIP(), []int{22}
 }
 
 func (x *PortMapping) GetOriginalPort() string {
@@ -353,6 +2480,13 @@ func (x *PortMapping) GetTranslatedPort() string {
 	return ""
 }
 
+func (x *PortMapping) GetProtocolPorts() map[string]string {
+	if x != nil {
+		return x.ProtocolPorts
+	}
+	return nil
+}
+
 type SessionTimeout struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// TCP connection timeout in seconds
@@ -361,13 +2495,22 @@ type SessionTimeout struct {
 	UdpTimeout uint32 `protobuf:"varint,2,opt,name=udp_timeout,json=udpTimeout,proto3" json:"udp_timeout,omitempty"`
 	// Idle session cleanup interval in seconds
 	CleanupInterval uint32 `protobuf:"varint,3,opt,name=cleanup_interval,json=cleanupInterval,proto3" json:"cleanup_interval,omitempty"`
-	unknownFields   protoimpl.UnknownFields
-	sizeCache       protoimpl.SizeCache
+	// ICMP query session timeout in seconds
+	IcmpTimeout uint32 `protobuf:"varint,4,opt,name=icmp_timeout,json=icmpTimeout,proto3" json:"icmp_timeout,omitempty"`
+	// Timeout of established TCP connections in seconds, defaults to tcp_timeout
+	EstablishedTcpTimeout uint32 `protobuf:"varint,5,opt,name=established_tcp_timeout,json=establishedTcpTimeout,proto3" json:"established_tcp_timeout,omitempty"`
+	// Timeout of TCP connections that are being opened or closed in seconds,
+	// defaults to tcp_timeout
+	TransitoryTcpTimeout uint32 `protobuf:"varint,6,opt,name=transitory_tcp_timeout,json=transitoryTcpTimeout,proto3" json:"transitory_tcp_timeout,omitempty"`
+	// Timeout of SCTP associations in seconds, defaults to tcp_timeout
+	SctpTimeout   uint32 `protobuf:"varint,7,opt,name=sctp_timeout,json=sctpTimeout,proto3" json:"sctp_timeout,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *SessionTimeout) Reset() {
 	*x = SessionTimeout{}
-	mi := &file_config_proto_msgTypes[4]
+	mi := &file_config_proto_msgTypes[23]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -379,7 +2522,7 @@ func (x *SessionTimeout) String() string {
 func (*SessionTimeout) ProtoMessage() {}
 
 func (x *SessionTimeout) ProtoReflect() protoreflect.Message {
-	mi := &file_config_proto_msgTypes[4]
+	mi := &file_config_proto_msgTypes[23]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -392,7 +2535,7 @@ func (x *SessionTimeout) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SessionTimeout.ProtoReflect.Descriptor instead.
 func (*SessionTimeout) Descriptor() ([]byte, []int) {
-	return file_config_proto_rawDescGZIP(), []int{4}
+	return file_config_proto_rawDescGZIP(), []int{23}
 }
 
 func (x *SessionTimeout) GetTcpTimeout() uint32 {
@@ -416,21 +2559,59 @@ func (x *SessionTimeout) GetCleanupInterval() uint32 {
 	return 0
 }
 
+func (x *SessionTimeout) GetIcmpTimeout() uint32 {
+	if x != nil {
+		return x.IcmpTimeout
+	}
+	return 0
+}
+
+func (x *SessionTimeout) GetEstablishedTcpTimeout() uint32 {
+	if x != nil {
+		return x.EstablishedTcpTimeout
+	}
+	return 0
+}
+
+func (x *SessionTimeout) GetTransitoryTcpTimeout() uint32 {
+	if x != nil {
+		return x.TransitoryTcpTimeout
+	}
+	return 0
+}
+
+func (x *SessionTimeout) GetSctpTimeout() uint32 {
+	if x != nil {
+		return x.SctpTimeout
+	}
+	return 0
+}
+
 type ResourceLimits struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Maximum concurrent sessions
 	MaxSessions uint32 `protobuf:"varint,1,opt,name=max_sessions,json=maxSessions,proto3" json:"max_sessions,omitempty"`
-	// Maximum memory usage in MB
+	// Maximum memory usage in MB of the sessions, measured from their size and ALG state
 	MaxMemoryMb uint32 `protobuf:"varint,2,opt,name=max_memory_mb,json=maxMemoryMb,proto3" json:"max_memory_mb,omitempty"`
-	// Session table cleanup threshold
+	// Share of max_sessions or max_memory_mb above which the sessions are cleaned up aggressively
+	// before a new session is created: expired sessions first, then idle ones with their timeouts
+	// shortened, UDP first. 0.8 when 0
 	CleanupThreshold float32 `protobuf:"fixed32,3,opt,name=cleanup_threshold,json=cleanupThreshold,proto3" json:"cleanup_threshold,omitempty"`
-	unknownFields    protoimpl.UnknownFields
-	sizeCache        protoimpl.SizeCache
+	// With the session table full, make room by dropping sessions of the source holding the most
+	// sessions, and refuse new flows from it, instead of dropping the least recently used session
+	DropNoisiestSources bool `protobuf:"varint,4,opt,name=drop_noisiest_sources,json=dropNoisiestSources,proto3" json:"drop_noisiest_sources,omitempty"`
+	// New sessions the gateway creates per second, unlimited when 0. Flows over the rate are
+	// refused, protecting the gateway during SYN floods or scans.
+	NewSessionsPerSecond uint32 `protobuf:"varint,5,opt,name=new_sessions_per_second,json=newSessionsPerSecond,proto3" json:"new_sessions_per_second,omitempty"`
+	// New sessions created at once before the rate applies, new_sessions_per_second when 0
+	NewSessionBurst uint32 `protobuf:"varint,6,opt,name=new_session_burst,json=newSessionBurst,proto3" json:"new_session_burst,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *ResourceLimits) Reset() {
 	*x = ResourceLimits{}
-	mi := &file_config_proto_msgTypes[5]
+	mi := &file_config_proto_msgTypes[24]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -442,7 +2623,7 @@ func (x *ResourceLimits) String() string {
 func (*ResourceLimits) ProtoMessage() {}
 
 func (x *ResourceLimits) ProtoReflect() protoreflect.Message {
-	mi := &file_config_proto_msgTypes[5]
+	mi := &file_config_proto_msgTypes[24]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -455,7 +2636,7 @@ func (x *ResourceLimits) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ResourceLimits.ProtoReflect.Descriptor instead.
 func (*ResourceLimits) Descriptor() ([]byte, []int) {
-	return file_config_proto_rawDescGZIP(), []int{5}
+	return file_config_proto_rawDescGZIP(), []int{24}
 }
 
 func (x *ResourceLimits) GetMaxSessions() uint32 {
@@ -479,11 +2660,96 @@ func (x *ResourceLimits) GetCleanupThreshold() float32 {
 	return 0
 }
 
+func (x *ResourceLimits) GetDropNoisiestSources() bool {
+	if x != nil {
+		return x.DropNoisiestSources
+	}
+	return false
+}
+
+func (x *ResourceLimits) GetNewSessionsPerSecond() uint32 {
+	if x != nil {
+		return x.NewSessionsPerSecond
+	}
+	return 0
+}
+
+func (x *ResourceLimits) GetNewSessionBurst() uint32 {
+	if x != nil {
+		return x.NewSessionBurst
+	}
+	return 0
+}
+
+type PerSourceLimits struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Maximum concurrent sessions of one virtual source address, unlimited when 0
+	MaxSessions uint32 `protobuf:"varint,1,opt,name=max_sessions,json=maxSessions,proto3" json:"max_sessions,omitempty"`
+	// New sessions a virtual source address may create per second, unlimited when 0
+	NewSessionsPerSecond uint32 `protobuf:"varint,2,opt,name=new_sessions_per_second,json=newSessionsPerSecond,proto3" json:"new_sessions_per_second,omitempty"`
+	// New sessions a virtual source address may create at once before the rate applies,
+	// new_sessions_per_second when 0
+	Burst         uint32 `protobuf:"varint,3,opt,name=burst,proto3" json:"burst,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PerSourceLimits) Reset() {
+	*x = PerSourceLimits{}
+	mi := &file_config_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PerSourceLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PerSourceLimits) ProtoMessage() {}
+
+func (x *PerSourceLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PerSourceLimits.ProtoReflect.Descriptor instead.
+func (*PerSourceLimits) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *PerSourceLimits) GetMaxSessions() uint32 {
+	if x != nil {
+		return x.MaxSessions
+	}
+	return 0
+}
+
+func (x *PerSourceLimits) GetNewSessionsPerSecond() uint32 {
+	if x != nil {
+		return x.NewSessionsPerSecond
+	}
+	return 0
+}
+
+func (x *PerSourceLimits) GetBurst() uint32 {
+	if x != nil {
+		return x.Burst
+	}
+	return 0
+}
+
 var File_config_proto protoreflect.FileDescriptor
 
 const file_config_proto_rawDesc = "" +
 	"\n" +
-	"\fconfig.proto\x12\x0exray.proxy.nat\"\x98\x03\n" +
+	"\fconfig.proto\x12\x0exray.proxy.nat\x1a\x17app/router/config.proto\x1a\x1ftransport/internet/config.proto\"\xac\x0e\n" +
 	"\x06Config\x12\x17\n" +
 	"\asite_id\x18\x01 \x01(\tR\x06siteId\x12\x1d\n" +
 	"\n" +
@@ -496,12 +2762,167 @@ const file_config_proto_rawDesc = "" +
 	"\x05rules\x18\x06 \x03(\v2\x17.xray.proxy.nat.NATRuleR\x05rules\x12G\n" +
 	"\x0fsession_timeout\x18\a \x01(\v2\x1e.xray.proxy.nat.SessionTimeoutR\x0esessionTimeout\x126\n" +
 	"\x06limits\x18\b \x01(\v2\x1e.xray.proxy.nat.ResourceLimitsR\x06limits\x12!\n" +
-	"\fnat64_prefix\x18\t \x01(\tR\vnat64Prefix\"\xaf\x01\n" +
+	"\fnat64_prefix\x18\t \x01(\tR\vnat64Prefix\x12W\n" +
+	"\x15port_block_allocation\x18\n" +
+	" \x01(\v2#.xray.proxy.nat.PortBlockAllocationR\x13portBlockAllocation\x12%\n" +
+	"\x0eenable_hairpin\x18\v \x01(\bR\renableHairpin\x12F\n" +
+	"\x0fstatic_mappings\x18\f \x03(\v2\x1d.xray.proxy.nat.StaticMappingR\x0estaticMappings\x12;\n" +
+	"\vsession_log\x18\r \x01(\v2\x1a.xray.proxy.nat.SessionLogR\n" +
+	"sessionLog\x12;\n" +
+	"\vflow_export\x18\x0e \x01(\v2\x1a.xray.proxy.nat.FlowExportR\n" +
+	"flowExport\x12\x1d\n" +
+	"\n" +
+	"rules_file\x18\x0f \x01(\tR\trulesFile\x12%\n" +
+	"\x0ematch_strategy\x18\x10 \x01(\tR\rmatchStrategy\x12S\n" +
+	"\x13session_persistence\x18\x11 \x01(\v2\".xray.proxy.nat.SessionPersistenceR\x12sessionPersistence\x12=\n" +
+	"\vreplication\x18\x12 \x01(\v2\x1b.xray.proxy.nat.ReplicationR\vreplication\x121\n" +
+	"\acluster\x18\x13 \x01(\v2\x17.xray.proxy.nat.ClusterR\acluster\x12>\n" +
+	"\fport_control\x18\x14 \x01(\v2\x1b.xray.proxy.nat.PortControlR\vportControl\x12(\n" +
+	"\x04stun\x18\x15 \x01(\v2\x14.xray.proxy.nat.StunR\x04stun\x12M\n" +
+	"\x11rule_distribution\x18\x16 \x01(\v2 .xray.proxy.nat.RuleDistributionR\x10ruleDistribution\x12>\n" +
+	"\faddress_pool\x18\x17 \x01(\v2\x1b.xray.proxy.nat.AddressPoolR\vaddressPool\x12\x19\n" +
+	"\bfake_dns\x18\x18 \x01(\bR\afakeDns\x12K\n" +
+	"\x11per_source_limits\x18\x19 \x01(\v2\x1f.xray.proxy.nat.PerSourceLimitsR\x0fperSourceLimits\x127\n" +
+	"\taudit_log\x18\x1a \x01(\v2\x1a.xray.proxy.nat.SessionLogR\bauditLog\x12G\n" +
+	"\x0fshared_sessions\x18\x1b \x01(\v2\x1e.xray.proxy.nat.SharedSessionsR\x0esharedSessions\x12D\n" +
+	"\x0ekernel_offload\x18\x1c \x01(\v2\x1d.xray.proxy.nat.KernelOffloadR\rkernelOffload\x12%\n" +
+	"\x0edisable_splice\x18\x1d \x01(\bR\rdisableSplice\x12!\n" +
+	"\fudp_batching\x18\x1e \x01(\bR\vudpBatching\x12G\n" +
+	"\x0fsession_webhook\x18\x1f \x01(\v2\x1e.xray.proxy.nat.SessionWebhookR\x0esessionWebhook\x12'\n" +
+	"\x0fcompliance_mode\x18  \x01(\tR\x0ecomplianceMode\x12(\n" +
+	"\x04clat\x18! \x01(\v2\x14.xray.proxy.nat.ClatR\x04clat\x124\n" +
+	"\tmap_rules\x18\" \x03(\v2\x17.xray.proxy.nat.MapRuleR\bmapRules\"\xab\x01\n" +
+	"\aMapRule\x12\x17\n" +
+	"\arule_id\x18\x01 \x01(\tR\x06ruleId\x12\x1f\n" +
+	"\vipv4_prefix\x18\x02 \x01(\tR\n" +
+	"ipv4Prefix\x12\x1f\n" +
+	"\vipv6_prefix\x18\x03 \x01(\tR\n" +
+	"ipv6Prefix\x12$\n" +
+	"\x0eea_bits_length\x18\x04 \x01(\rR\feaBitsLength\x12\x1f\n" +
+	"\vpsid_offset\x18\x05 \x01(\rR\n" +
+	"psidOffset\"e\n" +
+	"\vAddressPool\x12\x18\n" +
+	"\anetwork\x18\x01 \x01(\tR\anetwork\x12\x1d\n" +
+	"\n" +
+	"lease_time\x18\x02 \x01(\rR\tleaseTime\x12\x1d\n" +
+	"\n" +
+	"lease_file\x18\x03 \x01(\tR\tleaseFile\"b\n" +
+	"\x10RuleDistribution\x12\x16\n" +
+	"\x06listen\x18\x01 \x01(\tR\x06listen\x12\x1e\n" +
+	"\n" +
+	"controller\x18\x02 \x01(\tR\n" +
+	"controller\x12\x16\n" +
+	"\x06secret\x18\x03 \x01(\tR\x06secret\"h\n" +
+	"\x04Clat\x12\x1f\n" +
+	"\vplat_prefix\x18\x01 \x01(\tR\n" +
+	"platPrefix\x12#\n" +
+	"\rsource_prefix\x18\x02 \x01(\tR\fsourcePrefix\x12\x1a\n" +
+	"\bnetworks\x18\x03 \x03(\tR\bnetworks\"\x9b\x01\n" +
+	"\x04Stun\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12+\n" +
+	"\x11alternate_address\x18\x02 \x01(\tR\x10alternateAddress\x12!\n" +
+	"\fnat_behavior\x18\x03 \x01(\tR\vnatBehavior\x12)\n" +
+	"\x10external_address\x18\x04 \x01(\tR\x0fexternalAddress\"\x8a\x02\n" +
+	"\vPortControl\x12\x16\n" +
+	"\x06listen\x18\x01 \x01(\tR\x06listen\x12'\n" +
+	"\x0fmapping_address\x18\x02 \x01(\tR\x0emappingAddress\x12)\n" +
+	"\x10external_address\x18\x03 \x01(\tR\x0fexternalAddress\x12\x14\n" +
+	"\x05ports\x18\x04 \x01(\tR\x05ports\x12!\n" +
+	"\fmax_lifetime\x18\x05 \x01(\rR\vmaxLifetime\x125\n" +
+	"\x17max_mappings_per_client\x18\x06 \x01(\rR\x14maxMappingsPerClient\x12\x1f\n" +
+	"\vupnp_listen\x18\a \x01(\tR\n" +
+	"upnpListen\"q\n" +
+	"\aCluster\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x121\n" +
+	"\x05nodes\x18\x02 \x03(\v2\x1b.xray.proxy.nat.ClusterNodeR\x05nodes\x12\x1a\n" +
+	"\breplicas\x18\x03 \x01(\rR\breplicas\"@\n" +
+	"\vClusterNode\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12!\n" +
+	"\foutbound_tag\x18\x02 \x01(\tR\voutboundTag\"v\n" +
+	"\vReplication\x12\x16\n" +
+	"\x06listen\x18\x01 \x01(\tR\x06listen\x12\x12\n" +
+	"\x04peer\x18\x02 \x01(\tR\x04peer\x12\x16\n" +
+	"\x06secret\x18\x03 \x01(\tR\x06secret\x12#\n" +
+	"\rsync_interval\x18\x04 \x01(\rR\fsyncInterval\"\xad\x01\n" +
+	"\x12SessionPersistence\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12#\n" +
+	"\rredis_address\x18\x02 \x01(\tR\fredisAddress\x12%\n" +
+	"\x0eredis_password\x18\x03 \x01(\tR\rredisPassword\x12\x1b\n" +
+	"\tredis_key\x18\x04 \x01(\tR\bredisKey\x12\x1a\n" +
+	"\binterval\x18\x05 \x01(\rR\binterval\"T\n" +
+	"\rKernelOffload\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\x19\n" +
+	"\bnft_path\x18\x02 \x01(\tR\anftPath\x12\x12\n" +
+	"\x04mark\x18\x03 \x01(\rR\x04mark\"\xa0\x01\n" +
+	"\x0eSharedSessions\x12#\n" +
+	"\rredis_address\x18\x01 \x01(\tR\fredisAddress\x12%\n" +
+	"\x0eredis_password\x18\x02 \x01(\tR\rredisPassword\x12\x1d\n" +
+	"\n" +
+	"key_prefix\x18\x03 \x01(\tR\tkeyPrefix\x12#\n" +
+	"\rsync_interval\x18\x04 \x01(\rR\fsyncInterval\"\xc1\x01\n" +
+	"\n" +
+	"FlowExport\x12\x1c\n" +
+	"\tcollector\x18\x01 \x01(\tR\tcollector\x122\n" +
+	"\x15observation_domain_id\x18\x02 \x01(\rR\x13observationDomainId\x12:\n" +
+	"\x19template_refresh_interval\x18\x03 \x01(\rR\x17templateRefreshInterval\x12%\n" +
+	"\x0eflush_interval\x18\x04 \x01(\rR\rflushInterval\"\x9c\x01\n" +
+	"\n" +
+	"SessionLog\x12\x12\n" +
+	"\x04sink\x18\x01 \x01(\tR\x04sink\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x1e\n" +
+	"\vmax_size_mb\x18\x03 \x01(\rR\tmaxSizeMb\x12\x1f\n" +
+	"\vmax_backups\x18\x04 \x01(\rR\n" +
+	"maxBackups\x12%\n" +
+	"\x0esyslog_address\x18\x05 \x01(\tR\rsyslogAddress\"\xaf\x01\n" +
+	"\x0eSessionWebhook\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x1d\n" +
+	"\n" +
+	"batch_size\x18\x02 \x01(\rR\tbatchSize\x12%\n" +
+	"\x0eflush_interval\x18\x03 \x01(\rR\rflushInterval\x12\x1f\n" +
+	"\vmax_retries\x18\x04 \x01(\rR\n" +
+	"maxRetries\x12$\n" +
+	"\rauthorization\x18\x05 \x01(\tR\rauthorization\"[\n" +
+	"\rStaticMapping\x12'\n" +
+	"\x0fvirtual_address\x18\x01 \x01(\tR\x0evirtualAddress\x12!\n" +
+	"\freal_address\x18\x02 \x01(\tR\vrealAddress\"\xbb\x02\n" +
+	"\x13PortBlockAllocation\x12%\n" +
+	"\x0epublic_address\x18\x01 \x01(\tR\rpublicAddress\x12(\n" +
+	"\x10port_range_start\x18\x02 \x01(\rR\x0eportRangeStart\x12$\n" +
+	"\x0eport_range_end\x18\x03 \x01(\rR\fportRangeEnd\x12\x1d\n" +
+	"\n" +
+	"block_size\x18\x04 \x01(\rR\tblockSize\x129\n" +
+	"\x19max_blocks_per_subscriber\x18\x05 \x01(\rR\x16maxBlocksPerSubscriber\x12$\n" +
+	"\rdeterministic\x18\x06 \x01(\bR\rdeterministic\x12-\n" +
+	"\x12subscriber_network\x18\a \x01(\tR\x11subscriberNetwork\"\xde\x06\n" +
 	"\x0eVirtualIPRange\x12'\n" +
 	"\x0fvirtual_network\x18\x01 \x01(\tR\x0evirtualNetwork\x12!\n" +
 	"\freal_network\x18\x02 \x01(\tR\vrealNetwork\x12!\n" +
 	"\fipv6_enabled\x18\x03 \x01(\bR\vipv6Enabled\x12.\n" +
-	"\x13ipv6_virtual_prefix\x18\x04 \x01(\tR\x11ipv6VirtualPrefix\"\xfb\x01\n" +
+	"\x13ipv6_virtual_prefix\x18\x04 \x01(\tR\x11ipv6VirtualPrefix\x12&\n" +
+	"\x0fipv4_to6_prefix\x18\x05 \x01(\tR\ripv4To6Prefix\x121\n" +
+	"\x15np_tv6_virtual_prefix\x18\x06 \x01(\tR\x12npTv6VirtualPrefix\x12+\n" +
+	"\x12np_tv6_real_prefix\x18\a \x01(\tR\x0fnpTv6RealPrefix\x12!\n" +
+	"\fsend_through\x18\b \x01(\tR\vsendThrough\x12\x1f\n" +
+	"\vsource_site\x18\t \x01(\tR\n" +
+	"sourceSite\x12\x1c\n" +
+	"\tsymmetric\x18\n" +
+	" \x01(\bR\tsymmetric\x12\x1b\n" +
+	"\tpeer_site\x18\v \x01(\tR\bpeerSite\x120\n" +
+	"\x14peer_virtual_network\x18\f \x01(\tR\x12peerVirtualNetwork\x12*\n" +
+	"\x11peer_real_network\x18\r \x01(\tR\x0fpeerRealNetwork\x12\x1e\n" +
+	"\n" +
+	"masquerade\x18\x0e \x01(\bR\n" +
+	"masquerade\x12)\n" +
+	"\x10masquerade_ports\x18\x0f \x01(\tR\x0fmasqueradePorts\x12'\n" +
+	"\x0fmasquerade_pool\x18\x10 \x03(\tR\x0emasqueradePool\x12-\n" +
+	"\x12masquerade_pooling\x18\x11 \x01(\tR\x11masqueradePooling\x12!\n" +
+	"\fmax_sessions\x18\x12 \x01(\rR\vmaxSessions\x12\x10\n" +
+	"\x03tos\x18\x13 \x01(\rR\x03tos\x12!\n" +
+	"\fpreserve_tos\x18\x14 \x01(\bR\vpreserveTos\x12\x1d\n" +
+	"\n" +
+	"flow_label\x18\x15 \x01(\tR\tflowLabel\x12\x12\n" +
+	"\x04tags\x18\x16 \x03(\tR\x04tags\x12\x1a\n" +
+	"\bdisabled\x18\x17 \x01(\bR\bdisabled\"\xab\v\n" +
 	"\aNATRule\x12\x17\n" +
 	"\arule_id\x18\x01 \x01(\tR\x06ruleId\x12\x1f\n" +
 	"\vsource_site\x18\x02 \x01(\tR\n" +
@@ -509,20 +2930,88 @@ const file_config_proto_rawDesc = "" +
 	"\x13virtual_destination\x18\x03 \x01(\tR\x12virtualDestination\x12)\n" +
 	"\x10real_destination\x18\x04 \x01(\tR\x0frealDestination\x12\x1a\n" +
 	"\bprotocol\x18\x05 \x01(\tR\bprotocol\x12>\n" +
-	"\fport_mapping\x18\x06 \x01(\v2\x1b.xray.proxy.nat.PortMappingR\vportMapping\"[\n" +
+	"\fport_mapping\x18\x06 \x01(\v2\x1b.xray.proxy.nat.PortMappingR\vportMapping\x12\x1f\n" +
+	"\vport_offset\x18' \x01(\x05R\n" +
+	"portOffset\x12!\n" +
+	"\fnat_behavior\x18\a \x01(\tR\vnatBehavior\x12+\n" +
+	"\x11real_destinations\x18\b \x03(\tR\x10realDestinations\x12\x1a\n" +
+	"\bstrategy\x18\t \x01(\tR\bstrategy\x12\x1a\n" +
+	"\bpriority\x18\n" +
+	" \x01(\x05R\bpriority\x12\x16\n" +
+	"\x06action\x18\v \x01(\tR\x06action\x12\x14\n" +
+	"\x05ports\x18\f \x01(\tR\x05ports\x12'\n" +
+	"\x0fdomain_strategy\x18\r \x01(\tR\x0edomainStrategy\x129\n" +
+	"\fsource_geoip\x18\x0e \x03(\v2\x16.xray.app.router.GeoIPR\vsourceGeoip\x125\n" +
+	"\n" +
+	"dest_geoip\x18\x0f \x03(\v2\x16.xray.app.router.GeoIPR\tdestGeoip\x12:\n" +
+	"\fdest_geosite\x18\x10 \x03(\v2\x17.xray.app.router.DomainR\vdestGeosite\x124\n" +
+	"\bschedule\x18\x11 \x01(\v2\x18.xray.proxy.nat.ScheduleR\bschedule\x12)\n" +
+	"\x10source_addresses\x18\x12 \x03(\tR\x0fsourceAddresses\x12!\n" +
+	"\fsource_ports\x18\x13 \x01(\tR\vsourcePorts\x12\x14\n" +
+	"\x05users\x18\x14 \x03(\tR\x05users\x12\x1f\n" +
+	"\vuser_levels\x18\x15 \x03(\rR\n" +
+	"userLevels\x12\x10\n" +
+	"\x03alg\x18\x16 \x03(\tR\x03alg\x12+\n" +
+	"\x11reuse_connections\x18\x17 \x01(\bR\x10reuseConnections\x12?\n" +
+	"\asockopt\x18\x18 \x01(\v2%.xray.transport.internet.SocketConfigR\asockopt\x12\x10\n" +
+	"\x03tos\x18\x19 \x01(\rR\x03tos\x12\x1f\n" +
+	"\vforward_tag\x18\x1a \x01(\tR\n" +
+	"forwardTag\x12\x18\n" +
+	"\adomains\x18\x1b \x03(\tR\adomains\x128\n" +
+	"\n" +
+	"rate_limit\x18\x1c \x01(\v2\x19.xray.proxy.nat.RateLimitR\trateLimit\x12!\n" +
+	"\fmax_sessions\x18\x1d \x01(\rR\vmaxSessions\x12#\n" +
+	"\rsession_class\x18( \x01(\tR\fsessionClass\x12\"\n" +
+	"\rtcp_mss_clamp\x18\x1e \x01(\rR\vtcpMssClamp\x12\x10\n" +
+	"\x03mtu\x18\x1f \x01(\rR\x03mtu\x12\x1d\n" +
+	"\n" +
+	"mtu_policy\x18  \x01(\tR\tmtuPolicy\x12!\n" +
+	"\fpreserve_tos\x18! \x01(\bR\vpreserveTos\x12\x1d\n" +
+	"\n" +
+	"flow_label\x18\" \x01(\tR\tflowLabel\x12\x12\n" +
+	"\x04tags\x18# \x03(\tR\x04tags\x12\x1a\n" +
+	"\bdisabled\x18$ \x01(\bR\bdisabled\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18% \x01(\x03R\texpiresAt\x12\x10\n" +
+	"\x03ttl\x18& \x01(\rR\x03ttl\"\x9e\x01\n" +
+	"\tRateLimit\x125\n" +
+	"\x17uplink_bytes_per_second\x18\x01 \x01(\x04R\x14uplinkBytesPerSecond\x129\n" +
+	"\x19downlink_bytes_per_second\x18\x02 \x01(\x04R\x16downlinkBytesPerSecond\x12\x1f\n" +
+	"\vburst_bytes\x18\x03 \x01(\x04R\n" +
+	"burstBytes\"b\n" +
+	"\bSchedule\x12\x12\n" +
+	"\x04days\x18\x01 \x03(\tR\x04days\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\tR\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\tR\x03end\x12\x1a\n" +
+	"\btimezone\x18\x04 \x01(\tR\btimezone\"\xf4\x01\n" +
 	"\vPortMapping\x12#\n" +
 	"\roriginal_port\x18\x01 \x01(\tR\foriginalPort\x12'\n" +
-	"\x0ftranslated_port\x18\x02 \x01(\tR\x0etranslatedPort\"}\n" +
+	"\x0ftranslated_port\x18\x02 \x01(\tR\x0etranslatedPort\x12U\n" +
+	"\x0eprotocol_ports\x18\x03 \x03(\v2..xray.proxy.nat.PortMapping.ProtocolPortsEntryR\rprotocolPorts\x1a@\n" +
+	"\x12ProtocolPortsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb1\x02\n" +
 	"\x0eSessionTimeout\x12\x1f\n" +
 	"\vtcp_timeout\x18\x01 \x01(\rR\n" +
 	"tcpTimeout\x12\x1f\n" +
 	"\vudp_timeout\x18\x02 \x01(\rR\n" +
 	"udpTimeout\x12)\n" +
-	"\x10cleanup_interval\x18\x03 \x01(\rR\x0fcleanupInterval\"\x84\x01\n" +
+	"\x10cleanup_interval\x18\x03 \x01(\rR\x0fcleanupInterval\x12!\n" +
+	"\ficmp_timeout\x18\x04 \x01(\rR\vicmpTimeout\x126\n" +
+	"\x17established_tcp_timeout\x18\x05 \x01(\rR\x15establishedTcpTimeout\x124\n" +
+	"\x16transitory_tcp_timeout\x18\x06 \x01(\rR\x14transitoryTcpTimeout\x12!\n" +
+	"\fsctp_timeout\x18\a \x01(\rR\vsctpTimeout\"\x9b\x02\n" +
 	"\x0eResourceLimits\x12!\n" +
 	"\fmax_sessions\x18\x01 \x01(\rR\vmaxSessions\x12\"\n" +
 	"\rmax_memory_mb\x18\x02 \x01(\rR\vmaxMemoryMb\x12+\n" +
-	"\x11cleanup_threshold\x18\x03 \x01(\x02R\x10cleanupThresholdB%Z#github.com/xtls/xray-core/proxy/natb\x06proto3"
+	"\x11cleanup_threshold\x18\x03 \x01(\x02R\x10cleanupThreshold\x122\n" +
+	"\x15drop_noisiest_sources\x18\x04 \x01(\bR\x13dropNoisiestSources\x125\n" +
+	"\x17new_sessions_per_second\x18\x05 \x01(\rR\x14newSessionsPerSecond\x12*\n" +
+	"\x11new_session_burst\x18\x06 \x01(\rR\x0fnewSessionBurst\"\x81\x01\n" +
+	"\x0fPerSourceLimits\x12!\n" +
+	"\fmax_sessions\x18\x01 \x01(\rR\vmaxSessions\x125\n" +
+	"\x17new_sessions_per_second\x18\x02 \x01(\rR\x14newSessionsPerSecond\x12\x14\n" +
+	"\x05burst\x18\x03 \x01(\rR\x05burstB%Z#github.com/xtls/xray-core/proxy/natb\x06proto3"
 
 var (
 	file_config_proto_rawDescOnce sync.Once
@@ -536,26 +3025,76 @@ func file_config_proto_rawDescGZIP() []byte {
 	return file_config_proto_rawDescData
 }
 
-var file_config_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_config_proto_msgTypes = make([]protoimpl.MessageInfo, 27)
 var file_config_proto_goTypes = []any{
-	(*Config)(nil),         // 0: xray.proxy.nat.Config
-	(*VirtualIPRange)(nil), // 1: xray.proxy.nat.VirtualIPRange
-	(*NATRule)(nil),        // 2: xray.proxy.nat.NATRule
-	(*PortMapping)(nil),    // 3: xray.proxy.nat.PortMapping
-	(*SessionTimeout)(nil), // 4: xray.proxy.nat.SessionTimeout
-	(*ResourceLimits)(nil), // 5: xray.proxy.nat.ResourceLimits
+	(*Config)(nil),                // 0: xray.proxy.nat.Config
+	(*MapRule)(nil),               // 1: xray.proxy.nat.MapRule
+	(*AddressPool)(nil),           // 2: xray.proxy.nat.AddressPool
+	(*RuleDistribution)(nil),      // 3: xray.proxy.nat.RuleDistribution
+	(*Clat)(nil),                  // 4: xray.proxy.nat.Clat
+	(*Stun)(nil),                  // 5: xray.proxy.nat.Stun
+	(*PortControl)(nil),           // 6: xray.proxy.nat.PortControl
+	(*Cluster)(nil),               // 7: xray.proxy.nat.Cluster
+	(*ClusterNode)(nil),           // 8: xray.proxy.nat.ClusterNode
+	(*Replication)(nil),           // 9: xray.proxy.nat.Replication
+	(*SessionPersistence)(nil),    // 10: xray.proxy.nat.SessionPersistence
+	(*KernelOffload)(nil),         // 11: xray.proxy.nat.KernelOffload
+	(*SharedSessions)(nil),        // 12: xray.proxy.nat.SharedSessions
+	(*FlowExport)(nil),            // 13: xray.proxy.nat.FlowExport
+	(*SessionLog)(nil),            // 14: xray.proxy.nat.SessionLog
+	(*SessionWebhook)(nil),        // 15: xray.proxy.nat.SessionWebhook
+	(*StaticMapping)(nil),         // 16: xray.proxy.nat.StaticMapping
+	(*PortBlockAllocation)(nil),   // 17: xray.proxy.nat.PortBlockAllocation
+	(*VirtualIPRange)(nil),        // 18: xray.proxy.nat.VirtualIPRange
+	(*NATRule)(nil),               // 19: xray.proxy.nat.NATRule
+	(*RateLimit)(nil),             // 20: xray.proxy.nat.RateLimit
+	(*Schedule)(nil),              // 21: xray.proxy.nat.Schedule
+	(*PortMapping)(nil),           // 22: xray.proxy.nat.PortMapping
+	(*SessionTimeout)(nil),        // 23: xray.proxy.nat.SessionTimeout
+	(*ResourceLimits)(nil),        // 24: xray.proxy.nat.ResourceLimits
+	(*PerSourceLimits)(nil),       // 25: xray.proxy.nat.PerSourceLimits
+	nil,                           // 26: xray.proxy.nat.PortMapping.ProtocolPortsEntry
+	(*router.GeoIP)(nil),          // 27: xray.app.router.GeoIP
+	(*router.Domain)(nil),         // 28: xray.app.router.Domain
+	(*internet.SocketConfig)(nil), // 29: xray.transport.internet.SocketConfig
 }
 var file_config_proto_depIdxs = []int32{
-	1, // 0: xray.proxy.nat.Config.virtual_ranges:type_name -> xray.proxy.nat.VirtualIPRange
-	2, // 1: xray.proxy.nat.Config.rules:type_name -> xray.proxy.nat.NATRule
-	4, // 2: xray.proxy.nat.Config.session_timeout:type_name -> xray.proxy.nat.SessionTimeout
-	5, // 3: xray.proxy.nat.Config.limits:type_name -> xray.proxy.nat.ResourceLimits
-	3, // 4: xray.proxy.nat.NATRule.port_mapping:type_name -> xray.proxy.nat.PortMapping
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	18, // 0: xray.proxy.nat.Config.virtual_ranges:type_name -> xray.proxy.nat.VirtualIPRange
+	19, // 1: xray.proxy.nat.Config.rules:type_name -> xray.proxy.nat.NATRule
+	23, // 2: xray.proxy.nat.Config.session_timeout:type_name -> xray.proxy.nat.SessionTimeout
+	24, // 3: xray.proxy.nat.Config.limits:type_name -> xray.proxy.nat.ResourceLimits
+	17, // 4: xray.proxy.nat.Config.port_block_allocation:type_name -> xray.proxy.nat.PortBlockAllocation
+	16, // 5: xray.proxy.nat.Config.static_mappings:type_name -> xray.proxy.nat.StaticMapping
+	14, // 6: xray.proxy.nat.Config.session_log:type_name -> xray.proxy.nat.SessionLog
+	13, // 7: xray.proxy.nat.Config.flow_export:type_name -> xray.proxy.nat.FlowExport
+	10, // 8: xray.proxy.nat.Config.session_persistence:type_name -> xray.proxy.nat.SessionPersistence
+	9,  // 9: xray.proxy.nat.Config.replication:type_name -> xray.proxy.nat.Replication
+	7,  // 10: xray.proxy.nat.Config.cluster:type_name -> xray.proxy.nat.Cluster
+	6,  // 11: xray.proxy.nat.Config.port_control:type_name -> xray.proxy.nat.PortControl
+	5,  // 12: xray.proxy.nat.Config.stun:type_name -> xray.proxy.nat.Stun
+	3,  // 13: xray.proxy.nat.Config.rule_distribution:type_name -> xray.proxy.nat.RuleDistribution
+	2,  // 14: xray.proxy.nat.Config.address_pool:type_name -> xray.proxy.nat.AddressPool
+	25, // 15: xray.proxy.nat.Config.per_source_limits:type_name -> xray.proxy.nat.PerSourceLimits
+	14, // 16: xray.proxy.nat.Config.audit_log:type_name -> xray.proxy.nat.SessionLog
+	12, // 17: xray.proxy.nat.Config.shared_sessions:type_name -> xray.proxy.nat.SharedSessions
+	11, // 18: xray.proxy.nat.Config.kernel_offload:type_name -> xray.proxy.nat.KernelOffload
+	15, // 19: xray.proxy.nat.Config.session_webhook:type_name -> xray.proxy.nat.SessionWebhook
+	4,  // 20: xray.proxy.nat.Config.clat:type_name -> xray.proxy.nat.Clat
+	1,  // 21: xray.proxy.nat.Config.map_rules:type_name -> xray.proxy.nat.MapRule
+	8,  // 22: xray.proxy.nat.Cluster.nodes:type_name -> xray.proxy.nat.ClusterNode
+	22, // 23: xray.proxy.nat.NATRule.port_mapping:type_name -> xray.proxy.nat.PortMapping
+	27, // 24: xray.proxy.nat.NATRule.source_geoip:type_name -> xray.app.router.GeoIP
+	27, // 25: xray.proxy.nat.NATRule.dest_geoip:type_name -> xray.app.router.GeoIP
+	28, // 26: xray.proxy.nat.NATRule.dest_geosite:type_name -> xray.app.router.Domain
+	21, // 27: xray.proxy.nat.NATRule.schedule:type_name -> xray.proxy.nat.Schedule
+	29, // 28: xray.proxy.nat.NATRule.sockopt:type_name -> xray.transport.internet.SocketConfig
+	20, // 29: xray.proxy.nat.NATRule.rate_limit:type_name -> xray.proxy.nat.RateLimit
+	26, // 30: xray.proxy.nat.PortMapping.protocol_ports:type_name -> xray.proxy.nat.PortMapping.ProtocolPortsEntry
+	31, // [31:31] is the sub-list for method output_type
+	31, // [31:31] is the sub-list for method input_type
+	31, // [31:31] is the sub-list for extension type_name
+	31, // [31:31] is the sub-list for extension extendee
+	0,  // [0:31] is the sub-list for field type_name
 }
 
 func init() { file_config_proto_init() }
@@ -569,7 +3108,7 @@ func file_config_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_config_proto_rawDesc), len(file_config_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   27,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,83 @@
+package nat
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func diffTestConfig() *Config {
+	return &Config{
+		SiteId: "site-a",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+			{NpTv6VirtualPrefix: "fd01:203:405:1::/64", NpTv6RealPrefix: "2001:db8:1:1::/64"},
+		},
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Protocol: "tcp"},
+			{RuleId: "branch", VirtualDestination: "240.2.3.20", RealDestination: "192.168.2.20"},
+			{RuleId: "branch", VirtualDestination: "240.2.3.21", RealDestination: "192.168.2.20"},
+			{VirtualDestination: "240.2.2.30", Action: "deny"},
+		},
+		SessionTimeout: &SessionTimeout{TcpTimeout: 300, UdpTimeout: 60},
+		Limits:         &ResourceLimits{MaxSessions: 10000, CleanupThreshold: 0.8},
+	}
+}
+
+func TestConfigEquals(t *testing.T) {
+	config := diffTestConfig()
+	if !config.Equals(diffTestConfig()) {
+		t.Error("Expected equal configurations to be equal")
+	}
+	for _, change := range []func(*Config){
+		func(c *Config) { c.Rules[0].RealDestination = "192.168.1.21" },
+		func(c *Config) { c.Rules[1], c.Rules[2] = c.Rules[2], c.Rules[1] },
+		func(c *Config) { c.VirtualRanges[0].RealNetwork = "192.168.9.0/24" },
+		func(c *Config) { c.SessionTimeout.UdpTimeout = 30 },
+		func(c *Config) { c.Limits = nil },
+	} {
+		changed := diffTestConfig()
+		change(changed)
+		if config.Equals(changed) {
+			t.Errorf("Expected %v to differ from %v", changed, config)
+		}
+	}
+	if config.Equals(nil) || !(*Config)(nil).Equals(nil) {
+		t.Error("Unexpected comparison with nil")
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	config := diffTestConfig()
+	if changes := config.Diff(proto.Clone(config).(*Config)); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+
+	changed := diffTestConfig()
+	changed.SiteId = "site-b"
+	changed.Rules[0].Protocol = ""
+	changed.Rules[0].SourceAddresses = []string{"10.0.0.0/24"}
+	changed.Rules[2].VirtualDestination = "240.2.3.22"
+	changed.Rules = append(changed.Rules[:3], &NATRule{RuleId: "db", VirtualDestination: "240.2.2.40", RealDestination: "192.168.1.40"})
+	changed.VirtualRanges[1].NpTv6RealPrefix = "2001:db8:1:2::/64"
+	changed.SessionTimeout.TcpTimeout = 600
+	changed.Limits = nil
+	changed.SessionLog = &SessionLog{Sink: "log"}
+
+	expected := []string{
+		`siteId: "site-a" -> "site-b"`,
+		`virtualRanges[fd01:203:405:1::/64].npTv6RealPrefix: "2001:db8:1:1::/64" -> "2001:db8:1:2::/64"`,
+		`rules[web].protocol: "tcp" -> ""`,
+		`rules[web].sourceAddresses: [] -> ["10.0.0.0/24"]`,
+		`rules[branch#2].virtualDestination: "240.2.3.21" -> "240.2.3.22"`,
+		`rules[db]: added`,
+		`rules[240.2.2.30]: removed`,
+		`sessionTimeout.tcpTimeout: 300 -> 600`,
+		`limits: removed`,
+		`sessionLog: added`,
+	}
+	if changes := config.Diff(changed); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Unexpected changes:\n%q\nwant\n%q", changes, expected)
+	}
+}
@@ -0,0 +1,368 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of conflict between a rule added through the API and an active rule
+const (
+	ConflictContradicts = "contradicts" // Same virtual tuple translated differently, match order alone would pick one
+	ConflictShadowed    = "shadowed"    // The active rule takes every flow of the added rule, which would never match
+	ConflictShadows     = "shadows"     // The added rule takes every flow of the active rule
+)
+
+// RuleConflict is an active rule a rule added through the API conflicts with
+type RuleConflict struct {
+	Kind   string
+	RuleID string // ruleKey of the active rule
+	Detail string
+}
+
+// RuleConflictError rejects a rule conflicting with active rules
+type RuleConflictError struct {
+	RuleID    string
+	Conflicts []RuleConflict
+}
+
+func (e *RuleConflictError) Error() string {
+	conflicts := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		conflicts[i] = conflict.Kind + " rule " + conflict.RuleID
+	}
+	return "rule " + e.RuleID + " conflicts with active rules: " + strings.Join(conflicts, ", ")
+}
+
+// ruleScope is the virtual tuple a rule matches
+type ruleScope struct {
+	destination string              // Virtual destination, normalized
+	network     *net.IPNet          // Nil unless the virtual destination is an address or a network
+	flows       map[string]portList // Ports matched by transport network, tcp or udp; an empty list holds every port
+	conditions  *NATRule            // Site and conditions narrowing the tuple, nil without any
+}
+
+// scopeNetworks are the transport networks of flows, "sctp" riding on udp
+var scopeNetworks = []string{"tcp", "udp"}
+
+func scopeOf(rule *NATRule) ruleScope {
+	s := ruleScope{destination: strings.ToLower(strings.TrimSpace(rule.VirtualDestination))}
+	if !isPatternDestination(s.destination) {
+		if network, err := parseNetworkOrIP(s.destination); err == nil {
+			s.network = network
+			s.destination = network.String()
+		}
+	}
+	// Protocols expand to the networks they match, protocol groups to their ports
+	ports, _ := parsePortList(rule.Ports)
+	s.flows = make(map[string]portList)
+	tokens := protocolTokens(rule.Protocol)
+	if len(tokens) == 0 {
+		tokens = []string{protocolAny}
+	}
+	for _, token := range tokens {
+		switch group, isGroup := protocolGroups[token]; {
+		case token == protocolAny:
+			for _, network := range scopeNetworks {
+				s.addFlows(network, ports)
+			}
+		case token == protocolSCTP:
+			s.addFlows("udp", ports)
+		case isGroup:
+			groupPorts, _ := parsePortList(group.ports)
+			if ports.overlaps(groupPorts) {
+				for _, network := range group.networks {
+					s.addFlows(network, ports.intersect(groupPorts))
+				}
+			}
+		default:
+			s.addFlows(token, ports)
+		}
+	}
+	if rule.SourceSite != "" || hasConditions(rule) {
+		s.conditions = &NATRule{
+			SourceSite:      rule.SourceSite,
+			SourceGeoip:     rule.SourceGeoip,
+			DestGeoip:       rule.DestGeoip,
+			DestGeosite:     rule.DestGeosite,
+			Schedule:        rule.Schedule,
+			SourceAddresses: rule.SourceAddresses,
+			SourcePorts:     rule.SourcePorts,
+			Users:           rule.Users,
+			UserLevels:      rule.UserLevels,
+			Domains:         rule.Domains,
+		}
+	}
+	return s
+}
+
+// addFlows adds ports of a network to the scope
+func (s ruleScope) addFlows(network string, ports portList) {
+	existing, found := s.flows[network]
+	switch {
+	case !found:
+		s.flows[network] = ports
+	case len(existing) > 0 && len(ports) > 0:
+		s.flows[network] = append(slices.Clip(existing), ports...)
+	default:
+		s.flows[network] = nil
+	}
+}
+
+// same reports whether two scopes are one virtual tuple: the same destination and conditions,
+// with flows of a network and port in common
+func (s ruleScope) same(other ruleScope) bool {
+	if s.destination != other.destination || !proto.Equal(s.conditions, other.conditions) {
+		return false
+	}
+	for network, ports := range s.flows {
+		if otherPorts, found := other.flows[network]; found && ports.overlaps(otherPorts) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether every flow of other is in the scope
+func (s ruleScope) covers(other ruleScope) bool {
+	switch {
+	case s.network != nil && other.network != nil:
+		ones, bits := s.network.Mask.Size()
+		otherOnes, otherBits := other.network.Mask.Size()
+		if bits != otherBits || ones > otherOnes || !s.network.Contains(other.network.IP) {
+			return false
+		}
+	case s.destination != other.destination:
+		return false
+	}
+	if s.conditions != nil && !proto.Equal(s.conditions, other.conditions) {
+		return false
+	}
+	for network, otherPorts := range other.flows {
+		if ports, found := s.flows[network]; !found || !ports.covers(otherPorts) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether the lists have a port in common, an empty list holding every port
+func (l portList) overlaps(other portList) bool {
+	if len(l) == 0 || len(other) == 0 {
+		return true
+	}
+	for _, segment := range l {
+		for _, o := range other {
+			if segment.from <= o.to && o.from <= segment.to {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// intersect returns the ports in both lists, an empty list holding every port, of lists that
+// overlap
+func (l portList) intersect(other portList) portList {
+	if len(l) == 0 {
+		return other
+	}
+	if len(other) == 0 {
+		return l
+	}
+	var common portList
+	for _, segment := range l {
+		for _, o := range other {
+			if from, to := max(segment.from, o.from), min(segment.to, o.to); from <= to {
+				common = append(common, portSegment{from: from, to: to})
+			}
+		}
+	}
+	return common
+}
+
+// covers reports whether every port of other is in the list, an empty list holding every port
+func (l portList) covers(other portList) bool {
+	if len(l) == 0 {
+		return true
+	}
+	if len(other) == 0 {
+		return false
+	}
+	for _, o := range other {
+		for port := int(o.from); port <= int(o.to); {
+			i := slices.IndexFunc(l, func(segment portSegment) bool {
+				return int(segment.from) <= port && port <= int(segment.to)
+			})
+			if i < 0 {
+				return false
+			}
+			port = int(l[i].to) + 1
+		}
+	}
+	return true
+}
+
+// translationOf is what a rule does to the flows it matches
+func translationOf(rule *NATRule) *NATRule {
+	action := rule.Action
+	if action == "" || action == actionAllow {
+		action = actionTranslate
+	}
+	return &NATRule{
+		Action:           action,
+		RealDestination:  rule.RealDestination,
+		RealDestinations: rule.RealDestinations,
+		PortMapping:      rule.PortMapping,
+		PortOffset:       rule.PortOffset,
+		ForwardTag:       rule.ForwardTag,
+	}
+}
+
+// describeTranslation describes what a rule does to the flows it matches
+func describeTranslation(rule *NATRule) string {
+	switch rule.Action {
+	case actionBypass:
+		return "leaves them untranslated"
+	case actionDeny:
+		return "drops them"
+	case actionReject:
+		return "rejects them"
+	}
+	if len(rule.RealDestinations) > 0 {
+		return "balances them over " + strings.Join(rule.RealDestinations, ", ")
+	}
+	if rule.RealDestination == "" {
+		return "keeps their destination"
+	}
+	return "translates them to " + rule.RealDestination
+}
+
+// wins reports whether first takes the flows both rules match from second under strategy,
+// earlier telling whether first comes first in match order
+func wins(first, second *NATRule, earlier bool, strategy string) bool {
+	switch strategy {
+	case matchHighestPriority:
+		return first.Priority > second.Priority || first.Priority == second.Priority && earlier
+	case matchLongestPrefix:
+		firstLen, secondLen := virtualPrefixLen(first.VirtualDestination), virtualPrefixLen(second.VirtualDestination)
+		return firstLen > secondLen || firstLen == secondLen && earlier
+	default:
+		return earlier
+	}
+}
+
+// RuleConflicts returns the active rules a rule added after them would conflict with: those
+// matching its virtual tuple and translating it differently, those taking every flow it
+// matches and those it would take every flow of
+func (h *Handler) RuleConflicts(rule *NATRule) []RuleConflict {
+	return ruleConflicts(rule, h.activeRules(), h.matchStrategy())
+}
+
+// ruleConflicts is RuleConflicts against rules matched under strategy
+func ruleConflicts(rule *NATRule, rules []*NATRule, strategy string) []RuleConflict {
+	added := scopeOf(rule)
+	var conflicts []RuleConflict
+	for _, active := range rules {
+		scope := scopeOf(active)
+		switch {
+		case added.same(scope) && !proto.Equal(translationOf(rule), translationOf(active)):
+			conflicts = append(conflicts, RuleConflict{
+				Kind:   ConflictContradicts,
+				RuleID: ruleKey(active),
+				Detail: "both match " + active.VirtualDestination + ": rule " + ruleKey(active) + " " + describeTranslation(active) + ", rule " + ruleKey(rule) + " " + describeTranslation(rule),
+			})
+		case scope.covers(added) && wins(active, rule, true, strategy):
+			conflicts = append(conflicts, RuleConflict{
+				Kind:   ConflictShadowed,
+				RuleID: ruleKey(active),
+				Detail: "rule " + ruleKey(active) + " matches every flow of rule " + ruleKey(rule) + " first",
+			})
+		case added.covers(scope) && wins(rule, active, false, strategy):
+			conflicts = append(conflicts, RuleConflict{
+				Kind:   ConflictShadows,
+				RuleID: ruleKey(active),
+				Detail: "rule " + ruleKey(rule) + " would match every flow of rule " + ruleKey(active) + " first",
+			})
+		}
+	}
+	return conflicts
+}
+
+// AddRule adds a rule after the active ones, unless it conflicts with one of them as
+// RuleConflicts tells, which a *RuleConflictError then lists. Rules added stay after those of
+// the rules file or the controller across their reloads, until their ttl or expiresAt passes.
+func (h *Handler) AddRule(rule *NATRule) error {
+	if rule.RuleId == "" || rule.VirtualDestination == "" {
+		return errors.New("a rule needs a ruleId and a virtualDestination")
+	}
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+	now := time.Now()
+	deadline := deadlineOf(rule, now)
+	if !deadline.IsZero() && !now.Before(deadline) {
+		return errors.New("rule ", rule.RuleId, " already expired")
+	}
+
+	h.expiry.Lock()
+	defer h.expiry.Unlock()
+	active := h.activeRules()
+	for _, existing := range active {
+		if ruleKey(existing) == rule.RuleId {
+			return errors.New("ruleId ", rule.RuleId, " is already used")
+		}
+	}
+	if conflicts := h.RuleConflicts(rule); len(conflicts) > 0 {
+		return &RuleConflictError{RuleID: rule.RuleId, Conflicts: conflicts}
+	}
+
+	h.addedRules = append(slices.Clip(h.addedRules), rule)
+	rules := append(slices.Clip(active), rule)
+	h.compilePatterns([]*NATRule{rule})
+	h.parsePorts([]*NATRule{rule})
+	h.rules.Store(&rules)
+	if !deadline.IsZero() {
+		if h.expiry.deadlines == nil {
+			h.expiry.deadlines = make(map[*NATRule]time.Time)
+		}
+		h.expiry.deadlines[rule] = deadline
+		h.expiry.schedule(now, h.expireRules)
+	}
+	errors.LogInfo(context.Background(), "added NAT rule ", rule.RuleId, " through the API")
+	h.syncOffload()
+	if h.distribution != nil {
+		h.distribution.publish()
+	}
+	return nil
+}
+
+// validateRule checks the fields of a rule given through the API
+func validateRule(rule *NATRule) error {
+	if err := ValidateDestinationPattern(rule.VirtualDestination); err != nil {
+		return errors.New("invalid virtualDestination").Base(err)
+	}
+	if err := ValidateRuleAction(rule.Action); err != nil {
+		return errors.New("invalid action").Base(err)
+	}
+	if err := ValidateProtocol(rule.Protocol); err != nil {
+		return errors.New("invalid protocol").Base(err)
+	}
+	if err := ValidatePortOffset(rule.PortOffset, rule.Ports); err != nil {
+		return errors.New("invalid portOffset").Base(err)
+	}
+	if err := ValidateSessionClass(rule.SessionClass); err != nil {
+		return errors.New("invalid sessionClass").Base(err)
+	}
+	if rule.PortMapping != nil {
+		if err := rule.PortMapping.Validate(); err != nil {
+			return errors.New("invalid portMapping").Base(err)
+		}
+	}
+	return nil
+}
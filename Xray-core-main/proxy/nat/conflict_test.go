@@ -0,0 +1,132 @@
+package nat
+
+import (
+	goerrors "errors"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestRuleConflicts(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Protocol: "tcp", Ports: "80,443"},
+			{RuleId: "office", VirtualDestination: "240.2.3.0/24", RealDestination: "192.168.3.0/24"},
+			{RuleId: "lab", VirtualDestination: "240.2.4.10", RealDestination: "192.168.4.10", Ports: "8000-8100"},
+			{RuleId: "mail", VirtualDestination: "240.2.5.25", RealDestination: "192.168.5.25", Protocol: "tcp,udp"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		rule *NATRule
+		kind string
+		with string
+	}{
+		// The same virtual tuple, translated to another backend
+		{&NATRule{RuleId: "moved", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21", Ports: "443"}, ConflictContradicts, "web"},
+		// Bypassing what another rule translates
+		{&NATRule{RuleId: "direct", VirtualDestination: "240.2.2.20", Action: actionBypass, Protocol: "tcp"}, ConflictContradicts, "web"},
+		// A host of a translated network, matched by the network first
+		{&NATRule{RuleId: "printer", VirtualDestination: "240.2.3.9", RealDestination: "192.168.3.9"}, ConflictShadowed, "office"},
+		// The same translation would never match either
+		{&NATRule{RuleId: "web-alias", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Ports: "443", Protocol: "tcp"}, ConflictShadowed, "web"},
+		// Protocol groups match the networks and ports they stand for
+		{&NATRule{RuleId: "web-group", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21", Protocol: "web"}, ConflictContradicts, "web"},
+		{&NATRule{RuleId: "web-quic", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.22", Protocol: "quic"}, "", ""},
+		// Every protocol listed is any protocol
+		{&NATRule{RuleId: "mail-any", VirtualDestination: "240.2.5.25", RealDestination: "192.168.5.25", Protocol: "any"}, ConflictShadowed, "mail"},
+		// UDP is not matched by web
+		{&NATRule{RuleId: "web-udp", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.22", Protocol: "udp"}, "", ""},
+		// Other ports of the lab host
+		{&NATRule{RuleId: "lab-ssh", VirtualDestination: "240.2.4.10", RealDestination: "192.168.4.11", Ports: "22"}, "", ""},
+		// A later rule covering the lab host takes none of its flows under the first-match strategy
+		{&NATRule{RuleId: "lab-all", VirtualDestination: "240.2.4.0/28", RealDestination: "192.168.4.0/28", Priority: 10}, "", ""},
+	} {
+		conflicts := handler.RuleConflicts(tt.rule)
+		switch {
+		case tt.kind == "" && len(conflicts) > 0:
+			t.Errorf("Expected rule %s to conflict with no rule, got %+v", tt.rule.RuleId, conflicts)
+		case tt.kind != "" && (len(conflicts) != 1 || conflicts[0].Kind != tt.kind || conflicts[0].RuleID != tt.with):
+			t.Errorf("Expected rule %s to be %s with rule %s, got %+v", tt.rule.RuleId, tt.kind, tt.with, conflicts)
+		}
+	}
+
+	// Under the highestPriority strategy it takes them all
+	handler.config.MatchStrategy = matchHighestPriority
+	if conflicts := handler.RuleConflicts(&NATRule{RuleId: "lab-all", VirtualDestination: "240.2.4.0/28", RealDestination: "192.168.4.0/28", Priority: 10}); len(conflicts) != 1 || conflicts[0].Kind != ConflictShadows || conflicts[0].RuleID != "lab" {
+		t.Errorf("Expected rule lab-all to shadow rule lab, got %+v", conflicts)
+	}
+}
+
+func TestAddRule(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules:  []*NATRule{{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var conflict *RuleConflictError
+	if err := handler.AddRule(&NATRule{RuleId: "moved", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21"}); !goerrors.As(err, &conflict) || len(conflict.Conflicts) != 1 {
+		t.Fatalf("Expected a conflict with rule web, got %v", err)
+	}
+	for _, rule := range []*NATRule{
+		{VirtualDestination: "240.2.2.30"},
+		{RuleId: "web", VirtualDestination: "240.2.2.30"},
+		{RuleId: "db", VirtualDestination: "240.2.2.30", ExpiresAt: 1},
+		{RuleId: "db", VirtualDestination: "240.2.2.30", Protocol: "ipx"},
+	} {
+		if err := handler.AddRule(rule); err == nil || goerrors.As(err, &conflict) {
+			t.Errorf("Expected rule %v to be invalid, got %v", rule, err)
+		}
+	}
+
+	if err := handler.AddRule(&NATRule{RuleId: "db", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30"}); err != nil {
+		t.Fatal(err)
+	}
+	db := xnet.TCPDestination(xnet.ParseAddress("240.2.2.30"), 5432)
+	if translation, _ := handler.TestTranslation(xnet.Destination{}, db); translation.Rule == nil || translation.Rule.RuleId != "db" {
+		t.Errorf("Expected the flow to match rule db, got %+v", translation)
+	}
+
+	// Added rules stay after those of a reload
+	handler.applyRules([]*NATRule{{RuleId: "cache", VirtualDestination: "240.2.2.40", RealDestination: "192.168.1.40"}}, "test")
+	if keys := ruleKeys(handler.activeRules()); len(keys) != 3 || keys[1] != "cache" || keys[2] != "db" {
+		t.Errorf("Expected the added rule after the loaded one, got %v", keys)
+	}
+	handler.applyRules(nil, "test")
+	if keys := ruleKeys(handler.activeRules()); len(keys) != 2 || keys[1] != "db" {
+		t.Errorf("Expected the added rule to survive the reload, got %v", keys)
+	}
+	if translation, _ := handler.TestTranslation(xnet.Destination{}, db); translation.Rule == nil || translation.Rule.RuleId != "db" {
+		t.Errorf("Expected the flow to still match rule db, got %+v", translation)
+	}
+
+	// Added rules expire after their ttl, reloads included
+	temporary := &NATRule{RuleId: "debug", VirtualDestination: "240.2.2.50", RealDestination: "192.168.1.50", Ttl: 60}
+	if err := handler.AddRule(temporary); err != nil {
+		t.Fatal(err)
+	}
+	if deadline := handler.RuleExpiry(temporary); deadline.IsZero() || time.Until(deadline) > time.Minute {
+		t.Fatalf("Expected the added rule to expire within its ttl, got %v", deadline)
+	}
+	handler.expiry.Lock()
+	handler.expiry.deadlines[temporary] = time.Now().Add(-time.Second)
+	handler.expiry.Unlock()
+	handler.expireRules()
+	handler.applyRules(nil, "test")
+	if keys := ruleKeys(handler.activeRules()); len(keys) != 2 || keys[1] != "db" {
+		t.Errorf("Expected the added rule to expire, got %v", keys)
+	}
+	if expired := handler.ExpiredRules(); len(expired) != 0 {
+		t.Errorf("Expected the expired added rule to be forgotten by the reload, got %+v", expired)
+	}
+}
@@ -0,0 +1,152 @@
+package nat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+const (
+	// connPoolSize is the number of idle connections kept to each real destination
+	connPoolSize = 4
+
+	// connPoolIdleTimeout is how long an idle connection is kept before it is closed, under the
+	// idle timeouts of common servers
+	connPoolIdleTimeout = 30 * time.Second
+)
+
+// idleConn is a connection dialed ahead that no flow has used yet
+type idleConn struct {
+	conn   stat.Connection
+	dialed time.Time
+}
+
+// connPool keeps idle connections to one real destination of the rules reusing connections.
+// A TCP connection carries a single stream, so connections are never returned to the pool:
+// each one handed out is replaced by a new connection dialed in the background.
+type connPool struct {
+	sync.Mutex
+	idle    []idleConn
+	dialing int
+	closed  bool
+}
+
+// dialPooled dials dest for a flow of rule, handing out an idle connection of the pool of dest
+// when the rule reuses connections and refilling the pool. Flows leaving from the source port of
+// their session are always dialed, as no connection dialed ahead comes from it.
+func (h *Handler) dialPooled(ctx context.Context, dialer internet.Dialer, rule *NATRule, dest xnet.Destination) (stat.Connection, error) {
+	if d, bound := dialer.(sockoptDialer); bound && d.sockopt.GetBindPort() != 0 {
+		return dialer.Dial(ctx, dest)
+	}
+	if !rule.ReuseConnections || dest.Network != xnet.Network_TCP {
+		return dialer.Dial(ctx, dest)
+	}
+	value, _ := h.connPools.LoadOrStore(dest.String(), &connPool{})
+	p := value.(*connPool)
+	conn := p.take(time.Now())
+	p.refill(context.WithoutCancel(ctx), dialer, dest)
+	if conn != nil {
+		errors.LogDebug(ctx, "NAT: reusing an idle connection to ", dest)
+		return conn, nil
+	}
+	return dialer.Dial(ctx, dest)
+}
+
+// take returns the most recently dialed idle connection, closing those kept too long
+func (p *connPool) take(now time.Time) stat.Connection {
+	p.Lock()
+	defer p.Unlock()
+	p.expire(now)
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1].conn
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+// expire closes the idle connections kept longer than connPoolIdleTimeout, with p locked
+func (p *connPool) expire(now time.Time) {
+	kept := 0
+	for _, c := range p.idle {
+		if now.Sub(c.dialed) < connPoolIdleTimeout {
+			p.idle[kept] = c
+			kept++
+		} else {
+			c.conn.Close()
+		}
+	}
+	clear(p.idle[kept:])
+	p.idle = p.idle[:kept]
+}
+
+// refill dials the connections missing from the pool in the background
+func (p *connPool) refill(ctx context.Context, dialer internet.Dialer, dest xnet.Destination) {
+	p.Lock()
+	missing := connPoolSize - len(p.idle) - p.dialing
+	if p.closed || missing <= 0 {
+		p.Unlock()
+		return
+	}
+	p.dialing += missing
+	p.Unlock()
+
+	for range missing {
+		go func() {
+			conn, err := dialer.Dial(ctx, dest)
+			p.Lock()
+			defer p.Unlock()
+			p.dialing--
+			switch {
+			case err != nil:
+				errors.LogDebugInner(ctx, err, "NAT: failed to dial an idle connection to ", dest)
+			case p.closed:
+				conn.Close()
+			default:
+				p.idle = append(p.idle, idleConn{conn: conn, dialed: time.Now()})
+			}
+		}()
+	}
+}
+
+// close closes the idle connections and stops refilling the pool
+func (p *connPool) close() {
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.conn.Close()
+	}
+	p.idle = nil
+}
+
+// expireIdleConns closes the idle connections kept too long by all pools
+func (h *Handler) expireIdleConns() {
+	now := time.Now()
+	h.connPools.Range(func(key, value any) bool {
+		p := value.(*connPool)
+		p.Lock()
+		defer p.Unlock()
+		p.expire(now)
+		if len(p.idle) == 0 && p.dialing == 0 {
+			// Flows recreate the pool of a destination they dial again
+			p.closed = true
+			h.connPools.CompareAndDelete(key, p)
+		}
+		return true
+	})
+}
+
+// closeConnPools closes the idle connections of all pools
+func (h *Handler) closeConnPools() {
+	h.connPools.Range(func(key, value any) bool {
+		value.(*connPool).close()
+		h.connPools.Delete(key)
+		return true
+	})
+}
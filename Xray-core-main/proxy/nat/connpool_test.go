@@ -0,0 +1,118 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// countingDialer dials loopback TCP connections, counting them
+type countingDialer struct {
+	tcpTestDialer
+	dials *atomic.Int32
+}
+
+func (d countingDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	d.dials.Add(1)
+	return d.tcpTestDialer.Dial(ctx, dest)
+}
+
+// idleCount waits for the pool of dest to hold n idle connections
+func idleCount(t *testing.T, handler *Handler, dest xnet.Destination, n int) *connPool {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if value, ok := handler.connPools.Load(dest.String()); ok {
+			p := value.(*connPool)
+			p.Lock()
+			idle, dialing := len(p.idle), p.dialing
+			p.Unlock()
+			if idle == n && dialing == 0 {
+				return p
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d idle connections to %v", n, dest)
+		}
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	listener := listenTCP(t)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	dest := xnet.TCPDestination(xnet.LocalHostIP, xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+	dialer := countingDialer{dials: new(atomic.Int32)}
+	handler := &Handler{}
+	ctx := context.Background()
+
+	plain := &NATRule{RuleId: "plain"}
+	conn, err := handler.dialPooled(ctx, dialer, plain, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, ok := handler.connPools.Load(dest.String()); ok || dialer.dials.Load() != 1 {
+		t.Fatal("Rules without reuseConnections must dial their flows only")
+	}
+
+	rule := &NATRule{RuleId: "reuse", ReuseConnections: true}
+	first, err := handler.dialPooled(ctx, dialer, rule, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	idleCount(t, handler, dest, connPoolSize)
+	if dials := dialer.dials.Load(); dials != 2+connPoolSize {
+		t.Fatalf("Expected the first flow to dial and fill the pool, got %d dials", dials)
+	}
+
+	second, err := handler.dialPooled(ctx, dialer, rule, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	pool := idleCount(t, handler, dest, connPoolSize)
+	if dials := dialer.dials.Load(); dials != 3+connPoolSize {
+		t.Fatalf("Expected the second flow to take an idle connection and the pool to replace it, got %d dials", dials)
+	}
+
+	// Idle connections are closed once kept too long, and the unused pool dropped
+	stale := pool.idle[0].conn
+	pool.Lock()
+	for i := range pool.idle {
+		pool.idle[i].dialed = time.Now().Add(-connPoolIdleTimeout)
+	}
+	pool.Unlock()
+	handler.expireIdleConns()
+	if _, ok := handler.connPools.Load(dest.String()); ok {
+		t.Error("Expected the pool without idle connections to be dropped")
+	}
+	if _, err := stale.Write([]byte("x")); err == nil {
+		t.Error("Expected expired idle connections to be closed")
+	}
+
+	// Closing the handler closes the idle connections
+	third, err := handler.dialPooled(ctx, dialer, rule, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	pool = idleCount(t, handler, dest, connPoolSize)
+	idle := pool.idle[0].conn
+	handler.closeConnPools()
+	if _, err := idle.Write([]byte("x")); err == nil {
+		t.Error("Expected idle connections to be closed with the handler")
+	}
+}
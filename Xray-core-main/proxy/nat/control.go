@@ -0,0 +1,106 @@
+package nat
+
+import (
+	"context"
+	"sort"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// Sessions returns the active sessions, oldest first
+func (h *Handler) Sessions() []*NATSession {
+	var sessions []*NATSession
+	h.sessions.Range(func(session *NATSession) bool {
+		sessions = append(sessions, session)
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// FlushSessions drops the active sessions, only those of a rule when ruleID is not empty, and
+// returns how many were dropped. The connections relaying them are closed.
+func (h *Handler) FlushSessions(ruleID string) int {
+	flushed := 0
+	h.sessions.Range(func(session *NATSession) bool {
+		if ruleID == "" || session.RuleID == ruleID {
+			if _, loaded := h.sessions.LoadAndDelete(session.SessionID); loaded {
+				endSession(session, SessionEndFlushed)
+				h.dropSession(session)
+				flushed++
+			}
+		}
+		return true
+	})
+	return flushed
+}
+
+// Rules returns the active rules in match order. They must not be modified.
+func (h *Handler) Rules() []*NATRule {
+	return h.activeRules()
+}
+
+// Translation is how a flow would be translated
+type Translation struct {
+	Rule               *NATRule         // Nil when the flow would leave untranslated
+	VirtualDestination xnet.Destination // Destination the rules matched, the domain of a FakeDNS fake IP
+	RealSource         xnet.Destination // Zero when the source is left to the dialer; port 0 when it is allocated with the session
+	RealDestination    xnet.Destination // Zero for rules balancing over a backend pool, whose backend is chosen with the session, and rules blocking the flow
+	Hairpin            bool
+	PortMapping        *PortMapping // Port mapping that translated the destination port, nil when the port is kept
+}
+
+// TestTranslation reports how a flow from source to destination would be translated: the rule it
+// matches and its tuple after DNAT and SNAT. No session is created and no source port is allocated.
+func (h *Handler) TestTranslation(source, destination xnet.Destination) (Translation, error) {
+	return h.testTranslation(source, destination, h.activeRules())
+}
+
+// testContext is the context of a flow from source to destination tested without a session
+func testContext(source, destination xnet.Destination) context.Context {
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	return session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: destination}})
+}
+
+// testTranslation is TestTranslation matching rules in place of the active rules
+func (h *Handler) testTranslation(source, destination xnet.Destination, rules []*NATRule) (Translation, error) {
+	ctx := testContext(source, destination)
+	destination = h.fakeDNSDomain(ctx, destination)
+
+	rule, ok := h.ruleFor(ctx, destination, rules)
+	if !ok {
+		return Translation{VirtualDestination: destination}, nil
+	}
+	translation := Translation{Rule: rule, VirtualDestination: destination}
+	if len(rule.RealDestinations) > 0 || blocksFlows(rule) {
+		return translation, nil
+	}
+	realDest, err := h.applyDNATTo(destination, rule, rule.RealDestination)
+	if err != nil {
+		return translation, err
+	}
+	if realDest, err = h.resolveRealDestination(ctx, realDest, rule); err != nil {
+		return translation, err
+	}
+	translation.RealDestination = realDest
+	if rule.PortMapping != nil && realDest.Port != destination.Port {
+		translation.PortMapping = rule.PortMapping
+	}
+
+	// SNAT as the session set up would apply it
+	if hairpinSource, hairpin := h.hairpinSource(source, realDest); hairpin {
+		translation.RealSource, translation.Hairpin = hairpinSource, true
+	} else if h.portBlocks != nil && source.Address != nil && source.Address.Family().IsIP() {
+		translation.RealSource = xnet.Destination{Network: destination.Network, Address: h.portBlocks.publicAddress}
+	} else if table := h.masqueradeOf(realDest.Address); table != nil {
+		translation.RealSource = xnet.Destination{Network: destination.Network, Address: table.sourceOf(source)}
+	} else if ip := h.sendThroughOf(realDest.Address); ip != nil {
+		translation.RealSource = xnet.Destination{Network: destination.Network, Address: xnet.IPAddress(ip)}
+	} else if ip := h.clat.sourceOf(source.Address, realDest.Address); ip != nil {
+		translation.RealSource = xnet.Destination{Network: destination.Network, Address: xnet.IPAddress(ip)}
+	}
+	return translation, nil
+}
@@ -0,0 +1,97 @@
+package nat
+
+import (
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestFlushSessions(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, ruleID := range []string{"web", "db", "web"} {
+		dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(80+i))
+		handler.createNATSession(xnet.Destination{}, dest, dest, "outbound", classInteractive).RuleID = ruleID
+	}
+
+	if sessions := handler.Sessions(); len(sessions) != 3 || sessions[0].VirtualDest.Port != 80 {
+		t.Fatalf("Expected the sessions oldest first, got %v", sessions)
+	}
+	if flushed := handler.FlushSessions("web"); flushed != 2 {
+		t.Errorf("Expected the 2 sessions of the rule to be flushed, got %d", flushed)
+	}
+	if sessions := handler.Sessions(); len(sessions) != 1 || sessions[0].RuleID != "db" {
+		t.Errorf("Expected the session of the other rule to be kept, got %v", sessions)
+	}
+	if flushed := handler.FlushSessions(""); flushed != 1 || handler.Stats().ActiveSessions != 0 {
+		t.Errorf("Expected every session to be flushed, got %d", flushed)
+	}
+}
+
+func TestTestTranslation(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", PortMapping: &PortMapping{OriginalPort: "8080", TranslatedPort: "80"}},
+			{RuleId: "pool", VirtualDestination: "240.2.2.30", RealDestinations: []string{"192.168.1.30", "192.168.1.31"}},
+			{RuleId: "db", VirtualDestination: "240.2.2.40", RealDestination: "192.168.3.5"},
+		},
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.3.3.0/24", RealNetwork: "192.168.3.0/24", SendThrough: "10.0.0.1"}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	translation, err := handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.Rule == nil || translation.Rule.RuleId != "web" || translation.RealDestination.NetAddr() != "192.168.1.20:80" ||
+		translation.PortMapping == nil || translation.RealSource.Address != nil {
+		t.Errorf("Unexpected translation %+v", translation)
+	}
+	translation, err = handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("240.2.2.40"), 443))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.RealDestination.NetAddr() != "192.168.3.5:443" || translation.RealSource.Address.String() != "10.0.0.1" || translation.PortMapping != nil {
+		t.Errorf("Expected the source to be translated to the sendThrough of the real network, got %+v", translation)
+	}
+	if translation, _ := handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("240.2.2.30"), 80)); translation.Rule == nil || translation.RealDestination.Address != nil {
+		t.Errorf("Expected the pool rule without a real destination, got %+v", translation)
+	}
+	if translation, _ := handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("10.0.0.1"), 80)); translation.Rule != nil {
+		t.Errorf("Expected no rule to match, got %+v", translation)
+	}
+	if handler.Stats().TotalSessions != 0 {
+		t.Error("Tests must not create sessions")
+	}
+}
+
+func TestTestTranslationPortBlocks(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:              "test-site",
+		Rules:               []*NATRule{{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"}},
+		PortBlockAllocation: &PortBlockAllocation{PublicAddress: "203.0.113.1", PortRangeStart: 10000, PortRangeEnd: 10007, BlockSize: 4},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber := xnet.TCPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	translation, err := handler.TestTranslation(subscriber, xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.RealSource.Address.String() != "203.0.113.1" || translation.RealSource.Port != 0 {
+		t.Errorf("Expected the public address without a port, got %v", translation.RealSource)
+	}
+	if blocks := handler.PortBlocks(subscriber.Address.IP()); len(blocks) != 0 {
+		t.Errorf("Tests must not allocate port blocks, got %v", blocks)
+	}
+}
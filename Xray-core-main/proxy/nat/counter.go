@@ -0,0 +1,159 @@
+package nat
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+)
+
+// Stats is a snapshot of the handler-wide NAT counters
+type Stats struct {
+	ActiveSessions int64
+	TotalSessions  int64
+	TotalBytes     int64
+	TotalErrors    int64
+	Evictions      int64
+	DialFailures   int64
+	MemoryBytes    int64 // Memory held by the sessions and the ALG state of their flows
+
+	PortExhaustions    int64 // Flows refused because a CGNAT or masquerade port pool ran out
+	SessionExhaustions int64 // New flows that found the session table full
+	RateLimited        int64 // New flows refused over the new session rate
+	Drained            int64 // New flows refused or left untranslated while draining
+	ShapingDrops       int64 // Datagrams dropped over the rate limit of their rule
+	OversizeDrops      int64 // Datagrams dropped over the mtu of their rule
+	EventDrops         int64 // Session events the webhook or an API watcher could not take or deliver
+
+	ClassEvictions map[string]int64 // Evictions by the session class of the session evicted
+}
+
+// SessionStats is a snapshot of the traffic counters of a NAT session
+type SessionStats struct {
+	UplinkBytes     int64
+	DownlinkBytes   int64
+	UplinkPackets   int64
+	DownlinkPackets int64
+	LastActivity    time.Time
+}
+
+// sessionCounters holds the traffic counters of a NAT session. All fields are updated atomically
+// because both directions of a flow are copied concurrently.
+type sessionCounters struct {
+	uplinkBytes     atomic.Int64
+	downlinkBytes   atomic.Int64
+	uplinkPackets   atomic.Int64
+	downlinkPackets atomic.Int64
+	lastActivity    atomic.Int64 // Unix nanoseconds
+}
+
+// touch marks the session as active now
+func (s *NATSession) touch() {
+	s.counters.lastActivity.Store(time.Now().UnixNano())
+}
+
+// LastActivity returns the time traffic was last seen on the session
+func (s *NATSession) LastActivity() time.Time {
+	return time.Unix(0, s.counters.lastActivity.Load())
+}
+
+// Stats returns a snapshot of the session's traffic counters
+func (s *NATSession) Stats() SessionStats {
+	return SessionStats{
+		UplinkBytes:     s.counters.uplinkBytes.Load(),
+		DownlinkBytes:   s.counters.downlinkBytes.Load(),
+		UplinkPackets:   s.counters.uplinkPackets.Load(),
+		DownlinkPackets: s.counters.downlinkPackets.Load(),
+		LastActivity:    s.LastActivity(),
+	}
+}
+
+// record accounts a batch of buffers to the session and refreshes its activity
+func (s *NATSession) record(uplink bool, bytes, packets int64) {
+	if uplink {
+		s.counters.uplinkBytes.Add(bytes)
+		s.counters.uplinkPackets.Add(packets)
+		if s.uplinkCounter != nil {
+			s.uplinkCounter.Add(bytes)
+		}
+	} else {
+		s.counters.downlinkBytes.Add(bytes)
+		s.counters.downlinkPackets.Add(packets)
+		if s.downlinkCounter != nil {
+			s.downlinkCounter.Add(bytes)
+		}
+	}
+	if s.rule != nil {
+		s.rule.bytes.Add(bytes)
+	}
+	s.touch()
+}
+
+// countingReader counts everything read through it against a NAT session
+type countingReader struct {
+	buf.Reader
+	handler *Handler
+	session *NATSession
+	uplink  bool
+}
+
+func newCountingReader(reader buf.Reader, h *Handler, session *NATSession, uplink bool) buf.Reader {
+	return &countingReader{
+		Reader:  reader,
+		handler: h,
+		session: session,
+		uplink:  uplink,
+	}
+}
+
+// ReadMultiBuffer implements buf.Reader
+func (r *countingReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	mb, err := r.Reader.ReadMultiBuffer()
+	if !mb.IsEmpty() {
+		bytes := int64(mb.Len())
+		r.session.record(r.uplink, bytes, int64(len(mb)))
+		atomic.AddInt64(&r.handler.totalBytes, bytes)
+	}
+	return mb, err
+}
+
+// Stats returns a snapshot of the handler-wide counters
+func (h *Handler) Stats() Stats {
+	return Stats{
+		ActiveSessions: atomic.LoadInt64(&h.activeSessions),
+		TotalSessions:  atomic.LoadInt64(&h.totalSessions),
+		TotalBytes:     atomic.LoadInt64(&h.totalBytes),
+		TotalErrors:    atomic.LoadInt64(&h.totalErrors),
+		Evictions:      atomic.LoadInt64(&h.evictions),
+		DialFailures:   atomic.LoadInt64(&h.dialFailures),
+		MemoryBytes:    h.memoryUsed.Load(),
+
+		PortExhaustions:    atomic.LoadInt64(&h.portExhaustions),
+		SessionExhaustions: atomic.LoadInt64(&h.sessionExhaustions),
+		RateLimited:        atomic.LoadInt64(&h.rateLimited),
+		Drained:            atomic.LoadInt64(&h.drained),
+		ShapingDrops:       atomic.LoadInt64(&h.shapingDrops),
+		OversizeDrops:      atomic.LoadInt64(&h.oversizeDrops),
+		EventDrops:         atomic.LoadInt64(&h.eventDrops),
+
+		ClassEvictions: h.classEvictionCounts(),
+	}
+}
+
+// classEvictionCounts returns the evictions by session class
+func (h *Handler) classEvictionCounts() map[string]int64 {
+	counts := make(map[string]int64, sessionClassCount)
+	for class := range sessionClassCount {
+		counts[class.String()] = atomic.LoadInt64(&h.classEvictions[class])
+	}
+	return counts
+}
+
+// SessionStats returns the traffic counters of an active session
+func (h *Handler) SessionStats(sessionID string) (SessionStats, bool) {
+	session, ok := h.sessions.Load(sessionID)
+	if !ok {
+		return SessionStats{}, false
+	}
+	return session.Stats(), true
+}
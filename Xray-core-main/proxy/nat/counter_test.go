@@ -0,0 +1,63 @@
+package nat
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestCountingReader(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	dest := xnet.Destination{
+		Address: xnet.ParseAddress("240.2.2.20"),
+		Network: xnet.Network_TCP,
+		Port:    80,
+	}
+	session := handler.createNATSession(xnet.Destination{}, dest, dest, "outbound", classInteractive)
+	created := session.LastActivity()
+
+	time.Sleep(10 * time.Millisecond)
+
+	payload := bytes.Repeat([]byte{'a'}, 3000)
+	reader := newCountingReader(buf.NewReader(bytes.NewReader(payload)), handler, session, true)
+	var total int32
+	for {
+		mb, err := reader.ReadMultiBuffer()
+		total += mb.Len()
+		buf.ReleaseMulti(mb)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected read error: %v", err)
+		}
+	}
+
+	stats := session.Stats()
+	if stats.UplinkBytes != int64(len(payload)) || int64(total) != stats.UplinkBytes {
+		t.Errorf("Expected %d uplink bytes, got %d", len(payload), stats.UplinkBytes)
+	}
+	if stats.UplinkPackets == 0 {
+		t.Error("Expected uplink packets to be counted")
+	}
+	if stats.DownlinkBytes != 0 || stats.DownlinkPackets != 0 {
+		t.Errorf("Expected no downlink traffic, got %d bytes", stats.DownlinkBytes)
+	}
+	if !stats.LastActivity.After(created) {
+		t.Error("Expected LastActivity to advance after traffic")
+	}
+
+	if handler.Stats().TotalBytes != int64(len(payload)) {
+		t.Errorf("Expected handler total bytes %d, got %d", len(payload), handler.Stats().TotalBytes)
+	}
+
+	if lookup, ok := handler.SessionStats(session.SessionID); !ok || lookup.UplinkBytes != stats.UplinkBytes {
+		t.Error("Expected session stats to be queryable by session ID")
+	}
+}
@@ -0,0 +1,250 @@
+package nat
+
+//go:generate go run github.com/xtls/xray-core/common/proto -cproto=./distribution.proto -pnat -g
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// distributionRetry is the delay before subscribing to the controller again
+	distributionRetry = time.Second
+	// distributionSecretHeader carries the shared secret of the controller and its members
+	distributionSecretHeader = "x-nat-rules-secret"
+)
+
+// ruleDistributor publishes the active rules of a controller to the member sites subscribed to
+// it, or keeps the rules of a member in sync with its controller
+type ruleDistributor struct {
+	handler  *Handler
+	config   *RuleDistribution
+	server   *grpc.Server
+	listener net.Listener
+
+	access  sync.Mutex
+	version uint64        // Version of the published rules
+	changed chan struct{} // Closed when the version moves on
+}
+
+func newRuleDistributor(h *Handler, config *RuleDistribution) (*ruleDistributor, error) {
+	if config.Listen == "" && config.Controller == "" {
+		return nil, errors.New("rule distribution needs a listen or a controller address")
+	}
+	if config.Listen != "" && config.Controller != "" {
+		return nil, errors.New("a controller cannot subscribe to another controller")
+	}
+	d := &ruleDistributor{handler: h, config: config, version: 1, changed: make(chan struct{})}
+	if config.Controller != "" {
+		if _, _, err := net.SplitHostPort(config.Controller); err != nil {
+			return nil, errors.New("invalid rule controller ", config.Controller).Base(err)
+		}
+		if h.config.RulesFile != "" {
+			return nil, errors.New("members take their rules from the controller, not a rules file")
+		}
+		return d, nil
+	}
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return nil, errors.New("failed to listen for rule subscriptions on ", config.Listen).Base(err)
+	}
+	d.listener = listener
+	d.server = grpc.NewServer()
+	RegisterRuleDistributorServer(d.server, &ruleDistributionServer{distributor: d})
+	return d, nil
+}
+
+// start serves the members of a controller, or subscribes a member to its controller
+func (d *ruleDistributor) start() {
+	if d.server != nil {
+		go d.server.Serve(d.listener)
+	} else {
+		go d.run()
+	}
+}
+
+// publish moves the version of the rule set on and wakes the subscriptions
+func (d *ruleDistributor) publish() {
+	if d.server == nil {
+		// Members have no subscriptions
+		return
+	}
+	d.access.Lock()
+	defer d.access.Unlock()
+	d.version++
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// snapshot returns the current version of the rule set, its rules and the channel closed on its next change
+func (d *ruleDistributor) snapshot() (uint64, []*NATRule, <-chan struct{}) {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return d.version, d.handler.activeRules(), d.changed
+}
+
+// run keeps the member subscribed to the controller until the handler closes. The rules held
+// are kept while the controller is unreachable.
+func (d *ruleDistributor) run() {
+	for {
+		err := d.subscribe()
+		select {
+		case <-d.handler.done:
+			return
+		default:
+		}
+		errors.LogWarningInner(context.Background(), err, "NAT: rule subscription to ", d.config.Controller, " interrupted")
+		select {
+		case <-time.After(distributionRetry):
+		case <-d.handler.done:
+			return
+		}
+	}
+}
+
+// subscribe applies the updates of one subscription to the controller
+func (d *ruleDistributor) subscribe() error {
+	conn, err := grpc.NewClient(d.config.Controller, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.handler.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	streamCtx := ctx
+	if d.config.Secret != "" {
+		streamCtx = metadata.AppendToOutgoingContext(ctx, distributionSecretHeader, d.config.Secret)
+	}
+
+	stream, err := NewRuleDistributorClient(conn).Subscribe(streamCtx, &RuleSubscription{SiteId: d.handler.config.SiteId}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	held := make(map[string]*NATRule)
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if update.Full {
+			held = make(map[string]*NATRule)
+		}
+		for _, rule := range update.Rules {
+			held[ruleKey(rule)] = rule
+		}
+		for _, key := range update.Removed {
+			delete(held, key)
+		}
+		rules := make([]*NATRule, 0, len(update.Order))
+		for _, key := range update.Order {
+			if rule, found := held[key]; found {
+				rules = append(rules, rule)
+			}
+		}
+		d.handler.applyRules(rules, "controller "+d.config.Controller)
+		errors.LogDebug(ctx, "NAT: holding version ", update.Version, " of the rules of ", d.config.Controller)
+	}
+}
+
+// Close stops serving the members; the subscription of a member ends with the handler
+func (d *ruleDistributor) Close() {
+	if d.server != nil {
+		d.server.Stop()
+	}
+}
+
+// ruleDistributionServer streams the rules of the controller to its members
+type ruleDistributionServer struct {
+	UnimplementedRuleDistributorServer
+	distributor *ruleDistributor
+}
+
+func (s *ruleDistributionServer) Subscribe(subscription *RuleSubscription, stream RuleDistributor_SubscribeServer) error {
+	if secret := s.distributor.config.Secret; secret != "" {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		values := md.Get(distributionSecretHeader)
+		if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(secret)) != 1 {
+			return status.Error(codes.Unauthenticated, "invalid rule distribution secret")
+		}
+	}
+
+	var sent map[string]*NATRule // Rules the member holds, nil before the full sync
+	var order []string
+	for {
+		version, rules, changed := s.distributor.snapshot()
+		rules = siteRules(rules, subscription.SiteId)
+		if update := diffRules(sent, order, rules); update != nil {
+			update.Version = version
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+			sent = make(map[string]*NATRule, len(rules))
+			for _, rule := range rules {
+				sent[ruleKey(rule)] = rule
+			}
+			order = update.Order
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.distributor.handler.done:
+			return nil
+		}
+	}
+}
+
+// siteRules returns the rules distributed to a site
+func siteRules(rules []*NATRule, siteID string) []*NATRule {
+	var result []*NATRule
+	for _, rule := range rules {
+		if siteListed(rule.SourceSite, siteID) {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+// diffRules returns the update moving a member holding sent, matched in order, to rules: a full
+// sync when it holds nothing yet, nil when it is already up to date
+func diffRules(sent map[string]*NATRule, order []string, rules []*NATRule) *RuleUpdate {
+	update := &RuleUpdate{Full: sent == nil}
+	kept := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		key := ruleKey(rule)
+		update.Order = append(update.Order, key)
+		kept[key] = true
+		if old, found := sent[key]; !found || old != rule && !proto.Equal(old, rule) {
+			update.Rules = append(update.Rules, rule)
+		}
+	}
+	for key := range sent {
+		if !kept[key] {
+			update.Removed = append(update.Removed, key)
+		}
+	}
+	if !update.Full && len(update.Rules) == 0 && len(update.Removed) == 0 && slices.Equal(update.Order, order) {
+		return nil
+	}
+	return update
+}
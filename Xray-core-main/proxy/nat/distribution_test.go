@@ -0,0 +1,110 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func ruleKeys(rules []*NATRule) []string {
+	var keys []string
+	for _, rule := range rules {
+		keys = append(keys, ruleKey(rule))
+	}
+	return keys
+}
+
+func TestRuleDistribution(t *testing.T) {
+	controller := New()
+	defer controller.Close()
+	if err := controller.Init(&Config{
+		SiteId: "site-a",
+		Rules: []*NATRule{
+			{RuleId: "to-b", SourceSite: "site-b", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+			{RuleId: "to-c", SourceSite: "site-c", VirtualDestination: "240.3.3.20", RealDestination: "192.168.2.20"},
+			{RuleId: "all", VirtualDestination: "240.4.4.20", RealDestination: "192.168.4.20"},
+		},
+		RuleDistribution: &RuleDistribution{Listen: "127.0.0.1:0", Secret: "shared"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	member := New()
+	defer member.Close()
+	if err := member.Init(&Config{
+		SiteId:           "site-b",
+		Rules:            []*NATRule{{RuleId: "local", VirtualDestination: "240.9.9.9", RealDestination: "192.168.9.9"}},
+		RuleDistribution: &RuleDistribution{Controller: controller.distribution.listener.Addr().String(), Secret: "shared"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	held := func(keys ...string) func() bool {
+		return func() bool {
+			active := ruleKeys(member.activeRules())
+			if len(active) != len(keys) {
+				return false
+			}
+			for i := range keys {
+				if active[i] != keys[i] {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	// The full sync brings the rules of the site after the configured ones
+	waitFor(t, "the full sync", held("local", "to-b", "all"))
+
+	// Changes of the controller arrive as updates
+	controller.applyRules([]*NATRule{{RuleId: "new", SourceSite: "site-b", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30"}}, "test")
+	waitFor(t, "the added rule", held("local", "to-b", "all", "new"))
+
+	controller.applyRules([]*NATRule{{RuleId: "new", SourceSite: "site-b", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.31"}}, "test")
+	waitFor(t, "the changed rule", func() bool {
+		rules := member.activeRules()
+		return len(rules) == 4 && rules[3].RealDestination == "192.168.1.31"
+	})
+
+	controller.applyRules(nil, "test")
+	waitFor(t, "the removed rule", held("local", "to-b", "all"))
+}
+
+func TestRuleDistributionSecret(t *testing.T) {
+	controller := New()
+	defer controller.Close()
+	if err := controller.Init(&Config{
+		SiteId:           "site-a",
+		RuleDistribution: &RuleDistribution{Listen: "127.0.0.1:0", Secret: "shared"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.NewClient(controller.distribution.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := NewRuleDistributorClient(conn).Subscribe(context.Background(), &RuleSubscription{SiteId: "site-b"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a subscription without the secret to be rejected, got %v", err)
+	}
+}
+
+func TestRuleDistributionMemberRulesFile(t *testing.T) {
+	err := New().Init(&Config{
+		SiteId:           "site-b",
+		RulesFile:        "rules.json",
+		RuleDistribution: &RuleDistribution{Controller: "127.0.0.1:1"},
+	}, nil)
+	if err == nil {
+		t.Error("Expected error for a member with a rules file")
+	}
+}
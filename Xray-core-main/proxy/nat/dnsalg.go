@@ -0,0 +1,168 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/xtls/xray-core/common"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func init() {
+	common.Must(RegisterALG(algDNS, dnsGateway{rewrite: true}))
+	common.Must(RegisterALG(algDNS64, dnsGateway{dns64: true}))
+}
+
+// dnsGateway is the DNS or DNS64 gateway of DNS flows. Rules enabling both are handled by the
+// DNS64 gateway, which leaves the addresses it synthesizes alone.
+type dnsGateway struct {
+	rewrite bool
+	dns64   bool
+}
+
+// Match implements ALG
+func (g dnsGateway) Match(flow *ALGFlow) bool {
+	if g.rewrite && hasALG(flow.Rule, algDNS64) {
+		return false
+	}
+	flow.State = &dnsALG{handler: flow.handler, rewrite: g.rewrite || hasALG(flow.Rule, algDNS), dns64: g.dns64}
+	return true
+}
+
+// Frame implements ALGFramer
+func (dnsGateway) Frame(data []byte) int {
+	return dnsMessageLength(data)
+}
+
+// RewriteOutbound implements ALG
+func (dnsGateway) RewriteOutbound(flow *ALGFlow, message []byte) []byte {
+	return framed(message, flow.Stream(), flow.State.(*dnsALG).rewriteQuery)
+}
+
+// RewriteInbound implements ALG
+func (dnsGateway) RewriteInbound(flow *ALGFlow, message []byte) []byte {
+	return framed(message, flow.Stream(), flow.State.(*dnsALG).rewriteResponse)
+}
+
+// ExpectSessions implements ALG
+func (dnsGateway) ExpectSessions(flow *ALGFlow, message []byte, outbound bool) []ALGExpectation {
+	return nil
+}
+
+// dnsALG rewrites DNS responses crossing the NAT so clients see the virtual addressing plan.
+// With rewrite, A and AAAA answers inside a real network become the corresponding virtual
+// addresses. With dns64, AAAA questions are asked as A questions and answered with AAAA
+// records synthesized from the NAT64 prefix and the real IPv4 addresses, which the NAT
+// translates back (RFC 6147).
+type dnsALG struct {
+	handler *Handler
+	rewrite bool
+	dns64   bool
+	queries sync.Map // Message ID -> struct{} of AAAA questions asked as A
+}
+
+// dnsMessageLength frames DNS messages on TCP by their two byte length prefix
+func dnsMessageLength(data []byte) int {
+	if len(data) < 2 {
+		return -1
+	}
+	return 2 + int(binary.BigEndian.Uint16(data))
+}
+
+// framed applies rewrite to a message with or without its TCP length prefix
+func framed(message []byte, stream bool, rewrite func([]byte) []byte) []byte {
+	if !stream {
+		return rewrite(message)
+	}
+	rewritten := rewrite(message[2:])
+	if len(rewritten) > 0xffff {
+		return message
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(rewritten))), rewritten...)
+}
+
+// rewriteQuery asks the AAAA questions of a query as A questions for DNS64
+func (a *dnsALG) rewriteQuery(message []byte) []byte {
+	if !a.dns64 {
+		return message
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(message); err != nil || msg.Response || len(msg.Questions) != 1 {
+		return message
+	}
+	question := &msg.Questions[0]
+	if question.Type != dnsmessage.TypeAAAA || question.Class != dnsmessage.ClassINET {
+		return message
+	}
+	question.Type = dnsmessage.TypeA
+	packed, err := msg.Pack()
+	if err != nil {
+		return message
+	}
+	a.queries.Store(msg.ID, struct{}{})
+	return packed
+}
+
+// rewriteResponse rewrites the addresses in the answers of a response
+func (a *dnsALG) rewriteResponse(message []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(message); err != nil || !msg.Response {
+		return message
+	}
+
+	synthesize := false
+	if len(msg.Questions) == 1 && msg.Questions[0].Type == dnsmessage.TypeA {
+		_, synthesize = a.queries.LoadAndDelete(msg.ID)
+	}
+	if synthesize {
+		msg.Questions[0].Type = dnsmessage.TypeAAAA
+		prefix := net.ParseIP(a.handler.getNAT64Prefix()).To16()
+		for _, section := range []*[]dnsmessage.Resource{&msg.Answers, &msg.Additionals} {
+			resources := (*section)[:0]
+			for _, resource := range *section {
+				if body, ok := resource.Body.(*dnsmessage.AResource); ok {
+					if prefix == nil {
+						continue
+					}
+					synthesized := &dnsmessage.AAAAResource{}
+					copy(synthesized.AAAA[:12], prefix)
+					copy(synthesized.AAAA[12:], body.A[:])
+					resource.Header.Type = dnsmessage.TypeAAAA
+					resource.Body = synthesized
+				}
+				resources = append(resources, resource)
+			}
+			*section = resources
+		}
+	} else if !a.rewrite {
+		return message
+	}
+
+	if a.rewrite {
+		for _, section := range [][]dnsmessage.Resource{msg.Answers, msg.Additionals} {
+			for _, resource := range section {
+				switch body := resource.Body.(type) {
+				case *dnsmessage.AResource:
+					if virtualIP, ok := a.handler.virtualAddressOf(net.IP(body.A[:])); ok && virtualIP.To4() != nil {
+						copy(body.A[:], virtualIP.To4())
+					}
+				case *dnsmessage.AAAAResource:
+					if synthesize {
+						// Synthesized addresses embed the real IPv4 address on purpose
+						continue
+					}
+					if virtualIP, ok := a.handler.virtualAddressOf(net.IP(body.AAAA[:])); ok && virtualIP.To4() == nil {
+						copy(body.AAAA[:], virtualIP.To16())
+					}
+				}
+			}
+		}
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		return message
+	}
+	return packed
+}
@@ -0,0 +1,126 @@
+package nat
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newDNSTestALG(t *testing.T, rewrite, dns64 bool) *dnsALG {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return &dnsALG{handler: handler, rewrite: rewrite, dns64: dns64}
+}
+
+func dnsQuery(t *testing.T, id uint16, qType dnsmessage.Type) []byte {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("db.corp."), Type: qType, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func dnsResponse(t *testing.T, id uint16, qType dnsmessage.Type, addresses ...string) []byte {
+	name := dnsmessage.MustNewName("db.corp.")
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, Response: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qType, Class: dnsmessage.ClassINET}},
+	}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		header := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 60}
+		if v4 := ip.To4(); v4 != nil {
+			body := &dnsmessage.AResource{}
+			copy(body.A[:], v4)
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: body})
+		} else {
+			body := &dnsmessage.AAAAResource{}
+			copy(body.AAAA[:], ip)
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: body})
+		}
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func dnsAnswers(t *testing.T, message []byte) (dnsmessage.Type, []string) {
+	t.Helper()
+	var msg dnsmessage.Message
+	if err := msg.Unpack(message); err != nil {
+		t.Fatal(err)
+	}
+	var answers []string
+	for _, resource := range msg.Answers {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return msg.Questions[0].Type, answers
+}
+
+func TestDNSAnswerRewriting(t *testing.T) {
+	alg := newDNSTestALG(t, true, false)
+	response := alg.rewriteResponse(dnsResponse(t, 1, dnsmessage.TypeA, "192.168.1.20", "198.51.100.7"))
+	if _, answers := dnsAnswers(t, response); len(answers) != 2 || answers[0] != "240.2.2.20" || answers[1] != "198.51.100.7" {
+		t.Errorf("Expected only the real network answer to become virtual, got %v", answers)
+	}
+
+	query := dnsQuery(t, 2, dnsmessage.TypeAAAA)
+	if string(alg.rewriteQuery(query)) != string(query) {
+		t.Error("Expected queries to pass unchanged without dns64")
+	}
+}
+
+func TestDNS64Synthesis(t *testing.T) {
+	alg := newDNSTestALG(t, false, true)
+	query := alg.rewriteQuery(dnsQuery(t, 3, dnsmessage.TypeAAAA))
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || msg.Questions[0].Type != dnsmessage.TypeA {
+		t.Fatalf("Expected the AAAA question to be asked as A, got %v: %v", msg.Questions, err)
+	}
+
+	qType, answers := dnsAnswers(t, alg.rewriteResponse(dnsResponse(t, 3, dnsmessage.TypeA, "192.168.1.20")))
+	if qType != dnsmessage.TypeAAAA || len(answers) != 1 || answers[0] != "64:ff9b:1111::c0a8:114" {
+		t.Errorf("Expected a synthesized AAAA answer, got %v %v", qType, answers)
+	}
+
+	// Answers to A questions of the client stay A answers
+	_, answers = dnsAnswers(t, alg.rewriteResponse(dnsResponse(t, 4, dnsmessage.TypeA, "192.168.1.20")))
+	if len(answers) != 1 || answers[0] != "192.168.1.20" {
+		t.Errorf("Unexpected answers to an A question %v", answers)
+	}
+}
+
+func TestDNSStreamFraming(t *testing.T) {
+	alg := newDNSTestALG(t, true, false)
+	response := dnsResponse(t, 5, dnsmessage.TypeA, "192.168.1.30")
+	message := append([]byte{byte(len(response) >> 8), byte(len(response))}, response...)
+	if dnsMessageLength(message) != len(message) {
+		t.Fatal("Unexpected frame length")
+	}
+
+	rewritten := framed(message, true, alg.rewriteResponse)
+	if dnsMessageLength(rewritten) != len(rewritten) {
+		t.Error("Expected the length prefix to match the rewritten message")
+	}
+	if _, answers := dnsAnswers(t, rewritten[2:]); len(answers) != 1 || answers[0] != "240.2.2.30" {
+		t.Errorf("Unexpected answers %v", answers)
+	}
+}
@@ -0,0 +1,184 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/dice"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/dns"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+const (
+	// domainMinTTL bounds how often a domain rule is re-resolved
+	domainMinTTL = 5 * time.Second
+
+	// domainRetryInterval is how long a failed resolution is not retried
+	domainRetryInterval = 30 * time.Second
+)
+
+// resolvedDomain caches the addresses of a domain rule's virtual destination
+type resolvedDomain struct {
+	sync.Mutex
+	ips     []net.IP
+	expires time.Time
+}
+
+// isDomainDestination reports whether a virtual destination names a domain rather than
+// an address, a network, an embedded IPv4 pattern, a wildcard or a regular expression
+func isDomainDestination(virtual string) bool {
+	if virtual == "" || strings.ContainsAny(virtual, "/:*") {
+		return false
+	}
+	return net.ParseIP(virtual) == nil
+}
+
+// matchesDomain checks if destination is the domain or one of the addresses it resolves to
+func (h *Handler) matchesDomain(destination xnet.Destination, domain string) bool {
+	if destination.Address.Family().IsDomain() {
+		return strings.EqualFold(destination.Address.Domain(), domain)
+	}
+	ip := destination.Address.IP()
+	for _, resolved := range h.resolveDomain(domain) {
+		if resolved.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDomain returns the addresses of a domain, re-resolving them through the DNS feature
+// once their TTL expired. When a resolution fails the previous addresses are kept.
+func (h *Handler) resolveDomain(domain string) []net.IP {
+	if h.dnsClient == nil {
+		return nil
+	}
+	value, _ := h.domains.LoadOrStore(strings.ToLower(domain), new(resolvedDomain))
+	entry := value.(*resolvedDomain)
+
+	entry.Lock()
+	defer entry.Unlock()
+	now := time.Now()
+	if now.Before(entry.expires) {
+		return entry.ips
+	}
+
+	ips, ttl, err := h.dnsClient.LookupIP(domain, dns.IPOption{IPv4Enable: true, IPv6Enable: true})
+	if err != nil {
+		errors.LogInfoInner(context.Background(), err, "failed to resolve NAT rule destination ", domain)
+		entry.expires = now.Add(domainRetryInterval)
+		return entry.ips
+	}
+	expiry := time.Duration(ttl) * time.Second
+	if expiry < domainMinTTL {
+		expiry = domainMinTTL
+	}
+	entry.ips, entry.expires = ips, now.Add(expiry)
+	return entry.ips
+}
+
+// domainStrategies are the domainStrategy values of rules, named as in freedom
+var domainStrategies = map[string]internet.DomainStrategy{
+	"":            internet.DomainStrategy_AS_IS,
+	"asis":        internet.DomainStrategy_AS_IS,
+	"useip":       internet.DomainStrategy_USE_IP,
+	"useipv4":     internet.DomainStrategy_USE_IP4,
+	"useipv6":     internet.DomainStrategy_USE_IP6,
+	"useipv4v6":   internet.DomainStrategy_USE_IP46,
+	"useipv6v4":   internet.DomainStrategy_USE_IP64,
+	"forceip":     internet.DomainStrategy_FORCE_IP,
+	"forceipv4":   internet.DomainStrategy_FORCE_IP4,
+	"forceipv6":   internet.DomainStrategy_FORCE_IP6,
+	"forceipv4v6": internet.DomainStrategy_FORCE_IP46,
+	"forceipv6v4": internet.DomainStrategy_FORCE_IP64,
+}
+
+// ValidateDomainStrategy checks the domainStrategy of a rule
+func ValidateDomainStrategy(strategy string) error {
+	if _, found := domainStrategies[strings.ToLower(strategy)]; !found {
+		return errors.New("unknown domainStrategy ", strategy)
+	}
+	return nil
+}
+
+// ValidateDomainPatterns checks the domains of a rule: names, or "*." followed by a name
+func ValidateDomainPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		name := strings.TrimPrefix(pattern, "*.")
+		if !isDomainDestination(name) || strings.ContainsAny(name, "*") {
+			return errors.New("invalid domain ", pattern)
+		}
+	}
+	return nil
+}
+
+// matchesDomainPattern checks a domain against a name, or against the subdomains of a name for "*." patterns
+func matchesDomainPattern(domain, pattern string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	pattern = strings.ToLower(pattern)
+	if suffix, found := strings.CutPrefix(pattern, "*"); found {
+		return strings.HasSuffix(domain, suffix) && len(domain) > len(suffix)
+	}
+	return domain == pattern
+}
+
+// flowDomain returns the domain of a flow: its destination when that is a domain, or the domain
+// content sniffing found in it when the flow is routed by the sniffed domain but still addressed
+// to the destination IP
+func flowDomain(ctx context.Context, destination xnet.Destination) string {
+	if destination.Address.Family().IsDomain() {
+		return destination.Address.Domain()
+	}
+	return sniffedDomain(ctx)
+}
+
+// sniffedDomain returns the domain sniffed from a flow addressed to an IP, or ""
+func sniffedDomain(ctx context.Context) string {
+	outbounds := session.OutboundsFromContext(ctx)
+	if len(outbounds) == 0 {
+		return ""
+	}
+	if target := outbounds[len(outbounds)-1].RouteTarget; target.Address != nil && target.Address.Family().IsDomain() {
+		return target.Address.Domain()
+	}
+	return ""
+}
+
+// resolveRealDestination resolves a real destination hostname through xray DNS according to the
+// rule's domain strategy. Without a strategy, or when a non-forcing resolution fails, the hostname
+// is left to the dialer.
+func (h *Handler) resolveRealDestination(ctx context.Context, destination xnet.Destination, rule *NATRule) (xnet.Destination, error) {
+	strategy := domainStrategies[strings.ToLower(rule.DomainStrategy)]
+	if !destination.Address.Family().IsDomain() || !strategy.HasStrategy() {
+		return destination, nil
+	}
+	domain := destination.Address.Domain()
+
+	var ips []net.IP
+	var err error = errors.New("DNS client not available")
+	if h.dnsClient != nil {
+		ips, _, err = h.dnsClient.LookupIP(domain, dns.IPOption{IPv4Enable: strategy.PreferIP4(), IPv6Enable: strategy.PreferIP6()})
+		if (err != nil || len(ips) == 0) && strategy.HasFallback() {
+			ips, _, err = h.dnsClient.LookupIP(domain, dns.IPOption{IPv4Enable: strategy.FallbackIP4(), IPv6Enable: strategy.FallbackIP6()})
+		}
+		if err == nil && len(ips) == 0 {
+			err = dns.ErrEmptyResponse
+		}
+	}
+	if err != nil {
+		if strategy.ForceIP() {
+			return destination, errors.New("failed to resolve real destination ", domain).Base(err)
+		}
+		errors.LogInfoInner(ctx, err, "failed to resolve real destination ", domain, ", leaving it to the dialer")
+		return destination, nil
+	}
+
+	destination.Address = xnet.IPAddress(ips[dice.Roll(len(ips))])
+	return destination, nil
+}
@@ -0,0 +1,122 @@
+package nat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/dns"
+)
+
+// fakeDNS answers every lookup with its current addresses and TTL
+type fakeDNS struct {
+	ips     []xnet.IP
+	ttl     uint32
+	fail    bool
+	lookups int
+	option  dns.IPOption
+}
+
+func (*fakeDNS) Type() interface{} { return dns.ClientType() }
+func (*fakeDNS) Start() error      { return nil }
+func (*fakeDNS) Close() error      { return nil }
+
+func (d *fakeDNS) LookupIP(domain string, option dns.IPOption) ([]xnet.IP, uint32, error) {
+	d.lookups++
+	d.option = option
+	if d.fail {
+		return nil, 0, errors.New("server failure")
+	}
+	return d.ips, d.ttl, nil
+}
+
+func TestDomainRules(t *testing.T) {
+	resolver := &fakeDNS{ips: []xnet.IP{xnet.ParseIP("240.2.2.20")}, ttl: 60}
+	handler := &Handler{
+		dnsClient: resolver,
+		config: &Config{Rules: []*NATRule{
+			{RuleId: "app", VirtualDestination: "app.internal.example", RealDestination: "192.168.1.20"},
+		}},
+	}
+	match := func(address string) bool {
+		_, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(address), 443))
+		return ok
+	}
+
+	if !match("App.Internal.Example") {
+		t.Error("Expected the domain itself to match")
+	}
+	if !match("240.2.2.20") || !match("240.2.2.20") {
+		t.Error("Expected the resolved address to match")
+	}
+	if match("240.2.2.21") {
+		t.Error("Addresses the domain does not resolve to must not match")
+	}
+	if resolver.lookups != 1 {
+		t.Errorf("Expected one lookup within the TTL, got %d", resolver.lookups)
+	}
+
+	// The record changes; once the cached answer expires the rule follows it
+	resolver.ips = []xnet.IP{xnet.ParseIP("240.2.2.21")}
+	entry, _ := handler.domains.Load("app.internal.example")
+	entry.(*resolvedDomain).expires = time.Now()
+	if !match("240.2.2.21") || match("240.2.2.20") {
+		t.Error("Expected the rule to follow the re-resolved address")
+	}
+
+	// A failing resolver keeps the last known addresses
+	resolver.fail = true
+	entry.(*resolvedDomain).expires = time.Now()
+	if !match("240.2.2.21") {
+		t.Error("Expected the previous addresses to survive a failed resolution")
+	}
+}
+
+func TestDomainWithRanges(t *testing.T) {
+	handler := &Handler{
+		dnsClient: &fakeDNS{},
+		config: &Config{
+			Rules:         []*NATRule{{RuleId: "app", VirtualDestination: "app.internal.example", RealDestination: "192.168.1.20"}},
+			VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.0.0.0/8", RealNetwork: "192.168.0.0/16", Ipv6Enabled: true, Ipv6VirtualPrefix: "64:ff9b::"}},
+		},
+	}
+	for domain, expected := range map[string]bool{"app.internal.example": true, "other.example": false} {
+		if _, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.DomainAddress(domain), 443)); ok != expected {
+			t.Errorf("Domain %s: expected translate=%v", domain, expected)
+		}
+	}
+}
+
+func TestResolveRealDestination(t *testing.T) {
+	resolver := &fakeDNS{ips: []xnet.IP{xnet.ParseIP("192.168.1.20")}, ttl: 60}
+	handler := &Handler{dnsClient: resolver}
+	backend := xnet.TCPDestination(xnet.ParseAddress("backend.dyndns.example"), 443)
+
+	dest, err := handler.resolveRealDestination(context.Background(), backend, &NATRule{})
+	if err != nil || dest.Address.String() != "backend.dyndns.example" || resolver.lookups != 0 {
+		t.Errorf("Expected AsIs to leave the hostname to the dialer, got %v, %v", dest, err)
+	}
+
+	dest, err = handler.resolveRealDestination(context.Background(), backend, &NATRule{DomainStrategy: "UseIPv4"})
+	if err != nil || dest.Address.String() != "192.168.1.20" || dest.Port != 443 {
+		t.Errorf("Expected the resolved backend, got %v, %v", dest, err)
+	}
+	if !resolver.option.IPv4Enable || resolver.option.IPv6Enable {
+		t.Errorf("UseIPv4 must only ask for IPv4 answers, got %+v", resolver.option)
+	}
+
+	resolver.fail = true
+	dest, err = handler.resolveRealDestination(context.Background(), backend, &NATRule{DomainStrategy: "UseIP"})
+	if err != nil || dest.Address.String() != "backend.dyndns.example" {
+		t.Errorf("Expected a failed UseIP resolution to fall back to the hostname, got %v, %v", dest, err)
+	}
+	if _, err := handler.resolveRealDestination(context.Background(), backend, &NATRule{DomainStrategy: "ForceIPv6"}); err == nil {
+		t.Error("Expected ForceIPv6 to fail without an answer")
+	}
+
+	if ValidateDomainStrategy("UseIPv4v6") != nil || ValidateDomainStrategy("PreferIPv4") == nil {
+		t.Error("Unexpected domainStrategy validation")
+	}
+}
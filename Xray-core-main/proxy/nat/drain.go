@@ -0,0 +1,140 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// What happens to new flows to virtual destinations while the gateway drains
+const (
+	DrainReject = "reject" // Closed at once
+	DrainBypass = "bypass" // Left untranslated, like flows of a bypass rule
+)
+
+// ErrDraining is the cause of errors refusing a new flow because the gateway drains for
+// maintenance
+var ErrDraining = errors.New("NAT gateway is draining")
+
+// DrainStatus reports the progress of a drain
+type DrainStatus struct {
+	Draining bool
+	Action   string
+	Since    time.Time
+	Deadline time.Time // When the remaining sessions are closed, zero to let them finish
+	Sessions int64     // Sessions left
+}
+
+// drainState is a drain in progress. It is replaced, never modified, by a later Drain.
+type drainState struct {
+	action   string
+	since    time.Time
+	deadline time.Time
+	timer    *time.Timer // Closes the remaining sessions at the deadline, nil without one
+}
+
+// Drain stops the gateway from creating sessions for new flows, which are rejected or bypass the
+// translation as action says, while the existing sessions finish. After deadline, when not 0, the
+// sessions left are closed. Draining again changes the action and restarts the deadline.
+func (h *Handler) Drain(action string, deadline time.Duration) (DrainStatus, error) {
+	if action == "" {
+		action = DrainReject
+	}
+	if action != DrainReject && action != DrainBypass {
+		return DrainStatus{}, errors.New("unknown NAT drain action ", action)
+	}
+
+	h.drainAccess.Lock()
+	defer h.drainAccess.Unlock()
+	now := time.Now()
+	state := &drainState{action: action, since: now}
+	if previous := h.drain.Load(); previous != nil {
+		state.since = previous.since
+		previous.stop()
+	}
+	if deadline > 0 {
+		state.deadline = now.Add(deadline)
+		state.timer = time.AfterFunc(deadline, func() {
+			h.drainDeadline(state)
+		})
+	}
+	h.drain.Store(state)
+	h.syncOffload()
+	errors.LogInfo(context.Background(), "NAT: draining, new flows ", action, ", ", atomic.LoadInt64(&h.activeSessions), " sessions left")
+	return h.DrainStatus(), nil
+}
+
+// Resume ends a drain; new flows are translated again. The sessions left are kept.
+func (h *Handler) Resume() DrainStatus {
+	h.drainAccess.Lock()
+	defer h.drainAccess.Unlock()
+	if previous := h.drain.Swap(nil); previous != nil {
+		previous.stop()
+		h.syncOffload()
+		errors.LogInfo(context.Background(), "NAT: drain ended, accepting new flows")
+	}
+	return h.DrainStatus()
+}
+
+// DrainStatus reports whether the gateway drains and how many sessions are left
+func (h *Handler) DrainStatus() DrainStatus {
+	status := DrainStatus{Sessions: atomic.LoadInt64(&h.activeSessions)}
+	if state := h.drain.Load(); state != nil {
+		status.Draining = true
+		status.Action = state.action
+		status.Since = state.since
+		status.Deadline = state.deadline
+	}
+	return status
+}
+
+func (s *drainState) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// drainDeadline closes the sessions left when the deadline of a drain passes, unless the drain
+// was ended or replaced meanwhile
+func (h *Handler) drainDeadline(state *drainState) {
+	h.drainAccess.Lock()
+	current := h.drain.Load() == state
+	h.drainAccess.Unlock()
+	if !current {
+		return
+	}
+	flushed := h.FlushSessions("")
+	errors.LogInfo(context.Background(), "NAT: drain deadline passed, closed ", flushed, " sessions")
+	h.Audit(&AuditEvent{Action: AuditFlushSessions, Actor: "drain deadline", Detail: fmt.Sprint("flushed=", flushed)})
+}
+
+// drainFlow handles a new flow to a virtual destination while the gateway drains, counted as
+// drained
+func (h *Handler) drainFlow(ctx context.Context, link *transport.Link, destination xnet.Destination, dialer internet.Dialer, state *drainState) error {
+	atomic.AddInt64(&h.drained, 1)
+	if state.action == DrainBypass {
+		errors.LogDebug(ctx, "NAT: draining, ", destination, " left untranslated")
+		return h.handleNormalOutbound(ctx, link, destination, dialer)
+	}
+	common.Interrupt(link.Reader)
+	common.Interrupt(link.Writer)
+	return errors.New("flow to ", destination, " rejected").Base(ErrDraining)
+}
+
+// refuseWhileDraining is called before a session is created for a flow Process does not handle,
+// an echo query or a flow from the real side. Such flows are refused whatever the drain action,
+// and counted as drained.
+func (h *Handler) refuseWhileDraining() error {
+	if h.drain.Load() == nil {
+		return nil
+	}
+	atomic.AddInt64(&h.drained, 1)
+	return errors.New("refusing a new flow").Base(ErrDraining)
+}
@@ -0,0 +1,117 @@
+package nat
+
+import (
+	"context"
+	goerrors "errors"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// recordingDialer records the first destination dialed, failing every dial
+type recordingDialer struct {
+	udpTestDialer
+	dialed chan xnet.Destination
+}
+
+func (d recordingDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	select {
+	case d.dialed <- dest:
+	default:
+	}
+	return nil, errors.New("flow left the NAT")
+}
+
+func TestDrain(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	existing := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	handler.createNATSession(xnet.Destination{}, existing, existing, "outbound", classInteractive).RuleID = "web"
+
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 443)
+	process := func() (error, xnet.Destination) {
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)})
+		ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: destination}})
+		uplinkReader, _ := pipe.New()
+		_, downlinkWriter := pipe.New()
+		dialer := recordingDialer{dialed: make(chan xnet.Destination, 1)}
+		err := handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, dialer)
+		select {
+		case dialed := <-dialer.dialed:
+			return err, dialed
+		default:
+			return err, xnet.Destination{}
+		}
+	}
+
+	if _, err := handler.Drain("drop", 0); err == nil {
+		t.Error("Expected an unknown drain action to be rejected")
+	}
+
+	// Rejecting: new flows are refused, existing sessions are kept
+	status, err := handler.Drain("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Draining || status.Action != DrainReject || status.Sessions != 1 || !status.Deadline.IsZero() {
+		t.Errorf("Unexpected drain status %+v", status)
+	}
+	if err, dialed := process(); !goerrors.Is(err, ErrDraining) || dialed.Address != nil {
+		t.Errorf("Expected the new flow to be rejected with ErrDraining, got %v dialing %v", err, dialed)
+	}
+	if err := handler.refuseWhileDraining(); !goerrors.Is(err, ErrDraining) {
+		t.Errorf("Expected flows from the real side to be refused, got %v", err)
+	}
+
+	// Bypassing: new flows leave to the virtual destination untranslated
+	if _, err := handler.Drain(DrainBypass, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err, dialed := process(); goerrors.Is(err, ErrDraining) || dialed != destination {
+		t.Errorf("Expected the new flow to bypass the translation, got %v dialing %v", err, dialed)
+	}
+	if stats := handler.Stats(); stats.Drained != 3 || stats.ActiveSessions != 1 {
+		t.Errorf("Expected 3 drained flows and the existing session kept, got %+v", stats)
+	}
+
+	// At the deadline the sessions left are closed
+	since := handler.DrainStatus().Since
+	status, err = handler.Drain(DrainReject, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Since != since || status.Deadline.IsZero() {
+		t.Errorf("Expected draining again to keep its start and set a deadline, got %+v", status)
+	}
+	for deadline := time.Now().Add(time.Second); handler.DrainStatus().Sessions != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the sessions left to be closed at the deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Resuming translates new flows again
+	if status := handler.Resume(); status.Draining {
+		t.Errorf("Expected the drain to end, got %+v", status)
+	}
+	if err, dialed := process(); goerrors.Is(err, ErrDraining) || dialed.Address.String() != "192.168.1.20" {
+		t.Errorf("Expected the new flow to be translated, got %v dialing %v", err, dialed)
+	}
+	if err := handler.refuseWhileDraining(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package nat
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// Why a session was evicted
+const (
+	EvictSessionLimit     = "sessionLimit"     // The session table held maxSessions
+	EvictMemoryLimit      = "memoryLimit"      // The sessions would exceed maxMemoryMB
+	EvictNoisiestSource   = "noisiestSource"   // The oldest session of the source holding the most, with dropNoisiestSources
+	EvictCleanupThreshold = "cleanupThreshold" // Idle for longer than its timeout shortened above the cleanup threshold
+)
+
+// Session classes of rules, from the first evicted to the last
+const (
+	SessionClassBulk         = "bulk"
+	SessionClassInteractive  = "interactive"
+	SessionClassControlPlane = "controlPlane"
+)
+
+// sessionClass orders the eviction of sessions: the session and memory limits evict the least
+// recently active session of the lowest class holding any
+type sessionClass uint8
+
+const (
+	classBulk sessionClass = iota
+	classInteractive
+	classControlPlane
+	sessionClassCount
+)
+
+var sessionClassNames = [sessionClassCount]string{SessionClassBulk, SessionClassInteractive, SessionClassControlPlane}
+
+func (c sessionClass) String() string {
+	return sessionClassNames[c]
+}
+
+// ValidateSessionClass checks the session class of a rule
+func ValidateSessionClass(class string) error {
+	switch class {
+	case "", SessionClassBulk, SessionClassInteractive, SessionClassControlPlane:
+		return nil
+	default:
+		return errors.New(class, " is not controlPlane, interactive or bulk")
+	}
+}
+
+// classOf returns the session class of a rule, interactive unless set
+func classOf(rule *NATRule) sessionClass {
+	switch rule.GetSessionClass() {
+	case SessionClassBulk:
+		return classBulk
+	case SessionClassControlPlane:
+		return classControlPlane
+	default:
+		return classInteractive
+	}
+}
+
+// classOfRule returns the session class of the active rule of ruleID, interactive when none is
+func (h *Handler) classOfRule(ruleID string) sessionClass {
+	for _, rule := range h.activeRules() {
+		if rule.RuleId == ruleID {
+			return classOf(rule)
+		}
+	}
+	return classInteractive
+}
+
+// EvictHook is called with each session evicted to make room for a new one, after its flow was
+// torn down. It runs on the path creating the new session and must not block.
+type EvictHook func(session *NATSession, reason string)
+
+// OnEvict adds a hook called when a session is evicted, for logging or metrics
+func (h *Handler) OnEvict(hook EvictHook) {
+	h.evictHooksAccess.Lock()
+	defer h.evictHooksAccess.Unlock()
+	var hooks []EvictHook
+	if current := h.evictHooks.Load(); current != nil {
+		hooks = append(hooks, *current...)
+	}
+	hooks = append(hooks, hook)
+	h.evictHooks.Store(&hooks)
+}
+
+// evictSession drops a session taken out of the session table to make room, ending the flow
+// relaying it, and reports it to the hooks
+func (h *Handler) evictSession(session *NATSession, reason string) {
+	atomic.AddInt64(&h.evictions, 1)
+	atomic.AddInt64(&h.classEvictions[session.class], 1)
+	endSession(session, SessionEndEvicted)
+	h.dropSession(session)
+	errors.LogDebug(context.Background(), "NAT: evicted session ", session.SessionID, " of ", session.VirtualSource, " to ", session.VirtualDest, " (", reason, ")")
+	if hooks := h.evictHooks.Load(); hooks != nil {
+		for _, hook := range *hooks {
+			hook(session, reason)
+		}
+	}
+}
+
+// bindSession ties the flow relaying a session to it: the returned context is canceled when the
+// session is dropped, so a session evicted, flushed or expired stops being relayed instead of
+// copying on untracked. TCP flows hand the teardown over to their tcpFlow. The returned cancel
+// must be called once the flow ends.
+func bindSession(ctx context.Context, session *NATSession) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	session.cancel.Store(&cancel)
+	return ctx, cancel
+}
@@ -0,0 +1,124 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestEvictSession(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	type eviction struct {
+		session *NATSession
+		reason  string
+	}
+	evicted := make(chan eviction, 2)
+	handler.OnEvict(func(session *NATSession, reason string) {
+		evicted <- eviction{session, reason}
+	})
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	first := handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+	ctx, cancel := bindSession(context.Background(), first)
+	defer cancel()
+
+	// The new session evicts the oldest, whose flow is cancelled
+	second := handler.createNATSession(source, xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 443), dest, "outbound", classInteractive)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the flow of the evicted session to be cancelled")
+	}
+	select {
+	case e := <-evicted:
+		if e.session != first || e.reason != EvictSessionLimit {
+			t.Errorf("Expected the first session to be evicted over the session limit, got %s (%s)", e.session.SessionID, e.reason)
+		}
+	default:
+		t.Fatal("Expected the eviction hook to be called")
+	}
+	if stats := handler.Stats(); stats.Evictions != 1 || stats.ActiveSessions != 1 {
+		t.Errorf("Expected 1 eviction and the new session kept, got %+v", stats)
+	}
+
+	// Sessions dropped otherwise cancel their flow without being reported as evicted
+	ctx, cancel = bindSession(context.Background(), second)
+	defer cancel()
+	handler.removeSession(second.SessionID)
+	if ctx.Err() == nil {
+		t.Error("Expected the flow of a removed session to be cancelled")
+	}
+	if len(evicted) != 0 {
+		t.Error("Expected a removed session not to be reported as evicted")
+	}
+}
+
+func TestEvictSessionClasses(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "class-site",
+		Limits: &ResourceLimits{MaxSessions: 3},
+		Rules: []*NATRule{
+			{RuleId: "bgp", VirtualDestination: "240.2.2.10", RealDestination: "192.168.1.10", SessionClass: SessionClassControlPlane},
+			{RuleId: "ssh", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+			{RuleId: "backup", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30", SessionClass: SessionClassBulk},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(ruleID string, address string, port xnet.Port) *NATSession {
+		source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), port)
+		dest := xnet.TCPDestination(xnet.ParseAddress(address), 80)
+		return handler.createNATSession(source, dest, dest, "outbound", handler.classOfRule(ruleID))
+	}
+	bgp := open("bgp", "240.2.2.10", 40000)
+	ssh := open("ssh", "240.2.2.20", 40001)
+	backup := open("backup", "240.2.2.30", 40002)
+	if bgp.class != classControlPlane || ssh.class != classInteractive || backup.class != classBulk {
+		t.Fatalf("Unexpected session classes %s, %s and %s", bgp.class, ssh.class, backup.class)
+	}
+
+	// The bulk session goes first although it is the most recently active one
+	open("ssh", "240.2.2.20", 40003)
+	if _, exists := handler.sessions.Load(backup.SessionID); exists {
+		t.Error("Expected the bulk session to be evicted first")
+	}
+	// Then the least recently active interactive session, the control-plane session last
+	open("ssh", "240.2.2.20", 40004)
+	if _, exists := handler.sessions.Load(ssh.SessionID); exists {
+		t.Error("Expected the oldest interactive session to be evicted next")
+	}
+	if _, exists := handler.sessions.Load(bgp.SessionID); !exists {
+		t.Error("Expected the control-plane session to be kept")
+	}
+
+	stats := handler.Stats()
+	if stats.Evictions != 2 || stats.ClassEvictions[SessionClassBulk] != 1 || stats.ClassEvictions[SessionClassInteractive] != 1 || stats.ClassEvictions[SessionClassControlPlane] != 0 {
+		t.Errorf("Expected an eviction of a bulk and an interactive session, got %+v", stats)
+	}
+
+	var out bytes.Buffer
+	if err := WritePrometheusMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`xray_nat_class_evictions_total{siteId="class-site",class="bulk"} 1`,
+		`xray_nat_class_evictions_total{siteId="class-site",class="interactive"} 1`,
+		`xray_nat_class_evictions_total{siteId="class-site",class="controlPlane"} 0`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+const (
+	// exhaustionLogInterval is the least time between two logs of the top talkers
+	exhaustionLogInterval = 10 * time.Second
+	// topTalkerCount is how many sources holding the most sessions are logged on exhaustion
+	topTalkerCount = 5
+)
+
+// ErrExhausted is the cause of errors refusing a flow because a source port pool or the session
+// table is exhausted, so callers can tell backpressure from failures with errors.Is
+var ErrExhausted = errors.New("NAT resources exhausted")
+
+// talker is a virtual source address and the sessions it holds
+type talker struct {
+	source   string
+	sessions int
+}
+
+// topTalkers returns the n virtual source addresses holding the most sessions, most first
+func (h *Handler) topTalkers(n int) []talker {
+	counts := make(map[string]int)
+	h.sessions.Range(func(session *NATSession) bool {
+		if session.VirtualSource.Address != nil {
+			counts[session.VirtualSource.Address.String()]++
+		}
+		return true
+	})
+	talkers := make([]talker, 0, len(counts))
+	for source, sessions := range counts {
+		talkers = append(talkers, talker{source: source, sessions: sessions})
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].sessions != talkers[j].sessions {
+			return talkers[i].sessions > talkers[j].sessions
+		}
+		return talkers[i].source < talkers[j].source
+	})
+	if len(talkers) > n {
+		talkers = talkers[:n]
+	}
+	return talkers
+}
+
+// recordExhaustion counts an exhaustion of a source port pool or of the session table into
+// counter and logs the top talkers, at most once per exhaustionLogInterval
+func (h *Handler) recordExhaustion(ctx context.Context, counter *int64, err error) {
+	atomic.AddInt64(counter, 1)
+
+	now := time.Now().UnixNano()
+	last := h.exhaustionLogged.Load()
+	if now-last < int64(exhaustionLogInterval) || !h.exhaustionLogged.CompareAndSwap(last, now) {
+		return
+	}
+	var top []string
+	for _, t := range h.topTalkers(topTalkerCount) {
+		top = append(top, fmt.Sprint(t.source, "=", t.sessions))
+	}
+	errors.LogWarningInner(ctx, err, "NAT: exhausted, sources holding the most sessions: ", strings.Join(top, ", "))
+}
+
+// admitSession is called before a session of a new flow from source is created. With the session
+// table full it counts the exhaustion and, when dropping the noisiest sources, makes room by
+// dropping the oldest session of the source holding the most, or refuses the flow when that
+// source is its own. Otherwise the least recently used session makes room.
+func (h *Handler) admitSession(ctx context.Context, source xnet.Destination) error {
+	if atomic.LoadInt64(&h.activeSessions) < h.maxSessions {
+		return nil
+	}
+	err := errors.New("session table full at ", h.maxSessions, " sessions").Base(ErrExhausted)
+	h.recordExhaustion(ctx, &h.sessionExhaustions, err)
+	if h.config.Limits == nil || !h.config.Limits.DropNoisiestSources {
+		return nil
+	}
+
+	top := h.topTalkers(1)
+	if len(top) == 0 {
+		return nil
+	}
+	if source.Address != nil && source.Address.String() == top[0].source {
+		return errors.New("refusing a new flow of ", top[0].source, ", the source holding the most sessions").Base(err)
+	}
+	h.evictOldestOf(top[0].source)
+	return nil
+}
+
+// evictOldestOf drops the least recently active session of a virtual source address
+func (h *Handler) evictOldestOf(source string) {
+	var oldest *NATSession
+	h.sessions.Range(func(session *NATSession) bool {
+		if session.VirtualSource.Address != nil && session.VirtualSource.Address.String() == source &&
+			(oldest == nil || session.LastActivity().Before(oldest.LastActivity())) {
+			oldest = session
+		}
+		return true
+	})
+	if oldest == nil {
+		return
+	}
+	if _, loaded := h.sessions.LoadAndDelete(oldest.SessionID); loaded {
+		h.evictSession(oldest, EvictNoisiestSource)
+	}
+}
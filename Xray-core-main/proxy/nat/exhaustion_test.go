@@ -0,0 +1,112 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	goerrors "errors"
+	"strings"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestAdmitSession(t *testing.T) {
+	ctx := context.Background()
+	for _, dropNoisiest := range []bool{false, true} {
+		handler := New()
+		if err := handler.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 3, DropNoisiestSources: dropNoisiest}}, nil); err != nil {
+			t.Fatal(err)
+		}
+		open := func(source string, port xnet.Port) *NATSession {
+			src := xnet.TCPDestination(xnet.ParseAddress(source), port)
+			if err := handler.admitSession(ctx, src); err != nil {
+				t.Fatalf("Expected a flow of %s to be admitted, got %v", source, err)
+			}
+			dest := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+			return handler.createNATSession(src, dest, dest, "outbound", classInteractive)
+		}
+		noisy := open("10.0.0.5", 40000)
+		open("10.0.0.5", 40001)
+		quiet := open("10.0.0.6", 40000)
+
+		// The table is full: without dropping the noisiest sources, the least recently used
+		// session makes room as the new session is created
+		if !dropNoisiest {
+			open("10.0.0.7", 40000)
+			if _, found := handler.sessions.Load(noisy.SessionID); found {
+				t.Error("Expected the least recently used session to be evicted")
+			}
+			if stats := handler.Stats(); stats.SessionExhaustions != 1 {
+				t.Errorf("Expected 1 session exhaustion, got %d", stats.SessionExhaustions)
+			}
+			handler.Close()
+			continue
+		}
+
+		// The oldest session of the source holding the most sessions makes room for another source
+		open("10.0.0.7", 40000)
+		if _, found := handler.sessions.Load(noisy.SessionID); found {
+			t.Error("Expected the oldest session of the noisiest source to be dropped")
+		}
+		if _, found := handler.sessions.Load(quiet.SessionID); !found {
+			t.Error("Expected the session of the quiet source to be kept")
+		}
+
+		// The noisiest source itself is refused
+		open("10.0.0.6", 40001)
+		err := handler.admitSession(ctx, xnet.TCPDestination(xnet.ParseAddress("10.0.0.6"), 40002))
+		if !goerrors.Is(err, ErrExhausted) {
+			t.Errorf("Expected the noisiest source to be refused with ErrExhausted, got %v", err)
+		}
+		if stats := handler.Stats(); stats.SessionExhaustions != 3 || stats.ActiveSessions != 3 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+
+		if top := handler.topTalkers(1); len(top) != 1 || top[0].source != "10.0.0.6" || top[0].sessions != 2 {
+			t.Errorf("Unexpected top talkers %v", top)
+		}
+		handler.Close()
+	}
+}
+
+func TestPortExhaustion(t *testing.T) {
+	ctx := context.Background()
+	table, err := newMasqueradeTable(&VirtualIPRange{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePorts: "20000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	if _, err := table.Allocate(ctx, xnet.Network_TCP, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Allocate(ctx, xnet.Network_TCP, source); !goerrors.Is(err, ErrExhausted) {
+		t.Errorf("Expected masquerade port exhaustion to be ErrExhausted, got %v", err)
+	}
+
+	allocator, err := newPortBlockAllocator(&PortBlockAllocation{PublicAddress: "203.0.113.1", PortRangeStart: 1024, PortRangeEnd: 1025, BlockSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := allocator.Allocate(ctx, source.Address.IP()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := allocator.Allocate(ctx, source.Address.IP()); !goerrors.Is(err, ErrExhausted) {
+		t.Errorf("Expected CGNAT port exhaustion to be ErrExhausted, got %v", err)
+	}
+
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "exhausted-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	handler.recordExhaustion(ctx, &handler.portExhaustions, err)
+	var out bytes.Buffer
+	if err := WritePrometheusMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `xray_nat_port_exhaustion_total{siteId="exhausted-site"} 1`) {
+		t.Errorf("Expected the port exhaustion to be counted, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,202 @@
+package nat
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// ExpiredRule is a rule of the rules file, the controller or added through the API removed once
+// its ttl or expiresAt passed. It stays expired while the rule is still listed unchanged.
+type ExpiredRule struct {
+	Rule      *NATRule
+	ExpiredAt time.Time
+}
+
+// ruleExpiry tracks the deadlines of the rules carrying ttl or expiresAt. Its lock also orders
+// the swaps of the active rules by reloads and expiries.
+type ruleExpiry struct {
+	sync.Mutex
+	deadlines map[*NATRule]time.Time   // Active rules with a deadline, by identity
+	expired   map[string][]ExpiredRule // By ruleKey, rules sharing a key in their order
+	timer     *time.Timer
+}
+
+// deadlineOf returns when a rule added at now expires, zero when it does not
+func deadlineOf(rule *NATRule, now time.Time) time.Time {
+	var deadline time.Time
+	if rule.Ttl != 0 {
+		deadline = now.Add(time.Duration(rule.Ttl) * time.Second)
+	}
+	if rule.ExpiresAt != 0 {
+		if at := time.Unix(rule.ExpiresAt, 0); deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+	return deadline
+}
+
+// stillExpired reports whether a loaded rule expired before, unchanged since, and records the
+// rules loaded past their expiresAt
+func (e *ruleExpiry) stillExpired(key string, rule *NATRule, now time.Time) bool {
+	for _, expired := range e.expired[key] {
+		if proto.Equal(expired.Rule, rule) {
+			return true
+		}
+	}
+	if rule.ExpiresAt != 0 && !now.Before(time.Unix(rule.ExpiresAt, 0)) {
+		if e.expired == nil {
+			e.expired = make(map[string][]ExpiredRule)
+		}
+		e.expired[key] = append(e.expired[key], ExpiredRule{Rule: rule, ExpiredAt: time.Unix(rule.ExpiresAt, 0)})
+		return true
+	}
+	return false
+}
+
+// track sets the deadlines of the rules following the configured ones after a reload at now.
+// Unchanged rules keep theirs; the expired rules no longer loaded, or loaded changed, are
+// forgotten.
+func (e *ruleExpiry) track(rules []*NATRule, loaded map[string][]*NATRule, now time.Time) {
+	deadlines := make(map[*NATRule]time.Time)
+	for _, rule := range rules {
+		deadline, found := e.deadlines[rule]
+		if !found {
+			deadline = deadlineOf(rule, now)
+		}
+		if !deadline.IsZero() {
+			deadlines[rule] = deadline
+		}
+	}
+	e.deadlines = deadlines
+
+	for key, expired := range e.expired {
+		kept := expired[:0]
+		for _, entry := range expired {
+			for _, rule := range loaded[key] {
+				if proto.Equal(entry.Rule, rule) {
+					kept = append(kept, entry)
+					break
+				}
+			}
+		}
+		if len(kept) == 0 {
+			delete(e.expired, key)
+		} else {
+			e.expired[key] = kept
+		}
+	}
+}
+
+// schedule arms the timer for the earliest deadline, calling expire then
+func (e *ruleExpiry) schedule(now time.Time, expire func()) {
+	var next time.Time
+	for _, deadline := range e.deadlines {
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	switch {
+	case next.IsZero():
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+	case e.timer == nil:
+		e.timer = time.AfterFunc(next.Sub(now), expire)
+	default:
+		e.timer.Reset(next.Sub(now))
+	}
+}
+
+// stop stops the timer for good
+func (e *ruleExpiry) stop() {
+	e.Lock()
+	defer e.Unlock()
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.deadlines = nil
+}
+
+// expireRules removes the rules whose deadline passed from the active rules
+func (h *Handler) expireRules() {
+	h.expiry.Lock()
+	defer h.expiry.Unlock()
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+
+	now := time.Now()
+	active := h.activeRules()
+	rules := make([]*NATRule, 0, len(active))
+	var expired []string
+	var stale []*NATRule
+	var changes []AuditRuleChange
+	for _, rule := range active {
+		deadline, found := h.expiry.deadlines[rule]
+		if !found || now.Before(deadline) {
+			rules = append(rules, rule)
+			continue
+		}
+		key := ruleKey(rule)
+		if h.expiry.expired == nil {
+			h.expiry.expired = make(map[string][]ExpiredRule)
+		}
+		h.expiry.expired[key] = append(h.expiry.expired[key], ExpiredRule{Rule: rule, ExpiredAt: deadline})
+		delete(h.expiry.deadlines, rule)
+		expired = append(expired, key)
+		stale = append(stale, rule)
+		changes = append(changes, AuditRuleChange{Rule: key, Before: auditedRule(rule)})
+	}
+	if len(expired) > 0 {
+		h.addedRules = slices.DeleteFunc(h.addedRules, func(rule *NATRule) bool {
+			return slices.Contains(stale, rule)
+		})
+		h.rules.Store(&rules)
+		for _, rule := range stale {
+			h.forgetRule(rule)
+		}
+		errors.LogInfo(context.Background(), "NAT rules expired: ", strings.Join(expired, ", "))
+		if h.auditLog != nil {
+			sort.Slice(changes, func(i, j int) bool {
+				return changes[i].Rule < changes[j].Rule
+			})
+			h.Audit(&AuditEvent{Action: AuditRulesExpire, Actor: "rule expiry", Changes: changes})
+		}
+		h.syncOffload()
+		if h.distribution != nil {
+			h.distribution.publish()
+		}
+	}
+	h.expiry.schedule(now, h.expireRules)
+}
+
+// RuleExpiry returns when an active rule expires, zero when it does not
+func (h *Handler) RuleExpiry(rule *NATRule) time.Time {
+	h.expiry.Lock()
+	defer h.expiry.Unlock()
+	return h.expiry.deadlines[rule]
+}
+
+// ExpiredRules returns the rules that expired and are still listed by the rules file or the
+// controller, by the time they expired
+func (h *Handler) ExpiredRules() []ExpiredRule {
+	h.expiry.Lock()
+	defer h.expiry.Unlock()
+	var rules []ExpiredRule
+	for _, entries := range h.expiry.expired {
+		rules = append(rules, entries...)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].ExpiredAt.Before(rules[j].ExpiredAt)
+	})
+	return rules
+}
@@ -0,0 +1,139 @@
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRuleExpiry(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:   "test-site",
+		Rules:    []*NATRule{{RuleId: "static", VirtualDestination: "240.2.2.10", RealDestination: "192.168.1.10"}},
+		AuditLog: &SessionLog{Sink: "file", Path: auditPath},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	matched := func(ip string) string {
+		rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(ip), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	past := time.Now().Add(-time.Minute).Unix()
+	loaded := []*NATRule{
+		{RuleId: "maintenance", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Ttl: 3600},
+		{RuleId: "forward", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		{RuleId: "over", VirtualDestination: "240.2.2.40", RealDestination: "192.168.1.40", ExpiresAt: past},
+		{RuleId: "web", VirtualDestination: "240.2.2.50", RealDestination: "192.168.1.50"},
+	}
+	clone := func() []*NATRule {
+		rules := make([]*NATRule, len(loaded))
+		for i, rule := range loaded {
+			rules[i] = proto.Clone(rule).(*NATRule)
+		}
+		return rules
+	}
+	handler.applyRules(clone(), "test")
+
+	// Rules loaded past their expiresAt never become active
+	if id := matched("240.2.2.40"); id != "" {
+		t.Errorf("Expected the rule past its expiresAt to stay out, got %q", id)
+	}
+	if expired := handler.ExpiredRules(); len(expired) != 1 || expired[0].Rule.RuleId != "over" || expired[0].ExpiredAt.Unix() != past {
+		t.Errorf("Unexpected expired rules %v", expired)
+	}
+	rules := handler.activeRules()
+	if len(rules) != 4 || matched("240.2.2.20") != "maintenance" {
+		t.Fatalf("Unexpected active rules %v", rules)
+	}
+	maintenance := rules[1]
+	ttlDeadline := handler.RuleExpiry(maintenance)
+	if until := time.Until(ttlDeadline); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("Expected the ttl to expire in an hour, got %v", ttlDeadline)
+	}
+	if !handler.RuleExpiry(rules[0]).IsZero() || !handler.RuleExpiry(rules[3]).IsZero() {
+		t.Error("Expected rules without ttl or expiresAt not to expire")
+	}
+
+	// Reloading unchanged rules keeps their deadlines
+	handler.applyRules(clone(), "test")
+	if handler.activeRules()[1] != maintenance || !handler.RuleExpiry(maintenance).Equal(ttlDeadline) {
+		t.Error("Expected the unchanged rule to keep its deadline")
+	}
+
+	handler.expiry.Lock()
+	handler.expiry.deadlines[maintenance] = time.Now().Add(-time.Second)
+	handler.expiry.Unlock()
+	handler.expireRules()
+	if id := matched("240.2.2.20"); id != "" {
+		t.Errorf("Expected the expired rule to be removed, got %q", id)
+	}
+	if len(handler.activeRules()) != 3 || len(handler.ExpiredRules()) != 2 {
+		t.Errorf("Unexpected rules after the expiry %v, expired %v", handler.activeRules(), handler.ExpiredRules())
+	}
+
+	// Expired rules stay out while listed unchanged, and are added again once changed
+	handler.applyRules(clone(), "test")
+	if id := matched("240.2.2.20"); id != "" {
+		t.Errorf("Expected the expired rule to stay out, got %q", id)
+	}
+	loaded[0].RealDestination = "192.168.1.21"
+	handler.applyRules(clone(), "test")
+	if id := matched("240.2.2.20"); id != "maintenance" {
+		t.Errorf("Expected the changed rule to be added again, got %q", id)
+	}
+	if expired := handler.ExpiredRules(); len(expired) != 1 {
+		t.Errorf("Expected the changed rule no longer to be listed expired, got %v", expired)
+	}
+	loaded = loaded[3:]
+	handler.applyRules(clone(), "test")
+	if expired := handler.ExpiredRules(); len(expired) != 0 {
+		t.Errorf("Expected rules no longer loaded to be forgotten, got %v", expired)
+	}
+	handler.Close()
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event AuditEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Action == AuditRulesExpire {
+			break
+		}
+	}
+	if event.Action != AuditRulesExpire || len(event.Changes) != 1 || event.Changes[0].Rule != "maintenance" || event.Changes[0].Before == nil {
+		t.Errorf("Expected the expiry to be audited, got %+v", event)
+	}
+}
+
+func TestRuleExpiryTimer(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	handler.applyRules([]*NATRule{{RuleId: "short", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Ttl: 1}}, "test")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(handler.activeRules()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the rule to expire after its ttl")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
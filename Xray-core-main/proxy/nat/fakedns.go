@@ -0,0 +1,28 @@
+package nat
+
+import (
+	"context"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/dns"
+)
+
+// fakeDNSDomain readdresses a flow to a FakeDNS fake IP to the domain the fake IP was handed out
+// for, so it matches domain rules and its session is keyed by the domain. The real destination
+// of the rule, or the domain itself, is then resolved by the rule's domainStrategy.
+func (h *Handler) fakeDNSDomain(ctx context.Context, destination xnet.Destination) xnet.Destination {
+	if !h.config.FakeDns || h.fakeDNS == nil || !destination.Address.Family().IsIP() {
+		return destination
+	}
+	if pool, ok := h.fakeDNS.(dns.FakeDNSEngineRev0); ok && !pool.IsIPInIPPool(destination.Address) {
+		return destination
+	}
+	domain := h.fakeDNS.GetDomainFromFakeDNS(destination.Address)
+	if domain == "" {
+		return destination
+	}
+	errors.LogDebug(ctx, "NAT: fake IP ", destination.Address, " stands for ", domain)
+	destination.Address = xnet.DomainAddress(domain)
+	return destination
+}
@@ -0,0 +1,80 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/dns"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// fakeDNSEngine hands out one fake IP per domain
+type fakeDNSEngine struct {
+	dns.FakeDNSEngine
+	domains map[string]string // Fake IP -> domain
+}
+
+func (e *fakeDNSEngine) GetDomainFromFakeDNS(ip xnet.Address) string {
+	return e.domains[ip.String()]
+}
+
+func TestFakeDNSDomainRule(t *testing.T) {
+	listener := listenTCP(t)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	handler := New()
+	defer handler.Close()
+	handler.fakeDNS = &fakeDNSEngine{domains: map[string]string{"198.18.0.5": "db.corp"}}
+	if err := handler.Init(&Config{
+		SiteId:  "test-site",
+		FakeDns: true,
+		Rules:   []*NATRule{{RuleId: "db", VirtualDestination: "db.corp", RealDestination: "127.0.0.1"}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	port := xnet.Port(listener.Addr().(*net.TCPAddr).Port)
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	fake := xnet.TCPDestination(xnet.ParseAddress("198.18.0.5"), port)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: fake}})
+
+	uplinkReader, uplinkWriter := pipe.New()
+	_, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, tcpTestDialer{})
+	}()
+	request := buf.New()
+	request.WriteString("ping")
+	uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request})
+
+	conn := <-accepted
+	defer conn.Close()
+	if _, ok := handler.LookupSession(NewFiveTuple(source, xnet.TCPDestination(xnet.DomainAddress("db.corp"), port))); !ok {
+		t.Error("Expected the session to be keyed by the domain of the fake IP")
+	}
+	uplinkWriter.Close()
+	conn.Close()
+	<-done
+
+	// Other addresses are left as they are
+	other := xnet.TCPDestination(xnet.ParseAddress("198.18.0.6"), 80)
+	if destination := handler.fakeDNSDomain(ctx, other); destination != other {
+		t.Errorf("Expected %v to be kept, got %v", other, destination)
+	}
+	handler.config.FakeDns = false
+	if destination := handler.fakeDNSDomain(ctx, fake); destination != fake {
+		t.Errorf("Expected fake IPs to be kept without fakeDns, got %v", destination)
+	}
+}
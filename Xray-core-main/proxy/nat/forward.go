@@ -0,0 +1,75 @@
+package nat
+
+import (
+	"context"
+	"net"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/net/cnc"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// forwardDialer dispatches the translated flows of a rule through another outbound, as the
+// dialerProxy of an outbound does, so the real destination is reached over e.g. a tunnel to
+// its site. The flows are tracked and relayed like those dialed directly.
+type forwardDialer struct {
+	manager outbound.Manager
+	tag     string
+}
+
+// Dial implements internet.Dialer
+func (d forwardDialer) Dial(ctx context.Context, destination xnet.Destination) (stat.Connection, error) {
+	if d.manager == nil {
+		return nil, errors.New("no outbound manager to forward to ", d.tag)
+	}
+	handler := d.manager.GetHandler(d.tag)
+	if handler == nil {
+		return nil, errors.New("failed to get outbound handler with tag ", d.tag)
+	}
+
+	errors.LogDebug(ctx, "NAT: forwarding ", destination, " via ", d.tag)
+	outbounds := session.OutboundsFromContext(ctx)
+	ctx = session.ContextWithOutbounds(ctx, append(outbounds, &session.Outbound{
+		Target: destination,
+		Tag:    d.tag,
+	}))
+	opts := pipe.OptionsFromContext(ctx)
+	uplinkReader, uplinkWriter := pipe.New(opts...)
+	downlinkReader, downlinkWriter := pipe.New(opts...)
+	go handler.Dispatch(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter})
+
+	output := cnc.ConnectionOutputMulti(downlinkReader)
+	if destination.Network == xnet.Network_UDP {
+		// One datagram per read
+		output = cnc.ConnectionOutputMultiUDP(downlinkReader)
+	}
+	return &forwardConn{
+		Conn:   cnc.NewConnection(cnc.ConnectionInputMulti(uplinkWriter), output),
+		uplink: uplinkWriter,
+	}, nil
+}
+
+// DestIpAddress implements internet.Dialer
+func (forwardDialer) DestIpAddress() xnet.IP {
+	return nil
+}
+
+// SetOutboundGateway implements internet.Dialer
+func (forwardDialer) SetOutboundGateway(ctx context.Context, ob *session.Outbound) {}
+
+// forwardConn is a flow dispatched through another outbound, which can be half-closed by
+// ending its uplink
+type forwardConn struct {
+	net.Conn
+	uplink *pipe.Writer
+}
+
+// CloseWrite ends the uplink, leaving the downlink open
+func (c *forwardConn) CloseWrite() error {
+	return c.uplink.Close()
+}
@@ -0,0 +1,121 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// tunnelOutbounds serves one outbound handler by tag
+type tunnelOutbounds struct {
+	outbound.Manager
+	tag     string
+	handler outbound.Handler
+}
+
+func (m *tunnelOutbounds) GetHandler(tag string) outbound.Handler {
+	if tag == m.tag {
+		return m.handler
+	}
+	return nil
+}
+
+// echoOutbound records the targets it is dispatched to and echoes everything back once the
+// uplink ends
+type echoOutbound struct {
+	outbound.Handler
+	targets chan xnet.Destination
+}
+
+func (o *echoOutbound) Dispatch(ctx context.Context, link *transport.Link) {
+	outbounds := session.OutboundsFromContext(ctx)
+	o.targets <- outbounds[len(outbounds)-1].Target
+	var received buf.MultiBuffer
+	for {
+		mb, err := link.Reader.ReadMultiBuffer()
+		received = append(received, mb...)
+		if err != nil {
+			break
+		}
+	}
+	link.Writer.WriteMultiBuffer(received)
+	common.Close(link.Writer)
+}
+
+func TestForwardTag(t *testing.T) {
+	tunnel := &echoOutbound{targets: make(chan xnet.Destination, 1)}
+	rule := &NATRule{RuleId: "site-b", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", ForwardTag: "to-site-b"}
+	handler := New()
+	defer handler.Close()
+	handler.outboundManager = &tunnelOutbounds{tag: "to-site-b", handler: tunnel}
+	if err := handler.Init(&Config{
+		SiteId:        "site-a",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"}},
+		Rules:         []*NATRule{rule},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, externalDialer{}, rule)
+	}()
+
+	select {
+	case target := <-tunnel.targets:
+		if target.NetAddr() != "192.168.1.20:80" || target.Network != xnet.Network_TCP {
+			t.Errorf("Expected the translated destination to be dispatched, got %v", target)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the flow to be dispatched through the forward outbound")
+	}
+	if _, ok := handler.LookupSession(NewFiveTuple(source, destination)); !ok {
+		t.Error("Expected a session for the forwarded flow")
+	}
+
+	// The uplink ending is propagated as a half-close, and the reply still comes back
+	request := buf.New()
+	request.WriteString("ping")
+	uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request})
+	uplinkWriter.Close()
+	var reply buf.MultiBuffer
+	for {
+		mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+		reply = append(reply, mb...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected the reply of the forward outbound: %v", err)
+		}
+	}
+	if reply.String() != "ping" {
+		t.Errorf("Unexpected reply %q", reply.String())
+	}
+	buf.ReleaseMulti(reply)
+	if err := <-done; err != nil {
+		t.Errorf("Expected a clean end, got %v", err)
+	}
+}
+
+func TestForwardTagMissingOutbound(t *testing.T) {
+	dialer := forwardDialer{manager: &tunnelOutbounds{tag: "to-site-b"}, tag: "to-site-c"}
+	if _, err := dialer.Dial(context.Background(), xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)); err == nil {
+		t.Error("Expected error for an unknown forward outbound")
+	}
+}
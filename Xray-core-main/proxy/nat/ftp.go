@@ -0,0 +1,238 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+var (
+	// ftpHostPort matches the h1,h2,h3,h4,p1,p2 address of PORT commands and 227 replies
+	ftpHostPort = regexp.MustCompile(`(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3})`)
+	// ftpExtendedPort matches the (|||port|) of 229 replies
+	ftpExtendedPort = regexp.MustCompile(`\((.)(.)(.)(\d{1,5})(.)\)`)
+)
+
+func init() {
+	common.Must(RegisterALG(algFTP, ftpGateway{}))
+}
+
+// ftpGateway is the FTP gateway of control connections
+type ftpGateway struct{}
+
+// Match implements ALG
+func (ftpGateway) Match(flow *ALGFlow) bool {
+	if !flow.Stream() {
+		return false
+	}
+	flow.State = &ftpALG{handler: flow.handler, ctx: flow.Context, session: flow.Session, conn: flow.Conn}
+	return true
+}
+
+// RewriteOutbound implements ALG
+func (ftpGateway) RewriteOutbound(flow *ALGFlow, line []byte) []byte {
+	return flow.State.(*ftpALG).rewriteCommand(line)
+}
+
+// RewriteInbound implements ALG
+func (ftpGateway) RewriteInbound(flow *ALGFlow, line []byte) []byte {
+	return flow.State.(*ftpALG).rewriteReply(line)
+}
+
+// ExpectSessions implements ALG
+func (ftpGateway) ExpectSessions(flow *ALGFlow, line []byte, outbound bool) []ALGExpectation {
+	if outbound {
+		return nil
+	}
+	return flow.State.(*ftpALG).expectPassive(line)
+}
+
+// ftpALG rewrites the addresses announced on an FTP control connection (RFC 959 and RFC 2428).
+// Passive mode replies of the server are rewritten to the virtual destination and the data
+// connections they announce are expected. Active mode commands of the client are rewritten to
+// a port the NAT listens on, which relays the connection of the server to the client.
+type ftpALG struct {
+	handler *Handler
+	ctx     context.Context
+	session *NATSession     // Control connection
+	conn    stat.Connection // Control connection towards the server
+}
+
+// rewriteCommand rewrites PORT and EPRT commands of the client
+func (a *ftpALG) rewriteCommand(line []byte) []byte {
+	command, argument, ok := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+	if !ok {
+		return line
+	}
+	var client xnet.Destination
+	switch strings.ToUpper(command) {
+	case "PORT":
+		address, port, ok := parseFTPHostPort(argument)
+		if !ok {
+			return line
+		}
+		client = xnet.TCPDestination(address, port)
+	case "EPRT":
+		address, port, ok := parseFTPExtendedAddress(argument)
+		if !ok {
+			return line
+		}
+		client = xnet.TCPDestination(address, port)
+	default:
+		return line
+	}
+
+	// Refuse to open connections to third hosts (FTP bounce)
+	if source := a.session.VirtualSource.Address; source != nil && addressString(source) != addressString(client.Address) {
+		errors.LogWarning(a.ctx, "NAT: FTP ALG ignored ", command, " to ", client, " from ", source)
+		return line
+	}
+
+	local, err := a.listen(client)
+	if err != nil {
+		errors.LogWarningInner(a.ctx, err, "NAT: FTP ALG failed to relay active mode data connection")
+		return line
+	}
+	if ip := local.IP.To4(); ip != nil && strings.EqualFold(command, "PORT") {
+		return []byte("PORT " + formatFTPHostPort(ip, local.Port) + "\r\n")
+	}
+	family := "1"
+	if local.IP.To4() == nil {
+		family = "2"
+	}
+	return []byte("EPRT |" + family + "|" + local.IP.String() + "|" + strconv.Itoa(local.Port) + "|\r\n")
+}
+
+// rewriteReply rewrites the 227 passive mode replies of the server
+func (a *ftpALG) rewriteReply(line []byte) []byte {
+	if !bytes.HasPrefix(line, []byte("227")) {
+		return line
+	}
+	loc := ftpHostPort.FindSubmatchIndex(line)
+	if loc == nil {
+		return line
+	}
+	_, port, ok := parseFTPHostPort(string(line[loc[0]:loc[1]]))
+	virtualIP := a.session.VirtualDest.Address.IP().To4()
+	if !ok || !a.session.VirtualDest.Address.Family().IsIP() || virtualIP == nil {
+		return line
+	}
+	rewritten := append([]byte(nil), line[:loc[0]]...)
+	rewritten = append(rewritten, formatFTPHostPort(virtualIP, int(port))...)
+	return append(rewritten, line[loc[1]:]...)
+}
+
+// expectPassive expects the data connection the 227 and 229 replies of the server announce,
+// to the virtual destination on the announced port. It goes to the real destination of the
+// control connection, whatever address the server announced, like clients that ignore the
+// PASV address do.
+func (a *ftpALG) expectPassive(line []byte) []ALGExpectation {
+	var port xnet.Port
+	switch {
+	case bytes.HasPrefix(line, []byte("227")):
+		var ok bool
+		if _, port, ok = parseFTPHostPort(string(line)); !ok {
+			return nil
+		}
+	case bytes.HasPrefix(line, []byte("229")):
+		match := ftpExtendedPort.FindSubmatch(line)
+		if match == nil {
+			return nil
+		}
+		var err error
+		if port, err = xnet.PortFromString(string(match[4])); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return []ALGExpectation{{
+		VirtualDest: xnet.TCPDestination(a.session.VirtualDest.Address, port),
+		RealDest:    xnet.TCPDestination(a.session.RealDest.Address, port),
+	}}
+}
+
+// listen opens the port announced to the server for an active mode data connection and relays
+// the connection of the server to the client. The port closes after the first connection or
+// when the expectation times out.
+func (a *ftpALG) listen(client xnet.Destination) (*net.TCPAddr, error) {
+	localIP := net.IPv4zero
+	if local, ok := a.conn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = local.IP
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localIP})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetDeadline(time.Now().Add(a.handler.expectationTimeout()))
+
+	ctx := context.WithoutCancel(a.ctx)
+	server := a.session.RealDest.Address.IP()
+	go func() {
+		defer listener.Close()
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			if !conn.RemoteAddr().(*net.TCPAddr).IP.Equal(server) {
+				// Only the server of the control connection may connect
+				conn.Close()
+				continue
+			}
+			a.handler.relayInboundTCP(ctx, conn, client, a.session.RuleID)
+			return
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr), nil
+}
+
+// parseFTPHostPort parses the h1,h2,h3,h4,p1,p2 form of an address
+func parseFTPHostPort(s string) (xnet.Address, xnet.Port, bool) {
+	match := ftpHostPort.FindStringSubmatch(s)
+	if match == nil {
+		return nil, 0, false
+	}
+	var values [6]byte
+	for i := range values {
+		value, err := strconv.Atoi(match[i+1])
+		if err != nil || value > 255 {
+			return nil, 0, false
+		}
+		values[i] = byte(value)
+	}
+	ip := net.IPv4(values[0], values[1], values[2], values[3])
+	return xnet.IPAddress(ip), xnet.Port(int(values[4])<<8 | int(values[5])), true
+}
+
+func formatFTPHostPort(ip net.IP, port int) string {
+	ip = ip.To4()
+	return strconv.Itoa(int(ip[0])) + "," + strconv.Itoa(int(ip[1])) + "," + strconv.Itoa(int(ip[2])) + "," +
+		strconv.Itoa(int(ip[3])) + "," + strconv.Itoa(port>>8) + "," + strconv.Itoa(port&0xff)
+}
+
+// parseFTPExtendedAddress parses the |family|address|port| argument of EPRT
+func parseFTPExtendedAddress(s string) (xnet.Address, xnet.Port, bool) {
+	if len(s) < 1 {
+		return nil, 0, false
+	}
+	fields := strings.Split(s, s[:1])
+	if len(fields) != 5 || (fields[1] != "1" && fields[1] != "2") {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := xnet.PortFromString(fields[3])
+	if ip == nil || err != nil {
+		return nil, 0, false
+	}
+	return xnet.IPAddress(ip), port, true
+}
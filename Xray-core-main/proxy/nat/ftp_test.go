@@ -0,0 +1,215 @@
+package nat
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestLineReader(t *testing.T) {
+	reader, writer := pipe.New()
+	lines := &lineReader{reader: reader, rewrite: func(line []byte) []byte {
+		return []byte(strings.ToUpper(string(line)))
+	}}
+	for _, chunk := range []string{"pa", "sv\r\nqu", "it\r\n", "tail"} {
+		writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte(chunk)))
+	}
+	writer.Close()
+
+	var out strings.Builder
+	for {
+		mb, err := lines.ReadMultiBuffer()
+		out.WriteString(mb.String())
+		buf.ReleaseMulti(mb)
+		if err != nil {
+			break
+		}
+	}
+	if out.String() != "PASV\r\nQUIT\r\ntail" {
+		t.Errorf("Unexpected rewritten stream %q", out.String())
+	}
+}
+
+func TestParseFTPAddresses(t *testing.T) {
+	address, port, ok := parseFTPHostPort("192,168,2,20,19,137")
+	if !ok || address.String() != "192.168.2.20" || port != 5001 {
+		t.Errorf("Unexpected PORT address %v:%d", address, port)
+	}
+	if _, _, ok := parseFTPHostPort("192,168,2,256,19,137"); ok {
+		t.Error("Expected an out of range byte to be rejected")
+	}
+	if formatFTPHostPort(net.ParseIP("240.2.2.20"), 5001) != "240,2,2,20,19,137" {
+		t.Error("Unexpected formatted PORT address")
+	}
+
+	address, port, ok = parseFTPExtendedAddress("|2|2001:db8::1|5282|")
+	if !ok || address.IP().String() != "2001:db8::1" || port != 5282 {
+		t.Errorf("Unexpected EPRT address %v:%d", address, port)
+	}
+	if _, _, ok := parseFTPExtendedAddress("|3|x|1|"); ok {
+		t.Error("Expected an unknown address family to be rejected")
+	}
+}
+
+// ftpControl runs a control connection to virtualDest through the handler
+func ftpControl(t *testing.T, handler *Handler, source, virtualDest xnet.Destination) (*pipe.Writer, *pipe.Reader) {
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: virtualDest}})
+	go handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, hairpinDialer{})
+	t.Cleanup(func() { uplinkWriter.Close() })
+	return uplinkWriter, downlinkReader
+}
+
+func readLine(t *testing.T, reader *pipe.Reader) string {
+	t.Helper()
+	mb, err := reader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.ReleaseMulti(mb)
+	return mb.String()
+}
+
+func newFTPHandler(t *testing.T, controlPort int) *Handler {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{{
+			RuleId:             "ftp",
+			VirtualDestination: "240.2.2.21",
+			RealDestination:    "127.0.0.1",
+			Ports:              strconv.Itoa(controlPort),
+			Alg:                []string{"ftp"},
+		}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return handler
+}
+
+func listenTCP(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+func TestFTPPassiveMode(t *testing.T) {
+	control, data := listenTCP(t), listenTCP(t)
+	dataPort := data.Addr().(*net.TCPAddr).Port
+	go func() {
+		conn, err := control.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "227 Entering Passive Mode (127,0,0,1,"+strconv.Itoa(dataPort>>8)+","+strconv.Itoa(dataPort&0xff)+").\r\n")
+		io.Copy(io.Discard, conn)
+	}()
+	go func() {
+		conn, err := data.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "listing")
+	}()
+
+	handler := newFTPHandler(t, control.Addr().(*net.TCPAddr).Port)
+	source := xnet.TCPDestination(xnet.ParseAddress("127.0.0.5"), 40000)
+	virtual := xnet.ParseAddress("240.2.2.21")
+	_, replies := ftpControl(t, handler, source, xnet.TCPDestination(virtual, xnet.Port(control.Addr().(*net.TCPAddr).Port)))
+
+	expected := "227 Entering Passive Mode (240,2,2,21," + strconv.Itoa(dataPort>>8) + "," + strconv.Itoa(dataPort&0xff) + ").\r\n"
+	if reply := readLine(t, replies); reply != expected {
+		t.Fatalf("Expected the passive reply to announce the virtual destination, got %q", reply)
+	}
+
+	// Other sources may not open the expected data connection
+	dataDest := xnet.TCPDestination(virtual, xnet.Port(dataPort))
+	if _, ok := handler.claimExpectation(session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.TCPDestination(xnet.ParseAddress("127.0.0.6"), 40000)}), dataDest); ok {
+		t.Error("Expected the data connection to be reserved for the control connection's source")
+	}
+
+	// The data port is outside the rule's ports, the expectation translates it
+	_, listing := ftpControl(t, handler, xnet.TCPDestination(source.Address, 40001), dataDest)
+	if got := readLine(t, listing); got != "listing" {
+		t.Errorf("Unexpected data %q", got)
+	}
+	if _, ok := handler.expectations.Load(dataDest.String()); ok {
+		t.Error("Expected the expectation to be claimed")
+	}
+}
+
+func TestFTPActiveMode(t *testing.T) {
+	control, client := listenTCP(t), listenTCP(t)
+	commands := make(chan string, 2)
+	go func() {
+		conn, err := control.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		lines := bufio.NewReader(conn)
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				return
+			}
+			commands <- line
+		}
+	}()
+
+	handler := newFTPHandler(t, control.Addr().(*net.TCPAddr).Port)
+	source := xnet.TCPDestination(xnet.ParseAddress("127.0.0.1"), 40000)
+	virtualDest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.21"), xnet.Port(control.Addr().(*net.TCPAddr).Port))
+	uplink, _ := ftpControl(t, handler, source, virtualDest)
+
+	// Bounce attempts to third hosts are passed through untouched
+	uplink.WriteMultiBuffer(buf.MergeBytes(nil, []byte("PORT 10,0,0,9,0,21\r\n")))
+	if command := <-commands; command != "PORT 10,0,0,9,0,21\r\n" {
+		t.Errorf("Expected the bounce attempt to be left alone, got %q", command)
+	}
+
+	clientPort := client.Addr().(*net.TCPAddr).Port
+	uplink.WriteMultiBuffer(buf.MergeBytes(nil, []byte("PORT 127,0,0,1,"+strconv.Itoa(clientPort>>8)+","+strconv.Itoa(clientPort&0xff)+"\r\n")))
+	command := <-commands
+	address, port, ok := parseFTPHostPort(command)
+	if !ok || !strings.HasPrefix(command, "PORT ") || int(port) == clientPort {
+		t.Fatalf("Expected the PORT command to announce a relay port, got %q", command)
+	}
+
+	// The server connects to the announced port and reaches the client
+	conn, err := net.Dial("tcp", net.JoinHostPort(address.String(), port.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "file")
+	accepted, err := client.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	data := make([]byte, 4)
+	accepted.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(accepted, data); err != nil || string(data) != "file" {
+		t.Errorf("Expected the data connection to be relayed to the client, got %q: %v", data, err)
+	}
+}
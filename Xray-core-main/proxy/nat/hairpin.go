@@ -0,0 +1,54 @@
+package nat
+
+import (
+	"context"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// hairpinSource reports whether a flow from source to realDest stays behind the NAT, i.e. both
+// ends are hosts of a real network, and returns the virtual endpoint the source is translated to.
+func (h *Handler) hairpinSource(source, realDest xnet.Destination) (xnet.Destination, bool) {
+	if !h.hairpinEnabled() {
+		return xnet.Destination{}, false
+	}
+	if source.Address == nil || !source.Address.Family().IsIP() || !realDest.Address.Family().IsIP() {
+		return xnet.Destination{}, false
+	}
+	// Hosts reached through the PLAT are not behind the NAT
+	if _, ok := h.clat.virtualAddressOf(realDest.Address.IP()); ok {
+		return xnet.Destination{}, false
+	}
+	if _, ok := h.virtualAddressOf(realDest.Address.IP()); !ok {
+		return xnet.Destination{}, false
+	}
+	virtualIP, ok := h.virtualAddressOf(source.Address.IP())
+	if !ok {
+		return xnet.Destination{}, false
+	}
+	return xnet.Destination{
+		Network: realDest.Network,
+		Address: xnet.IPAddress(virtualIP),
+		Port:    source.Port,
+	}, true
+}
+
+// hairpinDialer loops hairpinned flows back to the internal host through the system dialer
+// instead of sending them out through the outbound's transport.
+type hairpinDialer struct{}
+
+// Dial implements internet.Dialer
+func (hairpinDialer) Dial(ctx context.Context, destination xnet.Destination) (stat.Connection, error) {
+	return internet.DialSystem(ctx, destination, nil)
+}
+
+// DestIpAddress implements internet.Dialer
+func (hairpinDialer) DestIpAddress() xnet.IP {
+	return nil
+}
+
+// SetOutboundGateway implements internet.Dialer
+func (hairpinDialer) SetOutboundGateway(ctx context.Context, ob *session.Outbound) {}
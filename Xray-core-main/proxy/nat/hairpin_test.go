@@ -0,0 +1,117 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// externalDialer stands for the outbound transport; hairpinned flows must not use it
+type externalDialer struct{ udpTestDialer }
+
+func (externalDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	return nil, errors.New("flow left the NAT")
+}
+
+func TestHairpinSource(t *testing.T) {
+	handler := &Handler{config: &Config{
+		EnableHairpin: true,
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+		},
+	}}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("192.168.1.5"), 40000)
+	internal := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+	external := xnet.TCPDestination(xnet.ParseAddress("8.8.8.8"), 80)
+
+	snat, ok := handler.hairpinSource(source, internal)
+	if !ok {
+		t.Fatal("Expected a flow between two internal hosts to be hairpinned")
+	}
+	if snat.Address.String() != "240.2.2.5" || snat.Port != 40000 {
+		t.Errorf("Expected source 240.2.2.5:40000, got %v", snat)
+	}
+
+	if _, ok := handler.hairpinSource(source, external); ok {
+		t.Error("Flows to external hosts must not be hairpinned")
+	}
+
+	handler.config.EnableHairpin = false
+	if _, ok := handler.hairpinSource(source, internal); ok {
+		t.Error("Hairpinning must be disabled unless enableHairpin is set")
+	}
+}
+
+func TestHairpinFlowIsLoopedBack(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	rule := &NATRule{RuleId: "internal", VirtualDestination: "240.2.2.1", RealDestination: "127.0.0.1"}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		EnableHairpin: true,
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "127.0.0.0/24"}},
+		Rules:         []*NATRule{rule},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("127.0.0.5"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	port := xnet.Port(listener.Addr().(*net.TCPAddr).Port)
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), port)
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, externalDialer{}, rule)
+	}()
+
+	request := buf.New()
+	request.WriteString("ping")
+	uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request})
+	mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Hairpinned flow did not reach the internal host: %v", err)
+	}
+	if mb.String() != "ping" {
+		t.Errorf("Unexpected echo %q", mb.String())
+	}
+	buf.ReleaseMulti(mb)
+
+	natSession, ok := handler.LookupSession(NewFiveTuple(source, destination))
+	if !ok {
+		t.Fatal("Expected a session for the hairpinned flow")
+	}
+	if natSession.Direction != "hairpin" || natSession.RealSource.Address.String() != "240.2.2.5" {
+		t.Errorf("Expected a hairpin session translated to 240.2.2.5, got %s from %v", natSession.Direction, natSession.RealSource)
+	}
+
+	uplinkWriter.Close()
+	<-done
+}
@@ -0,0 +1,418 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// IP protocol numbers and ICMP message types handled by the ICMP translation
+const (
+	protocolICMPv4 = 1
+	protocolICMPv6 = 58
+
+	icmpv4EchoReply        = 0
+	icmpv4Unreachable      = 3
+	icmpv4EchoRequest      = 8
+	icmpv4TimeExceeded     = 11
+	icmpv4ParameterProblem = 12
+
+	icmpv6Unreachable      = 1
+	icmpv6PacketTooBig     = 2
+	icmpv6TimeExceeded     = 3
+	icmpv6ParameterProblem = 4
+	icmpv6EchoRequest      = 128
+	icmpv6EchoReply        = 129
+)
+
+// ipPacket is a parsed IPv4 or IPv6 packet carrying ICMP, or a TCP or UDP packet embedded in an
+// ICMP error. Packets embedded in ICMP errors may be truncated after the first 8 bytes of their payload.
+type ipPacket struct {
+	data   []byte
+	v6     bool
+	header int // Length of the IP header; the ICMP message follows it
+}
+
+func parseIPPacket(data []byte) (ipPacket, error) {
+	p, err := parseIPHeader(data)
+	if err != nil {
+		return ipPacket{}, err
+	}
+	if p.v6 && data[6] != protocolICMPv6 {
+		return ipPacket{}, errors.New("not an ICMPv6 packet")
+	}
+	if !p.v6 && data[9] != protocolICMPv4 {
+		return ipPacket{}, errors.New("not an ICMP packet")
+	}
+	return p, nil
+}
+
+// parseIPHeader parses a packet of any protocol with at least 8 bytes of payload
+func parseIPHeader(data []byte) (ipPacket, error) {
+	if len(data) < 1 {
+		return ipPacket{}, errors.New("empty packet")
+	}
+	switch data[0] >> 4 {
+	case 4:
+		header := int(data[0]&0x0f) * 4
+		if header < 20 || len(data) < header+8 {
+			return ipPacket{}, errors.New("truncated IPv4 packet")
+		}
+		return ipPacket{data: data, header: header}, nil
+	case 6:
+		// Extension headers are not followed
+		if len(data) < 48 {
+			return ipPacket{}, errors.New("truncated IPv6 packet")
+		}
+		return ipPacket{data: data, v6: true, header: 40}, nil
+	default:
+		return ipPacket{}, errors.New("unknown IP version ", data[0]>>4)
+	}
+}
+
+func (p ipPacket) src() net.IP {
+	if p.v6 {
+		return net.IP(p.data[8:24])
+	}
+	return net.IP(p.data[12:16])
+}
+
+func (p ipPacket) dst() net.IP {
+	if p.v6 {
+		return net.IP(p.data[24:40])
+	}
+	return net.IP(p.data[16:20])
+}
+
+func (p ipPacket) icmp() []byte {
+	return p.data[p.header:]
+}
+
+// toFamily returns ip in the address length of the packet, or nil if it is of the other family
+func (p ipPacket) toFamily(ip net.IP) net.IP {
+	if p.v6 {
+		if ip.To4() != nil {
+			return nil
+		}
+		return ip.To16()
+	}
+	return ip.To4()
+}
+
+// setAddresses rewrites the source and destination of the packet; nil keeps an address
+func (p ipPacket) setAddresses(src, dst net.IP) error {
+	for _, rewrite := range []struct {
+		ip    net.IP
+		field net.IP
+	}{{src, p.src()}, {dst, p.dst()}} {
+		if rewrite.ip == nil {
+			continue
+		}
+		ip := p.toFamily(rewrite.ip)
+		if ip == nil {
+			return errors.New("ICMP translation between IPv4 and IPv6 is not supported")
+		}
+		copy(rewrite.field, ip)
+	}
+	return nil
+}
+
+func (p ipPacket) echoID() uint16 {
+	return binary.BigEndian.Uint16(p.icmp()[4:6])
+}
+
+func (p ipPacket) isEcho(request bool) bool {
+	switch typ := p.icmp()[0]; {
+	case p.v6:
+		return (request && typ == icmpv6EchoRequest) || (!request && typ == icmpv6EchoReply)
+	default:
+		return (request && typ == icmpv4EchoRequest) || (!request && typ == icmpv4EchoReply)
+	}
+}
+
+// isError reports whether the message is an ICMP error that embeds the offending packet
+func (p ipPacket) isError() bool {
+	typ := p.icmp()[0]
+	if p.v6 {
+		return typ == icmpv6Unreachable || typ == icmpv6PacketTooBig || typ == icmpv6TimeExceeded || typ == icmpv6ParameterProblem
+	}
+	return typ == icmpv4Unreachable || typ == icmpv4TimeExceeded || typ == icmpv4ParameterProblem
+}
+
+// sum adds data as 16-bit big endian words to a ones' complement sum
+func sum(data []byte, initial uint32) uint32 {
+	for len(data) >= 2 {
+		initial += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		initial += uint32(data[0]) << 8
+	}
+	return initial
+}
+
+func fold(s uint32) uint16 {
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return ^uint16(s)
+}
+
+// fixChecksums recomputes the IPv4 header checksum and the checksum of the complete ICMP message,
+// which covers a pseudo header for ICMPv6
+func (p ipPacket) fixChecksums() {
+	p.fixHeaderChecksum()
+	icmp := p.icmp()
+	binary.BigEndian.PutUint16(icmp[2:4], 0)
+	binary.BigEndian.PutUint16(icmp[2:4], fold(sum(icmp, p.pseudoHeaderSum(len(icmp)))))
+}
+
+// fixHeaderChecksum recomputes the header checksum of an IPv4 packet
+func (p ipPacket) fixHeaderChecksum() {
+	if !p.v6 {
+		binary.BigEndian.PutUint16(p.data[10:12], 0)
+		binary.BigEndian.PutUint16(p.data[10:12], fold(sum(p.data[:p.header], 0)))
+	}
+}
+
+func (p ipPacket) pseudoHeaderSum(length int) uint32 {
+	if !p.v6 {
+		return 0
+	}
+	s := sum(p.data[8:40], 0)
+	return s + uint32(length>>16) + uint32(length&0xffff) + protocolICMPv6
+}
+
+// updateChecksum adjusts a checksum for data that changed from old to new (RFC 1624)
+func updateChecksum(checksum uint16, old, new []byte) uint16 {
+	s := uint32(^checksum)
+	for i := 0; i+1 < len(old); i += 2 {
+		s += uint32(^binary.BigEndian.Uint16(old[i:])) + uint32(binary.BigEndian.Uint16(new[i:]))
+	}
+	return fold(s)
+}
+
+// rewriteEmbedded rewrites the addresses and echo ID of a packet embedded in an ICMP error. The
+// embedded ICMP checksum is adjusted incrementally, as the embedded message may be truncated.
+func (p ipPacket) rewriteEmbedded(src, dst net.IP, id uint16) error {
+	before := append([]byte(nil), p.data[:p.header+8]...)
+	if err := p.setAddresses(src, dst); err != nil {
+		return err
+	}
+	icmp := p.icmp()
+	binary.BigEndian.PutUint16(icmp[4:6], id)
+
+	checksum := binary.BigEndian.Uint16(icmp[2:4])
+	checksum = updateChecksum(checksum, before[p.header+4:p.header+6], icmp[4:6])
+	if p.v6 {
+		checksum = updateChecksum(checksum, before[8:40], p.data[8:40])
+	}
+	binary.BigEndian.PutUint16(icmp[2:4], checksum)
+	p.fixHeaderChecksum()
+	return nil
+}
+
+// icmpQueryKey identifies an echo query on the virtual side
+func icmpQueryKey(source, virtualDest xnet.Address, id uint16) string {
+	return ">" + source.String() + "|" + virtualDest.String() + "|" + strconv.Itoa(int(id))
+}
+
+// icmpReplyKey identifies the translated echo query on the real side
+func icmpReplyKey(realDest xnet.Address, id uint16) string {
+	return "<" + realDest.String() + "|" + strconv.Itoa(int(id))
+}
+
+// icmpSession returns the session of an echo query, creating it when the virtual destination
+// is translated. It returns nil for queries to destinations that are not translated.
+func (h *Handler) icmpSession(ctx context.Context, source, virtualDest xnet.Destination) (*NATSession, error) {
+	key := icmpQueryKey(source.Address, virtualDest.Address, uint16(source.Port))
+	if value, found := h.icmpQueries.Load(key); found {
+		return value.(*NATSession), nil
+	}
+
+	rule, ok := h.shouldApplyNAT(ctx, virtualDest)
+	if !ok {
+		return nil, nil
+	}
+	if blocksFlows(rule) {
+		h.ruleMetricsOf(rule.RuleId, virtualDest.Network).hit(time.Now())
+		return nil, errors.New("echo query to ", virtualDest.Address, " blocked by NAT rule ", rule.RuleId)
+	}
+	realDestination := rule.RealDestination
+	if len(rule.RealDestinations) > 0 {
+		// Echo queries are not balanced; they go to the first backend of a pool
+		realDestination = rule.RealDestinations[0]
+	}
+	realDest, err := h.applyDNATTo(virtualDest, rule, realDestination)
+	if err != nil {
+		return nil, errors.New("DNAT transformation failed").Base(err)
+	}
+	if !realDest.Address.Family().IsIP() {
+		return nil, errors.New("ICMP needs an IP real destination, got ", realDest.Address)
+	}
+
+	if err := h.refuseWhileDraining(); err != nil {
+		return nil, err
+	}
+	if err := h.admitNewSession(); err != nil {
+		return nil, err
+	}
+	ruleQuota, err := h.admitRule(rule, virtualDest.Network)
+	if err != nil {
+		return nil, err
+	}
+	natSession := h.createNATSession(source, virtualDest, realDest, "outbound", classOf(rule))
+	natSession.RuleID = rule.RuleId
+	natSession.ruleQuota = ruleQuota
+	natSession.Protocol = "icmp"
+	h.sessions.Schedule(natSession, natSession.LastActivity().Add(h.sessionTimeout(natSession)))
+
+	// The query ID is translated like a source port: CGNAT assigns it from the subscriber's
+	// port block, otherwise the original ID is kept unless another query to the same real
+	// destination uses it
+	id := uint16(source.Port)
+	if err := h.allocateSourcePort(ctx, natSession); err != nil {
+		h.removeSession(natSession.SessionID)
+		return nil, errors.New("failed to allocate CGNAT query ID").Base(err)
+	}
+	if natSession.RealSource.Port != 0 {
+		id = uint16(natSession.RealSource.Port)
+	}
+	for tries := 0; ; tries++ {
+		if _, loaded := h.icmpQueries.LoadOrStore(icmpReplyKey(realDest.Address, id), natSession); !loaded {
+			break
+		}
+		if natSession.RealSource.Port != 0 || tries == 65535 {
+			h.removeSession(natSession.SessionID)
+			return nil, errors.New("no free ICMP query ID towards ", realDest.Address)
+		}
+		id++
+	}
+	natSession.RealSource.Port = xnet.Port(id)
+
+	if actual, loaded := h.icmpQueries.LoadOrStore(key, natSession); loaded {
+		// A concurrent packet of the same query won
+		h.removeSession(natSession.SessionID)
+		return actual.(*NATSession), nil
+	}
+	h.announceSession(natSession)
+	return natSession, nil
+}
+
+// forgetICMPQuery drops the query indexes of a removed ICMP session
+func (h *Handler) forgetICMPQuery(natSession *NATSession) {
+	if natSession.Protocol != "icmp" {
+		return
+	}
+	h.icmpQueries.CompareAndDelete(icmpQueryKey(natSession.VirtualSource.Address, natSession.VirtualDest.Address, uint16(natSession.VirtualSource.Port)), natSession)
+	h.icmpQueries.CompareAndDelete(icmpReplyKey(natSession.RealDest.Address, uint16(natSession.RealSource.Port)), natSession)
+}
+
+// translateICMPOutbound translates an ICMP or ICMPv6 echo request from the virtual side for a
+// packet based datapath; Xray's links carry only TCP and UDP, so none passes it packets yet.
+// Requests to destinations that are not translated are returned as they are. The query ID is
+// tracked in a session with the ICMP timeout.
+func (h *Handler) translateICMPOutbound(ctx context.Context, packet []byte) ([]byte, error) {
+	p, err := parseIPPacket(packet)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isEcho(true) {
+		return nil, errors.New("only echo requests are translated towards the real side")
+	}
+
+	source := xnet.Destination{Address: xnet.IPAddress(p.src()), Port: xnet.Port(p.echoID())}
+	virtualDest := xnet.Destination{Address: xnet.IPAddress(p.dst())}
+	natSession, err := h.icmpSession(ctx, source, virtualDest)
+	if err != nil || natSession == nil {
+		if err != nil {
+			atomic.AddInt64(&h.totalErrors, 1)
+		}
+		return packet, err
+	}
+
+	out := ipPacket{data: append([]byte(nil), packet...), v6: p.v6, header: p.header}
+	var realSource net.IP
+	if natSession.RealSource.Address != nil {
+		realSource = natSession.RealSource.Address.IP()
+	}
+	if err := out.setAddresses(realSource, natSession.RealDest.Address.IP()); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(out.icmp()[4:6], uint16(natSession.RealSource.Port))
+	out.fixChecksums()
+	natSession.record(true, int64(len(packet)), 1)
+	return out.data, nil
+}
+
+// translateICMPInbound translates an echo reply or an ICMP error from the real side back to the
+// virtual side for a packet based datapath, like translateICMPOutbound. Errors embedding a
+// translated echo request, or a packet of the real side of a TCP or UDP session, have the
+// embedded packet translated too, so path MTU discovery, traceroute and port unreachable errors
+// would work across the NAT. ok is false for messages that belong to no translated query or flow.
+func (h *Handler) translateICMPInbound(packet []byte) (translated []byte, ok bool, err error) {
+	p, err := parseIPPacket(packet)
+	if err != nil {
+		return nil, false, err
+	}
+	out := ipPacket{data: append([]byte(nil), packet...), v6: p.v6, header: p.header}
+
+	switch {
+	case p.isEcho(false):
+		value, found := h.icmpQueries.Load(icmpReplyKey(xnet.IPAddress(p.src()), p.echoID()))
+		if !found {
+			return nil, false, nil
+		}
+		natSession := value.(*NATSession)
+		if err := out.setAddresses(natSession.VirtualDest.Address.IP(), natSession.VirtualSource.Address.IP()); err != nil {
+			return nil, false, err
+		}
+		binary.BigEndian.PutUint16(out.icmp()[4:6], uint16(natSession.VirtualSource.Port))
+		out.fixChecksums()
+		natSession.record(false, int64(len(packet)), 1)
+		return out.data, true, nil
+
+	case p.isError():
+		embedded, err := parseIPHeader(out.icmp()[8:])
+		if err != nil {
+			return nil, false, nil
+		}
+		if _, err := parseIPPacket(embedded.data); err != nil || !embedded.isEcho(true) {
+			ok, err := h.translateFlowError(p, out, embedded)
+			if !ok {
+				return nil, false, err
+			}
+			return out.data, true, nil
+		}
+		value, found := h.icmpQueries.Load(icmpReplyKey(xnet.IPAddress(embedded.dst()), embedded.echoID()))
+		if !found {
+			return nil, false, nil
+		}
+		natSession := value.(*NATSession)
+		virtualSource, virtualDest := natSession.VirtualSource.Address.IP(), natSession.VirtualDest.Address.IP()
+		if err := embedded.rewriteEmbedded(virtualSource, virtualDest, uint16(natSession.VirtualSource.Port)); err != nil {
+			return nil, false, err
+		}
+		// Errors from the real destination itself appear to come from the virtual destination;
+		// those of routers on the path keep the router address
+		var outerSource net.IP
+		if p.src().Equal(natSession.RealDest.Address.IP()) {
+			outerSource = virtualDest
+		}
+		if err := out.setAddresses(outerSource, virtualSource); err != nil {
+			return nil, false, err
+		}
+		out.fixChecksums()
+		natSession.record(false, int64(len(packet)), 1)
+		return out.data, true, nil
+
+	default:
+		return nil, false, nil
+	}
+}
@@ -0,0 +1,305 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// echoPacket builds an ICMP or ICMPv6 echo message with valid checksums
+func echoPacket(src, dst string, typ byte, id uint16) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	icmp := []byte{typ, 0, 0, 0, 0, 0, 0, 1, 'p', 'i', 'n', 'g'}
+	binary.BigEndian.PutUint16(icmp[4:6], id)
+
+	var packet []byte
+	if v4 := srcIP.To4(); v4 != nil {
+		packet = make([]byte, 20, 20+len(icmp))
+		packet[0], packet[8], packet[9] = 0x45, 64, protocolICMPv4
+		copy(packet[12:16], v4)
+		copy(packet[16:20], dstIP.To4())
+	} else {
+		packet = make([]byte, 40, 40+len(icmp))
+		packet[0], packet[6], packet[7] = 0x60, protocolICMPv6, 64
+		copy(packet[8:24], srcIP)
+		copy(packet[24:40], dstIP)
+	}
+	packet = append(packet, icmp...)
+	p, err := parseIPPacket(packet)
+	if err != nil {
+		panic(err)
+	}
+	p.setLengths()
+	p.fixChecksums()
+	return packet
+}
+
+// setLengths fills in the length fields of a packet built by a test
+func (p ipPacket) setLengths() {
+	if p.v6 {
+		binary.BigEndian.PutUint16(p.data[4:6], uint16(len(p.data)-40))
+	} else {
+		binary.BigEndian.PutUint16(p.data[2:4], uint16(len(p.data)))
+	}
+}
+
+// errorPacket builds an ICMP error from src to dst embedding the start of the offending packet
+func errorPacket(src, dst string, typ byte, offending []byte) []byte {
+	packet := echoPacket(src, dst, typ, 0)
+	p, _ := parseIPPacket(packet)
+	packet = append(packet[:p.header+8], offending[:len(offending)-4]...)
+	p = ipPacket{data: packet, v6: p.v6, header: p.header}
+	p.setLengths()
+	p.fixChecksums()
+	return packet
+}
+
+func checkPacket(t *testing.T, packet []byte, src, dst string, id uint16) ipPacket {
+	t.Helper()
+	p, err := parseIPPacket(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.src().Equal(net.ParseIP(src)) || !p.dst().Equal(net.ParseIP(dst)) || p.echoID() != id {
+		t.Errorf("Expected %s -> %s id %d, got %s -> %s id %d", src, dst, id, p.src(), p.dst(), p.echoID())
+	}
+	if !p.v6 && fold(sum(p.data[:p.header], 0)) != 0 {
+		t.Error("Invalid IPv4 header checksum")
+	}
+	if fold(sum(p.icmp(), p.pseudoHeaderSum(len(p.icmp())))) != 0 {
+		t.Error("Invalid ICMP checksum")
+	}
+	return p
+}
+
+func newICMPHandler(t *testing.T, config *Config) *Handler {
+	handler := New()
+	if err := handler.Init(config, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return handler
+}
+
+func TestICMPEchoTranslation(t *testing.T) {
+	handler := newICMPHandler(t, &Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "ping", VirtualDestination: "240.2.2.20", RealDestination: "192.168.2.20"},
+			{RuleId: "web", VirtualDestination: "240.2.2.30", RealDestination: "192.168.2.30", Protocol: "tcp"},
+		},
+		SessionTimeout: &SessionTimeout{IcmpTimeout: 5},
+	})
+	ctx := context.Background()
+
+	request, err := handler.translateICMPOutbound(ctx, echoPacket("10.0.0.1", "240.2.2.20", icmpv4EchoRequest, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPacket(t, request, "10.0.0.1", "192.168.2.20", 7)
+
+	reply, ok, err := handler.translateICMPInbound(echoPacket("192.168.2.20", "10.0.0.1", icmpv4EchoReply, 7))
+	if err != nil || !ok {
+		t.Fatal("Expected the echo reply to be translated: ", err)
+	}
+	checkPacket(t, reply, "240.2.2.20", "10.0.0.1", 7)
+
+	// Another host's query with the same ID towards the same real destination gets another ID
+	request, err = handler.translateICMPOutbound(ctx, echoPacket("10.0.0.2", "240.2.2.20", icmpv4EchoRequest, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	translated := checkPacket(t, request, "10.0.0.2", "192.168.2.20", 8)
+	reply, _, _ = handler.translateICMPInbound(echoPacket("192.168.2.20", "10.0.0.2", icmpv4EchoReply, translated.echoID()))
+	checkPacket(t, reply, "240.2.2.20", "10.0.0.2", 7)
+
+	var timeout bool
+	handler.sessions.Range(func(s *NATSession) bool {
+		timeout = s.Protocol == "icmp" && handler.sessionTimeout(s).Seconds() == 5
+		return timeout
+	})
+	if !timeout {
+		t.Error("Expected query sessions to use the ICMP timeout")
+	}
+
+	// Rules for other protocols and unknown replies are left alone
+	untouched := echoPacket("10.0.0.1", "240.2.2.30", icmpv4EchoRequest, 9)
+	if request, _ := handler.translateICMPOutbound(ctx, untouched); string(request) != string(untouched) {
+		t.Error("Expected queries to TCP only rules to pass untranslated")
+	}
+	if _, ok, _ := handler.translateICMPInbound(echoPacket("192.168.2.20", "10.0.0.1", icmpv4EchoReply, 99)); ok {
+		t.Error("Expected replies of unknown queries not to be translated")
+	}
+
+	// Removing the session forgets the query
+	handler.sessions.Range(func(s *NATSession) bool {
+		handler.removeSession(s.SessionID)
+		return true
+	})
+	if _, ok, _ := handler.translateICMPInbound(echoPacket("192.168.2.20", "10.0.0.1", icmpv4EchoReply, 7)); ok {
+		t.Error("Expected replies of removed queries not to be translated")
+	}
+}
+
+func TestICMPErrorTranslation(t *testing.T) {
+	handler := newICMPHandler(t, &Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "ping", VirtualDestination: "240.2.2.20", RealDestination: "192.168.2.20"},
+		},
+		VirtualRanges: []*VirtualIPRange{{NpTv6VirtualPrefix: "fd01:203:405:1::/64", NpTv6RealPrefix: "2001:db8:1:1::/64"}},
+	})
+
+	for _, test := range []struct {
+		source, virtual, router string
+		timeExceeded, tooBig    byte
+	}{
+		{"10.0.0.1", "240.2.2.20", "198.51.100.1", icmpv4TimeExceeded, icmpv4Unreachable},
+		{"fd00::1", "fd01:203:405:1::20", "2001:db8::1", icmpv6TimeExceeded, icmpv6PacketTooBig},
+	} {
+		request, err := handler.translateICMPOutbound(context.Background(), echoPacket(test.source, test.virtual, icmpEchoRequestOf(test.source), 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		translatedRequest, _ := parseIPPacket(request)
+		real := translatedRequest.dst().String()
+		if real == test.virtual {
+			t.Fatal("Expected the query to ", test.virtual, " to be translated")
+		}
+
+		// Traceroute: a router on the path keeps its address, the embedded query is translated back
+		translated, ok, err := handler.translateICMPInbound(errorPacket(test.router, test.source, test.timeExceeded, request))
+		if err != nil || !ok {
+			t.Fatal("Expected the error to be translated: ", err)
+		}
+		p := checkPacket(t, translated, test.router, test.source, 0)
+		embedded, _ := parseIPPacket(p.icmp()[8:])
+		if !embedded.src().Equal(net.ParseIP(test.source)) || !embedded.dst().Equal(net.ParseIP(test.virtual)) || embedded.echoID() != 3 {
+			t.Errorf("Unexpected embedded packet %s -> %s id %d", embedded.src(), embedded.dst(), embedded.echoID())
+		}
+		if !embedded.v6 && fold(sum(embedded.data[:embedded.header], 0)) != 0 {
+			t.Error("Invalid embedded IPv4 header checksum")
+		}
+		// The embedded checksum stays valid relative to the untruncated original
+		original := echoPacket(test.source, test.virtual, icmpEchoRequestOf(test.source), 3)
+		op, _ := parseIPPacket(original)
+		if binary.BigEndian.Uint16(embedded.icmp()[2:4]) != binary.BigEndian.Uint16(op.icmp()[2:4]) {
+			t.Error("Expected the embedded ICMP checksum to match the original query")
+		}
+
+		// Path MTU discovery: errors of the real destination come from the virtual destination
+		translated, ok, _ = handler.translateICMPInbound(errorPacket(real, test.source, test.tooBig, request))
+		if !ok {
+			t.Fatal("Expected the error of the real destination to be translated")
+		}
+		checkPacket(t, translated, test.virtual, test.source, 0)
+	}
+}
+
+func icmpEchoRequestOf(address string) byte {
+	if net.ParseIP(address).To4() != nil {
+		return icmpv4EchoRequest
+	}
+	return icmpv6EchoRequest
+}
+
+// transportPacket builds a TCP segment or UDP datagram with a valid checksum
+func transportPacket(src, dst string, protocol byte, srcPort, dstPort uint16) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	segment := make([]byte, 8, 24)
+	if protocol == protocolTCP {
+		segment = segment[:20]
+		segment[12] = 5 << 4
+	}
+	segment = append(segment, "data"...)
+	binary.BigEndian.PutUint16(segment[0:2], srcPort)
+	binary.BigEndian.PutUint16(segment[2:4], dstPort)
+
+	var packet []byte
+	var pseudo uint32
+	if v4 := srcIP.To4(); v4 != nil {
+		packet = make([]byte, 20)
+		packet[0], packet[8], packet[9] = 0x45, 64, protocol
+		copy(packet[12:16], v4)
+		copy(packet[16:20], dstIP.To4())
+		pseudo = sum(packet[12:20], 0)
+	} else {
+		packet = make([]byte, 40)
+		packet[0], packet[6], packet[7] = 0x60, protocol, 64
+		copy(packet[8:24], srcIP)
+		copy(packet[24:40], dstIP)
+		pseudo = sum(packet[8:40], 0)
+	}
+	offset := 16
+	if protocol == protocolUDP {
+		offset = 6
+		binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+	}
+	binary.BigEndian.PutUint16(segment[offset:], fold(sum(segment, pseudo+uint32(protocol)+uint32(len(segment)))))
+	packet = append(packet, segment...)
+	p := ipPacket{data: packet, v6: srcIP.To4() == nil, header: len(packet) - len(segment)}
+	p.setLengths()
+	p.fixHeaderChecksum()
+	return packet
+}
+
+func TestICMPFlowErrorTranslation(t *testing.T) {
+	handler := newICMPHandler(t, &Config{SiteId: "test-site"})
+	for _, test := range []struct {
+		protocol                     byte
+		network                      xnet.Network
+		source, virtual, local, real string
+		unreachable                  byte
+	}{
+		{protocolUDP, xnet.Network_UDP, "10.0.0.1", "240.2.2.20", "192.168.2.1", "192.168.2.20", icmpv4Unreachable},
+		{protocolTCP, xnet.Network_TCP, "10.0.0.1", "240.2.2.20", "192.168.2.1", "192.168.2.20", icmpv4Unreachable},
+		{protocolTCP, xnet.Network_TCP, "fd00::1", "fd01:203:405:1::20", "2001:db8:1::1", "2001:db8:1:1::20", icmpv6PacketTooBig},
+	} {
+		source := xnet.Destination{Network: test.network, Address: xnet.ParseAddress(test.source), Port: 40000}
+		virtual := xnet.Destination{Network: test.network, Address: xnet.ParseAddress(test.virtual), Port: 53}
+		real := xnet.Destination{Network: test.network, Address: xnet.ParseAddress(test.real), Port: 53}
+		session := handler.createNATSession(source, virtual, real, "outbound", classInteractive)
+		local := &net.UDPAddr{IP: net.ParseIP(test.local), Port: 50000}
+		untrack := handler.trackRealFlow(test.network, local, &net.UDPAddr{IP: net.ParseIP(test.real), Port: 53}, session, nil)
+
+		// Port unreachable or packet too big from the real destination comes from the virtual one
+		offending := transportPacket(test.local, test.real, test.protocol, 50000, 53)
+		translated, ok, err := handler.translateICMPInbound(errorPacket(test.real, test.local, test.unreachable, offending))
+		if err != nil || !ok {
+			t.Fatal("Expected the error to be translated: ", err)
+		}
+		p := checkPacket(t, translated, test.virtual, test.source, 0)
+		embedded, _ := parseIPHeader(p.icmp()[8:])
+		protocol, src, dst := embedded.transport()
+		if protocol != test.protocol || src.String() != source.NetAddr() || dst.String() != virtual.NetAddr() {
+			t.Errorf("Unexpected embedded packet %s -> %s", src, dst)
+		}
+		if !embedded.v6 && fold(sum(embedded.data[:embedded.header], 0)) != 0 {
+			t.Error("Invalid embedded IPv4 header checksum")
+		}
+		// The embedded checksum matches that of the packet the client sent
+		original, _ := parseIPHeader(transportPacket(test.source, test.virtual, test.protocol, 40000, 53))
+		offset := map[byte]int{protocolTCP: 16, protocolUDP: 6}[test.protocol]
+		if binary.BigEndian.Uint16(embedded.data[embedded.header+offset:]) != binary.BigEndian.Uint16(original.data[original.header+offset:]) {
+			t.Error("Expected the embedded checksum to match the original packet")
+		}
+		if session.Stats().DownlinkPackets != 1 {
+			t.Errorf("Expected the error to be counted on the session, got %d packets", session.Stats().DownlinkPackets)
+		}
+
+		// Errors of flows that ended, or to other remote endpoints of a TCP flow, are left alone
+		if test.protocol == protocolTCP {
+			other := transportPacket(test.local, test.real, test.protocol, 50000, 80)
+			if _, ok, _ := handler.translateICMPInbound(errorPacket(test.real, test.local, test.unreachable, other)); ok {
+				t.Error("Expected errors of other connections not to be translated")
+			}
+		}
+		untrack()
+		if _, ok, _ := handler.translateICMPInbound(errorPacket(test.real, test.local, test.unreachable, offending)); ok {
+			t.Error("Expected errors of ended flows not to be translated")
+		}
+	}
+}
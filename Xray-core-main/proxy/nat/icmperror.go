@@ -0,0 +1,156 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strconv"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// IP protocol numbers of the flows whose ICMP errors are translated
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// realFlow is a TCP connection or UDP socket on the real side of a session, so ICMP errors
+// embedding its packets can be translated back to the virtual side
+type realFlow struct {
+	session *NATSession
+	// virtualOf returns the virtual endpoint of a real remote endpoint the flow sent to
+	virtualOf func(remote netip.AddrPort) xnet.Destination
+}
+
+// realFlowKey identifies a real-side flow by its local port. UDP sockets may send to several
+// remote endpoints, so only TCP connections are told apart by their remote endpoint too.
+func realFlowKey(protocol byte, localPort uint16, remote netip.AddrPort) string {
+	if protocol == protocolUDP {
+		remote = netip.AddrPort{}
+	}
+	return strconv.Itoa(int(protocol)) + "|" + strconv.Itoa(int(localPort)) + "|" + remote.String()
+}
+
+// addrPortOf returns the IP endpoint of a socket address, or false for other addresses
+func addrPortOf(addr net.Addr) (netip.AddrPort, bool) {
+	if addr == nil {
+		return netip.AddrPort{}, false
+	}
+	endpoint, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(endpoint.Addr().Unmap(), endpoint.Port()), true
+}
+
+// trackRealFlow records the real-side socket of a session between local and remote, remote
+// being nil for UDP sockets of a mapping, and returns the function forgetting it. virtualOf
+// translates the remote endpoints of a mapping; without it they are the virtual destination
+// of the session. Sockets of dialers that do not expose IP endpoints are not tracked.
+func (h *Handler) trackRealFlow(network xnet.Network, local, remote net.Addr, session *NATSession, virtualOf func(netip.AddrPort) xnet.Destination) (untrack func()) {
+	protocol := byte(protocolUDP)
+	if network == xnet.Network_TCP {
+		protocol = protocolTCP
+	}
+	localEndpoint, ok := addrPortOf(local)
+	if !ok {
+		return func() {}
+	}
+	remoteEndpoint, ok := addrPortOf(remote)
+	if !ok && protocol == protocolTCP {
+		return func() {}
+	}
+	if virtualOf == nil {
+		virtualOf = func(netip.AddrPort) xnet.Destination { return session.VirtualDest }
+	}
+
+	key := realFlowKey(protocol, localEndpoint.Port(), remoteEndpoint)
+	flow := &realFlow{session: session, virtualOf: virtualOf}
+	h.realFlows.Store(key, flow)
+	return func() { h.realFlows.CompareAndDelete(key, flow) }
+}
+
+// transport returns the transport protocol of a packet embedded in an ICMP error, with its
+// source and destination endpoints
+func (p ipPacket) transport() (protocol byte, src, dst netip.AddrPort) {
+	protocol = p.data[9]
+	if p.v6 {
+		protocol = p.data[6]
+	}
+	srcAddr, _ := netip.AddrFromSlice(p.src())
+	dstAddr, _ := netip.AddrFromSlice(p.dst())
+	segment := p.data[p.header:]
+	src = netip.AddrPortFrom(srcAddr.Unmap(), binary.BigEndian.Uint16(segment[0:2]))
+	dst = netip.AddrPortFrom(dstAddr.Unmap(), binary.BigEndian.Uint16(segment[2:4]))
+	return protocol, src, dst
+}
+
+// rewriteEmbeddedTransport rewrites the endpoints of a TCP segment or UDP datagram embedded in
+// an ICMP error. Its checksum, which covers the addresses through the pseudo header, is adjusted
+// incrementally when the embedded packet is long enough to hold it.
+func (p ipPacket) rewriteEmbeddedTransport(protocol byte, src, dst xnet.Destination) error {
+	addresses := p.data[12:20]
+	if p.v6 {
+		addresses = p.data[8:40]
+	}
+	segment := p.data[p.header:]
+	before := append(append([]byte(nil), addresses...), segment[:4]...)
+	if err := p.setAddresses(src.Address.IP(), dst.Address.IP()); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(segment[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(segment[2:4], uint16(dst.Port))
+
+	offset := 16
+	if protocol == protocolUDP {
+		offset = 6
+	}
+	if len(segment) >= offset+2 {
+		checksum := binary.BigEndian.Uint16(segment[offset:])
+		// IPv4 UDP datagrams may carry no checksum
+		if protocol == protocolTCP || checksum != 0 || p.v6 {
+			checksum = updateChecksum(checksum, before[:len(addresses)], addresses)
+			checksum = updateChecksum(checksum, before[len(addresses):], segment[:4])
+			if checksum == 0 && protocol == protocolUDP {
+				checksum = 0xffff
+			}
+			binary.BigEndian.PutUint16(segment[offset:], checksum)
+		}
+	}
+	p.fixHeaderChecksum()
+	return nil
+}
+
+// translateFlowError translates an ICMP error embedding a packet of a tracked TCP or UDP flow,
+// reporting false when the packet belongs to no tracked flow
+func (h *Handler) translateFlowError(p, out, embedded ipPacket) (bool, error) {
+	protocol, src, dst := embedded.transport()
+	if protocol != protocolTCP && protocol != protocolUDP {
+		return false, nil
+	}
+	value, found := h.realFlows.Load(realFlowKey(protocol, src.Port(), dst))
+	if !found {
+		return false, nil
+	}
+	flow := value.(*realFlow)
+	natSession := flow.session
+	virtualSource, virtualDest := natSession.VirtualSource, flow.virtualOf(dst)
+	if virtualSource.Address == nil || virtualDest.Address == nil || !virtualSource.Address.Family().IsIP() || !virtualDest.Address.Family().IsIP() {
+		return false, nil
+	}
+	if err := embedded.rewriteEmbeddedTransport(protocol, virtualSource, virtualDest); err != nil {
+		return false, err
+	}
+	// As for echo queries, errors of the remote endpoint itself come from its virtual address
+	var outerSource net.IP
+	if p.src().Equal(net.IP(dst.Addr().AsSlice())) {
+		outerSource = virtualDest.Address.IP()
+	}
+	if err := out.setAddresses(outerSource, virtualSource.Address.IP()); err != nil {
+		return false, err
+	}
+	out.fixChecksums()
+	natSession.record(false, int64(len(p.data)), 1)
+	return true, nil
+}
@@ -0,0 +1,368 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// IPFIX protocol constants (RFC 7011)
+const (
+	ipfixVersion       = 10
+	ipfixHeaderLen     = 16
+	ipfixTemplateSetID = 2
+
+	ipfixNAT44TemplateID = 256
+	ipfixNAT64TemplateID = 257
+
+	// ipfixMaxRecords keeps messages of NAT64 records below a typical path MTU
+	ipfixMaxRecords = 20
+)
+
+// Information elements of the RFC 8158 NAT session templates
+const (
+	ieProtocolIdentifier               = 4
+	ieSourceTransportPort              = 7
+	ieSourceIPv4Address                = 8
+	ieDestinationTransportPort         = 11
+	ieDestinationIPv4Address           = 12
+	ieSourceIPv6Address                = 27
+	ieDestinationIPv6Address           = 28
+	iePostNATSourceIPv4Address         = 225
+	iePostNATDestinationIPv4Address    = 226
+	iePostNAPTSourceTransportPort      = 227
+	iePostNAPTDestinationTransportPort = 228
+	ieNATEvent                         = 230
+	ieObservationTimeMilliseconds      = 323
+)
+
+// natEvent values of RFC 8158
+const (
+	natEventNAT44SessionCreate = 1
+	natEventNAT44SessionDelete = 2
+	natEventNAT64SessionCreate = 3
+	natEventNAT64SessionDelete = 4
+)
+
+// ipfixField is a field specifier of a template
+type ipfixField struct {
+	id     uint16
+	length uint16
+}
+
+// ipfixTemplates are the NAT44 and NAT64 session templates, in record field order
+var ipfixTemplates = map[uint16][]ipfixField{
+	ipfixNAT44TemplateID: {
+		{ieObservationTimeMilliseconds, 8},
+		{ieNATEvent, 1},
+		{ieSourceIPv4Address, 4},
+		{iePostNATSourceIPv4Address, 4},
+		{ieProtocolIdentifier, 1},
+		{ieSourceTransportPort, 2},
+		{iePostNAPTSourceTransportPort, 2},
+		{ieDestinationIPv4Address, 4},
+		{iePostNATDestinationIPv4Address, 4},
+		{ieDestinationTransportPort, 2},
+		{iePostNAPTDestinationTransportPort, 2},
+	},
+	ipfixNAT64TemplateID: {
+		{ieObservationTimeMilliseconds, 8},
+		{ieNATEvent, 1},
+		{ieSourceIPv6Address, 16},
+		{iePostNATSourceIPv4Address, 4},
+		{ieProtocolIdentifier, 1},
+		{ieSourceTransportPort, 2},
+		{iePostNAPTSourceTransportPort, 2},
+		{ieDestinationIPv6Address, 16},
+		{iePostNATDestinationIPv4Address, 4},
+		{ieDestinationTransportPort, 2},
+		{iePostNAPTDestinationTransportPort, 2},
+	},
+}
+
+// flowEvent is a NAT session event in the shape of the RFC 8158 session templates
+type flowEvent struct {
+	template   uint16
+	time       time.Time
+	natEvent   uint8
+	protocol   uint8
+	source     net.IP
+	postSource net.IP
+	sourcePort uint16
+	postSPort  uint16
+	dest       net.IP
+	postDest   net.IP
+	destPort   uint16
+	postDPort  uint16
+}
+
+// newFlowEvent describes a session as a NAT44 or NAT64 event. Sessions of other translations,
+// such as NPTv6 or NAT46, have no RFC 8158 template and are not exported.
+func newFlowEvent(session *NATSession, create bool) (*flowEvent, bool) {
+	ipOf := func(dest xnet.Destination) net.IP {
+		if dest.Address == nil || !dest.Address.Family().IsIP() {
+			return nil
+		}
+		return dest.Address.IP()
+	}
+	virtualDest, realDest := ipOf(session.VirtualDest), ipOf(session.RealDest)
+	if virtualDest == nil || realDest == nil || realDest.To4() == nil {
+		return nil, false
+	}
+
+	event := &flowEvent{
+		time:       time.Now(),
+		sourcePort: uint16(session.VirtualSource.Port),
+		postSPort:  uint16(session.VirtualSource.Port),
+		destPort:   uint16(session.VirtualDest.Port),
+		postDPort:  uint16(session.RealDest.Port),
+		postDest:   realDest.To4(),
+		postSource: net.IPv4zero.To4(),
+	}
+	switch strings.ToLower(session.Protocol) {
+	case "tcp":
+		event.protocol = 6
+	case "udp":
+		event.protocol = 17
+	case "icmp":
+		event.protocol = 1
+	case protocolSCTP:
+		event.protocol = 132
+	}
+
+	source := ipOf(session.VirtualSource)
+	if source4 := source.To4(); source4 != nil {
+		event.postSource = source4
+	}
+	if realSource := ipOf(session.RealSource); realSource.To4() != nil {
+		event.postSource = realSource.To4()
+		event.postSPort = uint16(session.RealSource.Port)
+	}
+
+	if virtualDest.To4() != nil {
+		event.template = ipfixNAT44TemplateID
+		event.natEvent = natEventNAT44SessionDelete
+		if create {
+			event.natEvent = natEventNAT44SessionCreate
+		}
+		event.source = net.IPv4zero.To4()
+		if source.To4() != nil {
+			event.source = source.To4()
+		}
+		event.dest = virtualDest.To4()
+	} else {
+		event.template = ipfixNAT64TemplateID
+		event.natEvent = natEventNAT64SessionDelete
+		if create {
+			event.natEvent = natEventNAT64SessionCreate
+		}
+		event.source = net.IPv6zero
+		if source != nil {
+			event.source = source.To16()
+		}
+		event.dest = virtualDest.To16()
+	}
+	return event, true
+}
+
+// appendRecord appends the data record of an event to b
+func (e *flowEvent) appendRecord(b []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(e.time.UnixMilli()))
+	b = append(b, e.natEvent)
+	b = append(b, e.source...)
+	b = append(b, e.postSource...)
+	b = append(b, e.protocol)
+	b = binary.BigEndian.AppendUint16(b, e.sourcePort)
+	b = binary.BigEndian.AppendUint16(b, e.postSPort)
+	b = append(b, e.dest...)
+	b = append(b, e.postDest...)
+	b = binary.BigEndian.AppendUint16(b, e.destPort)
+	b = binary.BigEndian.AppendUint16(b, e.postDPort)
+	return b
+}
+
+// buildIPFIXMessage encodes one IPFIX message carrying the events, preceded by a template set if requested.
+// sequence is the number of data records sent before this message.
+func buildIPFIXMessage(domainID, sequence uint32, exportTime time.Time, withTemplates bool, events []*flowEvent) []byte {
+	b := make([]byte, ipfixHeaderLen, 512)
+	binary.BigEndian.PutUint16(b[0:], ipfixVersion)
+	binary.BigEndian.PutUint32(b[4:], uint32(exportTime.Unix()))
+	binary.BigEndian.PutUint32(b[8:], sequence)
+	binary.BigEndian.PutUint32(b[12:], domainID)
+
+	// A set is a 4 byte header followed by records; its length is patched once the records are in
+	beginSet := func(id uint16) int {
+		start := len(b)
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = append(b, 0, 0)
+		return start
+	}
+	endSet := func(start int) {
+		binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	}
+
+	if withTemplates {
+		start := beginSet(ipfixTemplateSetID)
+		for _, id := range []uint16{ipfixNAT44TemplateID, ipfixNAT64TemplateID} {
+			fields := ipfixTemplates[id]
+			b = binary.BigEndian.AppendUint16(b, id)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+			for _, field := range fields {
+				b = binary.BigEndian.AppendUint16(b, field.id)
+				b = binary.BigEndian.AppendUint16(b, field.length)
+			}
+		}
+		endSet(start)
+	}
+
+	for _, id := range []uint16{ipfixNAT44TemplateID, ipfixNAT64TemplateID} {
+		start := -1
+		for _, event := range events {
+			if event.template != id {
+				continue
+			}
+			if start < 0 {
+				start = beginSet(id)
+			}
+			b = event.appendRecord(b)
+		}
+		if start >= 0 {
+			endSet(start)
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// ipfixExporter batches NAT events and sends them to an IPFIX collector over UDP
+type ipfixExporter struct {
+	conn     net.Conn
+	domainID uint32
+	refresh  time.Duration
+	flush    time.Duration
+	events   chan *flowEvent
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// Owned by the run goroutine
+	sequence      uint32
+	lastTemplates time.Time
+}
+
+func newIPFIXExporter(config *FlowExport) (*ipfixExporter, error) {
+	if config.Collector == "" {
+		return nil, errors.New("flow export requires a collector address")
+	}
+	conn, err := net.Dial("udp", config.Collector)
+	if err != nil {
+		return nil, errors.New("failed to reach IPFIX collector ", config.Collector).Base(err)
+	}
+
+	e := &ipfixExporter{
+		conn:     conn,
+		domainID: config.ObservationDomainId,
+		refresh:  time.Duration(config.TemplateRefreshInterval) * time.Second,
+		flush:    time.Duration(config.FlushInterval) * time.Millisecond,
+		events:   make(chan *flowEvent, 1024),
+		done:     make(chan struct{}),
+	}
+	if e.refresh == 0 {
+		e.refresh = 600 * time.Second
+	}
+	if e.flush == 0 {
+		e.flush = time.Second
+	}
+
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+// Export queues an event; events are dropped while the queue is full
+func (e *ipfixExporter) Export(event *flowEvent) {
+	select {
+	case e.events <- event:
+	default:
+		errors.LogDebug(context.Background(), "IPFIX export queue full, dropping NAT event")
+	}
+}
+
+func (e *ipfixExporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.flush)
+	defer ticker.Stop()
+
+	var pending []*flowEvent
+	for {
+		select {
+		case event := <-e.events:
+			pending = append(pending, event)
+			if len(pending) >= ipfixMaxRecords {
+				pending = e.send(pending)
+			}
+		case <-ticker.C:
+			pending = e.send(pending)
+		case <-e.done:
+			for {
+				select {
+				case event := <-e.events:
+					pending = append(pending, event)
+				default:
+					for len(pending) > 0 {
+						pending = e.send(pending)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// send transmits up to ipfixMaxRecords pending events, with templates when they are due,
+// and returns the events left over
+func (e *ipfixExporter) send(pending []*flowEvent) []*flowEvent {
+	now := time.Now()
+	withTemplates := e.lastTemplates.IsZero() || now.Sub(e.lastTemplates) >= e.refresh
+	if len(pending) == 0 && !withTemplates {
+		return pending
+	}
+
+	batch := pending
+	if len(batch) > ipfixMaxRecords {
+		batch = batch[:ipfixMaxRecords]
+	}
+	message := buildIPFIXMessage(e.domainID, e.sequence, now, withTemplates, batch)
+	if _, err := e.conn.Write(message); err != nil {
+		errors.LogInfoInner(context.Background(), err, "failed to send IPFIX message")
+	}
+	e.sequence += uint32(len(batch))
+	if withTemplates {
+		e.lastTemplates = now
+	}
+	return pending[len(batch):]
+}
+
+// Close flushes the queued events and closes the connection to the collector
+func (e *ipfixExporter) Close() error {
+	close(e.done)
+	e.wg.Wait()
+	return e.conn.Close()
+}
+
+// exportSession sends the create or delete event of a session to the IPFIX collector
+func (h *Handler) exportSession(session *NATSession, create bool) {
+	if h.flowExporter == nil {
+		return
+	}
+	if event, ok := newFlowEvent(session, create); ok {
+		h.flowExporter.Export(event)
+	}
+}
@@ -0,0 +1,191 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// ipfixSet is one set of a received IPFIX message
+type ipfixSet struct {
+	id   uint16
+	body []byte
+}
+
+func parseIPFIXMessage(t *testing.T, b []byte) (sequence, domainID uint32, sets []ipfixSet) {
+	if len(b) < ipfixHeaderLen {
+		t.Fatalf("Short IPFIX message of %d bytes", len(b))
+	}
+	if version := binary.BigEndian.Uint16(b); version != ipfixVersion {
+		t.Fatalf("Expected IPFIX version 10, got %d", version)
+	}
+	if length := int(binary.BigEndian.Uint16(b[2:])); length != len(b) {
+		t.Fatalf("Message length %d does not match the datagram of %d bytes", length, len(b))
+	}
+	sequence, domainID = binary.BigEndian.Uint32(b[8:]), binary.BigEndian.Uint32(b[12:])
+	for rest := b[ipfixHeaderLen:]; len(rest) > 0; {
+		length := int(binary.BigEndian.Uint16(rest[2:]))
+		if length < 4 || length > len(rest) {
+			t.Fatalf("Invalid set length %d", length)
+		}
+		sets = append(sets, ipfixSet{id: binary.BigEndian.Uint16(rest), body: rest[4:length]})
+		rest = rest[length:]
+	}
+	return
+}
+
+func TestNewFlowEvent(t *testing.T) {
+	nat44 := &NATSession{
+		Protocol:      "tcp",
+		VirtualSource: xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 51000),
+		VirtualDest:   xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80),
+		RealSource:    xnet.TCPDestination(xnet.ParseAddress("100.64.0.1"), 2048),
+		RealDest:      xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 8080),
+	}
+	event, ok := newFlowEvent(nat44, true)
+	if !ok || event.template != ipfixNAT44TemplateID || event.natEvent != natEventNAT44SessionCreate {
+		t.Fatalf("Expected a NAT44 create event, got %+v", event)
+	}
+	if event.protocol != 6 || event.postSource.String() != "100.64.0.1" || event.postSPort != 2048 || event.postDPort != 8080 {
+		t.Errorf("Unexpected NAT44 event %+v", event)
+	}
+	nat44.Protocol = protocolSCTP
+	if event, _ := newFlowEvent(nat44, true); event.protocol != 132 {
+		t.Errorf("Expected the SCTP protocol number, got %d", event.protocol)
+	}
+
+	nat64 := &NATSession{
+		Protocol:      "udp",
+		VirtualSource: xnet.UDPDestination(xnet.ParseAddress("2001:db8::2"), 51000),
+		VirtualDest:   xnet.UDPDestination(xnet.ParseAddress("64:ff9b::c0a8:114"), 53),
+		RealDest:      xnet.UDPDestination(xnet.ParseAddress("192.168.1.20"), 53),
+	}
+	event, ok = newFlowEvent(nat64, false)
+	if !ok || event.template != ipfixNAT64TemplateID || event.natEvent != natEventNAT64SessionDelete {
+		t.Fatalf("Expected a NAT64 delete event, got %+v", event)
+	}
+	if len(event.source) != 16 || len(event.dest) != 16 || event.postDest.String() != "192.168.1.20" {
+		t.Errorf("Unexpected NAT64 event %+v", event)
+	}
+
+	nptv6 := &NATSession{
+		VirtualDest: xnet.TCPDestination(xnet.ParseAddress("fd00::1"), 80),
+		RealDest:    xnet.TCPDestination(xnet.ParseAddress("2001:db8::1"), 80),
+	}
+	if _, ok := newFlowEvent(nptv6, true); ok {
+		t.Error("IPv6 to IPv6 sessions have no RFC 8158 template")
+	}
+}
+
+func TestBuildIPFIXMessage(t *testing.T) {
+	event := &flowEvent{
+		template:   ipfixNAT44TemplateID,
+		time:       time.UnixMilli(1700000000123),
+		natEvent:   natEventNAT44SessionCreate,
+		protocol:   17,
+		source:     net.ParseIP("10.0.0.2").To4(),
+		postSource: net.ParseIP("100.64.0.1").To4(),
+		sourcePort: 51000,
+		postSPort:  2048,
+		dest:       net.ParseIP("240.2.2.20").To4(),
+		postDest:   net.ParseIP("192.168.1.20").To4(),
+		destPort:   53,
+		postDPort:  53,
+	}
+	message := buildIPFIXMessage(7, 41, time.Unix(1700000000, 0), true, []*flowEvent{event})
+
+	sequence, domainID, sets := parseIPFIXMessage(t, message)
+	if sequence != 41 || domainID != 7 {
+		t.Errorf("Expected sequence 41 in domain 7, got %d in %d", sequence, domainID)
+	}
+	if len(sets) != 2 || sets[0].id != ipfixTemplateSetID || sets[1].id != ipfixNAT44TemplateID {
+		t.Fatalf("Expected a template set followed by a NAT44 data set, got %v", sets)
+	}
+
+	templates := sets[0].body
+	if id, count := binary.BigEndian.Uint16(templates), binary.BigEndian.Uint16(templates[2:]); id != ipfixNAT44TemplateID || count != 11 {
+		t.Fatalf("Expected NAT44 template with 11 fields, got template %d with %d", id, count)
+	}
+	recordLength := 0
+	for i := 0; i < 11; i++ {
+		recordLength += int(binary.BigEndian.Uint16(templates[4+i*4+2:]))
+	}
+
+	record := sets[1].body
+	if len(record) != recordLength {
+		t.Fatalf("Expected a %d byte record, got %d bytes", recordLength, len(record))
+	}
+	if ms := binary.BigEndian.Uint64(record); ms != 1700000000123 {
+		t.Errorf("Unexpected observation time %d", ms)
+	}
+	if record[8] != natEventNAT44SessionCreate || net.IP(record[9:13]).String() != "10.0.0.2" || net.IP(record[13:17]).String() != "100.64.0.1" {
+		t.Errorf("Unexpected record %x", record)
+	}
+	if port := binary.BigEndian.Uint16(record[20:]); port != 2048 {
+		t.Errorf("Expected post-NAPT source port 2048, got %d", port)
+	}
+}
+
+func TestFlowExportToCollector(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{FlowExport: &FlowExport{
+		Collector:           collector.LocalAddr().String(),
+		ObservationDomainId: 3,
+		FlushInterval:       20,
+	}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 51000)
+	virtualDest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	realDest := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+	natSession := handler.createNATSession(source, virtualDest, realDest, "outbound", classInteractive)
+	handler.announceSession(natSession)
+	handler.removeSession(natSession.SessionID)
+
+	var natEvents []byte
+	var sequences []uint32
+	templates := false
+	collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(natEvents) < 2 {
+		b := make([]byte, 2048)
+		n, _, err := collector.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("Collector received %d NAT events: %v", len(natEvents), err)
+		}
+		sequence, domainID, sets := parseIPFIXMessage(t, b[:n])
+		if domainID != 3 {
+			t.Errorf("Expected observation domain 3, got %d", domainID)
+		}
+		sequences = append(sequences, sequence)
+		for _, set := range sets {
+			switch set.id {
+			case ipfixTemplateSetID:
+				templates = true
+			case ipfixNAT44TemplateID:
+				if !templates {
+					t.Fatal("Data set arrived before its template")
+				}
+				for record := set.body; len(record) >= 34; record = record[34:] {
+					natEvents = append(natEvents, record[8])
+				}
+			}
+		}
+	}
+	if natEvents[0] != natEventNAT44SessionCreate || natEvents[1] != natEventNAT44SessionDelete {
+		t.Errorf("Expected create and delete events, got %v", natEvents)
+	}
+	if sequences[0] != 0 {
+		t.Errorf("Expected the first message to have sequence 0, got %d", sequences[0])
+	}
+}
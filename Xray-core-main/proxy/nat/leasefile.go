@@ -0,0 +1,92 @@
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// leaseTable is the JSON form of the lease file, meant to be audited and edited offline. Leases
+// without an expiry time never expire.
+type leaseTable struct {
+	Network    string        `json:"network"`
+	ExportedAt time.Time     `json:"exportedAt"`
+	Leases     []leaseRecord `json:"leases"`
+}
+
+type leaseRecord struct {
+	Virtual string     `json:"virtual"`
+	Real    string     `json:"real"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// export writes the leases to the lease file
+func (p *addressPool) export() error {
+	if p.file == nil {
+		return nil
+	}
+	p.exportAccess.Lock()
+	defer p.exportAccess.Unlock()
+
+	table := leaseTable{Network: p.network.String(), ExportedAt: time.Now()}
+	for _, lease := range p.Leases() {
+		record := leaseRecord{Virtual: lease.Virtual.String(), Real: lease.Real.String()}
+		if !lease.Expires.IsZero() {
+			expires := lease.Expires
+			record.Expires = &expires
+		}
+		table.Leases = append(table.Leases, record)
+	}
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.file.Save(data)
+}
+
+// importLeases loads the lease file and returns the number of leases imported. Leases that expired
+// meanwhile are dropped, as are those conflicting with an earlier lease or outside the pool.
+func (p *addressPool) importLeases(now time.Time) (int, error) {
+	if p.file == nil {
+		return 0, nil
+	}
+	data, err := p.file.Load()
+	if err != nil || data == nil {
+		return 0, err
+	}
+	var table leaseTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return 0, errors.New("invalid NAT lease file ", p.file).Base(err)
+	}
+
+	imported := 0
+	for _, record := range table.Leases {
+		lease := AddressLease{Virtual: net.ParseIP(record.Virtual), Real: net.ParseIP(record.Real)}
+		if record.Expires != nil {
+			if !now.Before(*record.Expires) {
+				continue
+			}
+			lease.Expires = *record.Expires
+		}
+		if lease.Virtual == nil {
+			errors.LogWarning(context.Background(), "NAT: skipped the lease of ", record.Real, " to the invalid address ", record.Virtual)
+			continue
+		}
+		if err := p.restore(lease); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: skipped the lease of ", record.Virtual)
+			continue
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// exportLeases writes the leases of the address pool to its lease file
+func (h *Handler) exportLeases() {
+	if err := h.addresses.export(); err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: failed to export leases to ", h.addresses.file)
+	}
+}
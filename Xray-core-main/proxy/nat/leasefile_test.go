@@ -0,0 +1,81 @@
+package nat
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLeaseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	expired := time.Now().Add(-time.Minute)
+	data, _ := json.Marshal(leaseTable{Network: "240.5.5.0/24", Leases: []leaseRecord{
+		{Virtual: "240.5.5.1", Real: "192.168.1.20", Expires: &expires},
+		{Virtual: "240.5.5.2", Real: "192.168.1.21"},
+		{Virtual: "240.5.5.3", Real: "192.168.1.22", Expires: &expired},
+		{Virtual: "240.6.6.1", Real: "192.168.1.23"},
+		{Virtual: "240.5.5.4", Real: "192.168.1.20"},
+	}})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:      "test-site",
+		AddressPool: &AddressPool{Network: "240.5.5.0/24", LeaseFile: path},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired leases, leases outside the pool and second leases of a host are dropped
+	leases := handler.AddressLeases()
+	if len(leases) != 2 {
+		t.Fatalf("Expected two imported leases, got %v", leases)
+	}
+	if leases[0].Virtual.String() != "240.5.5.1" || leases[0].Real.String() != "192.168.1.20" || !leases[0].Expires.Equal(expires) {
+		t.Errorf("Unexpected imported lease %v", leases[0])
+	}
+	if leases[1].Virtual.String() != "240.5.5.2" || !leases[1].Expires.IsZero() {
+		t.Errorf("Expected a lease that never expires, got %v", leases[1])
+	}
+	if renewed, err := handler.RenewLease(leases[1].Virtual, time.Minute); err != nil || !renewed.Expires.IsZero() {
+		t.Errorf("Expected the lease to keep never expiring, got %v, %v", renewed, err)
+	}
+
+	// Changes are exported
+	if _, err := handler.AllocateAddress(net.ParseIP("192.168.1.30"), net.ParseIP("240.5.5.30"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.ReleaseAddress(net.ParseIP("240.5.5.1")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var table leaseTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		t.Fatal(err)
+	}
+	if table.Network != "240.5.5.0/24" || len(table.Leases) != 2 ||
+		table.Leases[0].Virtual != "240.5.5.2" || table.Leases[0].Expires != nil ||
+		table.Leases[1].Virtual != "240.5.5.30" || table.Leases[1].Real != "192.168.1.30" || table.Leases[1].Expires == nil {
+		t.Errorf("Unexpected exported leases %s", data)
+	}
+}
+
+func TestLeaseFileMissing(t *testing.T) {
+	pool, err := newAddressPool(&AddressPool{Network: "240.5.5.0/24", LeaseFile: filepath.Join(t.TempDir(), "leases.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := pool.importLeases(time.Now()); err != nil || imported != 0 {
+		t.Errorf("Expected a missing lease file to import nothing, got %d, %v", imported, err)
+	}
+}
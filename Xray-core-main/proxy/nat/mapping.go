@@ -0,0 +1,305 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/common/task"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// inboundMapping is a port a client behind the NAT asked to be opened for it. Connections and
+// datagrams reaching the external port are relayed to the internal endpoint of the client.
+// The mapping is held as a session with the "mapping" direction, from the internal to the
+// external endpoint, which expires with the lifetime granted to the client.
+type inboundMapping struct {
+	session  *NATSession
+	internal xnet.Destination
+	external xnet.Destination
+	owner    string // Identifies the requester allowed to refresh and delete the mapping
+	listener io.Closer
+}
+
+// Mapping is an inbound mapping a client behind the NAT opened through port control
+type Mapping struct {
+	SessionID string
+	Protocol  string // Port control protocol that opened the mapping: "pcp", "natpmp" or "upnp"
+	Internal  xnet.Destination
+	External  xnet.Destination
+	Expires   time.Time
+}
+
+// Mappings returns the inbound mappings currently open
+func (h *Handler) Mappings() []Mapping {
+	var mappings []Mapping
+	h.mappings.Range(func(_, value any) bool {
+		m := value.(*inboundMapping)
+		mappings = append(mappings, Mapping{
+			SessionID: m.session.SessionID,
+			Protocol:  m.session.RuleID,
+			Internal:  m.internal,
+			External:  m.external,
+			Expires:   m.session.LastActivity().Add(time.Duration(m.session.lifetime.Load())),
+		})
+		return true
+	})
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].External.NetAddr() < mappings[j].External.NetAddr()
+	})
+	return mappings
+}
+
+// mappingKey identifies the mapping of an internal endpoint
+func mappingKey(internal xnet.Destination) string {
+	return internal.NetAddr() + "/" + internal.Network.String()
+}
+
+// openMapping opens an external port on localIP relaying to internal and tracks it as a session
+// expiring after lifetime. port 0 picks a free port.
+func (h *Handler) openMapping(ctx context.Context, internal xnet.Destination, localIP, externalIP net.IP, port xnet.Port, lifetime time.Duration, owner, ruleID string) (*inboundMapping, error) {
+	var listener io.Closer
+	var bound int
+	switch internal.Network {
+	case xnet.Network_TCP:
+		tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localIP, Port: int(port)})
+		if err != nil {
+			return nil, err
+		}
+		listener, bound = tcpListener, tcpListener.Addr().(*net.TCPAddr).Port
+		go h.acceptInbound(ctx, tcpListener, internal, ruleID)
+	case xnet.Network_UDP:
+		udpListener, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP, Port: int(port)})
+		if err != nil {
+			return nil, err
+		}
+		listener, bound = udpListener, udpListener.LocalAddr().(*net.UDPAddr).Port
+		go h.relayInboundUDP(ctx, udpListener, internal, ruleID, false)
+	default:
+		return nil, errors.New("cannot map ", internal.Network, " ports")
+	}
+
+	external := xnet.Destination{Network: internal.Network, Address: xnet.IPAddress(externalIP), Port: xnet.Port(bound)}
+	natSession := h.createNATSession(internal, external, external, "mapping", h.classOfRule(ruleID))
+	natSession.RuleID = ruleID
+	m := &inboundMapping{session: natSession, internal: internal, external: external, owner: owner, listener: listener}
+	h.setMappingLifetime(m, lifetime)
+	if previous, loaded := h.mappings.Swap(mappingKey(internal), m); loaded {
+		h.removeSession(previous.(*inboundMapping).session.SessionID)
+	}
+	h.announceSession(natSession)
+	errors.LogInfo(ctx, "NAT: mapped ", external, " to ", internal, " for ", lifetime)
+	return m, nil
+}
+
+// lookupMapping returns the mapping of an internal endpoint
+func (h *Handler) lookupMapping(internal xnet.Destination) (*inboundMapping, bool) {
+	value, found := h.mappings.Load(mappingKey(internal))
+	if !found {
+		return nil, false
+	}
+	return value.(*inboundMapping), true
+}
+
+// setMappingLifetime extends a mapping to expire after lifetime from now
+func (h *Handler) setMappingLifetime(m *inboundMapping, lifetime time.Duration) {
+	m.session.lifetime.Store(int64(lifetime))
+	m.session.touch()
+	h.sessions.Schedule(m.session, m.session.LastActivity().Add(lifetime))
+}
+
+// mappingsOf counts the mappings an internal address holds
+func (h *Handler) mappingsOf(ip net.IP) int {
+	count := 0
+	h.mappings.Range(func(_, value any) bool {
+		if value.(*inboundMapping).internal.Address.IP().Equal(ip) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// forgetMapping closes the external port of a removed mapping session
+func (h *Handler) forgetMapping(natSession *NATSession) {
+	if natSession.Direction != "mapping" {
+		return
+	}
+	key := mappingKey(natSession.VirtualSource)
+	if value, found := h.mappings.Load(key); found && value.(*inboundMapping).session == natSession {
+		h.mappings.CompareAndDelete(key, value)
+		value.(*inboundMapping).listener.Close()
+		errors.LogInfo(context.Background(), "NAT: unmapped ", natSession.VirtualDest, " from ", natSession.VirtualSource)
+	}
+}
+
+// acceptInbound relays the connections accepted on listener to internal until it is closed
+func (h *Handler) acceptInbound(ctx context.Context, listener *net.TCPListener, internal xnet.Destination, ruleID string) {
+	ctx = context.WithoutCancel(ctx)
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go h.relayInboundTCP(ctx, conn, internal, ruleID)
+	}
+}
+
+// relayInboundTCP relays a connection from the real side to the internal endpoint of a client,
+// tracked as an inbound session
+func (h *Handler) relayInboundTCP(ctx context.Context, conn *net.TCPConn, internal xnet.Destination, ruleID string) {
+	defer conn.Close()
+	peer := xnet.DestinationFromAddr(conn.RemoteAddr())
+	if err := h.refuseWhileDraining(); err != nil {
+		errors.LogDebugInner(ctx, err, "NAT: dropped inbound connection from ", peer)
+		return
+	}
+	if err := h.admitNewSession(); err != nil {
+		errors.LogDebugInner(ctx, err, "NAT: dropped inbound connection from ", peer)
+		return
+	}
+
+	internalConn, err := internet.DialSystem(ctx, internal, nil)
+	if err != nil {
+		atomic.AddInt64(&h.dialFailures, 1)
+		errors.LogWarningInner(ctx, err, "NAT: failed to reach internal endpoint ", internal)
+		return
+	}
+	defer internalConn.Close()
+
+	natSession := h.createNATSession(peer, internal, internal, "inbound", h.classOfRule(ruleID))
+	natSession.RuleID = ruleID
+	h.setTCPState(natSession, tcpStateEstablished)
+	h.announceSession(natSession)
+	defer h.removeSession(natSession.SessionID)
+
+	plcy := h.policy()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := signal.CancelAfterInactivity(ctx, cancel, plcy.Timeouts.ConnectionIdle)
+
+	flow := h.newTCPFlow(natSession, conn, internalConn)
+	toInternal := func() error {
+		defer timer.SetTimeout(plcy.Timeouts.UplinkOnly)
+		err := buf.Copy(newCountingReader(buf.NewReader(conn), h, natSession, false), buf.NewWriter(internalConn), buf.UpdateActivity(timer))
+		return flow.finish(err, func() bool { return closeWrite(internalConn) })
+	}
+	toPeer := func() error {
+		defer timer.SetTimeout(plcy.Timeouts.DownlinkOnly)
+		err := buf.Copy(newCountingReader(buf.NewReader(internalConn), h, natSession, true), buf.NewWriter(conn), buf.UpdateActivity(timer))
+		return flow.finish(err, func() bool { return closeWrite(conn) })
+	}
+	if err := task.Run(ctx, toInternal, toPeer); err != nil {
+		errors.LogDebugInner(ctx, err, "NAT: inbound connection from ", peer, " ended")
+	}
+}
+
+// relayInboundUDP relays the datagrams reaching listener to target and the replies of target
+// back, each peer through a socket of its own tracked as an inbound session. With latch only
+// the first peer is relayed and the listener closes once it is idle for the UDP timeout;
+// otherwise the listener stays open until it is closed and idle peers are dropped.
+func (h *Handler) relayInboundUDP(ctx context.Context, listener *net.UDPConn, target xnet.Destination, ruleID string, latch bool) {
+	defer listener.Close()
+	ctx = context.WithoutCancel(ctx)
+	targetAddr := &net.UDPAddr{IP: target.Address.IP(), Port: int(target.Port)}
+	idle := h.sessionTimeout(&NATSession{Protocol: "udp"})
+
+	type peerRelay struct {
+		conn    *net.UDPConn
+		session *NATSession
+	}
+	var access sync.Mutex
+	peers := make(map[netip.AddrPort]*peerRelay)
+	var relays sync.WaitGroup
+	defer func() {
+		access.Lock()
+		for _, relay := range peers {
+			relay.conn.Close()
+		}
+		access.Unlock()
+		relays.Wait()
+	}()
+
+	packet := buf.New()
+	defer packet.Release()
+	payload := packet.Extend(buf.Size)
+	for {
+		if latch {
+			listener.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, from, err := listener.ReadFromUDPAddrPort(payload)
+		if err != nil {
+			return
+		}
+		access.Lock()
+		relay, found := peers[from]
+		if !found && latch && len(peers) > 0 {
+			// Latched to the first peer
+			access.Unlock()
+			continue
+		}
+		if !found {
+			err := h.refuseWhileDraining()
+			if err == nil {
+				err = h.admitNewSession()
+			}
+			if err != nil {
+				access.Unlock()
+				errors.LogDebugInner(ctx, err, "NAT: dropped inbound datagram from ", from)
+				continue
+			}
+			conn, err := net.DialUDP("udp", nil, targetAddr)
+			if err != nil {
+				access.Unlock()
+				atomic.AddInt64(&h.dialFailures, 1)
+				errors.LogWarningInner(ctx, err, "NAT: failed to relay to ", target)
+				continue
+			}
+			natSession := h.createNATSession(xnet.UDPDestination(xnet.IPAddress(from.Addr().AsSlice()), xnet.Port(from.Port())), target, target, "inbound", h.classOfRule(ruleID))
+			natSession.RuleID = ruleID
+			var closer io.Closer = conn
+			natSession.relay.Store(&closer)
+			h.announceSession(natSession)
+			relay = &peerRelay{conn: conn, session: natSession}
+			peers[from] = relay
+
+			relays.Add(1)
+			go func(peer netip.AddrPort) {
+				defer relays.Done()
+				defer h.removeSession(relay.session.SessionID)
+				buffer := buf.New()
+				defer buffer.Release()
+				reply := buffer.Extend(buf.Size)
+				for {
+					relay.conn.SetReadDeadline(time.Now().Add(idle))
+					n, err := relay.conn.Read(reply)
+					if err != nil {
+						break
+					}
+					relay.session.record(false, int64(n), 1)
+					listener.WriteToUDPAddrPort(reply[:n], peer)
+				}
+				relay.conn.Close()
+				if latch {
+					listener.Close()
+					return
+				}
+				access.Lock()
+				delete(peers, peer)
+				access.Unlock()
+			}(from)
+		}
+		access.Unlock()
+		relay.session.record(true, int64(n), 1)
+		relay.conn.Write(payload[:n])
+	}
+}
@@ -0,0 +1,179 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// defaultPSIDOffset is the PSID offset of RFC 7597, excluding the system ports 0-1023
+const defaultPSIDOffset = 6
+
+// mapRule is a basic mapping rule of MAP (RFC 7597). The suffix of a virtual IPv4 address in the
+// rule's IPv4 prefix and the port set id (PSID) of the destination port form the embedded address
+// (EA) bits appended to the rule's IPv6 prefix; the interface id of the MAP node embeds the IPv4
+// address and the PSID, so both directions are computed without per-flow state.
+type mapRule struct {
+	id         string
+	ipv4Prefix *net.IPNet
+	ipv6Prefix *net.IPNet
+	eaLen      int
+	psidLen    int
+	psidOffset int
+}
+
+// mapRules are the basic mapping rules in match order; a nil list translates nothing
+type mapRules []*mapRule
+
+func newMapRule(config *MapRule) (*mapRule, error) {
+	r := &mapRule{id: config.RuleId, eaLen: int(config.EaBitsLength), psidOffset: int(config.PsidOffset)}
+	if r.id == "" {
+		r.id = "map-" + config.Ipv4Prefix
+	}
+	var err error
+	if _, r.ipv4Prefix, err = net.ParseCIDR(config.Ipv4Prefix); err != nil || r.ipv4Prefix.IP.To4() == nil {
+		return nil, errors.New("invalid ipv4Prefix ", config.Ipv4Prefix)
+	}
+	if _, r.ipv6Prefix, err = net.ParseCIDR(config.Ipv6Prefix); err != nil || r.ipv6Prefix.IP.To4() != nil {
+		return nil, errors.New("invalid ipv6Prefix ", config.Ipv6Prefix)
+	}
+	if r.psidOffset == 0 {
+		r.psidOffset = defaultPSIDOffset
+	}
+
+	ipv4Len, _ := r.ipv4Prefix.Mask.Size()
+	ipv6Len, _ := r.ipv6Prefix.Mask.Size()
+	r.psidLen = r.eaLen - (32 - ipv4Len)
+	switch {
+	case r.psidLen < 0:
+		return nil, errors.New("eaBitsLength ", r.eaLen, " is shorter than the ", 32-ipv4Len, " suffix bits of ", config.Ipv4Prefix)
+	case r.psidLen > 16:
+		return nil, errors.New("eaBitsLength ", r.eaLen, " leaves ", r.psidLen, " PSID bits, more than a port has")
+	case ipv6Len+r.eaLen > 64:
+		return nil, errors.New("ipv6Prefix ", config.Ipv6Prefix, " and eaBitsLength ", r.eaLen, " exceed the 64 bits of the end-user prefix")
+	case r.psidLen > 0 && r.psidOffset+r.psidLen > 16:
+		return nil, errors.New("psidOffset ", r.psidOffset, " leaves no room in the port for ", r.psidLen, " PSID bits")
+	}
+	return r, nil
+}
+
+func newMapRules(configs []*MapRule) (mapRules, error) {
+	var rules mapRules
+	for _, config := range configs {
+		r, err := newMapRule(config)
+		if err != nil {
+			return nil, errors.New("invalid MAP rule ", config.RuleId).Base(err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// ValidateMapRule checks the prefixes and bit lengths of a MAP rule
+func ValidateMapRule(config *MapRule) error {
+	_, err := newMapRule(config)
+	return err
+}
+
+// psid returns the port set id of port, false for the ports excluded by the PSID offset
+func (r *mapRule) psid(port xnet.Port) (uint16, bool) {
+	if r.psidLen == 0 {
+		return 0, true
+	}
+	p := uint16(port)
+	if p>>(16-r.psidOffset) == 0 {
+		return 0, false
+	}
+	return p >> (16 - r.psidOffset - r.psidLen) & (1<<r.psidLen - 1), true
+}
+
+// address returns the IPv6 address of the MAP node serving ip and the port set of psid
+func (r *mapRule) address(ip net.IP, psid uint16) net.IP {
+	ip4 := ip.To4()
+	ipv4Len, _ := r.ipv4Prefix.Mask.Size()
+	ipv6Len, _ := r.ipv6Prefix.Mask.Size()
+	suffix := uint64(binary.BigEndian.Uint32(ip4)) & (1<<(32-ipv4Len) - 1)
+	ea := suffix<<r.psidLen | uint64(psid)
+
+	out := make(net.IP, net.IPv6len)
+	prefix := binary.BigEndian.Uint64(r.ipv6Prefix.IP.To16())
+	if r.eaLen > 0 {
+		prefix |= ea << (64 - ipv6Len - r.eaLen)
+	}
+	binary.BigEndian.PutUint64(out, prefix)
+	// Interface id: 16 zero bits, the IPv4 address and the PSID (RFC 7597 Section 6)
+	copy(out[10:14], ip4)
+	binary.BigEndian.PutUint16(out[14:], psid)
+	return out
+}
+
+// rule returns the dynamic rule translating an IPv4 destination by the first MAP rule covering it
+func (rules mapRules) rule(destination xnet.Destination) (*NATRule, bool) {
+	if len(rules) == 0 || !destination.Address.Family().IsIPv4() {
+		return nil, false
+	}
+	ip := destination.Address.IP()
+	for _, r := range rules {
+		if !r.ipv4Prefix.Contains(ip) {
+			continue
+		}
+		psid, ok := r.psid(destination.Port)
+		if !ok {
+			continue
+		}
+		return &NATRule{
+			RuleId:             r.id,
+			VirtualDestination: ip.String(),
+			RealDestination:    r.address(ip, psid).String(),
+			Protocol:           "tcp,udp",
+		}, true
+	}
+	return nil, false
+}
+
+// owns reports whether rule was created by a MAP rule
+func (rules mapRules) owns(rule *NATRule) bool {
+	for _, r := range rules {
+		if r.id == rule.RuleId {
+			return true
+		}
+	}
+	return false
+}
+
+// virtualAddressOf returns the IPv4 address the interface id of a MAP node address embeds
+func (rules mapRules) virtualAddressOf(ip net.IP) (net.IP, bool) {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil, false
+	}
+	for _, r := range rules {
+		ip4 := net.IP(ip16[10:14])
+		if r.ipv6Prefix.Contains(ip16) && r.ipv4Prefix.Contains(ip4) {
+			return net.IPv4(ip4[0], ip4[1], ip4[2], ip4[3]).To4(), true
+		}
+	}
+	return nil, false
+}
+
+// handleMappedOutbound relays a flow of a MAP rule to the MAP node its destination maps to. The
+// mapping is a function of the destination, so the flow holds no session and is accounted only
+// in the hits of the rule.
+func (h *Handler) handleMappedOutbound(ctx context.Context, link *transport.Link, destination xnet.Destination, dialer internet.Dialer, rule *NATRule) error {
+	transformedDest, err := h.applyDNAT(destination, rule)
+	if err != nil {
+		return errors.New("MAP translation failed").Base(err)
+	}
+	ctx = h.withSendThrough(ctx, transformedDest)
+	metrics := h.ruleMetricsOf(rule.RuleId, destination.Network)
+	metrics.hits.Add(1)
+	metrics.lastHit.Store(time.Now().UnixNano())
+	errors.LogDebug(ctx, "NAT mapped ", destination, " to ", transformedDest, " by MAP rule ", rule.RuleId)
+	return h.handleNormalOutbound(ctx, link, transformedDest, dialer)
+}
@@ -0,0 +1,122 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// sessionCountingDialer records the destination dialed and the sessions held at the time
+type sessionCountingDialer struct {
+	udpTestDialer
+	handler  *Handler
+	dialed   chan xnet.Destination
+	sessions chan int
+}
+
+func (d sessionCountingDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	select {
+	case d.dialed <- dest:
+		d.sessions <- len(d.handler.Sessions())
+	default:
+	}
+	return nil, errors.New("flow left the NAT")
+}
+
+func TestMapRules(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	// The basic mapping rule of RFC 7597 Appendix A, Example 1
+	if err := handler.Init(&Config{
+		SiteId:   "test-site",
+		Rules:    []*NATRule{{RuleId: "web", VirtualDestination: "192.0.2.80", RealDestination: "192.168.1.80"}},
+		MapRules: []*MapRule{{Ipv4Prefix: "192.0.2.0/24", Ipv6Prefix: "2001:db8::/40", EaBitsLength: 16}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Port 1232 is in the port set 0x34, so 192.0.2.18 maps to the MAP node of EA bits 0x1234
+	translation, err := handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("192.0.2.18"), 1232))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.Rule == nil || translation.Rule.RuleId != "map-192.0.2.0/24" || translation.RealDestination.NetAddr() != "[2001:db8:12:3400:0:c000:212:34]:1232" {
+		t.Errorf("Unexpected translation %+v", translation)
+	}
+	if ip, ok := handler.virtualAddressOf(net.ParseIP("2001:db8:12:3400:0:c000:212:34")); !ok || !ip.Equal(net.ParseIP("192.0.2.18")) {
+		t.Errorf("Expected the MAP node address to map back to 192.0.2.18, got %v", ip)
+	}
+	// Rules keep translating their destinations; the system ports are in no port set
+	if translation, _ := handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("192.0.2.80"), 1232)); translation.Rule == nil || translation.Rule.RuleId != "web" {
+		t.Errorf("Expected rule web, got %+v", translation)
+	}
+	if translation, _ := handler.TestTranslation(xnet.Destination{}, xnet.TCPDestination(xnet.ParseAddress("192.0.2.18"), 80)); translation.Rule != nil {
+		t.Errorf("Expected port 80 to be left alone, got %+v", translation)
+	}
+
+	// Flows are relayed without a session
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)})
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: xnet.TCPDestination(xnet.ParseAddress("192.0.2.18"), 1233)}})
+	uplinkReader, _ := pipe.New()
+	_, downlinkWriter := pipe.New()
+	dialer := sessionCountingDialer{handler: handler, dialed: make(chan xnet.Destination, 1), sessions: make(chan int, 1)}
+	if err := handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, dialer); err == nil {
+		t.Fatal("Expected the dial to fail")
+	}
+	if dialed := <-dialer.dialed; dialed.NetAddr() != "[2001:db8:12:3400:0:c000:212:34]:1233" {
+		t.Errorf("Unexpected destination dialed %v", dialed)
+	}
+	if sessions := <-dialer.sessions; sessions != 0 {
+		t.Errorf("Expected no session for the flow, got %d", sessions)
+	}
+	if stats := handler.RuleStats(); len(stats) != 2 || stats[1].RuleID != "map-192.0.2.0/24" || stats[1].Hits != 1 {
+		t.Errorf("Expected the flow to count as a hit of the MAP rule, got %+v", stats)
+	}
+}
+
+func TestMapRulePortSets(t *testing.T) {
+	// A shared address: suffix 8 bits, PSID 4 bits at the default offset
+	rule, err := newMapRule(&MapRule{RuleId: "shared", Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "2001:db8:100::/48", EaBitsLength: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for port, expected := range map[xnet.Port]string{
+		1024:  "2001:db8:100:700:0:c633:6407:0",
+		4160:  "2001:db8:100:710:0:c633:6407:1",
+		65535: "2001:db8:100:7f0:0:c633:6407:f",
+	} {
+		mapped, ok := (mapRules{rule}).rule(xnet.UDPDestination(xnet.ParseAddress("198.51.100.7"), port))
+		if !ok || mapped.RuleId != "shared" || mapped.RealDestination != expected {
+			t.Errorf("Expected port %d to map to %s, got %v", port, expected, mapped)
+		}
+	}
+
+	// A full address per node needs no port set
+	rule, err = newMapRule(&MapRule{Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "2001:db8:100::/48", EaBitsLength: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped, ok := (mapRules{rule}).rule(xnet.TCPDestination(xnet.ParseAddress("198.51.100.7"), 80)); !ok || mapped.RealDestination != "2001:db8:100:700:0:c633:6407:0" {
+		t.Errorf("Unexpected mapping %v", mapped)
+	}
+
+	for _, config := range []*MapRule{
+		{Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "2001:db8::/40", EaBitsLength: 4},
+		{Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "2001:db8::/40", EaBitsLength: 25},
+		{Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "2001:db8::/56", EaBitsLength: 16},
+		{Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "2001:db8::/40", EaBitsLength: 16, PsidOffset: 12},
+		{Ipv4Prefix: "2001:db8::/64", Ipv6Prefix: "2001:db8::/40", EaBitsLength: 16},
+		{Ipv4Prefix: "198.51.100.0/24", Ipv6Prefix: "198.51.0.0/16", EaBitsLength: 16},
+	} {
+		if err := ValidateMapRule(config); err == nil {
+			t.Errorf("Expected %v to be rejected", config)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package nat
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+const (
+	// defaultMasqueradePorts are the source ports of masquerade sessions unless the range sets them
+	defaultMasqueradePorts = "1024-65535"
+	// maxMasqueradePool bounds the addresses of a masquerade pool
+	maxMasqueradePool = 256
+)
+
+// masqueradeTable is the port allocation table of a masquerade range. Every virtual address of
+// the range reaches the same real address, so two flows of one source to different virtual
+// addresses would collide on the real side; each session gets a source endpoint of its own
+// instead, from a pool of real source addresses with separate TCP and UDP port spaces.
+type masqueradeTable struct {
+	sync.Mutex
+	ports     portList
+	arbitrary bool                          // Arbitrary rather than paired pooling
+	addresses []*masqueradeAddress          // Real source addresses of the pool
+	paired    map[string]*masqueradePairing // Virtual source address -> pool address of its sessions
+}
+
+// masqueradeAddress is a real source address of a masquerade pool
+type masqueradeAddress struct {
+	address   xnet.Address
+	spaces    map[xnet.Network]*masqueradePorts
+	exhausted uint64 // Allocations that found no free port on the address
+}
+
+// masqueradePorts is the port space of one protocol on a pool address
+type masqueradePorts struct {
+	used   map[xnet.Port]bool
+	cursor int // Next position of the port list to try, so released ports are not reused immediately
+}
+
+// masqueradePairing pins the sessions of a virtual source to one pool address while it holds any
+type masqueradePairing struct {
+	address *masqueradeAddress
+	refs    int
+}
+
+func newMasqueradeTable(vrange *VirtualIPRange) (*masqueradeTable, error) {
+	realIP := net.ParseIP(vrange.RealNetwork)
+	if realIP == nil {
+		return nil, errors.New("the real network of a masquerade range must be a single address, got ", vrange.RealNetwork)
+	}
+	if vrange.Ipv4To6Prefix != "" || vrange.NpTv6VirtualPrefix != "" || vrange.Symmetric {
+		return nil, errors.New("masquerade ranges cannot be combined with NAT46, NPTv6 or symmetric")
+	}
+	if err := ValidateMasqueradePool(vrange.MasqueradePool, vrange.MasqueradePooling); err != nil {
+		return nil, err
+	}
+	spec := vrange.MasqueradePorts
+	if spec == "" {
+		spec = defaultMasqueradePorts
+	}
+	ports, err := parsePortList(spec)
+	if err != nil {
+		return nil, errors.New("invalid masquerade ports").Base(err)
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("masquerade ports cannot be any")
+	}
+
+	t := &masqueradeTable{ports: ports, arbitrary: vrange.MasqueradePooling == "arbitrary", paired: make(map[string]*masqueradePairing)}
+	sources, _ := masqueradePoolAddresses(vrange.MasqueradePool)
+	if len(sources) == 0 {
+		source := net.IPv4zero
+		if vrange.SendThrough != "" {
+			if source = net.ParseIP(vrange.SendThrough); source == nil {
+				return nil, errors.New("invalid sendThrough ", vrange.SendThrough)
+			}
+		}
+		sources = []net.IP{source}
+	}
+	for _, source := range sources {
+		if len(vrange.MasqueradePool) > 0 && (source.To4() == nil) != (realIP.To4() == nil) {
+			return nil, errors.New("masquerade pool address ", source, " is not of the family of ", vrange.RealNetwork)
+		}
+		t.addresses = append(t.addresses, &masqueradeAddress{address: xnet.IPAddress(source), spaces: make(map[xnet.Network]*masqueradePorts)})
+	}
+	return t, nil
+}
+
+// ValidateMasqueradePool checks the addresses and the pooling behavior of a masquerade pool
+func ValidateMasqueradePool(pool []string, pooling string) error {
+	switch pooling {
+	case "", "paired", "arbitrary":
+	default:
+		return errors.New("masquerade pooling ", pooling, " is not one of paired, arbitrary")
+	}
+	_, err := masqueradePoolAddresses(pool)
+	return err
+}
+
+// masqueradePoolAddresses expands the addresses and networks of a masquerade pool. Networks of
+// four addresses or more leave out their first and, for IPv4, their last address.
+func masqueradePoolAddresses(pool []string) ([]net.IP, error) {
+	var addresses []net.IP
+	seen := make(map[string]bool)
+	for _, entry := range pool {
+		network, err := parseNetworkOrIP(entry)
+		if err != nil {
+			return nil, errors.New("invalid masquerade pool entry ", entry).Base(err)
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones > 8 {
+			return nil, errors.New("masquerade pool network ", entry, " is larger than ", maxMasqueradePool, " addresses")
+		}
+		size := 1 << (bits - ones)
+		first, last := 0, size-1
+		if size >= 4 {
+			first = 1
+			if bits == 32 {
+				last = size - 2
+			}
+		}
+		for offset := first; offset <= last; offset++ {
+			ip := make(net.IP, len(network.IP))
+			copy(ip, network.IP)
+			ip[len(ip)-1] += byte(offset)
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				addresses = append(addresses, ip)
+			}
+		}
+		if len(addresses) > maxMasqueradePool {
+			return nil, errors.New("masquerade pool holds more than ", maxMasqueradePool, " addresses")
+		}
+	}
+	return addresses, nil
+}
+
+// take allocates a free port of network, the preferred one when it is free so flows keep their
+// source port as long as nothing collides
+func (a *masqueradeAddress) take(ports portList, network xnet.Network, preferred xnet.Port) (xnet.Port, bool) {
+	space := a.spaces[network]
+	if space == nil {
+		space = &masqueradePorts{used: make(map[xnet.Port]bool)}
+		a.spaces[network] = space
+	}
+	if _, listed := ports.indexOf(preferred); listed && !space.used[preferred] {
+		space.used[preferred] = true
+		return preferred, true
+	}
+	size := ports.size()
+	if len(space.used) >= size {
+		a.exhausted++
+		return 0, false
+	}
+	for i := 0; i < size; i++ {
+		index := (space.cursor + i) % size
+		if port := ports.at(index); !space.used[port] {
+			space.used[port] = true
+			space.cursor = index + 1
+			return port, true
+		}
+	}
+	a.exhausted++
+	return 0, false
+}
+
+// candidates returns the pool addresses in the order a virtual source tries them: highest
+// rendezvous hash first, so a source keeps its address as the pool changes and spills over to
+// the same next address every time
+func (t *masqueradeTable) candidates(key string) []*masqueradeAddress {
+	if len(t.addresses) == 1 || key == "" {
+		return t.addresses
+	}
+	scores := make(map[*masqueradeAddress]uint64, len(t.addresses))
+	for _, a := range t.addresses {
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(a.address.String()))
+		scores[a] = hash.Sum64()
+	}
+	ordered := append([]*masqueradeAddress(nil), t.addresses...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+	return ordered
+}
+
+// Allocate returns the real source endpoint of a session of network from a virtual source. With
+// paired pooling the sessions of a source stay on the address of its first session while it
+// holds any; an exhausted address spills new sources, or with arbitrary pooling new sessions,
+// over to the next address.
+func (t *masqueradeTable) Allocate(ctx context.Context, network xnet.Network, source xnet.Destination) (xnet.Destination, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	var key string
+	if source.Address != nil {
+		key = source.Address.String()
+	}
+	if pairing := t.paired[key]; !t.arbitrary && pairing != nil {
+		port, ok := pairing.address.take(t.ports, network, source.Port)
+		if !ok {
+			return xnet.Destination{}, errors.New("masquerade ports of ", pairing.address.address, " paired with ", key, " exhausted for ", network).Base(ErrExhausted)
+		}
+		pairing.refs++
+		return xnet.Destination{Network: network, Address: pairing.address.address, Port: port}, nil
+	}
+
+	candidates := t.candidates(key)
+	for i, candidate := range candidates {
+		port, ok := candidate.take(t.ports, network, source.Port)
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			errors.LogInfo(ctx, "NAT: masquerade ports of ", candidates[0].address, " exhausted for ", network, ", spilled ", key, " over to ", candidate.address)
+		}
+		if !t.arbitrary && key != "" {
+			t.paired[key] = &masqueradePairing{address: candidate, refs: 1}
+		}
+		return xnet.Destination{Network: network, Address: candidate.address, Port: port}, nil
+	}
+	return xnet.Destination{}, errors.New("masquerade ports exhausted for ", network, " on every pool address").Base(ErrExhausted)
+}
+
+// sourceOf returns the pool address the next session of a virtual source would use while no
+// address is exhausted
+func (t *masqueradeTable) sourceOf(source xnet.Destination) xnet.Address {
+	t.Lock()
+	defer t.Unlock()
+	var key string
+	if source.Address != nil {
+		key = source.Address.String()
+	}
+	if pairing := t.paired[key]; !t.arbitrary && pairing != nil {
+		return pairing.address.address
+	}
+	return t.candidates(key)[0].address
+}
+
+// Release returns the real source endpoint of a session from a virtual source
+func (t *masqueradeTable) Release(source, realSource xnet.Destination) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, a := range t.addresses {
+		if a.address.String() != realSource.Address.String() {
+			continue
+		}
+		if space := a.spaces[realSource.Network]; space != nil {
+			delete(space.used, realSource.Port)
+		}
+	}
+	if source.Address == nil {
+		return
+	}
+	key := source.Address.String()
+	if pairing := t.paired[key]; pairing != nil {
+		if pairing.refs--; pairing.refs <= 0 {
+			delete(t.paired, key)
+		}
+	}
+}
+
+// InUse returns how many source ports of network are held on the pool addresses
+func (t *masqueradeTable) InUse(network xnet.Network) int {
+	t.Lock()
+	defer t.Unlock()
+	inUse := 0
+	for _, a := range t.addresses {
+		if space := a.spaces[network]; space != nil {
+			inUse += len(space.used)
+		}
+	}
+	return inUse
+}
+
+// newMasqueradeTables builds the port allocation tables of the masquerade ranges
+func newMasqueradeTables(ranges []*VirtualIPRange) (map[*VirtualIPRange]*masqueradeTable, error) {
+	var tables map[*VirtualIPRange]*masqueradeTable
+	for _, vrange := range ranges {
+		if !vrange.Masquerade {
+			continue
+		}
+		table, err := newMasqueradeTable(vrange)
+		if err != nil {
+			return nil, errors.New("invalid masquerade range ", vrange.VirtualNetwork).Base(err)
+		}
+		if tables == nil {
+			tables = make(map[*VirtualIPRange]*masqueradeTable)
+		}
+		tables[vrange] = table
+	}
+	return tables, nil
+}
+
+// masqueradeOf returns the port allocation table of the masquerade range translating into a real address
+func (h *Handler) masqueradeOf(realAddress xnet.Address) *masqueradeTable {
+	if len(h.masquerades) == 0 || realAddress == nil || !realAddress.Family().IsIP() {
+		return nil
+	}
+	for _, vrange := range h.config.VirtualRanges {
+		if table := h.masquerades[vrange]; table != nil && net.ParseIP(vrange.RealNetwork).Equal(realAddress.IP()) {
+			return table
+		}
+	}
+	return nil
+}
+
+// allocateMasqueradePort assigns the translated source endpoint of a session into a masquerade
+// range, unless CGNAT already assigned one, and makes the outbound dial from its address
+func (h *Handler) allocateMasqueradePort(ctx context.Context, natSession *NATSession) error {
+	if natSession.RealSource.Port != 0 {
+		return nil
+	}
+	table := h.masqueradeOf(natSession.RealDest.Address)
+	if table == nil {
+		return nil
+	}
+	realSource, err := table.Allocate(ctx, natSession.RealDest.Network, natSession.VirtualSource)
+	if err != nil {
+		return err
+	}
+	natSession.RealSource = realSource
+	natSession.masquerade = table
+
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 && !realSource.Address.IP().IsUnspecified() {
+		outbounds[len(outbounds)-1].Gateway = realSource.Address
+	}
+	return nil
+}
+
+// releaseMasqueradePort returns the source endpoint of a removed masquerade session
+func (h *Handler) releaseMasqueradePort(natSession *NATSession) {
+	if natSession.masquerade != nil {
+		natSession.masquerade.Release(natSession.VirtualSource, natSession.RealSource)
+	}
+}
@@ -0,0 +1,241 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestMasqueradeTable(t *testing.T) {
+	table, err := newMasqueradeTable(&VirtualIPRange{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePorts: "20000-20002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	allocate := func(network xnet.Network, port xnet.Port) (xnet.Port, error) {
+		realSource, err := table.Allocate(ctx, network, xnet.Destination{Network: network, Address: xnet.ParseAddress("172.16.0.5"), Port: port})
+		return realSource.Port, err
+	}
+
+	// The source port is kept while it is free; collisions move to the next free port
+	if port, err := allocate(xnet.Network_TCP, 20001); err != nil || port != 20001 {
+		t.Errorf("Expected the preferred port 20001, got %d, %v", port, err)
+	}
+	if port, err := allocate(xnet.Network_TCP, 20001); err != nil || port != 20000 {
+		t.Errorf("Expected port 20000 for a colliding flow, got %d, %v", port, err)
+	}
+	if port, err := allocate(xnet.Network_TCP, 40000); err != nil || port != 20002 {
+		t.Errorf("Expected port 20002 for a source port outside the range, got %d, %v", port, err)
+	}
+	if _, err := allocate(xnet.Network_TCP, 40000); err == nil {
+		t.Error("Expected the TCP ports to be exhausted")
+	}
+
+	// UDP has a port space of its own
+	if port, err := allocate(xnet.Network_UDP, 20001); err != nil || port != 20001 {
+		t.Errorf("Expected the preferred UDP port 20001, got %d, %v", port, err)
+	}
+
+	table.Release(xnet.TCPDestination(xnet.ParseAddress("172.16.0.5"), 0), xnet.TCPDestination(xnet.AnyIP, 20000))
+	if port, err := allocate(xnet.Network_TCP, 40000); err != nil || port != 20000 {
+		t.Errorf("Expected the released port 20000, got %d, %v", port, err)
+	}
+
+	for _, vrange := range []*VirtualIPRange{
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", Masquerade: true},
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePorts: "any"},
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, Ipv4To6Prefix: "64:ff9b::/96"},
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePool: []string{"10.0.0.0/16"}},
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePool: []string{"fd00::1"}},
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, MasqueradePooling: "random"},
+	} {
+		if _, err := newMasqueradeTable(vrange); err == nil {
+			t.Errorf("Expected error for masquerade range %v", vrange)
+		}
+	}
+}
+
+func TestMasqueradeRange(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "site-a",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.10", Masquerade: true, SendThrough: "10.0.0.1"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every virtual address reaches the single real address
+	source := xnet.TCPDestination(xnet.ParseAddress("172.16.0.5"), 40000)
+	var sessions []*NATSession
+	for _, address := range []string{"240.2.2.20", "240.2.2.21"} {
+		destination := xnet.TCPDestination(xnet.ParseAddress(address), 443)
+		rule, ok := handler.shouldApplyNAT(context.Background(), destination)
+		if !ok {
+			t.Fatalf("Expected %s to be translated", address)
+		}
+		realDest, err := handler.applyDNAT(destination, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if realDest.NetAddr() != "192.168.1.10:443" {
+			t.Errorf("Expected %s to map to 192.168.1.10:443, got %s", address, realDest.NetAddr())
+		}
+
+		natSession := handler.createNATSession(source, destination, realDest, "outbound", classInteractive)
+		if err := handler.allocateMasqueradePort(context.Background(), natSession); err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, natSession)
+	}
+
+	// The second flow of the source collides with the first and moves to another port
+	if sessions[0].RealSource.NetAddr() != "10.0.0.1:40000" {
+		t.Errorf("Expected the first flow to keep its source port, got %s", sessions[0].RealSource.NetAddr())
+	}
+	if sessions[1].RealSource.Address.String() != "10.0.0.1" || sessions[1].RealSource.Port == sessions[0].RealSource.Port {
+		t.Errorf("Expected the second flow on another port of 10.0.0.1, got %s", sessions[1].RealSource.NetAddr())
+	}
+
+	// Removed sessions return their ports
+	table := handler.masquerades[handler.config.VirtualRanges[0]]
+	for _, natSession := range sessions {
+		handler.removeSession(natSession.SessionID)
+	}
+	if inUse := table.InUse(xnet.Network_TCP); inUse != 0 {
+		t.Errorf("Expected no port in use after the sessions ended, got %d", inUse)
+	}
+
+	// The real address does not translate back into one of the virtual addresses
+	if virtualIP, ok := handler.virtualAddressOf(xnet.ParseAddress("192.168.1.10").IP()); ok {
+		t.Errorf("Expected no virtual address for the masquerade real address, got %s", virtualIP)
+	}
+
+	translation, err := handler.TestTranslation(source, xnet.TCPDestination(xnet.ParseAddress("240.2.2.30"), 443))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translation.RealSource.Address.String() != "10.0.0.1" || translation.RealDestination.NetAddr() != "192.168.1.10:443" {
+		t.Errorf("Unexpected translation %+v", translation)
+	}
+}
+
+func TestMasqueradePool(t *testing.T) {
+	ctx := context.Background()
+	newTable := func(pooling string) *masqueradeTable {
+		table, err := newMasqueradeTable(&VirtualIPRange{
+			VirtualNetwork:    "240.0.0.0/16",
+			RealNetwork:       "192.168.1.10",
+			Masquerade:        true,
+			MasqueradePorts:   "20000-20001",
+			MasqueradePool:    []string{"10.0.0.0/30", "10.0.1.1"},
+			MasqueradePooling: pooling,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return table
+	}
+
+	// The networks of the pool leave out their network and broadcast addresses
+	table := newTable("")
+	var addresses []string
+	for _, a := range table.addresses {
+		addresses = append(addresses, a.address.String())
+	}
+	if strings.Join(addresses, ",") != "10.0.0.1,10.0.0.2,10.0.1.1" {
+		t.Errorf("Unexpected pool addresses %v", addresses)
+	}
+
+	// Paired pooling keeps the sessions of a source on the address of its first session and
+	// fails once its ports are exhausted; new sources spill over to the next address
+	source := xnet.TCPDestination(xnet.ParseAddress("240.0.3.7"), 40000)
+	first, err := table.Allocate(ctx, xnet.Network_TCP, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := table.candidates("240.0.3.7")[0].address; first.Address != expected {
+		t.Errorf("Expected the address hashed from the source, %s, got %s", expected, first.Address)
+	}
+	if second, err := table.Allocate(ctx, xnet.Network_TCP, source); err != nil || second.Address != first.Address {
+		t.Errorf("Expected the paired address %s, got %s, %v", first.Address, second.Address, err)
+	}
+	if _, err := table.Allocate(ctx, xnet.Network_TCP, source); err == nil {
+		t.Error("Expected the ports of the paired address to be exhausted")
+	}
+	spilled := 0
+	for i := 0; i < 4; i++ {
+		other, err := table.Allocate(ctx, xnet.Network_TCP, xnet.TCPDestination(xnet.ParseAddress(fmt.Sprint("240.0.4.", i)), 40000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Address == first.Address {
+			t.Errorf("Expected a new source to spill over from the exhausted %s", first.Address)
+		}
+		if table.candidates(fmt.Sprint("240.0.4.", i))[0].address != other.Address {
+			spilled++
+		}
+	}
+	if spilled == 0 {
+		t.Error("Expected some new sources to spill over")
+	}
+
+	// Arbitrary pooling moves the next session of the source to another address
+	table = newTable("arbitrary")
+	for i := 0; i < 3; i++ {
+		if _, err := table.Allocate(ctx, xnet.Network_TCP, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inUse := table.InUse(xnet.Network_TCP); inUse != 3 {
+		t.Errorf("Expected 3 ports in use, got %d", inUse)
+	}
+	if len(table.paired) != 0 {
+		t.Error("Expected no pairing with arbitrary pooling")
+	}
+}
+
+func TestMasqueradePortOnTheWire(t *testing.T) {
+	held := listenTCP(t)
+	port := held.Addr().(*net.TCPAddr).Port
+	rule := &NATRule{RuleId: "local", VirtualDestination: "240.2.2.1", RealDestination: "127.0.0.1"}
+	newHandler := func() *Handler {
+		handler := New()
+		t.Cleanup(func() { handler.Close() })
+		if err := handler.Init(&Config{
+			SiteId: "test-site",
+			VirtualRanges: []*VirtualIPRange{{
+				VirtualNetwork:  "240.2.2.0/24",
+				RealNetwork:     "127.0.0.1",
+				Masquerade:      true,
+				MasqueradePorts: fmt.Sprint(port),
+				MasqueradePool:  []string{"127.0.0.1"},
+			}},
+			Rules: []*NATRule{rule},
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+
+	// The only port of the range is taken, so the flow fails rather than leave from another one
+	if addr, err := sourceSeenByServer(t, newHandler(), rule); err == nil {
+		t.Errorf("Expected the flow to fail when its source port cannot be bound, it left from %v", addr)
+	}
+
+	held.Close()
+	addr, err := sourceSeenByServer(t, newHandler(), rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.Port != port || !addr.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected the server to see the pool address and port 127.0.0.1:%d, got %v", port, addr)
+	}
+}
@@ -0,0 +1,110 @@
+package nat
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// Strategies resolving destinations matched by several rules or ranges
+const (
+	// matchFirstMatch takes the first matching rule in declaration order, then static
+	// mappings, then the first matching range
+	matchFirstMatch = "firstMatch"
+
+	// matchLongestPrefix takes the most specific rule, static mapping or range
+	matchLongestPrefix = "longestPrefix"
+
+	// matchHighestPriority takes the matching rule of the highest priority, then static
+	// mappings, then the first matching range
+	matchHighestPriority = "highestPriority"
+)
+
+// Rule actions
+const (
+	actionTranslate = "translate"
+	actionAllow     = "allow" // Same as translate, for firewall-style rule lists
+	actionBypass    = "bypass"
+	actionDeny      = "deny"   // Silently drops matching flows
+	actionReject    = "reject" // Closes matching flows at once
+)
+
+// ValidateRuleAction checks the action of a rule
+func ValidateRuleAction(action string) error {
+	switch action {
+	case "", actionTranslate, actionAllow, actionBypass, actionDeny, actionReject:
+		return nil
+	default:
+		return errors.New(action, " is not one of translate, allow, bypass, deny, reject")
+	}
+}
+
+// blocksFlows reports whether a rule denies or rejects the flows it matches
+func blocksFlows(rule *NATRule) bool {
+	return rule.Action == actionDeny || rule.Action == actionReject
+}
+
+// ValidateMatchStrategy checks a rule match strategy
+func ValidateMatchStrategy(strategy string) error {
+	switch strategy {
+	case "", matchFirstMatch, matchLongestPrefix, matchHighestPriority:
+		return nil
+	default:
+		return errors.New(strategy, " is not one of firstMatch, longestPrefix, highestPriority")
+	}
+}
+
+func (h *Handler) matchStrategy() string {
+	if h.config == nil || h.config.MatchStrategy == "" {
+		return matchFirstMatch
+	}
+	return h.config.MatchStrategy
+}
+
+// virtualPrefixLen is the number of leading address bits a rule's virtual destination fixes.
+// Patterns of IPv4 embedded in the NAT64 prefix fix the 96 prefix bits and those of the IPv4 part,
+// wildcards the octets ahead of their first "*", and regular expressions none.
+func virtualPrefixLen(virtual string) int {
+	if isPatternDestination(virtual) {
+		if strings.HasPrefix(virtual, regexpPrefix) {
+			return 0
+		}
+		fixed, _, _ := strings.Cut(virtual, "*")
+		return strings.Count(fixed, ".") * 8
+	}
+	if ip := net.ParseIP(virtual); ip != nil {
+		if ip.To4() != nil {
+			return 32
+		}
+		return 128
+	}
+	if _, network, err := net.ParseCIDR(virtual); err == nil {
+		ones, _ := network.Mask.Size()
+		return ones
+	}
+	if i := strings.LastIndex(virtual, "/"); i >= 0 && strings.Contains(virtual, ":") && strings.Contains(virtual, ".") {
+		if bits, err := strconv.Atoi(virtual[i+1:]); err == nil {
+			return 96 + bits
+		}
+	}
+	return 128
+}
+
+// rangePrefixLen is the length of the prefix through which destination matched a range
+func (h *Handler) rangePrefixLen(destination xnet.Destination, vrange *VirtualIPRange) int {
+	prefix := vrange.VirtualNetwork
+	switch {
+	case h.matchesNPTv6Range(destination, vrange):
+		prefix = vrange.NpTv6VirtualPrefix
+	case destination.Address.Family().IsIPv6() && vrange.Ipv6Enabled && vrange.Ipv6VirtualPrefix != "":
+		prefix = vrange.Ipv6VirtualPrefix
+	}
+	if _, network, err := net.ParseCIDR(prefix); err == nil {
+		ones, _ := network.Mask.Size()
+		return ones
+	}
+	return virtualPrefixLen(prefix)
+}
@@ -0,0 +1,100 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestMatchStrategies(t *testing.T) {
+	config := &Config{
+		SiteId: "test-site",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.0.0.0/8", RealNetwork: "10.0.0.0/8"},
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+		},
+		Rules: []*NATRule{
+			{RuleId: "low", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Priority: 1},
+			{RuleId: "high", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21", Priority: 10},
+		},
+	}
+	handler := &Handler{config: config}
+	match := func(address string) string {
+		rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(address), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	// firstMatch: declaration order for rules and ranges
+	if id := match("240.2.2.20"); id != "low" {
+		t.Errorf("firstMatch: expected the first declared rule, got %q", id)
+	}
+	if id := match("240.2.2.30"); id != "dynamic-range-240.0.0.0/8" {
+		t.Errorf("firstMatch: expected the first declared range, got %q", id)
+	}
+
+	config.MatchStrategy = matchHighestPriority
+	if id := match("240.2.2.20"); id != "high" {
+		t.Errorf("highestPriority: expected the rule of the highest priority, got %q", id)
+	}
+
+	config.MatchStrategy = matchLongestPrefix
+	if id := match("240.2.2.30"); id != "dynamic-range-240.2.2.0/24" {
+		t.Errorf("longestPrefix: expected the most specific range, got %q", id)
+	}
+	if id := match("240.2.2.20"); id != "low" {
+		t.Errorf("longestPrefix: expected a host rule over ranges, got %q", id)
+	}
+	if id := match("240.9.9.9"); id != "dynamic-range-240.0.0.0/8" {
+		t.Errorf("longestPrefix: expected the only matching range, got %q", id)
+	}
+}
+
+func TestValidateMatchStrategy(t *testing.T) {
+	for _, strategy := range []string{"", "firstMatch", "longestPrefix", "highestPriority"} {
+		if err := ValidateMatchStrategy(strategy); err != nil {
+			t.Errorf("Expected %q to be valid: %v", strategy, err)
+		}
+	}
+	if ValidateMatchStrategy("bestMatch") == nil {
+		t.Error("Expected error for an unknown match strategy")
+	}
+}
+
+func TestBypassRules(t *testing.T) {
+	handler := &Handler{config: &Config{
+		SiteId: "test-site",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+		},
+		Rules: []*NATRule{
+			{RuleId: "no-nat-gateway", VirtualDestination: "240.2.2.0/30", Action: "bypass"},
+			{RuleId: "no-nat-ssh", VirtualDestination: "240.2.2.0/24", Ports: "22,2200-2299", Action: "bypass"},
+		},
+	}}
+	match := func(address string, port xnet.Port) (string, bool) {
+		rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(address), port))
+		if !ok {
+			return "", false
+		}
+		return rule.RuleId, true
+	}
+
+	if _, ok := match("240.2.2.1", 80); ok {
+		t.Error("Hosts of a bypassed network must not be translated")
+	}
+	if _, ok := match("240.2.2.50", 2222); ok {
+		t.Error("Bypassed ports must not be translated")
+	}
+	if id, ok := match("240.2.2.50", 80); !ok || id != "dynamic-range-240.2.2.0/24" {
+		t.Errorf("Expected other flows to be translated by the range, got %q", id)
+	}
+
+	handler.config.MatchStrategy = matchLongestPrefix
+	if _, ok := match("240.2.2.50", 22); ok {
+		t.Error("Bypass rules must take precedence over ranges under longestPrefix")
+	}
+}
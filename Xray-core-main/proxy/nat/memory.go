@@ -0,0 +1,84 @@
+package nat
+
+import (
+	"unsafe"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// mapEntryOverhead estimates what an entry of the session table or of a sync.Map holds beyond its
+// key and value: the entry, its pointers and the share of the buckets
+const mapEntryOverhead = 64
+
+var sessionStructSize = int64(unsafe.Sizeof(NATSession{}))
+
+// addressSize is the memory an address references beyond the Destination holding it
+func addressSize(destination xnet.Destination) int64 {
+	if destination.Address == nil {
+		return 0
+	}
+	if destination.Address.Family().IsDomain() {
+		return int64(len(destination.Address.Domain()))
+	}
+	return int64(len(destination.Address.IP()))
+}
+
+// sessionSize is the memory a session holds: the session, the strings and addresses it references,
+// its entries in the session table and the tuple index, and the restored index for restored ones.
+// The ALG state of its flow is charged apart, as it grows.
+func sessionSize(s *NATSession) int64 {
+	size := sessionStructSize + 2*mapEntryOverhead
+	// The session ID is held by the session and the tuple index, the tuple key by the index
+	size += int64(2*len(s.SessionID) + len(s.Tuple.Key()))
+	size += int64(len(s.RuleID) + len(s.Protocol) + len(s.Direction) + len(s.Domain) + len(s.quotaSource))
+	size += addressSize(s.VirtualSource) + addressSize(s.VirtualDest) + addressSize(s.RealSource) + addressSize(s.RealDest)
+	if s.restored.Load() {
+		size += mapEntryOverhead + int64(len(s.Tuple.Key()))
+	}
+	return size
+}
+
+// accountSession charges the session the memory of its fields, again after they changed
+func (h *Handler) accountSession(s *NATSession) {
+	size := sessionSize(s)
+	h.chargeSession(s, size-s.fieldMemory.Swap(size))
+}
+
+// chargeSession adds delta bytes to the memory of a session and the handler, unless the session
+// was dropped and released its memory already
+func (h *Handler) chargeSession(s *NATSession, delta int64) {
+	for {
+		charged := s.memory.Load()
+		if charged < 0 {
+			return
+		}
+		if s.memory.CompareAndSwap(charged, charged+delta) {
+			h.memoryUsed.Add(delta)
+			return
+		}
+	}
+}
+
+// releaseSessionMemory returns the memory of a dropped session, once
+func (h *Handler) releaseSessionMemory(s *NATSession) {
+	if charged := s.memory.Swap(-1); charged > 0 {
+		h.memoryUsed.Add(-charged)
+	}
+}
+
+// memoryLimit is maxMemoryMB in bytes
+func (h *Handler) memoryLimit() int64 {
+	return h.maxMemoryMB * 1024 * 1024
+}
+
+// enforceMemoryLimits evicts least recently used sessions until a new one of size bytes fits
+// within maxMemoryMB
+func (h *Handler) enforceMemoryLimits(size int64) {
+	for h.memoryUsed.Load()+size > h.memoryLimit() {
+		session, ok := h.sessions.EvictOldest()
+		if !ok {
+			return
+		}
+		h.evictSession(session, EvictMemoryLimit)
+	}
+}
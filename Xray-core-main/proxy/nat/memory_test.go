@@ -0,0 +1,122 @@
+package nat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestSessionMemory(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	var sessions []*NATSession
+	var want int64
+	for i := 0; i < 3; i++ {
+		dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(80+i))
+		session := handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+		session.RuleID = "web"
+		session.Domain = "www.example.com"
+		handler.announceSession(session)
+		sessions = append(sessions, session)
+		want += sessionSize(session)
+	}
+	if got := handler.Stats().MemoryBytes; got != want || want <= 3*sessionStructSize {
+		t.Errorf("Expected the sessions to hold %d bytes, got %d", want, got)
+	}
+
+	// ALG state held back by a reader counts until the session is dropped
+	reader := &lineReader{reader: &bufferReader{data: []byte("USER anonymous")}, rewrite: func(line []byte) []byte { return line }}
+	reader.held.account = func(delta int64) { handler.chargeSession(sessions[0], delta) }
+	if _, err := reader.ReadMultiBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	if got := handler.Stats().MemoryBytes; got <= want {
+		t.Errorf("Expected the incomplete line to be charged, got %d bytes", got)
+	}
+
+	for _, session := range sessions {
+		handler.removeSession(session.SessionID)
+	}
+	// Charges after the drop are ignored
+	handler.accountSession(sessions[0])
+	if got := handler.Stats().MemoryBytes; got != 0 {
+		t.Errorf("Expected the memory of removed sessions to be released, got %d bytes", got)
+	}
+}
+
+// bufferReader returns its data on every read
+type bufferReader struct {
+	data []byte
+}
+
+func (r *bufferReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	return buf.MergeBytes(nil, r.data), nil
+}
+
+func TestMemoryLimit(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 100000, MaxMemoryMb: 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[string]int)
+	handler.OnEvict(func(session *NATSession, reason string) {
+		reasons[reason]++
+	})
+
+	source := xnet.UDPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	for i := 0; i < 10000; i++ {
+		dest := xnet.UDPDestination(xnet.IPAddress([]byte{240, 2, byte(i >> 8), byte(i)}), 53)
+		handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+	}
+	stats := handler.Stats()
+	if stats.MemoryBytes > 1024*1024 || stats.MemoryBytes < 1024*1024-2*sessionStructSize {
+		t.Errorf("Expected the sessions to fill up to 1MB, got %d bytes", stats.MemoryBytes)
+	}
+	if stats.Evictions == 0 || reasons[EvictMemoryLimit] != int(stats.Evictions) || stats.ActiveSessions+stats.Evictions != 10000 {
+		t.Errorf("Expected the sessions over the memory limit to be evicted, got %+v and %v", stats, reasons)
+	}
+}
+
+func TestCleanupThreshold(t *testing.T) {
+	thresholds := []float32{0.5, 1}
+	remaining := []int64{4, 7}
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	var handlers []*Handler
+	for _, threshold := range thresholds {
+		handler := New()
+		defer handler.Close()
+		if err := handler.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 10, CleanupThreshold: threshold}}, nil); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 6; i++ {
+			dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(80+i))
+			session := handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+			if i%2 == 0 {
+				// Expired, but not collected by the periodic cleanup yet
+				expired := time.Now().Add(-time.Hour)
+				session.counters.lastActivity.Store(expired.UnixNano())
+				handler.sessions.Schedule(session, expired)
+			}
+		}
+		handlers = append(handlers, handler)
+	}
+	time.Sleep(wheelTick + 100*time.Millisecond)
+
+	// Above the threshold, creating a session collects the expired ones first
+	for i, handler := range handlers {
+		handler.pressureCleanup.Store(0)
+		dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 443)
+		handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+		if active := handler.Stats().ActiveSessions; active != remaining[i] {
+			t.Errorf("Expected %d sessions left with threshold %v, got %d", remaining[i], thresholds[i], active)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package nat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// metricsPath is where the metrics service serves NAT metrics in the Prometheus text format
+const metricsPath = "/metrics/nat"
+
+// ruleMetricsKey identifies the counters of one rule and protocol
+type ruleMetricsKey struct {
+	ruleID   string
+	protocol string
+}
+
+// ruleMetrics holds the counters of one rule and protocol, updated atomically
+type ruleMetrics struct {
+	hits          atomic.Int64
+	bytes         atomic.Int64
+	sessions      atomic.Int64 // Active sessions created by the rule
+	lastHit       atomic.Int64 // Unix nanoseconds of the last flow translated, 0 before the first
+	shapingDrops  atomic.Int64 // Datagrams dropped over the rate limit of the rule
+	capRefused    atomic.Int64 // Flows refused over the maxSessions of the rule
+	oversizeDrops atomic.Int64 // Datagrams dropped over the mtu of the rule
+}
+
+// hit accounts a session created by the rule
+func (m *ruleMetrics) hit(now time.Time) {
+	m.hits.Add(1)
+	m.sessions.Add(1)
+	m.lastHit.Store(now.UnixNano())
+}
+
+// RuleStats are the counters of a rule, summed over its protocols
+type RuleStats struct {
+	RuleID         string
+	Tags           []string // Tags of the rule or virtual range
+	Hits           int64    // Flows translated
+	ActiveSessions int64
+	Bytes          int64
+	LastHit        time.Time // Zero when the rule never matched
+	ShapingDrops   int64     // Datagrams dropped over the rate limit of the rule
+	CapRefused     int64     // Flows refused over the maxSessions of the rule
+	OversizeDrops  int64     // Datagrams dropped over the mtu of the rule
+}
+
+// RuleStats returns the counters of the active rules in match order, rules that never matched
+// included, followed by those of other rules that translated flows, such as virtual ranges,
+// static mappings and rules no longer active
+func (h *Handler) RuleStats() []RuleStats {
+	byID := make(map[string]*RuleStats)
+	var result []*RuleStats
+	add := func(id string) *RuleStats {
+		entry, found := byID[id]
+		if !found {
+			entry = &RuleStats{RuleID: id}
+			byID[id] = entry
+			result = append(result, entry)
+		}
+		return entry
+	}
+	for _, rule := range h.activeRules() {
+		add(rule.RuleId)
+	}
+	for _, rule := range h.sortedRuleMetrics() {
+		entry := add(rule.key.ruleID)
+		entry.Hits += rule.metrics.hits.Load()
+		entry.ActiveSessions += rule.metrics.sessions.Load()
+		entry.Bytes += rule.metrics.bytes.Load()
+		entry.ShapingDrops += rule.metrics.shapingDrops.Load()
+		entry.CapRefused += rule.metrics.capRefused.Load()
+		entry.OversizeDrops += rule.metrics.oversizeDrops.Load()
+		if nanos := rule.metrics.lastHit.Load(); nanos != 0 {
+			if last := time.Unix(0, nanos); last.After(entry.LastHit) {
+				entry.LastHit = last
+			}
+		}
+	}
+
+	tags := h.RuleTags()
+	stats := make([]RuleStats, len(result))
+	for i, entry := range result {
+		entry.Tags = tags[entry.RuleID]
+		stats[i] = *entry
+	}
+	return stats
+}
+
+// metricsHandlers are the live handlers reported on metricsPath
+var metricsHandlers struct {
+	sync.Mutex
+	handlers map[*Handler]struct{}
+}
+
+func init() {
+	// Served by the metrics service, which serves http.DefaultServeMux
+	http.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheusMetrics(w)
+	})
+}
+
+func registerMetrics(h *Handler) {
+	metricsHandlers.Lock()
+	defer metricsHandlers.Unlock()
+	if metricsHandlers.handlers == nil {
+		metricsHandlers.handlers = make(map[*Handler]struct{})
+	}
+	metricsHandlers.handlers[h] = struct{}{}
+}
+
+func unregisterMetrics(h *Handler) {
+	metricsHandlers.Lock()
+	defer metricsHandlers.Unlock()
+	delete(metricsHandlers.handlers, h)
+}
+
+// ruleMetricsOf returns the counters of a rule for flows of the given network
+func (h *Handler) ruleMetricsOf(ruleID string, network xnet.Network) *ruleMetrics {
+	key := ruleMetricsKey{ruleID: ruleID, protocol: strings.ToLower(network.String())}
+	if metrics, found := h.ruleMetrics.Load(key); found {
+		return metrics.(*ruleMetrics)
+	}
+	metrics, _ := h.ruleMetrics.LoadOrStore(key, new(ruleMetrics))
+	return metrics.(*ruleMetrics)
+}
+
+// recordCleanup accounts one run of the expired session cleanup
+func (h *Handler) recordCleanup(duration time.Duration) {
+	atomic.AddInt64(&h.cleanupRuns, 1)
+	atomic.AddInt64(&h.cleanupNanos, int64(duration))
+}
+
+// WritePrometheusMetrics writes the metrics of all NAT handlers in the Prometheus text exposition format
+func WritePrometheusMetrics(w io.Writer) error {
+	metricsHandlers.Lock()
+	handlers := make([]*Handler, 0, len(metricsHandlers.handlers))
+	for h := range metricsHandlers.handlers {
+		handlers = append(handlers, h)
+	}
+	metricsHandlers.Unlock()
+	sort.Slice(handlers, func(i, j int) bool { return handlers[i].siteID() < handlers[j].siteID() })
+
+	out := bufio.NewWriter(w)
+	family := func(name, kind, help string, samples func(write func(labels string, value float64))) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		samples(func(labels string, value float64) {
+			fmt.Fprintf(out, "%s{%s} %v\n", name, labels, value)
+		})
+	}
+	perHandler := func(value func(h *Handler) float64) func(write func(string, float64)) {
+		return func(write func(string, float64)) {
+			for _, h := range handlers {
+				write(fmt.Sprintf("siteId=%q", h.siteID()), value(h))
+			}
+		}
+	}
+	perRule := func(value func(m *ruleMetrics) int64) func(write func(string, float64)) {
+		return func(write func(string, float64)) {
+			for _, h := range handlers {
+				for _, rule := range h.sortedRuleMetrics() {
+					labels := fmt.Sprintf("siteId=%q,ruleId=%q,protocol=%q", h.siteID(), rule.key.ruleID, rule.key.protocol)
+					write(labels, float64(value(rule.metrics)))
+				}
+			}
+		}
+	}
+
+	family("xray_nat_active_sessions", "gauge", "Number of active NAT sessions.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.activeSessions)) }))
+	family("xray_nat_memory_bytes", "gauge", "Memory held by the NAT sessions and the ALG state of their flows.",
+		perHandler(func(h *Handler) float64 { return float64(h.memoryUsed.Load()) }))
+	family("xray_nat_sessions_total", "counter", "Number of NAT sessions created.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.totalSessions)) }))
+	family("xray_nat_rule_hits_total", "counter", "Number of flows translated by a rule.",
+		perRule(func(m *ruleMetrics) int64 { return m.hits.Load() }))
+	family("xray_nat_rule_bytes_total", "counter", "Number of bytes translated by a rule.",
+		perRule(func(m *ruleMetrics) int64 { return m.bytes.Load() }))
+	family("xray_nat_rule_active_sessions", "gauge", "Number of active NAT sessions created by a rule.",
+		perRule(func(m *ruleMetrics) int64 { return m.sessions.Load() }))
+	family("xray_nat_rule_last_hit_timestamp_seconds", "gauge", "Unix time of the last flow translated by a rule.",
+		perRule(func(m *ruleMetrics) int64 { return time.Unix(0, m.lastHit.Load()).Unix() }))
+	family("xray_nat_rule_shaping_drops_total", "counter", "Number of datagrams dropped over the rate limit of a rule.",
+		perRule(func(m *ruleMetrics) int64 { return m.shapingDrops.Load() }))
+	family("xray_nat_rule_cap_refused_total", "counter", "Number of flows refused over the maxSessions of a rule.",
+		perRule(func(m *ruleMetrics) int64 { return m.capRefused.Load() }))
+	family("xray_nat_rule_oversize_drops_total", "counter", "Number of datagrams dropped over the mtu of a rule.",
+		perRule(func(m *ruleMetrics) int64 { return m.oversizeDrops.Load() }))
+	family("xray_nat_bytes_total", "counter", "Number of bytes translated.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.totalBytes)) }))
+	family("xray_nat_evictions_total", "counter", "Number of sessions evicted by session or memory limits.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.evictions)) }))
+	family("xray_nat_class_evictions_total", "counter", "Number of sessions evicted by session or memory limits, by the session class of their rule.",
+		func(write func(string, float64)) {
+			for _, h := range handlers {
+				for class := range sessionClassCount {
+					write(fmt.Sprintf("siteId=%q,class=%q", h.siteID(), class), float64(atomic.LoadInt64(&h.classEvictions[class])))
+				}
+			}
+		})
+	family("xray_nat_port_exhaustion_total", "counter", "Number of flows refused because a CGNAT or masquerade source port pool ran out.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.portExhaustions)) }))
+	family("xray_nat_session_exhaustion_total", "counter", "Number of new flows that found the session table full.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.sessionExhaustions)) }))
+	family("xray_nat_rate_limited_total", "counter", "Number of new flows refused over the new session rate.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.rateLimited)) }))
+	family("xray_nat_shaping_drops_total", "counter", "Number of datagrams dropped over the rate limit of their rule.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.shapingDrops)) }))
+	family("xray_nat_oversize_drops_total", "counter", "Number of datagrams dropped over the mtu of their rule.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.oversizeDrops)) }))
+	family("xray_nat_session_events_dropped_total", "counter", "Number of session events the webhook or an API watcher could not take or deliver.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.eventDrops)) }))
+	family("xray_nat_draining", "gauge", "Whether the gateway drains, 1 while new flows are refused or left untranslated.",
+		perHandler(func(h *Handler) float64 {
+			if h.drain.Load() != nil {
+				return 1
+			}
+			return 0
+		}))
+	family("xray_nat_drained_total", "counter", "Number of new flows refused or left untranslated while draining.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.drained)) }))
+	family("xray_nat_dial_failures_total", "counter", "Number of failed connections to translated destinations.",
+		perHandler(func(h *Handler) float64 { return float64(atomic.LoadInt64(&h.dialFailures)) }))
+	family("xray_nat_cleanup_duration_seconds", "summary", "Duration of expired session cleanup runs.",
+		func(write func(string, float64)) {
+			for _, h := range handlers {
+				labels := fmt.Sprintf("siteId=%q", h.siteID())
+				fmt.Fprintf(out, "xray_nat_cleanup_duration_seconds_sum{%s} %v\n", labels, time.Duration(atomic.LoadInt64(&h.cleanupNanos)).Seconds())
+				fmt.Fprintf(out, "xray_nat_cleanup_duration_seconds_count{%s} %d\n", labels, atomic.LoadInt64(&h.cleanupRuns))
+			}
+		})
+
+	return out.Flush()
+}
+
+type ruleMetricsEntry struct {
+	key     ruleMetricsKey
+	metrics *ruleMetrics
+}
+
+func (h *Handler) sortedRuleMetrics() []ruleMetricsEntry {
+	var entries []ruleMetricsEntry
+	h.ruleMetrics.Range(func(key, value interface{}) bool {
+		entries = append(entries, ruleMetricsEntry{key: key.(ruleMetricsKey), metrics: value.(*ruleMetrics)})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key.ruleID != entries[j].key.ruleID {
+			return entries[i].key.ruleID < entries[j].key.ruleID
+		}
+		return entries[i].key.protocol < entries[j].key.protocol
+	})
+	return entries
+}
+
+func (h *Handler) siteID() string {
+	if h.config == nil {
+		return ""
+	}
+	return h.config.SiteId
+}
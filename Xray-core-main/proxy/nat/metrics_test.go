@@ -0,0 +1,106 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "metrics-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53)
+	natSession := handler.createNATSession(xnet.Destination{}, dest, dest, "outbound", classInteractive)
+	natSession.rule = handler.ruleMetricsOf("dns", dest.Network)
+	natSession.rule.hits.Add(1)
+	natSession.record(true, 120, 1)
+	handler.cleanupExpiredSessions()
+
+	var out bytes.Buffer
+	if err := WritePrometheusMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	metrics := out.String()
+	for _, expected := range []string{
+		"# TYPE xray_nat_active_sessions gauge\n",
+		`xray_nat_active_sessions{siteId="metrics-site"} 1`,
+		`xray_nat_sessions_total{siteId="metrics-site"} 1`,
+		`xray_nat_rule_hits_total{siteId="metrics-site",ruleId="dns",protocol="udp"} 1`,
+		`xray_nat_rule_bytes_total{siteId="metrics-site",ruleId="dns",protocol="udp"} 120`,
+		`xray_nat_dial_failures_total{siteId="metrics-site"} 0`,
+		`xray_nat_cleanup_duration_seconds_count{siteId="metrics-site"} 1`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, metrics)
+		}
+	}
+
+	// Closed handlers are no longer reported
+	handler.Close()
+	recorder := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", recorder.Code)
+	}
+	if strings.Contains(recorder.Body.String(), "metrics-site") {
+		t.Error("Closed handler is still reported")
+	}
+}
+
+func TestRuleStats(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+			{RuleId: "unused", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flow whose dial fails still counts as a hit, but leaves no session behind
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	uplinkReader, _ := pipe.New()
+	_, downlinkWriter := pipe.New()
+	rule, _ := handler.shouldApplyNAT(ctx, destination)
+	if err := handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, externalDialer{}, rule); err == nil {
+		t.Fatal("Expected the dial to fail")
+	}
+
+	// Sessions still held count as active
+	held := handler.createNATSession(xnet.Destination{}, destination, destination, "outbound", classInteractive)
+	held.rule = handler.ruleMetricsOf("web", xnet.Network_UDP)
+	held.rule.hit(time.Now())
+	held.record(true, 50, 1)
+
+	stats := handler.RuleStats()
+	if len(stats) != 2 || stats[0].RuleID != "web" || stats[1].RuleID != "unused" {
+		t.Fatalf("Expected the stats of both rules in order, got %+v", stats)
+	}
+	if web := stats[0]; web.Hits != 2 || web.ActiveSessions != 1 || web.Bytes != 50 || time.Since(web.LastHit) > time.Minute {
+		t.Errorf("Unexpected stats of the matched rule %+v", web)
+	}
+	if unused := stats[1]; unused.Hits != 0 || !unused.LastHit.IsZero() {
+		t.Errorf("Unexpected stats of the unused rule %+v", unused)
+	}
+
+	handler.removeSession(held.SessionID)
+	if active := handler.RuleStats()[0].ActiveSessions; active != 0 {
+		t.Errorf("Expected no active sessions once removed, got %d", active)
+	}
+}
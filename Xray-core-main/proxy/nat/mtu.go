@@ -0,0 +1,287 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// Handling of the UDP datagrams of a rule over its mtu
+const (
+	// mtuPolicyFragment has IPv4 datagrams sent without DF, so the path fragments them, and IPv6
+	// datagrams fragmented by the gateway at the mtu
+	mtuPolicyFragment = "fragment"
+	// mtuPolicyDrop drops them; packet based datapaths answer with an ICMP error carrying the mtu
+	mtuPolicyDrop = "drop"
+	// mtuPolicyPass forwards them as they are
+	mtuPolicyPass = "pass"
+)
+
+// Bounds of the mtu and tcpMssClamp of a rule: every IPv4 host takes 576 byte datagrams, and
+// MSS options with their 536 byte default
+const (
+	minMTU = 576
+	minMSS = 536
+)
+
+// ValidateMTU checks the tcpMssClamp, mtu and mtuPolicy of a rule
+func ValidateMTU(mssClamp, mtu uint32, policy string) error {
+	if mtu != 0 && (mtu < minMTU || mtu > 65535) {
+		return errors.New("mtu ", mtu, " is out of range ", minMTU, "-65535")
+	}
+	if mssClamp != 0 && (mssClamp < minMSS || mssClamp > 65535-40) {
+		return errors.New("tcpMssClamp ", mssClamp, " is out of range ", minMSS, "-65495")
+	}
+	switch policy {
+	case "":
+	case mtuPolicyFragment, mtuPolicyDrop, mtuPolicyPass:
+		if mtu == 0 {
+			return errors.New("mtuPolicy needs an mtu")
+		}
+	default:
+		return errors.New("unknown mtuPolicy ", policy)
+	}
+	return nil
+}
+
+// headerSize returns the size of the IP and transport headers of a packet without options
+func headerSize(v6 bool, protocol byte) int {
+	size := 20
+	if v6 {
+		size = 40
+	}
+	if protocol == protocolTCP {
+		return size + 20
+	}
+	return size + 8
+}
+
+// ruleMSS returns the MSS clamp of the IPv4 or IPv6 flows of a rule: its tcpMssClamp, lowered to
+// what fits its mtu. It is 0 when the rule sets neither.
+func ruleMSS(rule *NATRule, v6 bool) int {
+	mss := int(rule.TcpMssClamp)
+	if rule.Mtu != 0 {
+		if derived := int(rule.Mtu) - headerSize(v6, protocolTCP); mss == 0 || derived < mss {
+			mss = derived
+		}
+	}
+	return mss
+}
+
+// mtuPolicy returns the mtu policy of a rule, "" without an mtu
+func mtuPolicy(rule *NATRule) string {
+	switch {
+	case rule.Mtu == 0:
+		return ""
+	case rule.MtuPolicy == "":
+		return mtuPolicyFragment
+	default:
+		return rule.MtuPolicy
+	}
+}
+
+// mtuSockopts returns the custom socket options applying the MSS clamp and the fragment policy
+// of a rule on Linux: TCP_MAXSEG on TCP sockets, IP_MTU_DISCOVER set to IP_PMTUDISC_DONT on
+// IPv4 UDP sockets and IPV6_MTU on IPv6 ones
+func mtuSockopts(rule *NATRule) []*internet.CustomSockopt {
+	var options []*internet.CustomSockopt
+	option := func(network, level, opt string, value int) {
+		options = append(options, &internet.CustomSockopt{System: "linux", Network: network, Level: level, Opt: opt, Value: strconv.Itoa(value), Type: "int"})
+	}
+	if mss := ruleMSS(rule, false); mss != 0 {
+		option("tcp4", "6", "2", mss)
+	}
+	if mss := ruleMSS(rule, true); mss != 0 {
+		option("tcp6", "6", "2", mss)
+	}
+	if mtuPolicy(rule) == mtuPolicyFragment {
+		option("udp4", "0", "10", 0)
+		option("udp6", "41", "24", int(rule.Mtu))
+	}
+	return options
+}
+
+// dropsDatagram reports whether a UDP payload of size bytes towards an IPv4 or IPv6 real
+// destination is over the mtu of a rule with the drop policy
+func dropsDatagram(rule *NATRule, size int, v6 bool) bool {
+	return mtuPolicy(rule) == mtuPolicyDrop && size+headerSize(v6, protocolUDP) > int(rule.Mtu)
+}
+
+// oversizeDrop accounts a datagram dropped over the mtu of its rule
+func (h *Handler) oversizeDrop(rule *ruleMetrics) {
+	atomic.AddInt64(&h.oversizeDrops, 1)
+	if rule != nil {
+		rule.oversizeDrops.Add(1)
+	}
+}
+
+// packetRule returns the rule translating the flow of a TCP or UDP packet from the virtual
+// side, or towards it when the packet comes from a virtual destination
+func (h *Handler) packetRule(ctx context.Context, p ipPacket, protocol byte) (*NATRule, bool) {
+	network := xnet.Network_UDP
+	if protocol == protocolTCP {
+		network = xnet.Network_TCP
+	}
+	segment := p.data[p.header:]
+	for _, endpoint := range []xnet.Destination{
+		{Network: network, Address: xnet.IPAddress(p.dst()), Port: xnet.Port(binary.BigEndian.Uint16(segment[2:4]))},
+		{Network: network, Address: xnet.IPAddress(p.src()), Port: xnet.Port(binary.BigEndian.Uint16(segment[0:2]))},
+	} {
+		if rule, ok := h.shouldApplyNAT(ctx, endpoint); ok && !blocksFlows(rule) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// clampTCPMSS lowers the MSS option of a TCP SYN of a translated flow to the clamp of its rule,
+// for a packet based datapath, so the real network is never sent segments over its mtu; none
+// passes it packets yet, Xray's connections are clamped through their socket options. Both the
+// SYN from the client and the SYN-ACK from the virtual destination are clamped, their TCP
+// checksum recomputed. Other packets are returned as they are.
+func (h *Handler) clampTCPMSS(ctx context.Context, packet []byte) ([]byte, error) {
+	p, err := parseIPHeader(packet)
+	if err != nil {
+		return nil, err
+	}
+	protocol, _, _ := p.transport()
+	segment := p.data[p.header:]
+	if protocol != protocolTCP || len(segment) < 20 || segment[13]&0x02 == 0 {
+		return packet, nil
+	}
+	offset := int(segment[12]>>4) * 4
+	if offset < 20 || offset > len(segment) {
+		return nil, errors.New("invalid TCP data offset ", offset)
+	}
+	rule, ok := h.packetRule(ctx, p, protocol)
+	if !ok {
+		return packet, nil
+	}
+	mss := ruleMSS(rule, p.v6)
+	if mss == 0 {
+		return packet, nil
+	}
+
+	// Options are a kind byte, followed by a length byte except for the end and no-operation ones
+	options := segment[20:offset]
+	for i := 0; i < len(options); {
+		switch kind := options[i]; {
+		case kind == 0:
+			return packet, nil
+		case kind == 1:
+			i++
+			continue
+		case i+1 >= len(options) || options[i+1] < 2:
+			return packet, nil
+		case kind == 2 && options[i+1] == 4 && i+4 <= len(options):
+			if int(binary.BigEndian.Uint16(options[i+2:])) <= mss {
+				return packet, nil
+			}
+			out := ipPacket{data: append([]byte(nil), packet...), v6: p.v6, header: p.header}
+			segment := out.data[out.header:]
+			binary.BigEndian.PutUint16(segment[20+i+2:], uint16(mss))
+			out.fixTransportChecksum(protocolTCP)
+			return out.data, nil
+		}
+		i += int(options[i+1])
+	}
+	return packet, nil
+}
+
+// fixTransportChecksum recomputes the checksum of the complete TCP segment or UDP datagram of a packet
+func (p ipPacket) fixTransportChecksum(protocol byte) {
+	addresses := p.data[12:20]
+	if p.v6 {
+		addresses = p.data[8:40]
+	}
+	segment := p.data[p.header:]
+	offset := 16
+	if protocol == protocolUDP {
+		offset = 6
+	}
+	binary.BigEndian.PutUint16(segment[offset:], 0)
+	pseudo := sum(addresses, 0) + uint32(protocol) + uint32(len(segment))
+	checksum := fold(sum(segment, pseudo))
+	if checksum == 0 && protocol == protocolUDP {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[offset:], checksum)
+}
+
+// applyMTU applies the mtu policy of the rule of a translated UDP flow to a datagram from the
+// virtual side, for a packet based datapath like clampTCPMSS. It returns the packet to forward, nil when it is
+// dropped, and the ICMP error to return to the client for dropped packets: fragmentation needed
+// for IPv4, packet too big for IPv6, both carrying the mtu. The fragment policy clears DF of
+// IPv4 packets; IPv6 packets are not fragmented on the path, so they are dropped as with the
+// drop policy. Packets of other flows are returned as they are.
+func (h *Handler) applyMTU(ctx context.Context, packet []byte) (forward, reply []byte, err error) {
+	p, err := parseIPHeader(packet)
+	if err != nil {
+		return nil, nil, err
+	}
+	protocol, _, _ := p.transport()
+	if protocol != protocolUDP {
+		return packet, nil, nil
+	}
+	rule, ok := h.packetRule(ctx, p, protocol)
+	if !ok || len(packet) <= int(rule.Mtu) {
+		return packet, nil, nil
+	}
+	switch mtuPolicy(rule) {
+	case "", mtuPolicyPass:
+		return packet, nil, nil
+	case mtuPolicyFragment:
+		if !p.v6 {
+			out := ipPacket{data: append([]byte(nil), packet...), header: p.header}
+			out.data[6] &^= 0x40
+			out.fixHeaderChecksum()
+			return out.data, nil, nil
+		}
+	}
+	h.oversizeDrop(h.ruleMetricsOf(rule.RuleId, xnet.Network_UDP))
+	return nil, tooBigError(p, int(rule.Mtu)), nil
+}
+
+// tooBigError builds the ICMP error telling the source of p that it exceeds mtu, sent from the
+// destination of p and embedding as much of p as the minimum MTU of its family allows
+func tooBigError(p ipPacket, mtu int) []byte {
+	header, limit := 20, minMTU
+	if p.v6 {
+		header, limit = 40, 1280
+		mtu = max(mtu, 1280)
+	}
+	embedded := p.data[:min(len(p.data), limit-header-8)]
+	if !p.v6 {
+		// IPv4 errors carry the header and the first 8 bytes of the payload
+		embedded = p.data[:min(len(p.data), p.header+8)]
+	}
+
+	data := make([]byte, header+8, header+8+len(embedded))
+	if p.v6 {
+		data[0], data[6], data[7] = 0x60, protocolICMPv6, 64
+		copy(data[8:24], p.dst())
+		copy(data[24:40], p.src())
+		data[40] = icmpv6PacketTooBig
+		binary.BigEndian.PutUint32(data[44:48], uint32(mtu))
+	} else {
+		data[0], data[8], data[9] = 0x45, 64, protocolICMPv4
+		copy(data[12:16], p.dst())
+		copy(data[16:20], p.src())
+		data[20], data[21] = icmpv4Unreachable, 4 // Fragmentation needed and DF set
+		binary.BigEndian.PutUint16(data[26:28], uint16(mtu))
+	}
+	data = append(data, embedded...)
+	if p.v6 {
+		binary.BigEndian.PutUint16(data[4:6], uint16(len(data)-header))
+	} else {
+		binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	}
+	reply := ipPacket{data: data, v6: p.v6, header: header}
+	reply.fixChecksums()
+	return reply.data
+}
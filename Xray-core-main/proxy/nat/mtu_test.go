@@ -0,0 +1,217 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// synPacket builds a TCP SYN carrying an MSS option, with a valid checksum
+func synPacket(src, dst string, mss uint16) []byte {
+	packet := transportPacket(src, dst, protocolTCP, 40000, 443)
+	p, _ := parseIPHeader(packet)
+	// A no-operation option before the MSS, followed by the end of the options
+	options := []byte{1, 2, 4, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(options[3:5], mss)
+	segment := append(append([]byte(nil), p.data[p.header:p.header+20]...), options...)
+	segment[12] = byte(len(segment)/4) << 4
+	segment[13] = 0x02
+	packet = append(packet[:p.header], segment...)
+	p = ipPacket{data: packet, v6: p.v6, header: p.header}
+	p.setLengths()
+	p.fixHeaderChecksum()
+	p.fixTransportChecksum(protocolTCP)
+	return packet
+}
+
+func checkTransportChecksum(t *testing.T, p ipPacket) {
+	t.Helper()
+	addresses := p.data[12:20]
+	if p.v6 {
+		addresses = p.data[8:40]
+	}
+	segment := p.data[p.header:]
+	if fold(sum(segment, sum(addresses, 0)+protocolTCP+uint32(len(segment)))) != 0 {
+		t.Error("Invalid TCP checksum")
+	}
+}
+
+func TestClampTCPMSS(t *testing.T) {
+	handler := newICMPHandler(t, &Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "tunnel", VirtualDestination: "240.2.2.20", RealDestination: "192.168.2.20", Mtu: 1400},
+			{RuleId: "clamp", VirtualDestination: "240.2.2.30", RealDestination: "192.168.2.30", TcpMssClamp: 1200, Mtu: 1400},
+		},
+		VirtualRanges: []*VirtualIPRange{{NpTv6VirtualPrefix: "fd01:203:405:1::/64", NpTv6RealPrefix: "2001:db8:1:1::/64"}},
+	})
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		src, dst string
+		mss      uint16
+	}{
+		{"10.0.0.1", "240.2.2.20", 1360},
+		{"240.2.2.20", "10.0.0.1", 1360}, // SYN-ACK from the virtual destination
+		{"10.0.0.1", "240.2.2.30", 1200},
+		{"10.0.0.1", "240.2.2.99", 1460},
+	} {
+		clamped, err := handler.clampTCPMSS(ctx, synPacket(test.src, test.dst, 1460))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _ := parseIPHeader(clamped)
+		if mss := binary.BigEndian.Uint16(p.data[p.header+23:]); mss != test.mss {
+			t.Errorf("Expected MSS %d from %s to %s, got %d", test.mss, test.src, test.dst, mss)
+		}
+		checkTransportChecksum(t, p)
+	}
+
+	// Smaller MSS options are kept; virtual ranges have no clamp
+	small := synPacket("10.0.0.1", "240.2.2.20", 1200)
+	if clamped, _ := handler.clampTCPMSS(ctx, small); !bytes.Equal(clamped, small) {
+		t.Error("Expected a smaller MSS to be kept")
+	}
+	v6 := synPacket("fd00::1", "fd01:203:405:1::20", 1440)
+	if clamped, _ := handler.clampTCPMSS(ctx, v6); !bytes.Equal(clamped, v6) {
+		t.Error("Expected SYNs of rules without a clamp to be kept")
+	}
+}
+
+func TestRuleSockoptMTU(t *testing.T) {
+	if ruleSockopt(&NATRule{RuleId: "plain"}) != nil {
+		t.Error("Expected no socket options without a clamp or mtu")
+	}
+	sockopt := ruleSockopt(&NATRule{RuleId: "tunnel", Mtu: 1400})
+	options := make(map[string]string)
+	for _, option := range sockopt.CustomSockopt {
+		options[option.Network+"/"+option.Level+"/"+option.Opt] = option.Value
+	}
+	for key, value := range map[string]string{"tcp4/6/2": "1360", "tcp6/6/2": "1340", "udp4/0/10": "0", "udp6/41/24": "1400"} {
+		if options[key] != value {
+			t.Errorf("Expected socket option %s set to %s, got %q", key, value, options[key])
+		}
+	}
+	if sockopt := ruleSockopt(&NATRule{RuleId: "drop", Mtu: 1400, MtuPolicy: mtuPolicyDrop}); len(sockopt.CustomSockopt) != 2 {
+		t.Errorf("Expected the drop policy to leave UDP sockets alone, got %v", sockopt.CustomSockopt)
+	}
+}
+
+func TestApplyMTU(t *testing.T) {
+	handler := newICMPHandler(t, &Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "drop", VirtualDestination: "240.2.2.20", RealDestination: "192.168.2.20", Mtu: 600, MtuPolicy: mtuPolicyDrop},
+			{RuleId: "fragment", VirtualDestination: "240.2.2.30", RealDestination: "192.168.2.30", Mtu: 600},
+		},
+	})
+	ctx := context.Background()
+	large := func(src, dst string) []byte {
+		packet := transportPacket(src, dst, protocolUDP, 40000, 53)
+		p, _ := parseIPHeader(packet)
+		packet = append(packet, make([]byte, 1400)...)
+		p = ipPacket{data: packet, v6: p.v6, header: p.header}
+		if !p.v6 {
+			p.data[6] |= 0x40 // DF
+		}
+		p.setLengths()
+		p.fixHeaderChecksum()
+		return packet
+	}
+
+	forward, reply, err := handler.applyMTU(ctx, large("10.0.0.1", "240.2.2.20"))
+	if err != nil || forward != nil {
+		t.Fatal("Expected the datagram over the mtu to be dropped: ", err)
+	}
+	p := checkPacket(t, reply, "240.2.2.20", "10.0.0.1", 0)
+	if p.icmp()[0] != icmpv4Unreachable || p.icmp()[1] != 4 || binary.BigEndian.Uint16(p.icmp()[6:8]) != 600 {
+		t.Errorf("Expected fragmentation needed with an mtu of 600, got type %d code %d", p.icmp()[0], p.icmp()[1])
+	}
+
+	forward, reply, _ = handler.applyMTU(ctx, large("10.0.0.1", "240.2.2.30"))
+	if forward == nil || reply != nil || forward[6]&0x40 != 0 {
+		t.Error("Expected the fragment policy to forward the datagram without DF")
+	} else if fold(sum(forward[:20], 0)) != 0 {
+		t.Error("Invalid IPv4 header checksum")
+	}
+
+	// IPv6 datagrams are not fragmented on the path, the client learns the mtu instead
+	v6, _ := parseIPHeader(large("fd00::1", "fd01::30"))
+	reply = tooBigError(v6, 1280)
+	p = checkPacket(t, reply, "fd01::30", "fd00::1", 0)
+	if p.icmp()[0] != icmpv6PacketTooBig || binary.BigEndian.Uint32(p.icmp()[4:8]) != 1280 || len(reply) > 1280 {
+		t.Errorf("Unexpected packet too big error of %d bytes", len(reply))
+	}
+
+	small := transportPacket("10.0.0.1", "240.2.2.20", protocolUDP, 40000, 53)
+	if forward, reply, _ := handler.applyMTU(ctx, small); !bytes.Equal(forward, small) || reply != nil {
+		t.Error("Expected datagrams within the mtu to be forwarded")
+	}
+	if drops := handler.Stats().OversizeDrops; drops != 1 {
+		t.Errorf("Expected 1 oversize drop, got %d", drops)
+	}
+}
+
+func TestUDPMappingMTUDrop(t *testing.T) {
+	server := listenUDP(t)
+	rule := &NATRule{
+		RuleId:             "tunnel",
+		VirtualDestination: "240.2.2.20",
+		RealDestination:    "127.0.0.1",
+		Protocol:           "udp",
+		NatBehavior:        natBehaviorSymmetric,
+		Mtu:                600,
+		MtuPolicy:          mtuPolicyDrop,
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{rule}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	_, downlinkWriter := pipe.New()
+	virtual := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(server.LocalAddr().(*net.UDPAddr).Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, virtual, udpTestDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	// 600 bytes of IP and UDP headers and payload fit, one byte more does not
+	var mb buf.MultiBuffer
+	for _, size := range []int{573, 572} {
+		b := buf.New()
+		b.Write(bytes.Repeat([]byte("x"), size))
+		b.UDP = &virtual
+		mb = append(mb, b)
+	}
+	if err := uplinkWriter.WriteMultiBuffer(mb); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := server.ReadFromUDP(make([]byte, 2048))
+	if err != nil || n != 572 {
+		t.Fatalf("Expected the datagram within the mtu, got %d bytes: %v", n, err)
+	}
+	server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := server.ReadFromUDP(make([]byte, 2048)); err == nil {
+		t.Error("Expected the datagram over the mtu to be dropped")
+	}
+	for _, stats := range handler.RuleStats() {
+		if stats.RuleID == "tunnel" && stats.OversizeDrops != 1 {
+			t.Errorf("Expected 1 oversize drop of the rule, got %d", stats.OversizeDrops)
+		}
+	}
+}
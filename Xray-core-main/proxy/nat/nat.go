@@ -4,89 +4,264 @@ package nat
 //go:generate go run github.com/xtls/xray-core/common/proto -cproto=./config.proto -pnat -g
 
 import (
-	"container/list"
 	"context"
+	goerrors "errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/buf"
 	"github.com/xtls/xray-core/common/errors"
 	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/retry"
 	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/common/task"
 	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/dns"
+	"github.com/xtls/xray-core/features/outbound"
 	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/features/stats"
 	"github.com/xtls/xray-core/transport"
 	"github.com/xtls/xray-core/transport/internet"
 	"github.com/xtls/xray-core/transport/internet/stat"
-	"github.com/xtls/xray-core/common/retry"
-	"github.com/xtls/xray-core/common/task"
+	"google.golang.org/protobuf/proto"
 )
 
 func init() {
 	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, config interface{}) (interface{}, error) {
-		h := &Handler{}
-		if err := core.RequireFeatures(ctx, func(pm policy.Manager) error {
+		h := New()
+		if err := core.RequireFeatures(ctx, func(pm policy.Manager, sm stats.Manager, dc dns.Client, om outbound.Manager) error {
+			h.statsManager = sm
+			h.dnsClient = dc
+			h.outboundManager = om
 			return h.Init(config.(*Config), pm)
 		}); err != nil {
 			return nil, err
 		}
+		core.OptionalFeatures(ctx, func(fdns dns.FakeDNSEngine) {
+			h.fakeDNS = fdns
+		})
 		return h, nil
 	}))
 }
 
 // Handler implements bidirectional NAT functionality
 type Handler struct {
-	config        *Config
-	policyManager policy.Manager
+	config          *Config
+	policyManager   policy.Manager
+	statsManager    stats.Manager
+	dnsClient       dns.Client
+	fakeDNS         dns.FakeDNSEngine
+	outboundManager outbound.Manager
 
 	// Session management
-	sessionTable   *sync.Map // Concurrent map for session storage
-	sessionLock    sync.RWMutex
-	cleanupTicker  *time.Ticker
+	sessions      *sessionTable // Sharded session storage with per-shard LRU tracking
+	tupleIndex    sync.Map      // 5-tuple key -> session ID of the latest session
+	sessionSeq    atomic.Uint64
+	ports         sync.Map // *NATRule -> *rulePorts of rules limited to some ports
+	portMappings  sync.Map // *PortMapping -> *parsedPortMapping with its round-robin position
+	pools         sync.Map // *NATRule -> *backendPool of rules with real destination pools
+	connPools     sync.Map // Real destination string -> *connPool of rules reusing connections
+	domains       sync.Map // Lowercase domain -> *resolvedDomain of domain rules
+	conditions    sync.Map // *NATRule -> *ruleConditions of rules with geo conditions
+	patterns      sync.Map // Virtual destination -> *destinationPattern of wildcard and regexp rules
+	cleanupTicker *time.Ticker
 	done          chan struct{}
 
-	// LRU and memory management
-	lruList       *list.List // Doubly-linked list for LRU tracking
-	lruMap        map[string]*list.Element // Map for O(1) LRU access
-	lruLock       sync.RWMutex
-	maxSessions   int64
-	maxMemoryMB   int64
-
-	// Metrics and statistics
+	// Configured rules followed by those of the rules file, then those added through the API,
+	// swapped on reload
+	rules atomic.Pointer[[]*NATRule]
+	// Deadlines of the rules of the rules file or the controller carrying ttl or expiresAt
+	expiry ruleExpiry
+	// Rules added through the API, kept after those of the rules file across reloads; guarded by expiry
+	addedRules []*NATRule
+
+	// Rules synthesized from static 1:1 mappings
+	staticForward []*NATRule
+	staticReverse []*NATRule
+
+	// Session create and teardown log, nil when disabled
+	sessionLog sessionLogSink
+	// Audit log of rule changes and administrative actions, nil when disabled
+	auditLog sessionLogSink
+
+	// IPFIX exporter of NAT session events, nil when disabled
+	flowExporter *ipfixExporter
+	// Webhook receiving batches of session events, nil when disabled
+	webhook *sessionWebhook
+	// API clients watching the session events
+	watchers eventWatchers
+
+	// Carrier-grade NAT source port allocation, nil when disabled
+	portBlocks *portBlockAllocator
+	// Source port allocation of masquerade ranges, by range, nil when no range masquerades
+	masquerades map[*VirtualIPRange]*masqueradeTable
+
+	// Session checkpoints, nil when persistence is disabled
+	checkpoints checkpointStore
+	restored    sync.Map // 5-tuple key -> restored *NATSession no flow has resumed yet
+
+	// Expected data connections announced on ALG control connections, by virtual destination
+	expectations sync.Map // Destination string -> *NATSession with the "expected" direction
+
+	// ICMP echo query sessions by query key (">" prefix) and reply key ("<" prefix)
+	icmpQueries sync.Map
+
+	// UDP mappings by the WireGuard tunnels pinned to them
+	wireGuardPins sync.Map // wireGuardPin -> *udpMapping
+
+	// Real-side TCP connections and UDP sockets of sessions by realFlowKey, for ICMP errors
+	realFlows sync.Map
+
+	// Inbound mappings requested by clients, by internal endpoint
+	mappings sync.Map // mappingKey -> *inboundMapping
+
+	// Port Control Protocol server, nil when disabled
+	portControl *pcpServer
+
+	// STUN binding responder, nil when disabled
+	stun *stunServer
+
+	// Session replication to and from the HA peer, nil when disabled
+	replication *replicator
+	// Sessions shared with other gateways through a SessionStore, nil when disabled
+	sharing *sessionSharing
+	// Translations programmed into the kernel, nil when disabled
+	offload *kernelOffload
+	// Rule distribution to members or from the controller, nil when disabled
+	distribution *ruleDistributor
+	// Virtual addresses leased to real hosts on demand, nil when disabled
+	addresses *addressPool
+
+	// Customer-side 464XLAT translator, nil when disabled
+	clat *clatTranslator
+	// Algorithmic MAP-T / MAP-E mapping rules, nil when none
+	mapRules mapRules
+
+	// Partitioning of the virtual addresses among cluster nodes, nil when disabled
+	cluster *cluster
+
+	// Session quotas and new session rate limits of each virtual source address, nil when disabled
+	sourceQuotas *sourceQuotas
+
+	// Memory management
+	maxSessions      int64
+	maxMemoryMB      int64
+	cleanupThreshold float64      // Share of the limits above which the sessions are cleaned up aggressively
+	memoryUsed       atomic.Int64 // Bytes held by the sessions and the ALG state of their flows
+	pressureCleanup  atomic.Int64 // Unix nanoseconds of the last cleanup above the threshold
+
+	// Limit of the new sessions of the gateway, nil when unlimited
+	sessionRate *sessionRateLimiter
+
+	// Hooks called with evicted sessions
+	evictHooks       atomic.Pointer[[]EvictHook]
+	evictHooksAccess sync.Mutex
+
+	// Drain for maintenance, nil when new flows are translated
+	drain       atomic.Pointer[drainState]
+	drainAccess sync.Mutex // Serializes Drain, Resume and the deadline
+
+	// Rules and tags disabled through the API, nil when none is
+	disabledRules  atomic.Pointer[disabledState]
+	disabledAccess sync.Mutex // Serializes DisableRules and EnableRules
+
+	// Metrics and statistics, accessed atomically
 	activeSessions int64
 	totalSessions  int64
-	totalBytes    int64
-	totalErrors   int64
+	totalBytes     int64
+	totalErrors    int64
+	evictions      int64
+	classEvictions [sessionClassCount]int64 // Evictions by the class of the session evicted
+	eventDrops     int64
+	dialFailures   int64
+	cleanupRuns    int64
+	cleanupNanos   int64
+	ruleMetrics    sync.Map // ruleMetricsKey -> *ruleMetrics
+	ruleQuotas     sync.Map // Rule ID -> *ruleQuota, for rules with a maxSessions
+
+	// Flows that found a source port pool or the session table exhausted
+	portExhaustions    int64
+	sessionExhaustions int64
+	exhaustionLogged   atomic.Int64 // Unix nanoseconds of the last log of the top talkers
+	rateLimited        int64        // New flows refused over the new session rate
+	drained            int64        // New flows refused or left untranslated while draining
+	shapingDrops       int64        // Datagrams dropped over the rate limit of their rule
+	oversizeDrops      int64        // Datagrams dropped over the mtu of their rule
 }
 
 // NATSession represents a NAT translation session
 type NATSession struct {
-	SessionID      string
-	Protocol       string
-	VirtualSource  xnet.Destination
-	VirtualDest    xnet.Destination
-	RealSource     xnet.Destination
-	RealDest       xnet.Destination
-	CreatedAt      time.Time
-	LastActivity   time.Time
-	Direction      string // "inbound" or "outbound"
+	SessionID     string
+	RuleID        string
+	Tuple         FiveTuple
+	Protocol      string
+	VirtualSource xnet.Destination
+	VirtualDest   xnet.Destination
+	RealSource    xnet.Destination
+	RealDest      xnet.Destination
+	CreatedAt     time.Time
+	Direction     string // "inbound", "outbound", "hairpin", "expected" or "mapping"
+	Domain        string // Domain sniffed from a flow addressed to an IP, if any
+
+	counters  sessionCounters
+	rule      *ruleMetrics                       // Counters of the rule that created the session, may be nil
+	class     sessionClass                       // Eviction class of the rule, fixed once the session is stored
+	tcpState  atomic.Int32                       // tcpState of TCP sessions
+	wireGuard atomic.Bool                        // Whether the UDP flow carries WireGuard
+	wheelTick uint64                             // Live expiry tick, guarded by the session table shard lock
+	lruStamp  int64                              // Activity the LRU position reflects, guarded by the session table shard lock
+	announced atomic.Bool                        // Whether the creation was logged and exported
+	restored  atomic.Bool                        // Whether the session was restored from a checkpoint or replicated by the peer
+	lifetime  atomic.Int64                       // Nanoseconds granted to a requested mapping, overriding the protocol timeout
+	relay     atomic.Pointer[io.Closer]          // Connection relaying the session, closed when the session is dropped
+	cancel    atomic.Pointer[context.CancelFunc] // Cancels the context of the flow relaying the session when it is dropped
+	endReason atomic.Pointer[string]             // Why the session is torn down, SessionEndClosed when not set
+
+	memory      atomic.Int64 // Bytes charged to memoryUsed, -1 once released
+	fieldMemory atomic.Int64 // Share of memory held by the fields, the rest is ALG state
+
+	masquerade  *masqueradeTable // Table the source port was allocated from, nil unless masqueraded
+	quotaSource string           // Virtual source address counted against the per-source limits, empty unless counted
+	ruleQuota   *ruleQuota       // Sessions of the rule counted against its maxSessions, nil unless counted
+
+	replicaStamp atomic.Int64 // replicaStamp last sent to the HA peer
+	sharedStamp  atomic.Int64 // replicaStamp last written to the shared session store
+
+	// Stats manager counters of the rule, nil unless enabled by policy
+	uplinkCounter   stats.Counter
+	downlinkCounter stats.Counter
+	sessionsCounter stats.Counter
 }
 
+// tcpState is the coarse connection state of a TCP session, following the lifecycle of the
+// relayed connection rather than the segments on the wire
+type tcpState int32
+
+const (
+	// tcpStateTransitory covers connections that are being opened
+	tcpStateTransitory tcpState = iota
+	tcpStateEstablished
+	// tcpStateHalfClosed covers connections one side finished sending on
+	tcpStateHalfClosed
+)
+
 // New creates a new NAT handler
 func New() *Handler {
 	return &Handler{
-		sessionTable:   &sync.Map{},
-		lruList:        list.New(),
-		lruMap:         make(map[string]*list.Element),
-		cleanupTicker:  time.NewTicker(30 * time.Second),
-		done:          make(chan struct{}),
-		maxSessions:   10000, // Default max sessions
-		maxMemoryMB:   100,   // Default max memory in MB
+		sessions:         newSessionTable(),
+		cleanupTicker:    time.NewTicker(30 * time.Second),
+		done:             make(chan struct{}),
+		maxSessions:      10000, // Default max sessions
+		maxMemoryMB:      100,   // Default max memory in MB
+		cleanupThreshold: 0.8,   // Default share of the limits above which the sessions are cleaned up aggressively
 	}
 }
 
@@ -96,8 +271,20 @@ func (h *Handler) Init(config *Config, pm policy.Manager) error {
 		return errors.New("NAT config cannot be nil")
 	}
 
+	ranges, changed, err := siteRanges(config.VirtualRanges, config.SiteId)
+	if err != nil {
+		return errors.New("failed to build the virtual ranges of site ", config.SiteId).Base(err)
+	}
+	if changed {
+		config = proto.Clone(config).(*Config)
+		config.VirtualRanges = ranges
+	}
+
 	h.config = config
 	h.policyManager = pm
+	if h.sessions == nil {
+		h.sessions = newSessionTable()
+	}
 
 	// Configure limits from config
 	if config.Limits != nil {
@@ -107,16 +294,205 @@ func (h *Handler) Init(config *Config, pm policy.Manager) error {
 		if config.Limits.MaxMemoryMb > 0 {
 			h.maxMemoryMB = int64(config.Limits.MaxMemoryMb)
 		}
+		if threshold := config.Limits.CleanupThreshold; threshold > 0 && threshold <= 1 {
+			h.cleanupThreshold = float64(threshold)
+		}
+		h.sessionRate = newSessionRateLimiter(config.Limits)
+	}
+
+	forward, reverse, err := buildStaticRules(config.StaticMappings)
+	if err != nil {
+		return errors.New("failed to build static NAT mappings").Base(err)
+	}
+	h.staticForward, h.staticReverse = forward, reverse
+	h.compilePatterns(config.Rules)
+	h.parsePorts(config.Rules)
+
+	if config.PortBlockAllocation != nil {
+		allocator, err := newPortBlockAllocator(config.PortBlockAllocation)
+		if err != nil {
+			return errors.New("failed to initialize CGNAT port blocks").Base(err)
+		}
+		h.portBlocks = allocator
+	}
+
+	masquerades, err := newMasqueradeTables(config.VirtualRanges)
+	if err != nil {
+		return err
+	}
+	h.masquerades = masquerades
+
+	if config.PerSourceLimits != nil {
+		h.sourceQuotas = newSourceQuotas(config.PerSourceLimits)
+	}
+
+	if config.SessionLog != nil {
+		sink, err := newSessionLogSink(config.SessionLog)
+		if err != nil {
+			return errors.New("failed to initialize NAT session log").Base(err)
+		}
+		h.sessionLog = sink
+	}
+
+	if config.AuditLog != nil {
+		sink, err := newSessionLogSink(config.AuditLog)
+		if err != nil {
+			return errors.New("failed to initialize NAT audit log").Base(err)
+		}
+		h.auditLog = sink
+	}
+
+	if config.FlowExport != nil {
+		exporter, err := newIPFIXExporter(config.FlowExport)
+		if err != nil {
+			return errors.New("failed to initialize NAT flow export").Base(err)
+		}
+		h.flowExporter = exporter
+	}
+
+	if config.SessionWebhook != nil {
+		webhook, err := newSessionWebhook(h, config.SessionWebhook)
+		if err != nil {
+			return errors.New("failed to initialize NAT session webhook").Base(err)
+		}
+		h.webhook = webhook
 	}
 
+	if config.RuleDistribution != nil {
+		distribution, err := newRuleDistributor(h, config.RuleDistribution)
+		if err != nil {
+			return errors.New("failed to initialize NAT rule distribution").Base(err)
+		}
+		h.distribution = distribution
+		distribution.start()
+	}
+
+	if config.RulesFile != "" {
+		stamp, err := stampRulesFile(config.RulesFile)
+		if err != nil {
+			return errors.New("failed to open NAT rules file").Base(err)
+		}
+		if err := h.reloadRules(); err != nil {
+			return err
+		}
+		go h.watchRulesFile(stamp)
+	}
+
+	if config.KernelOffload != nil {
+		if strategy := config.MatchStrategy; strategy != "" && strategy != matchFirstMatch {
+			return errors.New("NAT kernel offload needs the firstMatch strategy, not ", strategy)
+		}
+		// Without the kernel the flows are translated by Xray as usual
+		if offload, err := newKernelOffload(config.KernelOffload); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: kernel offload disabled")
+		} else {
+			h.offload = offload
+			h.syncOffload()
+		}
+	}
+
+	if config.SessionPersistence != nil {
+		store, err := newCheckpointStore(config.SessionPersistence, config.SiteId)
+		if err != nil {
+			return errors.New("failed to initialize NAT session persistence").Base(err)
+		}
+		h.checkpoints = store
+		// A missing or damaged checkpoint must not keep the handler from starting
+		if restored, err := h.restoreSessions(); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: failed to restore sessions from ", store)
+		} else if restored > 0 {
+			errors.LogInfo(context.Background(), "NAT: restored ", restored, " sessions from ", store)
+		}
+		go h.checkpointRoutine()
+	}
+
+	if config.Cluster != nil {
+		cluster, err := newCluster(config.Cluster)
+		if err != nil {
+			return errors.New("invalid NAT cluster").Base(err)
+		}
+		h.cluster = cluster
+	}
+
+	if config.Replication != nil {
+		replication, err := newReplicator(h, config.Replication)
+		if err != nil {
+			return errors.New("failed to initialize NAT session replication").Base(err)
+		}
+		h.replication = replication
+	}
+
+	if config.SharedSessions != nil {
+		store, err := newRedisSessionStore(config.SharedSessions)
+		if err != nil {
+			return errors.New("failed to initialize NAT session sharing").Base(err)
+		}
+		// An unreachable store must not keep the handler from starting
+		if err := h.UseSessionStore(store); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: failed to load shared sessions from ", store)
+		}
+	}
+
+	if config.PortControl != nil {
+		server, err := newPCPServer(h, config.PortControl)
+		if err != nil {
+			return errors.New("failed to start NAT port control").Base(err)
+		}
+		h.portControl = server
+	}
+
+	if config.AddressPool != nil {
+		pool, err := newAddressPool(config.AddressPool)
+		if err != nil {
+			return errors.New("failed to initialize NAT address pool").Base(err)
+		}
+		h.addresses = pool
+		// A missing or damaged lease file must not keep the handler from starting
+		if imported, err := pool.importLeases(time.Now()); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: failed to import leases")
+		} else if imported > 0 {
+			errors.LogInfo(context.Background(), "NAT: imported ", imported, " leases from ", pool.file)
+		}
+	}
+
+	if config.Clat != nil {
+		clat, err := newCLAT(config.Clat)
+		if err != nil {
+			return errors.New("failed to build the NAT CLAT").Base(err)
+		}
+		h.clat = clat
+	}
+	if h.mapRules, err = newMapRules(config.MapRules); err != nil {
+		return errors.New("failed to build the NAT MAP rules").Base(err)
+	}
+
+	if config.Stun != nil {
+		server, err := newSTUNServer(config.Stun)
+		if err != nil {
+			return errors.New("failed to start NAT STUN responder").Base(err)
+		}
+		h.stun = server
+	}
+
+	registerMetrics(h)
+
 	// Only start cleanup routine if not already running
 	if h.cleanupTicker != nil {
+		h.cleanupTicker.Reset(h.cleanupInterval())
 		go h.sessionCleanupRoutine()
 	}
 
 	return nil
 }
 
+// policy returns the session policy of the configured user level
+func (h *Handler) policy() policy.Session {
+	if h.policyManager == nil {
+		return policy.SessionDefault()
+	}
+	return h.policyManager.ForLevel(h.config.UserLevel)
+}
+
 // getNAT64Prefix returns the configured NAT64 prefix or the default
 func (h *Handler) getNAT64Prefix() string {
 	if h.config != nil && h.config.Nat64Prefix != "" {
@@ -139,16 +515,40 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 	}
 
 	destination := outbounds[len(outbounds)-1].Target
-	if !destination.Address.Family().IsIP() {
-		return errors.New("NAT only supports IP destinations")
+	if destination.Address == nil {
+		return errors.New("no outbound destination address specified")
+	}
+	ctx = policy.ContextWithBufferPolicy(ctx, h.policy().Buffer)
+
+	// Data connections an ALG expects are translated as announced on their control connection
+	if expectedRule, expected := h.claimExpectation(ctx, destination); expected {
+		return h.handleNATOutbound(ctx, link, destination, dialer, expectedRule)
 	}
 
+	destination = h.fakeDNSDomain(ctx, destination)
+
 	// Determine if this is virtual IP traffic that needs NAT transformation
 	natRule, shouldTransform := h.shouldApplyNAT(ctx, destination)
 	if !shouldTransform {
 		// Not a virtual IP, handle as normal outbound
 		return h.handleNormalOutbound(ctx, link, destination, dialer)
 	}
+	if blocksFlows(natRule) {
+		return h.blockFlow(ctx, link, destination, natRule)
+	}
+	if state := h.drain.Load(); state != nil {
+		return h.drainFlow(ctx, link, destination, dialer, state)
+	}
+
+	// MAP rules translate without sessions, so any node translates their flows
+	if h.mapRules.owns(natRule) {
+		return h.handleMappedOutbound(ctx, link, destination, dialer, natRule)
+	}
+
+	// In a cluster, the node owning the virtual destination translates it
+	if node, local := h.clusterOwner(destination); !local {
+		return h.forwardToNode(ctx, link, destination, node)
+	}
 
 	// Apply NAT transformation
 	return h.handleNATOutbound(ctx, link, destination, dialer, natRule)
@@ -156,41 +556,196 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 
 // shouldApplyNAT determines if NAT transformation should be applied to destination
 func (h *Handler) shouldApplyNAT(ctx context.Context, destination xnet.Destination) (*NATRule, bool) {
-	// First check specific rules
-	for _, rule := range h.config.Rules {
-		if h.matchesVirtualDestination(destination, rule.VirtualDestination) &&
-			h.matchesProtocol(destination, rule.Protocol) &&
-			h.matchesPort(destination, rule) &&
-			h.matchesSite(ctx, rule) {
-			return rule, true
+	return h.ruleFor(ctx, destination, h.activeRules())
+}
+
+// ruleFor is shouldApplyNAT matching rules in place of the active rules
+func (h *Handler) ruleFor(ctx context.Context, destination xnet.Destination, rules []*NATRule) (*NATRule, bool) {
+	// The STUN responder answers on its own virtual addresses
+	if rule, ok := h.stun.rule(destination); ok {
+		return rule, true
+	}
+
+	strategy := h.matchStrategy()
+
+	// First check specific rules; bypass, deny and reject rules exempt destinations from static
+	// mappings and ranges too
+	rule := h.matchingRule(ctx, destination, rules, strategy)
+	if rule != nil && rule.Action == actionBypass {
+		errors.LogDebug(ctx, "NAT bypassed for ", destination, " by rule ", rule.RuleId)
+		return nil, false
+	}
+	if rule != nil && blocksFlows(rule) {
+		return rule, true
+	}
+	if rule != nil && strategy != matchLongestPrefix {
+		return rule, true
+	}
+
+	// Static mappings and ranges only translate IP destinations; domains need domain rules
+	if !destination.Address.Family().IsIP() {
+		return rule, rule != nil
+	}
+
+	// Then check static 1:1 mappings
+	if rule == nil {
+		for _, static := range h.staticForward {
+			if h.matchesVirtualDestination(destination, static.VirtualDestination) {
+				if strategy != matchLongestPrefix {
+					return static, true
+				}
+				rule = static
+				break
+			}
+		}
+	}
+
+	// Then check the virtual addresses leased from the address pool
+	if rule == nil {
+		if leased, ok := h.addresses.rule(destination); ok {
+			if strategy != matchLongestPrefix {
+				return leased, true
+			}
+			rule = leased
 		}
 	}
 
-	// Then check virtual ranges
+	// Then check virtual ranges; under longestPrefix a range only wins if it is more specific
+	var matched *VirtualIPRange
+	bits := -1
 	for _, vrange := range h.config.VirtualRanges {
-		if h.matchesVirtualRange(destination, vrange) {
-			// Create a dynamic rule for this range
-			return &NATRule{
-				RuleId:            "dynamic-range-" + vrange.VirtualNetwork,
-				VirtualDestination: destination.Address.String(),
-				RealDestination:    vrange.RealNetwork,
-				Protocol:          "tcp,udp", // Support both
-			}, true
+		if !h.matchesVirtualRange(destination, vrange) || h.rangeDisabled(vrange) {
+			continue
+		}
+		if strategy != matchLongestPrefix {
+			if rangeRule, ok := h.rangeRule(ctx, destination, vrange); ok {
+				return rangeRule, true
+			}
+			continue
+		}
+		if length := h.rangePrefixLen(destination, vrange); length > bits {
+			matched, bits = vrange, length
+		}
+	}
+	if matched != nil && (rule == nil || bits > virtualPrefixLen(rule.VirtualDestination)) {
+		if rangeRule, ok := h.rangeRule(ctx, destination, matched); ok {
+			return rangeRule, true
+		}
+	}
+
+	// IPv4 destinations left untranslated map algorithmically, or else reach the PLAT of the CLAT
+	if rule == nil {
+		if mapped, ok := h.mapRules.rule(destination); ok {
+			return mapped, true
 		}
+		return h.clat.rule(destination)
 	}
+	return rule, true
+}
+
+// matchingRule returns the explicit rule of rules translating destination under the match strategy, or nil
+func (h *Handler) matchingRule(ctx context.Context, destination xnet.Destination, rules []*NATRule, strategy string) *NATRule {
+	var best *NATRule
+	for _, rule := range rules {
+		if !h.matchesRule(ctx, destination, rule) {
+			continue
+		}
+		switch strategy {
+		case matchHighestPriority:
+			if best == nil || rule.Priority > best.Priority {
+				best = rule
+			}
+		case matchLongestPrefix:
+			if best == nil || virtualPrefixLen(rule.VirtualDestination) > virtualPrefixLen(best.VirtualDestination) {
+				best = rule
+			}
+		default:
+			return rule
+		}
+	}
+	return best
+}
 
-	return nil, false
+// matchesRule reports whether a rule, enabled, matches a flow to destination
+func (h *Handler) matchesRule(ctx context.Context, destination xnet.Destination, rule *NATRule) bool {
+	return !h.RuleDisabled(rule) &&
+		h.matchesVirtualDestination(destination, rule.VirtualDestination) &&
+		h.matchesProtocol(destination, rule.Protocol) &&
+		h.matchesPort(destination, rule) &&
+		h.matchesSite(ctx, rule) &&
+		h.matchesConditions(ctx, destination, rule)
+}
+
+// rangeRule creates the dynamic rule translating destination through a matching range
+func (h *Handler) rangeRule(ctx context.Context, destination xnet.Destination, vrange *VirtualIPRange) (*NATRule, bool) {
+	realDestination := vrange.RealNetwork
+
+	// NAT46: synthesize the real IPv6 destination from the IPv4 virtual address
+	if vrange.Ipv4To6Prefix != "" && destination.Address.Family().IsIPv4() {
+		realIP, err := translateNAT46(destination.Address.IP(), vrange)
+		if err != nil {
+			errors.LogWarningInner(ctx, err, "failed to apply NAT46 translation for ", destination)
+			return nil, false
+		}
+		realDestination = realIP.String()
+	} else if h.matchesNPTv6Range(destination, vrange) {
+		// NPTv6: rewrite the prefix of the IPv6 virtual address
+		realIP, err := h.applyNPTv6(destination.Address.IP(), vrange)
+		if err != nil {
+			errors.LogWarningInner(ctx, err, "failed to apply NPTv6 translation for ", destination)
+			return nil, false
+		}
+		realDestination = realIP.String()
+	} else if vrange.Masquerade {
+		// Masquerade: every virtual address reaches the single real address
+		realDestination = vrange.RealNetwork
+	} else if destination.Address.Family().IsIP() {
+		// Plain ranges keep the host bits of the virtual address in the real network
+		realIP, ok, err := translateRange(destination.Address.IP(), vrange)
+		if err != nil {
+			errors.LogWarningInner(ctx, err, "failed to map ", destination, " into ", vrange.RealNetwork)
+			return nil, false
+		}
+		if ok {
+			realDestination = realIP.String()
+		}
+	}
+
+	// Create a dynamic rule for this range
+	return &NATRule{
+		RuleId:             rangeRuleID(vrange),
+		VirtualDestination: destination.Address.String(),
+		RealDestination:    realDestination,
+		Protocol:           "tcp,udp", // Support both
+		MaxSessions:        vrange.MaxSessions,
+		Tos:                vrange.Tos,
+		PreserveTos:        vrange.PreserveTos,
+		FlowLabel:          vrange.FlowLabel,
+		Tags:               vrange.Tags,
+	}, true
 }
 
 // matchesVirtualDestination checks if destination matches virtual network
 func (h *Handler) matchesVirtualDestination(destination xnet.Destination, virtualNetwork string) bool {
 	destStr := destination.Address.String()
 
+	if isPatternDestination(virtualNetwork) {
+		return h.patternOf(virtualNetwork).matches(destination.Address)
+	}
+
 	// Handle IPv6 addresses with embedded IPv4 (like [prefix]::192.168.1.1)
 	if strings.Contains(virtualNetwork, ":") && strings.Contains(virtualNetwork, ".") {
 		return h.matchesIPv6EmbeddedIPv4(destination, virtualNetwork)
 	}
 
+	if strings.Contains(virtualNetwork, "/") {
+		return h.matchesCIDR(destStr, virtualNetwork)
+	}
+
+	if isDomainDestination(virtualNetwork) {
+		return h.matchesDomain(destination, virtualNetwork)
+	}
+
 	// Exact match for specific IP addresses
 	return destStr == virtualNetwork
 }
@@ -199,6 +754,11 @@ func (h *Handler) matchesVirtualDestination(destination xnet.Destination, virtua
 func (h *Handler) matchesVirtualRange(destination xnet.Destination, vrange *VirtualIPRange) bool {
 	destAddr := destination.Address.String()
 
+	// Handle NPTv6 prefix translation
+	if h.matchesNPTv6Range(destination, vrange) {
+		return true
+	}
+
 	// Handle IPv6 with embedded IPv4
 	if vrange.Ipv6Enabled && vrange.Ipv6VirtualPrefix != "" {
 		if h.matchesIPv6EmbeddedIPv4Range(destination, vrange.Ipv6VirtualPrefix, vrange.RealNetwork) {
@@ -214,6 +774,27 @@ func (h *Handler) matchesVirtualRange(destination xnet.Destination, vrange *Virt
 	return destAddr == vrange.VirtualNetwork
 }
 
+// matchesNPTv6Range checks if an IPv6 destination falls within the NPTv6 virtual prefix of the range
+func (h *Handler) matchesNPTv6Range(destination xnet.Destination, vrange *VirtualIPRange) bool {
+	if vrange.NpTv6VirtualPrefix == "" || vrange.NpTv6RealPrefix == "" || !destination.Address.Family().IsIPv6() {
+		return false
+	}
+	return h.matchesCIDR(destination.Address.IP().String(), vrange.NpTv6VirtualPrefix)
+}
+
+// applyNPTv6 translates an IPv6 virtual address into the real prefix of the range
+func (h *Handler) applyNPTv6(ip net.IP, vrange *VirtualIPRange) (net.IP, error) {
+	_, virtualPrefix, err := net.ParseCIDR(vrange.NpTv6VirtualPrefix)
+	if err != nil {
+		return nil, errors.New("invalid NPTv6 virtual prefix ", vrange.NpTv6VirtualPrefix).Base(err)
+	}
+	_, realPrefix, err := net.ParseCIDR(vrange.NpTv6RealPrefix)
+	if err != nil {
+		return nil, errors.New("invalid NPTv6 real prefix ", vrange.NpTv6RealPrefix).Base(err)
+	}
+	return translateNPTv6(ip, virtualPrefix, realPrefix)
+}
+
 // matchesIPv6EmbeddedIPv4 matches IPv6 addresses with embedded IPv4
 func (h *Handler) matchesIPv6EmbeddedIPv4(destination xnet.Destination, virtualNetwork string) bool {
 	destStr := destination.Address.String()
@@ -398,17 +979,19 @@ func (h *Handler) matchesCIDR(ip, cidr string) bool {
 
 // matchesProtocol checks if destination protocol matches rule protocol specification
 func (h *Handler) matchesProtocol(destination xnet.Destination, protocol string) bool {
-	if protocol == "" {
+	tokens := protocolTokens(protocol)
+	if len(tokens) == 0 {
 		// Empty protocol means match all protocols
 		return true
 	}
 
 	destProtocol := strings.ToLower(destination.Network.String())
-	ruleProtocols := strings.Split(strings.ToLower(protocol), ",")
-
-	for _, ruleProtocol := range ruleProtocols {
-		ruleProtocol = strings.TrimSpace(ruleProtocol)
-		if ruleProtocol == destProtocol || ruleProtocol == "tcp,udp" || ruleProtocol == "udp,tcp" {
+	if destination.Network == xnet.Network_Unknown {
+		// Echo queries carry no transport network
+		destProtocol = "icmp"
+	}
+	for _, token := range tokens {
+		if matchesProtocolToken(destination, destProtocol, token) {
 			return true
 		}
 	}
@@ -416,74 +999,65 @@ func (h *Handler) matchesProtocol(destination xnet.Destination, protocol string)
 	return false
 }
 
-// matchesPort checks if destination port matches rule port mapping
+// matchesPort checks if destination port is one of the rule's ports. Port mappings do not
+// restrict matching; ports outside the original list keep their value.
 func (h *Handler) matchesPort(destination xnet.Destination, rule *NATRule) bool {
-	if rule.PortMapping == nil {
-		// No port mapping specified, match all ports
+	if rule.Ports == "" {
 		return true
 	}
-
-	// For now, we match all ports when port mapping is specified
-	// Port mapping logic will be applied during transformation
-	return true
+	p := h.portsOf(rule)
+	if p.err != nil {
+		return false
+	}
+	if len(p.ports) == 0 {
+		return true
+	}
+	_, ok := p.ports.indexOf(destination.Port)
+	return ok
 }
 
-// mapPort maps the original port to the translated port based on port mapping configuration
-func (h *Handler) mapPort(originalPort xnet.Port, portMapping *PortMapping) xnet.Port {
+// mapPort maps the original port of a flow of network to the translated port based on port
+// mapping configuration
+func (h *Handler) mapPort(network xnet.Network, originalPort xnet.Port, portMapping *PortMapping) xnet.Port {
 	if portMapping == nil {
 		return originalPort
 	}
-
-	// If original port is specified, check if it matches
-	if portMapping.OriginalPort != "" && portMapping.OriginalPort != "any" {
-		// Parse the specified original port
-		specifiedPorts := strings.Split(portMapping.OriginalPort, "-")
-		if len(specifiedPorts) == 1 {
-			// Single port
-			if specifiedPort, err := xnet.PortFromString(specifiedPorts[0]); err == nil {
-				if specifiedPort.Value() != originalPort.Value() {
-					// Original port doesn't match, no mapping
-					return originalPort
-				}
-			}
-		}
-	}
-
-	// Map to translated port
-	if portMapping.TranslatedPort != "" {
-		if translatedPort, err := xnet.PortFromString(portMapping.TranslatedPort); err == nil {
-			return translatedPort
-		}
-	}
-
-	return originalPort
+	return h.portMappingOf(portMapping).translate(network, originalPort)
 }
 
 // matchesSite checks if the rule's source site matches the current site context
 func (h *Handler) matchesSite(ctx context.Context, rule *NATRule) bool {
-	if rule.SourceSite == "" {
-		// Empty source site means match all sites
-		return true
-	}
+	// Empty source site, or no site ID configured, matches all sites
+	return siteListed(rule.SourceSite, h.config.SiteId)
+}
 
-	// Get the current site ID from configuration
-	currentSite := h.config.SiteId
-	if currentSite == "" {
-		// No site ID configured, match all rules
-		return true
+// dialWithin runs dial, giving up once timeout passed; a connection it establishes later is
+// closed. The dial is not cancelled through its context, to which some transports bind the
+// lifetime of the connection.
+func dialWithin(timeout time.Duration, dial func() (stat.Connection, error)) (stat.Connection, error) {
+	if timeout <= 0 {
+		return dial()
 	}
-
-	// Check if the rule's source site matches the current site
-	// Support for multiple sites separated by comma
-	sites := strings.Split(strings.ToLower(rule.SourceSite), ",")
-	for _, site := range sites {
-		site = strings.TrimSpace(site)
-		if strings.ToLower(site) == strings.ToLower(currentSite) {
-			return true
-		}
+	type dialResult struct {
+		conn stat.Connection
+		err  error
+	}
+	result := make(chan dialResult, 1)
+	go func() {
+		conn, err := dial()
+		result <- dialResult{conn, err}
+	}()
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, errors.New("connection not established within the handshake timeout ", timeout)
 	}
-
-	return false
 }
 
 // handleNormalOutbound handles non-NAT outbound traffic
@@ -491,31 +1065,40 @@ func (h *Handler) handleNormalOutbound(ctx context.Context, link *transport.Link
 	// Implement standard outbound connection
 	// This will be similar to freedom proxy implementation
 
-	var conn stat.Connection
-	var err error
-
-	err = retry.ExponentialBackoff(5, 100).On(func() error {
-		rawConn, dialErr := dialer.Dial(ctx, destination)
-		if dialErr != nil {
-			return dialErr
-		}
-		conn = rawConn
-		return nil
+	plcy := h.policy()
+	conn, err := dialWithin(plcy.Timeouts.Handshake, func() (stat.Connection, error) {
+		var conn stat.Connection
+		err := retry.ExponentialBackoff(5, 100).On(func() error {
+			rawConn, dialErr := dialer.Dial(ctx, destination)
+			if dialErr != nil {
+				return dialErr
+			}
+			conn = rawConn
+			return nil
+		})
+		return conn, err
 	})
 
 	if err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		atomic.AddInt64(&h.dialFailures, 1)
 		return errors.New("failed to establish connection").Base(err)
 	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := signal.CancelAfterInactivity(ctx, cancel, plcy.Timeouts.ConnectionIdle)
 
 	// Handle bidirectional traffic
 	requestDone := func() error {
-		defer conn.Close()
-		return buf.Copy(buf.NewReader(conn), link.Writer)
+		defer timer.SetTimeout(plcy.Timeouts.DownlinkOnly)
+		return buf.Copy(link.Reader, buf.NewWriter(conn), buf.UpdateActivity(timer))
 	}
 
 	responseDone := func() error {
-		defer conn.Close()
-		return buf.Copy(link.Reader, buf.NewWriter(conn))
+		defer timer.SetTimeout(plcy.Timeouts.UplinkOnly)
+		return buf.Copy(buf.NewReader(conn), link.Writer, buf.UpdateActivity(timer))
 	}
 
 	return task.Run(ctx, requestDone, task.OnSuccess(responseDone, task.Close(link.Writer)))
@@ -523,46 +1106,195 @@ func (h *Handler) handleNormalOutbound(ctx context.Context, link *transport.Link
 
 // handleNATOutbound handles NAT-transformed outbound traffic
 func (h *Handler) handleNATOutbound(ctx context.Context, link *transport.Link, destination xnet.Destination, dialer internet.Dialer, rule *NATRule) error {
+	var source xnet.Destination
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		source = inbound.Source
+	}
+
+	// Balance the flow onto a backend of the rule's pool; the session sticks to it for its lifetime
+	realDestination := rule.RealDestination
+	pool, err := h.backendPool(rule)
+	if err != nil {
+		return errors.New("invalid backend pool of rule ", rule.RuleId).Base(err)
+	}
+	var chosen *backend
+	if pool != nil {
+		chosen = pool.pick(source.Address)
+		defer pool.release(source.Address, chosen)
+		realDestination = chosen.address
+	}
+
 	// Apply DNAT transformation
-	transformedDest, err := h.applyDNAT(destination, rule)
+	transformedDest, err := h.applyDNATTo(destination, rule, realDestination)
 	if err != nil {
 		return errors.New("DNAT transformation failed").Base(err)
 	}
+	if transformedDest, err = h.resolveRealDestination(ctx, transformedDest, rule); err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		return err
+	}
+	ctx = h.withSendThrough(ctx, transformedDest)
+	ctx = h.withCLATSource(ctx, source, transformedDest)
+
+	// Hairpin: both ends are behind this NAT, so loop the flow back with the source translated too
+	hairpinSource, hairpin := h.hairpinSource(source, transformedDest)
+	direction := "outbound"
+	if hairpin {
+		direction = "hairpin"
+		dialer = hairpinDialer{}
+	} else if rule.ForwardTag != "" {
+		dialer = forwardDialer{manager: h.outboundManager, tag: rule.ForwardTag}
+	} else if sockopt := flowSockopt(ctx, rule); sockopt != nil {
+		dialer = sockoptDialer{sockopt: sockopt}
+	}
 
-	// Create NAT session for tracking
-	session := h.createNATSession(destination, transformedDest, "outbound")
-
-	// Establish connection with transformed destination
-	var conn stat.Connection
-	err = retry.ExponentialBackoff(5, 100).On(func() error {
-		rawConn, dialErr := dialer.Dial(ctx, transformedDest)
-		if dialErr != nil {
-			return dialErr
+	// Create NAT session for tracking, once the gateway and the source are within their limits
+	// and the session table has room
+	if err := h.admitNewSession(); err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		return err
+	}
+	quotaSource, err := h.admitSource(source)
+	if err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		return err
+	}
+	ruleQuota, err := h.admitRule(rule, transformedDest.Network)
+	if err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		h.releaseSource(quotaSource)
+		return err
+	}
+	if err := h.admitSession(ctx, source); err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		h.releaseSource(quotaSource)
+		ruleQuota.release()
+		return err
+	}
+	session := h.createNATSession(source, destination, transformedDest, direction, classOf(rule))
+	sctp := transformedDest.Network == xnet.Network_UDP && carriesSCTP(rule)
+	if sctp {
+		session.Protocol = protocolSCTP
+		h.sessions.Schedule(session, session.LastActivity().Add(h.sessionTimeout(session)))
+	}
+	ctx, release := bindSession(ctx, session)
+	defer release()
+	session.quotaSource = quotaSource
+	session.ruleQuota = ruleQuota
+	session.RuleID = rule.RuleId
+	session.Domain = sniffedDomain(ctx)
+	session.rule = h.ruleMetricsOf(rule.RuleId, transformedDest.Network)
+	session.rule.hit(session.CreatedAt)
+	h.attachStatsCounters(session)
+	if hairpin {
+		session.RealSource = hairpinSource
+	} else if h.adoptRestoredSession(ctx, session) {
+		// The flow resumed after a restart and keeps its source port
+	} else if err := h.allocateSourcePort(ctx, session); err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		h.removeSession(session.SessionID)
+		err = errors.New("failed to allocate CGNAT source port").Base(err)
+		if goerrors.Is(err, ErrExhausted) {
+			h.recordExhaustion(ctx, &h.portExhaustions, err)
+		}
+		return err
+	} else if err := h.allocateMasqueradePort(ctx, session); err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		h.removeSession(session.SessionID)
+		err = errors.New("failed to allocate masquerade source port").Base(err)
+		if goerrors.Is(err, ErrExhausted) {
+			h.recordExhaustion(ctx, &h.portExhaustions, err)
 		}
-		conn = rawConn
-		return nil
+		return err
+	}
+	// Sessions given a source port by CGNAT or masquerading leave from it
+	if !hairpin && rule.ForwardTag == "" && session.RealSource.Port != 0 {
+		dialer = sockoptDialer{sockopt: boundSockopt(flowSockopt(ctx, rule), session.RealSource)}
+	}
+	h.announceSession(session)
+
+	// UDP is relayed per datagram, each to the destination it is addressed to, with return
+	// traffic filtered by the NAT behavior. Gateways rewrite the payloads of a connection, so
+	// UDP rules with gateways and no NAT behavior keep relaying the flow as one, as do SCTP
+	// associations.
+	shapers := newFlowShapers(rule.RateLimit)
+	if transformedDest.Network == xnet.Network_UDP && !sctp && (rule.NatBehavior != "" || len(rule.Alg) == 0) {
+		defer h.removeSession(session.SessionID)
+		return h.handleUDPMapping(ctx, link, destination, transformedDest, dialer, rule, session, chosen, shapers)
+	}
+
+	// Establish connection with transformed destination, within the handshake timeout
+	plcy := h.policy()
+	conn, err := dialWithin(plcy.Timeouts.Handshake, func() (stat.Connection, error) {
+		var conn stat.Connection
+		err := retry.ExponentialBackoff(5, 100).On(func() error {
+			rawConn, dialErr := h.dialPooled(ctx, dialer, rule, transformedDest)
+			if dialErr != nil {
+				return dialErr
+			}
+			conn = rawConn
+			return nil
+		})
+		return conn, err
 	})
 
 	if err != nil {
+		atomic.AddInt64(&h.totalErrors, 1)
+		atomic.AddInt64(&h.dialFailures, 1)
 		h.removeSession(session.SessionID)
+		if chosen != nil {
+			chosen.markUnhealthy()
+		}
 		return errors.New("failed to establish NAT connection").Base(err)
 	}
+	if transformedDest.Network == xnet.Network_TCP {
+		h.setTCPState(session, tcpStateEstablished)
+	}
+	defer h.trackRealFlow(transformedDest.Network, conn.LocalAddr(), conn.RemoteAddr(), session, nil)()
+
+	// Flows idle for the connection idle timeout of the policy are cut, as are flows with one
+	// direction finished that stay idle for the uplink or downlink only timeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := signal.CancelAfterInactivity(ctx, cancel, plcy.Timeouts.ConnectionIdle)
 
 	// Handle bidirectional traffic with NAT transformation
+	conn, uplink, downlink := h.wrapALG(ctx, rule, session, conn, link.Reader)
+	if transformedDest.Network == xnet.Network_TCP {
+		flow := h.newTCPFlow(session, conn)
+		splice := h.canSplice(rule, conn)
+		err := task.Run(ctx, func() error {
+			defer timer.SetTimeout(plcy.Timeouts.DownlinkOnly)
+			err := buf.Copy(shapers.uplink.pace(ctx, newCountingReader(uplink, h, session, true)), buf.NewWriter(conn), buf.UpdateActivity(timer))
+			return flow.finish(err, func() bool { return closeWrite(conn) })
+		}, func() error {
+			defer timer.SetTimeout(plcy.Timeouts.UplinkOnly)
+			var err error
+			if splice {
+				err = h.copyDownlinkSplice(ctx, session, conn, link.Writer, timer)
+			} else {
+				err = buf.Copy(shapers.downlink.pace(ctx, newCountingReader(downlink, h, session, false)), link.Writer, buf.UpdateActivity(timer))
+			}
+			return flow.finish(err, func() bool { return common.Close(link.Writer) == nil })
+		})
+		if err != nil {
+			flow.reset()
+		}
+		return err
+	}
+
+	defer func() {
+		h.removeSession(session.SessionID)
+		conn.Close()
+	}()
 	requestDone := func() error {
-		defer func() {
-			h.removeSession(session.SessionID)
-			conn.Close()
-		}()
-		return buf.Copy(buf.NewReader(conn), link.Writer)
+		defer timer.SetTimeout(plcy.Timeouts.UplinkOnly)
+		return buf.Copy(shapers.downlink.police(h, session, newCountingReader(downlink, h, session, false)), link.Writer, buf.UpdateActivity(timer))
 	}
 
 	responseDone := func() error {
-		defer func() {
-			h.removeSession(session.SessionID)
-			conn.Close()
-		}()
-		return buf.Copy(link.Reader, buf.NewWriter(conn))
+		defer timer.SetTimeout(plcy.Timeouts.DownlinkOnly)
+		return buf.Copy(shapers.uplink.police(h, session, newCountingReader(uplink, h, session, true)), buf.NewWriter(conn), buf.UpdateActivity(timer))
 	}
 
 	return task.Run(ctx, requestDone, task.OnSuccess(responseDone, task.Close(link.Writer)))
@@ -570,11 +1302,20 @@ func (h *Handler) handleNATOutbound(ctx context.Context, link *transport.Link, d
 
 // applyDNAT applies Destination Network Address Translation
 func (h *Handler) applyDNAT(destination xnet.Destination, rule *NATRule) (xnet.Destination, error) {
+	return h.applyDNATTo(destination, rule, rule.RealDestination)
+}
+
+// applyDNATTo applies Destination Network Address Translation towards the given real destination,
+// which is the rule's real destination or a backend picked from its pool
+func (h *Handler) applyDNATTo(destination xnet.Destination, rule *NATRule, realDestination string) (xnet.Destination, error) {
 	var realAddr xnet.Address
 	destStr := destination.Address.String()
 
-	// Handle IPv6 embedded IPv4 addresses
-	if strings.Contains(destStr, ":") && (strings.Contains(destStr, ".") || strings.Contains(destStr, "]")) {
+	// Prefer a literal real IPv6 destination (e.g. synthesized by NPTv6)
+	// over extracting an embedded IPv4 address
+	if destination.Address.Family().IsIPv6() && net.ParseIP(realDestination) != nil {
+		realAddr = xnet.ParseAddress(realDestination)
+	} else if strings.Contains(destStr, ":") && (strings.Contains(destStr, ".") || strings.Contains(destStr, "]")) {
 		// Extract IPv4 from IPv6 embedded address
 		extractedIPv4 := h.extractIPv4FromIPv6(destStr)
 		if extractedIPv4 != "" {
@@ -582,12 +1323,12 @@ func (h *Handler) applyDNAT(destination xnet.Destination, rule *NATRule) (xnet.D
 			realAddr = xnet.ParseAddress(extractedIPv4)
 		} else {
 			// Fallback to rule's real destination
-			realAddr = xnet.ParseAddress(rule.RealDestination)
+			realAddr = xnet.ParseAddress(realDestination)
 		}
 	} else {
 		// Regular IPv4 address or use rule's real destination
-		if rule.RealDestination != "" {
-			realAddr = xnet.ParseAddress(rule.RealDestination)
+		if realDestination != "" {
+			realAddr = xnet.ParseAddress(realDestination)
 		} else {
 			realAddr = destination.Address
 		}
@@ -603,99 +1344,122 @@ func (h *Handler) applyDNAT(destination xnet.Destination, rule *NATRule) (xnet.D
 		Port:    destination.Port,
 	}
 
-	// Apply port mapping if specified
+	// Apply port mapping or offset if specified
 	if rule.PortMapping != nil {
-		transformed.Port = h.mapPort(destination.Port, rule.PortMapping)
+		transformed.Port = h.mapPort(destination.Network, destination.Port, rule.PortMapping)
+	} else if rule.PortOffset != 0 {
+		transformed.Port = shiftPort(destination.Port, rule.PortOffset)
 	}
 
 	return transformed, nil
 }
 
 // createNATSession creates a new NAT session for tracking
-func (h *Handler) createNATSession(virtualDest, realDest xnet.Destination, direction string) *NATSession {
-	sessionID := generateSessionID(virtualDest, realDest)
+// The source is the virtual source endpoint of the flow and may be zero when unknown; class is
+// the eviction class of its rule.
+func (h *Handler) createNATSession(source, virtualDest, realDest xnet.Destination, direction string, class sessionClass) *NATSession {
+	tuple := NewFiveTuple(source, virtualDest)
+	sessionID := generateSessionID(tuple, h.sessionSeq.Add(1))
 
 	session := &NATSession{
 		SessionID:     sessionID,
+		Tuple:         tuple,
 		Protocol:      virtualDest.Network.String(),
+		VirtualSource: source,
 		VirtualDest:   virtualDest,
 		RealDest:      realDest,
 		CreatedAt:     time.Now(),
-		LastActivity:  time.Now(),
 		Direction:     direction,
+		class:         class,
 	}
+	session.touch()
 
-	// Check memory limits and evict if necessary
-	h.enforceMemoryLimits()
-
-	// Check session limits and evict LRU if necessary
+	// Above the cleanup threshold clean up aggressively first, then evict least recently used
+	// sessions until the new one fits within the memory and session limits
+	h.relievePressure()
+	h.enforceMemoryLimits(sessionSize(session))
 	h.enforceSessionLimits()
 
-	h.sessionTable.Store(sessionID, session)
+	h.sessions.Store(session)
+	h.sessions.Schedule(session, session.LastActivity().Add(h.sessionTimeout(session)))
+	h.tupleIndex.Store(tuple.Key(), sessionID)
+	h.accountSession(session)
 
-	// Add to LRU tracking
-	h.lruLock.Lock()
-	if elem, exists := h.lruMap[sessionID]; exists {
-		h.lruList.MoveToFront(elem)
-	} else {
-		elem := h.lruList.PushFront(sessionID)
-		h.lruMap[sessionID] = elem
-	}
-	h.lruLock.Unlock()
-
-	h.totalSessions++
-	h.activeSessions++
+	atomic.AddInt64(&h.totalSessions, 1)
+	atomic.AddInt64(&h.activeSessions, 1)
 
 	return session
 }
 
 // removeSession removes a NAT session from tracking table
 func (h *Handler) removeSession(sessionID string) {
-	if _, loaded := h.sessionTable.LoadAndDelete(sessionID); loaded {
-		h.activeSessions--
+	if session, loaded := h.sessions.LoadAndDelete(sessionID); loaded {
+		h.dropSession(session)
+	}
+}
 
-		// Remove from LRU tracking
-		h.lruLock.Lock()
-		if elem, exists := h.lruMap[sessionID]; exists {
-			h.lruList.Remove(elem)
-			delete(h.lruMap, sessionID)
-		}
-		h.lruLock.Unlock()
+// dropSession releases the resources of a session that was taken out of the session table
+func (h *Handler) dropSession(session *NATSession) {
+	atomic.AddInt64(&h.activeSessions, -1)
+	if session.rule != nil {
+		session.rule.sessions.Add(-1)
+	}
+	if session.sessionsCounter != nil {
+		session.sessionsCounter.Add(-1)
+	}
+	h.unindexSession(session)
+	h.forgetRestored(session)
+	h.forgetICMPQuery(session)
+	h.forgetExpectation(session)
+	h.forgetMapping(session)
+	h.releaseSourcePort(session)
+	h.releaseMasqueradePort(session)
+	h.releaseSource(session.quotaSource)
+	session.ruleQuota.release()
+	h.retireSession(session)
+	h.releaseSessionMemory(session)
+	if relay := session.relay.Load(); relay != nil {
+		(*relay).Close()
+	}
+	if cancel := session.cancel.Load(); cancel != nil {
+		(*cancel)()
 	}
 }
 
-// enforceSessionLimits enforces session count limits by evicting least recently used sessions
-func (h *Handler) enforceSessionLimits() {
-	h.lruLock.Lock()
-	defer h.lruLock.Unlock()
+// announceSession logs and exports the creation of a fully set up session
+func (h *Handler) announceSession(session *NATSession) {
+	session.announced.Store(true)
+	h.accountSession(session)
+	h.logSessionCreate(session)
+	h.exportSession(session, true)
+	h.replicateSession(ReplicationEvent_CREATE, session)
+	h.shareSession(session)
+}
 
-	// Evict LRU sessions until we're under the limit
-	for h.activeSessions >= h.maxSessions && h.lruList.Len() > 0 {
-		// Get the least recently used session (back of the list)
-		if elem := h.lruList.Back(); elem != nil {
-			sessionID := elem.Value.(string)
-			h.lruList.Remove(elem)
-			delete(h.lruMap, sessionID)
-			h.sessionTable.Delete(sessionID)
-			h.activeSessions--
-		}
+// retireSession logs and exports the removal of an announced session, once
+func (h *Handler) retireSession(session *NATSession) {
+	if !session.announced.CompareAndSwap(true, false) {
+		return
 	}
+	h.logSessionTeardown(session)
+	h.exportSession(session, false)
+	h.replicateSession(ReplicationEvent_DELETE, session)
+	h.unshareSession(session)
 }
 
-// enforceMemoryLimits enforces memory limits by estimating session memory usage
-func (h *Handler) enforceMemoryLimits() {
-	// Estimate memory usage per session (rough estimate in bytes)
-	const sessionMemoryEstimate = 2048 // 2KB per session
-	maxSessionsFromMemory := (h.maxMemoryMB * 1024 * 1024) / sessionMemoryEstimate
-
-	// If session count would exceed memory limits, enforce it
-	if maxSessionsFromMemory < h.maxSessions {
-		h.maxSessions = maxSessionsFromMemory
+// unindexSession drops the tuple index entry of a session unless a newer session took it over
+func (h *Handler) unindexSession(session *NATSession) {
+	h.tupleIndex.CompareAndDelete(session.Tuple.Key(), session.SessionID)
+}
 
-		// Log the adjustment (in production, this would use the logging system)
-		if h.activeSessions >= h.maxSessions {
-			h.enforceSessionLimits()
+// enforceSessionLimits enforces session count limits by evicting least recently used sessions
+func (h *Handler) enforceSessionLimits() {
+	for atomic.LoadInt64(&h.activeSessions) >= h.maxSessions {
+		session, ok := h.sessions.EvictOldest()
+		if !ok {
+			return
 		}
+		h.evictSession(session, EvictSessionLimit)
 	}
 }
 
@@ -705,51 +1469,140 @@ func (h *Handler) sessionCleanupRoutine() {
 		select {
 		case <-h.cleanupTicker.C:
 			h.cleanupExpiredSessions()
+			h.expireIdleConns()
+			h.expireLeases()
+			h.pruneSources()
 		case <-h.done:
 			return
 		}
 	}
 }
 
-// cleanupExpiredSessions removes sessions that have exceeded their timeout
-func (h *Handler) cleanupExpiredSessions() {
-	now := time.Now()
-	var timeout time.Duration
+// cleanupInterval returns how often expired sessions are collected
+func (h *Handler) cleanupInterval() time.Duration {
+	if h.config != nil && h.config.SessionTimeout != nil && h.config.SessionTimeout.CleanupInterval > 0 {
+		return time.Duration(h.config.SessionTimeout.CleanupInterval) * time.Second
+	}
+	return 30 * time.Second // Default 30 seconds
+}
 
-	// Use default timeout if config is not available
-	if h.config != nil && h.config.SessionTimeout != nil {
-		timeout = time.Duration(h.config.SessionTimeout.TcpTimeout) * time.Second
-	} else {
-		timeout = 300 * time.Second // Default 5 minutes
+// sessionTimeout returns the idle timeout of a session based on its protocol and state
+func (h *Handler) sessionTimeout(session *NATSession) time.Duration {
+	var timeouts *SessionTimeout
+	if h.config != nil {
+		timeouts = h.config.SessionTimeout
 	}
 
-	var expiredSessions []string
-	h.sessionTable.Range(func(key, value interface{}) bool {
-		if session, ok := value.(*NATSession); ok {
-			if now.Sub(session.LastActivity) > timeout {
-				expiredSessions = append(expiredSessions, key.(string))
-			}
+	seconds := func(value, fallback uint32) time.Duration {
+		if value == 0 {
+			value = fallback
 		}
-		return true
-	})
+		return time.Duration(value) * time.Second
+	}
+
+	if lifetime := session.lifetime.Load(); lifetime > 0 {
+		return time.Duration(lifetime)
+	}
+	switch strings.ToLower(session.Protocol) {
+	case "udp":
+		timeout := h.complianceTimeout(session, seconds(timeouts.GetUdpTimeout(), 60)) // Default 1 minute
+		if session.wireGuard.Load() {
+			return max(timeout, wireGuardTimeout)
+		}
+		return timeout
+	case "icmp":
+		return h.complianceTimeout(session, seconds(timeouts.GetIcmpTimeout(), 60)) // Default 1 minute
+	default:
+		tcpTimeout := timeouts.GetTcpTimeout()
+		if tcpTimeout == 0 {
+			tcpTimeout = 300 // Default 5 minutes
+		}
+		if strings.EqualFold(session.Protocol, protocolSCTP) {
+			return seconds(timeouts.GetSctpTimeout(), tcpTimeout)
+		}
+		if tcpState(session.tcpState.Load()) == tcpStateEstablished {
+			return h.complianceTimeout(session, seconds(timeouts.GetEstablishedTcpTimeout(), tcpTimeout))
+		}
+		return h.complianceTimeout(session, seconds(timeouts.GetTransitoryTcpTimeout(), tcpTimeout))
+	}
+}
 
-	// Clean up expired sessions from both tables
-	for _, sessionID := range expiredSessions {
-		h.removeSession(sessionID)
+// setTCPState updates the state of a TCP session and reschedules its expiry for the new timeout
+func (h *Handler) setTCPState(session *NATSession, state tcpState) {
+	if tcpState(session.tcpState.Swap(int32(state))) != state {
+		h.sessions.Schedule(session, session.LastActivity().Add(h.sessionTimeout(session)))
 	}
 }
 
+// cleanupExpiredSessions removes sessions that have exceeded their timeout.
+// Only sessions whose scheduled deadline came due are examined; sessions that saw
+// traffic in the meantime are rescheduled to their new deadline.
+func (h *Handler) cleanupExpiredSessions() {
+	now := time.Now()
+	defer func() { h.recordCleanup(time.Since(now)) }()
 
-// generateSessionID generates a unique session identifier
-func generateSessionID(virtualDest, realDest xnet.Destination) string {
-	return virtualDest.Address.String() + ":" + virtualDest.Port.String() + "->" +
-		realDest.Address.String() + ":" + realDest.Port.String() + "_" +
-		time.Now().Format("20060102150405")
+	for _, session := range h.sessions.Due(now) {
+		deadline := session.LastActivity().Add(h.sessionTimeout(session))
+		if now.After(deadline) {
+			endSession(session, SessionEndExpired)
+			h.removeSession(session.SessionID)
+		} else {
+			h.sessions.Schedule(session, deadline)
+		}
+	}
 }
 
 // Close implements common.Closable
 func (h *Handler) Close() error {
+	unregisterMetrics(h)
 	close(h.done)
 	h.cleanupTicker.Stop()
+	h.expiry.stop()
+	if state := h.drain.Load(); state != nil {
+		state.stop()
+	}
+	if h.checkpoints != nil {
+		if err := h.checkpointSessions(); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: failed to checkpoint sessions to ", h.checkpoints)
+		}
+	}
+	if h.sessionLog != nil {
+		h.sessionLog.Close()
+	}
+	if h.auditLog != nil {
+		h.auditLog.Close()
+	}
+	if h.flowExporter != nil {
+		h.flowExporter.Close()
+	}
+	if h.webhook != nil {
+		h.webhook.Close()
+	}
+	h.watchers.close()
+	if h.replication != nil {
+		h.replication.Close()
+	}
+	if h.sharing != nil {
+		h.sharing.Close()
+	}
+	if h.offload != nil {
+		if err := h.offload.Close(); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: failed to remove kernel offload table ", h.offload.table)
+		}
+	}
+	if h.distribution != nil {
+		h.distribution.Close()
+	}
+	if h.portControl != nil {
+		h.portControl.Close()
+	}
+	if h.stun != nil {
+		h.stun.Close()
+	}
+	h.closeConnPools()
+	h.mappings.Range(func(_, value any) bool {
+		h.removeSession(value.(*inboundMapping).session.SessionID)
+		return true
+	})
 	return nil
-}
\ No newline at end of file
+}
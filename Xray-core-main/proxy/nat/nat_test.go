@@ -3,7 +3,6 @@ package nat
 import (
 	"context"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 
@@ -25,10 +24,10 @@ func TestHandler_Init(t *testing.T) {
 		},
 		Rules: []*NATRule{
 			{
-				RuleId:            "rule-1",
+				RuleId:             "rule-1",
 				VirtualDestination: "240.2.2.20",
 				RealDestination:    "192.168.1.20",
-				Protocol:          "tcp",
+				Protocol:           "tcp",
 			},
 		},
 		SessionTimeout: &SessionTimeout{
@@ -37,8 +36,8 @@ func TestHandler_Init(t *testing.T) {
 			CleanupInterval: 30,
 		},
 		Limits: &ResourceLimits{
-			MaxSessions:     10000,
-			MaxMemoryMb:     100,
+			MaxSessions:      10000,
+			MaxMemoryMb:      100,
 			CleanupThreshold: 0.8,
 		},
 	}
@@ -79,7 +78,7 @@ func TestNATSession_Lifecycle(t *testing.T) {
 	}
 
 	// Create NAT session
-	session := handler.createNATSession(virtualDest, realDest, "outbound")
+	session := handler.createNATSession(xnet.Destination{}, virtualDest, realDest, "outbound", classInteractive)
 	if session == nil {
 		t.Fatal("Failed to create NAT session")
 	}
@@ -105,7 +104,7 @@ func TestNATSession_Lifecycle(t *testing.T) {
 	handler.removeSession(session.SessionID)
 
 	// Verify session is removed
-	if _, exists := handler.sessionTable.Load(session.SessionID); exists {
+	if _, exists := handler.sessions.Load(session.SessionID); exists {
 		t.Error("Session should be removed after calling removeSession")
 	}
 
@@ -117,10 +116,10 @@ func TestShouldApplyNAT(t *testing.T) {
 	config := &Config{
 		Rules: []*NATRule{
 			{
-				RuleId:            "rule-1",
+				RuleId:             "rule-1",
 				VirtualDestination: "240.2.2.20",
 				RealDestination:    "192.168.1.20",
-				Protocol:          "tcp",
+				Protocol:           "tcp",
 			},
 		},
 	}
@@ -166,10 +165,10 @@ func TestApplyDNAT(t *testing.T) {
 	handler := &Handler{}
 
 	rule := &NATRule{
-		RuleId:            "rule-1",
+		RuleId:             "rule-1",
 		VirtualDestination: "240.2.2.20",
 		RealDestination:    "192.168.1.20",
-		Protocol:          "tcp",
+		Protocol:           "tcp",
 	}
 
 	virtualDest := xnet.Destination{
@@ -220,7 +219,7 @@ func TestSessionCleanup(t *testing.T) {
 		Port:    80,
 	}
 
-	session := handler.createNATSession(virtualDest, realDest, "outbound")
+	session := handler.createNATSession(xnet.Destination{}, virtualDest, realDest, "outbound", classInteractive)
 
 	// Wait for session to expire
 	time.Sleep(2 * time.Second)
@@ -229,7 +228,7 @@ func TestSessionCleanup(t *testing.T) {
 	handler.cleanupExpiredSessions()
 
 	// Verify session was cleaned up
-	if _, exists := handler.sessionTable.Load(session.SessionID); exists {
+	if _, exists := handler.sessions.Load(session.SessionID); exists {
 		t.Error("Expired session should be removed during cleanup")
 	}
 
@@ -245,10 +244,10 @@ func TestIPv6EmbeddedIPv4NAT(t *testing.T) {
 		EnableUdp: true,
 		VirtualRanges: []*VirtualIPRange{
 			{
-				VirtualNetwork:      "64:FF9B:1111::192.168.1.1/120",
-				RealNetwork:         "192.168.1.0/24",
-				Ipv6Enabled:         true,
-				Ipv6VirtualPrefix:   "64:FF9B:1111::192.168.1.1/120",
+				VirtualNetwork:    "64:FF9B:1111::192.168.1.1/120",
+				RealNetwork:       "192.168.1.0/24",
+				Ipv6Enabled:       true,
+				Ipv6VirtualPrefix: "64:FF9B:1111::192.168.1.1/120",
 			},
 		},
 		SessionTimeout: &SessionTimeout{
@@ -257,8 +256,8 @@ func TestIPv6EmbeddedIPv4NAT(t *testing.T) {
 			CleanupInterval: 30,
 		},
 		Limits: &ResourceLimits{
-			MaxSessions:     10000,
-			MaxMemoryMb:     100,
+			MaxSessions:      10000,
+			MaxMemoryMb:      100,
 			CleanupThreshold: 0.8,
 		},
 	}
@@ -343,10 +342,10 @@ func TestIPv6EmbeddedIPv4NAT_NewPrefix(t *testing.T) {
 		EnableUdp: true,
 		VirtualRanges: []*VirtualIPRange{
 			{
-				VirtualNetwork:      "64:FF9B:9876::192.168.1.1/120",
-				RealNetwork:         "192.168.1.0/24",
-				Ipv6Enabled:         true,
-				Ipv6VirtualPrefix:   "64:FF9B:9876::192.168.1.1/120",
+				VirtualNetwork:    "64:FF9B:9876::192.168.1.1/120",
+				RealNetwork:       "192.168.1.0/24",
+				Ipv6Enabled:       true,
+				Ipv6VirtualPrefix: "64:FF9B:9876::192.168.1.1/120",
 			},
 		},
 		SessionTimeout: &SessionTimeout{
@@ -355,8 +354,8 @@ func TestIPv6EmbeddedIPv4NAT_NewPrefix(t *testing.T) {
 			CleanupInterval: 30,
 		},
 		Limits: &ResourceLimits{
-			MaxSessions:     10000,
-			MaxMemoryMb:     100,
+			MaxSessions:      10000,
+			MaxMemoryMb:      100,
 			CleanupThreshold: 0.8,
 		},
 	}
@@ -497,7 +496,7 @@ func TestIPv6NATSessionCreation(t *testing.T) {
 	}
 
 	// Create NAT session
-	session := handler.createNATSession(ipv6Dest, ipv4Dest, "outbound")
+	session := handler.createNATSession(xnet.Destination{}, ipv6Dest, ipv4Dest, "outbound", classInteractive)
 	if session == nil {
 		t.Fatal("Failed to create NAT session for IPv6->IPv4")
 	}
@@ -526,46 +525,46 @@ func TestSiteBasedRuleSelection(t *testing.T) {
 		SiteId: "site-b",
 		Rules: []*NATRule{
 			{
-				RuleId:            "rule-site-a",
+				RuleId:             "rule-site-a",
 				VirtualDestination: "240.1.1.20",
 				RealDestination:    "192.168.1.20",
-				Protocol:          "tcp",
-				SourceSite:        "site-a",
+				Protocol:           "tcp",
+				SourceSite:         "site-a",
 			},
 			{
-				RuleId:            "rule-site-b",
+				RuleId:             "rule-site-b",
 				VirtualDestination: "240.2.2.20",
 				RealDestination:    "192.168.2.20",
-				Protocol:          "tcp",
-				SourceSite:        "site-b",
+				Protocol:           "tcp",
+				SourceSite:         "site-b",
 			},
 			{
-				RuleId:            "rule-both-sites",
+				RuleId:             "rule-both-sites",
 				VirtualDestination: "240.3.3.20",
 				RealDestination:    "192.168.3.20",
-				Protocol:          "tcp",
-				SourceSite:        "site-a,site-b",
+				Protocol:           "tcp",
+				SourceSite:         "site-a,site-b",
 			},
 			{
-				RuleId:            "rule-any-site",
+				RuleId:             "rule-any-site",
 				VirtualDestination: "240.4.4.20",
 				RealDestination:    "192.168.4.20",
-				Protocol:          "tcp",
-				SourceSite:        "",
+				Protocol:           "tcp",
+				SourceSite:         "",
 			},
 		},
 	}
 
 	handler := &Handler{
-		config:       config,
-		sessionTable: &sync.Map{},
+		config:   config,
+		sessions: newSessionTable(),
 	}
 
 	testCases := []struct {
-		name          string
-		virtualDest   string
-		expectRule    string
-		expectMatch   bool
+		name        string
+		virtualDest string
+		expectRule  string
+		expectMatch bool
 	}{
 		{
 			name:        "Site A rule - should not match for Site B handler",
@@ -619,18 +618,18 @@ func TestSiteBasedRuleSelection_NoSiteConfigured(t *testing.T) {
 		SiteId: "", // No site configured
 		Rules: []*NATRule{
 			{
-				RuleId:            "rule-site-a",
+				RuleId:             "rule-site-a",
 				VirtualDestination: "240.1.1.20",
 				RealDestination:    "192.168.1.20",
-				Protocol:          "tcp",
-				SourceSite:        "site-a",
+				Protocol:           "tcp",
+				SourceSite:         "site-a",
 			},
 		},
 	}
 
 	handler := &Handler{
-		config:       config,
-		sessionTable: &sync.Map{},
+		config:   config,
+		sessions: newSessionTable(),
 	}
 
 	dest := xnet.Destination{
@@ -659,10 +658,10 @@ func TestCustomNAT64Prefix(t *testing.T) {
 		Nat64Prefix: customNAT64Prefix,
 		VirtualRanges: []*VirtualIPRange{
 			{
-				VirtualNetwork:      customNAT64Prefix + "192.168.1.1/120",
-				RealNetwork:         "192.168.1.0/24",
-				Ipv6Enabled:         true,
-				Ipv6VirtualPrefix:   customNAT64Prefix + "192.168.1.1/120",
+				VirtualNetwork:    customNAT64Prefix + "192.168.1.1/120",
+				RealNetwork:       "192.168.1.0/24",
+				Ipv6Enabled:       true,
+				Ipv6VirtualPrefix: customNAT64Prefix + "192.168.1.1/120",
 			},
 		},
 	}
@@ -727,4 +726,48 @@ func TestDefaultNAT64PrefixFallback(t *testing.T) {
 	}
 
 	handler.Close()
-}
\ No newline at end of file
+}
+
+func TestSessionTimeoutPerProtocol(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	handler.config = &Config{
+		SessionTimeout: &SessionTimeout{
+			TcpTimeout:            300,
+			UdpTimeout:            60,
+			IcmpTimeout:           30,
+			EstablishedTcpTimeout: 7440,
+			TransitoryTcpTimeout:  240,
+		},
+	}
+
+	tcpDest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	udpDest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53)
+
+	tcpSession := handler.createNATSession(xnet.Destination{}, tcpDest, tcpDest, "outbound", classInteractive)
+	if timeout := handler.sessionTimeout(tcpSession); timeout != 240*time.Second {
+		t.Errorf("Expected transitory TCP timeout 240s, got %v", timeout)
+	}
+
+	handler.setTCPState(tcpSession, tcpStateEstablished)
+	if timeout := handler.sessionTimeout(tcpSession); timeout != 7440*time.Second {
+		t.Errorf("Expected established TCP timeout 7440s, got %v", timeout)
+	}
+
+	udpSession := handler.createNATSession(xnet.Destination{}, udpDest, udpDest, "outbound", classInteractive)
+	if timeout := handler.sessionTimeout(udpSession); timeout != 60*time.Second {
+		t.Errorf("Expected UDP timeout 60s, got %v", timeout)
+	}
+
+	icmpSession := &NATSession{Protocol: "icmp"}
+	if timeout := handler.sessionTimeout(icmpSession); timeout != 30*time.Second {
+		t.Errorf("Expected ICMP timeout 30s, got %v", timeout)
+	}
+
+	// Established and transitory timeouts fall back to tcpTimeout
+	handler.config.SessionTimeout.EstablishedTcpTimeout = 0
+	handler.config.SessionTimeout.TransitoryTcpTimeout = 0
+	if timeout := handler.sessionTimeout(tcpSession); timeout != 300*time.Second {
+		t.Errorf("Expected fallback TCP timeout 300s, got %v", timeout)
+	}
+}
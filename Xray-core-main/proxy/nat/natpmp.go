@@ -0,0 +1,124 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// NAT Port Mapping Protocol (RFC 6886), answered on the PCP port
+const (
+	natpmpVersion = 0
+
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+	natpmpOpMapTCP          = 2
+
+	natpmpMapSize = 12
+)
+
+// NAT-PMP result codes
+const (
+	natpmpSuccess        = 0
+	natpmpNotAuthorized  = 2
+	natpmpNetworkFailure = 3
+	natpmpOutOfResources = 4
+	natpmpUnsuppOpcode   = 5
+)
+
+// handleNATPMP answers a NAT-PMP request. Mappings are shared with PCP: a client may refresh
+// and delete the mappings it created with NAT-PMP, but not those created with PCP.
+func (s *pcpServer) handleNATPMP(request []byte, from *net.UDPAddr) []byte {
+	opcode := request[1]
+	switch opcode {
+	case natpmpOpExternalAddress:
+		response := s.natpmpResponse(opcode, natpmpSuccess, 4)
+		external := s.externalIP.To4()
+		if external == nil || external.IsUnspecified() {
+			// No IPv4 address to announce yet
+			response[3] = natpmpNetworkFailure
+			return response
+		}
+		copy(response[8:12], external)
+		return response
+	case natpmpOpMapUDP, natpmpOpMapTCP:
+		if len(request) < natpmpMapSize || from.IP.To4() == nil {
+			// NAT-PMP only maps IPv4 clients
+			return nil
+		}
+		return s.handleNATPMPMap(request, from)
+	default:
+		return s.natpmpResponse(opcode, natpmpUnsuppOpcode, 0)
+	}
+}
+
+// handleNATPMPMap creates, refreshes or deletes the mapping a NAT-PMP map request asks for
+func (s *pcpServer) handleNATPMPMap(request []byte, from *net.UDPAddr) []byte {
+	h := s.handler
+	opcode := request[1]
+	network := xnet.Network_UDP
+	if opcode == natpmpOpMapTCP {
+		network = xnet.Network_TCP
+	}
+	internalPort := xnet.Port(binary.BigEndian.Uint16(request[4:6]))
+	suggestedPort := xnet.Port(binary.BigEndian.Uint16(request[6:8]))
+	requested := binary.BigEndian.Uint32(request[8:12])
+
+	response := s.natpmpResponse(opcode, natpmpSuccess, 8)
+	copy(response[8:10], request[4:6])
+
+	if requested == 0 {
+		// Deletion, of all mappings of the protocol for internal port 0
+		h.mappings.Range(func(_, value any) bool {
+			m := value.(*inboundMapping)
+			if m.owner == "natpmp" && m.internal.Network == network && m.internal.Address.IP().Equal(from.IP) &&
+				(internalPort == 0 || m.internal.Port == internalPort) {
+				h.removeSession(m.session.SessionID)
+			}
+			return true
+		})
+		return response
+	}
+	if internalPort == 0 {
+		response[3] = natpmpNotAuthorized
+		return response
+	}
+	internal := xnet.Destination{Network: network, Address: xnet.IPAddress(from.IP), Port: internalPort}
+
+	lifetime := min(max(requested, pcpMinLifetime), s.maxLifetime)
+	m, found := h.lookupMapping(internal)
+	switch {
+	case found && m.owner != "natpmp":
+		response[3] = natpmpNotAuthorized
+		return response
+	case found:
+		h.setMappingLifetime(m, time.Duration(lifetime)*time.Second)
+	case h.mappingsOf(from.IP) >= s.maxMappings:
+		response[3] = natpmpOutOfResources
+		return response
+	default:
+		var err error
+		if m, err = s.open(internal, suggestedPort, time.Duration(lifetime)*time.Second, "natpmp", "natpmp"); err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: NAT-PMP failed to map ", internal)
+			response[3] = natpmpOutOfResources
+			return response
+		}
+	}
+	binary.BigEndian.PutUint16(response[10:12], uint16(m.external.Port))
+	binary.BigEndian.PutUint32(response[12:16], lifetime)
+	return response
+}
+
+// natpmpResponse builds a response carrying size bytes after the common header
+func (s *pcpServer) natpmpResponse(opcode, result uint8, size int) []byte {
+	response := make([]byte, 8+size)
+	response[0] = natpmpVersion
+	response[1] = 0x80 | opcode
+	binary.BigEndian.PutUint16(response[2:4], uint16(result))
+	binary.BigEndian.PutUint32(response[4:8], s.secondsSinceEpoch())
+	return response
+}
@@ -0,0 +1,97 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// natpmpRequest sends a NAT-PMP request and returns the response
+func natpmpRequest(t *testing.T, client *net.UDPConn, request []byte) []byte {
+	t.Helper()
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, pcpMaxPacketSize)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := client.Read(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 8 || response[0] != natpmpVersion || response[1] != 0x80|request[1] {
+		t.Fatalf("Unexpected response % x", response[:n])
+	}
+	return response[:n]
+}
+
+// natpmpMap sends a map request and returns the result code, mapped port and lifetime
+func natpmpMap(t *testing.T, client *net.UDPConn, opcode uint8, internalPort uint16, lifetime uint32) (uint16, uint16, uint32) {
+	t.Helper()
+	request := make([]byte, natpmpMapSize)
+	request[1] = opcode
+	binary.BigEndian.PutUint16(request[4:6], internalPort)
+	binary.BigEndian.PutUint32(request[8:12], lifetime)
+	response := natpmpRequest(t, client, request)
+	if len(response) != 16 || binary.BigEndian.Uint16(response[8:10]) != internalPort {
+		t.Fatalf("Unexpected response % x", response)
+	}
+	return binary.BigEndian.Uint16(response[2:4]), binary.BigEndian.Uint16(response[10:12]), binary.BigEndian.Uint32(response[12:16])
+}
+
+func TestNATPMP(t *testing.T) {
+	handler, client := newPCPTestHandler(t)
+
+	response := natpmpRequest(t, client, []byte{natpmpVersion, natpmpOpExternalAddress})
+	if len(response) != 12 || binary.BigEndian.Uint16(response[2:4]) != natpmpSuccess || !net.IP(response[8:12]).Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("Unexpected external address response % x", response)
+	}
+	if response := natpmpRequest(t, client, []byte{natpmpVersion, 9}); binary.BigEndian.Uint16(response[2:4]) != natpmpUnsuppOpcode {
+		t.Errorf("Expected an unsupported opcode, got % x", response)
+	}
+
+	internal := listenTCP(t)
+	internalPort := uint16(internal.Addr().(*net.TCPAddr).Port)
+	result, port, lifetime := natpmpMap(t, client, natpmpOpMapTCP, internalPort, 3600)
+	if result != natpmpSuccess || port == 0 || lifetime != 600 {
+		t.Fatalf("Expected a mapping limited to the max lifetime, got result %d port %d lifetime %d", result, port, lifetime)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", xnet.Port(port).String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	mappings := handler.Mappings()
+	if len(mappings) != 1 || mappings[0].Protocol != "natpmp" || mappings[0].External.Port.Value() != port {
+		t.Fatalf("Unexpected mappings %v", mappings)
+	}
+	if result, refreshed, _ := natpmpMap(t, client, natpmpOpMapTCP, internalPort, 300); result != natpmpSuccess || refreshed != port {
+		t.Errorf("Expected the refresh to keep the external port, got %d %d", result, refreshed)
+	}
+
+	// Mappings created with PCP are not NAT-PMP's to change
+	udpPort := uint16(listenUDP(t).LocalAddr().(*net.UDPAddr).Port)
+	if result, _, _ := pcpMap(t, client, 1, 17, udpPort, 300); result != pcpSuccess {
+		t.Fatalf("Unexpected PCP result %d", result)
+	}
+	if result, _, _ := natpmpMap(t, client, natpmpOpMapUDP, udpPort, 0); result != natpmpSuccess {
+		t.Errorf("Unexpected deletion result %d", result)
+	}
+	if len(handler.Mappings()) != 2 {
+		t.Error("Expected the PCP mapping to survive a NAT-PMP deletion")
+	}
+	if result, _, _ := natpmpMap(t, client, natpmpOpMapUDP, udpPort, 300); result != natpmpNotAuthorized {
+		t.Errorf("Expected the PCP mapping to be refused, got %d", result)
+	}
+
+	// Internal port 0 deletes all mappings of the protocol
+	if result, _, _ := natpmpMap(t, client, natpmpOpMapTCP, 0, 0); result != natpmpSuccess {
+		t.Errorf("Unexpected deletion result %d", result)
+	}
+	if mappings := handler.Mappings(); len(mappings) != 1 || mappings[0].Protocol != "pcp" {
+		t.Errorf("Expected only the PCP mapping to remain, got %v", mappings)
+	}
+}
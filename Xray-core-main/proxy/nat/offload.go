@@ -0,0 +1,386 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+const (
+	// defaultOffloadTable is the nftables table translations are programmed into unless configured
+	defaultOffloadTable = "xray_nat"
+	// defaultOffloadMark is the conntrack mark of offloaded flows unless configured, "XN"
+	defaultOffloadMark = 0x584e
+)
+
+// kernelOffload programs the translations of the rules the kernel can apply as nftables DNAT
+// rules, so their flows are translated by netfilter without reaching Xray, and masqueraded on
+// their way out like flows Xray dials. The match order is kept: rules that need Xray, such as
+// rules matching users or domains, or with ALGs or backend pools, return their destinations to
+// the usual path, and nothing is offloaded past an entry whose destination is not an address.
+// Flows translated by the kernel have no sessions: they are neither logged nor exported.
+type kernelOffload struct {
+	sync.Mutex
+	table string
+	mark  uint32
+	// apply runs an nft script
+	apply func(script string) error
+}
+
+func newKernelOffload(config *KernelOffload) (*kernelOffload, error) {
+	if runtime.GOOS != "linux" {
+		return nil, errors.New("kernel offload needs Linux")
+	}
+	o := &kernelOffload{table: config.Table, mark: config.Mark}
+	if o.table == "" {
+		o.table = defaultOffloadTable
+	}
+	if o.mark == 0 {
+		o.mark = defaultOffloadMark
+	}
+	nft := config.NftPath
+	if nft == "" {
+		nft = "nft"
+	}
+	o.apply = func(script string) error {
+		cmd := exec.Command(nft, "-f", "-")
+		cmd.Stdin = strings.NewReader(script)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return errors.New("nft failed: ", strings.TrimSpace(string(output))).Base(err)
+		}
+		return nil
+	}
+	return o, nil
+}
+
+// program replaces the table by one translating what the entries allow, all at once
+func (o *kernelOffload) program(entries []string) error {
+	o.Lock()
+	defer o.Unlock()
+	mark := "0x" + strconv.FormatUint(uint64(o.mark), 16)
+	var b strings.Builder
+	b.WriteString(o.removal())
+	b.WriteString("table inet " + o.table + " {\n")
+	b.WriteString("\tchain prerouting {\n\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+	for _, entry := range entries {
+		b.WriteString("\t\t" + strings.ReplaceAll(entry, "$mark", mark) + "\n")
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("\tchain postrouting {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	b.WriteString("\t\tct mark " + mark + " masquerade\n")
+	b.WriteString("\t}\n}\n")
+	return o.apply(b.String())
+}
+
+// removal is an nft script deleting the table, present or not
+func (o *kernelOffload) removal() string {
+	return "table inet " + o.table + " {}\ndelete table inet " + o.table + "\n"
+}
+
+// Close removes the table; flows the kernel translates already keep their conntrack entries
+func (o *kernelOffload) Close() error {
+	o.Lock()
+	defer o.Unlock()
+	return o.apply(o.removal())
+}
+
+// syncOffload programs the kernel with the active rules, or with none while draining so new flows
+// stop being translated there too
+func (h *Handler) syncOffload() {
+	if h.offload == nil {
+		return
+	}
+	var entries []string
+	if h.drain.Load() == nil {
+		entries = h.offloadEntries()
+	}
+	if err := h.offload.program(entries); err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: failed to program kernel offload table ", h.offload.table)
+		return
+	}
+	errors.LogInfo(context.Background(), "NAT: programmed ", len(entries), " translations into kernel offload table ", h.offload.table)
+}
+
+// offloadEntries renders the prerouting rules of the rules, static mappings and virtual ranges,
+// in that order as firstMatch checks them
+func (h *Handler) offloadEntries() []string {
+	var entries []string
+	for _, rule := range h.activeRules() {
+		if h.RuleDisabled(rule) {
+			continue
+		}
+		entry, reason := offloadRule(rule)
+		if entry == "" {
+			errors.LogInfo(context.Background(), "NAT: kernel offload stops at rule ", ruleKey(rule), ": ", reason)
+			return entries
+		}
+		if reason != "" {
+			errors.LogDebug(context.Background(), "NAT: rule ", ruleKey(rule), " is left to Xray: ", reason)
+		}
+		entries = append(entries, entry)
+	}
+	for _, mapping := range h.config.StaticMappings {
+		entry, reason := offloadAddress(mapping.VirtualAddress, mapping.RealAddress)
+		if entry == "" {
+			errors.LogInfo(context.Background(), "NAT: kernel offload stops at static mapping ", mapping.VirtualAddress, ": ", reason)
+			return entries
+		}
+		if reason != "" {
+			errors.LogDebug(context.Background(), "NAT: static mapping ", mapping.VirtualAddress, " is left to Xray: ", reason)
+		}
+		entries = append(entries, entry)
+	}
+	for _, vr := range h.config.VirtualRanges {
+		if h.rangeDisabled(vr) {
+			continue
+		}
+		entry, reason := offloadRange(vr)
+		if entry == "" {
+			errors.LogInfo(context.Background(), "NAT: kernel offload stops at virtual range ", vr.VirtualNetwork, ": ", reason)
+			return entries
+		}
+		if reason != "" {
+			errors.LogDebug(context.Background(), "NAT: virtual range ", vr.VirtualNetwork, " is left to Xray: ", reason)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// nftAddress returns the nftables family and form of an address or network, or false when s is
+// neither, such as a domain
+func nftAddress(s string) (string, string, bool) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return "ip", ip4.String(), true
+		}
+		return "ip6", ip.String(), true
+	}
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		if network.IP.To4() != nil {
+			return "ip", network.String(), true
+		}
+		return "ip6", network.String(), true
+	}
+	return "", "", false
+}
+
+// nftPorts renders a port list as an nftables set
+func nftPorts(s string) (string, error) {
+	list, err := parsePortList(s)
+	if err != nil || len(list) == 0 {
+		return "", err
+	}
+	parts := make([]string, len(list))
+	for i, segment := range list {
+		parts[i] = segment.from.String()
+		if segment.to != segment.from {
+			parts[i] += "-" + segment.to.String()
+		}
+	}
+	return "{ " + strings.Join(parts, ", ") + " }", nil
+}
+
+// nftProtocols renders the protocols of a rule, reporting false for protocols other than TCP and
+// UDP, protocol groups included; ports need one of these
+func nftProtocols(protocol string, ports bool) (string, bool) {
+	tokens := protocolTokens(protocol)
+	if slices.Contains(tokens, protocolAny) {
+		// Every protocol is translated, as with an empty protocol
+		tokens = nil
+	}
+	var protocols []string
+	for _, p := range tokens {
+		switch p {
+		case "tcp", "udp":
+			protocols = append(protocols, p)
+		default:
+			return "", false
+		}
+	}
+	switch {
+	case len(protocols) == 1:
+		return "meta l4proto " + protocols[0], true
+	case len(protocols) > 1 || ports:
+		return "meta l4proto { tcp, udp }", true
+	default:
+		return "", true
+	}
+}
+
+// offloadRule renders a rule: its translation, or a return of its destination to Xray with the
+// reason it stays there. The entry is empty, with the reason, for a destination that is not an
+// address.
+func offloadRule(rule *NATRule) (string, string) {
+	family, dest, ok := nftAddress(rule.VirtualDestination)
+	if !ok {
+		return "", "virtual destination " + rule.VirtualDestination + " is not an address"
+	}
+	leave := func(reason string) (string, string) {
+		return family + " daddr " + dest + " return", reason
+	}
+	switch {
+	case rule.SourceSite != "":
+		return leave("matches a source site")
+	case len(rule.Users) > 0 || len(rule.UserLevels) > 0:
+		return leave("matches users")
+	case len(rule.Domains) > 0 || len(rule.DestGeosite) > 0:
+		return leave("matches domains")
+	case len(rule.SourceGeoip) > 0 || len(rule.DestGeoip) > 0:
+		return leave("matches geoip lists")
+	case rule.Schedule != nil:
+		return leave("has a schedule")
+	case len(rule.Alg) > 0:
+		return leave("has ALGs")
+	case rule.ForwardTag != "":
+		return leave("is forwarded through an outbound")
+	case len(rule.RealDestinations) > 0:
+		return leave("has a backend pool")
+	case rule.NatBehavior != "":
+		return leave("has a NAT behavior")
+	case rule.MaxSessions != 0:
+		return leave("caps its sessions")
+	case rule.RateLimit != nil:
+		return leave("has a rate limit")
+	case rule.Sockopt != nil || rule.Tos != 0 || rule.PreserveTos || rule.FlowLabel != "":
+		return leave("sets socket options")
+	case rule.TcpMssClamp != 0 || rule.Mtu != 0 || rule.MtuPolicy != "":
+		return leave("clamps the MSS or sets an MTU")
+	}
+	switch strings.ToLower(rule.Action) {
+	case "", "translate", "allow":
+	case "bypass":
+		entry, _ := leave("")
+		return entry, ""
+	default:
+		return leave("action " + rule.Action)
+	}
+
+	realFamily, real, ok := nftAddress(rule.RealDestination)
+	if !ok || strings.Contains(real, "/") {
+		return leave("real destination is not an address")
+	}
+	if realFamily != family {
+		return leave("translates between address families")
+	}
+
+	match := []string{family + " daddr " + dest}
+	for i, source := range rule.SourceAddresses {
+		sourceFamily, address, ok := nftAddress(source)
+		if !ok || sourceFamily != family {
+			return leave("source addresses are not of its family")
+		}
+		if i == 0 {
+			match = append(match, family+" saddr { "+address)
+		} else {
+			match[len(match)-1] += ", " + address
+		}
+	}
+	if len(rule.SourceAddresses) > 0 {
+		match[len(match)-1] += " }"
+	}
+
+	var ports []string
+	for _, list := range []struct{ selector, value string }{
+		{"th dport", rule.Ports},
+		{"th sport", rule.SourcePorts},
+		{"th dport", rule.GetPortMapping().GetOriginalPort()},
+	} {
+		set, err := nftPorts(list.value)
+		if err != nil {
+			return leave("invalid ports")
+		}
+		if set != "" {
+			ports = append(ports, list.selector+" "+set)
+		}
+	}
+	target := real
+	translatesPort := false
+	if mapping := rule.PortMapping; mapping != nil && strings.TrimSpace(mapping.OriginalPort) != "" {
+		if len(mapping.ProtocolPorts) > 0 {
+			return leave("maps ports per protocol")
+		}
+		translated, err := parsePortList(mapping.TranslatedPort)
+		if err != nil || translated.size() > 1 {
+			return leave("maps ports to several ports")
+		}
+		if translated.size() == 1 {
+			port := translated.at(0).String()
+			if family == "ip6" {
+				target = "[" + real + "]:" + port
+			} else {
+				target = real + ":" + port
+			}
+			translatesPort = true
+		}
+	}
+	if rule.PortOffset != 0 {
+		return leave("shifts ports by an offset")
+	}
+	protocols, ok := nftProtocols(rule.Protocol, len(ports) > 0 || translatesPort)
+	if !ok {
+		return leave("matches protocol " + rule.Protocol)
+	}
+	if protocols != "" {
+		match = append(match, protocols)
+	}
+	match = append(match, ports...)
+	return strings.Join(match, " ") + " ct mark set $mark dnat " + family + " to " + target, ""
+}
+
+// offloadAddress renders a static mapping of one address to another
+func offloadAddress(virtual, real string) (string, string) {
+	family, dest, ok := nftAddress(virtual)
+	if !ok {
+		return "", "virtual address " + virtual + " is not an address"
+	}
+	realFamily, target, ok := nftAddress(real)
+	if !ok || realFamily != family || strings.Contains(target, "/") {
+		return family + " daddr " + dest + " return", "real address is not of its family"
+	}
+	return family + " daddr " + dest + " ct mark set $mark dnat " + family + " to " + target, ""
+}
+
+// offloadRange renders a virtual range mapped onto its real network prefix for prefix, or onto
+// the one address of a masquerade range
+func offloadRange(vr *VirtualIPRange) (string, string) {
+	family, dest, ok := nftAddress(vr.VirtualNetwork)
+	if !ok {
+		return "", "virtual network " + vr.VirtualNetwork + " is not a network"
+	}
+	leave := func(reason string) (string, string) {
+		return family + " daddr " + dest + " return", reason
+	}
+	switch {
+	case vr.SourceSite != "" || vr.Symmetric:
+		return leave("matches sites")
+	case vr.Ipv6Enabled || vr.Ipv4To6Prefix != "" || vr.NpTv6VirtualPrefix != "":
+		return leave("translates IPv6")
+	case vr.SendThrough != "":
+		return leave("is dialed from a local address")
+	case len(vr.MasqueradePool) > 0 || vr.MasqueradePorts != "":
+		return leave("has a masquerade pool")
+	case vr.MaxSessions != 0:
+		return leave("caps its sessions")
+	case vr.Tos != 0 || vr.PreserveTos || vr.FlowLabel != "":
+		return leave("sets socket options")
+	}
+	realFamily, real, ok := nftAddress(vr.RealNetwork)
+	if !ok || realFamily != family {
+		return leave("real network is not of its family")
+	}
+	if vr.Masquerade {
+		if strings.Contains(real, "/") {
+			return leave("masquerades onto a network")
+		}
+		return family + " daddr " + dest + " ct mark set $mark dnat " + family + " to " + real, ""
+	}
+	return family + " daddr " + dest + " ct mark set $mark dnat " + family + " prefix to " + family + " daddr map { " + dest + " : " + real + " }", ""
+}
@@ -0,0 +1,162 @@
+package nat
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOffloadRule(t *testing.T) {
+	cases := []struct {
+		rule  *NATRule
+		entry string
+	}{
+		{
+			&NATRule{VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Protocol: "tcp", Ports: "80,8000-8100", SourceAddresses: []string{"10.0.0.0/8", "172.16.0.1"}},
+			"ip daddr 240.2.2.20 ip saddr { 10.0.0.0/8, 172.16.0.1 } meta l4proto tcp th dport { 80, 8000-8100 } ct mark set $mark dnat ip to 192.168.1.20",
+		},
+		{
+			&NATRule{VirtualDestination: "240.2.2.21", RealDestination: "192.168.1.21", PortMapping: &PortMapping{OriginalPort: "80", TranslatedPort: "8080"}},
+			"ip daddr 240.2.2.21 meta l4proto { tcp, udp } th dport { 80 } ct mark set $mark dnat ip to 192.168.1.21:8080",
+		},
+		{
+			&NATRule{VirtualDestination: "fd00::20", RealDestination: "fd01::20", PortMapping: &PortMapping{OriginalPort: "any", TranslatedPort: "443"}, Protocol: "tcp"},
+			"ip6 daddr fd00::20 meta l4proto tcp ct mark set $mark dnat ip6 to [fd01::20]:443",
+		},
+		{&NATRule{VirtualDestination: "240.2.2.0/24", Action: "bypass"}, "ip daddr 240.2.2.0/24 return"},
+		{&NATRule{VirtualDestination: "240.2.2.22", RealDestination: "192.168.1.22", Users: []string{"alice@example.com"}}, "ip daddr 240.2.2.22 return"},
+		{&NATRule{VirtualDestination: "240.2.2.23", RealDestinations: []string{"192.168.1.23", "192.168.1.24"}}, "ip daddr 240.2.2.23 return"},
+		{&NATRule{VirtualDestination: "240.2.2.24", RealDestination: "64:ff9b::c0a8:118"}, "ip daddr 240.2.2.24 return"},
+		{&NATRule{VirtualDestination: "240.2.2.25", RealDestination: "db.internal"}, "ip daddr 240.2.2.25 return"},
+		{&NATRule{VirtualDestination: "db.corp", RealDestination: "192.168.1.25"}, ""},
+		{&NATRule{VirtualDestination: "240.2.2.26", RealDestination: "192.168.1.26", Protocol: "any", Ports: "22"}, "ip daddr 240.2.2.26 meta l4proto { tcp, udp } th dport { 22 } ct mark set $mark dnat ip to 192.168.1.26"},
+		{&NATRule{VirtualDestination: "240.2.2.27", RealDestination: "192.168.1.27", Protocol: "web"}, "ip daddr 240.2.2.27 return"},
+		{&NATRule{VirtualDestination: "240.2.2.28", RealDestination: "192.168.1.28", Ports: "8000-8100", PortOffset: 1000}, "ip daddr 240.2.2.28 return"},
+		{&NATRule{VirtualDestination: "240.2.2.29", RealDestination: "192.168.1.29", MaxSessions: 100}, "ip daddr 240.2.2.29 return"},
+		{&NATRule{VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30", RateLimit: &RateLimit{UplinkBytesPerSecond: 1 << 20}}, "ip daddr 240.2.2.30 return"},
+		{&NATRule{VirtualDestination: "240.2.2.31", RealDestination: "192.168.1.31", TcpMssClamp: 1360}, "ip daddr 240.2.2.31 return"},
+		{&NATRule{VirtualDestination: "240.2.2.32", RealDestination: "192.168.1.32", Mtu: 1400, MtuPolicy: "drop"}, "ip daddr 240.2.2.32 return"},
+		{&NATRule{VirtualDestination: "240.2.2.33", RealDestination: "192.168.1.33", PreserveTos: true}, "ip daddr 240.2.2.33 return"},
+		{&NATRule{VirtualDestination: "fd00::34", RealDestination: "fd01::34", FlowLabel: "none"}, "ip6 daddr fd00::34 return"},
+	}
+	for _, c := range cases {
+		if entry, reason := offloadRule(c.rule); entry != c.entry {
+			t.Errorf("Unexpected entry %q (%s) for %v, expected %q", entry, reason, c.rule, c.entry)
+		}
+	}
+}
+
+func TestKernelOffload(t *testing.T) {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Protocol: "tcp", Ports: "443"},
+			{RuleId: "staff", VirtualDestination: "240.2.2.21", RealDestination: "192.168.1.21", Users: []string{"alice@example.com"}},
+			{RuleId: "db", VirtualDestination: "db.corp", RealDestination: "192.168.1.30"},
+			{RuleId: "late", VirtualDestination: "240.2.2.22", RealDestination: "192.168.1.22"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var scripts []string
+	handler.offload = &kernelOffload{table: defaultOffloadTable, mark: defaultOffloadMark, apply: func(script string) error {
+		scripts = append(scripts, script)
+		return nil
+	}}
+
+	handler.syncOffload()
+	script := scripts[len(scripts)-1]
+	for _, want := range []string{
+		"delete table inet xray_nat\n",
+		"type nat hook prerouting priority dstnat;",
+		"ip daddr 240.2.2.20 meta l4proto tcp th dport { 443 } ct mark set 0x584e dnat ip to 192.168.1.20\n",
+		"ip daddr 240.2.2.21 return\n",
+		"ct mark 0x584e masquerade\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in the ruleset:\n%s", want, script)
+		}
+	}
+	// Flows to a domain rule may have any address, so the rules after it stay with Xray
+	if strings.Contains(script, "240.2.2.22") {
+		t.Errorf("Expected nothing to be offloaded past the domain rule:\n%s", script)
+	}
+
+	// Draining empties the table, resuming programs it again
+	if _, err := handler.Drain(DrainReject, 0); err != nil {
+		t.Fatal(err)
+	}
+	if script := scripts[len(scripts)-1]; strings.Contains(script, "dnat") {
+		t.Errorf("Expected no translations while draining:\n%s", script)
+	}
+	handler.Resume()
+	if script := scripts[len(scripts)-1]; !strings.Contains(script, "dnat ip to 192.168.1.20") {
+		t.Errorf("Expected the translations back after resuming:\n%s", script)
+	}
+
+	handler.Close()
+	if script := scripts[len(scripts)-1]; script != "table inet xray_nat {}\ndelete table inet xray_nat\n" {
+		t.Errorf("Expected the table to be removed on close, got:\n%s", script)
+	}
+}
+
+func TestOffloadRange(t *testing.T) {
+	cases := []struct {
+		vr    *VirtualIPRange
+		entry string
+	}{
+		{&VirtualIPRange{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"}, "ip daddr 240.2.2.0/24 ct mark set $mark dnat ip prefix to ip daddr map { 240.2.2.0/24 : 192.168.1.0/24 }"},
+		{&VirtualIPRange{VirtualNetwork: "240.3.0.0/16", RealNetwork: "192.168.2.1", Masquerade: true}, "ip daddr 240.3.0.0/16 ct mark set $mark dnat ip to 192.168.2.1"},
+		{&VirtualIPRange{VirtualNetwork: "240.4.0.0/16", RealNetwork: "192.168.4.0/16", SendThrough: "10.0.0.1"}, "ip daddr 240.4.0.0/16 return"},
+		{&VirtualIPRange{VirtualNetwork: "240.5.0.0/16", RealNetwork: "192.168.5.0/24", Ipv4To6Prefix: "64:ff9b::/96"}, "ip daddr 240.5.0.0/16 return"},
+		{&VirtualIPRange{VirtualNetwork: "240.6.0.0/16", RealNetwork: "192.168.6.0/16", MaxSessions: 100}, "ip daddr 240.6.0.0/16 return"},
+		{&VirtualIPRange{VirtualNetwork: "240.7.0.0/16", RealNetwork: "192.168.7.0/16", Tos: 0x20}, "ip daddr 240.7.0.0/16 return"},
+		{&VirtualIPRange{VirtualNetwork: "240.8.0.0/16", RealNetwork: "192.168.8.0/16", PreserveTos: true}, "ip daddr 240.8.0.0/16 return"},
+		{&VirtualIPRange{VirtualNetwork: "fd00:9::/64", RealNetwork: "fd01:9::/64", FlowLabel: "auto"}, "ip6 daddr fd00:9::/64 return"},
+	}
+	for _, c := range cases {
+		if entry, reason := offloadRange(c.vr); entry != c.entry {
+			t.Errorf("Unexpected entry %q (%s) for %v, expected %q", entry, reason, c.vr, c.entry)
+		}
+	}
+}
+
+// offloadFields are the fields of the rules and ranges offloadRule and offloadRange account for,
+// either in the nftables entry, by leaving the rule to Xray, or because they do not change how a
+// flow is translated. A field missing here has to be handled there before it is added.
+var offloadFields = map[string][]string{
+	"NATRule": {
+		"rule_id", "source_site", "virtual_destination", "real_destination", "protocol",
+		"port_mapping", "port_offset", "nat_behavior", "real_destinations", "strategy", "priority",
+		"action", "ports", "domain_strategy", "source_geoip", "dest_geoip", "dest_geosite",
+		"schedule", "source_addresses", "source_ports", "users", "user_levels", "alg",
+		"reuse_connections", "sockopt", "tos", "forward_tag", "domains", "rate_limit",
+		"max_sessions", "session_class", "tcp_mss_clamp", "mtu", "mtu_policy", "preserve_tos",
+		"flow_label", "tags", "disabled", "expires_at", "ttl",
+	},
+	"VirtualIPRange": {
+		"virtual_network", "real_network", "ipv6_enabled", "ipv6_virtual_prefix", "ipv4_to6_prefix",
+		"np_tv6_virtual_prefix", "np_tv6_real_prefix", "send_through", "source_site", "symmetric",
+		"peer_site", "peer_virtual_network", "peer_real_network", "masquerade", "masquerade_ports",
+		"masquerade_pool", "masquerade_pooling", "max_sessions", "tos", "preserve_tos",
+		"flow_label", "tags", "disabled",
+	},
+}
+
+func TestOffloadCoversFields(t *testing.T) {
+	for _, message := range []proto.Message{&NATRule{}, &VirtualIPRange{}} {
+		descriptor := message.ProtoReflect().Descriptor()
+		known := make(map[string]bool)
+		for _, name := range offloadFields[string(descriptor.Name())] {
+			known[name] = true
+		}
+		fields := descriptor.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			if name := string(fields.Get(i).Name()); !known[name] {
+				t.Errorf("Field %s of %s is not accounted for by the kernel offload", name, descriptor.Name())
+			}
+		}
+	}
+}
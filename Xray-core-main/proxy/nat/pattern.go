@@ -0,0 +1,108 @@
+package nat
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// regexpPrefix marks a virtual destination holding a regular expression
+const regexpPrefix = "regexp:"
+
+// destinationPattern is a compiled wildcard or regular expression virtual destination
+type destinationPattern struct {
+	err    error  // Set when the pattern could not be compiled; it then never matches
+	octets [4]int // IPv4 octets of a wildcard, -1 for "*"
+	regexp *regexp.Regexp
+}
+
+// isPatternDestination reports whether a virtual destination is a wildcard such as
+// "240.2.*.20" or a "regexp:" regular expression rather than an address, network or domain
+func isPatternDestination(virtual string) bool {
+	return strings.HasPrefix(virtual, regexpPrefix) || (strings.Contains(virtual, "*") && !strings.ContainsAny(virtual, "/:"))
+}
+
+// compilePattern compiles a wildcard or regular expression virtual destination
+func compilePattern(virtual string) *destinationPattern {
+	p := &destinationPattern{}
+	if expr, found := strings.CutPrefix(virtual, regexpPrefix); found {
+		p.regexp, p.err = regexp.Compile(expr)
+		return p
+	}
+	octets := strings.Split(virtual, ".")
+	if len(octets) != 4 {
+		p.err = errors.New("wildcard ", virtual, " is not four IPv4 octets")
+		return p
+	}
+	for i, octet := range octets {
+		if octet == "*" {
+			p.octets[i] = -1
+			continue
+		}
+		value, err := strconv.ParseUint(octet, 10, 8)
+		if err != nil {
+			p.err = errors.New("wildcard ", virtual, " has an invalid octet ", octet)
+			return p
+		}
+		p.octets[i] = int(value)
+	}
+	return p
+}
+
+// ValidateDestinationPattern checks a virtual destination if it is a wildcard or regular expression
+func ValidateDestinationPattern(virtual string) error {
+	if !isPatternDestination(virtual) {
+		return nil
+	}
+	return compilePattern(virtual).err
+}
+
+// matches checks an address against the pattern. Wildcards match IPv4 addresses; regular
+// expressions match the text of addresses, without brackets, and domains.
+func (p *destinationPattern) matches(address xnet.Address) bool {
+	switch {
+	case p.err != nil:
+		return false
+	case p.regexp != nil:
+		if address.Family().IsDomain() {
+			return p.regexp.MatchString(address.Domain())
+		}
+		return p.regexp.MatchString(address.IP().String())
+	case !address.Family().IsIPv4():
+		return false
+	}
+	ip := address.IP().To4()
+	for i, octet := range p.octets {
+		if octet >= 0 && int(ip[i]) != octet {
+			return false
+		}
+	}
+	return true
+}
+
+// patternOf returns the compiled pattern of a virtual destination, compiling it on first use
+func (h *Handler) patternOf(virtual string) *destinationPattern {
+	if p, found := h.patterns.Load(virtual); found {
+		return p.(*destinationPattern)
+	}
+	p := compilePattern(virtual)
+	if p.err != nil {
+		errors.LogWarningInner(context.Background(), p.err, "NAT virtual destination ", virtual, " never matches")
+	}
+	actual, _ := h.patterns.LoadOrStore(virtual, p)
+	return actual.(*destinationPattern)
+}
+
+// compilePatterns compiles the wildcard and regular expression virtual destinations of rules
+// ahead of their first flow
+func (h *Handler) compilePatterns(rules []*NATRule) {
+	for _, rule := range rules {
+		if isPatternDestination(rule.VirtualDestination) {
+			h.patternOf(rule.VirtualDestination)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestDestinationPatterns(t *testing.T) {
+	config := &Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "wildcard", VirtualDestination: "240.2.*.20", RealDestination: "192.168.1.20"},
+			{RuleId: "regexp", VirtualDestination: `regexp:^240\.3\.[0-9]+\.(1|2)$`, RealDestination: "192.168.2.10"},
+			{RuleId: "domain", VirtualDestination: `regexp:^db[0-9]+\.internal\.example$`, RealDestination: "192.168.3.10"},
+			{RuleId: "broken", VirtualDestination: "240.4.*.300", RealDestination: "192.168.4.10"},
+			{RuleId: "host", VirtualDestination: "240.2.7.20", RealDestination: "192.168.1.21"},
+		},
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(config, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := handler.patterns.Load("240.2.*.20"); !found {
+		t.Error("Expected the patterns of the rules to be compiled at init")
+	}
+	matched := func(address string) string {
+		rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(address), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	for address, expected := range map[string]string{
+		"240.2.5.20":            "wildcard",
+		"240.2.7.20":            "wildcard",
+		"240.2.5.21":            "",
+		"240.3.9.2":             "regexp",
+		"240.3.9.3":             "",
+		"db12.internal.example": "domain",
+		"app.internal.example":  "",
+		"240.4.1.44":            "",
+		"::ffff:240.2.5.20":     "wildcard",
+		"2001:db8::240:2:5:20":  "",
+	} {
+		if id := matched(address); id != expected {
+			t.Errorf("Expected %s to match %q, got %q", address, expected, id)
+		}
+	}
+
+	// Wildcards fix the octets ahead of their first "*"
+	config.MatchStrategy = matchLongestPrefix
+	if id := matched("240.2.7.20"); id != "host" {
+		t.Errorf("longestPrefix: expected the host rule over the wildcard, got %q", id)
+	}
+	if bits := virtualPrefixLen("240.2.*.20"); bits != 16 {
+		t.Errorf("Expected the wildcard to fix 16 bits, got %d", bits)
+	}
+	if bits := virtualPrefixLen("regexp:^240\\."); bits != 0 {
+		t.Errorf("Expected the regular expression to fix no bits, got %d", bits)
+	}
+}
+
+func TestValidateDestinationPattern(t *testing.T) {
+	for _, virtual := range []string{"240.2.*.20", "*.*.*.*", `regexp:^240\.2\.`, "240.2.2.20", "240.2.2.0/24", "app.internal.example"} {
+		if err := ValidateDestinationPattern(virtual); err != nil {
+			t.Errorf("Expected %q to be valid: %v", virtual, err)
+		}
+	}
+	for _, virtual := range []string{"240.2.*", "240.2.*.256", "240.2.x*.20", "*.example.com", "regexp:240.(2"} {
+		if err := ValidateDestinationPattern(virtual); err == nil {
+			t.Errorf("Expected %q to be rejected", virtual)
+		}
+	}
+}
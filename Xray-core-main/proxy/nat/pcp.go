@@ -0,0 +1,319 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/xtls/xray-core/common/dice"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// Port Control Protocol (RFC 6887)
+const (
+	pcpPort    = 5351
+	pcpVersion = 2
+
+	pcpOpAnnounce = 0
+	pcpOpMap      = 1
+
+	pcpHeaderSize    = 24
+	pcpMapSize       = 36
+	pcpMaxPacketSize = 1100
+
+	pcpDefaultMaxLifetime = 7200
+	pcpMinLifetime        = 120
+	pcpDefaultMaxMappings = 64
+)
+
+// PCP result codes
+const (
+	pcpSuccess          = 0
+	pcpUnsuppVersion    = 1
+	pcpNotAuthorized    = 2
+	pcpMalformedRequest = 3
+	pcpUnsuppOpcode     = 4
+	pcpUnsuppOption     = 5
+	pcpNetworkFailure   = 7
+	pcpNoResources      = 8
+	pcpUnsuppProtocol   = 9
+	pcpUserExQuota      = 10
+	pcpAddressMismatch  = 12
+)
+
+// pcpServer answers the PCP requests of clients behind the NAT. MAP requests open inbound
+// mappings on the external side, which are refreshed and deleted by the client that created
+// them, identified by its nonce. NAT-PMP requests are answered on the same port and UPnP by
+// the IGD of the server, when enabled.
+type pcpServer struct {
+	handler     *Handler
+	conn        *net.UDPConn
+	upnp        *upnpServer
+	mappingIP   net.IP // Local address mappings listen on, nil for all
+	externalIP  net.IP
+	ports       portList
+	maxLifetime uint32
+	maxMappings int
+	epoch       time.Time
+}
+
+func newPCPServer(h *Handler, config *PortControl) (*pcpServer, error) {
+	s := &pcpServer{
+		handler:     h,
+		maxLifetime: config.MaxLifetime,
+		maxMappings: int(config.MaxMappingsPerClient),
+		epoch:       time.Now(),
+	}
+	if s.maxLifetime == 0 {
+		s.maxLifetime = pcpDefaultMaxLifetime
+	}
+	if s.maxMappings == 0 {
+		s.maxMappings = pcpDefaultMaxMappings
+	}
+	ports := config.Ports
+	if ports == "" {
+		ports = "1024-65535"
+	}
+	var err error
+	if s.ports, err = parsePortList(ports); err != nil {
+		return nil, errors.New("invalid mapping ports ", ports).Base(err)
+	}
+	if config.MappingAddress != "" {
+		if s.mappingIP = net.ParseIP(config.MappingAddress); s.mappingIP == nil {
+			return nil, errors.New("invalid mapping address ", config.MappingAddress)
+		}
+	}
+	if config.ExternalAddress != "" {
+		if s.externalIP = net.ParseIP(config.ExternalAddress); s.externalIP == nil {
+			return nil, errors.New("invalid external address ", config.ExternalAddress)
+		}
+	} else if s.mappingIP != nil {
+		s.externalIP = s.mappingIP
+	} else if s.externalIP = defaultRouteAddress(); s.externalIP == nil {
+		s.externalIP = net.IPv4zero
+	}
+
+	listen, err := pcpListenAddress(config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if s.conn, err = net.ListenUDP("udp", listen); err != nil {
+		return nil, errors.New("failed to listen for PCP on ", listen).Base(err)
+	}
+	if config.UpnpListen != "" {
+		if s.upnp, err = newUPnPServer(s, config.UpnpListen); err != nil {
+			s.conn.Close()
+			return nil, err
+		}
+	}
+	errors.LogInfo(context.Background(), "NAT: PCP server listening on ", s.conn.LocalAddr(), ", announcing ", s.externalIP)
+	go s.serve()
+	return s, nil
+}
+
+// pcpListenAddress parses the listen address of the server, port 5351 when omitted
+func pcpListenAddress(listen string) (*net.UDPAddr, error) {
+	if ip := net.ParseIP(listen); ip != nil || listen == "" {
+		return &net.UDPAddr{IP: ip, Port: pcpPort}, nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, errors.New("invalid PCP listen address ", listen).Base(err)
+	}
+	return addr, nil
+}
+
+// defaultRouteAddress returns the local address of the default IPv4 route
+func defaultRouteAddress() net.IP {
+	// Connecting a UDP socket sends nothing but selects the route
+	probe, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9})
+	if err != nil {
+		return nil
+	}
+	defer probe.Close()
+	return probe.LocalAddr().(*net.UDPAddr).IP
+}
+
+func (s *pcpServer) Close() error {
+	if s.upnp != nil {
+		s.upnp.Close()
+	}
+	return s.conn.Close()
+}
+
+func (s *pcpServer) serve() {
+	packet := make([]byte, pcpMaxPacketSize+1)
+	for {
+		n, from, err := s.conn.ReadFromUDP(packet)
+		if err != nil {
+			return
+		}
+		if response := s.handle(packet[:n], from); response != nil {
+			s.conn.WriteToUDP(response, from)
+		}
+	}
+}
+
+// secondsSinceEpoch is the epoch time of responses, which lets clients notice a restart of the
+// server and recreate their mappings
+func (s *pcpServer) secondsSinceEpoch() uint32 {
+	return uint32(time.Since(s.epoch) / time.Second)
+}
+
+// handle answers a request, or returns nil for packets that are not answered
+func (s *pcpServer) handle(request []byte, from *net.UDPAddr) []byte {
+	if len(request) < 2 || request[1]&0x80 != 0 {
+		// Too short to answer, or a response
+		return nil
+	}
+	opcode := request[1] & 0x7f
+	if request[0] == natpmpVersion {
+		return s.handleNATPMP(request, from)
+	}
+	if request[0] != pcpVersion {
+		return s.response(opcode, pcpUnsuppVersion, 0, nil)
+	}
+	if len(request) < pcpHeaderSize || len(request) > pcpMaxPacketSize || len(request)%4 != 0 {
+		return s.response(opcode, pcpMalformedRequest, 0, nil)
+	}
+	if !net.IP(request[8:24]).Equal(from.IP) {
+		// The client is behind another NAT
+		return s.response(opcode, pcpAddressMismatch, 0, nil)
+	}
+
+	switch opcode {
+	case pcpOpAnnounce:
+		return s.response(opcode, pcpSuccess, 0, nil)
+	case pcpOpMap:
+		if len(request) < pcpHeaderSize+pcpMapSize {
+			return s.response(opcode, pcpMalformedRequest, 0, nil)
+		}
+		payload := request[pcpHeaderSize : pcpHeaderSize+pcpMapSize]
+		if result := pcpCheckOptions(request[pcpHeaderSize+pcpMapSize:]); result != pcpSuccess {
+			return s.response(opcode, result, 0, payload)
+		}
+		return s.handleMap(from, binary.BigEndian.Uint32(request[4:8]), payload)
+	default:
+		return s.response(opcode, pcpUnsuppOpcode, 0, nil)
+	}
+}
+
+// pcpCheckOptions rejects the options of a request that must be processed, none of which
+// are supported; optional ones are ignored
+func pcpCheckOptions(options []byte) uint8 {
+	for len(options) > 0 {
+		if len(options) < 4 {
+			return pcpMalformedRequest
+		}
+		code, length := options[0], int(binary.BigEndian.Uint16(options[2:4]))
+		padded := 4 + (length+3)&^3
+		if padded > len(options) {
+			return pcpMalformedRequest
+		}
+		if code < 128 {
+			return pcpUnsuppOption
+		}
+		options = options[padded:]
+	}
+	return pcpSuccess
+}
+
+// handleMap creates, refreshes or deletes the mapping a MAP request asks for
+func (s *pcpServer) handleMap(from *net.UDPAddr, requested uint32, payload []byte) []byte {
+	h := s.handler
+	reply := append([]byte(nil), payload...)
+	nonce := hex.EncodeToString(payload[:12])
+	internalPort := xnet.Port(binary.BigEndian.Uint16(payload[16:18]))
+	suggestedPort := xnet.Port(binary.BigEndian.Uint16(payload[18:20]))
+
+	var network xnet.Network
+	switch payload[12] {
+	case 6:
+		network = xnet.Network_TCP
+	case 17:
+		network = xnet.Network_UDP
+	default:
+		return s.response(pcpOpMap, pcpUnsuppProtocol, 0, reply)
+	}
+	if internalPort == 0 {
+		return s.response(pcpOpMap, pcpMalformedRequest, 0, reply)
+	}
+	internal := xnet.Destination{Network: network, Address: xnet.IPAddress(from.IP), Port: internalPort}
+
+	existing, found := h.lookupMapping(internal)
+	if found && existing.owner != "pcp:"+nonce {
+		return s.response(pcpOpMap, pcpNotAuthorized, 0, reply)
+	}
+	if requested == 0 {
+		// Deletion
+		if found {
+			h.removeSession(existing.session.SessionID)
+		}
+		return s.response(pcpOpMap, pcpSuccess, 0, reply)
+	}
+
+	lifetime := min(max(requested, pcpMinLifetime), s.maxLifetime)
+	if found {
+		h.setMappingLifetime(existing, time.Duration(lifetime)*time.Second)
+		return s.response(pcpOpMap, pcpSuccess, lifetime, s.assigned(reply, existing.external))
+	}
+
+	if s.handler.mappingsOf(from.IP) >= s.maxMappings {
+		return s.response(pcpOpMap, pcpUserExQuota, 0, reply)
+	}
+	// The suggested external address is a hint; mappings always get the server's
+	m, err := s.open(internal, suggestedPort, time.Duration(lifetime)*time.Second, "pcp:"+nonce, "pcp")
+	if err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: PCP failed to map ", internal)
+		return s.response(pcpOpMap, pcpNoResources, 0, reply)
+	}
+	return s.response(pcpOpMap, pcpSuccess, lifetime, s.assigned(reply, m.external))
+}
+
+// open opens a mapping on the suggested port when it is allowed and free, or on a free port of
+// the mapping ports. The mapping is attributed to the port control protocol that asked for it.
+func (s *pcpServer) open(internal xnet.Destination, suggested xnet.Port, lifetime time.Duration, owner, protocol string) (*inboundMapping, error) {
+	ctx := context.Background()
+	if _, allowed := s.ports.indexOf(suggested); allowed && suggested != 0 {
+		if m, err := s.handler.openMapping(ctx, internal, s.mappingIP, s.externalIP, suggested, lifetime, owner, protocol); err == nil {
+			return m, nil
+		}
+	}
+	var err error
+	for tries := 0; tries < 16; tries++ {
+		port := s.ports.at(dice.Roll(s.ports.size()))
+		var m *inboundMapping
+		if m, err = s.handler.openMapping(ctx, internal, s.mappingIP, s.externalIP, port, lifetime, owner, protocol); err == nil {
+			return m, nil
+		}
+	}
+	return nil, errors.New("no free external port").Base(err)
+}
+
+// assigned fills the assigned external port and address into a MAP payload
+func (s *pcpServer) assigned(payload []byte, external xnet.Destination) []byte {
+	binary.BigEndian.PutUint16(payload[18:20], uint16(external.Port))
+	copy(payload[20:36], external.Address.IP().To16())
+	return payload
+}
+
+// response builds a response with the opcode payload of the request, if any. Errors carry the
+// lifetime clients wait before retrying: short for failures of resources that may free up.
+func (s *pcpServer) response(opcode, result uint8, lifetime uint32, payload []byte) []byte {
+	if result != pcpSuccess {
+		lifetime = 30 * 60
+		if result == pcpNoResources || result == pcpNetworkFailure || result == pcpUserExQuota {
+			lifetime = 30
+		}
+	}
+	response := make([]byte, pcpHeaderSize, pcpHeaderSize+len(payload))
+	response[0] = pcpVersion
+	response[1] = 0x80 | opcode
+	response[3] = result
+	binary.BigEndian.PutUint32(response[4:8], lifetime)
+	binary.BigEndian.PutUint32(response[8:12], s.secondsSinceEpoch())
+	return append(response, payload...)
+}
@@ -0,0 +1,161 @@
+package nat
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func newPCPTestHandler(t *testing.T) (*Handler, *net.UDPConn) {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:      "test-site",
+		PortControl: &PortControl{Listen: "127.0.0.1:0", MappingAddress: "127.0.0.1", MaxLifetime: 600},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+
+	client, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, handler.portControl.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return handler, client
+}
+
+// pcpMap sends a MAP request and returns the result code, lifetime and assigned external port
+func pcpMap(t *testing.T, client *net.UDPConn, nonce byte, protocol uint8, internalPort uint16, lifetime uint32) (uint8, uint32, uint16) {
+	t.Helper()
+	request := make([]byte, pcpHeaderSize+pcpMapSize)
+	request[0], request[1] = pcpVersion, pcpOpMap
+	binary.BigEndian.PutUint32(request[4:8], lifetime)
+	copy(request[8:24], client.LocalAddr().(*net.UDPAddr).IP.To16())
+	request[24] = nonce
+	request[36] = protocol
+	binary.BigEndian.PutUint16(request[40:42], internalPort)
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+
+	response := make([]byte, pcpMaxPacketSize)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := client.Read(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != pcpHeaderSize+pcpMapSize || response[1] != 0x80|pcpOpMap || response[24] != nonce {
+		t.Fatalf("Unexpected response % x", response[:n])
+	}
+	return response[3], binary.BigEndian.Uint32(response[4:8]), binary.BigEndian.Uint16(response[42:44])
+}
+
+func TestPCPMapTCP(t *testing.T) {
+	handler, client := newPCPTestHandler(t)
+	internal := listenTCP(t)
+	internalPort := uint16(internal.Addr().(*net.TCPAddr).Port)
+
+	result, lifetime, port := pcpMap(t, client, 1, 6, internalPort, 3600)
+	if result != pcpSuccess || lifetime != 600 || port == 0 {
+		t.Fatalf("Expected a mapping limited to the max lifetime, got result %d lifetime %d port %d", result, lifetime, port)
+	}
+
+	// The mapping is a session of the session table
+	m, found := handler.lookupMapping(xnet.TCPDestination(xnet.ParseAddress("127.0.0.1"), xnet.Port(internalPort)))
+	if !found || m.session.Direction != "mapping" || handler.sessionTimeout(m.session) != 600*time.Second {
+		t.Fatal("Expected the mapping to be tracked with its lifetime")
+	}
+	if _, ok := handler.sessions.Load(m.session.SessionID); !ok {
+		t.Error("Expected the mapping in the session table")
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", xnet.Port(port).String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "ping")
+	accepted, err := internal.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	data := make([]byte, 4)
+	accepted.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(accepted, data); err != nil || string(data) != "ping" {
+		t.Errorf("Expected the connection to reach the client, got %q: %v", data, err)
+	}
+
+	// Only the client's nonce may refresh or delete the mapping
+	if result, _, _ := pcpMap(t, client, 2, 6, internalPort, 3600); result != pcpNotAuthorized {
+		t.Errorf("Expected another nonce to be refused, got %d", result)
+	}
+	if result, _, refreshed := pcpMap(t, client, 1, 6, internalPort, 300); result != pcpSuccess || refreshed != port {
+		t.Errorf("Expected the refresh to keep the external port, got %d %d", result, refreshed)
+	}
+	if result, _, _ := pcpMap(t, client, 1, 6, internalPort, 0); result != pcpSuccess {
+		t.Errorf("Expected the deletion to succeed, got %d", result)
+	}
+	if _, ok := handler.sessions.Load(m.session.SessionID); ok {
+		t.Error("Expected the deleted mapping to leave the session table")
+	}
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", xnet.Port(port).String())); err == nil {
+		conn.Close()
+		t.Error("Expected the external port to be closed")
+	}
+}
+
+func TestPCPMapUDP(t *testing.T) {
+	_, client := newPCPTestHandler(t)
+	internal := listenUDP(t)
+
+	result, _, port := pcpMap(t, client, 3, 17, uint16(internal.LocalAddr().(*net.UDPAddr).Port), 120)
+	if result != pcpSuccess {
+		t.Fatalf("Unexpected result %d", result)
+	}
+	for _, payload := range []string{"first peer", "second peer"} {
+		peer, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		peer.Write([]byte(payload))
+		if got, from := readDatagram(t, internal); string(got) != payload {
+			t.Errorf("Unexpected datagram %q", got)
+		} else {
+			internal.WriteToUDP([]byte("reply"), from)
+		}
+		reply := make([]byte, 16)
+		peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := peer.Read(reply); err != nil || string(reply[:n]) != "reply" {
+			t.Errorf("Expected the reply to reach %s: %v", payload, err)
+		}
+	}
+}
+
+func TestPCPRequestErrors(t *testing.T) {
+	_, client := newPCPTestHandler(t)
+	if result, _, _ := pcpMap(t, client, 4, 1, 7, 120); result != pcpUnsuppProtocol {
+		t.Errorf("Expected ICMP mappings to be refused, got %d", result)
+	}
+
+	request := make([]byte, pcpHeaderSize+pcpMapSize)
+	request[0], request[1] = pcpVersion, pcpOpMap
+	copy(request[8:24], net.ParseIP("192.0.2.9").To16())
+	client.Write(request)
+	response := make([]byte, pcpMaxPacketSize)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := client.Read(response); err != nil || n < pcpHeaderSize || response[3] != pcpAddressMismatch {
+		t.Errorf("Expected a client behind another NAT to be detected: %v", err)
+	}
+
+	request[0] = 1
+	client.Write(request)
+	if n, err := client.Read(response); err != nil || n < pcpHeaderSize || response[3] != pcpUnsuppVersion {
+		t.Errorf("Expected an unsupported version to be reported: %v", err)
+	}
+}
@@ -0,0 +1,455 @@
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// checkpointVersion is the format version of session checkpoints
+const checkpointVersion = 1
+
+// redisTimeout bounds a checkpoint round trip to the Redis server
+const redisTimeout = 5 * time.Second
+
+// SessionRecord is the form sessions are checkpointed and shared in. Endpoints use the string
+// form of xnet.Destination and are empty when unknown.
+type SessionRecord struct {
+	SessionID     string    `json:"id"`
+	RuleID        string    `json:"rule,omitempty"`
+	Protocol      string    `json:"protocol"`
+	Direction     string    `json:"direction"`
+	VirtualSource string    `json:"virtualSource,omitempty"`
+	VirtualDest   string    `json:"virtualDest"`
+	RealSource    string    `json:"realSource,omitempty"`
+	RealDest      string    `json:"realDest"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastActivity  time.Time `json:"lastActivity"`
+	Established   bool      `json:"established,omitempty"`
+}
+
+// sessionCheckpoint is a snapshot of the session table
+type sessionCheckpoint struct {
+	Version  int             `json:"version"`
+	SavedAt  time.Time       `json:"savedAt"`
+	Sequence uint64          `json:"sequence"` // Session sequence, so restored and new IDs never collide
+	Sessions []SessionRecord `json:"sessions"`
+}
+
+func formatEndpoint(dest xnet.Destination) string {
+	if dest.Address == nil {
+		return ""
+	}
+	return dest.String()
+}
+
+func parseEndpoint(s string) (xnet.Destination, error) {
+	if s == "" {
+		return xnet.Destination{}, nil
+	}
+	return xnet.ParseDestination(s)
+}
+
+// persistable reports whether a session is carried over by checkpoints and replication. ICMP
+// query sessions and expected data connections are short lived and not worth restoring, and
+// requested mappings hold a listener of their own; clients refresh them.
+func persistable(s *NATSession) bool {
+	return s.Protocol != "icmp" && s.Direction != "expected" && s.Direction != "mapping"
+}
+
+func persistSession(s *NATSession) SessionRecord {
+	return SessionRecord{
+		SessionID:     s.SessionID,
+		RuleID:        s.RuleID,
+		Protocol:      s.Protocol,
+		Direction:     s.Direction,
+		VirtualSource: formatEndpoint(s.VirtualSource),
+		VirtualDest:   formatEndpoint(s.VirtualDest),
+		RealSource:    formatEndpoint(s.RealSource),
+		RealDest:      formatEndpoint(s.RealDest),
+		CreatedAt:     s.CreatedAt,
+		LastActivity:  s.LastActivity(),
+		Established:   tcpState(s.tcpState.Load()) == tcpStateEstablished,
+	}
+}
+
+func restoreSession(p *SessionRecord) (*NATSession, error) {
+	s := &NATSession{
+		SessionID: p.SessionID,
+		RuleID:    p.RuleID,
+		Protocol:  p.Protocol,
+		Direction: p.Direction,
+		CreatedAt: p.CreatedAt,
+	}
+	for _, endpoint := range []struct {
+		value string
+		dest  *xnet.Destination
+	}{
+		{p.VirtualSource, &s.VirtualSource},
+		{p.VirtualDest, &s.VirtualDest},
+		{p.RealSource, &s.RealSource},
+		{p.RealDest, &s.RealDest},
+	} {
+		dest, err := parseEndpoint(endpoint.value)
+		if err != nil {
+			return nil, errors.New("invalid endpoint ", endpoint.value).Base(err)
+		}
+		*endpoint.dest = dest
+	}
+	if s.SessionID == "" || s.VirtualDest.Address == nil {
+		return nil, errors.New("incomplete session")
+	}
+	s.Tuple = NewFiveTuple(s.VirtualSource, s.VirtualDest)
+	s.counters.lastActivity.Store(p.LastActivity.UnixNano())
+	if p.Established {
+		s.tcpState.Store(int32(tcpStateEstablished))
+	}
+	return s, nil
+}
+
+// checkpointStore keeps the latest session checkpoint
+type checkpointStore interface {
+	// Save replaces the stored checkpoint
+	Save(data []byte) error
+	// Load returns the stored checkpoint, or nil when there is none
+	Load() ([]byte, error)
+	String() string
+}
+
+func newCheckpointStore(config *SessionPersistence, siteID string) (checkpointStore, error) {
+	switch {
+	case config.Path != "":
+		return &fileCheckpoint{path: config.Path}, nil
+	case config.RedisAddress != "":
+		if _, _, err := net.SplitHostPort(config.RedisAddress); err != nil {
+			return nil, errors.New("invalid Redis address ", config.RedisAddress).Base(err)
+		}
+		key := config.RedisKey
+		if key == "" {
+			key = "xray:nat:" + siteID
+		}
+		return &redisCheckpoint{address: config.RedisAddress, password: config.RedisPassword, key: key}, nil
+	default:
+		return nil, errors.New("session persistence needs a path or a Redis address")
+	}
+}
+
+// fileCheckpoint stores the checkpoint in a file, replaced atomically so a crash while
+// saving leaves the previous checkpoint intact
+type fileCheckpoint struct {
+	sync.Mutex
+	path string
+}
+
+func (f *fileCheckpoint) Save(data []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	temp := f.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, f.path)
+}
+
+func (f *fileCheckpoint) Load() ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f *fileCheckpoint) String() string {
+	return f.path
+}
+
+// redisCheckpoint stores the checkpoint under a key of a Redis server, speaking just
+// enough RESP for AUTH, GET and SET
+type redisCheckpoint struct {
+	address  string
+	password string
+	key      string
+}
+
+func (r *redisCheckpoint) Save(data []byte) error {
+	_, err := r.command("SET", r.key, string(data))
+	return err
+}
+
+func (r *redisCheckpoint) Load() ([]byte, error) {
+	return r.command("GET", r.key)
+}
+
+func (r *redisCheckpoint) String() string {
+	return "redis://" + r.address + "/" + r.key
+}
+
+// command runs one command on a fresh connection, authenticating first when a password is set
+func (r *redisCheckpoint) command(args ...string) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", r.address, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	reader := bufio.NewReader(conn)
+	if r.password != "" {
+		if _, err := redisRoundTrip(conn, reader, "AUTH", r.password); err != nil {
+			return nil, errors.New("Redis authentication failed").Base(err)
+		}
+	}
+	return redisRoundTrip(conn, reader, args...)
+}
+
+// redisRoundTrip sends a command and reads a simple, integer, error or bulk string reply.
+// A null bulk string is returned as nil.
+func redisRoundTrip(w io.Writer, r *bufio.Reader, args ...string) ([]byte, error) {
+	reply, err := redisExchange(w, r, args...)
+	if err != nil {
+		return nil, err
+	}
+	if _, isArray := reply.([]any); isArray {
+		return nil, errors.New("unexpected Redis array reply")
+	}
+	data, _ := reply.([]byte)
+	return data, nil
+}
+
+// redisExchange sends a command and reads its reply of any type: strings as []byte, a null
+// bulk string or array as nil and arrays as []any
+func redisExchange(w io.Writer, r *bufio.Reader, args ...string) (any, error) {
+	var b bytes.Buffer
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(r)
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty Redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errors.New("Redis error: ", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.New("invalid Redis reply ", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.New("invalid Redis reply ", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		elements := make([]any, n)
+		for i := range elements {
+			if elements[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	default:
+		return nil, errors.New("unexpected Redis reply ", line)
+	}
+}
+
+// checkpointInterval returns how often the session table is checkpointed
+func (h *Handler) checkpointInterval() time.Duration {
+	if interval := h.config.GetSessionPersistence().GetInterval(); interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+	return 60 * time.Second // Default 1 minute
+}
+
+// checkpointSessions saves a snapshot of the session table
+func (h *Handler) checkpointSessions() error {
+	checkpoint := sessionCheckpoint{
+		Version:  checkpointVersion,
+		SavedAt:  time.Now(),
+		Sequence: h.sessionSeq.Load(),
+		Sessions: make([]SessionRecord, 0, h.sessions.Len()),
+	}
+	h.sessions.Range(func(s *NATSession) bool {
+		if persistable(s) {
+			checkpoint.Sessions = append(checkpoint.Sessions, persistSession(s))
+		}
+		return true
+	})
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return h.checkpoints.Save(data)
+}
+
+// checkpointRoutine periodically checkpoints the session table until the handler closes
+func (h *Handler) checkpointRoutine() {
+	ticker := time.NewTicker(h.checkpointInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.checkpointSessions(); err != nil {
+				errors.LogWarningInner(context.Background(), err, "NAT: failed to checkpoint sessions to ", h.checkpoints)
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// restoreSessions loads the stored checkpoint into the session table and returns the number of
+// restored sessions. Sessions that expired meanwhile are dropped, and the CGNAT source ports of
+// the others are reserved again so no new flow takes them.
+func (h *Handler) restoreSessions() (int, error) {
+	data, err := h.checkpoints.Load()
+	if err != nil || data == nil {
+		return 0, err
+	}
+	var checkpoint sessionCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return 0, errors.New("invalid NAT session checkpoint").Base(err)
+	}
+	if checkpoint.Version != checkpointVersion {
+		return 0, errors.New("unsupported NAT session checkpoint version ", checkpoint.Version)
+	}
+	if checkpoint.Sequence > h.sessionSeq.Load() {
+		h.sessionSeq.Store(checkpoint.Sequence)
+	}
+
+	now := time.Now()
+	restored := 0
+	for i := range checkpoint.Sessions {
+		if atomic.LoadInt64(&h.activeSessions) >= h.maxSessions {
+			break
+		}
+		natSession, err := restoreSession(&checkpoint.Sessions[i])
+		if err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: skipped persisted session ", checkpoint.Sessions[i].SessionID)
+			continue
+		}
+		stored, err := h.storeRestored(natSession, now)
+		if err != nil {
+			errors.LogWarningInner(context.Background(), err, "NAT: skipped persisted session ", natSession.SessionID)
+			continue
+		}
+		if stored {
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// storeRestored adds a session restored from a checkpoint or replicated by the peer to the session
+// table, reserving its CGNAT source port, until a resuming flow adopts it or it expires. It reports
+// false for sessions that already expired.
+func (h *Handler) storeRestored(natSession *NATSession, now time.Time) (bool, error) {
+	deadline := natSession.LastActivity().Add(h.sessionTimeout(natSession))
+	if !now.Before(deadline) {
+		return false, nil
+	}
+	if err := h.reserveSourcePort(natSession); err != nil {
+		return false, err
+	}
+
+	natSession.restored.Store(true)
+	natSession.class = h.classOfRule(natSession.RuleID)
+	h.advanceSequence(natSession.SessionID)
+	h.sessions.Store(natSession)
+	h.sessions.Schedule(natSession, deadline)
+	h.tupleIndex.Store(natSession.Tuple.Key(), natSession.SessionID)
+	h.restored.Store(natSession.Tuple.Key(), natSession)
+	atomic.AddInt64(&h.activeSessions, 1)
+	h.accountSession(natSession)
+	return true, nil
+}
+
+// reserveSourcePort reserves the CGNAT source port of a restored session
+func (h *Handler) reserveSourcePort(natSession *NATSession) error {
+	if h.portBlocks == nil || natSession.RealSource.Port == 0 || natSession.Direction == "hairpin" {
+		return nil
+	}
+	if natSession.VirtualSource.Address == nil || !natSession.VirtualSource.Address.Family().IsIP() {
+		return errors.New("CGNAT session without a subscriber address")
+	}
+	if !natSession.RealSource.Address.Family().IsIP() || !natSession.RealSource.Address.IP().Equal(h.portBlocks.publicAddress.IP()) {
+		return errors.New("CGNAT public address changed from ", natSession.RealSource.Address)
+	}
+	return h.portBlocks.Reserve(natSession.VirtualSource.Address.IP(), natSession.RealSource.Port)
+}
+
+// adoptRestoredSession replaces a restored session of the same flow by natSession, handing its
+// CGNAT source port over so a flow that resumes after a restart keeps its public endpoint.
+// It reports whether a source port was taken over.
+func (h *Handler) adoptRestoredSession(ctx context.Context, natSession *NATSession) bool {
+	var previous *NATSession
+	if value, found := h.restored.LoadAndDelete(natSession.Tuple.Key()); found {
+		previous = value.(*NATSession)
+		if _, loaded := h.sessions.LoadAndDelete(previous.SessionID); !loaded {
+			return false
+		}
+		atomic.AddInt64(&h.activeSessions, -1)
+		h.unindexSession(previous)
+		h.releaseSessionMemory(previous)
+	} else if previous = h.fetchSharedSession(natSession); previous == nil {
+		return false
+	}
+
+	if h.portBlocks == nil || previous.RealSource.Port == 0 || previous.Direction == "hairpin" {
+		return false
+	}
+	natSession.RealSource = xnet.Destination{
+		Network: natSession.VirtualDest.Network,
+		Address: h.portBlocks.publicAddress,
+		Port:    previous.RealSource.Port,
+	}
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		outbounds[len(outbounds)-1].Gateway = h.portBlocks.publicAddress
+	}
+	return true
+}
+
+// forgetRestored drops the restored flow entry of a session that leaves the session table
+func (h *Handler) forgetRestored(natSession *NATSession) {
+	if natSession.restored.Load() {
+		h.restored.CompareAndDelete(natSession.Tuple.Key(), natSession)
+	}
+}
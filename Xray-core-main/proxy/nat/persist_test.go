@@ -0,0 +1,256 @@
+package nat
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func newPersistentHandler(t *testing.T, persistence *SessionPersistence) *Handler {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:              "test-site",
+		PortBlockAllocation: &PortBlockAllocation{PublicAddress: "203.0.113.1", BlockSize: 512},
+		SessionPersistence:  persistence,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestSessionPersistence(t *testing.T) {
+	persistence := &SessionPersistence{Path: filepath.Join(t.TempDir(), "sessions.json")}
+	source := xnet.UDPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	dest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53)
+	backend := xnet.UDPDestination(xnet.ParseAddress("192.168.1.20"), 53)
+
+	first := newPersistentHandler(t, persistence)
+	for i, port := range []xnet.Port{40000, 40001} {
+		natSession := first.createNATSession(xnet.UDPDestination(source.Address, port), dest, backend, "outbound", classInteractive)
+		if err := first.allocateSourcePort(context.Background(), natSession); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// Idle beyond the UDP timeout, so it is not worth restoring
+			natSession.counters.lastActivity.Store(time.Now().Add(-time.Hour).UnixNano())
+		}
+	}
+	first.Close() // Writes the final checkpoint
+
+	second := newPersistentHandler(t, persistence)
+	defer second.Close()
+	if n := second.sessions.Len(); n != 1 {
+		t.Fatalf("Expected the live session to be restored, got %d sessions", n)
+	}
+	restored, found := second.LookupSession(NewFiveTuple(source, dest))
+	if !found || restored.RealDest != backend || restored.RealSource.Port != 1024 {
+		t.Fatalf("Unexpected restored session %+v", restored)
+	}
+
+	// The restored port stays reserved for its flow
+	other := second.createNATSession(xnet.UDPDestination(source.Address, 40002), dest, backend, "outbound", classInteractive)
+	if err := second.allocateSourcePort(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	if other.RealSource.Port == 1024 {
+		t.Error("A new flow must not take the port of a restored session")
+	}
+
+	// The resumed flow takes the restored session over with its port
+	resumed := second.createNATSession(source, dest, backend, "outbound", classInteractive)
+	if !second.adoptRestoredSession(context.Background(), resumed) || resumed.RealSource.Port != 1024 {
+		t.Errorf("Expected the resumed flow to keep port 1024, got %v", resumed.RealSource)
+	}
+	if _, found := second.sessions.Load(restored.SessionID); found {
+		t.Error("Expected the restored session to be replaced")
+	}
+	if resumed.SessionID == restored.SessionID {
+		t.Error("Session IDs must stay unique across restarts")
+	}
+
+	second.removeSession(resumed.SessionID)
+	second.removeSession(other.SessionID)
+	if blocks := second.PortBlocks(net.ParseIP("100.64.0.1")); len(blocks) != 0 {
+		t.Errorf("Expected all ports to be released, got %v", blocks)
+	}
+	if memory := second.Stats().MemoryBytes; memory != 0 {
+		t.Errorf("Expected the memory of the adopted session to be released, got %d bytes", memory)
+	}
+}
+
+func TestDamagedCheckpoint(t *testing.T) {
+	store := &fileCheckpoint{path: filepath.Join(t.TempDir(), "sessions.json")}
+	if err := store.Save([]byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	handler := newPersistentHandler(t, &SessionPersistence{Path: store.path})
+	defer handler.Close()
+	if handler.sessions.Len() != 0 {
+		t.Error("Expected a damaged checkpoint to be ignored")
+	}
+}
+
+// fakeKeys is the key space of fakeRedis
+type fakeKeys struct {
+	values map[string]string
+	expiry map[string]time.Time
+}
+
+// live reports whether a key is set, dropping it once expired
+func (k *fakeKeys) live(key string) bool {
+	if at, found := k.expiry[key]; found && !time.Now().Before(at) {
+		delete(k.values, key)
+		delete(k.expiry, key)
+	}
+	_, found := k.values[key]
+	return found
+}
+
+func bulkString(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+// fakeRedis serves AUTH, GET, SET with PX, DEL, PTTL, PEXPIREAT and SCAN, in one batch, for one
+// key space
+func fakeRedis(t *testing.T, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	values := make(chan *fakeKeys, 1)
+	values <- &fakeKeys{values: make(map[string]string), expiry: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				authenticated := password == ""
+				for {
+					args, err := readRESPCommand(reader)
+					if err != nil {
+						return
+					}
+					store := <-values
+					reply := "+OK\r\n"
+					switch {
+					case args[0] == "AUTH":
+						authenticated = args[1] == password
+						if !authenticated {
+							reply = "-WRONGPASS invalid password\r\n"
+						}
+					case !authenticated:
+						reply = "-NOAUTH Authentication required.\r\n"
+					case args[0] == "SET":
+						store.values[args[1]] = args[2]
+						delete(store.expiry, args[1])
+						if len(args) == 5 && args[3] == "PX" {
+							ms, _ := strconv.Atoi(args[4])
+							store.expiry[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+						}
+					case args[0] == "GET":
+						if store.live(args[1]) {
+							reply = bulkString(store.values[args[1]])
+						} else {
+							reply = "$-1\r\n"
+						}
+					case args[0] == "DEL":
+						reply = ":0\r\n"
+						if store.live(args[1]) {
+							delete(store.values, args[1])
+							delete(store.expiry, args[1])
+							reply = ":1\r\n"
+						}
+					case args[0] == "PTTL":
+						switch at, expires := store.expiry[args[1]]; {
+						case !store.live(args[1]):
+							reply = ":-2\r\n"
+						case !expires:
+							reply = ":-1\r\n"
+						default:
+							reply = ":" + strconv.FormatInt(time.Until(at).Milliseconds(), 10) + "\r\n"
+						}
+					case args[0] == "PEXPIREAT":
+						reply = ":0\r\n"
+						if store.live(args[1]) {
+							ms, _ := strconv.ParseInt(args[2], 10, 64)
+							store.expiry[args[1]] = time.UnixMilli(ms)
+							reply = ":1\r\n"
+						}
+					case args[0] == "SCAN":
+						prefix := strings.TrimSuffix(args[3], "*")
+						var keys []string
+						for key := range store.values {
+							if strings.HasPrefix(key, prefix) && store.live(key) {
+								keys = append(keys, bulkString(key))
+							}
+						}
+						reply = "*2\r\n" + bulkString("0") + "*" + strconv.Itoa(len(keys)) + "\r\n" + strings.Join(keys, "")
+					}
+					values <- store
+					io.WriteString(conn, reply)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisCheckpoint(t *testing.T) {
+	address := fakeRedis(t, "secret")
+	store, err := newCheckpointStore(&SessionPersistence{RedisAddress: address, RedisPassword: "secret"}, "site-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.Load(); err != nil || data != nil {
+		t.Fatalf("Expected no checkpoint yet, got %q, %v", data, err)
+	}
+	if err := store.Save([]byte(`{"version":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.Load(); err != nil || string(data) != `{"version":1}` {
+		t.Errorf("Unexpected checkpoint %q, %v", data, err)
+	}
+	if store.String() != "redis://"+address+"/xray:nat:site-a" {
+		t.Errorf("Unexpected store %s", store)
+	}
+
+	wrong, _ := newCheckpointStore(&SessionPersistence{RedisAddress: address, RedisPassword: "guess"}, "site-a")
+	if _, err := wrong.Load(); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+}
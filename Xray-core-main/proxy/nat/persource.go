@@ -0,0 +1,158 @@
+package nat
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// SourceUsage is how much of the per-source limits a virtual source address uses
+type SourceUsage struct {
+	Source   string
+	Sessions int     // Concurrent sessions held
+	Tokens   float64 // New sessions the address may create right now, 0 when the rate is unlimited
+	Refused  uint64  // New flows refused for exceeding the limits
+}
+
+// sourceQuota is the usage of one virtual source address
+type sourceQuota struct {
+	sessions int
+	bucket   tokenBucket // New session tokens, unused when the rate is unlimited
+	refused  uint64
+}
+
+// sourceQuotas enforces the per-source limits: a cap on the concurrent sessions of each virtual
+// source address and a token bucket of its new sessions, so a single host cannot exhaust the
+// session table. Addresses without sessions are forgotten once their bucket is full again.
+type sourceQuotas struct {
+	sync.Mutex
+	maxSessions int
+	rate        float64 // Tokens per second, 0 when unlimited
+	burst       float64
+	sources     map[string]*sourceQuota
+}
+
+func newSourceQuotas(config *PerSourceLimits) *sourceQuotas {
+	return &sourceQuotas{
+		maxSessions: int(config.MaxSessions),
+		rate:        float64(config.NewSessionsPerSecond),
+		burst:       float64(config.Burst),
+		sources:     make(map[string]*sourceQuota),
+	}
+}
+
+// idle reports whether a quota can be forgotten without loosening the limits. It must be
+// called with the lock held.
+func (q *sourceQuotas) idle(quota *sourceQuota, now time.Time) bool {
+	return quota.sessions == 0 && (q.rate == 0 || quota.bucket.full(now))
+}
+
+// acquire counts a new session of source, or refuses it when the source holds its maximum of
+// sessions or has no token left
+func (q *sourceQuotas) acquire(source string, now time.Time) error {
+	q.Lock()
+	defer q.Unlock()
+
+	quota, found := q.sources[source]
+	if !found {
+		quota = &sourceQuota{bucket: newTokenBucket(q.rate, q.burst, now)}
+		q.sources[source] = quota
+	}
+	if q.maxSessions > 0 && quota.sessions >= q.maxSessions {
+		quota.refused++
+		return errors.New("source ", source, " holds its maximum of ", q.maxSessions, " sessions")
+	}
+	if q.rate > 0 && !quota.bucket.take(now) {
+		quota.refused++
+		return errors.New("source ", source, " exceeds its rate of ", q.rate, " new sessions per second")
+	}
+	quota.sessions++
+	return nil
+}
+
+// release returns a session of source acquired before
+func (q *sourceQuotas) release(source string, now time.Time) {
+	q.Lock()
+	defer q.Unlock()
+
+	quota, found := q.sources[source]
+	if !found {
+		return
+	}
+	quota.sessions--
+	if q.idle(quota, now) {
+		delete(q.sources, source)
+	}
+}
+
+// prune forgets the addresses without sessions whose bucket refilled
+func (q *sourceQuotas) prune(now time.Time) {
+	q.Lock()
+	defer q.Unlock()
+
+	for source, quota := range q.sources {
+		if q.idle(quota, now) {
+			delete(q.sources, source)
+		}
+	}
+}
+
+// usage returns the usage of the tracked addresses, the most sessions first
+func (q *sourceQuotas) usage(now time.Time) []SourceUsage {
+	q.Lock()
+	defer q.Unlock()
+
+	usage := make([]SourceUsage, 0, len(q.sources))
+	for source, quota := range q.sources {
+		quota.bucket.refill(now)
+		usage = append(usage, SourceUsage{Source: source, Sessions: quota.sessions, Tokens: quota.bucket.tokens, Refused: quota.refused})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Sessions != usage[j].Sessions {
+			return usage[i].Sessions > usage[j].Sessions
+		}
+		return usage[i].Source < usage[j].Source
+	})
+	return usage
+}
+
+// admitSource counts a new flow from source against the per-source limits and returns the
+// address it was counted as, to be released with the session; it is empty when not counted
+func (h *Handler) admitSource(source xnet.Destination) (string, error) {
+	if h.sourceQuotas == nil || source.Address == nil {
+		return "", nil
+	}
+	address := source.Address.String()
+	if err := h.sourceQuotas.acquire(address, time.Now()); err != nil {
+		return "", errors.New("refusing a new flow over the per-source limits").Base(err)
+	}
+	return address, nil
+}
+
+// releaseSource returns a flow counted by admitSource
+func (h *Handler) releaseSource(address string) {
+	if h.sourceQuotas == nil || address == "" {
+		return
+	}
+	h.sourceQuotas.release(address, time.Now())
+}
+
+// SourceUsage returns how much of the per-source limits each virtual source address uses, the
+// most sessions first; nil when no per-source limits are configured
+func (h *Handler) SourceUsage() []SourceUsage {
+	if h.sourceQuotas == nil {
+		return nil
+	}
+	return h.sourceQuotas.usage(time.Now())
+}
+
+// pruneSources forgets the virtual source addresses that no longer count against the limits
+func (h *Handler) pruneSources() {
+	if h.sourceQuotas == nil {
+		return
+	}
+	h.sourceQuotas.prune(time.Now())
+}
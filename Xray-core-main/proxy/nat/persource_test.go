@@ -0,0 +1,92 @@
+package nat
+
+import (
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestSourceQuotas(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	q := newSourceQuotas(&PerSourceLimits{MaxSessions: 3, NewSessionsPerSecond: 2})
+
+	// The bucket holds a burst of the rate; spent tokens come back over time
+	for i := 0; i < 2; i++ {
+		if err := q.acquire("10.0.0.5", now); err != nil {
+			t.Fatalf("Expected session %d to be admitted, got %v", i, err)
+		}
+	}
+	if err := q.acquire("10.0.0.5", now); err == nil {
+		t.Error("Expected the rate to be exceeded")
+	}
+	if err := q.acquire("10.0.0.6", now); err != nil {
+		t.Errorf("Expected another source to have a bucket of its own, got %v", err)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if err := q.acquire("10.0.0.5", now); err != nil {
+		t.Errorf("Expected a refilled token to admit the session, got %v", err)
+	}
+
+	// The concurrent sessions are capped whatever the tokens
+	now = now.Add(10 * time.Second)
+	if err := q.acquire("10.0.0.5", now); err == nil {
+		t.Error("Expected the maximum of 3 sessions to be enforced")
+	}
+	q.release("10.0.0.5", now)
+	if err := q.acquire("10.0.0.5", now); err != nil {
+		t.Errorf("Expected a released session to make room, got %v", err)
+	}
+
+	usage := q.usage(now)
+	if len(usage) != 2 || usage[0].Source != "10.0.0.5" || usage[0].Sessions != 3 || usage[0].Refused != 2 {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+	if usage[1].Source != "10.0.0.6" || usage[1].Sessions != 1 || usage[1].Tokens != 2 {
+		t.Errorf("Unexpected usage of 10.0.0.6 %+v", usage[1])
+	}
+
+	// Sources are forgotten once idle with a full bucket
+	q.release("10.0.0.6", now)
+	for i := 0; i < 3; i++ {
+		q.release("10.0.0.5", now)
+	}
+	q.prune(now.Add(time.Second))
+	if usage := q.usage(now); len(usage) != 0 {
+		t.Errorf("Expected idle sources to be forgotten, got %+v", usage)
+	}
+}
+
+func TestPerSourceLimits(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", PerSourceLimits: &PerSourceLimits{MaxSessions: 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	destination := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+	quotaSource, err := handler.admitSource(source)
+	if err != nil || quotaSource != "10.0.0.5" {
+		t.Fatalf("Expected the first flow to be admitted, got %q, %v", quotaSource, err)
+	}
+	session := handler.createNATSession(source, destination, destination, "outbound", classInteractive)
+	session.quotaSource = quotaSource
+
+	if _, err := handler.admitSource(source); err == nil {
+		t.Error("Expected a second flow of the source to be refused")
+	}
+	if usage := handler.SourceUsage(); len(usage) != 1 || usage[0].Sessions != 1 || usage[0].Refused != 1 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	// Removing the session frees the quota of its source
+	handler.removeSession(session.SessionID)
+	if _, err := handler.admitSource(source); err != nil {
+		t.Errorf("Expected the flow to be admitted once the session ended, got %v", err)
+	}
+
+	if usage := New().SourceUsage(); usage != nil {
+		t.Errorf("Expected no usage without per-source limits, got %+v", usage)
+	}
+}
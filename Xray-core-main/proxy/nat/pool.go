@@ -0,0 +1,229 @@
+package nat
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// Backend selection strategies of a rule's real destination pool
+const (
+	strategyRoundRobin     = "round-robin"
+	strategyRandom         = "random"
+	strategyLeastSessions  = "least-sessions"
+	strategyConsistentHash = "consistent-hash"
+)
+
+const (
+	// maxPoolSize bounds the number of backends a pool may expand to
+	maxPoolSize = 4096
+
+	// backendCooldown is how long a backend is skipped after a connection to it failed
+	backendCooldown = 30 * time.Second
+)
+
+// backend is one real destination of a pool
+type backend struct {
+	address        string
+	sessions       atomic.Int64
+	unhealthyUntil atomic.Int64 // Unix nanoseconds
+}
+
+func (b *backend) healthy(now time.Time) bool {
+	return now.UnixNano() >= b.unhealthyUntil.Load()
+}
+
+// markUnhealthy takes the backend out of selection for backendCooldown
+func (b *backend) markUnhealthy() {
+	b.unhealthyUntil.Store(time.Now().Add(backendCooldown).UnixNano())
+}
+
+// affinity pins the sessions of one source to a backend while any of them is alive
+type affinity struct {
+	backend *backend
+	refs    int
+}
+
+// backendPool balances the sessions of a rule over its real destinations
+type backendPool struct {
+	strategy string
+	backends []*backend
+	cursor   atomic.Uint64
+
+	sync.Mutex
+	affinity map[string]*affinity // Source address -> backend of its live sessions
+}
+
+// expandPool expands the entries of a pool into backend addresses. CIDR entries contribute
+// their host addresses, without the network and broadcast addresses of IPv4 networks up to /30.
+func expandPool(destinations []string) ([]string, error) {
+	var addresses []string
+	for _, destination := range destinations {
+		destination = strings.TrimSpace(destination)
+		if !strings.Contains(destination, "/") {
+			if destination == "" {
+				return nil, errors.New("empty pool entry")
+			}
+			addresses = append(addresses, destination)
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(destination)
+		if err != nil {
+			return nil, errors.New("invalid pool network ", destination).Base(err)
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones > 12 {
+			return nil, errors.New("pool network ", destination, " exceeds ", maxPoolSize, " addresses")
+		}
+		count := 1 << (bits - ones)
+		first, last := 0, count
+		if bits == 32 && bits-ones >= 2 {
+			first, last = 1, count-1
+		}
+		for i := first; i < last; i++ {
+			ip := make(net.IP, len(network.IP))
+			copy(ip, network.IP)
+			for j, carry := len(ip)-1, i; j >= 0 && carry > 0; j, carry = j-1, carry>>8 {
+				ip[j] |= byte(carry)
+			}
+			addresses = append(addresses, ip.String())
+		}
+	}
+	if len(addresses) > maxPoolSize {
+		return nil, errors.New("pool has ", len(addresses), " addresses, more than ", maxPoolSize)
+	}
+	return addresses, nil
+}
+
+// ValidateBackendPool checks the entries and the strategy of a real destination pool
+func ValidateBackendPool(destinations []string, strategy string) error {
+	switch strategy {
+	case "", strategyRoundRobin, strategyRandom, strategyLeastSessions, strategyConsistentHash:
+	default:
+		return errors.New(strategy, " is not one of round-robin, random, least-sessions, consistent-hash")
+	}
+	_, err := expandPool(destinations)
+	return err
+}
+
+func newBackendPool(rule *NATRule) (*backendPool, error) {
+	if err := ValidateBackendPool(rule.RealDestinations, rule.Strategy); err != nil {
+		return nil, err
+	}
+	addresses, _ := expandPool(rule.RealDestinations)
+
+	pool := &backendPool{
+		strategy: rule.Strategy,
+		affinity: make(map[string]*affinity),
+	}
+	if pool.strategy == "" {
+		pool.strategy = strategyRoundRobin
+	}
+	for _, address := range addresses {
+		pool.backends = append(pool.backends, &backend{address: address})
+	}
+	return pool, nil
+}
+
+// pick selects the backend of a new session from source. Sources with live sessions stay on their
+// backend while it is healthy; unhealthy backends are skipped unless no backend is healthy.
+func (p *backendPool) pick(source xnet.Address) *backend {
+	key := ""
+	if source != nil {
+		key = source.String()
+	}
+	now := time.Now()
+
+	p.Lock()
+	defer p.Unlock()
+
+	if a, found := p.affinity[key]; found && key != "" && a.backend.healthy(now) {
+		a.refs++
+		a.backend.sessions.Add(1)
+		return a.backend
+	}
+
+	candidates := make([]*backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.healthy(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.backends
+	}
+
+	var chosen *backend
+	switch p.strategy {
+	case strategyRandom:
+		chosen = candidates[rand.Intn(len(candidates))]
+	case strategyLeastSessions:
+		for _, b := range candidates {
+			if chosen == nil || b.sessions.Load() < chosen.sessions.Load() {
+				chosen = b
+			}
+		}
+	case strategyConsistentHash:
+		// Rendezvous hashing: removing a backend only moves the sources that were on it
+		var best uint64
+		for _, b := range candidates {
+			hash := fnv.New64a()
+			hash.Write([]byte(key))
+			hash.Write([]byte{0})
+			hash.Write([]byte(b.address))
+			if score := hash.Sum64(); chosen == nil || score > best {
+				chosen, best = b, score
+			}
+		}
+	default:
+		chosen = candidates[(p.cursor.Add(1)-1)%uint64(len(candidates))]
+	}
+
+	chosen.sessions.Add(1)
+	if key != "" {
+		p.affinity[key] = &affinity{backend: chosen, refs: 1}
+	}
+	return chosen
+}
+
+// release ends a session from source on backend b
+func (p *backendPool) release(source xnet.Address, b *backend) {
+	b.sessions.Add(-1)
+	if source == nil {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	key := source.String()
+	if a, found := p.affinity[key]; found && a.backend == b {
+		a.refs--
+		if a.refs <= 0 {
+			delete(p.affinity, key)
+		}
+	}
+}
+
+// backendPool returns the pool of a rule with real destinations, or nil for rules without one
+func (h *Handler) backendPool(rule *NATRule) (*backendPool, error) {
+	if len(rule.RealDestinations) == 0 {
+		return nil, nil
+	}
+	if pool, found := h.pools.Load(rule); found {
+		return pool.(*backendPool), nil
+	}
+	pool, err := newBackendPool(rule)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := h.pools.LoadOrStore(rule, pool)
+	return actual.(*backendPool), nil
+}
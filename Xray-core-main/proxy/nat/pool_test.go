@@ -0,0 +1,120 @@
+package nat
+
+import (
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestExpandPool(t *testing.T) {
+	addresses, err := expandPool([]string{"10.0.0.1", "192.168.1.0/30", "2001:db8::/127"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"10.0.0.1", "192.168.1.1", "192.168.1.2", "2001:db8::", "2001:db8::1"}
+	if len(addresses) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, addresses)
+	}
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, addresses)
+			break
+		}
+	}
+
+	if _, err := expandPool([]string{"10.0.0.0/8"}); err == nil {
+		t.Error("Expected pools larger than the limit to be rejected")
+	}
+	if err := ValidateBackendPool([]string{"10.0.0.1"}, "fastest"); err == nil {
+		t.Error("Expected unknown strategies to be rejected")
+	}
+}
+
+func TestBackendPoolRoundRobinSkipsUnhealthy(t *testing.T) {
+	pool, err := newBackendPool(&NATRule{RealDestinations: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.backends[1].markUnhealthy()
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		b := pool.pick(nil)
+		seen[b.address]++
+		pool.release(nil, b)
+	}
+	if seen["10.0.0.2"] != 0 || seen["10.0.0.1"] != 2 || seen["10.0.0.3"] != 2 {
+		t.Errorf("Unexpected distribution %v", seen)
+	}
+}
+
+func TestBackendPoolSourceAffinity(t *testing.T) {
+	pool, err := newBackendPool(&NATRule{RealDestinations: []string{"10.0.0.1", "10.0.0.2"}, Strategy: strategyLeastSessions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := xnet.ParseAddress("100.64.0.1")
+
+	first := pool.pick(client)
+	second := pool.pick(client)
+	if first != second {
+		t.Error("Sessions of one source should stick to the same backend")
+	}
+	if other := pool.pick(xnet.ParseAddress("100.64.0.2")); other == first {
+		t.Error("Least-sessions should move another source to the idle backend")
+	}
+
+	// Once the backend fails, new sessions of the source move away from it
+	first.markUnhealthy()
+	if moved := pool.pick(client); moved == first {
+		t.Error("Affinity must not keep a source on an unhealthy backend")
+	}
+}
+
+func TestBackendPoolConsistentHash(t *testing.T) {
+	pool, err := newBackendPool(&NATRule{RealDestinations: []string{"10.0.0.0/28"}, Strategy: strategyConsistentHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := []xnet.Address{xnet.ParseAddress("100.64.0.1"), xnet.ParseAddress("100.64.0.2"), xnet.ParseAddress("100.64.0.3")}
+	chosen := make(map[xnet.Address]*backend)
+	for _, source := range sources {
+		b := pool.pick(source)
+		pool.release(source, b)
+		chosen[source] = b
+	}
+
+	// Removing one backend only moves the sources that were on it
+	failed := chosen[sources[0]]
+	failed.markUnhealthy()
+	for _, source := range sources {
+		b := pool.pick(source)
+		pool.release(source, b)
+		if chosen[source] == failed {
+			if b == failed {
+				t.Error("Source stayed on the failed backend")
+			}
+		} else if b != chosen[source] {
+			t.Errorf("Source %s moved although its backend is healthy", source)
+		}
+	}
+}
+
+func TestHandlerBackendPoolPerRule(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	rule := &NATRule{RealDestinations: []string{"10.0.0.1", "10.0.0.2"}}
+	first, err := handler.backendPool(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := handler.backendPool(rule)
+	if first != second {
+		t.Error("Expected the pool of a rule to be reused")
+	}
+	if pool, _ := handler.backendPool(&NATRule{RealDestination: "10.0.0.1"}); pool != nil {
+		t.Error("Rules without real destinations must not have a pool")
+	}
+}
@@ -0,0 +1,259 @@
+package nat
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// portSegment is an inclusive port range of a port list
+type portSegment struct {
+	from, to xnet.Port
+}
+
+func (s portSegment) size() int {
+	return int(s.to) - int(s.from) + 1
+}
+
+// portList is a parsed comma-separated list of ports and port ranges, e.g. "80,443,8000-8100"
+type portList []portSegment
+
+// parsePortList parses a port list. An empty string or "any" yields an empty list.
+func parsePortList(s string) (portList, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "any") {
+		return nil, nil
+	}
+
+	var list portList
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		fromPort, err := xnet.PortFromString(strings.TrimSpace(from))
+		if err != nil {
+			return nil, errors.New("invalid port ", part).Base(err)
+		}
+		toPort := fromPort
+		if isRange {
+			if toPort, err = xnet.PortFromString(strings.TrimSpace(to)); err != nil {
+				return nil, errors.New("invalid port ", part).Base(err)
+			}
+		}
+		if fromPort > toPort {
+			return nil, errors.New("invalid port range ", part)
+		}
+		list = append(list, portSegment{from: fromPort, to: toPort})
+	}
+	return list, nil
+}
+
+// size returns the number of ports in the list
+func (l portList) size() int {
+	n := 0
+	for _, segment := range l {
+		n += segment.size()
+	}
+	return n
+}
+
+// indexOf returns the position of port in the list
+func (l portList) indexOf(port xnet.Port) (int, bool) {
+	index := 0
+	for _, segment := range l {
+		if port >= segment.from && port <= segment.to {
+			return index + int(port-segment.from), true
+		}
+		index += segment.size()
+	}
+	return 0, false
+}
+
+// at returns the port at a position of the list
+func (l portList) at(index int) xnet.Port {
+	for _, segment := range l {
+		if index < segment.size() {
+			return segment.from + xnet.Port(index)
+		}
+		index -= segment.size()
+	}
+	return 0
+}
+
+// Validate checks that both sides of the mapping parse and that a specified original side
+// has as many ports as each translated side, unless everything is translated to one port.
+func (m *PortMapping) Validate() error {
+	if strings.TrimSpace(m.OriginalPort) == "" {
+		return errors.New(`originalPort is empty, "any" maps every port`)
+	}
+	original, err := parsePortList(m.OriginalPort)
+	if err != nil {
+		return errors.New("invalid originalPort").Base(err)
+	}
+	validate := func(name, ports string) error {
+		translated, err := parsePortList(ports)
+		if err != nil {
+			return errors.New("invalid ", name).Base(err)
+		}
+		if len(original) > 0 && translated.size() > 1 && original.size() != translated.size() {
+			return errors.New("originalPort has ", original.size(), " ports but ", name, " has ", translated.size())
+		}
+		return nil
+	}
+	if err := validate("translatedPort", m.TranslatedPort); err != nil {
+		return err
+	}
+	for protocol, ports := range m.ProtocolPorts {
+		if protocol != "tcp" && protocol != "udp" {
+			return errors.New("protocolPorts has ", protocol, ", not tcp or udp")
+		}
+		if err := validate("protocolPorts "+protocol, ports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidatePortOffset checks that an offset shifts every port of a rule's ports into 1-65535.
+// The ports must be listed, as an offset shifts some port out of any range.
+func ValidatePortOffset(offset int32, ports string) error {
+	if offset == 0 {
+		return nil
+	}
+	list, err := parsePortList(ports)
+	if err != nil {
+		return errors.New("invalid ports").Base(err)
+	}
+	if len(list) == 0 {
+		return errors.New("portOffset ", offset, " needs the ports it shifts")
+	}
+	for _, segment := range list {
+		if from := int(segment.from) + int(offset); from < 1 {
+			return errors.New("portOffset ", offset, " shifts port ", segment.from, " to ", from, ", below 1")
+		}
+		if to := int(segment.to) + int(offset); to > 65535 {
+			return errors.New("portOffset ", offset, " shifts port ", segment.to, " to ", to, ", past 65535")
+		}
+	}
+	return nil
+}
+
+// shiftPort adds offset to port, keeping ports the offset would shift out of 1-65535
+func shiftPort(port xnet.Port, offset int32) xnet.Port {
+	shifted := int(port) + int(offset)
+	if shifted < 1 || shifted > 65535 {
+		return port
+	}
+	return xnet.Port(shifted)
+}
+
+// ValidatePorts checks a port list such as the ports of a rule
+func ValidatePorts(s string) error {
+	_, err := parsePortList(s)
+	return err
+}
+
+// translatedPortOf returns the translated ports of the flows of network
+func (m *PortMapping) translatedPortOf(network xnet.Network) string {
+	if ports, ok := m.ProtocolPorts[network.SystemString()]; ok {
+		return ports
+	}
+	return m.TranslatedPort
+}
+
+// rulePorts is the parsed ports of a rule
+type rulePorts struct {
+	err   error // Set when the ports do not parse; the rule then never matches
+	ports portList
+}
+
+// parsedPortMapping is a port mapping with its port lists parsed, and the round-robin position
+// of its "any" original side
+type parsedPortMapping struct {
+	mapped     bool                // Set when the original side is given and parses
+	original   portList            // Empty for "any"
+	translated map[string]portList // By network, of the networks whose translated side parses
+	cursor     atomic.Uint64
+}
+
+func newParsedPortMapping(m *PortMapping) *parsedPortMapping {
+	p := &parsedPortMapping{translated: make(map[string]portList)}
+	if strings.TrimSpace(m.OriginalPort) == "" {
+		return p
+	}
+	original, err := parsePortList(m.OriginalPort)
+	if err != nil {
+		return p
+	}
+	p.mapped, p.original = true, original
+	for _, network := range []xnet.Network{xnet.Network_TCP, xnet.Network_UDP, xnet.Network_Unknown} {
+		if translated, err := parsePortList(m.translatedPortOf(network)); err == nil && len(translated) > 0 {
+			p.translated[network.SystemString()] = translated
+		}
+	}
+	return p
+}
+
+// portsOf returns the parsed ports of a rule, parsing them on first use
+func (h *Handler) portsOf(rule *NATRule) *rulePorts {
+	if p, found := h.ports.Load(rule); found {
+		return p.(*rulePorts)
+	}
+	p := new(rulePorts)
+	p.ports, p.err = parsePortList(rule.Ports)
+	actual, _ := h.ports.LoadOrStore(rule, p)
+	return actual.(*rulePorts)
+}
+
+// portMappingOf returns the parsed port mapping, parsing it on first use
+func (h *Handler) portMappingOf(m *PortMapping) *parsedPortMapping {
+	if p, found := h.portMappings.Load(m); found {
+		return p.(*parsedPortMapping)
+	}
+	actual, _ := h.portMappings.LoadOrStore(m, newParsedPortMapping(m))
+	return actual.(*parsedPortMapping)
+}
+
+// parsePorts parses the ports and port mappings of rules ahead of their first flow
+func (h *Handler) parsePorts(rules []*NATRule) {
+	for _, rule := range rules {
+		if rule.Ports != "" {
+			h.portsOf(rule)
+		}
+		if rule.PortMapping != nil {
+			h.portMappingOf(rule.PortMapping)
+		}
+	}
+}
+
+// translate maps a port of a flow of network through the mapping. Ports of the original list
+// map to the port at the same position of the translated list, so ranges keep their offsets;
+// with an "any" original side ports are spread round-robin over the translated list. An empty
+// original side maps no port, every port is mapped only when asked for with "any".
+func (p *parsedPortMapping) translate(network xnet.Network, port xnet.Port) xnet.Port {
+	translated, ok := p.translated[network.SystemString()]
+	if !p.mapped || !ok {
+		return port
+	}
+
+	if len(p.original) == 0 {
+		if translated.size() == 1 {
+			return translated.at(0)
+		}
+		return translated.at(int((p.cursor.Add(1) - 1) % uint64(translated.size())))
+	}
+
+	index, ok := p.original.indexOf(port)
+	if !ok {
+		// Original port doesn't match, no mapping
+		return port
+	}
+	if translated.size() == 1 {
+		return translated.at(0)
+	}
+	if index >= translated.size() {
+		return port
+	}
+	return translated.at(index)
+}
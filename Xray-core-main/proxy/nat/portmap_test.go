@@ -0,0 +1,175 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestMapPortRanges(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	tests := []struct {
+		name       string
+		original   string
+		translated string
+		port       xnet.Port
+		expected   xnet.Port
+	}{
+		{"single port", "8080", "80", 8080, 80},
+		{"single port mismatch", "8080", "80", 8081, 8081},
+		{"range offset", "8000-8100", "9000-9100", 8042, 9042},
+		{"range end", "8000-8100", "9000-9100", 8100, 9100},
+		{"outside range", "8000-8100", "9000-9100", 8101, 8101},
+		{"list", "80,443,8000-8001", "8080,8443,9000-9001", 443, 8443},
+		{"list into range", "80,443", "10000-10001", 443, 10001},
+		{"range to one port", "8000-8100", "80", 8050, 80},
+		{"any to one port", "any", "80", 1234, 80},
+	}
+	for _, tt := range tests {
+		mapping := &PortMapping{OriginalPort: tt.original, TranslatedPort: tt.translated}
+		if err := mapping.Validate(); err != nil {
+			t.Errorf("%s: unexpected validation error %v", tt.name, err)
+		}
+		if got := handler.mapPort(xnet.Network_TCP, tt.port, mapping); got != tt.expected {
+			t.Errorf("%s: mapPort(%d) = %d, want %d", tt.name, tt.port, got, tt.expected)
+		}
+	}
+}
+
+func TestMapPortRoundRobin(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	mapping := &PortMapping{OriginalPort: "any", TranslatedPort: "9000-9001,9005"}
+	expected := []xnet.Port{9000, 9001, 9005, 9000}
+	for i, want := range expected {
+		if got := handler.mapPort(xnet.Network_TCP, 443, mapping); got != want {
+			t.Errorf("Round-robin step %d: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestMapPortProtocols(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	mapping := &PortMapping{OriginalPort: "53,8000-8001", TranslatedPort: "5353,9000-9001", ProtocolPorts: map[string]string{"udp": "5454,9100-9101"}}
+	if err := mapping.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		network  xnet.Network
+		port     xnet.Port
+		expected xnet.Port
+	}{
+		{xnet.Network_TCP, 53, 5353},
+		{xnet.Network_UDP, 53, 5454},
+		{xnet.Network_UDP, 8001, 9101},
+		{xnet.Network_UDP, 8002, 8002},
+	} {
+		if got := handler.mapPort(tt.network, tt.port, mapping); got != tt.expected {
+			t.Errorf("mapPort(%v, %d) = %d, want %d", tt.network, tt.port, got, tt.expected)
+		}
+	}
+
+	// Every port is mapped only through an explicit "any"
+	if got := handler.mapPort(xnet.Network_TCP, 1234, &PortMapping{TranslatedPort: "80"}); got != 1234 {
+		t.Errorf("Expected an empty originalPort to map no port, got %d", got)
+	}
+	anyPort := &PortMapping{OriginalPort: "any", ProtocolPorts: map[string]string{"tcp": "443"}}
+	if got := handler.mapPort(xnet.Network_TCP, 1234, anyPort); got != 443 {
+		t.Errorf("Expected any TCP port to map to 443, got %d", got)
+	}
+	if got := handler.mapPort(xnet.Network_UDP, 1234, anyPort); got != 1234 {
+		t.Errorf("Expected UDP ports without translated ports to be kept, got %d", got)
+	}
+}
+
+func TestRulePortsParsedAtInit(t *testing.T) {
+	mapping := &PortMapping{OriginalPort: "8000-8100", TranslatedPort: "9000-9100"}
+	rule := &NATRule{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Ports: "80,8000-8100", PortMapping: mapping}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{rule}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := handler.ports.Load(rule); !found {
+		t.Error("Expected the ports of the rule to be parsed at init")
+	}
+	if _, found := handler.portMappings.Load(mapping); !found {
+		t.Error("Expected the port mapping of the rule to be parsed at init")
+	}
+
+	for port, expected := range map[xnet.Port]bool{80: true, 8042: true, 443: false} {
+		if _, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), port)); ok != expected {
+			t.Errorf("Expected port %d to match %v, got %v", port, expected, ok)
+		}
+	}
+	if got := handler.mapPort(xnet.Network_TCP, 8042, mapping); got != 9042 {
+		t.Errorf("Expected 8042 to map to 9042, got %d", got)
+	}
+
+	handler.forgetRule(rule)
+	if _, found := handler.ports.Load(rule); found {
+		t.Error("Expected the ports of a forgotten rule to be dropped")
+	}
+	if _, found := handler.portMappings.Load(mapping); found {
+		t.Error("Expected the port mapping of a forgotten rule to be dropped")
+	}
+}
+
+func TestPortMappingValidate(t *testing.T) {
+	invalid := []*PortMapping{
+		{OriginalPort: "8000-8100", TranslatedPort: "9000-9050"},
+		{OriginalPort: "80,443", TranslatedPort: "8080-8082"},
+		{OriginalPort: "9000-8000", TranslatedPort: "80"},
+		{OriginalPort: "80", TranslatedPort: "http"},
+		{TranslatedPort: "80"},
+		{OriginalPort: "53", ProtocolPorts: map[string]string{"sctp": "5353"}},
+		{OriginalPort: "8000-8100", ProtocolPorts: map[string]string{"udp": "9000-9050"}},
+	}
+	for _, mapping := range invalid {
+		if err := mapping.Validate(); err == nil {
+			t.Errorf("Expected %s -> %s to be rejected", mapping.OriginalPort, mapping.TranslatedPort)
+		}
+	}
+}
+
+func TestPortOffset(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	rule := &NATRule{RuleId: "shifted", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Ports: "8000-8100", PortOffset: 1000}
+	if err := ValidatePortOffset(rule.PortOffset, rule.Ports); err != nil {
+		t.Fatal(err)
+	}
+	for port, expected := range map[xnet.Port]xnet.Port{8000: 9000, 8042: 9042, 8100: 9100} {
+		if real, err := handler.applyDNAT(xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), port), rule); err != nil || real.Port != expected {
+			t.Errorf("Expected port %d to shift to %d, got %v: %v", port, expected, real, err)
+		}
+	}
+	if port := shiftPort(65000, 1000); port != 65000 {
+		t.Errorf("Expected a port shifted past 65535 to be kept, got %d", port)
+	}
+
+	for _, tt := range []struct {
+		offset int32
+		ports  string
+	}{
+		{1000, ""},
+		{1000, "any"},
+		{1000, "80,65000-65100"},
+		{-100, "80,443"},
+		{1000, "80-"},
+	} {
+		if err := ValidatePortOffset(tt.offset, tt.ports); err == nil {
+			t.Errorf("Expected offset %d of ports %q to be rejected", tt.offset, tt.ports)
+		}
+	}
+	if err := ValidatePortOffset(-79, "80,443"); err != nil {
+		t.Errorf("Expected port 80 to shift to 1: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package nat
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pressureCleanupInterval is how often at most the sessions are cleaned up above the cleanup
+	// threshold, on top of the periodic cleanup
+	pressureCleanupInterval = time.Second
+
+	// minTimeoutScale is the share of their timeout idle sessions keep with the session table or
+	// the memory full
+	minTimeoutScale = 0.25
+)
+
+// pressure is the share of maxSessions or maxMemoryMB the sessions hold, whichever is higher
+func (h *Handler) pressure() float64 {
+	sessions := float64(atomic.LoadInt64(&h.activeSessions)) / float64(h.maxSessions)
+	memory := float64(h.memoryUsed.Load()) / float64(h.memoryLimit())
+	if memory > sessions {
+		return memory
+	}
+	return sessions
+}
+
+// timeoutScale shortens the timeouts as the pressure rises from the cleanup threshold, down to
+// minTimeoutScale with the limits reached
+func (h *Handler) timeoutScale(pressure float64) float64 {
+	threshold := h.cleanupThreshold
+	if pressure >= 1 || threshold >= 1 {
+		return minTimeoutScale
+	}
+	return 1 - (pressure-threshold)/(1-threshold)*(1-minTimeoutScale)
+}
+
+// relievePressure runs an aggressive cleanup, at most once a second, once the sessions reach the
+// cleanup threshold, rather than leaving it to the periodic cleanup. Expired sessions are
+// collected first; while still above the threshold the sessions idle for longer than their
+// timeout shortened by timeoutScale are evicted, those of the lowest session class first, then
+// UDP and ICMP sessions as they carry no connection state, the longest idle first. Requested
+// inbound mappings keep their lifetime.
+func (h *Handler) relievePressure() {
+	pressure := h.pressure()
+	if pressure < h.cleanupThreshold {
+		return
+	}
+	now := time.Now()
+	last := h.pressureCleanup.Load()
+	if now.UnixNano()-last < int64(pressureCleanupInterval) || !h.pressureCleanup.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	h.cleanupExpiredSessions()
+	if pressure = h.pressure(); pressure < h.cleanupThreshold {
+		return
+	}
+	scale := h.timeoutScale(pressure)
+
+	type idleSession struct {
+		session *NATSession
+		idle    time.Duration
+	}
+	var idle []idleSession
+	h.sessions.Range(func(session *NATSession) bool {
+		if session.Direction == "mapping" {
+			return true
+		}
+		if elapsed := now.Sub(session.LastActivity()); elapsed > time.Duration(float64(h.sessionTimeout(session))*scale) {
+			idle = append(idle, idleSession{session, elapsed})
+		}
+		return true
+	})
+	sort.Slice(idle, func(i, j int) bool {
+		if idle[i].session.class != idle[j].session.class {
+			return idle[i].session.class < idle[j].session.class
+		}
+		if stateless, other := !strings.EqualFold(idle[i].session.Protocol, "tcp"), !strings.EqualFold(idle[j].session.Protocol, "tcp"); stateless != other {
+			return stateless
+		}
+		return idle[i].idle > idle[j].idle
+	})
+	for _, candidate := range idle {
+		if h.pressure() < h.cleanupThreshold {
+			return
+		}
+		if _, loaded := h.sessions.LoadAndDelete(candidate.session.SessionID); loaded {
+			h.evictSession(candidate.session, EvictCleanupThreshold)
+		}
+	}
+}
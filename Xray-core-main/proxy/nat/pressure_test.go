@@ -0,0 +1,75 @@
+package nat
+
+import (
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestRelievePressure(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 10, CleanupThreshold: 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var evicted []*NATSession
+	handler.OnEvict(func(session *NATSession, reason string) {
+		if reason != EvictCleanupThreshold {
+			t.Errorf("Unexpected eviction reason %s", reason)
+		}
+		evicted = append(evicted, session)
+	})
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	port := xnet.Port(1000)
+	create := func(network xnet.Network, idle time.Duration, class sessionClass) *NATSession {
+		port++
+		dest := xnet.Destination{Network: network, Address: xnet.ParseAddress("240.2.2.20"), Port: port}
+		session := handler.createNATSession(source, dest, dest, "outbound", class)
+		session.counters.lastActivity.Store(time.Now().Add(-idle).UnixNano())
+		return session
+	}
+	// At 90% of maxSessions with a threshold of 50% the timeouts shrink to 40%: 120s of the 300s
+	// of TCP, 24s of the 60s of UDP
+	for i := 0; i < 3; i++ {
+		create(xnet.Network_TCP, 100*time.Second, classInteractive)
+	}
+	idleBulk := create(xnet.Network_TCP, 250*time.Second, classBulk)
+	for i := 0; i < 3; i++ {
+		create(xnet.Network_UDP, 40*time.Second, classInteractive)
+	}
+	create(xnet.Network_UDP, 0, classInteractive)
+	create(xnet.Network_UDP, 50*time.Second, classInteractive).Direction = "mapping"
+	handler.cleanupThreshold = 0.5
+	if scale := handler.timeoutScale(handler.pressure()); scale < 0.39 || scale > 0.41 {
+		t.Fatalf("Expected the timeouts to shrink to 40%%, got %v", scale)
+	}
+	handler.relievePressure()
+
+	if len(evicted) != 4 || evicted[0] != idleBulk {
+		t.Fatalf("Expected the idle bulk TCP session then the 3 idle UDP sessions to be evicted, got %d", len(evicted))
+	}
+	for _, session := range evicted[1:] {
+		if session.Protocol != xnet.Network_UDP.String() {
+			t.Errorf("Expected UDP sessions to be evicted first, got %s", session.Protocol)
+		}
+	}
+	if active := handler.Stats().ActiveSessions; active != 5 {
+		t.Errorf("Expected the recently active sessions and the mapping to be kept, got %d", active)
+	}
+
+	if scale := handler.timeoutScale(1); scale != minTimeoutScale {
+		t.Errorf("Expected the timeouts to shrink to a quarter with the table full, got %v", scale)
+	}
+	if scale := handler.timeoutScale(0.5); scale != 1 {
+		t.Errorf("Expected the timeouts to be kept at the threshold, got %v", scale)
+	}
+
+	// At most once a second
+	create(xnet.Network_UDP, time.Hour, classInteractive)
+	handler.relievePressure()
+	if len(evicted) != 4 {
+		t.Error("Expected the cleanup to wait a second before running again")
+	}
+}
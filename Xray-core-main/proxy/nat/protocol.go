@@ -0,0 +1,108 @@
+package nat
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// protocolAny matches every protocol, as does an empty protocol
+const protocolAny = "any"
+
+// protocolSCTP matches SCTP encapsulated in UDP (RFC 6951), the way SCTP crosses the transport.
+// The UDP flows of rules of this protocol are SCTP associations, relayed and tracked as one
+// stream under the SCTP timeout instead of being mapped per datagram.
+const protocolSCTP = "sctp"
+
+// protocolGroup is a named protocol of rules: transport networks limited to some ports
+type protocolGroup struct {
+	networks []string
+	ports    string
+}
+
+// protocolGroups are the named protocols rules may use next to "tcp", "udp", "sctp" and "any"
+var protocolGroups = map[string]protocolGroup{
+	"web":  {networks: []string{"tcp"}, ports: "80,443"},
+	"dns":  {networks: []string{"tcp", "udp"}, ports: "53"},
+	"quic": {networks: []string{"udp"}, ports: "443"},
+	"ssh":  {networks: []string{"tcp"}, ports: "22"},
+	"ntp":  {networks: []string{"udp"}, ports: "123"},
+}
+
+// protocolTokens splits the protocol of a rule into its lower case tokens
+func protocolTokens(protocol string) []string {
+	var tokens []string
+	for _, token := range strings.Split(strings.ToLower(protocol), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// ValidateProtocol checks the protocol of a rule: a comma separated list of "tcp", "udp",
+// "sctp", "any" and protocol groups, so unknown protocols fail instead of never matching.
+// SCTP rides on UDP, so "sctp" takes no other protocol matching UDP flows. Xray's links carry
+// only TCP and UDP, so there is no "icmp".
+func ValidateProtocol(protocol string) error {
+	tokens := protocolTokens(protocol)
+	for _, token := range tokens {
+		switch token {
+		case "tcp", "udp", protocolSCTP, protocolAny:
+		default:
+			if _, ok := protocolGroups[token]; !ok {
+				return errors.New("unknown protocol ", token)
+			}
+		}
+	}
+	if slices.Contains(tokens, protocolSCTP) {
+		for _, token := range tokens {
+			if token == "udp" || token == protocolAny || slices.Contains(protocolGroups[token].networks, "udp") {
+				return errors.New("sctp is carried over udp and excludes ", token)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateReuseConnections checks that a rule reusing connections matches TCP flows, the only
+// ones pooled, whatever the case or the groups of its protocol
+func ValidateReuseConnections(protocol string) error {
+	tokens := protocolTokens(protocol)
+	if len(tokens) == 0 {
+		return nil
+	}
+	for _, token := range tokens {
+		if token == "tcp" || token == protocolAny || slices.Contains(protocolGroups[token].networks, "tcp") {
+			return nil
+		}
+	}
+	return errors.New("reuseConnections only applies to tcp, not ", protocol)
+}
+
+// carriesSCTP reports whether the UDP flows of a rule are SCTP associations
+func carriesSCTP(rule *NATRule) bool {
+	return slices.Contains(protocolTokens(rule.Protocol), protocolSCTP)
+}
+
+// matchesProtocolToken checks the network of destination, "icmp" for echo queries, against
+// one token of the protocol of a rule
+func matchesProtocolToken(destination xnet.Destination, network, token string) bool {
+	if token == network || token == protocolAny || (token == protocolSCTP && network == "udp") {
+		return true
+	}
+	group, ok := protocolGroups[token]
+	if !ok {
+		return false
+	}
+	for _, groupNetwork := range group.networks {
+		if groupNetwork == network {
+			ports, _ := parsePortList(group.ports)
+			_, ok := ports.indexOf(destination.Port)
+			return ok
+		}
+	}
+	return false
+}
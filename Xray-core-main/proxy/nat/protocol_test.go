@@ -0,0 +1,122 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestMatchesProtocol(t *testing.T) {
+	handler := &Handler{}
+	tcp := func(port xnet.Port) xnet.Destination {
+		return xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), port)
+	}
+	udp := func(port xnet.Port) xnet.Destination {
+		return xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), port)
+	}
+	echo := xnet.Destination{Address: xnet.ParseAddress("240.2.2.20")}
+
+	for _, test := range []struct {
+		protocol    string
+		destination xnet.Destination
+		matches     bool
+	}{
+		{"", echo, true},
+		{"any", echo, true},
+		{"ANY", udp(5000), true},
+		{"tcp, udp", udp(5000), true},
+		{"tcp,udp", echo, false},
+		{"web", tcp(443), true},
+		{"web", tcp(8443), false},
+		{"web", udp(443), false},
+		{"web,quic", udp(443), true},
+		{"dns", udp(53), true},
+		{"dns", tcp(53), true},
+		{"ssh", echo, false},
+		{"sctp", udp(9899), true},
+		{"sctp", tcp(9899), false},
+		{"sctp", echo, false},
+	} {
+		if matches := handler.matchesProtocol(test.destination, test.protocol); matches != test.matches {
+			t.Errorf("Expected protocol %q matching %v to be %v", test.protocol, test.destination, test.matches)
+		}
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	for _, protocol := range []string{"", "tcp", "UDP", "tcp, udp", "any", "web,dns,quic,ssh,ntp", "sctp", "tcp,sctp,ssh"} {
+		if err := ValidateProtocol(protocol); err != nil {
+			t.Errorf("Expected %q to be valid: %v", protocol, err)
+		}
+	}
+	for _, protocol := range []string{"sctp,udp", "any,sctp", "sctp,dns", "tcp/80", "web,http", "icmp"} {
+		if ValidateProtocol(protocol) == nil {
+			t.Errorf("Expected error for protocol %q", protocol)
+		}
+	}
+}
+
+func TestSCTPAssociation(t *testing.T) {
+	server := listenUDP(t)
+	rule := &NATRule{RuleId: "m3ua", VirtualDestination: "240.2.2.29", RealDestination: "127.0.0.1", Protocol: "sctp"}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:         "test-site",
+		SessionTimeout: &SessionTimeout{TcpTimeout: 300, UdpTimeout: 30, SctpTimeout: 900},
+		Rules:          []*NATRule{rule},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	virtual := xnet.UDPDestination(xnet.ParseAddress("240.2.2.29"), xnet.Port(server.LocalAddr().(*net.UDPAddr).Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, virtual, udpTestDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	request := buf.New()
+	request.WriteString("INIT")
+	if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request}); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	payload := make([]byte, 64)
+	n, peer, err := server.ReadFromUDP(payload)
+	if err != nil || string(payload[:n]) != "INIT" {
+		t.Fatalf("Server did not receive the chunk: %v", err)
+	}
+	if _, err := server.WriteToUDP([]byte("INIT ACK"), peer); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil || mb.String() != "INIT ACK" {
+		t.Fatalf("Client did not receive the answer: %v", err)
+	}
+	buf.ReleaseMulti(mb)
+
+	// The association is one session under the SCTP timeout
+	sessions := handler.Sessions()
+	if len(sessions) != 1 || sessions[0].Protocol != protocolSCTP {
+		t.Fatalf("Expected one SCTP session, got %v", sessions)
+	}
+	if timeout := handler.sessionTimeout(sessions[0]); timeout != 900*time.Second {
+		t.Errorf("Expected the SCTP timeout 900s, got %v", timeout)
+	}
+	handler.config.SessionTimeout.SctpTimeout = 0
+	if timeout := handler.sessionTimeout(sessions[0]); timeout != 300*time.Second {
+		t.Errorf("Expected the SCTP timeout to fall back to tcpTimeout, got %v", timeout)
+	}
+}
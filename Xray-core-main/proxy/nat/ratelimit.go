@@ -0,0 +1,108 @@
+package nat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// ErrRateLimited is the cause of errors refusing a flow because the gateway creates new sessions
+// faster than its configured rate. It is retryable: the flow is admitted once tokens refill.
+var ErrRateLimited = errors.New("NAT new session rate exceeded")
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second. It is not safe for
+// concurrent use.
+type tokenBucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	refilled time.Time
+}
+
+// newTokenBucket returns a full bucket; burst defaults to the rate when 0
+func newTokenBucket(rate, burst float64, now time.Time) tokenBucket {
+	if burst == 0 {
+		burst = rate
+	}
+	return tokenBucket{rate: rate, burst: burst, tokens: burst, refilled: now}
+}
+
+// refill adds the tokens earned since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.refilled).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.refilled = now
+}
+
+// take spends a token, or reports false when none is left
+func (b *tokenBucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// takeBytes spends n tokens, or reports false when fewer are left. More than the burst may be
+// spent from a full bucket, so anything larger than the burst passes now and then.
+func (b *tokenBucket) takeBytes(now time.Time, n float64) bool {
+	b.refill(now)
+	if b.tokens < n && b.tokens < b.burst {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// spend takes n tokens, going into debt when fewer are left, and returns how long until the
+// debt is paid off
+func (b *tokenBucket) spend(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket refilled to its burst
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// sessionRateLimiter limits the new sessions of the whole gateway
+type sessionRateLimiter struct {
+	sync.Mutex
+	bucket tokenBucket
+}
+
+func newSessionRateLimiter(limits *ResourceLimits) *sessionRateLimiter {
+	if limits.GetNewSessionsPerSecond() == 0 {
+		return nil
+	}
+	return &sessionRateLimiter{
+		bucket: newTokenBucket(float64(limits.NewSessionsPerSecond), float64(limits.NewSessionBurst), time.Now()),
+	}
+}
+
+func (l *sessionRateLimiter) allow(now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.bucket.take(now)
+}
+
+// admitNewSession is called before a session of a new flow is created. Over the new session
+// rate it counts the refused flow and returns an error caused by ErrRateLimited.
+func (h *Handler) admitNewSession() error {
+	if h.sessionRate == nil || h.sessionRate.allow(time.Now()) {
+		return nil
+	}
+	atomic.AddInt64(&h.rateLimited, 1)
+	return errors.New("refusing a new flow over ", h.sessionRate.bucket.rate, " new sessions per second").Base(ErrRateLimited)
+}
@@ -0,0 +1,72 @@
+package nat
+
+import (
+	"bytes"
+	goerrors "errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	bucket := newTokenBucket(4, 2, now)
+	for i := 0; i < 2; i++ {
+		if !bucket.take(now) {
+			t.Fatalf("Expected token %d of the burst", i)
+		}
+	}
+	if bucket.take(now) {
+		t.Error("Expected the burst to be spent")
+	}
+	if !bucket.take(now.Add(250 * time.Millisecond)) {
+		t.Error("Expected a token after a quarter second at 4 per second")
+	}
+	if !bucket.full(now.Add(time.Hour)) || bucket.tokens != 2 {
+		t.Errorf("Expected the bucket to refill up to its burst, got %v tokens", bucket.tokens)
+	}
+
+	if bucket := newTokenBucket(3, 0, now); bucket.burst != 3 || bucket.tokens != 3 {
+		t.Errorf("Expected the burst to default to the rate, got %+v", bucket)
+	}
+}
+
+func TestNewSessionRate(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "limited-site", Limits: &ResourceLimits{NewSessionsPerSecond: 1, NewSessionBurst: 2}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := handler.admitNewSession(); err != nil {
+			t.Fatalf("Expected new session %d to be admitted, got %v", i, err)
+		}
+	}
+	if err := handler.admitNewSession(); !goerrors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected the flow over the burst to be refused with ErrRateLimited, got %v", err)
+	}
+	if stats := handler.Stats(); stats.RateLimited != 1 {
+		t.Errorf("Expected 1 rate limited flow, got %d", stats.RateLimited)
+	}
+
+	var out bytes.Buffer
+	if err := WritePrometheusMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `xray_nat_rate_limited_total{siteId="limited-site"} 1`) {
+		t.Errorf("Expected the rate limited flow to be counted, got:\n%s", out.String())
+	}
+
+	// Without a rate every flow is admitted
+	unlimited := New()
+	defer unlimited.Close()
+	if err := unlimited.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 10}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := unlimited.admitNewSession(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
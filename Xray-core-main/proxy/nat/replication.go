@@ -0,0 +1,385 @@
+package nat
+
+//go:generate go run github.com/xtls/xray-core/common/proto -cproto=./replication.proto -pnat -g
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// replicationQueueSize bounds the events waiting for the peer; when it overflows the
+	// stream restarts with a full sync
+	replicationQueueSize = 4096
+	// replicationRetry is the delay before reconnecting to the peer
+	replicationRetry = time.Second
+	// replicationSecretHeader carries the shared secret of the peers
+	replicationSecretHeader = "x-nat-replication-secret"
+)
+
+func replicatedSession(s *NATSession) *ReplicatedSession {
+	p := persistSession(s)
+	return &ReplicatedSession{
+		SessionId:     p.SessionID,
+		RuleId:        p.RuleID,
+		Protocol:      p.Protocol,
+		Direction:     p.Direction,
+		VirtualSource: p.VirtualSource,
+		VirtualDest:   p.VirtualDest,
+		RealSource:    p.RealSource,
+		RealDest:      p.RealDest,
+		CreatedAt:     p.CreatedAt.UnixNano(),
+		LastActivity:  p.LastActivity.UnixNano(),
+		Established:   p.Established,
+	}
+}
+
+func (r *ReplicatedSession) persisted() *SessionRecord {
+	return &SessionRecord{
+		SessionID:     r.SessionId,
+		RuleID:        r.RuleId,
+		Protocol:      r.Protocol,
+		Direction:     r.Direction,
+		VirtualSource: r.VirtualSource,
+		VirtualDest:   r.VirtualDest,
+		RealSource:    r.RealSource,
+		RealDest:      r.RealDest,
+		CreatedAt:     time.Unix(0, r.CreatedAt),
+		LastActivity:  time.Unix(0, r.LastActivity),
+		Established:   r.Established,
+	}
+}
+
+// replicaStamp combines the activity and TCP state of a session, so updates are only sent
+// for sessions that changed since they were last replicated
+func replicaStamp(s *NATSession) int64 {
+	stamp := s.counters.lastActivity.Load() << 1
+	if tcpState(s.tcpState.Load()) == tcpStateEstablished {
+		stamp |= 1
+	}
+	return stamp
+}
+
+// replicator streams the handler's sessions to the HA peer and serves the peer's stream.
+// Only sessions the handler translates itself are sent, so sessions a standby holds for
+// its peer are never echoed back.
+type replicator struct {
+	handler  *Handler
+	config   *Replication
+	events   chan *ReplicationEvent // nil unless a peer is configured
+	resync   atomic.Bool            // Set when events were dropped
+	server   *grpc.Server
+	listener net.Listener
+}
+
+func newReplicator(h *Handler, config *Replication) (*replicator, error) {
+	if config.Listen == "" && config.Peer == "" {
+		return nil, errors.New("replication needs a listen or a peer address")
+	}
+	r := &replicator{handler: h, config: config}
+	if config.Peer != "" {
+		if _, _, err := net.SplitHostPort(config.Peer); err != nil {
+			return nil, errors.New("invalid replication peer ", config.Peer).Base(err)
+		}
+		r.events = make(chan *ReplicationEvent, replicationQueueSize)
+	}
+	if config.Listen != "" {
+		listener, err := net.Listen("tcp", config.Listen)
+		if err != nil {
+			return nil, errors.New("failed to listen for replication on ", config.Listen).Base(err)
+		}
+		r.listener = listener
+		r.server = grpc.NewServer()
+		RegisterSessionReplicationServer(r.server, &replicationServer{handler: h, secret: config.Secret})
+		go r.server.Serve(listener)
+	}
+	if r.events != nil {
+		go r.run()
+	}
+	return r, nil
+}
+
+func (r *replicator) syncInterval() time.Duration {
+	if r.config.SyncInterval > 0 {
+		return time.Duration(r.config.SyncInterval) * time.Second
+	}
+	return 10 * time.Second // Default 10 seconds
+}
+
+// enqueue queues an event of a session for the peer without blocking the data path
+func (r *replicator) enqueue(eventType ReplicationEvent_Type, s *NATSession) {
+	if r.events == nil || !persistable(s) {
+		return
+	}
+	event := &ReplicationEvent{Type: eventType}
+	if eventType == ReplicationEvent_DELETE {
+		event.Session = &ReplicatedSession{SessionId: s.SessionID}
+	} else {
+		s.replicaStamp.Store(replicaStamp(s))
+		event.Session = replicatedSession(s)
+	}
+	select {
+	case r.events <- event:
+	default:
+		r.resync.Store(true)
+	}
+}
+
+// run keeps a stream to the peer open until the handler closes
+func (r *replicator) run() {
+	for {
+		err := r.stream()
+		select {
+		case <-r.handler.done:
+			return
+		default:
+		}
+		errors.LogWarningInner(context.Background(), err, "NAT: replication to ", r.config.Peer, " interrupted")
+		select {
+		case <-time.After(replicationRetry):
+		case <-r.handler.done:
+			return
+		}
+	}
+}
+
+// stream opens a stream to the peer, sends a full sync of the sessions and then forwards
+// queued events and periodic activity updates
+func (r *replicator) stream() error {
+	conn, err := grpc.NewClient(r.config.Peer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.handler.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	streamCtx := ctx
+	if r.config.Secret != "" {
+		streamCtx = metadata.AppendToOutgoingContext(ctx, replicationSecretHeader, r.config.Secret)
+	}
+
+	stream, err := NewSessionReplicationClient(conn).Replicate(streamCtx, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	var sequence uint64
+	send := func(eventType ReplicationEvent_Type, session *ReplicatedSession) error {
+		sequence++
+		err := stream.Send(&ReplicationEvent{Sequence: sequence, Type: eventType, Session: session})
+		if err == io.EOF {
+			// The peer ended the stream; its status tells why
+			_, err = stream.CloseAndRecv()
+		}
+		return err
+	}
+
+	// Events queued so far are covered by the full sync
+	r.resync.Store(false)
+	for len(r.events) > 0 {
+		<-r.events
+	}
+	if err := send(ReplicationEvent_SYNC_BEGIN, nil); err != nil {
+		return err
+	}
+	synced := 0
+	r.handler.sessions.Range(func(s *NATSession) bool {
+		if !s.announced.Load() || !persistable(s) {
+			return true
+		}
+		s.replicaStamp.Store(replicaStamp(s))
+		err = send(ReplicationEvent_CREATE, replicatedSession(s))
+		synced++
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := send(ReplicationEvent_SYNC_END, nil); err != nil {
+		return err
+	}
+	errors.LogInfo(ctx, "NAT: replicating ", synced, " sessions to ", r.config.Peer)
+
+	ticker := time.NewTicker(r.syncInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-r.events:
+			if err := send(event.Type, event.Session); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if r.resync.Load() {
+				stream.CloseSend()
+				return errors.New("replication queue overflowed, restarting with a full sync")
+			}
+			if err := r.sendUpdates(send); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sendUpdates sends the sessions whose activity or state changed since they were last replicated
+func (r *replicator) sendUpdates(send func(ReplicationEvent_Type, *ReplicatedSession) error) error {
+	var err error
+	r.handler.sessions.Range(func(s *NATSession) bool {
+		if !s.announced.Load() || !persistable(s) {
+			return true
+		}
+		if stamp := replicaStamp(s); s.replicaStamp.Swap(stamp) != stamp {
+			err = send(ReplicationEvent_UPDATE, replicatedSession(s))
+		}
+		return err == nil
+	})
+	return err
+}
+
+// Close stops serving the peer; the stream to the peer ends with the handler
+func (r *replicator) Close() {
+	if r.server != nil {
+		r.server.Stop()
+	}
+}
+
+// replicateSession queues an event of a session for the HA peer
+func (h *Handler) replicateSession(eventType ReplicationEvent_Type, s *NATSession) {
+	if h.replication != nil {
+		h.replication.enqueue(eventType, s)
+	}
+}
+
+// replicationServer applies the session events of the peer. Its sessions are held like
+// sessions restored from a checkpoint, so a flow that fails over adopts its mapping.
+type replicationServer struct {
+	UnimplementedSessionReplicationServer
+	handler *Handler
+	secret  string
+}
+
+func (s *replicationServer) Replicate(stream SessionReplication_ReplicateServer) error {
+	if s.secret != "" {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		values := md.Get(replicationSecretHeader)
+		if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(s.secret)) != 1 {
+			return status.Error(codes.Unauthenticated, "invalid replication secret")
+		}
+	}
+
+	var applied uint64
+	var synced map[string]bool // Session IDs received by a running full sync
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&ReplicationAck{Sequence: applied})
+		}
+		if err != nil {
+			return err
+		}
+		if event.Sequence != applied+1 {
+			return status.Errorf(codes.DataLoss, "expected replication event %d, got %d", applied+1, event.Sequence)
+		}
+		applied = event.Sequence
+
+		switch event.Type {
+		case ReplicationEvent_SYNC_BEGIN:
+			synced = make(map[string]bool)
+		case ReplicationEvent_SYNC_END:
+			s.handler.dropUnsynced(synced)
+			synced = nil
+		case ReplicationEvent_CREATE, ReplicationEvent_UPDATE:
+			if event.Session == nil {
+				continue
+			}
+			if synced != nil {
+				synced[event.Session.SessionId] = true
+			}
+			if err := s.handler.applyReplicated(event.Session); err != nil {
+				errors.LogDebugInner(stream.Context(), err, "NAT: skipped replicated session ", event.Session.SessionId)
+			}
+		case ReplicationEvent_DELETE:
+			s.handler.removeReplicated(event.GetSession().GetSessionId())
+		}
+	}
+}
+
+// applyReplicated creates or refreshes a session replicated by the peer
+func (h *Handler) applyReplicated(replicated *ReplicatedSession) error {
+	if existing, found := h.sessions.Load(replicated.SessionId); found {
+		if existing.restored.Load() {
+			existing.counters.lastActivity.Store(replicated.LastActivity)
+			if replicated.Established {
+				existing.tcpState.Store(int32(tcpStateEstablished))
+			}
+			h.sessions.Schedule(existing, existing.LastActivity().Add(h.sessionTimeout(existing)))
+		}
+		return nil
+	}
+	if atomic.LoadInt64(&h.activeSessions) >= h.maxSessions {
+		return errors.New("session limit reached")
+	}
+	natSession, err := restoreSession(replicated.persisted())
+	if err != nil {
+		return err
+	}
+	_, err = h.storeRestored(natSession, time.Now())
+	return err
+}
+
+// removeReplicated removes a session the peer removed
+func (h *Handler) removeReplicated(sessionID string) {
+	if s, found := h.sessions.Load(sessionID); found && s.restored.Load() {
+		h.removeSession(sessionID)
+	}
+}
+
+// dropUnsynced removes the held sessions a full sync of the peer did not include
+func (h *Handler) dropUnsynced(synced map[string]bool) {
+	h.sessions.Range(func(s *NATSession) bool {
+		if s.restored.Load() && !synced[s.SessionID] {
+			h.removeSession(s.SessionID)
+		}
+		return true
+	})
+}
+
+// advanceSequence moves the session sequence past that of a session ID from a checkpoint or the
+// peer, so sessions created later never reuse the ID
+func (h *Handler) advanceSequence(sessionID string) {
+	i := strings.LastIndexByte(sessionID, '#')
+	if i < 0 {
+		return
+	}
+	seq, err := strconv.ParseUint(sessionID[i+1:], 10, 64)
+	if err != nil {
+		return
+	}
+	for {
+		current := h.sessionSeq.Load()
+		if current >= seq || h.sessionSeq.CompareAndSwap(current, seq) {
+			return
+		}
+	}
+}
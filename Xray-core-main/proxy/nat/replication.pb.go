@@ -0,0 +1,410 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v6.33.0
+// source: replication.proto
+
+package nat
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ReplicationEvent_Type int32
+
+const (
+	// A session was created; also used for every session of a full sync
+	ReplicationEvent_CREATE ReplicationEvent_Type = 0
+	// Activity or state of a session changed
+	ReplicationEvent_UPDATE ReplicationEvent_Type = 1
+	// A session was removed; only the session ID is set
+	ReplicationEvent_DELETE ReplicationEvent_Type = 2
+	// A full sync starts; sessions not sent before it ends are gone
+	ReplicationEvent_SYNC_BEGIN ReplicationEvent_Type = 3
+	ReplicationEvent_SYNC_END   ReplicationEvent_Type = 4
+)
+
+// Enum value maps for ReplicationEvent_Type.
+var (
+	ReplicationEvent_Type_name = map[int32]string{
+		0: "CREATE",
+		1: "UPDATE",
+		2: "DELETE",
+		3: "SYNC_BEGIN",
+		4: "SYNC_END",
+	}
+	ReplicationEvent_Type_value = map[string]int32{
+		"CREATE":     0,
+		"UPDATE":     1,
+		"DELETE":     2,
+		"SYNC_BEGIN": 3,
+		"SYNC_END":   4,
+	}
+)
+
+func (x ReplicationEvent_Type) Enum() *ReplicationEvent_Type {
+	p := new(ReplicationEvent_Type)
+	*p = x
+	return p
+}
+
+func (x ReplicationEvent_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ReplicationEvent_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_replication_proto_enumTypes[0].Descriptor()
+}
+
+func (ReplicationEvent_Type) Type() protoreflect.EnumType {
+	return &file_replication_proto_enumTypes[0]
+}
+
+func (x ReplicationEvent_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ReplicationEvent_Type.Descriptor instead.
+func (ReplicationEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return file_replication_proto_rawDescGZIP(), []int{1, 0}
+}
+
+// ReplicatedSession is the state of a session as seen by the peer. Endpoints use the
+// string form of destinations and are empty when unknown; times are Unix nanoseconds.
+type ReplicatedSession struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SessionId     string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	RuleId        string                 `protobuf:"bytes,2,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	Protocol      string                 `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Direction     string                 `protobuf:"bytes,4,opt,name=direction,proto3" json:"direction,omitempty"`
+	VirtualSource string                 `protobuf:"bytes,5,opt,name=virtual_source,json=virtualSource,proto3" json:"virtual_source,omitempty"`
+	VirtualDest   string                 `protobuf:"bytes,6,opt,name=virtual_dest,json=virtualDest,proto3" json:"virtual_dest,omitempty"`
+	RealSource    string                 `protobuf:"bytes,7,opt,name=real_source,json=realSource,proto3" json:"real_source,omitempty"`
+	RealDest      string                 `protobuf:"bytes,8,opt,name=real_dest,json=realDest,proto3" json:"real_dest,omitempty"`
+	CreatedAt     int64                  `protobuf:"varint,9,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	LastActivity  int64                  `protobuf:"varint,10,opt,name=last_activity,json=lastActivity,proto3" json:"last_activity,omitempty"`
+	Established   bool                   `protobuf:"varint,11,opt,name=established,proto3" json:"established,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicatedSession) Reset() {
+	*x = ReplicatedSession{}
+	mi := &file_replication_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicatedSession) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicatedSession) ProtoMessage() {}
+
+func (x *ReplicatedSession) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicatedSession.ProtoReflect.Descriptor instead.
+func (*ReplicatedSession) Descriptor() ([]byte, []int) {
+	return file_replication_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ReplicatedSession) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetVirtualSource() string {
+	if x != nil {
+		return x.VirtualSource
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetVirtualDest() string {
+	if x != nil {
+		return x.VirtualDest
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetRealSource() string {
+	if x != nil {
+		return x.RealSource
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetRealDest() string {
+	if x != nil {
+		return x.RealDest
+	}
+	return ""
+}
+
+func (x *ReplicatedSession) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+func (x *ReplicatedSession) GetLastActivity() int64 {
+	if x != nil {
+		return x.LastActivity
+	}
+	return 0
+}
+
+func (x *ReplicatedSession) GetEstablished() bool {
+	if x != nil {
+		return x.Established
+	}
+	return false
+}
+
+type ReplicationEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Position of the event in its stream, starting at 1 without gaps
+	Sequence      uint64                `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Type          ReplicationEvent_Type `protobuf:"varint,2,opt,name=type,proto3,enum=xray.proxy.nat.ReplicationEvent_Type" json:"type,omitempty"`
+	Session       *ReplicatedSession    `protobuf:"bytes,3,opt,name=session,proto3" json:"session,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationEvent) Reset() {
+	*x = ReplicationEvent{}
+	mi := &file_replication_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationEvent) ProtoMessage() {}
+
+func (x *ReplicationEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationEvent.ProtoReflect.Descriptor instead.
+func (*ReplicationEvent) Descriptor() ([]byte, []int) {
+	return file_replication_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReplicationEvent) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *ReplicationEvent) GetType() ReplicationEvent_Type {
+	if x != nil {
+		return x.Type
+	}
+	return ReplicationEvent_CREATE
+}
+
+func (x *ReplicationEvent) GetSession() *ReplicatedSession {
+	if x != nil {
+		return x.Session
+	}
+	return nil
+}
+
+type ReplicationAck struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sequence number of the last applied event
+	Sequence      uint64 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationAck) Reset() {
+	*x = ReplicationAck{}
+	mi := &file_replication_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationAck) ProtoMessage() {}
+
+func (x *ReplicationAck) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationAck.ProtoReflect.Descriptor instead.
+func (*ReplicationAck) Descriptor() ([]byte, []int) {
+	return file_replication_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReplicationAck) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+var File_replication_proto protoreflect.FileDescriptor
+
+const file_replication_proto_rawDesc = "" +
+	"\n" +
+	"\x11replication.proto\x12\x0exray.proxy.nat\"\xf3\x02\n" +
+	"\x11ReplicatedSession\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x17\n" +
+	"\arule_id\x18\x02 \x01(\tR\x06ruleId\x12\x1a\n" +
+	"\bprotocol\x18\x03 \x01(\tR\bprotocol\x12\x1c\n" +
+	"\tdirection\x18\x04 \x01(\tR\tdirection\x12%\n" +
+	"\x0evirtual_source\x18\x05 \x01(\tR\rvirtualSource\x12!\n" +
+	"\fvirtual_dest\x18\x06 \x01(\tR\vvirtualDest\x12\x1f\n" +
+	"\vreal_source\x18\a \x01(\tR\n" +
+	"realSource\x12\x1b\n" +
+	"\treal_dest\x18\b \x01(\tR\brealDest\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\t \x01(\x03R\tcreatedAt\x12#\n" +
+	"\rlast_activity\x18\n" +
+	" \x01(\x03R\flastActivity\x12 \n" +
+	"\vestablished\x18\v \x01(\bR\vestablished\"\xf0\x01\n" +
+	"\x10ReplicationEvent\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x129\n" +
+	"\x04type\x18\x02 \x01(\x0e2%.xray.proxy.nat.ReplicationEvent.TypeR\x04type\x12;\n" +
+	"\asession\x18\x03 \x01(\v2!.xray.proxy.nat.ReplicatedSessionR\asession\"H\n" +
+	"\x04Type\x12\n" +
+	"\n" +
+	"\x06CREATE\x10\x00\x12\n" +
+	"\n" +
+	"\x06UPDATE\x10\x01\x12\n" +
+	"\n" +
+	"\x06DELETE\x10\x02\x12\x0e\n" +
+	"\n" +
+	"SYNC_BEGIN\x10\x03\x12\f\n" +
+	"\bSYNC_END\x10\x04\",\n" +
+	"\x0eReplicationAck\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence2g\n" +
+	"\x12SessionReplication\x12Q\n" +
+	"\tReplicate\x12 .xray.proxy.nat.ReplicationEvent\x1a\x1e.xray.proxy.nat.ReplicationAck\"\x00(\x01B%Z#github.com/xtls/xray-core/proxy/natb\x06proto3"
+
+var (
+	file_replication_proto_rawDescOnce sync.Once
+	file_replication_proto_rawDescData []byte
+)
+
+func file_replication_proto_rawDescGZIP() []byte {
+	file_replication_proto_rawDescOnce.Do(func() {
+		file_replication_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_replication_proto_rawDesc), len(file_replication_proto_rawDesc)))
+	})
+	return file_replication_proto_rawDescData
+}
+
+var file_replication_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_replication_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_replication_proto_goTypes = []any{
+	(ReplicationEvent_Type)(0), // 0: xray.proxy.nat.ReplicationEvent.Type
+	(*ReplicatedSession)(nil),  // 1: xray.proxy.nat.ReplicatedSession
+	(*ReplicationEvent)(nil),   // 2: xray.proxy.nat.ReplicationEvent
+	(*ReplicationAck)(nil),     // 3: xray.proxy.nat.ReplicationAck
+}
+var file_replication_proto_depIdxs = []int32{
+	0, // 0: xray.proxy.nat.ReplicationEvent.type:type_name -> xray.proxy.nat.ReplicationEvent.Type
+	1, // 1: xray.proxy.nat.ReplicationEvent.session:type_name -> xray.proxy.nat.ReplicatedSession
+	2, // 2: xray.proxy.nat.SessionReplication.Replicate:input_type -> xray.proxy.nat.ReplicationEvent
+	3, // 3: xray.proxy.nat.SessionReplication.Replicate:output_type -> xray.proxy.nat.ReplicationAck
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_replication_proto_init() }
+func file_replication_proto_init() {
+	if File_replication_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_replication_proto_rawDesc), len(file_replication_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_replication_proto_goTypes,
+		DependencyIndexes: file_replication_proto_depIdxs,
+		EnumInfos:         file_replication_proto_enumTypes,
+		MessageInfos:      file_replication_proto_msgTypes,
+	}.Build()
+	File_replication_proto = out.File
+	file_replication_proto_goTypes = nil
+	file_replication_proto_depIdxs = nil
+}
@@ -0,0 +1,131 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newReplicatingHandler(t *testing.T, replication *Replication) *Handler {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:              "test-site",
+		PortBlockAllocation: &PortBlockAllocation{PublicAddress: "203.0.113.1", BlockSize: 512},
+		Replication:         replication,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return handler
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal("Timed out waiting for ", what)
+}
+
+func TestSessionReplication(t *testing.T) {
+	standby := newReplicatingHandler(t, &Replication{Listen: "127.0.0.1:0", Secret: "shared"})
+	active := New()
+	defer active.Close()
+	if err := active.Init(&Config{
+		SiteId:              "test-site",
+		PortBlockAllocation: &PortBlockAllocation{PublicAddress: "203.0.113.1", BlockSize: 512},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.UDPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	dest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53)
+	newSession := func(port xnet.Port) *NATSession {
+		natSession := active.createNATSession(xnet.UDPDestination(source.Address, port), dest, dest, "outbound", classInteractive)
+		if err := active.allocateSourcePort(context.Background(), natSession); err != nil {
+			t.Fatal(err)
+		}
+		active.announceSession(natSession)
+		return natSession
+	}
+	replica := func(sessionID string) *NATSession {
+		natSession, _ := standby.sessions.Load(sessionID)
+		return natSession
+	}
+
+	// Sessions that exist when the peers connect arrive with the full sync
+	existing := newSession(40000)
+	replication, err := newReplicator(active, &Replication{Peer: standby.replication.listener.Addr().String(), Secret: "shared", SyncInterval: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active.replication = replication
+	waitFor(t, "the full sync", func() bool { return replica(existing.SessionID) != nil })
+	if held := replica(existing.SessionID); !held.restored.Load() || held.RealSource.Port != existing.RealSource.Port {
+		t.Errorf("Unexpected replicated session %+v", held)
+	}
+	if blocks := standby.PortBlocks(net.ParseIP("100.64.0.1")); len(blocks) != 1 {
+		t.Errorf("Expected the standby to reserve the port block, got %v", blocks)
+	}
+
+	created := newSession(40001)
+	waitFor(t, "the created session", func() bool { return replica(created.SessionID) != nil })
+
+	later := time.Now().Add(time.Second)
+	created.counters.lastActivity.Store(later.UnixNano())
+	waitFor(t, "the activity update", func() bool { return replica(created.SessionID).LastActivity().Equal(later) })
+
+	active.removeSession(existing.SessionID)
+	waitFor(t, "the removal", func() bool { return replica(existing.SessionID) == nil })
+
+	// The failed over flow keeps its mapping
+	resumed := standby.createNATSession(created.VirtualSource, dest, dest, "outbound", classInteractive)
+	if !standby.adoptRestoredSession(context.Background(), resumed) || resumed.RealSource.Port != created.RealSource.Port {
+		t.Errorf("Expected the standby to take the mapping over, got %v", resumed.RealSource)
+	}
+}
+
+func TestReplicationStreamChecks(t *testing.T) {
+	standby := newReplicatingHandler(t, &Replication{Listen: "127.0.0.1:0", Secret: "shared"})
+	conn, err := grpc.NewClient(standby.replication.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewSessionReplicationClient(conn)
+
+	replicate := func(ctx context.Context, events ...*ReplicationEvent) error {
+		stream, err := client.Replicate(ctx)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			stream.Send(event)
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	if err := replicate(context.Background(), &ReplicationEvent{Sequence: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected streams without the secret to be rejected, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), replicationSecretHeader, "shared")
+	err = replicate(ctx,
+		&ReplicationEvent{Sequence: 1, Type: ReplicationEvent_SYNC_BEGIN},
+		&ReplicationEvent{Sequence: 3, Type: ReplicationEvent_SYNC_END},
+	)
+	if status.Code(err) != codes.DataLoss {
+		t.Errorf("Expected a sequence gap to end the stream, got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func init() {
+	common.Must(RegisterALG(algRTSP, rtspGateway{}))
+}
+
+// rtspGateway is the RTSP gateway of control connections
+type rtspGateway struct{}
+
+// Match implements ALG
+func (rtspGateway) Match(flow *ALGFlow) bool {
+	if !flow.Stream() {
+		return false
+	}
+	flow.State = newRTSPALG(flow.handler, flow.Context, flow.Session, flow.Conn)
+	return true
+}
+
+// Frame implements ALGFramer
+func (rtspGateway) Frame(data []byte) int {
+	return rtspMessageLength(data)
+}
+
+// RewriteOutbound implements ALG
+func (rtspGateway) RewriteOutbound(flow *ALGFlow, message []byte) []byte {
+	return flow.State.(*rtspALG).rewriteRequest(message)
+}
+
+// RewriteInbound implements ALG
+func (rtspGateway) RewriteInbound(flow *ALGFlow, message []byte) []byte {
+	return flow.State.(*rtspALG).rewriteResponse(message)
+}
+
+// ExpectSessions implements ALG
+func (rtspGateway) ExpectSessions(flow *ALGFlow, message []byte, outbound bool) []ALGExpectation {
+	if outbound {
+		return nil
+	}
+	return flow.State.(*rtspALG).expectServerPorts(message)
+}
+
+// rtspALG rewrites the RTSP signalling (RFC 2326) of a control connection. The client_port of
+// the transports a client sets up is replaced by pinholes on the local address of the flow,
+// which relay the media of the server to the client. The server_port of the transports the
+// server answers with is expected on the virtual destination. URLs and addresses of the server
+// in its replies are rewritten to the virtual destination.
+type rtspALG struct {
+	handler *Handler
+	ctx     context.Context
+	session *NATSession
+	localIP net.IP // Address of the flow towards the real destination
+
+	sync.Mutex
+	clientPorts map[string]string // Announced pinhole ports -> client ports
+}
+
+func newRTSPALG(h *Handler, ctx context.Context, natSession *NATSession, conn stat.Connection) *rtspALG {
+	return &rtspALG{
+		handler:     h,
+		ctx:         ctx,
+		session:     natSession,
+		localIP:     localAddressTowards(conn, natSession.RealDest.Address),
+		clientPorts: make(map[string]string),
+	}
+}
+
+func (a *rtspALG) rewriteRequest(message []byte) []byte {
+	return a.rewriteMessage(message, true)
+}
+
+func (a *rtspALG) rewriteResponse(message []byte) []byte {
+	return a.rewriteMessage(message, false)
+}
+
+// rewriteMessage rewrites the request line and headers of a message. uplink messages come from
+// the client on the virtual side. Bodies and interleaved data pass unchanged.
+func (a *rtspALG) rewriteMessage(message []byte, uplink bool) []byte {
+	if len(message) > 0 && message[0] == '$' {
+		return message
+	}
+	head, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return message
+	}
+	virtualAddr, realAddr := a.session.VirtualDest.Address, a.session.RealDest.Address
+	if !virtualAddr.Family().IsIP() || !realAddr.Family().IsIP() {
+		return message
+	}
+	virtualHost, realHost := sipHost(virtualAddr.IP()), sipHost(realAddr.IP())
+
+	lines := strings.Split(string(head), "\r\n")
+	if uplink {
+		// The request URL addresses the virtual destination
+		lines[0] = replaceHost(lines[0], virtualHost, realHost, 0)
+	}
+	for i := 1; i < len(lines); i++ {
+		name, value, ok := strings.Cut(lines[i], ":")
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "transport") {
+			value = a.rewriteTransports(value, uplink)
+		}
+		if !uplink {
+			// Content-Base, RTP-Info and the source of transports name the server
+			value = replaceHost(value, realHost, virtualHost, 0)
+		}
+		lines[i] = name + ":" + value
+	}
+	return append([]byte(strings.Join(lines, "\r\n")+"\r\n\r\n"), body...)
+}
+
+// rewriteTransports rewrites the ports of the comma separated transports of a Transport header
+func (a *rtspALG) rewriteTransports(value string, uplink bool) string {
+	transports := strings.Split(value, ",")
+	for i, transport := range transports {
+		params := strings.Split(transport, ";")
+		if lower := strings.ToLower(strings.TrimSpace(params[0])); !strings.HasPrefix(lower, "rtp/avp") || strings.HasSuffix(lower, "/tcp") {
+			// Interleaved transports ride the control connection
+			continue
+		}
+		for j, param := range params {
+			key, ports, ok := strings.Cut(param, "=")
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "client_port":
+				if uplink {
+					ports = a.relayClientPorts(ports)
+				} else {
+					a.Lock()
+					if clientPorts, found := a.clientPorts[ports]; found {
+						ports = clientPorts
+					}
+					a.Unlock()
+				}
+			case "destination":
+				source := a.session.VirtualSource.Address
+				if source == nil || !source.Family().IsIP() || a.localIP == nil {
+					break
+				}
+				if uplink && strings.TrimSpace(ports) == addressString(source) {
+					ports = a.localIP.String()
+				} else if !uplink && strings.TrimSpace(ports) == a.localIP.String() {
+					ports = addressString(source)
+				}
+			}
+			params[j] = key + "=" + ports
+		}
+		transports[i] = strings.Join(params, ";")
+	}
+	return strings.Join(transports, ",")
+}
+
+// parseRTSPPorts parses the port or port-port range of a transport parameter
+func parseRTSPPorts(ports string) (xnet.Port, bool, bool) {
+	first, second, isRange := strings.Cut(strings.TrimSpace(ports), "-")
+	port, err := xnet.PortFromString(first)
+	if err != nil || port == 0 {
+		return 0, false, false
+	}
+	if isRange {
+		if last, err := xnet.PortFromString(second); err != nil || last != port+1 {
+			return 0, false, false
+		}
+	}
+	return port, isRange, true
+}
+
+// relayClientPorts opens RTP/RTCP pinholes relaying to the client ports and returns the ports
+// to announce to the server instead
+func (a *rtspALG) relayClientPorts(ports string) string {
+	port, isRange, ok := parseRTSPPorts(ports)
+	source := a.session.VirtualSource.Address
+	if !ok || a.localIP == nil || source == nil || !source.Family().IsIP() {
+		return ports
+	}
+	target := xnet.UDPDestination(source, port)
+	pinhole, err := a.handler.openPinholePair(a.ctx, a.localIP, target, a.session.RuleID)
+	if err != nil {
+		errors.LogWarningInner(a.ctx, err, "NAT: RTSP ALG failed to open media pinholes for ", target)
+		return ports
+	}
+	announced := strconv.Itoa(pinhole.Port)
+	if isRange {
+		announced += "-" + strconv.Itoa(pinhole.Port+1)
+	}
+	a.Lock()
+	a.clientPorts[announced] = ports
+	a.Unlock()
+	return announced
+}
+
+// expectServerPorts expects the datagrams of the client to the server ports of the transports
+// a reply of the server announces, such as RTCP receiver reports, on the virtual destination
+func (a *rtspALG) expectServerPorts(message []byte) []ALGExpectation {
+	if len(message) > 0 && message[0] == '$' {
+		return nil
+	}
+	head, _, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return nil
+	}
+	var expectations []ALGExpectation
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "transport") {
+			continue
+		}
+		for _, transport := range strings.Split(value, ",") {
+			for _, param := range strings.Split(transport, ";") {
+				key, ports, ok := strings.Cut(param, "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "server_port") {
+					continue
+				}
+				port, isRange, ok := parseRTSPPorts(ports)
+				if !ok {
+					continue
+				}
+				expected := []xnet.Port{port}
+				if isRange {
+					expected = append(expected, port+1)
+				}
+				for _, p := range expected {
+					expectations = append(expectations, ALGExpectation{
+						VirtualDest: xnet.UDPDestination(a.session.VirtualDest.Address, p),
+						RealDest:    xnet.UDPDestination(a.session.RealDest.Address, p),
+					})
+				}
+			}
+		}
+	}
+	return expectations
+}
+
+// rtspMessageLength frames the messages of an RTSP stream: interleaved binary data by its
+// length prefix, requests and replies by their Content-Length
+func rtspMessageLength(data []byte) int {
+	if data[0] != '$' {
+		return sipMessageLength(data)
+	}
+	if len(data) < 4 {
+		return -1
+	}
+	return 4 + int(binary.BigEndian.Uint16(data[2:]))
+}
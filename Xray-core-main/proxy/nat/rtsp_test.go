@@ -0,0 +1,76 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func newRTSPTestALG(t *testing.T) *rtspALG {
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	natSession := handler.createNATSession(
+		xnet.TCPDestination(xnet.ParseAddress("127.0.0.1"), 40000),
+		xnet.TCPDestination(xnet.ParseAddress("240.2.2.24"), 554),
+		xnet.TCPDestination(xnet.ParseAddress("192.168.2.24"), 554),
+		"outbound", classInteractive)
+	natSession.RuleID = "camera"
+	return &rtspALG{handler: handler, ctx: context.Background(), session: natSession, localIP: net.ParseIP("127.0.0.1"), clientPorts: make(map[string]string)}
+}
+
+func TestRTSPSetup(t *testing.T) {
+	alg := newRTSPTestALG(t)
+	request := "SETUP rtsp://240.2.2.24/stream/track1 RTSP/1.0\r\nCSeq: 3\r\n" +
+		"Transport: RTP/AVP;unicast;client_port=50000-50001\r\n\r\n"
+	rewritten := string(alg.rewriteRequest([]byte(request)))
+	if !strings.HasPrefix(rewritten, "SETUP rtsp://192.168.2.24/stream/track1 RTSP/1.0\r\n") {
+		t.Errorf("Expected the request URL to address the real destination:\n%s", rewritten)
+	}
+	_, transport, _ := strings.Cut(rewritten, "client_port=")
+	announced, _, _ := strings.Cut(transport, "\r\n")
+	if announced == "50000-50001" {
+		t.Fatalf("Expected the client ports to be replaced by pinholes:\n%s", rewritten)
+	}
+	port, isRange, ok := parseRTSPPorts(announced)
+	if !ok || !isRange || port%2 != 0 {
+		t.Errorf("Expected an RTP/RTCP pinhole pair, got %q", announced)
+	}
+
+	reply := "RTSP/1.0 200 OK\r\nCSeq: 3\r\nSession: 12345678\r\n" +
+		"Transport: RTP/AVP;unicast;client_port=" + announced + ";server_port=6970-6971;source=192.168.2.24\r\n\r\n"
+	rewritten = string(alg.rewriteResponse([]byte(reply)))
+	if !strings.Contains(rewritten, "Transport: RTP/AVP;unicast;client_port=50000-50001;server_port=6970-6971;source=240.2.2.24\r\n") {
+		t.Errorf("Expected the reply to announce the client ports and the virtual source:\n%s", rewritten)
+	}
+	for _, expectation := range alg.expectServerPorts([]byte(reply)) {
+		alg.handler.expect(alg.session, expectation.VirtualDest, expectation.RealDest)
+	}
+	for _, p := range []xnet.Port{6970, 6971} {
+		value, ok := alg.handler.expectations.Load(xnet.UDPDestination(xnet.ParseAddress("240.2.2.24"), p).String())
+		if !ok || value.(*NATSession).RealDest.String() != "udp:192.168.2.24:"+p.String() {
+			t.Errorf("Expected the server port %d to be expected", p)
+		}
+	}
+}
+
+func TestRTSPInterleaved(t *testing.T) {
+	alg := newRTSPTestALG(t)
+	request := "SETUP rtsp://240.2.2.24/stream RTSP/1.0\r\nTransport: RTP/AVP/TCP;interleaved=0-1\r\n\r\n"
+	if rewritten := string(alg.rewriteRequest([]byte(request))); !strings.Contains(rewritten, "Transport: RTP/AVP/TCP;interleaved=0-1\r\n") {
+		t.Errorf("Expected interleaved transports to be left alone:\n%s", rewritten)
+	}
+
+	data := []byte{'$', 0, 0, 3, 'r', 't', 'p'}
+	if rtspMessageLength(append(data, "RTSP/1.0 200 OK\r\n"...)) != len(data) {
+		t.Error("Expected interleaved data to be framed by its length prefix")
+	}
+	if string(alg.rewriteResponse(data)) != string(data) {
+		t.Error("Expected interleaved data to pass unchanged")
+	}
+}
@@ -0,0 +1,45 @@
+package nat
+
+import (
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// ErrRuleSessionLimit is the cause of errors refusing a flow because its rule or virtual range
+// holds its maxSessions, so a busy mapping cannot take the session table from the others
+var ErrRuleSessionLimit = errors.New("NAT rule session limit reached")
+
+// ruleQuota counts the concurrent sessions of a rule with a maxSessions, whatever their protocol
+type ruleQuota struct {
+	sessions atomic.Int64
+}
+
+// release returns a session acquired by admitRule
+func (q *ruleQuota) release() {
+	if q != nil {
+		q.sessions.Add(-1)
+	}
+}
+
+// admitRule counts a new flow of rule against its maxSessions and returns the quota to release
+// with the session, nil when the rule has no cap. Over the cap the flow is refused before it
+// may evict other sessions, and the refusal is counted against the rule.
+func (h *Handler) admitRule(rule *NATRule, network xnet.Network) (*ruleQuota, error) {
+	if rule.MaxSessions == 0 {
+		return nil, nil
+	}
+	value, _ := h.ruleQuotas.LoadOrStore(rule.RuleId, new(ruleQuota))
+	quota := value.(*ruleQuota)
+	for {
+		sessions := quota.sessions.Load()
+		if sessions >= int64(rule.MaxSessions) {
+			h.ruleMetricsOf(rule.RuleId, network).capRefused.Add(1)
+			return nil, errors.New("rule ", rule.RuleId, " holds its maximum of ", rule.MaxSessions, " sessions").Base(ErrRuleSessionLimit)
+		}
+		if quota.sessions.CompareAndSwap(sessions, sessions+1) {
+			return quota, nil
+		}
+	}
+}
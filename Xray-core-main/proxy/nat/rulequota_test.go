@@ -0,0 +1,106 @@
+package nat
+
+import (
+	"context"
+	goerrors "errors"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestRuleMaxSessions(t *testing.T) {
+	listener := listenTCP(t)
+	port := xnet.Port(listener.Addr().(*net.TCPAddr).Port)
+	backup := &NATRule{RuleId: "backup", VirtualDestination: "240.2.2.30", RealDestination: "127.0.0.1", MaxSessions: 1}
+	web := &NATRule{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "127.0.0.1"}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{backup, web}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	flow := func(rule *NATRule, sourcePort xnet.Port) (*pipe.Writer, chan error) {
+		source := xnet.TCPDestination(xnet.ParseAddress("100.64.0.1"), sourcePort)
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+		destination := xnet.TCPDestination(xnet.ParseAddress(rule.VirtualDestination), port)
+		uplinkReader, uplinkWriter := pipe.New()
+		_, downlinkWriter := pipe.New()
+		done := make(chan error, 1)
+		go func() {
+			done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, tcpTestDialer{}, rule)
+		}()
+		return uplinkWriter, done
+	}
+
+	first, firstDone := flow(backup, 40000)
+	defer first.Close()
+	if server, err := listener.Accept(); err != nil {
+		t.Fatal(err)
+	} else {
+		defer server.Close()
+	}
+
+	// The rule holds its maximum, other rules are not affected
+	if _, done := flow(backup, 40001); !goerrors.Is(<-done, ErrRuleSessionLimit) {
+		t.Error("Expected the second flow of the rule to be refused")
+	}
+	other, otherDone := flow(web, 40002)
+	if server, err := listener.Accept(); err != nil {
+		t.Fatal(err)
+	} else {
+		server.Close()
+	}
+	other.Close()
+	<-otherDone
+
+	for _, stats := range handler.RuleStats() {
+		if expected := map[string]int64{"backup": 1, "web": 0}[stats.RuleID]; stats.CapRefused != expected {
+			t.Errorf("Expected %d refusals of rule %s, got %d", expected, stats.RuleID, stats.CapRefused)
+		}
+	}
+
+	// The session ending frees its place
+	first.Close()
+	<-firstDone
+	quota, err := handler.admitRule(backup, xnet.Network_TCP)
+	if err != nil {
+		t.Fatalf("Expected room for a new flow once the session ended: %v", err)
+	}
+	quota.release()
+}
+
+func TestVirtualRangeMaxSessions(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", MaxSessions: 2}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress("240.2.2.9"), 443))
+	if !ok {
+		t.Fatal("Expected the range to translate the destination")
+	}
+	var quotas []*ruleQuota
+	for range 2 {
+		quota, err := handler.admitRule(rule, xnet.Network_TCP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		quotas = append(quotas, quota)
+	}
+	// Every address of the range shares its cap
+	other, _ := handler.shouldApplyNAT(context.Background(), xnet.UDPDestination(xnet.ParseAddress("240.2.2.10"), 53))
+	if _, err := handler.admitRule(other, xnet.Network_UDP); !goerrors.Is(err, ErrRuleSessionLimit) {
+		t.Errorf("Expected the range to be full, got %v", err)
+	}
+	quotas[0].release()
+	if _, err := handler.admitRule(other, xnet.Network_UDP); err != nil {
+		t.Errorf("Expected room after a release, got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package nat
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/xtls/xray-core/common/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// rulesFileSettle is how long the rules file is left to settle after a change is seen, so a
+// file written in several steps is reloaded once
+const rulesFileSettle = 100 * time.Millisecond
+
+// rulesFilePollInterval is how often the rules file is checked for changes when its directory
+// cannot be watched
+const rulesFilePollInterval = 2 * time.Second
+
+// RulesDecoder parses the content of a rules file in the given format, "json", "yaml" or "toml"
+type RulesDecoder func(data []byte, format string) ([]*NATRule, error)
+
+var rulesDecoder RulesDecoder
+
+// RegisterRulesDecoder sets the decoder of rules files. The JSON config loader registers it,
+// as it owns the JSON form of rules.
+func RegisterRulesDecoder(decoder RulesDecoder) {
+	rulesDecoder = decoder
+}
+
+// rulesFileStamp identifies a version of the rules file by a hash of its content, so edits
+// keeping its size within the resolution of modification times are not missed
+type rulesFileStamp [sha256.Size]byte
+
+func stampRulesFile(path string) (rulesFileStamp, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rulesFileStamp{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// LoadRulesFile parses a rules file through the registered decoder, in the format RulesFormat
+// tells
+func LoadRulesFile(path string) ([]*NATRule, error) {
+	if rulesDecoder == nil {
+		return nil, errors.New("no decoder for NAT rules files is registered")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read NAT rules file ", path).Base(err)
+	}
+	rules, err := rulesDecoder(data, RulesFormat(path))
+	if err != nil {
+		return nil, errors.New("failed to parse NAT rules file ", path).Base(err)
+	}
+	return rules, nil
+}
+
+// RulesFormat returns the format of a rules file from its extension: "yaml" for .yaml and
+// .yml, "toml" for .toml and "json" otherwise
+func RulesFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
+
+// activeRules returns the rules matched against new flows: the configured rules followed by those of the rules
+// file or the controller, then those added through the API
+func (h *Handler) activeRules() []*NATRule {
+	if rules := h.rules.Load(); rules != nil {
+		return *rules
+	}
+	if h.config == nil {
+		return nil
+	}
+	return h.config.Rules
+}
+
+// ruleKey identifies a rule across reloads
+func ruleKey(rule *NATRule) string {
+	if rule.RuleId != "" {
+		return rule.RuleId
+	}
+	return rule.VirtualDestination
+}
+
+// reloadRules loads the rules file and swaps the active rules
+func (h *Handler) reloadRules() error {
+	fileRules, err := LoadRulesFile(h.config.RulesFile)
+	if err != nil {
+		return err
+	}
+	h.applyRules(fileRules, h.config.RulesFile)
+	return nil
+}
+
+// applyRules swaps the rules following the configured ones for those loaded from source. Existing sessions
+// keep the rule they were created with; rules that did not change keep their identity, and so their pools,
+// port cursors and deadlines. Rules that expired stay out while they are loaded unchanged.
+func (h *Handler) applyRules(loaded []*NATRule, source string) {
+	h.expiry.Lock()
+	defer h.expiry.Unlock()
+	now := time.Now()
+
+	previous := make(map[string]*NATRule)
+	active := h.activeRules()
+	for _, rule := range active[len(h.config.Rules) : len(active)-len(h.addedRules)] {
+		previous[ruleKey(rule)] = rule
+	}
+
+	rules := make([]*NATRule, 0, len(h.config.Rules)+len(loaded)+len(h.addedRules))
+	rules = append(rules, h.config.Rules...)
+	var added, removed, changed, fieldChanges []string
+	var stale []*NATRule
+	var changes []AuditRuleChange
+	byKey := make(map[string][]*NATRule, len(loaded))
+	for _, rule := range loaded {
+		key := ruleKey(rule)
+		byKey[key] = append(byKey[key], rule)
+		if h.expiry.stillExpired(key, rule, now) {
+			continue
+		}
+		old, found := previous[key]
+		switch {
+		case !found:
+			added = append(added, key)
+			changes = append(changes, AuditRuleChange{Rule: key, After: auditedRule(rule)})
+		case proto.Equal(old, rule):
+			rule = old
+		default:
+			changed = append(changed, key)
+			fieldChanges = append(fieldChanges, "rule "+key+": "+strings.Join(diffRule(old, rule), ", "))
+			stale = append(stale, old)
+			changes = append(changes, AuditRuleChange{Rule: key, Before: auditedRule(old), After: auditedRule(rule)})
+		}
+		delete(previous, key)
+		rules = append(rules, rule)
+	}
+	rules = append(rules, h.addedRules...)
+	for key, rule := range previous {
+		removed = append(removed, key)
+		stale = append(stale, rule)
+		changes = append(changes, AuditRuleChange{Rule: key, Before: auditedRule(rule)})
+	}
+
+	h.compilePatterns(rules[len(h.config.Rules):])
+	h.parsePorts(rules[len(h.config.Rules):])
+	h.rules.Store(&rules)
+	for _, rule := range stale {
+		h.forgetRule(rule)
+	}
+	h.expiry.track(rules[len(h.config.Rules):], byKey, now)
+	h.expiry.schedule(now, h.expireRules)
+	if len(added)+len(removed)+len(changed) > 0 {
+		errors.LogInfo(context.Background(), "reloaded NAT rules from ", source,
+			": added ", added, ", removed ", removed, ", changed ", changed)
+		for _, change := range fieldChanges {
+			errors.LogInfo(context.Background(), "reloaded NAT ", change)
+		}
+		h.auditRulesReload(source, changes)
+		h.syncOffload()
+		if h.distribution != nil {
+			h.distribution.publish()
+		}
+	}
+}
+
+// forgetRule drops the per-rule state of a rule that is no longer active
+func (h *Handler) forgetRule(rule *NATRule) {
+	h.pools.Delete(rule)
+	h.conditions.Delete(rule)
+	h.ports.Delete(rule)
+	if rule.PortMapping != nil {
+		h.portMappings.Delete(rule.PortMapping)
+	}
+}
+
+// watchRulesFile reloads the rules file whenever its content changes. The directory of the file
+// is watched rather than the file, as editors and config managers replace files by renaming
+// another over them, or swap the symlink of a directory holding them; every change in the
+// directory has the content checked once it settles. Without a watch the file is polled.
+func (h *Handler) watchRulesFile(stamp rulesFileStamp) {
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(h.config.RulesFile)); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: cannot watch rules file ", h.config.RulesFile, ", polling it instead")
+		h.pollRulesFile(stamp)
+		return
+	}
+	defer watcher.Close()
+
+	// Catch the changes made before the watch was set
+	stamp = h.reloadChangedRules(stamp)
+	var settled <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op != fsnotify.Chmod {
+				settled = time.After(rulesFileSettle)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been lost, so check the content anyway
+			errors.LogWarningInner(context.Background(), err, "NAT: error watching rules file ", h.config.RulesFile)
+			settled = time.After(rulesFileSettle)
+		case <-settled:
+			settled = nil
+			stamp = h.reloadChangedRules(stamp)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// pollRulesFile checks the content of the rules file every rulesFilePollInterval
+func (h *Handler) pollRulesFile(stamp rulesFileStamp) {
+	ticker := time.NewTicker(rulesFilePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stamp = h.reloadChangedRules(stamp)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// reloadChangedRules reloads the rules file when its content no longer matches stamp, and
+// returns the stamp of the content seen
+func (h *Handler) reloadChangedRules(stamp rulesFileStamp) rulesFileStamp {
+	current, err := stampRulesFile(h.config.RulesFile)
+	if err != nil || current == stamp {
+		return stamp
+	}
+	if err := h.reloadRules(); err != nil {
+		errors.LogWarningInner(context.Background(), err, "keeping the previous NAT rules")
+	}
+	return current
+}
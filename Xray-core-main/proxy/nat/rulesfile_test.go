@@ -0,0 +1,189 @@
+package nat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// lineRulesDecoder stands for the JSON config loader: one "ruleId virtual real" rule per line
+func lineRulesDecoder(data []byte, format string) ([]*NATRule, error) {
+	var rules []*NATRule
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			rules = append(rules, &NATRule{RuleId: fields[0], VirtualDestination: fields[1], RealDestination: fields[2]})
+		}
+	}
+	return rules, nil
+}
+
+func TestRulesFileReload(t *testing.T) {
+	previous := rulesDecoder
+	RegisterRulesDecoder(lineRulesDecoder)
+	defer RegisterRulesDecoder(previous)
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte("db 240.2.2.30 192.168.1.30\nweb 240.2.2.20 192.168.1.20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:    "test-site",
+		RulesFile: path,
+		Rules:     []*NATRule{{RuleId: "static", VirtualDestination: "240.2.2.10", RealDestination: "192.168.1.10"}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	match := func(address string) *NATRule {
+		rule, _ := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(address), 80))
+		return rule
+	}
+	if rule := match("240.2.2.10"); rule == nil || rule.RuleId != "static" {
+		t.Errorf("Expected the configured rule to match, got %v", rule)
+	}
+	db := match("240.2.2.30")
+	if db == nil || db.RuleId != "db" {
+		t.Fatalf("Expected the file rule db to match, got %v", db)
+	}
+
+	if err := os.WriteFile(path, []byte("db 240.2.2.30 192.168.1.30\nweb 240.2.2.20 192.168.1.21\nmail 240.2.2.25 192.168.1.25\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rule := match("240.2.2.20"); rule == nil || rule.RealDestination != "192.168.1.21" {
+		t.Errorf("Expected the changed web rule, got %v", rule)
+	}
+	if rule := match("240.2.2.25"); rule == nil || rule.RuleId != "mail" {
+		t.Errorf("Expected the added mail rule, got %v", rule)
+	}
+	if rule := match("240.2.2.30"); rule != db {
+		t.Error("Unchanged rules must keep their identity across reloads")
+	}
+
+	if err := os.WriteFile(path, []byte("db 240.2.2.30 192.168.1.30\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	if rule := match("240.2.2.25"); rule != nil {
+		t.Errorf("Expected the removed mail rule to stop matching, got %v", rule)
+	}
+	if len(handler.activeRules()) != 2 {
+		t.Errorf("Expected the configured rule and db, got %v", handler.activeRules())
+	}
+}
+
+func TestRulesFileWatch(t *testing.T) {
+	previous := rulesDecoder
+	RegisterRulesDecoder(lineRulesDecoder)
+	defer RegisterRulesDecoder(previous)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(path, []byte("web 240.2.2.20 192.168.1.20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", RulesFile: path}, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(real string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if rules := handler.activeRules(); len(rules) == 1 && rules[0].RealDestination == real {
+				return
+			}
+		}
+		t.Fatalf("Expected the rules file to be reloaded with %s, got %v", real, handler.activeRules())
+	}
+
+	// Edits of the same size within the same second
+	for _, real := range []string{"192.168.1.21", "192.168.1.22"} {
+		if err := os.WriteFile(path, []byte("web 240.2.2.20 "+real+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor("192.168.1.22")
+
+	// A file renamed over the rules file
+	staged := filepath.Join(dir, "rules.json.tmp")
+	if err := os.WriteFile(staged, []byte("web 240.2.2.20 192.168.1.23\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(staged, path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("192.168.1.23")
+
+	// The content tells a change its size and modification time do not
+	stamp, err := stampRulesFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("web 240.2.2.20 192.168.1.24\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if handler.reloadChangedRules(stamp) == stamp {
+		t.Error("Expected the changed content to be reloaded")
+	}
+	waitFor("192.168.1.24")
+}
+
+func TestRulesFileReloadKeepsRulesOnError(t *testing.T) {
+	previous := rulesDecoder
+	RegisterRulesDecoder(lineRulesDecoder)
+	defer RegisterRulesDecoder(previous)
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte("web 240.2.2.20 192.168.1.20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", RulesFile: path}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(path)
+	if err := handler.reloadRules(); err == nil {
+		t.Error("Expected an error for a missing rules file")
+	}
+	if rules := handler.activeRules(); len(rules) != 1 || rules[0].RuleId != "web" {
+		t.Errorf("Expected the previous rules to stay active, got %v", rules)
+	}
+}
+
+func TestRulesFormat(t *testing.T) {
+	for path, format := range map[string]string{
+		"rules.json":            "json",
+		"/etc/xray/rules.YAML":  "yaml",
+		"rules.yml":             "yaml",
+		"rules/site-b.toml":     "toml",
+		"https://example/rules": "json",
+	} {
+		if got := RulesFormat(path); got != format {
+			t.Errorf("Expected format %s for %s, got %s", format, path, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package nat
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// schedule is a compiled Schedule; times are minutes since midnight
+type schedule struct {
+	days       [7]bool
+	start, end int
+	location   *time.Location
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New("invalid time ", s, ", expected HH:MM").Base(err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func newSchedule(config *Schedule) (*schedule, error) {
+	s := &schedule{location: time.Local}
+	if len(config.Days) == 0 {
+		for i := range s.days {
+			s.days[i] = true
+		}
+	}
+	for _, day := range config.Days {
+		weekday, found := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !found {
+			return nil, errors.New("invalid day ", day)
+		}
+		s.days[weekday] = true
+	}
+
+	if config.Start != "" || config.End != "" {
+		var err error
+		if s.start, err = parseClock(config.Start); err != nil {
+			return nil, err
+		}
+		if s.end, err = parseClock(config.End); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, errors.New("invalid timezone ", config.Timezone).Base(err)
+		}
+		s.location = location
+	}
+	return s, nil
+}
+
+// ValidateSchedule checks the days, times and time zone of a rule schedule
+func ValidateSchedule(config *Schedule) error {
+	_, err := newSchedule(config)
+	return err
+}
+
+// active reports whether the schedule's window is open at now. A window that closes on the
+// next day belongs to the day it opens on.
+func (s *schedule) active(now time.Time) bool {
+	now = now.In(s.location)
+	minute := now.Hour()*60 + now.Minute()
+	today := now.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case s.start == s.end:
+		return s.days[today]
+	case s.start < s.end:
+		return s.days[today] && minute >= s.start && minute < s.end
+	default:
+		return (s.days[today] && minute >= s.start) || (s.days[yesterday] && minute < s.end)
+	}
+}
@@ -0,0 +1,92 @@
+package nat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	at := func(s *schedule, value string) bool {
+		now, err := time.ParseInLocation("2006-01-02 15:04", value, s.location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.active(now)
+	}
+
+	office, err := newSchedule(&Schedule{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00", Timezone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-12 is a Monday
+	for value, expected := range map[string]bool{
+		"2026-10-12 08:00": true,
+		"2026-10-12 17:59": true,
+		"2026-10-12 18:00": false,
+		"2026-10-12 07:59": false,
+		"2026-10-17 12:00": false, // Saturday
+	} {
+		if at(office, value) != expected {
+			t.Errorf("Office hours at %s: expected active=%v", value, expected)
+		}
+	}
+
+	// A window closing after midnight belongs to the day it opens on
+	night, err := newSchedule(&Schedule{Days: []string{"fri"}, Start: "22:00", End: "02:00", Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for value, expected := range map[string]bool{
+		"2026-10-16 23:00": true,  // Friday night
+		"2026-10-17 01:30": true,  // Saturday morning, still Friday's window
+		"2026-10-17 23:00": false, // Saturday night
+		"2026-10-16 01:30": false, // Friday morning, Thursday's window is not scheduled
+	} {
+		if at(night, value) != expected {
+			t.Errorf("Night window at %s: expected active=%v", value, expected)
+		}
+	}
+
+	allDay, err := newSchedule(&Schedule{Days: []string{"sun"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at(allDay, "2026-10-18 00:00") || !at(allDay, "2026-10-18 23:59") || at(allDay, "2026-10-19 12:00") {
+		t.Error("Expected a schedule without times to cover the whole day")
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	for _, config := range []*Schedule{
+		{Days: []string{"monday"}},
+		{Start: "8am", End: "18:00"},
+		{Start: "08:00"},
+		{Timezone: "Mars/Olympus"},
+	} {
+		if ValidateSchedule(config) == nil {
+			t.Errorf("Expected schedule %v to be invalid", config)
+		}
+	}
+}
+
+func TestScheduledRule(t *testing.T) {
+	rule := &NATRule{RuleId: "scheduled", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"}
+	handler := &Handler{config: &Config{Rules: []*NATRule{rule}}}
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	days := []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+	today := time.Now().Weekday()
+
+	rule.Schedule = &Schedule{Days: []string{days[today]}}
+	if _, ok := handler.shouldApplyNAT(context.Background(), destination); !ok {
+		t.Error("Expected the rule to match within its window")
+	}
+
+	handler.conditions.Delete(rule)
+	rule.Schedule = &Schedule{Days: []string{days[(today+1)%7]}}
+	if _, ok := handler.shouldApplyNAT(context.Background(), destination); ok {
+		t.Error("Rules must not match outside their window")
+	}
+}
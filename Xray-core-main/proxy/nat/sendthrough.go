@@ -0,0 +1,53 @@
+package nat
+
+import (
+	"context"
+	"net"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// sendThroughOf returns the local address flows to a real address are dialed from: the
+// sendThrough of the first virtual range whose real network holds the address
+func (h *Handler) sendThroughOf(realAddress xnet.Address) net.IP {
+	if h.config == nil || realAddress == nil || !realAddress.Family().IsIP() {
+		return nil
+	}
+	ip := realAddress.IP()
+	for _, vrange := range h.config.VirtualRanges {
+		if vrange.SendThrough == "" {
+			continue
+		}
+		for _, network := range []string{vrange.RealNetwork, vrange.NpTv6RealPrefix} {
+			if network == "" {
+				continue
+			}
+			if realNet, err := parseNetworkOrIP(network); err == nil && realNet.Contains(ip) {
+				return net.ParseIP(vrange.SendThrough)
+			}
+		}
+	}
+	return nil
+}
+
+// withSendThrough makes a flow to realDest leave from the sendThrough address of its real
+// network, as the gateway of the outbound that the system dialer binds to. The address takes
+// precedence over the sendThrough of the outbound.
+func (h *Handler) withSendThrough(ctx context.Context, realDest xnet.Destination) context.Context {
+	ip := h.sendThroughOf(realDest.Address)
+	if ip == nil {
+		return ctx
+	}
+	return withGateway(ctx, realDest, ip)
+}
+
+// withGateway makes a flow to realDest leave from the local address ip
+func withGateway(ctx context.Context, realDest xnet.Destination, ip net.IP) context.Context {
+	outbounds := session.OutboundsFromContext(ctx)
+	if len(outbounds) == 0 {
+		return session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: realDest, Gateway: xnet.IPAddress(ip)}})
+	}
+	outbounds[len(outbounds)-1].Gateway = xnet.IPAddress(ip)
+	return ctx
+}
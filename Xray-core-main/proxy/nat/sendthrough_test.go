@@ -0,0 +1,91 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestSendThroughOf(t *testing.T) {
+	handler := &Handler{config: &Config{
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", SendThrough: "10.0.0.1"},
+			{VirtualNetwork: "240.3.3.0/24", RealNetwork: "192.168.2.0/24"},
+			{NpTv6VirtualPrefix: "fd01:203:405::/48", NpTv6RealPrefix: "2001:db8:1::/48", SendThrough: "2001:db8:ffff::1"},
+		},
+	}}
+
+	for address, expected := range map[string]string{
+		"192.168.1.20":    "10.0.0.1",
+		"2001:db8:1::20":  "2001:db8:ffff::1",
+		"192.168.2.20":    "",
+		"8.8.8.8":         "",
+		"www.example.com": "",
+	} {
+		ip := handler.sendThroughOf(xnet.ParseAddress(address))
+		if expected == "" && ip != nil || expected != "" && !ip.Equal(net.ParseIP(expected)) {
+			t.Errorf("Expected flows to %s to be sent through %q, got %v", address, expected, ip)
+		}
+	}
+}
+
+func TestSendThroughBindsSource(t *testing.T) {
+	listener := listenTCP(t)
+	sources := make(chan net.Addr, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sources <- conn.RemoteAddr()
+		conn.Write([]byte("hello"))
+	}()
+
+	rule := &NATRule{RuleId: "lan", VirtualDestination: "240.2.2.1", RealDestination: "127.0.0.1", Sockopt: &internet.SocketConfig{}}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "127.0.0.0/24", SendThrough: "127.0.0.9"}},
+		Rules:         []*NATRule{rule},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, externalDialer{}, rule)
+	}()
+
+	select {
+	case addr := <-sources:
+		if ip := addr.(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 9)) {
+			t.Errorf("Expected the flow to be dialed from 127.0.0.9, got %v", ip)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the flow to reach the server")
+	}
+	mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil || mb.String() != "hello" {
+		t.Errorf("Expected the reply of the server, got %q, %v", mb.String(), err)
+	}
+	buf.ReleaseMulti(mb)
+
+	uplinkWriter.Close()
+	<-done
+}
@@ -0,0 +1,226 @@
+package nat
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// sessionShardCount is the number of independently locked shards of the session table
+const sessionShardCount = 32
+
+// sessionShard is one partition of the session table with its own LRU lists and lock.
+// Touching a session only updates its activity; a list is kept ordered by the lruStamp
+// of its sessions, and a session touched since it was placed is moved up once it reaches
+// the back, so the back is always the least recently active session of the list.
+type sessionShard struct {
+	sync.Mutex
+	lru      [sessionClassCount]*list.List // By session class, front is the most recently used session
+	elements map[string]*list.Element      // Session ID -> LRU element holding the *NATSession
+	wheel    timingWheel                   // Expiry schedule of the shard's sessions
+}
+
+// sessionTable stores NAT sessions in shards selected by a hash of the session key,
+// so creating and removing sessions of unrelated flows never contends on one lock.
+type sessionTable struct {
+	shards [sessionShardCount]sessionShard
+	start  time.Time // Reference time of tick 0 of the timing wheels
+}
+
+func newSessionTable() *sessionTable {
+	t := &sessionTable{start: time.Now()}
+	for i := range t.shards {
+		for class := range t.shards[i].lru {
+			t.shards[i].lru[class] = list.New()
+		}
+		t.shards[i].elements = make(map[string]*list.Element)
+	}
+	return t
+}
+
+func (t *sessionTable) shard(sessionID string) *sessionShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(sessionID))
+	return &t.shards[hash.Sum32()%sessionShardCount]
+}
+
+// Store inserts or refreshes a session and marks it as most recently used of its class
+func (t *sessionTable) Store(session *NATSession) {
+	shard := t.shard(session.SessionID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if elem, exists := shard.elements[session.SessionID]; exists {
+		shard.lru[elem.Value.(*NATSession).class].Remove(elem)
+	}
+	session.lruStamp = session.counters.lastActivity.Load()
+	shard.elements[session.SessionID] = shard.insert(session)
+}
+
+// insert adds a session to the list of its class at the position of its lruStamp. New and
+// touched sessions go to the front, restored ones may land further back.
+func (shard *sessionShard) insert(session *NATSession) *list.Element {
+	lru := shard.lru[session.class]
+	if front := lru.Front(); front == nil || front.Value.(*NATSession).lruStamp <= session.lruStamp {
+		return lru.PushFront(session)
+	}
+	if back := lru.Back(); back.Value.(*NATSession).lruStamp >= session.lruStamp {
+		return lru.PushBack(session)
+	}
+	elem := lru.Front()
+	for elem.Value.(*NATSession).lruStamp > session.lruStamp {
+		elem = elem.Next()
+	}
+	return lru.InsertBefore(session, elem)
+}
+
+// oldest returns the least recently active session of a class, moving the sessions touched
+// since they were placed up from the back first
+func (shard *sessionShard) oldest(class sessionClass) *NATSession {
+	lru := shard.lru[class]
+	for elem := lru.Back(); elem != nil; elem = lru.Back() {
+		session := elem.Value.(*NATSession)
+		activity := session.counters.lastActivity.Load()
+		if activity <= session.lruStamp {
+			return session
+		}
+		lru.Remove(elem)
+		session.lruStamp = activity
+		shard.elements[session.SessionID] = shard.insert(session)
+	}
+	return nil
+}
+
+// Load returns the session with the given ID
+func (t *sessionTable) Load(sessionID string) (*NATSession, bool) {
+	shard := t.shard(sessionID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if elem, exists := shard.elements[sessionID]; exists {
+		return elem.Value.(*NATSession), true
+	}
+	return nil, false
+}
+
+// LoadAndDelete removes the session with the given ID and returns it if it was present
+func (t *sessionTable) LoadAndDelete(sessionID string) (*NATSession, bool) {
+	shard := t.shard(sessionID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	elem, exists := shard.elements[sessionID]
+	if !exists {
+		return nil, false
+	}
+	session := elem.Value.(*NATSession)
+	shard.lru[session.class].Remove(elem)
+	delete(shard.elements, sessionID)
+	return session, true
+}
+
+// tickOf returns the first wheel tick at or after t
+func (t *sessionTable) tickOf(at time.Time) uint64 {
+	elapsed := at.Sub(t.start)
+	if elapsed <= 0 {
+		return 0
+	}
+	return uint64((elapsed + wheelTick - 1) / wheelTick)
+}
+
+// Schedule arranges for a session to be returned by Due once the deadline has passed
+func (t *sessionTable) Schedule(session *NATSession, deadline time.Time) {
+	shard := t.shard(session.SessionID)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if _, exists := shard.elements[session.SessionID]; exists {
+		shard.wheel.schedule(session, t.tickOf(deadline))
+	}
+}
+
+// Due advances the timing wheels to now and returns the stored sessions whose scheduled
+// deadline has passed. Callers decide whether a session really expired and reschedule it otherwise.
+func (t *sessionTable) Due(now time.Time) []*NATSession {
+	elapsed := now.Sub(t.start)
+	if elapsed < 0 {
+		return nil
+	}
+	to := uint64(elapsed / wheelTick)
+
+	var due []*NATSession
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.Lock()
+		fired := shard.wheel.advance(to, nil)
+		for _, session := range fired {
+			// Skip sessions that were removed after being scheduled
+			if elem, exists := shard.elements[session.SessionID]; exists && elem.Value == session {
+				due = append(due, session)
+			}
+		}
+		shard.Unlock()
+	}
+	return due
+}
+
+// Range calls f for every session until f returns false. Each shard is snapshotted
+// before f is called, so f may modify the table.
+func (t *sessionTable) Range(f func(session *NATSession) bool) {
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.Lock()
+		sessions := make([]*NATSession, 0, len(shard.elements))
+		for _, lru := range shard.lru {
+			for elem := lru.Front(); elem != nil; elem = elem.Next() {
+				sessions = append(sessions, elem.Value.(*NATSession))
+			}
+		}
+		shard.Unlock()
+
+		for _, session := range sessions {
+			if !f(session) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of stored sessions
+func (t *sessionTable) Len() int {
+	n := 0
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.Lock()
+		n += len(shard.elements)
+		shard.Unlock()
+	}
+	return n
+}
+
+// EvictOldest removes the least recently active session of the lowest session class holding
+// any, comparing the least recently active session of that class in every shard
+func (t *sessionTable) EvictOldest() (*NATSession, bool) {
+	for class := range sessionClassCount {
+		for {
+			var oldest *NATSession
+			for i := range t.shards {
+				shard := &t.shards[i]
+				shard.Lock()
+				if candidate := shard.oldest(class); candidate != nil && (oldest == nil || candidate.LastActivity().Before(oldest.LastActivity())) {
+					oldest = candidate
+				}
+				shard.Unlock()
+			}
+			if oldest == nil {
+				break
+			}
+			// Another goroutine may have removed the candidate meanwhile; pick again then
+			if session, ok := t.LoadAndDelete(oldest.SessionID); ok {
+				return session, true
+			}
+		}
+	}
+	return nil, false
+}
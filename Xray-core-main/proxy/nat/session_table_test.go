@@ -0,0 +1,131 @@
+package nat
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestSessionTableConcurrentAccess(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	const workers = 16
+	const perWorker = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), xnet.Port(10000+w))
+			dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+			for i := 0; i < perWorker; i++ {
+				session := handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+				if i%2 == 0 {
+					handler.removeSession(session.SessionID)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stats := handler.Stats()
+	if stats.TotalSessions != workers*perWorker {
+		t.Errorf("Expected %d total sessions, got %d", workers*perWorker, stats.TotalSessions)
+	}
+	if stats.ActiveSessions != workers*perWorker/2 {
+		t.Errorf("Expected %d active sessions, got %d", workers*perWorker/2, stats.ActiveSessions)
+	}
+	if n := handler.sessions.Len(); int64(n) != stats.ActiveSessions {
+		t.Errorf("Session table holds %d sessions but %d are active", n, stats.ActiveSessions)
+	}
+}
+
+func TestSessionTableEvictsLeastRecentlyActive(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	// Many more sessions than shards, so every shard holds several
+	handler.maxSessions = 200
+
+	dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	open := func(port xnet.Port) *NATSession {
+		return handler.createNATSession(xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), port), dest, dest, "outbound", classInteractive)
+	}
+	var sessions []*NATSession
+	for i := 0; i < 200; i++ {
+		sessions = append(sessions, open(xnet.Port(10000+i)))
+	}
+
+	// The older half becomes the most recently active one
+	for _, session := range sessions[:100] {
+		session.touch()
+	}
+
+	// Each new session evicts one of the sessions left idle, the least recently active first
+	for i := 0; i < 100; i++ {
+		open(xnet.Port(20000 + i))
+		if _, exists := handler.sessions.Load(sessions[100+i].SessionID); exists {
+			t.Fatalf("Expected the least recently active session %d to be evicted", 100+i)
+		}
+	}
+	for i, session := range sessions[:100] {
+		if _, exists := handler.sessions.Load(session.SessionID); !exists {
+			t.Fatalf("Recently active session %d should not be evicted", i)
+		}
+	}
+	if handler.Stats().ActiveSessions != 200 {
+		t.Errorf("Expected 200 active sessions, got %d", handler.Stats().ActiveSessions)
+	}
+}
+
+func TestSessionTableEvictsByClass(t *testing.T) {
+	table := newSessionTable()
+	base := time.Now().Add(-time.Hour)
+
+	// Sessions of both classes in one shard, each older than the next
+	shard := table.shard("s")
+	var bulk, interactive []*NATSession
+	for i := 0; len(bulk)+len(interactive) < 20; i++ {
+		session := &NATSession{SessionID: fmt.Sprint("s", i), class: classBulk}
+		if table.shard(session.SessionID) != shard {
+			continue
+		}
+		if len(bulk) == 10 {
+			session.class = classInteractive
+		}
+		session.counters.lastActivity.Store(base.Add(time.Duration(i) * time.Second).UnixNano())
+		table.Store(session)
+		if session.class == classBulk {
+			bulk = append(bulk, session)
+		} else {
+			interactive = append(interactive, session)
+		}
+	}
+	for _, session := range bulk[:5] {
+		session.touch()
+	}
+	interactive[0].touch()
+
+	// The idle bulk sessions go first, then the touched ones, then the interactive sessions
+	var expected []*NATSession
+	expected = append(expected, bulk[5:]...)
+	expected = append(expected, bulk[:5]...)
+	expected = append(expected, interactive[1:]...)
+	expected = append(expected, interactive[0])
+	for i, session := range expected {
+		evicted, ok := table.EvictOldest()
+		if !ok {
+			t.Fatalf("Expected eviction %d to take %s, the table is empty", i, session.SessionID)
+		}
+		if evicted != session {
+			t.Fatalf("Expected eviction %d to take %s of class %s, got %s of class %s", i, session.SessionID, session.class, evicted.SessionID, evicted.class)
+		}
+	}
+	if _, ok := table.EvictOldest(); ok {
+		t.Error("Expected the table to be empty")
+	}
+}
@@ -0,0 +1,255 @@
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// Session log event types
+const (
+	SessionEventCreate   = "create"
+	SessionEventTeardown = "teardown"
+)
+
+// Why a session was torn down
+const (
+	SessionEndClosed  = "closed"  // The flow relaying it ended
+	SessionEndExpired = "expired" // Idle for longer than its timeout
+	SessionEndEvicted = "evicted" // Evicted to make room for a new session
+	SessionEndFlushed = "flushed" // Flushed through the API
+	SessionEndRoamed  = "roamed"  // Its WireGuard peer roamed to a new source, whose session continues the mapping
+)
+
+// SessionEvent is one entry of the NAT session log
+type SessionEvent struct {
+	Event         string    `json:"event"`
+	Reason        string    `json:"reason,omitempty"` // Why a session was torn down
+	Time          time.Time `json:"time"`
+	SiteID        string    `json:"siteId"`
+	SessionID     string    `json:"sessionId"`
+	RuleID        string    `json:"ruleId"`
+	Protocol      string    `json:"protocol"`
+	VirtualSource string    `json:"virtualSource"`
+	VirtualDest   string    `json:"virtualDest"`
+	RealSource    string    `json:"realSource"`
+	RealDest      string    `json:"realDest"`
+	Domain        string    `json:"domain,omitempty"`
+	UplinkBytes   int64     `json:"uplinkBytes"`
+	DownlinkBytes int64     `json:"downlinkBytes"`
+	Duration      float64   `json:"durationSeconds"`
+}
+
+// String formats the event as space separated key=value pairs
+func (e *SessionEvent) String() string {
+	line := fmt.Sprintf("NAT session %s id=%s rule=%s proto=%s virtual=%s->%s real=%s->%s up=%d down=%d duration=%.3fs",
+		e.Event, e.SessionID, e.RuleID, e.Protocol, e.VirtualSource, e.VirtualDest, e.RealSource, e.RealDest,
+		e.UplinkBytes, e.DownlinkBytes, e.Duration)
+	if e.Domain != "" {
+		line += " domain=" + e.Domain
+	}
+	if e.Reason != "" {
+		line += " reason=" + e.Reason
+	}
+	return line
+}
+
+// logRecord is an entry of the session or audit log, written as JSON by the file and syslog sinks
+type logRecord interface {
+	String() string // One line form for the xray log
+}
+
+// sessionLogSink receives session log events, and audit events when it writes the audit log
+type sessionLogSink interface {
+	Write(record logRecord) error
+	Close() error
+}
+
+func newSessionLogSink(config *SessionLog) (sessionLogSink, error) {
+	switch strings.ToLower(config.Sink) {
+	case "", "log":
+		return logSink{}, nil
+	case "file":
+		return newFileSink(config)
+	case "syslog":
+		return newSyslogSink(config)
+	default:
+		return nil, errors.New("unknown session log sink ", config.Sink)
+	}
+}
+
+// logSink writes session events to the xray log
+type logSink struct{}
+
+func (logSink) Write(record logRecord) error {
+	errors.LogInfo(context.Background(), record.String())
+	return nil
+}
+
+func (logSink) Close() error {
+	return nil
+}
+
+// fileSink appends records as JSON lines to a file that is rotated by size
+type fileSink struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(config *SessionLog) (*fileSink, error) {
+	if config.Path == "" {
+		return nil, errors.New("file sink requires a path")
+	}
+	s := &fileSink{
+		path:       config.Path,
+		maxSize:    int64(config.MaxSizeMb) * 1024 * 1024,
+		maxBackups: int(config.MaxBackups),
+	}
+	if s.maxBackups == 0 {
+		s.maxBackups = 5
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.New("failed to open log file ", s.path).Base(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest file, and starts a new file
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Write(record logRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return errors.New("log file ", s.path, " is closed")
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return errors.New("failed to rotate log file ", s.path).Base(err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// newSessionEvent describes a session for the session log
+func (h *Handler) newSessionEvent(event string, session *NATSession) *SessionEvent {
+	endpoint := func(dest xnet.Destination) string {
+		if dest.Address == nil {
+			return ""
+		}
+		return dest.NetAddr()
+	}
+	stats := session.Stats()
+	var reason string
+	if event == SessionEventTeardown {
+		reason = SessionEndClosed
+		if end := session.endReason.Load(); end != nil {
+			reason = *end
+		}
+	}
+	return &SessionEvent{
+		Event:         event,
+		Reason:        reason,
+		Time:          time.Now(),
+		SiteID:        h.siteID(),
+		SessionID:     session.SessionID,
+		RuleID:        session.RuleID,
+		Protocol:      strings.ToLower(session.Protocol),
+		VirtualSource: endpoint(session.VirtualSource),
+		VirtualDest:   endpoint(session.VirtualDest),
+		RealSource:    endpoint(session.RealSource),
+		RealDest:      endpoint(session.RealDest),
+		Domain:        session.Domain,
+		UplinkBytes:   stats.UplinkBytes,
+		DownlinkBytes: stats.DownlinkBytes,
+		Duration:      time.Since(session.CreatedAt).Seconds(),
+	}
+}
+
+// logSessionCreate records the creation of a fully set up session
+func (h *Handler) logSessionCreate(session *NATSession) {
+	h.logSessionEvent(SessionEventCreate, session)
+}
+
+// logSessionTeardown records the removal of a session
+func (h *Handler) logSessionTeardown(session *NATSession) {
+	h.logSessionEvent(SessionEventTeardown, session)
+}
+
+// logSessionEvent writes an event of session to the session log and the webhook, and publishes
+// it to the API watchers
+func (h *Handler) logSessionEvent(event string, session *NATSession) {
+	if h.sessionLog == nil && h.webhook == nil && !h.watchers.watched() {
+		return
+	}
+	record := h.newSessionEvent(event, session)
+	if h.sessionLog != nil {
+		if err := h.sessionLog.Write(record); err != nil {
+			errors.LogWarningInner(context.Background(), err, "failed to write NAT session log")
+		}
+	}
+	if h.webhook != nil {
+		h.webhook.Send(record)
+	}
+	h.watchers.publish(record)
+}
+
+// endSession records why session is about to be torn down, for its teardown event
+func endSession(session *NATSession, reason string) {
+	session.endReason.Store(&reason)
+}
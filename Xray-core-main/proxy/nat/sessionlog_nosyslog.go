@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package nat
+
+import (
+	"github.com/xtls/xray-core/common/errors"
+)
+
+func newSyslogSink(config *SessionLog) (sessionLogSink, error) {
+	return nil, errors.New("syslog sink is not supported on this platform")
+}
@@ -0,0 +1,45 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package nat
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// syslogSink sends records as JSON messages to syslog
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(config *SessionLog) (sessionLogSink, error) {
+	var network, address string
+	if config.SyslogAddress != "" {
+		var found bool
+		network, address, found = strings.Cut(config.SyslogAddress, ":")
+		if !found || (network != "udp" && network != "tcp") {
+			return nil, errors.New("invalid syslog address ", config.SyslogAddress, ", expected udp:host:port or tcp:host:port")
+		}
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "xray-nat")
+	if err != nil {
+		return nil, errors.New("failed to connect to syslog").Base(err)
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(record logRecord) error {
+	message, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(message))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,98 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func readSessionEvents(t *testing.T, path string) []SessionEvent {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []SessionEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event SessionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid session log line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestSessionLogFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nat-sessions.log")
+
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", SessionLog: &SessionLog{Sink: "file", Path: path}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 51000)
+	virtualDest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	realDest := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+	natSession := handler.createNATSession(source, virtualDest, realDest, "outbound", classInteractive)
+	natSession.RuleID = "web"
+	natSession.Domain = "web.internal.corp"
+	handler.announceSession(natSession)
+	natSession.record(true, 42, 1)
+	handler.removeSession(natSession.SessionID)
+	handler.removeSession(natSession.SessionID)
+
+	events := readSessionEvents(t, path)
+	if len(events) != 2 {
+		t.Fatalf("Expected create and teardown events, got %v", events)
+	}
+	create, teardown := events[0], events[1]
+	if create.Event != SessionEventCreate || create.RuleID != "web" || create.VirtualSource != "10.0.0.2:51000" || create.RealDest != "192.168.1.20:80" || create.Domain != "web.internal.corp" {
+		t.Errorf("Unexpected create event %+v", create)
+	}
+	if teardown.Event != SessionEventTeardown || teardown.UplinkBytes != 42 || teardown.SessionID != create.SessionID || teardown.Reason != SessionEndClosed {
+		t.Errorf("Unexpected teardown event %+v", teardown)
+	}
+}
+
+func TestSessionLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nat-sessions.log")
+	sink, err := newFileSink(&SessionLog{Path: path, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.maxSize = 300
+
+	for i := 0; i < 20; i++ {
+		if err := sink.Write(&SessionEvent{Event: SessionEventCreate, SessionID: "s"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("%s exceeds the rotation size: %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most two rotated files")
+	}
+}
+
+func TestSessionLogUnknownSink(t *testing.T) {
+	if _, err := newSessionLogSink(&SessionLog{Sink: "kafka"}); err == nil {
+		t.Error("Expected error for an unknown sink")
+	}
+}
@@ -0,0 +1,133 @@
+package nat
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+)
+
+// flowShaper limits one direction of a flow to a byte rate with a token bucket. TCP is paced,
+// waiting for the tokens of what was read; datagrams over the rate are dropped.
+type flowShaper struct {
+	sync.Mutex
+	bucket tokenBucket
+}
+
+// flowShapers are the shapers of both directions of a flow, nil for a direction without a limit
+type flowShapers struct {
+	uplink, downlink *flowShaper
+}
+
+// newFlowShapers returns the shapers of a new flow of a rule limited to limit
+func newFlowShapers(limit *RateLimit) flowShapers {
+	shaper := func(rate uint64) *flowShaper {
+		if rate == 0 {
+			return nil
+		}
+		return &flowShaper{bucket: newTokenBucket(float64(rate), float64(limit.GetBurstBytes()), time.Now())}
+	}
+	return flowShapers{uplink: shaper(limit.GetUplinkBytesPerSecond()), downlink: shaper(limit.GetDownlinkBytesPerSecond())}
+}
+
+// wait spends n bytes, waiting for as long as the bucket is in debt or until ctx ends
+func (s *flowShaper) wait(ctx context.Context, n int64) error {
+	s.Lock()
+	delay := s.bucket.spend(time.Now(), float64(n))
+	s.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// allow spends the n bytes of a datagram, or reports false when it is over the rate. A datagram
+// larger than the burst passes when the bucket is full.
+func (s *flowShaper) allow(n int64) bool {
+	if s == nil {
+		return true
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.bucket.takeBytes(time.Now(), float64(n))
+}
+
+// pace returns reader waiting for the tokens of what it read, reader itself without a limit
+func (s *flowShaper) pace(ctx context.Context, reader buf.Reader) buf.Reader {
+	if s == nil {
+		return reader
+	}
+	return &pacedReader{Reader: reader, ctx: ctx, shaper: s}
+}
+
+// police returns reader dropping the datagrams over the rate, counting them as shaping drops of
+// natSession, reader itself without a limit
+func (s *flowShaper) police(h *Handler, natSession *NATSession, reader buf.Reader) buf.Reader {
+	if s == nil {
+		return reader
+	}
+	return &policedReader{Reader: reader, handler: h, session: natSession, shaper: s}
+}
+
+// pacedReader paces a stream to the rate of its shaper
+type pacedReader struct {
+	buf.Reader
+	ctx    context.Context
+	shaper *flowShaper
+}
+
+// ReadMultiBuffer implements buf.Reader
+func (r *pacedReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	mb, err := r.Reader.ReadMultiBuffer()
+	if !mb.IsEmpty() {
+		if werr := r.shaper.wait(r.ctx, int64(mb.Len())); werr != nil {
+			buf.ReleaseMulti(mb)
+			return nil, werr
+		}
+	}
+	return mb, err
+}
+
+// policedReader drops the datagrams over the rate of its shaper
+type policedReader struct {
+	buf.Reader
+	handler *Handler
+	session *NATSession
+	shaper  *flowShaper
+}
+
+// ReadMultiBuffer implements buf.Reader
+func (r *policedReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	for {
+		mb, err := r.Reader.ReadMultiBuffer()
+		kept := mb[:0]
+		for _, b := range mb {
+			if r.shaper.allow(int64(b.Len())) {
+				kept = append(kept, b)
+				continue
+			}
+			b.Release()
+			r.handler.shapingDrop(r.session)
+		}
+		if !kept.IsEmpty() || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// shapingDrop accounts a datagram of natSession dropped over the rate limit of its rule
+func (h *Handler) shapingDrop(natSession *NATSession) {
+	atomic.AddInt64(&h.shapingDrops, 1)
+	if natSession.rule != nil {
+		natSession.rule.shapingDrops.Add(1)
+	}
+}
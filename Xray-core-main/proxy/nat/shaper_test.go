@@ -0,0 +1,169 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestTokenBucketBytes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	bucket := newTokenBucket(1000, 1500, now)
+	if !bucket.takeBytes(now, 1200) || bucket.takeBytes(now, 400) {
+		t.Fatalf("Expected 1200 of the 1500 bytes of the burst only, %v left", bucket.tokens)
+	}
+	if !bucket.takeBytes(now.Add(100*time.Millisecond), 400) {
+		t.Error("Expected 400 bytes after a tenth of a second at 1000 per second")
+	}
+	// A datagram larger than the burst passes a full bucket
+	if !bucket.takeBytes(now.Add(time.Hour), 4000) || bucket.takeBytes(now.Add(time.Hour), 1) {
+		t.Errorf("Expected an oversized datagram to empty a full bucket, %v left", bucket.tokens)
+	}
+
+	now = now.Add(2 * time.Hour)
+	bucket = newTokenBucket(1000, 1000, now)
+	if delay := bucket.spend(now, 800); delay != 0 {
+		t.Errorf("Expected no wait within the burst, got %v", delay)
+	}
+	if delay := bucket.spend(now, 1300); delay != 1100*time.Millisecond {
+		t.Errorf("Expected to wait for 1100 bytes at 1000 per second, got %v", delay)
+	}
+}
+
+func TestRateLimitTCP(t *testing.T) {
+	listener := listenTCP(t)
+	rule := &NATRule{
+		RuleId:             "backup",
+		VirtualDestination: "240.2.2.1",
+		RealDestination:    "127.0.0.1",
+		RateLimit:          &RateLimit{UplinkBytesPerSecond: 20000, BurstBytes: 4000},
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{rule}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	_, downlinkWriter := pipe.New()
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, tcpTestDialer{}, rule)
+	}()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// 14000 bytes at 20000 per second after a burst of 4000 take half a second
+	start := time.Now()
+	payload := bytes.Repeat([]byte("x"), 2000)
+	for range 7 {
+		b := buf.New()
+		b.Write(payload)
+		if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(server, make([]byte, 14000)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the uplink to be paced to 20000 bytes per second, took %v", elapsed)
+	}
+	uplinkWriter.Close()
+	server.Close()
+	<-done
+}
+
+func TestRateLimitUDP(t *testing.T) {
+	server := listenUDP(t)
+	rule := &NATRule{
+		RuleId:             "game",
+		VirtualDestination: "240.2.2.20",
+		RealDestination:    "127.0.0.1",
+		Protocol:           "udp",
+		RateLimit:          &RateLimit{UplinkBytesPerSecond: 1000, DownlinkBytesPerSecond: 1000},
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{SiteId: "test-site", Rules: []*NATRule{rule}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	virtual := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(server.LocalAddr().(*net.UDPAddr).Port))
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, virtual, udpTestDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	// Two datagrams of 400 bytes fit the burst of 1000 bytes, the third is dropped
+	payload := bytes.Repeat([]byte("x"), 400)
+	mb := make(buf.MultiBuffer, 0, 3)
+	for range 3 {
+		b := buf.New()
+		b.Write(payload)
+		b.UDP = &virtual
+		mb = append(mb, b)
+	}
+	if err := uplinkWriter.WriteMultiBuffer(mb); err != nil {
+		t.Fatal(err)
+	}
+	var mapped *net.UDPAddr
+	for i := range 2 {
+		server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, from, err := server.ReadFromUDP(make([]byte, 1024))
+		if err != nil {
+			t.Fatalf("Expected datagram %d: %v", i, err)
+		}
+		mapped = from
+	}
+	server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := server.ReadFromUDP(make([]byte, 1024)); err == nil {
+		t.Error("Expected the datagram over the uplink rate to be dropped")
+	}
+
+	for range 3 {
+		if _, err := server.WriteToUDP(payload, mapped); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := 0
+	for received < 2 {
+		replies, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+		if err != nil {
+			t.Fatalf("Expected 2 replies, got %d: %v", received, err)
+		}
+		received += len(replies)
+		buf.ReleaseMulti(replies)
+	}
+	if replies, err := downlinkReader.ReadMultiBufferTimeout(200 * time.Millisecond); err == nil {
+		t.Errorf("Expected the reply over the downlink rate to be dropped, got %d", len(replies))
+	}
+
+	if drops := handler.Stats().ShapingDrops; drops != 2 {
+		t.Errorf("Expected 2 shaping drops, got %d", drops)
+	}
+	for _, stats := range handler.RuleStats() {
+		if stats.RuleID == "game" && stats.ShapingDrops != 2 {
+			t.Errorf("Expected 2 shaping drops of the rule, got %d", stats.ShapingDrops)
+		}
+	}
+}
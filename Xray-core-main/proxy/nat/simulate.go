@@ -0,0 +1,137 @@
+package nat
+
+import (
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"google.golang.org/protobuf/proto"
+)
+
+// Outcomes of a simulated flow
+const (
+	SimulationTranslated = "translated"
+	SimulationBypassed   = "bypassed" // Matched a bypass rule
+	SimulationBlocked    = "blocked"  // Matched a deny or reject rule
+	SimulationUnmatched  = "unmatched"
+)
+
+// SimulatedFlow is a flow of a traffic sample replayed by Simulate
+type SimulatedFlow struct {
+	Source      xnet.Destination // Zero when unknown
+	Destination xnet.Destination
+}
+
+// FlowSimulation is how the candidate rules treat a flow of the sample
+type FlowSimulation struct {
+	Flow        SimulatedFlow
+	Outcome     string
+	RuleID      string // ruleKey of the rule taking the flow, empty when unmatched
+	Translation Translation
+	// ruleKeys of the other candidate rules matching the flow and treating it differently, left
+	// out by match order alone
+	Contested []string
+	// Outcome and rule of the flow under the active rules, and whether the candidate rules treat
+	// it differently
+	ActiveOutcome string
+	ActiveRuleID  string
+	Changed       bool
+	Err           error // Translation failed
+}
+
+// RuleSimulation is a candidate rule with the flows of the sample it takes
+type RuleSimulation struct {
+	RuleID    string
+	Flows     int
+	Conflicts []RuleConflict // With the candidate rules before it, as RuleConflicts tells
+}
+
+// Simulation is the outcome of replaying a traffic sample against candidate rules
+type Simulation struct {
+	Flows []FlowSimulation
+	Rules []RuleSimulation // In match order
+}
+
+// Simulate replays the flows of a traffic sample against candidate rules taking the place of the
+// active rules, the configured ones included, and compares the outcome with that of the active
+// rules. Static mappings, virtual ranges, leased addresses, MAP rules and the CLAT still apply.
+// No session is created and the active rules are left unchanged; the flows are matched as
+// TestTranslation matches them.
+func (h *Handler) Simulate(candidates []*NATRule, flows []SimulatedFlow) (*Simulation, error) {
+	for _, rule := range candidates {
+		if rule.VirtualDestination == "" {
+			return nil, errors.New("rule ", ruleKey(rule), ": virtualDestination is required")
+		}
+		if err := validateRule(rule); err != nil {
+			return nil, errors.New("rule ", ruleKey(rule)).Base(err)
+		}
+	}
+	// The patterns of the candidates are compiled on their first flow; drop those no rule had
+	var uncached []string
+	for _, rule := range candidates {
+		if _, found := h.patterns.Load(rule.VirtualDestination); !found && isPatternDestination(rule.VirtualDestination) {
+			uncached = append(uncached, rule.VirtualDestination)
+		}
+	}
+	defer func() {
+		for _, rule := range candidates {
+			h.forgetRule(rule)
+		}
+		for _, virtual := range uncached {
+			h.patterns.Delete(virtual)
+		}
+	}()
+
+	strategy := h.matchStrategy()
+	simulation := &Simulation{Rules: make([]RuleSimulation, len(candidates))}
+	byRule := make(map[*NATRule]*RuleSimulation, len(candidates))
+	for i, rule := range candidates {
+		simulation.Rules[i] = RuleSimulation{RuleID: ruleKey(rule), Conflicts: ruleConflicts(rule, candidates[:i], strategy)}
+		byRule[rule] = &simulation.Rules[i]
+	}
+
+	active := h.activeRules()
+	for _, flow := range flows {
+		result := FlowSimulation{Flow: flow}
+		result.Translation, result.Err = h.testTranslation(flow.Source, flow.Destination, candidates)
+		var rule *NATRule
+		result.Outcome, rule = h.simulatedOutcome(flow, result.Translation, candidates, strategy)
+		if rule != nil {
+			result.RuleID = ruleKey(rule)
+			if entry, found := byRule[rule]; found {
+				entry.Flows++
+			}
+			ctx := testContext(flow.Source, flow.Destination)
+			for _, other := range candidates {
+				if other != rule && h.matchesRule(ctx, result.Translation.VirtualDestination, other) && !proto.Equal(translationOf(other), translationOf(rule)) {
+					result.Contested = append(result.Contested, ruleKey(other))
+				}
+			}
+		}
+
+		activeTranslation, _ := h.testTranslation(flow.Source, flow.Destination, active)
+		var activeRule *NATRule
+		result.ActiveOutcome, activeRule = h.simulatedOutcome(flow, activeTranslation, active, strategy)
+		if activeRule != nil {
+			result.ActiveRuleID = ruleKey(activeRule)
+		}
+		result.Changed = result.Outcome != result.ActiveOutcome || result.RuleID != result.ActiveRuleID ||
+			result.Translation.RealDestination != activeTranslation.RealDestination
+		simulation.Flows = append(simulation.Flows, result)
+	}
+	return simulation, nil
+}
+
+// simulatedOutcome returns the outcome of a flow translated by rules, and the rule taking it
+func (h *Handler) simulatedOutcome(flow SimulatedFlow, translation Translation, rules []*NATRule, strategy string) (string, *NATRule) {
+	switch rule := translation.Rule; {
+	case rule == nil:
+		bypass := h.matchingRule(testContext(flow.Source, flow.Destination), translation.VirtualDestination, rules, strategy)
+		if bypass != nil && bypass.Action == actionBypass {
+			return SimulationBypassed, bypass
+		}
+		return SimulationUnmatched, nil
+	case blocksFlows(rule):
+		return SimulationBlocked, rule
+	default:
+		return SimulationTranslated, rule
+	}
+}
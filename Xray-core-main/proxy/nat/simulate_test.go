@@ -0,0 +1,109 @@
+package nat
+
+import (
+	"slices"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestSimulate(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+			{RuleId: "db", VirtualDestination: "240.2.2.30", RealDestination: "192.168.1.30"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The candidate moves the web server, bypasses its monitoring port and leaves db out
+	candidates := []*NATRule{
+		{RuleId: "monitoring", VirtualDestination: "240.2.2.20", Ports: "9100", Action: actionBypass},
+		{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.21"},
+		{RuleId: "web-old", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20", Protocol: "tcp"},
+		{RuleId: "blocked", VirtualDestination: "240.2.2.40", Action: actionDeny},
+	}
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	flow := func(network, address string, port xnet.Port) SimulatedFlow {
+		destination := xnet.TCPDestination(xnet.ParseAddress(address), port)
+		if network == "udp" {
+			destination.Network = xnet.Network_UDP
+		}
+		return SimulatedFlow{Source: source, Destination: destination}
+	}
+	simulation, err := handler.Simulate(candidates, []SimulatedFlow{
+		flow("tcp", "240.2.2.20", 443),
+		flow("tcp", "240.2.2.20", 9100),
+		flow("udp", "240.2.2.20", 53),
+		flow("tcp", "240.2.2.30", 5432),
+		flow("tcp", "240.2.2.40", 80),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []struct {
+		outcome, rule, real string
+		contested           int
+		activeRule          string
+		changed             bool
+	}{
+		{SimulationTranslated, "web", "192.168.1.21:443", 1, "web", true},
+		{SimulationBypassed, "monitoring", "", 2, "web", true},
+		{SimulationTranslated, "web", "192.168.1.21:53", 0, "web", true},
+		{SimulationUnmatched, "", "", 0, "db", true},
+		{SimulationBlocked, "blocked", "", 0, "", true},
+	} {
+		result := simulation.Flows[i]
+		real := ""
+		if result.Translation.RealDestination.IsValid() {
+			real = result.Translation.RealDestination.NetAddr()
+		}
+		if result.Outcome != expected.outcome || result.RuleID != expected.rule || real != expected.real ||
+			len(result.Contested) != expected.contested || result.ActiveRuleID != expected.activeRule || result.Changed != expected.changed {
+			t.Errorf("Unexpected simulation of flow %d: %+v", i, result)
+		}
+	}
+	if simulation.Flows[0].ActiveOutcome != SimulationTranslated || simulation.Flows[0].Contested[0] != "web-old" {
+		t.Errorf("Expected rule web-old to contest the flow, got %+v", simulation.Flows[0])
+	}
+
+	for i, expected := range []struct {
+		rule      string
+		flows     int
+		conflicts []string
+	}{
+		{"monitoring", 1, nil},
+		{"web", 2, []string{ConflictContradicts}},
+		{"web-old", 0, []string{ConflictContradicts, ConflictContradicts}},
+		{"blocked", 1, nil},
+	} {
+		rule := simulation.Rules[i]
+		var kinds []string
+		for _, conflict := range rule.Conflicts {
+			kinds = append(kinds, conflict.Kind)
+		}
+		if rule.RuleID != expected.rule || rule.Flows != expected.flows || !slices.Equal(kinds, expected.conflicts) {
+			t.Errorf("Unexpected simulation of rule %d: %+v", i, rule)
+		}
+	}
+
+	// The active rules are left alone
+	if translation, _ := handler.TestTranslation(source, xnet.TCPDestination(xnet.ParseAddress("240.2.2.30"), 5432)); translation.Rule == nil || translation.Rule.RuleId != "db" {
+		t.Errorf("Expected the active rules to keep translating, got %+v", translation)
+	}
+	// The patterns of the candidates are not kept
+	if _, err := handler.Simulate([]*NATRule{{RuleId: "wildcard", VirtualDestination: "240.2.9.*", RealDestination: "192.168.9.1"}}, []SimulatedFlow{flow("tcp", "240.2.9.7", 80)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := handler.patterns.Load("240.2.9.*"); cached {
+		t.Error("Expected the pattern of the candidate rule to be dropped")
+	}
+	if _, err := handler.Simulate([]*NATRule{{RuleId: "broken", VirtualDestination: "240.2.2.50", Action: "drop-later"}}, nil); err == nil {
+		t.Error("Expected an invalid candidate rule to be rejected")
+	}
+}
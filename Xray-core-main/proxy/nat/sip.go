@@ -0,0 +1,317 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func init() {
+	common.Must(RegisterALG(algSIP, sipGateway{}))
+}
+
+// sipGateway is the SIP gateway of signalling on UDP and TCP
+type sipGateway struct{}
+
+// Match implements ALG
+func (sipGateway) Match(flow *ALGFlow) bool {
+	flow.State = newSIPALG(flow.handler, flow.Context, flow.Session, flow.Conn)
+	return true
+}
+
+// Frame implements ALGFramer
+func (sipGateway) Frame(data []byte) int {
+	return sipMessageLength(data)
+}
+
+// RewriteOutbound implements ALG
+func (sipGateway) RewriteOutbound(flow *ALGFlow, message []byte) []byte {
+	return flow.State.(*sipALG).rewriteRequest(message)
+}
+
+// RewriteInbound implements ALG
+func (sipGateway) RewriteInbound(flow *ALGFlow, message []byte) []byte {
+	return flow.State.(*sipALG).rewriteResponse(message)
+}
+
+// ExpectSessions implements ALG
+func (sipGateway) ExpectSessions(flow *ALGFlow, message []byte, outbound bool) []ALGExpectation {
+	if outbound {
+		return nil
+	}
+	return flow.State.(*sipALG).expectMedia(message)
+}
+
+// sipALG rewrites the addresses of SIP signalling (RFC 3261) and its SDP bodies (RFC 4566).
+// Requests of the client announce the local address of the flow instead of the client's, and
+// the media the client offers is relayed through pinholes on that address. Messages of the
+// real destination announce the virtual destination, and the media they offer is expected.
+type sipALG struct {
+	handler   *Handler
+	ctx       context.Context
+	session   *NATSession
+	localIP   net.IP // Address of the flow towards the real destination
+	localPort int
+}
+
+func newSIPALG(h *Handler, ctx context.Context, natSession *NATSession, conn stat.Connection) *sipALG {
+	a := &sipALG{handler: h, ctx: ctx, session: natSession}
+	a.localIP = localAddressTowards(conn, natSession.RealDest.Address)
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		a.localPort = addr.Port
+	case *net.UDPAddr:
+		a.localPort = addr.Port
+	}
+	return a
+}
+
+// sipHost formats an address as the host of a SIP URI or header
+func sipHost(ip net.IP) string {
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
+
+func isHostChar(c byte) bool {
+	return c == '.' || c == '-' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// replaceHost replaces the occurrences of host in value by replacement. A port > 0 replaces
+// the port following the host too, or is added when there is none.
+func replaceHost(value, host, replacement string, port int) string {
+	var out strings.Builder
+	for {
+		i := strings.Index(value, host)
+		if i < 0 {
+			out.WriteString(value)
+			return out.String()
+		}
+		end := i + len(host)
+		if (i > 0 && isHostChar(value[i-1])) || (end < len(value) && isHostChar(value[end])) {
+			// Part of another host
+			out.WriteString(value[:end])
+			value = value[end:]
+			continue
+		}
+		out.WriteString(value[:i])
+		out.WriteString(replacement)
+		if port > 0 {
+			if end < len(value) && value[end] == ':' {
+				end++
+				for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+					end++
+				}
+			}
+			out.WriteString(":" + strconv.Itoa(port))
+		}
+		value = value[end:]
+	}
+}
+
+func (a *sipALG) rewriteRequest(message []byte) []byte {
+	return a.rewriteMessage(message, true)
+}
+
+func (a *sipALG) rewriteResponse(message []byte) []byte {
+	return a.rewriteMessage(message, false)
+}
+
+// rewriteMessage rewrites the start line, Via and Contact headers and SDP body of a message.
+// uplink messages come from the client on the virtual side.
+func (a *sipALG) rewriteMessage(message []byte, uplink bool) []byte {
+	head, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return message
+	}
+	virtualAddr, realAddr := a.session.VirtualDest.Address, a.session.RealDest.Address
+	if !virtualAddr.Family().IsIP() || !realAddr.Family().IsIP() {
+		return message
+	}
+	virtualHost, realHost := sipHost(virtualAddr.IP()), sipHost(realAddr.IP())
+
+	lines := strings.Split(string(head), "\r\n")
+	if uplink {
+		// The request URI addresses the virtual destination
+		lines[0] = replaceHost(lines[0], virtualHost, realHost, 0)
+	}
+	sdp := false
+	for i := 1; i < len(lines); i++ {
+		name, value, ok := strings.Cut(lines[i], ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "via", "v", "contact", "m":
+			if uplink {
+				if source := a.session.VirtualSource.Address; source != nil && source.Family().IsIP() && a.localIP != nil {
+					value = replaceHost(value, sipHost(source.IP()), sipHost(a.localIP), a.localPort)
+				}
+			} else {
+				value = replaceHost(value, realHost, virtualHost, 0)
+			}
+			lines[i] = name + ":" + value
+		case "content-type", "c":
+			sdp = strings.Contains(strings.ToLower(value), "application/sdp")
+		}
+	}
+
+	if sdp {
+		body = a.rewriteSDP(body, uplink)
+		for i := 1; i < len(lines); i++ {
+			name, _, ok := strings.Cut(lines[i], ":")
+			if lower := strings.ToLower(strings.TrimSpace(name)); ok && (lower == "content-length" || lower == "l") {
+				lines[i] = name + ": " + strconv.Itoa(len(body))
+			}
+		}
+	}
+	return append([]byte(strings.Join(lines, "\r\n")+"\r\n\r\n"), body...)
+}
+
+// sdpMedia returns the media address and port of the m= lines of SDP body lines, by line. The
+// connection address of each media is its own c= line or the session's.
+func sdpMedia(lines []string) map[int]xnet.Destination {
+	var sessionAddr string
+	mediaAddr := make(map[int]string)
+	media := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			media = i
+		} else if fields := strings.Fields(strings.TrimPrefix(line, "c=")); strings.HasPrefix(line, "c=") && len(fields) == 3 {
+			if media < 0 {
+				sessionAddr = fields[2]
+			} else {
+				mediaAddr[media] = fields[2]
+			}
+		}
+	}
+
+	destinations := make(map[int]xnet.Destination)
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if !strings.HasPrefix(line, "m=") || len(fields) < 2 {
+			continue
+		}
+		portField, _, _ := strings.Cut(fields[1], "/")
+		port, err := strconv.Atoi(portField)
+		addr := mediaAddr[i]
+		if addr == "" {
+			addr = sessionAddr
+		}
+		ip := net.ParseIP(addr)
+		if err != nil || port <= 0 || port >= 65535 || ip == nil {
+			continue
+		}
+		destinations[i] = xnet.UDPDestination(xnet.IPAddress(ip), xnet.Port(port))
+	}
+	return destinations
+}
+
+// sdpLines returns the lines of the SDP body of a message, if it has one
+func sdpLines(message []byte) ([]string, bool) {
+	head, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return nil, false
+	}
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if lower := strings.ToLower(strings.TrimSpace(name)); ok && (lower == "content-type" || lower == "c") {
+			if strings.Contains(strings.ToLower(value), "application/sdp") {
+				return strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n"), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// expectMedia expects the RTP and RTCP ports of the media the SDP body of a message of the
+// real destination offers, on the virtual destination
+func (a *sipALG) expectMedia(message []byte) []ALGExpectation {
+	lines, ok := sdpLines(message)
+	if !ok || !a.session.VirtualDest.Address.Family().IsIP() {
+		return nil
+	}
+	var expectations []ALGExpectation
+	for _, target := range sdpMedia(lines) {
+		for _, p := range []xnet.Port{target.Port, target.Port + 1} {
+			expectations = append(expectations, ALGExpectation{
+				VirtualDest: xnet.UDPDestination(a.session.VirtualDest.Address, p),
+				RealDest:    xnet.UDPDestination(target.Address, p),
+			})
+		}
+	}
+	return expectations
+}
+
+// rewriteSDP rewrites the connection addresses and media ports of an SDP body. The media the
+// client offers is relayed through RTP/RTCP pinholes.
+func (a *sipALG) rewriteSDP(body []byte, uplink bool) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	media := sdpMedia(lines)
+
+	announced := a.localIP
+	if !uplink {
+		announced = a.session.VirtualDest.Address.IP()
+	}
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "c=") && announced != nil:
+			family := "IP4"
+			if announced.To4() == nil {
+				family = "IP6"
+			}
+			lines[i] = "c=IN " + family + " " + announced.String()
+		case strings.HasPrefix(line, "m=") && uplink:
+			target, found := media[i]
+			if !found {
+				continue
+			}
+			// Only media of the client itself is relayed
+			if source := a.session.VirtualSource.Address; a.localIP == nil || (source != nil && addressString(source) != addressString(target.Address)) {
+				continue
+			}
+			pinhole, err := a.handler.openPinholePair(a.ctx, a.localIP, target, a.session.RuleID)
+			if err != nil {
+				errors.LogWarningInner(a.ctx, err, "NAT: SIP ALG failed to open media pinholes for ", target)
+				continue
+			}
+			fields := strings.Fields(line)
+			_, count, _ := strings.Cut(fields[1], "/")
+			fields[1] = strconv.Itoa(pinhole.Port)
+			if count != "" {
+				fields[1] += "/" + count
+			}
+			lines[i] = strings.Join(fields, " ")
+		}
+	}
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+// sipMessageLength frames the SIP messages of a stream by their Content-Length. It returns the
+// length of the message at the start of data, or -1 while its header is incomplete.
+func sipMessageLength(data []byte) int {
+	if data[0] == '\r' || data[0] == '\n' {
+		// Keepalives are empty lines between messages
+		return 1
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		return -1
+	}
+	length := 0
+	for _, line := range strings.Split(string(data[:end]), "\r\n")[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if lower := strings.ToLower(strings.TrimSpace(name)); ok && (lower == "content-length" || lower == "l") {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	return end + 4 + length
+}
@@ -0,0 +1,154 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func newSIPTestALG(t *testing.T) *sipALG {
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	natSession := handler.createNATSession(
+		xnet.UDPDestination(xnet.ParseAddress("127.0.0.1"), 5060),
+		xnet.UDPDestination(xnet.ParseAddress("240.2.2.22"), 5060),
+		xnet.UDPDestination(xnet.ParseAddress("192.168.2.22"), 5060),
+		"outbound", classInteractive)
+	natSession.RuleID = "pbx"
+	return &sipALG{handler: handler, ctx: context.Background(), session: natSession, localIP: net.ParseIP("127.0.0.1"), localPort: 5070}
+}
+
+func sipMessage(startLine string, headers []string, sdp string) string {
+	if sdp != "" {
+		headers = append(headers, "Content-Type: application/sdp")
+	}
+	headers = append(headers, "Content-Length: "+strconv.Itoa(len(sdp)))
+	return startLine + "\r\n" + strings.Join(headers, "\r\n") + "\r\n\r\n" + sdp
+}
+
+func TestSIPResponseRewriting(t *testing.T) {
+	alg := newSIPTestALG(t)
+	response := sipMessage("SIP/2.0 200 OK", []string{
+		"Via: SIP/2.0/UDP 127.0.0.1:5060;branch=z9hG4bK1",
+		"Contact: <sip:100@192.168.2.22:5060>",
+	}, "v=0\r\no=pbx 1 1 IN IP4 192.168.2.22\r\nc=IN IP4 192.168.2.22\r\nm=audio 30000 RTP/AVP 0\r\n")
+
+	rewritten := string(alg.rewriteResponse([]byte(response)))
+	if !strings.Contains(rewritten, "Contact: <sip:100@240.2.2.22:5060>") {
+		t.Errorf("Expected the contact to announce the virtual destination:\n%s", rewritten)
+	}
+	if !strings.Contains(rewritten, "c=IN IP4 240.2.2.22\r\n") || !strings.Contains(rewritten, "m=audio 30000 RTP/AVP 0") {
+		t.Errorf("Expected the media to be announced on the virtual destination:\n%s", rewritten)
+	}
+	_, body, _ := strings.Cut(rewritten, "\r\n\r\n")
+	if !strings.Contains(rewritten, "Content-Length: "+strconv.Itoa(len(body))+"\r\n") {
+		t.Errorf("Expected the content length to match the rewritten body:\n%s", rewritten)
+	}
+
+	for _, expectation := range alg.expectMedia([]byte(response)) {
+		alg.handler.expect(alg.session, expectation.VirtualDest, expectation.RealDest)
+	}
+	for _, port := range []xnet.Port{30000, 30001} {
+		value, ok := alg.handler.expectations.Load(xnet.UDPDestination(xnet.ParseAddress("240.2.2.22"), port).String())
+		if !ok || value.(*NATSession).RealDest.String() != "udp:192.168.2.22:"+port.String() {
+			t.Errorf("Expected the RTP/RTCP port %d to be expected", port)
+		}
+	}
+}
+
+func TestSIPRequestRewriting(t *testing.T) {
+	alg := newSIPTestALG(t)
+	media, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer media.Close()
+	mediaPort := media.LocalAddr().(*net.UDPAddr).Port
+
+	request := sipMessage("INVITE sip:100@240.2.2.22 SIP/2.0", []string{
+		"Via: SIP/2.0/UDP 127.0.0.1:5060;branch=z9hG4bK2",
+		"Contact: <sip:200@127.0.0.1:5060>",
+	}, "v=0\r\nc=IN IP4 127.0.0.1\r\nm=audio "+strconv.Itoa(mediaPort)+" RTP/AVP 0\r\n")
+
+	rewritten := string(alg.rewriteRequest([]byte(request)))
+	if !strings.HasPrefix(rewritten, "INVITE sip:100@192.168.2.22 SIP/2.0\r\n") {
+		t.Errorf("Expected the request URI to address the real destination:\n%s", rewritten)
+	}
+	if !strings.Contains(rewritten, "Via: SIP/2.0/UDP 127.0.0.1:5070;") || !strings.Contains(rewritten, "<sip:200@127.0.0.1:5070>") {
+		t.Errorf("Expected Via and Contact to announce the local address of the flow:\n%s", rewritten)
+	}
+
+	var pinhole int
+	for _, line := range strings.Split(rewritten, "\r\n") {
+		if strings.HasPrefix(line, "m=audio ") {
+			pinhole, _ = strconv.Atoi(strings.Fields(line)[1])
+		}
+	}
+	if pinhole == 0 || pinhole == mediaPort || pinhole%2 != 0 {
+		t.Fatalf("Expected the media port to be replaced by an even pinhole port:\n%s", rewritten)
+	}
+
+	// RTP of the real side reaches the client through the pinhole
+	peer, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: pinhole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	peer.Write([]byte("rtp"))
+	packet := make([]byte, 16)
+	media.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := media.ReadFromUDP(packet)
+	if err != nil || string(packet[:n]) != "rtp" {
+		t.Fatalf("Expected RTP to be relayed to the client, got %q: %v", packet[:n], err)
+	}
+	media.WriteToUDP([]byte("back"), from)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := peer.Read(packet); err != nil || string(packet[:n]) != "back" {
+		t.Errorf("Expected the client's RTP to return through the pinhole, got %q: %v", packet[:n], err)
+	}
+}
+
+func TestSIPStreamFraming(t *testing.T) {
+	reader, writer := pipe.New()
+	var messages []string
+	sip := newMessageReader(reader, true, sipMessageLength, func(message []byte) []byte {
+		if strings.TrimSpace(string(message)) != "" {
+			messages = append(messages, string(message))
+		}
+		return message
+	})
+
+	first := sipMessage("OPTIONS sip:100@240.2.2.22 SIP/2.0", nil, "")
+	second := sipMessage("MESSAGE sip:100@240.2.2.22 SIP/2.0", nil, "hello")
+	stream := first + "\r\n\r\n" + second
+	for _, chunk := range []string{stream[:10], stream[10 : len(first)+6], stream[len(first)+6 : len(stream)-2], stream[len(stream)-2:]} {
+		writer.WriteMultiBuffer(buf.MergeBytes(nil, []byte(chunk)))
+	}
+	writer.Close()
+
+	var out strings.Builder
+	for {
+		mb, err := sip.ReadMultiBuffer()
+		out.WriteString(mb.String())
+		buf.ReleaseMulti(mb)
+		if err != nil {
+			break
+		}
+	}
+	if out.String() != stream {
+		t.Errorf("Expected the stream to pass unchanged, got %q", out.String())
+	}
+	if len(messages) != 2 || messages[0] != first || messages[1] != second {
+		t.Errorf("Expected two framed messages, got %q", messages)
+	}
+}
@@ -0,0 +1,121 @@
+package nat
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"google.golang.org/protobuf/proto"
+)
+
+// IPv6 flow labels of the real-side connections of a rule
+const (
+	// flowLabelAuto has the system give each connection a label hashed from its endpoints
+	flowLabelAuto = "auto"
+	// flowLabelNone sends the packets without a label
+	flowLabelNone = "none"
+)
+
+// ValidateFlowLabel checks the flowLabel of a rule or range
+func ValidateFlowLabel(label string) error {
+	switch label {
+	case "", flowLabelAuto, flowLabelNone:
+		return nil
+	default:
+		return errors.New("unknown flowLabel ", label)
+	}
+}
+
+// ruleSockopt returns the socket options the flows of a rule are dialed with, nil when the rule
+// sets none. The TOS byte is set with custom socket options, IP_TOS on IPv4 sockets and
+// IPV6_TCLASS on IPv6 ones, as are the flow label, the MSS clamp and the mtu policy.
+func ruleSockopt(rule *NATRule) *internet.SocketConfig {
+	if rule.Sockopt == nil && rule.Tos == 0 && !rule.PreserveTos && rule.FlowLabel == "" && rule.TcpMssClamp == 0 && rule.Mtu == 0 {
+		return nil
+	}
+	sockopt := &internet.SocketConfig{}
+	if rule.Sockopt != nil {
+		sockopt = proto.Clone(rule.Sockopt).(*internet.SocketConfig)
+	}
+	if rule.Tos != 0 {
+		sockopt.CustomSockopt = append(sockopt.CustomSockopt, tosSockopts(rule.Tos)...)
+	}
+	if rule.FlowLabel != "" {
+		// IPV6_AUTOFLOWLABEL
+		auto := "0"
+		if rule.FlowLabel == flowLabelAuto {
+			auto = "1"
+		}
+		for _, network := range []string{"tcp6", "udp6"} {
+			sockopt.CustomSockopt = append(sockopt.CustomSockopt, &internet.CustomSockopt{System: "linux", Network: network, Level: "41", Opt: "70", Value: auto, Type: "int"})
+		}
+	}
+	sockopt.CustomSockopt = append(sockopt.CustomSockopt, mtuSockopts(rule)...)
+	return sockopt
+}
+
+// tosSockopts returns the custom socket options setting the TOS byte of IPv4 and IPv6 sockets
+func tosSockopts(value uint32) []*internet.CustomSockopt {
+	tos := strconv.FormatUint(uint64(value), 10)
+	var options []*internet.CustomSockopt
+	for _, network := range []string{"tcp4", "udp4"} {
+		options = append(options, &internet.CustomSockopt{System: "linux", Network: network, Level: "0", Opt: "1", Value: tos, Type: "int"})
+	}
+	for _, network := range []string{"tcp6", "udp6"} {
+		options = append(options, &internet.CustomSockopt{System: "linux", Network: network, Level: "41", Opt: "67", Value: tos, Type: "int"})
+	}
+	return options
+}
+
+// flowSockopt returns the socket options a flow of a rule is dialed with: those of the rule,
+// with the TOS byte of the client's connection when the rule preserves it and it is known.
+// Later custom socket options win, so it takes the place of the tos of the rule.
+func flowSockopt(ctx context.Context, rule *NATRule) *internet.SocketConfig {
+	sockopt := ruleSockopt(rule)
+	if sockopt == nil || !rule.PreserveTos {
+		return sockopt
+	}
+	inbound := session.InboundFromContext(ctx)
+	if inbound == nil || inbound.Conn == nil {
+		return sockopt
+	}
+	if tos, ok := connTOS(inbound.Conn); ok {
+		sockopt.CustomSockopt = append(sockopt.CustomSockopt, tosSockopts(tos)...)
+	}
+	return sockopt
+}
+
+// boundSockopt returns the socket options of a flow with its connection bound to source, the
+// endpoint CGNAT or masquerading allocated to its session, so the real destination sees that
+// port rather than one the system picks. The dial fails when the endpoint cannot be bound.
+func boundSockopt(sockopt *internet.SocketConfig, source xnet.Destination) *internet.SocketConfig {
+	if sockopt == nil {
+		sockopt = &internet.SocketConfig{}
+	}
+	sockopt.BindAddress = source.Address.IP()
+	sockopt.BindPort = uint32(source.Port)
+	return sockopt
+}
+
+// sockoptDialer dials the real destinations of a rule with its socket options through the
+// system dialer, so flows to different real networks can leave through different uplinks.
+type sockoptDialer struct {
+	sockopt *internet.SocketConfig
+}
+
+// Dial implements internet.Dialer
+func (d sockoptDialer) Dial(ctx context.Context, destination xnet.Destination) (stat.Connection, error) {
+	return internet.DialSystem(ctx, destination, d.sockopt)
+}
+
+// DestIpAddress implements internet.Dialer
+func (sockoptDialer) DestIpAddress() xnet.IP {
+	return nil
+}
+
+// SetOutboundGateway implements internet.Dialer
+func (sockoptDialer) SetOutboundGateway(ctx context.Context, ob *session.Outbound) {}
@@ -0,0 +1,140 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestRuleSockopt(t *testing.T) {
+	if sockopt := ruleSockopt(&NATRule{RuleId: "plain"}); sockopt != nil {
+		t.Errorf("Expected no socket options for a rule without any, got %v", sockopt)
+	}
+
+	rule := &NATRule{RuleId: "uplink-b", Sockopt: &internet.SocketConfig{Mark: 100, Interface: "eth1"}, Tos: 0xb8}
+	sockopt := ruleSockopt(rule)
+	if sockopt.Mark != 100 || sockopt.Interface != "eth1" {
+		t.Errorf("Expected the socket options of the rule, got %v", sockopt)
+	}
+	if len(sockopt.CustomSockopt) != 4 {
+		t.Fatalf("Expected IP_TOS and IPV6_TCLASS for TCP and UDP, got %v", sockopt.CustomSockopt)
+	}
+	for _, custom := range sockopt.CustomSockopt {
+		if custom.Value != "184" || custom.Type != "int" || custom.System != "linux" {
+			t.Errorf("Unexpected TOS socket option %v", custom)
+		}
+	}
+	if len(rule.Sockopt.CustomSockopt) != 0 {
+		t.Error("The socket options of the rule must not be modified")
+	}
+}
+
+func TestRuleSockoptFlowLabel(t *testing.T) {
+	for label, value := range map[string]string{flowLabelAuto: "1", flowLabelNone: "0"} {
+		sockopt := ruleSockopt(&NATRule{RuleId: "wan", FlowLabel: label})
+		if len(sockopt.CustomSockopt) != 2 {
+			t.Fatalf("Expected IPV6_AUTOFLOWLABEL for TCP and UDP, got %v", sockopt.CustomSockopt)
+		}
+		for _, custom := range sockopt.CustomSockopt {
+			if custom.Level != "41" || custom.Opt != "70" || custom.Value != value || custom.Network[3] != '6' {
+				t.Errorf("Unexpected flow label socket option %v for %s", custom, label)
+			}
+		}
+	}
+	if err := ValidateFlowLabel("0x12345"); err == nil {
+		t.Error("Expected error for an explicit flow label")
+	}
+}
+
+func TestRangeRuleSockopt(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		VirtualRanges: []*VirtualIPRange{{
+			NpTv6VirtualPrefix: "fd01:203:405:1::/64",
+			NpTv6RealPrefix:    "2001:db8:1:1::/64",
+			Tos:                0x2e << 2,
+			PreserveTos:        true,
+			FlowLabel:          flowLabelAuto,
+		}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	destination := xnet.TCPDestination(xnet.ParseAddress("fd01:203:405:1::20"), 443)
+	rule, ok := handler.shouldApplyNAT(context.Background(), destination)
+	if !ok {
+		t.Fatal("Expected the NPTv6 range to match")
+	}
+	if rule.Tos != 0xb8 || !rule.PreserveTos || rule.FlowLabel != flowLabelAuto {
+		t.Errorf("Expected the range rule to carry the tos and flow label of the range, got %v", rule)
+	}
+	if sockopt := ruleSockopt(rule); len(sockopt.CustomSockopt) != 6 {
+		t.Errorf("Expected the TOS and flow label socket options, got %v", sockopt.CustomSockopt)
+	}
+}
+
+func TestRuleSockoptDialsDirectly(t *testing.T) {
+	listener := listenTCP(t)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	rule := &NATRule{
+		RuleId:             "uplink-b",
+		VirtualDestination: "240.2.2.1",
+		RealDestination:    "127.0.0.1",
+		Sockopt:            &internet.SocketConfig{},
+		Tos:                0x20,
+	}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "127.0.0.0/24"}},
+		Rules:         []*NATRule{rule},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.5"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		// The outbound transport refuses every flow; the rule must not use it
+		done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, externalDialer{}, rule)
+	}()
+
+	request := buf.New()
+	request.WriteString("ping")
+	uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{request})
+	mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected the flow to be dialed with the socket options of the rule: %v", err)
+	}
+	if mb.String() != "ping" {
+		t.Errorf("Unexpected echo %q", mb.String())
+	}
+	buf.ReleaseMulti(mb)
+
+	uplinkWriter.Close()
+	<-done
+}
@@ -0,0 +1,112 @@
+package nat
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/features/stats"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// spliceTouchInterval is how often a session relayed with splice is marked active, as a splice
+// only reports its traffic once it ends
+const spliceTouchInterval = 30 * time.Second
+
+// canSplice reports whether the downlink of a TCP session of rule may be spliced: splicing is
+// not disabled, no ALG may rewrite the flow, no rate limit paces it and the real connection is
+// plain TCP
+func (h *Handler) canSplice(rule *NATRule, conn stat.Connection) bool {
+	return !h.config.GetDisableSplice() && len(rule.Alg) == 0 && rule.RateLimit.GetDownlinkBytesPerSecond() == 0 &&
+		proxy.IsRAWTransportWithoutSecurity(conn)
+}
+
+// copyDownlinkSplice relays the downlink of an established TCP session with
+// proxy.CopyRawConnIfExist, which on Linux splices it from the real connection into the inbound
+// connection once every hop of the flow allows, as Freedom does, and reads it with readv
+// otherwise. The traffic is counted against the session either way; the session counts as active
+// while the flow is open.
+func (h *Handler) copyDownlinkSplice(ctx context.Context, natSession *NATSession, conn stat.Connection, writer buf.Writer, timer *signal.ActivityTimer) error {
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		outbounds[len(outbounds)-1].CanSpliceCopy = 1
+	}
+	var inboundConn stat.Connection
+	var inboundTimer *signal.ActivityTimer
+	if inbound := session.InboundFromContext(ctx); inbound != nil && inbound.Conn != nil {
+		inboundConn = inbound.Conn
+		inboundTimer = inbound.Timer
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(spliceTouchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				natSession.touch()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	counter := &downlinkCounter{handler: h, session: natSession}
+	return proxy.CopyRawConnIfExist(ctx, countDownlink(conn, counter), inboundConn, writer, timer, inboundTimer)
+}
+
+// countDownlink has the bytes read from conn counted by counter too, keeping the counter of a
+// connection counted already
+func countDownlink(conn stat.Connection, counter stats.Counter) stat.Connection {
+	if counted, ok := conn.(*stat.CounterConnection); ok {
+		if counted.ReadCounter != nil {
+			counter = counterPair{counted.ReadCounter, counter}
+		}
+		return &stat.CounterConnection{Connection: counted.Connection, ReadCounter: counter, WriteCounter: counted.WriteCounter}
+	}
+	return &stat.CounterConnection{Connection: conn, ReadCounter: counter}
+}
+
+// downlinkCounter counts what it is added against the downlink of a session, each addition as
+// one packet
+type downlinkCounter struct {
+	handler *Handler
+	session *NATSession
+}
+
+func (c *downlinkCounter) Value() int64 {
+	return c.session.counters.downlinkBytes.Load()
+}
+
+func (c *downlinkCounter) Set(int64) int64 {
+	return c.Value()
+}
+
+func (c *downlinkCounter) Add(delta int64) int64 {
+	previous := c.Value()
+	c.session.record(false, delta, 1)
+	atomic.AddInt64(&c.handler.totalBytes, delta)
+	return previous
+}
+
+// counterPair adds to two counters, reporting the first
+type counterPair [2]stats.Counter
+
+func (p counterPair) Value() int64 {
+	return p[0].Value()
+}
+
+func (p counterPair) Set(value int64) int64 {
+	p[1].Set(value)
+	return p[0].Set(value)
+}
+
+func (p counterPair) Add(delta int64) int64 {
+	p[1].Add(delta)
+	return p[0].Add(delta)
+}
@@ -0,0 +1,99 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func TestSplice(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		testSplice(t, disabled)
+	}
+}
+
+func testSplice(t *testing.T, disabled bool) {
+	listener := listenTCP(t)
+	rule := &NATRule{RuleId: "internal", VirtualDestination: "240.2.2.1", RealDestination: "127.0.0.1"}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "127.0.0.0/24"}},
+		Rules:         []*NATRule{rule},
+		DisableSplice: disabled,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The inbound connection of a client the reply may be spliced into
+	clients := listenTCP(t)
+	client, err := net.Dial("tcp", clients.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	inboundConn, err := clients.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inboundConn.Close()
+
+	source := xnet.TCPDestination(xnet.ParseAddress("127.0.0.5"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source, Conn: inboundConn, CanSpliceCopy: 1})
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{}})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, tcpTestDialer{}, rule)
+	}()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	natSession, ok := handler.LookupSession(NewFiveTuple(source, destination))
+	if !ok {
+		t.Fatal("Expected a session for the flow")
+	}
+
+	io.WriteString(server, "pong")
+	server.(*net.TCPConn).CloseWrite()
+	var reply []byte
+	if runtime.GOOS == "linux" && !disabled {
+		// Spliced into the inbound connection, past the link
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reply = make([]byte, 4)
+		_, err = io.ReadFull(client, reply)
+	} else {
+		mb, readErr := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+		reply, err = []byte(mb.String()), readErr
+	}
+	if err != nil || string(reply) != "pong" {
+		t.Fatalf("Expected the reply, got %q, %v", reply, err)
+	}
+
+	uplinkWriter.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean end, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the relay to end")
+	}
+	if stats := natSession.Stats(); stats.DownlinkBytes != 4 {
+		t.Errorf("Expected the reply to be counted against the session, got %+v", stats)
+	}
+}
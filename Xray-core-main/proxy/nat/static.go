@@ -0,0 +1,61 @@
+package nat
+
+import (
+	"net"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// buildStaticRules synthesizes the rules of one-to-one static mappings: forward rules translate the
+// virtual address to the real one, reverse rules translate the real address back to the virtual one.
+func buildStaticRules(mappings []*StaticMapping) (forward, reverse []*NATRule, err error) {
+	virtuals := make(map[string]bool)
+	reals := make(map[string]bool)
+
+	for _, mapping := range mappings {
+		virtualIP := net.ParseIP(mapping.VirtualAddress)
+		realIP := net.ParseIP(mapping.RealAddress)
+		if virtualIP == nil || realIP == nil {
+			return nil, nil, errors.New("invalid static mapping ", mapping.VirtualAddress, " <-> ", mapping.RealAddress)
+		}
+		if (virtualIP.To4() == nil) != (realIP.To4() == nil) {
+			return nil, nil, errors.New("static mapping ", mapping.VirtualAddress, " <-> ", mapping.RealAddress, " mixes address families")
+		}
+		if virtuals[virtualIP.String()] || reals[realIP.String()] {
+			return nil, nil, errors.New("static mapping ", mapping.VirtualAddress, " <-> ", mapping.RealAddress, " overlaps another mapping")
+		}
+		virtuals[virtualIP.String()] = true
+		reals[realIP.String()] = true
+
+		forward = append(forward, &NATRule{
+			RuleId:             "static-" + virtualIP.String(),
+			VirtualDestination: virtualIP.String(),
+			RealDestination:    realIP.String(),
+			Protocol:           "tcp,udp",
+		})
+		reverse = append(reverse, &NATRule{
+			RuleId:             "static-reverse-" + realIP.String(),
+			VirtualDestination: realIP.String(),
+			RealDestination:    virtualIP.String(),
+			Protocol:           "tcp,udp",
+		})
+	}
+	return forward, reverse, nil
+}
+
+// staticVirtualAddress returns the virtual address a real address is statically mapped to
+func (h *Handler) staticVirtualAddress(ip net.IP) (net.IP, bool) {
+	for _, rule := range h.staticReverse {
+		if net.ParseIP(rule.VirtualDestination).Equal(ip) {
+			return net.ParseIP(rule.RealDestination), true
+		}
+	}
+	return nil, false
+}
+
+// ValidateStaticMappings checks that every mapping pairs two addresses of the same family and
+// that no virtual or real address appears in more than one mapping
+func ValidateStaticMappings(mappings []*StaticMapping) error {
+	_, _, err := buildStaticRules(mappings)
+	return err
+}
@@ -0,0 +1,68 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestStaticMappings(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		StaticMappings: []*StaticMapping{
+			{VirtualAddress: "240.2.2.20", RealAddress: "192.168.1.20"},
+			{VirtualAddress: "fd00::20", RealAddress: "2001:db8::20"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 443)
+	rule, ok := handler.shouldApplyNAT(context.Background(), destination)
+	if !ok {
+		t.Fatal("Expected the static mapping to apply")
+	}
+	transformed, err := handler.applyDNAT(destination, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transformed.Address.String() != "192.168.1.20" || transformed.Port != 443 {
+		t.Errorf("Expected 192.168.1.20:443, got %v", transformed)
+	}
+
+	if _, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress("240.2.2.21"), 443)); ok {
+		t.Error("Unmapped addresses must not match a static mapping")
+	}
+
+	for real, virtual := range map[string]string{"192.168.1.20": "240.2.2.20", "2001:db8::20": "fd00::20"} {
+		ip, ok := handler.virtualAddressOf(net.ParseIP(real))
+		if !ok || !ip.Equal(net.ParseIP(virtual)) {
+			t.Errorf("Expected reverse mapping of %s to %s, got %v", real, virtual, ip)
+		}
+	}
+}
+
+func TestStaticMappingsRejectOverlap(t *testing.T) {
+	invalid := [][]*StaticMapping{
+		{
+			{VirtualAddress: "240.2.2.20", RealAddress: "192.168.1.20"},
+			{VirtualAddress: "240.2.2.20", RealAddress: "192.168.1.21"},
+		},
+		{
+			{VirtualAddress: "240.2.2.20", RealAddress: "192.168.1.20"},
+			{VirtualAddress: "240.2.2.21", RealAddress: "192.168.1.20"},
+		},
+		{
+			{VirtualAddress: "240.2.2.20", RealAddress: "2001:db8::20"},
+		},
+	}
+	for i, mappings := range invalid {
+		if _, _, err := buildStaticRules(mappings); err == nil {
+			t.Errorf("Expected mapping set %d to be rejected", i)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package nat
+
+import (
+	"github.com/xtls/xray-core/features/stats"
+)
+
+// attachStatsCounters registers the traffic counters of the session's rule with the stats manager,
+// as nat>>>ruleId>>>traffic>>>uplink and downlink, when the policy of the configured user level asks for them.
+// Rules with traffic counters also count their flows as nat>>>ruleId>>>hits, their active sessions as
+// nat>>>ruleId>>>sessions and the Unix time of their last flow as nat>>>ruleId>>>lasthit.
+func (h *Handler) attachStatsCounters(session *NATSession) {
+	if h.statsManager == nil || h.policyManager == nil || session.RuleID == "" {
+		return
+	}
+
+	p := h.policyManager.ForLevel(h.config.UserLevel)
+	if p.Stats.UserUplink {
+		name := "nat>>>" + session.RuleID + ">>>traffic>>>uplink"
+		if c, _ := stats.GetOrRegisterCounter(h.statsManager, name); c != nil {
+			session.uplinkCounter = c
+		}
+	}
+	if p.Stats.UserDownlink {
+		name := "nat>>>" + session.RuleID + ">>>traffic>>>downlink"
+		if c, _ := stats.GetOrRegisterCounter(h.statsManager, name); c != nil {
+			session.downlinkCounter = c
+		}
+	}
+	if p.Stats.UserUplink || p.Stats.UserDownlink {
+		prefix := "nat>>>" + session.RuleID + ">>>"
+		if c, _ := stats.GetOrRegisterCounter(h.statsManager, prefix+"hits"); c != nil {
+			c.Add(1)
+		}
+		if c, _ := stats.GetOrRegisterCounter(h.statsManager, prefix+"sessions"); c != nil {
+			c.Add(1)
+			session.sessionsCounter = c
+		}
+		if c, _ := stats.GetOrRegisterCounter(h.statsManager, prefix+"lasthit"); c != nil {
+			c.Set(session.CreatedAt.Unix())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xtls/xray-core/app/stats"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/features/policy"
+)
+
+// levelPolicy is a policy manager returning the same session policy for every level
+type levelPolicy struct {
+	session policy.Session
+}
+
+func (p levelPolicy) Type() interface{}                    { return policy.ManagerType() }
+func (p levelPolicy) Start() error                         { return nil }
+func (p levelPolicy) Close() error                         { return nil }
+func (p levelPolicy) ForLevel(level uint32) policy.Session { return p.session }
+func (p levelPolicy) ForSystem() policy.System             { return policy.System{} }
+
+func TestStatsManagerCounters(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := policy.SessionDefault()
+	session.Stats.UserUplink = true
+
+	handler := New()
+	defer handler.Close()
+	handler.statsManager = manager
+	if err := handler.Init(&Config{SiteId: "test-site"}, levelPolicy{session: session}); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	natSession := handler.createNATSession(xnet.Destination{}, dest, dest, "outbound", classInteractive)
+	natSession.RuleID = "web"
+	handler.attachStatsCounters(natSession)
+	natSession.record(true, 100, 1)
+	natSession.record(false, 300, 1)
+
+	uplink := manager.GetCounter("nat>>>web>>>traffic>>>uplink")
+	if uplink == nil || uplink.Value() != 100 {
+		t.Errorf("Expected the uplink counter to be registered and count 100 bytes, got %v", uplink)
+	}
+	if manager.GetCounter("nat>>>web>>>traffic>>>downlink") != nil {
+		t.Error("Downlink counter must not be registered when disabled by policy")
+	}
+	if hits := manager.GetCounter("nat>>>web>>>hits"); hits == nil || hits.Value() != 1 {
+		t.Errorf("Expected the hit counter to count the session, got %v", hits)
+	}
+	sessions := manager.GetCounter("nat>>>web>>>sessions")
+	if sessions == nil || sessions.Value() != 1 {
+		t.Errorf("Expected the session counter to count the session, got %v", sessions)
+	}
+	if lastHit := manager.GetCounter("nat>>>web>>>lasthit"); lastHit == nil || lastHit.Value() != natSession.CreatedAt.Unix() {
+		t.Errorf("Expected the last hit counter to hold the session creation, got %v", lastHit)
+	}
+	handler.removeSession(natSession.SessionID)
+	if sessions.Value() != 0 {
+		t.Errorf("Expected the session counter to drop with the session, got %d", sessions.Value())
+	}
+}
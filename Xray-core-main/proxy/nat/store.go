@@ -0,0 +1,339 @@
+package nat
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// sharingQueueSize bounds the session writes waiting for the store; when it overflows the
+// sessions are all written again at the next sync
+const sharingQueueSize = 4096
+
+// SessionStore holds the sessions gateways share, by the key of their flow tuple, so a flow that
+// anycast or ECMP moves to another gateway keeps its mapping. The session table of each gateway
+// stays in memory; the store carries the records of the sessions it translates itself.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Get returns the record under key and when it expires, or nil when there is none
+	Get(key string) (*SessionRecord, time.Time, error)
+	// Put stores the record under key until expireAt
+	Put(key string, record *SessionRecord, expireAt time.Time) error
+	// Delete removes the record under key
+	Delete(key string) error
+	// Range calls f with each unexpired record until it returns false
+	Range(f func(key string, record *SessionRecord, expireAt time.Time) bool) error
+	// ExpireAt moves the expiry of the record under key
+	ExpireAt(key string, expireAt time.Time) error
+}
+
+// memoryRecord is a record of a memorySessionStore
+type memoryRecord struct {
+	record   SessionRecord
+	expireAt time.Time
+}
+
+// memorySessionStore is a SessionStore in memory, shared by the handlers of one process
+type memorySessionStore struct {
+	sync.Mutex
+	records map[string]memoryRecord
+}
+
+// NewMemorySessionStore returns a SessionStore in memory, for handlers of the same process
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{records: make(map[string]memoryRecord)}
+}
+
+func (m *memorySessionStore) Get(key string) (*SessionRecord, time.Time, error) {
+	m.Lock()
+	defer m.Unlock()
+	entry, found := m.records[key]
+	if !found {
+		return nil, time.Time{}, nil
+	}
+	if !time.Now().Before(entry.expireAt) {
+		delete(m.records, key)
+		return nil, time.Time{}, nil
+	}
+	return &entry.record, entry.expireAt, nil
+}
+
+func (m *memorySessionStore) Put(key string, record *SessionRecord, expireAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	m.records[key] = memoryRecord{record: *record, expireAt: expireAt}
+	return nil
+}
+
+func (m *memorySessionStore) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+func (m *memorySessionStore) Range(f func(key string, record *SessionRecord, expireAt time.Time) bool) error {
+	m.Lock()
+	now := time.Now()
+	records := make(map[string]memoryRecord, len(m.records))
+	for key, entry := range m.records {
+		if now.Before(entry.expireAt) {
+			records[key] = entry
+		} else {
+			delete(m.records, key)
+		}
+	}
+	m.Unlock()
+
+	for key, entry := range records {
+		if !f(key, &entry.record, entry.expireAt) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) ExpireAt(key string, expireAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	if entry, found := m.records[key]; found {
+		entry.expireAt = expireAt
+		m.records[key] = entry
+	}
+	return nil
+}
+
+// sharedWrite is a session record waiting for the store; a nil record removes the record of the
+// session created at createdAt
+type sharedWrite struct {
+	key       string
+	sessionID string
+	createdAt time.Time
+	record    *SessionRecord
+	expireAt  time.Time
+}
+
+// sessionSharing writes the sessions the handler translates itself to a SessionStore and holds
+// the sessions of other gateways found there like sessions restored from a checkpoint, so a flow
+// that moves over adopts their CGNAT source port. Writes are queued off the data path.
+type sessionSharing struct {
+	handler  *Handler
+	store    SessionStore
+	interval time.Duration
+	writes   chan sharedWrite
+	resync   atomic.Bool // Set when writes were dropped
+	done     chan struct{}
+}
+
+func newSessionSharing(h *Handler, store SessionStore, interval time.Duration) *sessionSharing {
+	s := &sessionSharing{
+		handler:  h,
+		store:    store,
+		interval: interval,
+		writes:   make(chan sharedWrite, sharingQueueSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// enqueue queues a write without blocking the data path
+func (s *sessionSharing) enqueue(write sharedWrite) {
+	select {
+	case s.writes <- write:
+	default:
+		s.resync.Store(true)
+	}
+}
+
+// run applies the queued writes and periodically moves the expiry of the shared sessions that
+// saw activity, until the handler or the sharing closes
+func (s *sessionSharing) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case write := <-s.writes:
+			if err := s.apply(write); err != nil {
+				errors.LogWarningInner(context.Background(), err, "NAT: failed to share session ", write.sessionID)
+			}
+		case <-ticker.C:
+			if err := s.sync(); err != nil {
+				errors.LogWarningInner(context.Background(), err, "NAT: failed to sync shared sessions")
+			}
+		case <-s.done:
+			return
+		case <-s.handler.done:
+			return
+		}
+	}
+}
+
+// apply writes a record to the store. A removal leaves the record alone when another gateway
+// took the flow over meanwhile; session IDs repeat across gateways, creation times hardly.
+func (s *sessionSharing) apply(write sharedWrite) error {
+	if write.record != nil {
+		return s.store.Put(write.key, write.record, write.expireAt)
+	}
+	record, _, err := s.store.Get(write.key)
+	if err != nil || record == nil || record.SessionID != write.sessionID || !record.CreatedAt.Equal(write.createdAt) {
+		return err
+	}
+	return s.store.Delete(write.key)
+}
+
+// sync writes the shared sessions whose TCP state changed since they were last written, or all of
+// them after writes were dropped, and moves the expiry of those that only saw activity
+func (s *sessionSharing) sync() error {
+	resync := s.resync.Swap(false)
+	var err error
+	s.handler.sessions.Range(func(session *NATSession) bool {
+		if !shareable(session) {
+			return true
+		}
+		stamp := replicaStamp(session)
+		previous := session.sharedStamp.Swap(stamp)
+		switch {
+		case resync || previous&1 != stamp&1:
+			err = s.store.Put(session.Tuple.Key(), s.handler.sharedRecord(session), s.handler.sharedExpiry(session))
+		case previous != stamp:
+			err = s.store.ExpireAt(session.Tuple.Key(), s.handler.sharedExpiry(session))
+		}
+		return err == nil
+	})
+	return err
+}
+
+// Close stops writing to the store; queued writes are left to expire
+func (s *sessionSharing) Close() {
+	close(s.done)
+}
+
+// shareable reports whether a session is written to the shared store: an announced session
+// the handler translates itself, of a kind checkpoints carry too
+func shareable(s *NATSession) bool {
+	return s.announced.Load() && !s.restored.Load() && persistable(s)
+}
+
+// sharedRecord returns the record of a shared session
+func (h *Handler) sharedRecord(s *NATSession) *SessionRecord {
+	record := persistSession(s)
+	return &record
+}
+
+// sharedExpiry is when the record of a shared session expires unless it sees activity
+func (h *Handler) sharedExpiry(s *NATSession) time.Time {
+	return s.LastActivity().Add(h.sessionTimeout(s))
+}
+
+// UseSessionStore shares the sessions with other gateways through store, in place of the
+// configured one. The sessions already in the store are held at once. Call it after Init,
+// before the handler relays flows.
+func (h *Handler) UseSessionStore(store SessionStore) error {
+	if h.sharing != nil {
+		h.sharing.Close()
+	}
+	h.sharing = newSessionSharing(h, store, h.sharingInterval())
+	held, err := h.holdSharedSessions()
+	if err != nil {
+		return err
+	}
+	if held > 0 {
+		errors.LogInfo(context.Background(), "NAT: holding ", held, " sessions shared by other gateways")
+	}
+	return nil
+}
+
+// sharingInterval returns how often the expiry of the shared sessions is updated
+func (h *Handler) sharingInterval() time.Duration {
+	if interval := h.config.GetSharedSessions().GetSyncInterval(); interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+	return 10 * time.Second // Default 10 seconds
+}
+
+// shareSession queues the record of a newly announced session for the store
+func (h *Handler) shareSession(s *NATSession) {
+	if h.sharing == nil || !shareable(s) {
+		return
+	}
+	s.sharedStamp.Store(replicaStamp(s))
+	h.sharing.enqueue(sharedWrite{key: s.Tuple.Key(), sessionID: s.SessionID, record: h.sharedRecord(s), expireAt: h.sharedExpiry(s)})
+}
+
+// unshareSession queues the removal of the record of a retired session
+func (h *Handler) unshareSession(s *NATSession) {
+	if h.sharing == nil || s.restored.Load() || !persistable(s) {
+		return
+	}
+	h.sharing.enqueue(sharedWrite{key: s.Tuple.Key(), sessionID: s.SessionID, createdAt: s.CreatedAt})
+}
+
+// holdSharedSessions holds the sessions of the store the session table has room for and returns
+// how many; their CGNAT source ports are reserved so no new flow takes them
+func (h *Handler) holdSharedSessions() (int, error) {
+	held := 0
+	err := h.sharing.store.Range(func(key string, record *SessionRecord, expireAt time.Time) bool {
+		if atomic.LoadInt64(&h.activeSessions) >= h.maxSessions {
+			return false
+		}
+		if h.holdShared(record, expireAt) {
+			held++
+		}
+		return true
+	})
+	return held, err
+}
+
+// fetchSharedSession looks the flow of a new session up in the store and returns the session of
+// another gateway found there, with its CGNAT source port reserved for the new session to take
+// over, or nil
+func (h *Handler) fetchSharedSession(natSession *NATSession) *NATSession {
+	if h.sharing == nil || h.portBlocks == nil {
+		return nil
+	}
+	record, _, err := h.sharing.store.Get(natSession.Tuple.Key())
+	if err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: failed to look up shared session")
+		return nil
+	}
+	if record == nil {
+		return nil
+	}
+	previous, err := restoreSession(record)
+	if err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: skipped shared session ", record.SessionID)
+		return nil
+	}
+	// Fails as well for a record of a session of this handler, whose port is taken
+	if err := h.reserveSourcePort(previous); err != nil {
+		errors.LogDebugInner(context.Background(), err, "NAT: skipped shared session ", record.SessionID)
+		return nil
+	}
+	return previous
+}
+
+// holdShared holds a session of another gateway until a flow adopts it or it expires. Its
+// activity is moved up to what its expiry in the store implies.
+func (h *Handler) holdShared(record *SessionRecord, expireAt time.Time) bool {
+	if _, found := h.sessions.Load(record.SessionID); found {
+		return false
+	}
+	natSession, err := restoreSession(record)
+	if err != nil {
+		errors.LogWarningInner(context.Background(), err, "NAT: skipped shared session ", record.SessionID)
+		return false
+	}
+	if active := expireAt.Add(-h.sessionTimeout(natSession)); active.After(natSession.LastActivity()) {
+		natSession.counters.lastActivity.Store(active.UnixNano())
+	}
+	stored, err := h.storeRestored(natSession, time.Now())
+	if err != nil {
+		errors.LogDebugInner(context.Background(), err, "NAT: skipped shared session ", record.SessionID)
+		return false
+	}
+	return stored
+}
@@ -0,0 +1,182 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// defaultSharedKeyPrefix prefixes the Redis keys of shared sessions unless configured otherwise.
+// Unlike checkpoints the keys are not per site, as the gateways sharing them are one site to
+// their clients.
+const defaultSharedKeyPrefix = "xray:nat:sessions:"
+
+// redisScanCount is how many keys a SCAN of the shared sessions asks for at a time
+const redisScanCount = "100"
+
+// redisSessionStore is a SessionStore on a Redis server. Each session is a JSON record under the
+// key prefix and its flow tuple key, expiring with the session. The connection is kept open and
+// dialed again after an error.
+type redisSessionStore struct {
+	sync.Mutex
+	address  string
+	password string
+	prefix   string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func newRedisSessionStore(config *SharedSessions) (*redisSessionStore, error) {
+	if _, _, err := net.SplitHostPort(config.RedisAddress); err != nil {
+		return nil, errors.New("invalid Redis address ", config.RedisAddress).Base(err)
+	}
+	prefix := config.KeyPrefix
+	if prefix == "" {
+		prefix = defaultSharedKeyPrefix
+	}
+	return &redisSessionStore{address: config.RedisAddress, password: config.RedisPassword, prefix: prefix}, nil
+}
+
+func (r *redisSessionStore) String() string {
+	return "redis://" + r.address + "/" + r.prefix
+}
+
+// command runs a command, dialing and authenticating first when no connection is open
+func (r *redisSessionStore) command(args ...string) (any, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout("tcp", r.address, redisTimeout)
+		if err != nil {
+			return nil, err
+		}
+		r.conn, r.reader = conn, bufio.NewReader(conn)
+		if r.password != "" {
+			r.conn.SetDeadline(time.Now().Add(redisTimeout))
+			if _, err := redisRoundTrip(r.conn, r.reader, "AUTH", r.password); err != nil {
+				r.closeConn()
+				return nil, errors.New("Redis authentication failed").Base(err)
+			}
+		}
+	}
+	r.conn.SetDeadline(time.Now().Add(redisTimeout))
+	reply, err := redisExchange(r.conn, r.reader, args...)
+	if err != nil {
+		// The reply may be left half read
+		r.closeConn()
+	}
+	return reply, err
+}
+
+func (r *redisSessionStore) closeConn() {
+	r.conn.Close()
+	r.conn, r.reader = nil, nil
+}
+
+func (r *redisSessionStore) Get(key string) (*SessionRecord, time.Time, error) {
+	reply, err := r.command("GET", r.prefix+key)
+	if err != nil || reply == nil {
+		return nil, time.Time{}, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, time.Time{}, errors.New("unexpected Redis reply to GET")
+	}
+	var record SessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, time.Time{}, errors.New("invalid shared session ", key).Base(err)
+	}
+
+	reply, err = r.command("PTTL", r.prefix+key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, _ = reply.([]byte)
+	ttl, err := strconv.ParseInt(string(data), 10, 64)
+	switch {
+	case err != nil:
+		return nil, time.Time{}, errors.New("unexpected Redis reply to PTTL")
+	case ttl == -2:
+		// Expired since the GET
+		return nil, time.Time{}, nil
+	case ttl < 0:
+		// No expiry, left to the activity of the record
+		return &record, time.Time{}, nil
+	}
+	return &record, time.Now().Add(time.Duration(ttl) * time.Millisecond), nil
+}
+
+func (r *redisSessionStore) Put(key string, record *SessionRecord, expireAt time.Time) error {
+	ttl := time.Until(expireAt).Milliseconds()
+	if ttl <= 0 {
+		return r.Delete(key)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.command("SET", r.prefix+key, string(data), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (r *redisSessionStore) Delete(key string) error {
+	_, err := r.command("DEL", r.prefix+key)
+	return err
+}
+
+func (r *redisSessionStore) ExpireAt(key string, expireAt time.Time) error {
+	_, err := r.command("PEXPIREAT", r.prefix+key, strconv.FormatInt(expireAt.UnixMilli(), 10))
+	return err
+}
+
+// Range scans the keys under the prefix. Records that expire during the scan are skipped, and
+// records written meanwhile may be missed.
+func (r *redisSessionStore) Range(f func(key string, record *SessionRecord, expireAt time.Time) bool) error {
+	pattern := escapeRedisPattern(r.prefix) + "*"
+	cursor := "0"
+	for {
+		reply, err := r.command("SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount)
+		if err != nil {
+			return err
+		}
+		elements, _ := reply.([]any)
+		if len(elements) != 2 {
+			return errors.New("unexpected Redis reply to SCAN")
+		}
+		next, _ := elements[0].([]byte)
+		keys, _ := elements[1].([]any)
+		for _, element := range keys {
+			name, _ := element.([]byte)
+			key := strings.TrimPrefix(string(name), r.prefix)
+			record, expireAt, err := r.Get(key)
+			if err != nil {
+				return err
+			}
+			if record != nil && !f(key, record, expireAt) {
+				return nil
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// escapeRedisPattern escapes the glob characters of a key prefix for MATCH
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
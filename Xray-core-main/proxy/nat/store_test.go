@@ -0,0 +1,155 @@
+package nat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// waitForRecord waits until the store holds a record of the session ID under key, or none when
+// sessionID is empty
+func waitForRecord(t *testing.T, store SessionStore, key, sessionID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		record, _, err := store.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (record == nil && sessionID == "") || (record != nil && record.SessionID == sessionID) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the record of %q under %s, got %+v", sessionID, key, record)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSharedSessions(t *testing.T) {
+	store := NewMemorySessionStore()
+	gateways := make([]*Handler, 3)
+	for i := range gateways {
+		gateways[i] = newPersistentHandler(t, nil)
+		defer gateways[i].Close()
+	}
+	first, second, third := gateways[0], gateways[1], gateways[2]
+	for _, gateway := range gateways[:2] {
+		if err := gateway.UseSessionStore(store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := xnet.UDPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	dest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53)
+	backend := xnet.UDPDestination(xnet.ParseAddress("192.168.1.20"), 53)
+	key := NewFiveTuple(source, dest).Key()
+
+	created := first.createNATSession(source, dest, backend, "outbound", classInteractive)
+	if err := first.allocateSourcePort(context.Background(), created); err != nil {
+		t.Fatal(err)
+	}
+	first.announceSession(created)
+	waitForRecord(t, store, key, created.SessionID)
+
+	// The flow moves to the second gateway and keeps its source port
+	moved := second.createNATSession(source, dest, backend, "outbound", classInteractive)
+	if !second.adoptRestoredSession(context.Background(), moved) || moved.RealSource.Port != created.RealSource.Port {
+		t.Fatalf("Expected the moved flow to keep port %d, got %v", created.RealSource.Port, moved.RealSource)
+	}
+	second.announceSession(moved)
+	waitForRecord(t, store, key, moved.SessionID)
+
+	// A gateway joining later holds the shared sessions at once
+	if err := third.UseSessionStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if held, found := third.LookupSession(NewFiveTuple(source, dest)); !found || !held.restored.Load() || held.SessionID != moved.SessionID {
+		t.Errorf("Expected the third gateway to hold the moved session, got %+v", held)
+	}
+
+	// The first gateway expiring its session leaves the record of the second alone
+	first.removeSession(created.SessionID)
+	time.Sleep(100 * time.Millisecond)
+	waitForRecord(t, store, key, moved.SessionID)
+	second.removeSession(moved.SessionID)
+	waitForRecord(t, store, key, "")
+}
+
+func TestSharedSessionSync(t *testing.T) {
+	store := NewMemorySessionStore()
+	handler := newPersistentHandler(t, nil)
+	defer handler.Close()
+	if err := handler.UseSessionStore(store); err != nil {
+		t.Fatal(err)
+	}
+	source := xnet.TCPDestination(xnet.ParseAddress("100.64.0.1"), 40000)
+	dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 443)
+	natSession := handler.createNATSession(source, dest, dest, "outbound", classInteractive)
+	handler.announceSession(natSession)
+	waitForRecord(t, store, natSession.Tuple.Key(), natSession.SessionID)
+	_, announced, _ := store.Get(natSession.Tuple.Key())
+
+	// Activity moves the expiry, an established connection rewrites the record
+	natSession.counters.lastActivity.Store(time.Now().Add(time.Minute).UnixNano())
+	natSession.tcpState.Store(int32(tcpStateEstablished))
+	if err := handler.sharing.sync(); err != nil {
+		t.Fatal(err)
+	}
+	record, expireAt, _ := store.Get(natSession.Tuple.Key())
+	if !record.Established || !expireAt.After(announced) {
+		t.Errorf("Expected the record to be rewritten, got %+v expiring at %v", record, expireAt)
+	}
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	address := fakeRedis(t, "secret")
+	store, err := newRedisSessionStore(&SharedSessions{RedisAddress: address, RedisPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.String() != "redis://"+address+"/xray:nat:sessions:" {
+		t.Errorf("Unexpected store %s", store)
+	}
+	if record, _, err := store.Get("udp:a"); err != nil || record != nil {
+		t.Fatalf("Expected no record yet, got %+v, %v", record, err)
+	}
+
+	expireAt := time.Now().Add(time.Minute)
+	for _, key := range []string{"udp:a", "udp:b"} {
+		if err := store.Put(key, &SessionRecord{SessionID: key, Protocol: "udp", VirtualDest: "udp:240.2.2.20:53"}, expireAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record, got, err := store.Get("udp:a")
+	if err != nil || record.SessionID != "udp:a" || got.Sub(expireAt).Abs() > time.Second {
+		t.Fatalf("Unexpected record %+v expiring at %v, %v", record, got, err)
+	}
+	if err := store.ExpireAt("udp:a", expireAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, got, _ = store.Get("udp:a"); got.Before(expireAt.Add(time.Hour - time.Second)) {
+		t.Errorf("Expected the expiry to move, got %v", got)
+	}
+
+	if err := store.Delete("udp:b"); err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]bool)
+	if err := store.Range(func(key string, record *SessionRecord, _ time.Time) bool {
+		keys[key] = record.SessionID == key
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys["udp:a"] {
+		t.Errorf("Unexpected shared sessions %v", keys)
+	}
+
+	wrong, _ := newRedisSessionStore(&SharedSessions{RedisAddress: address, RedisPassword: "guess"})
+	if _, _, err := wrong.Get("udp:a"); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+}
@@ -0,0 +1,290 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// Session Traversal Utilities for NAT (RFC 5389, RFC 3489) and NAT behavior discovery (RFC 5780)
+const (
+	stunPort          = 3478
+	stunAlternatePort = 3479
+	stunMagicCookie   = 0x2112a442
+	stunHeaderSize    = 20
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunBindingError    = 0x0111
+
+	stunChangeIP   = 0x04
+	stunChangePort = 0x02
+)
+
+// STUN attributes
+const (
+	stunAttrMappedAddress     = 0x0001
+	stunAttrSourceAddress     = 0x0004
+	stunAttrChangeRequest     = 0x0003
+	stunAttrChangedAddress    = 0x0005
+	stunAttrUsername          = 0x0006
+	stunAttrMessageIntegrity  = 0x0008
+	stunAttrErrorCode         = 0x0009
+	stunAttrUnknownAttributes = 0x000a
+	stunAttrXORMappedAddress  = 0x0020
+	stunAttrSoftware          = 0x8022
+	stunAttrFingerprint       = 0x8028
+	stunAttrResponseOrigin    = 0x802b
+	stunAttrOtherAddress      = 0x802c
+)
+
+// stunLocalAddresses are the loopback addresses the responder listens on for the virtual
+// address and the alternate address
+var stunLocalAddresses = [2]net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+
+// stunServer answers STUN binding requests sent to its virtual addresses. The requests are
+// translated like any other flow, with the NAT behavior configured for the responder, and
+// reach sockets on loopback addresses; the mapped address answered is the source the NAT sent
+// the request from, with the external address as the address.
+type stunServer struct {
+	virtual  [2]net.IP // Primary and alternate virtual address, the alternate nil when disabled
+	rules    [2]*NATRule
+	conns    [2][2]*net.UDPConn // By address and port index
+	mappedIP net.IP
+}
+
+func newSTUNServer(config *Stun) (*stunServer, error) {
+	s := &stunServer{mappedIP: defaultRouteAddress()}
+	if config.ExternalAddress != "" {
+		if s.mappedIP = net.ParseIP(config.ExternalAddress); s.mappedIP == nil {
+			return nil, errors.New("invalid STUN external address ", config.ExternalAddress)
+		}
+	}
+	if s.virtual[0] = net.ParseIP(config.Address); s.virtual[0] == nil {
+		return nil, errors.New("invalid STUN address ", config.Address)
+	}
+	if config.AlternateAddress != "" {
+		if s.virtual[1] = net.ParseIP(config.AlternateAddress); s.virtual[1] == nil || s.virtual[1].Equal(s.virtual[0]) {
+			return nil, errors.New("invalid STUN alternate address ", config.AlternateAddress)
+		}
+	}
+
+	for i, virtual := range s.virtual {
+		if virtual == nil {
+			continue
+		}
+		s.rules[i] = &NATRule{
+			RuleId:             "stun",
+			VirtualDestination: virtual.String(),
+			RealDestination:    stunLocalAddresses[i].String(),
+			Protocol:           "udp",
+			NatBehavior:        config.NatBehavior,
+		}
+		for j, port := range []int{stunPort, stunAlternatePort} {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: stunLocalAddresses[i], Port: port})
+			if err != nil {
+				s.Close()
+				return nil, errors.New("failed to listen for STUN on ", stunLocalAddresses[i], ":", port).Base(err)
+			}
+			s.conns[i][j] = conn
+		}
+	}
+	for i := range s.conns {
+		for j, conn := range s.conns[i] {
+			if conn != nil {
+				go s.serve(i, j)
+			}
+		}
+	}
+	errors.LogInfo(context.Background(), "NAT: STUN responder answering on ", s.virtual[0])
+	return s, nil
+}
+
+func (s *stunServer) Close() error {
+	for i := range s.conns {
+		for _, conn := range s.conns[i] {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	return nil
+}
+
+// rule returns the rule translating flows to a virtual endpoint of the responder
+func (s *stunServer) rule(destination xnet.Destination) (*NATRule, bool) {
+	if s == nil || destination.Network != xnet.Network_UDP || !destination.Address.Family().IsIP() {
+		return nil, false
+	}
+	if destination.Port != stunPort && destination.Port != stunAlternatePort {
+		return nil, false
+	}
+	for i, virtual := range s.virtual {
+		if virtual != nil && virtual.Equal(destination.Address.IP()) {
+			return s.rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// virtualAddressOf returns the virtual address of a local address of the responder
+func (s *stunServer) virtualAddressOf(ip net.IP) (net.IP, bool) {
+	if s == nil {
+		return nil, false
+	}
+	for i, virtual := range s.virtual {
+		if virtual != nil && stunLocalAddresses[i].Equal(ip) {
+			return virtual, true
+		}
+	}
+	return nil, false
+}
+
+func (s *stunServer) serve(i, j int) {
+	packet := make([]byte, 2048)
+	for {
+		n, from, err := s.conns[i][j].ReadFromUDP(packet)
+		if err != nil {
+			return
+		}
+		if response, ci, cj := s.answer(packet[:n], from, i, j); response != nil {
+			s.conns[ci][cj].WriteToUDP(response, from)
+		}
+	}
+}
+
+// endpoint returns the virtual endpoint of the socket of an address and port index
+func (s *stunServer) endpoint(i, j int) (net.IP, int) {
+	return s.virtual[i], []int{stunPort, stunAlternatePort}[j]
+}
+
+// answer answers a binding request received on the socket of address index i and port index j,
+// returning the response and the indexes of the socket to send it from. Requests without the
+// magic cookie are answered as of RFC 3489.
+func (s *stunServer) answer(request []byte, from *net.UDPAddr, i, j int) ([]byte, int, int) {
+	if len(request) < stunHeaderSize || request[0]&0xc0 != 0 || binary.BigEndian.Uint16(request[0:2]) != stunBindingRequest {
+		return nil, 0, 0
+	}
+	length := int(binary.BigEndian.Uint16(request[2:4]))
+	if length%4 != 0 || stunHeaderSize+length != len(request) {
+		return nil, 0, 0
+	}
+	classic := binary.BigEndian.Uint32(request[4:8]) != stunMagicCookie
+	transaction := request[4:stunHeaderSize]
+
+	var change uint32
+	var unknown []uint16
+	for attributes := request[stunHeaderSize:]; len(attributes) > 0; {
+		if len(attributes) < 4 {
+			return nil, 0, 0
+		}
+		attrType, attrLength := binary.BigEndian.Uint16(attributes[0:2]), int(binary.BigEndian.Uint16(attributes[2:4]))
+		padded := 4 + (attrLength+3)&^3
+		if padded > len(attributes) {
+			return nil, 0, 0
+		}
+		switch attrType {
+		case stunAttrChangeRequest:
+			if attrLength != 4 {
+				return nil, 0, 0
+			}
+			change = binary.BigEndian.Uint32(attributes[4:8])
+			if s.virtual[1] == nil && change&(stunChangeIP|stunChangePort) != 0 {
+				// Without an alternate address the responder cannot send from elsewhere
+				unknown = append(unknown, attrType)
+			}
+		case stunAttrUsername, stunAttrMessageIntegrity:
+			// Credentials are not checked
+		default:
+			if attrType < 0x8000 {
+				unknown = append(unknown, attrType)
+			}
+		}
+		attributes = attributes[padded:]
+	}
+
+	response := make([]byte, stunHeaderSize, 128)
+	copy(response[4:], transaction)
+	if len(unknown) > 0 {
+		binary.BigEndian.PutUint16(response[0:2], stunBindingError)
+		response = stunAttribute(response, stunAttrErrorCode, append([]byte{0, 0, 4, 20}, "Unknown Attribute"...))
+		value := make([]byte, 0, 2*len(unknown))
+		for _, attrType := range unknown {
+			value = binary.BigEndian.AppendUint16(value, attrType)
+		}
+		response = stunAttribute(response, stunAttrUnknownAttributes, value)
+		return s.finish(response, classic), i, j
+	}
+
+	ci, cj := i, j
+	if change&stunChangeIP != 0 {
+		ci ^= 1
+	}
+	if change&stunChangePort != 0 {
+		cj ^= 1
+	}
+	mappedIP := from.IP
+	if s.mappedIP != nil {
+		mappedIP = s.mappedIP
+	}
+	originIP, originPort := s.endpoint(ci, cj)
+
+	binary.BigEndian.PutUint16(response[0:2], stunBindingResponse)
+	response = stunAttribute(response, stunAttrMappedAddress, stunAddress(mappedIP, from.Port, nil))
+	if classic {
+		response = stunAttribute(response, stunAttrSourceAddress, stunAddress(originIP, originPort, nil))
+		if s.virtual[1] != nil {
+			otherIP, otherPort := s.endpoint(i^1, j^1)
+			response = stunAttribute(response, stunAttrChangedAddress, stunAddress(otherIP, otherPort, nil))
+		}
+		return s.finish(response, classic), ci, cj
+	}
+	response = stunAttribute(response, stunAttrXORMappedAddress, stunAddress(mappedIP, from.Port, transaction))
+	response = stunAttribute(response, stunAttrResponseOrigin, stunAddress(originIP, originPort, nil))
+	if s.virtual[1] != nil {
+		otherIP, otherPort := s.endpoint(i^1, j^1)
+		response = stunAttribute(response, stunAttrOtherAddress, stunAddress(otherIP, otherPort, nil))
+	}
+	response = stunAttribute(response, stunAttrSoftware, []byte("Xray"))
+	return s.finish(response, classic), ci, cj
+}
+
+// finish sets the length of a response and, unless it is an RFC 3489 one, its fingerprint
+func (s *stunServer) finish(response []byte, classic bool) []byte {
+	if classic {
+		binary.BigEndian.PutUint16(response[2:4], uint16(len(response)-stunHeaderSize))
+		return response
+	}
+	binary.BigEndian.PutUint16(response[2:4], uint16(len(response)-stunHeaderSize+8))
+	fingerprint := crc32.ChecksumIEEE(response) ^ 0x5354554e
+	return stunAttribute(response, stunAttrFingerprint, binary.BigEndian.AppendUint32(nil, fingerprint))
+}
+
+// stunAttribute appends an attribute, padded to a multiple of 4 bytes
+func stunAttribute(message []byte, attrType uint16, value []byte) []byte {
+	message = binary.BigEndian.AppendUint16(message, attrType)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(value)))
+	message = append(message, value...)
+	return append(message, make([]byte, (4-len(value)%4)%4)...)
+}
+
+// stunAddress encodes an address attribute value, XORed with the magic cookie and transaction
+// identifier when xor is the transaction of the message
+func stunAddress(ip net.IP, port int, xor []byte) []byte {
+	family, address := byte(1), ip.To4()
+	if address == nil {
+		family, address = 2, ip.To16()
+	}
+	value := append([]byte{0, family, byte(port >> 8), byte(port)}, address...)
+	if xor != nil {
+		// transaction starts with the magic cookie
+		for k := 2; k < len(value); k++ {
+			value[k] ^= xor[k-2]
+		}
+	}
+	return value
+}
@@ -0,0 +1,194 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// stunRequest builds a binding request, with a CHANGE-REQUEST when change is not zero
+func stunRequest(id byte, change uint32) []byte {
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	request[19] = id
+	if change != 0 {
+		request = stunAttribute(request, stunAttrChangeRequest, binary.BigEndian.AppendUint32(nil, change))
+	}
+	binary.BigEndian.PutUint16(request[2:4], uint16(len(request)-stunHeaderSize))
+	return request
+}
+
+// stunAttributes returns the attributes of a message by type
+func stunAttributes(t *testing.T, message []byte) map[uint16][]byte {
+	t.Helper()
+	if len(message) < stunHeaderSize || int(binary.BigEndian.Uint16(message[2:4])) != len(message)-stunHeaderSize {
+		t.Fatalf("Malformed STUN message % x", message)
+	}
+	attributes := make(map[uint16][]byte)
+	for rest := message[stunHeaderSize:]; len(rest) >= 4; {
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		attributes[binary.BigEndian.Uint16(rest[0:2])] = rest[4 : 4+length]
+		rest = rest[4+(length+3)&^3:]
+	}
+	return attributes
+}
+
+// stunEndpoint decodes an address attribute, XORed with the transaction of message when xor is set
+func stunEndpoint(t *testing.T, message, value []byte, xor bool) string {
+	t.Helper()
+	if len(value) != 8 {
+		t.Fatalf("Unexpected address attribute % x", value)
+	}
+	decoded := append([]byte(nil), value...)
+	if xor {
+		for k := 2; k < len(decoded); k++ {
+			decoded[k] ^= message[4+k-2]
+		}
+	}
+	return net.JoinHostPort(net.IP(decoded[4:]).String(), xnet.Port(binary.BigEndian.Uint16(decoded[2:4])).String())
+}
+
+func newSTUNTestHandler(t *testing.T, behavior string) *Handler {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Stun:   &Stun{Address: "240.2.2.3", AlternateAddress: "240.2.2.4", NatBehavior: behavior, ExternalAddress: "203.0.113.1"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return handler
+}
+
+func TestSTUNBindingResponse(t *testing.T) {
+	newSTUNTestHandler(t, "")
+	client := listenUDP(t)
+	port := xnet.Port(client.LocalAddr().(*net.UDPAddr).Port).String()
+
+	// Changing both address and port answers from the alternate socket
+	request := stunRequest(1, stunChangeIP|stunChangePort)
+	if _, err := client.WriteToUDP(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: stunPort}); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, 512)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := client.ReadFromUDP(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = response[:n]
+	if from.String() != "127.0.0.2:3479" {
+		t.Errorf("Expected the response from the alternate socket, got %v", from)
+	}
+	if binary.BigEndian.Uint16(response[0:2]) != stunBindingResponse || response[19] != 1 {
+		t.Fatalf("Unexpected response % x", response)
+	}
+
+	attributes := stunAttributes(t, response)
+	for attrType, expected := range map[uint16]string{
+		stunAttrXORMappedAddress: "203.0.113.1:" + port,
+		stunAttrMappedAddress:    "203.0.113.1:" + port,
+		stunAttrResponseOrigin:   "240.2.2.4:3479",
+		stunAttrOtherAddress:     "240.2.2.4:3479",
+	} {
+		if actual := stunEndpoint(t, response, attributes[attrType], attrType == stunAttrXORMappedAddress); actual != expected {
+			t.Errorf("Expected attribute %#x to be %s, got %s", attrType, expected, actual)
+		}
+	}
+	fingerprint := attributes[stunAttrFingerprint]
+	if len(fingerprint) != 4 || binary.BigEndian.Uint32(fingerprint) != crc32.ChecksumIEEE(response[:n-8])^0x5354554e {
+		t.Error("Expected a valid fingerprint")
+	}
+
+	// Unknown comprehension-required attributes are refused
+	request = stunAttribute(stunRequest(2, 0), 0x0024, []byte{0, 0, 0, 1})
+	binary.BigEndian.PutUint16(request[2:4], uint16(len(request)-stunHeaderSize))
+	response, _, _ = (&stunServer{}).answer(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, 0, 0)
+	if binary.BigEndian.Uint16(response[0:2]) != stunBindingError || binary.BigEndian.Uint16(stunAttributes(t, response)[stunAttrUnknownAttributes]) != 0x0024 {
+		t.Errorf("Expected an unknown attribute error, got % x", response)
+	}
+}
+
+// runSTUNDiscovery sends binding requests through a mapping of the responder's rule and returns
+// the mapped addresses answered to the primary and the alternate address, and whether the
+// response sent from the other address and port passed the filtering of the mapping
+func runSTUNDiscovery(t *testing.T, behavior string) (string, string, bool) {
+	handler := newSTUNTestHandler(t, behavior)
+	primary := xnet.UDPDestination(xnet.ParseAddress("240.2.2.3"), stunPort)
+	alternate := xnet.UDPDestination(xnet.ParseAddress("240.2.2.4"), stunPort)
+	rule, ok := handler.shouldApplyNAT(context.Background(), primary)
+	if !ok || rule.RuleId != "stun" {
+		t.Fatal("Expected the responder's rule for its virtual address")
+	}
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.handleNATOutbound(context.Background(), &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, primary, udpTestDialer{}, rule)
+	}()
+	defer func() {
+		uplinkWriter.Close()
+		<-done
+	}()
+
+	exchange := func(to xnet.Destination, request []byte, timeout time.Duration) ([]byte, bool) {
+		b := buf.New()
+		b.Write(request)
+		b.UDP = &to
+		if err := uplinkWriter.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+			t.Fatal(err)
+		}
+		mb, err := downlinkReader.ReadMultiBufferTimeout(timeout)
+		if err != nil || mb.IsEmpty() {
+			return nil, false
+		}
+		defer buf.ReleaseMulti(mb)
+		return append([]byte(nil), mb[0].Bytes()...), true
+	}
+	mapped := func(to xnet.Destination, id byte) string {
+		response, ok := exchange(to, stunRequest(id, 0), 2*time.Second)
+		if !ok {
+			t.Fatalf("No response from %v", to)
+		}
+		return stunEndpoint(t, response, stunAttributes(t, response)[stunAttrXORMappedAddress], true)
+	}
+
+	first := mapped(primary, 1)
+	second := mapped(alternate, 2)
+	_, filtered := exchange(primary, stunRequest(3, stunChangeIP|stunChangePort), 300*time.Millisecond)
+	return first, second, filtered
+}
+
+func TestSTUNBehaviorDiscovery(t *testing.T) {
+	t.Run(natBehaviorFullCone, func(t *testing.T) {
+		first, second, passed := runSTUNDiscovery(t, natBehaviorFullCone)
+		if first != second {
+			t.Errorf("Expected an endpoint-independent mapping, got %s and %s", first, second)
+		}
+		if !passed {
+			t.Error("Expected a full-cone mapping to pass the response from another address")
+		}
+	})
+	t.Run(natBehaviorPortRestricted, func(t *testing.T) {
+		first, second, passed := runSTUNDiscovery(t, natBehaviorPortRestricted)
+		if first != second || passed {
+			t.Errorf("Expected endpoint-independent mapping and filtering of other endpoints, got %s %s %v", first, second, passed)
+		}
+	})
+	t.Run(natBehaviorSymmetric, func(t *testing.T) {
+		first, second, _ := runSTUNDiscovery(t, natBehaviorSymmetric)
+		if first == second {
+			t.Errorf("Expected a symmetric mapping per destination, got %s for both", first)
+		}
+	})
+}
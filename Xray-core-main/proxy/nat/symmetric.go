@@ -0,0 +1,67 @@
+package nat
+
+import (
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// siteRanges returns the virtual ranges in use at a site: those whose source site lists it, and
+// the reverse ranges of symmetric ranges whose peer it is. changed reports whether the result
+// differs from ranges, so configs without site-specific ranges are left untouched.
+func siteRanges(ranges []*VirtualIPRange, siteID string) (result []*VirtualIPRange, changed bool, err error) {
+	for _, vrange := range ranges {
+		if vrange.SourceSite == "" && !vrange.Symmetric {
+			result = append(result, vrange)
+			continue
+		}
+		changed = true
+		if vrange.Symmetric {
+			if vrange.PeerSite == "" {
+				return nil, false, errors.New("symmetric virtual range ", vrange.VirtualNetwork, " has no peer site")
+			}
+			if vrange.RealNetwork == "" || vrange.Ipv4To6Prefix != "" || vrange.NpTv6VirtualPrefix != "" {
+				return nil, false, errors.New("symmetric virtual range ", vrange.VirtualNetwork, " must map a plain real network")
+			}
+		}
+		if siteListed(vrange.SourceSite, siteID) {
+			result = append(result, vrange)
+		}
+		if vrange.Symmetric && siteListed(vrange.PeerSite, siteID) {
+			result = append(result, reverseRange(vrange))
+		}
+	}
+	return result, changed, nil
+}
+
+// reverseRange returns the range of the peer direction of a symmetric range
+func reverseRange(vrange *VirtualIPRange) *VirtualIPRange {
+	reverse := &VirtualIPRange{
+		VirtualNetwork:    vrange.PeerVirtualNetwork,
+		RealNetwork:       vrange.PeerRealNetwork,
+		Ipv6Enabled:       vrange.Ipv6Enabled,
+		Ipv6VirtualPrefix: vrange.Ipv6VirtualPrefix,
+		SourceSite:        vrange.PeerSite,
+	}
+	if reverse.VirtualNetwork == "" {
+		reverse.VirtualNetwork = vrange.VirtualNetwork
+	}
+	if reverse.RealNetwork == "" {
+		reverse.RealNetwork = vrange.RealNetwork
+	}
+	return reverse
+}
+
+// siteListed reports whether a comma separated site list holds a site. An empty list, or no site
+// configured, matches all sites.
+func siteListed(sites, siteID string) bool {
+	if sites == "" || siteID == "" {
+		return true
+	}
+	for _, site := range strings.Split(sites, ",") {
+		if strings.EqualFold(strings.TrimSpace(site), siteID) {
+			return true
+		}
+	}
+	return false
+}
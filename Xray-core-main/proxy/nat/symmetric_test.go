@@ -0,0 +1,55 @@
+package nat
+
+import (
+	"context"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestSymmetricRange(t *testing.T) {
+	ranges := []*VirtualIPRange{
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", SourceSite: "site-a", Symmetric: true, PeerSite: "site-b", PeerRealNetwork: "192.168.2.0/24", SendThrough: "10.0.0.1"},
+		{VirtualNetwork: "240.3.3.0/24", RealNetwork: "192.168.3.0/24", SourceSite: "site-c"},
+		{VirtualNetwork: "240.4.4.0/24", RealNetwork: "192.168.4.0/24"},
+	}
+
+	for site, expected := range map[string]map[string]string{
+		"site-a": {"240.2.2.20": "192.168.1.20", "240.3.3.20": "", "240.4.4.20": "192.168.4.20"},
+		"site-b": {"240.2.2.20": "192.168.2.20", "240.3.3.20": "", "240.4.4.20": "192.168.4.20"},
+		"site-c": {"240.2.2.20": "", "240.3.3.20": "192.168.3.20", "240.4.4.20": "192.168.4.20"},
+	} {
+		handler := New()
+		if err := handler.Init(&Config{SiteId: site, VirtualRanges: ranges}, nil); err != nil {
+			t.Fatal(err)
+		}
+		for address, real := range expected {
+			rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(address), 80))
+			if real == "" && ok || real != "" && (!ok || rule.RealDestination != real) {
+				t.Errorf("Expected %s to be translated into %q at %s, got %v", address, real, site, rule)
+			}
+		}
+		handler.Close()
+	}
+
+	// The reverse range translates into the peer network, without the sendThrough of this side
+	handler := &Handler{config: &Config{SiteId: "site-b"}}
+	handler.config.VirtualRanges, _, _ = siteRanges(ranges, "site-b")
+	if ip := handler.sendThroughOf(xnet.ParseAddress("192.168.2.20")); ip != nil {
+		t.Errorf("Expected no sendThrough for the peer network, got %v", ip)
+	}
+	if len(ranges[0].PeerVirtualNetwork) != 0 {
+		t.Error("Expected the configured range to be left untouched")
+	}
+}
+
+func TestSymmetricRangeWithoutPeer(t *testing.T) {
+	for _, vrange := range []*VirtualIPRange{
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", Symmetric: true},
+		{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", Ipv4To6Prefix: "64:ff9b::/96", Symmetric: true, PeerSite: "site-b"},
+	} {
+		if err := New().Init(&Config{SiteId: "site-a", VirtualRanges: []*VirtualIPRange{vrange}}, nil); err == nil {
+			t.Errorf("Expected error for symmetric range %v", vrange)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package nat
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// disabledState is the rules and tags disabled through the API, and the rules disabled in the
+// configuration it enabled. It is replaced, never modified, by DisableRules and EnableRules.
+type disabledState struct {
+	rules   map[string]bool // By ruleId, or the id "dynamic-range-<virtualNetwork>" of the sessions of a range
+	tags    map[string]bool
+	enabled map[string]bool // Rules and ranges disabled in the configuration, by ruleId
+}
+
+// rangeRuleID is the ruleId of the rules, and so of the sessions, a virtual range creates
+func rangeRuleID(vrange *VirtualIPRange) string {
+	return "dynamic-range-" + vrange.VirtualNetwork
+}
+
+// disabled reports whether the rule or range of id and tags is disabled, through its id or
+// one of its tags, or in the configuration unless the API enabled it
+func (h *Handler) disabled(id string, tags []string, configured bool) bool {
+	state := h.disabledRules.Load()
+	if state == nil {
+		return configured
+	}
+	if id != "" && state.rules[id] {
+		return true
+	}
+	for _, tag := range tags {
+		if state.tags[tag] {
+			return true
+		}
+	}
+	return configured && !(id != "" && state.enabled[id])
+}
+
+// RuleDisabled reports whether a rule is disabled, in the configuration or through the API
+func (h *Handler) RuleDisabled(rule *NATRule) bool {
+	return h.disabled(rule.RuleId, rule.Tags, rule.Disabled)
+}
+
+// rangeDisabled reports whether a virtual range is disabled, in the configuration or through the API
+func (h *Handler) rangeDisabled(vrange *VirtualIPRange) bool {
+	return h.disabled(rangeRuleID(vrange), vrange.Tags, vrange.Disabled)
+}
+
+// TaggedRuleIDs returns the ruleIds of the active rules carrying tag, and those of the
+// sessions of the virtual ranges carrying tag. Rules without a ruleId are left out.
+func (h *Handler) TaggedRuleIDs(tag string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, rule := range h.activeRules() {
+		if rule.RuleId != "" && slices.Contains(rule.Tags, tag) {
+			ids[rule.RuleId] = true
+		}
+	}
+	for _, vrange := range h.config.VirtualRanges {
+		if slices.Contains(vrange.Tags, tag) {
+			ids[rangeRuleID(vrange)] = true
+		}
+	}
+	return ids
+}
+
+// RuleTags returns the tags of the active rules and virtual ranges by the ruleId of their sessions
+func (h *Handler) RuleTags() map[string][]string {
+	tags := make(map[string][]string)
+	for _, rule := range h.activeRules() {
+		if len(rule.Tags) > 0 && tags[rule.RuleId] == nil {
+			tags[rule.RuleId] = rule.Tags
+		}
+	}
+	for _, vrange := range h.config.VirtualRanges {
+		if len(vrange.Tags) > 0 {
+			tags[rangeRuleID(vrange)] = vrange.Tags
+		}
+	}
+	return tags
+}
+
+// FlushTaggedSessions drops the active sessions of the rules and virtual ranges carrying tag
+// and returns how many were dropped
+func (h *Handler) FlushTaggedSessions(tag string) int {
+	ids := h.TaggedRuleIDs(tag)
+	flushed := 0
+	h.sessions.Range(func(session *NATSession) bool {
+		if ids[session.RuleID] {
+			if _, loaded := h.sessions.LoadAndDelete(session.SessionID); loaded {
+				endSession(session, SessionEndFlushed)
+				h.dropSession(session)
+				flushed++
+			}
+		}
+		return true
+	})
+	return flushed
+}
+
+// DisableRules disables the rule of ruleID, or every rule and virtual range carrying tag,
+// including those a later reload adds. Flows to their virtual destinations are matched as if
+// they did not exist; the sessions they created are kept. It returns how many active rules
+// and ranges are disabled.
+func (h *Handler) DisableRules(ruleID, tag string) (int, error) {
+	return h.setRulesDisabled(ruleID, tag, true)
+}
+
+// EnableRules enables the rule of ruleID, or the rules and ranges carrying tag, again. Rules
+// stay disabled while their ruleId or another of their tags is. A rule disabled in the
+// configuration is only enabled by its ruleId, until DisableRules disables it again. It
+// returns how many active rules and ranges are enabled.
+func (h *Handler) EnableRules(ruleID, tag string) (int, error) {
+	return h.setRulesDisabled(ruleID, tag, false)
+}
+
+// setRulesDisabled disables or enables the rule of ruleID or the rules and ranges of tag
+func (h *Handler) setRulesDisabled(ruleID, tag string, disable bool) (int, error) {
+	if (ruleID == "") == (tag == "") {
+		return 0, errors.New("NAT rules are selected by either a ruleId or a tag")
+	}
+
+	h.disabledAccess.Lock()
+	defer h.disabledAccess.Unlock()
+	state := &disabledState{rules: make(map[string]bool), tags: make(map[string]bool), enabled: make(map[string]bool)}
+	if previous := h.disabledRules.Load(); previous != nil {
+		maps.Copy(state.rules, previous.rules)
+		maps.Copy(state.tags, previous.tags)
+		maps.Copy(state.enabled, previous.enabled)
+	}
+	selects := func(id string, tags []string) bool {
+		return (ruleID != "" && id == ruleID) || (tag != "" && slices.Contains(tags, tag))
+	}
+	switch {
+	case ruleID != "" && disable:
+		state.rules[ruleID] = true
+		delete(state.enabled, ruleID)
+	case ruleID != "":
+		delete(state.rules, ruleID)
+		if h.configuredDisabled(ruleID) {
+			state.enabled[ruleID] = true
+		}
+	case disable:
+		state.tags[tag] = true
+	default:
+		delete(state.tags, tag)
+	}
+	if len(state.rules)+len(state.tags)+len(state.enabled) == 0 {
+		state = nil
+	}
+	h.disabledRules.Store(state)
+
+	matched := 0
+	for _, rule := range h.activeRules() {
+		if selects(rule.RuleId, rule.Tags) && h.RuleDisabled(rule) == disable {
+			matched++
+		}
+	}
+	for _, vrange := range h.config.VirtualRanges {
+		if selects(rangeRuleID(vrange), vrange.Tags) && h.rangeDisabled(vrange) == disable {
+			matched++
+		}
+	}
+	h.syncOffload()
+	action, selection := "enabled ", "ruleId "+ruleID
+	if disable {
+		action = "disabled "
+	}
+	if tag != "" {
+		selection = "tag " + tag
+	}
+	errors.LogInfo(context.Background(), "NAT: ", action, matched, " rules and ranges of ", selection)
+	return matched, nil
+}
+
+// configuredDisabled reports whether an active rule or virtual range of ruleID is disabled in
+// the configuration
+func (h *Handler) configuredDisabled(ruleID string) bool {
+	for _, rule := range h.activeRules() {
+		if rule.RuleId == ruleID && rule.Disabled {
+			return true
+		}
+	}
+	for _, vrange := range h.config.VirtualRanges {
+		if rangeRuleID(vrange) == ruleID && vrange.Disabled {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package nat
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestRuleTags(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", Tags: []string{"backup-window"}},
+		},
+		Rules: []*NATRule{
+			{RuleId: "backup", VirtualDestination: "240.2.2.20", RealDestination: "192.168.9.20", Tags: []string{"backup-window", "storage"}},
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.30"},
+			{RuleId: "db", VirtualDestination: "240.2.2.40", RealDestination: "192.168.1.40", Tags: []string{"storage"}},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	matched := func(ip string) string {
+		rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(ip), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	if _, err := handler.DisableRules("", ""); err == nil {
+		t.Error("Expected a selection by neither ruleId nor tag to be rejected")
+	}
+	if _, err := handler.DisableRules("web", "storage"); err == nil {
+		t.Error("Expected a selection by both ruleId and tag to be rejected")
+	}
+
+	if ids := handler.TaggedRuleIDs("backup-window"); len(ids) != 2 || !ids["backup"] || !ids["dynamic-range-240.2.2.0/24"] {
+		t.Errorf("Unexpected rules of tag backup-window %v", ids)
+	}
+	for _, stats := range handler.RuleStats() {
+		if stats.RuleID == "db" && !slices.Equal(stats.Tags, []string{"storage"}) {
+			t.Errorf("Unexpected tags of rule db %v", stats.Tags)
+		}
+	}
+
+	backup := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	handler.createNATSession(xnet.Destination{}, backup, backup, "outbound", classInteractive).RuleID = "backup"
+	web := xnet.TCPDestination(xnet.ParseAddress("240.2.2.30"), 80)
+	handler.createNATSession(xnet.Destination{}, web, web, "outbound", classInteractive).RuleID = "web"
+
+	// Disabling a tag disables its rules and ranges; flows fall through to the next rule
+	if n, err := handler.DisableRules("", "backup-window"); err != nil || n != 2 {
+		t.Fatalf("Expected the rule and the range to be disabled, got %d, %v", n, err)
+	}
+	if id := matched("240.2.2.20"); id != "web" {
+		t.Errorf("Expected the flow to match rule web once backup is disabled, got %q", id)
+	}
+	if id := matched("240.2.2.50"); id != "" {
+		t.Errorf("Expected the disabled range not to translate, got %q", id)
+	}
+	if handler.sessions.Len() != 2 {
+		t.Error("Expected the sessions of disabled rules to be kept")
+	}
+
+	// A rule stays disabled while another of its tags is
+	if n, err := handler.DisableRules("", "storage"); err != nil || n != 2 {
+		t.Fatalf("Expected rules backup and db to be disabled, got %d, %v", n, err)
+	}
+	if n, err := handler.EnableRules("", "backup-window"); err != nil || n != 1 {
+		t.Fatalf("Expected only the range to be enabled, got %d, %v", n, err)
+	}
+	if id := matched("240.2.2.20"); id != "web" {
+		t.Errorf("Expected rule backup to stay disabled through tag storage, got %q", id)
+	}
+	if id := matched("240.2.2.50"); id != "dynamic-range-240.2.2.0/24" {
+		t.Errorf("Expected the range to translate again, got %q", id)
+	}
+	if n, err := handler.EnableRules("", "storage"); err != nil || n != 2 {
+		t.Fatalf("Expected rules backup and db to be enabled, got %d, %v", n, err)
+	}
+	if id := matched("240.2.2.20"); id != "backup" {
+		t.Errorf("Expected rule backup to match again, got %q", id)
+	}
+
+	// By ruleId
+	if n, err := handler.DisableRules("db", ""); err != nil || n != 1 || !handler.RuleDisabled(handler.activeRules()[2]) {
+		t.Errorf("Expected rule db to be disabled, got %d, %v", n, err)
+	}
+	if n, err := handler.EnableRules("db", ""); err != nil || n != 1 || handler.disabledRules.Load() != nil {
+		t.Errorf("Expected rule db to be enabled, got %d, %v", n, err)
+	}
+
+	if n := handler.FlushTaggedSessions("backup-window"); n != 1 || handler.sessions.Len() != 1 {
+		t.Errorf("Expected the session of rule backup to be flushed, got %d", n)
+	}
+}
+
+func TestDisabledRules(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		VirtualRanges: []*VirtualIPRange{
+			{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24", Disabled: true},
+		},
+		Rules: []*NATRule{
+			{RuleId: "maintenance", VirtualDestination: "240.2.2.20", RealDestination: "192.168.9.20", Disabled: true, Tags: []string{"standby"}},
+			{RuleId: "web", VirtualDestination: "240.2.2.20", RealDestination: "192.168.1.20"},
+		},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	matched := func(ip string) string {
+		rule, ok := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress(ip), 80))
+		if !ok {
+			return ""
+		}
+		return rule.RuleId
+	}
+
+	if id := matched("240.2.2.20"); id != "web" {
+		t.Errorf("Expected the disabled rule to be skipped, got %q", id)
+	}
+	if id := matched("240.2.2.50"); id != "" {
+		t.Errorf("Expected the disabled range not to translate, got %q", id)
+	}
+	if entries := handler.offloadEntries(); len(entries) != 1 {
+		t.Errorf("Expected only rule web to be offloaded, got %v", entries)
+	}
+	if stats := handler.RuleStats(); len(stats) != 2 || stats[0].RuleID != "maintenance" {
+		t.Errorf("Expected the disabled rule to keep its statistics, got %+v", stats)
+	}
+
+	// Enabling the tag leaves a rule disabled in the configuration alone, enabling its ruleId does not
+	if n, err := handler.EnableRules("", "standby"); err != nil || n != 0 {
+		t.Errorf("Expected no rule enabled by tag, got %d, %v", n, err)
+	}
+	if n, err := handler.EnableRules("maintenance", ""); err != nil || n != 1 {
+		t.Fatalf("Expected rule maintenance to be enabled, got %d, %v", n, err)
+	}
+	if id := matched("240.2.2.20"); id != "maintenance" {
+		t.Errorf("Expected the enabled rule to match, got %q", id)
+	}
+	if n, err := handler.EnableRules("dynamic-range-240.2.2.0/24", ""); err != nil || n != 1 {
+		t.Fatalf("Expected the range to be enabled, got %d, %v", n, err)
+	}
+	if id := matched("240.2.2.50"); id != "dynamic-range-240.2.2.0/24" {
+		t.Errorf("Expected the enabled range to translate, got %q", id)
+	}
+	if n, err := handler.DisableRules("maintenance", ""); err != nil || n != 1 || matched("240.2.2.20") != "web" {
+		t.Errorf("Expected rule maintenance to be disabled again, got %d, %v", n, err)
+	}
+}
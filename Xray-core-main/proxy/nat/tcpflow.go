@@ -0,0 +1,66 @@
+package nat
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// tcpFlow follows the lifecycle of a relayed TCP connection from the ends of its two directions.
+// A direction ending cleanly is a FIN: it is propagated to the other side and the session turns
+// half-closed, expiring after the transitory timeout unless the other direction ends first. A
+// direction failing is a reset and tears the flow down at once.
+type tcpFlow struct {
+	handler  *Handler
+	session  *NATSession
+	conns    []io.Closer
+	finished atomic.Int32
+}
+
+// newTCPFlow tracks a session relayed over conns, which are closed when the flow ends or the
+// session is dropped. Closing them ends the flow, so dropping the session no longer cancels its
+// context: a flow ending cleanly removes its own session and must not see its context canceled.
+func (h *Handler) newTCPFlow(natSession *NATSession, conns ...io.Closer) *tcpFlow {
+	f := &tcpFlow{handler: h, session: natSession, conns: conns}
+	var relay io.Closer = f
+	natSession.relay.Store(&relay)
+	natSession.cancel.Store(nil)
+	return f
+}
+
+// finish records the end of one direction, propagating a clean end with halfClose
+func (f *tcpFlow) finish(err error, halfClose func() bool) error {
+	if err == nil && halfClose() && f.finished.Add(1) == 1 {
+		f.handler.setTCPState(f.session, tcpStateHalfClosed)
+		return nil
+	}
+	f.reset()
+	return err
+}
+
+// reset tears the flow down at once, removing its session
+func (f *tcpFlow) reset() {
+	f.handler.removeSession(f.session.SessionID)
+	f.Close()
+}
+
+// Close implements io.Closer
+func (f *tcpFlow) Close() error {
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// closeWrite half-closes conn, reporting whether it could
+func closeWrite(conn net.Conn) bool {
+	if statConn, ok := conn.(*stat.CounterConnection); ok {
+		conn = statConn.Connection
+	}
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite() == nil
+	}
+	return false
+}
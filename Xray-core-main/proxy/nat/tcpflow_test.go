@@ -0,0 +1,205 @@
+package nat
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// tcpTestDialer dials loopback TCP connections like the system dialer does
+type tcpTestDialer struct{ udpTestDialer }
+
+func (tcpTestDialer) Dial(ctx context.Context, dest xnet.Destination) (stat.Connection, error) {
+	return net.Dial("tcp", dest.NetAddr())
+}
+
+// tcpFlowTest is a TCP flow relayed through a handler to a loopback server
+type tcpFlowTest struct {
+	handler  *Handler
+	session  *NATSession
+	uplink   *pipe.Writer
+	downlink *pipe.Reader
+	server   net.Conn
+	done     chan error
+}
+
+func newTCPFlowTest(t *testing.T, pm policy.Manager) *tcpFlowTest {
+	listener := listenTCP(t)
+	rule := &NATRule{RuleId: "internal", VirtualDestination: "240.2.2.1", RealDestination: "127.0.0.1"}
+	handler := New()
+	t.Cleanup(func() { handler.Close() })
+	if err := handler.Init(&Config{
+		SiteId:        "test-site",
+		VirtualRanges: []*VirtualIPRange{{VirtualNetwork: "240.2.2.0/24", RealNetwork: "127.0.0.0/24"}},
+		Rules:         []*NATRule{rule},
+		SessionTimeout: &SessionTimeout{
+			EstablishedTcpTimeout: 7440,
+			TransitoryTcpTimeout:  240,
+		},
+	}, pm); err != nil {
+		t.Fatal(err)
+	}
+
+	source := xnet.TCPDestination(xnet.ParseAddress("127.0.0.5"), 40000)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: source})
+	destination := xnet.TCPDestination(xnet.ParseAddress("240.2.2.1"), xnet.Port(listener.Addr().(*net.TCPAddr).Port))
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	f := &tcpFlowTest{handler: handler, uplink: uplinkWriter, downlink: downlinkReader, done: make(chan error, 1)}
+	go func() {
+		f.done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, destination, tcpTestDialer{}, rule)
+	}()
+
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	f.server = server
+	natSession, ok := handler.LookupSession(NewFiveTuple(source, destination))
+	if !ok {
+		t.Fatal("Expected a session for the flow")
+	}
+	f.session = natSession
+	f.waitState(t, tcpStateEstablished)
+	return f
+}
+
+// waitState waits for the session to reach a TCP state
+func (f *tcpFlowTest) waitState(t *testing.T, state tcpState) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); tcpState(f.session.tcpState.Load()) != state; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected TCP state %d, got %d", state, f.session.tcpState.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// wait waits for the relay to end
+func (f *tcpFlowTest) wait(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-f.done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the relay to end")
+		return nil
+	}
+}
+
+func TestTCPFlowHalfClose(t *testing.T) {
+	f := newTCPFlowTest(t, nil)
+	if timeout := f.handler.sessionTimeout(f.session); timeout != 7440*time.Second {
+		t.Errorf("Expected established TCP timeout 7440s, got %v", timeout)
+	}
+
+	request := buf.New()
+	request.WriteString("ping")
+	f.uplink.WriteMultiBuffer(buf.MultiBuffer{request})
+	f.uplink.Close()
+
+	// The FIN of the client reaches the server, which may still answer
+	received, err := io.ReadAll(f.server)
+	if err != nil || string(received) != "ping" {
+		t.Fatalf("Expected the server to read ping then EOF, got %q, %v", received, err)
+	}
+	f.waitState(t, tcpStateHalfClosed)
+	if timeout := f.handler.sessionTimeout(f.session); timeout != 240*time.Second {
+		t.Errorf("Expected transitory TCP timeout 240s for a half-closed session, got %v", timeout)
+	}
+
+	io.WriteString(f.server, "pong")
+	mb, err := f.downlink.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil || mb.String() != "pong" {
+		t.Fatalf("Expected the reply after the half-close, got %q, %v", mb.String(), err)
+	}
+	buf.ReleaseMulti(mb)
+
+	f.server.(*net.TCPConn).CloseWrite()
+	if err := f.wait(t); err != nil {
+		t.Errorf("Expected a clean end, got %v", err)
+	}
+	if _, err := f.downlink.ReadMultiBufferTimeout(2 * time.Second); err != io.EOF {
+		t.Errorf("Expected the FIN of the server to reach the client, got %v", err)
+	}
+	if _, ok := f.handler.sessions.Load(f.session.SessionID); ok {
+		t.Error("Expected the session to be removed once both sides closed")
+	}
+}
+
+func TestTCPFlowReset(t *testing.T) {
+	f := newTCPFlowTest(t, nil)
+	f.uplink.Interrupt()
+
+	if err := f.wait(t); err == nil {
+		t.Error("Expected a reset flow to end with an error")
+	}
+	if _, ok := f.handler.sessions.Load(f.session.SessionID); ok {
+		t.Error("Expected the session of a reset flow to be removed at once")
+	}
+	f.server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := f.server.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the real connection to be closed")
+	}
+}
+
+func TestTCPFlowClosedWithSession(t *testing.T) {
+	f := newTCPFlowTest(t, nil)
+	f.handler.removeSession(f.session.SessionID)
+
+	f.server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := f.server.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the real connection to be closed with its session")
+	}
+	f.wait(t)
+}
+
+func TestTCPFlowIdleTimeout(t *testing.T) {
+	session := policy.SessionDefault()
+	session.Timeouts.ConnectionIdle = 200 * time.Millisecond
+	f := newTCPFlowTest(t, levelPolicy{session: session})
+
+	if err := f.wait(t); err == nil {
+		t.Error("Expected an idle flow to be cut")
+	}
+	if _, ok := f.handler.sessions.Load(f.session.SessionID); ok {
+		t.Error("Expected the session of an idle flow to be removed")
+	}
+	f.server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := f.server.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the real connection of an idle flow to be closed")
+	}
+}
+
+func TestDialWithinHandshakeTimeout(t *testing.T) {
+	late, peer := net.Pipe()
+	defer peer.Close()
+	_, err := dialWithin(50*time.Millisecond, func() (stat.Connection, error) {
+		time.Sleep(200 * time.Millisecond)
+		return late, nil
+	})
+	if err == nil {
+		t.Fatal("Expected a dial outlasting the handshake timeout to fail")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := late.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("Expected the connection established late to be closed, got %v", err)
+	}
+
+	conn, err := dialWithin(time.Second, func() (stat.Connection, error) { return peer, nil })
+	if err != nil || conn != peer {
+		t.Errorf("Expected the dial to succeed within the timeout, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// TFTP opcodes starting a transfer (RFC 1350)
+const (
+	tftpReadRequest  = 1
+	tftpWriteRequest = 2
+)
+
+func init() {
+	common.Must(RegisterALG(algTFTP, tftpGateway{}))
+}
+
+// tftpGateway is the TFTP gateway of UDP flows. It follows the datagrams of a transfer and
+// rewrites nothing.
+type tftpGateway struct{}
+
+// Match implements ALG
+func (tftpGateway) Match(flow *ALGFlow) bool {
+	return flow.Session.RealDest.Network == xnet.Network_UDP
+}
+
+// WrapConn implements ALGConnWrapper
+func (tftpGateway) WrapConn(flow *ALGFlow, conn stat.Connection) stat.Connection {
+	return newTFTPConn(conn)
+}
+
+// RewriteOutbound implements ALG
+func (tftpGateway) RewriteOutbound(flow *ALGFlow, message []byte) []byte {
+	return message
+}
+
+// RewriteInbound implements ALG
+func (tftpGateway) RewriteInbound(flow *ALGFlow, message []byte) []byte {
+	return message
+}
+
+// ExpectSessions implements ALG
+func (tftpGateway) ExpectSessions(flow *ALGFlow, message []byte, outbound bool) []ALGExpectation {
+	return nil
+}
+
+// tftpConn follows a TFTP transfer (RFC 1350) on the connection towards the server. The server
+// answers a request from a new port, its transfer identifier, and the rest of the transfer
+// goes to that port. The datagrams of the client keep addressing the virtual destination, so
+// they are sent to the transfer identifier the server answered from.
+type tftpConn struct {
+	stat.Connection
+	packet *internet.PacketConnWrapper
+	server net.Addr // Port the requests go to
+
+	sync.Mutex
+	peer net.Addr // Transfer identifier of the server, nil until it answers
+}
+
+// newTFTPConn wraps conn to follow the transfer identifier of the server. Connections of
+// proxying dialers can only talk to their remote address and are returned unchanged.
+func newTFTPConn(conn stat.Connection) stat.Connection {
+	packet, ok := newPacketConn(conn).(*internet.PacketConnWrapper)
+	if !ok {
+		return conn
+	}
+	return &tftpConn{Connection: conn, packet: packet, server: packet.RemoteAddr()}
+}
+
+// Read implements net.Conn. Datagrams of other hosts and, once the transfer identifier is
+// known, of other ports of the server are dropped.
+func (c *tftpConn) Read(p []byte) (int, error) {
+	for {
+		n, from, err := c.packet.ReadFrom(p)
+		if err != nil {
+			return n, err
+		}
+		fromUDP, ok := from.(*net.UDPAddr)
+		serverUDP, _ := c.server.(*net.UDPAddr)
+		if !ok || serverUDP == nil || !fromUDP.IP.Equal(serverUDP.IP) {
+			continue
+		}
+		c.Lock()
+		if c.peer == nil {
+			c.peer = from
+			errors.LogDebug(context.Background(), "NAT: TFTP ALG following transfer identifier ", from)
+		}
+		latched := c.peer.String() == from.String()
+		c.Unlock()
+		if latched {
+			return n, nil
+		}
+	}
+}
+
+// Write implements net.Conn. A new request starts a new transfer on the server's port.
+func (c *tftpConn) Write(p []byte) (int, error) {
+	c.Lock()
+	if len(p) >= 2 {
+		if opcode := binary.BigEndian.Uint16(p); opcode == tftpReadRequest || opcode == tftpWriteRequest {
+			c.peer = nil
+		}
+	}
+	to := c.peer
+	c.Unlock()
+	if to == nil {
+		to = c.server
+	}
+	return c.packet.WriteTo(p, to)
+}
@@ -0,0 +1,89 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+func readDatagram(t *testing.T, conn *net.UDPConn) ([]byte, *net.UDPAddr) {
+	t.Helper()
+	packet := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFromUDP(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet[:n], from
+}
+
+func TestTFTPTransferIdentifier(t *testing.T) {
+	server, transfer := listenUDP(t), listenUDP(t)
+	serverPort := server.LocalAddr().(*net.UDPAddr).Port
+
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId: "test-site",
+		Rules: []*NATRule{{
+			RuleId:             "tftp",
+			VirtualDestination: "240.2.2.23",
+			RealDestination:    "127.0.0.1",
+			Ports:              strconv.Itoa(serverPort),
+			Protocol:           "udp",
+			Alg:                []string{"tftp"},
+		}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	defer uplinkWriter.Close()
+	virtualDest := xnet.UDPDestination(xnet.ParseAddress("240.2.2.23"), xnet.Port(serverPort))
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.UDPDestination(xnet.ParseAddress("127.0.0.1"), 40000)})
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{Target: virtualDest}})
+	go handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, hairpinDialer{})
+
+	uplinkWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("\x00\x01boot.bin\x00octet\x00")))
+	request, client := readDatagram(t, server)
+	if request[1] != tftpReadRequest {
+		t.Fatalf("Unexpected request %q", request)
+	}
+
+	// The server answers from its transfer identifier
+	transfer.WriteToUDP([]byte("\x00\x03\x00\x01data"), client)
+	mb, err := downlinkReader.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mb.String() != "\x00\x03\x00\x01data" {
+		t.Errorf("Unexpected data %q", mb.String())
+	}
+	buf.ReleaseMulti(mb)
+
+	// Datagrams from other ports of the server do not belong to the transfer
+	server.WriteToUDP([]byte("\x00\x05stray"), client)
+
+	uplinkWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("\x00\x04\x00\x01")))
+	if ack, _ := readDatagram(t, transfer); string(ack) != "\x00\x04\x00\x01" {
+		t.Errorf("Expected the acknowledgement to reach the transfer identifier, got %q", ack)
+	}
+	if _, err := downlinkReader.ReadMultiBufferTimeout(100 * time.Millisecond); err == nil {
+		t.Error("Expected the datagram from another port to be dropped")
+	}
+
+	// A new request goes to the server's port again
+	uplinkWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("\x00\x02log.txt\x00octet\x00")))
+	if request, _ := readDatagram(t, server); request[1] != tftpWriteRequest {
+		t.Errorf("Expected the new request on the server's port, got %q", request)
+	}
+}
@@ -0,0 +1,93 @@
+package nat
+
+import (
+	"time"
+)
+
+const (
+	// wheelTick is the expiry resolution of the timing wheel
+	wheelTick = time.Second
+
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+
+	// wheelSpan is the furthest deadline the wheel can hold, in ticks (about 194 days)
+	wheelSpan = 1 << (wheelBits * wheelLevels)
+)
+
+// timingWheel is a hierarchical timing wheel of sessions keyed on their expiry tick.
+// Level 0 has one slot per tick, every following level covers wheelSlots times the span
+// of the previous one. Entries of a higher level are cascaded down when its slot comes due,
+// so advancing the wheel only touches sessions whose slot actually expired.
+// timingWheel is not safe for concurrent use.
+type timingWheel struct {
+	current uint64
+	levels  [wheelLevels][wheelSlots][]wheelEntry
+}
+
+type wheelEntry struct {
+	session *NATSession
+	tick    uint64
+}
+
+// schedule adds a session that should be checked at the given tick or as soon as possible after it.
+// A session has at most one live deadline; scheduling it again supersedes the previous one.
+func (w *timingWheel) schedule(session *NATSession, tick uint64) {
+	if tick <= w.current {
+		tick = w.current + 1
+	}
+	if tick-w.current >= wheelSpan {
+		tick = w.current + wheelSpan - 1
+	}
+	session.wheelTick = tick
+	w.add(session, tick)
+}
+
+func (w *timingWheel) add(session *NATSession, tick uint64) {
+	if tick < w.current {
+		tick = w.current
+	}
+	delta := tick - w.current
+
+	level := 0
+	for delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	slot := (tick >> (wheelBits * level)) & wheelMask
+	w.levels[level][slot] = append(w.levels[level][slot], wheelEntry{session: session, tick: tick})
+}
+
+// advance moves the wheel to the given tick and appends all sessions that came due to fired
+func (w *timingWheel) advance(to uint64, fired []*NATSession) []*NATSession {
+	for w.current < to {
+		w.current++
+		tick := w.current
+
+		// Cascade higher levels whose slot boundary was reached, highest first
+		level := 0
+		for level+1 < wheelLevels && (tick>>(wheelBits*level))&wheelMask == 0 {
+			level++
+		}
+		for ; level > 0; level-- {
+			slot := (tick >> (wheelBits * level)) & wheelMask
+			entries := w.levels[level][slot]
+			w.levels[level][slot] = nil
+			// Entries of this slot have deadlines within the span that starts now
+			for _, entry := range entries {
+				w.add(entry.session, entry.tick)
+			}
+		}
+
+		slot := tick & wheelMask
+		for _, entry := range w.levels[0][slot] {
+			// Drop entries superseded by a later schedule call
+			if entry.tick == entry.session.wheelTick {
+				fired = append(fired, entry.session)
+			}
+		}
+		w.levels[0][slot] = nil
+	}
+	return fired
+}
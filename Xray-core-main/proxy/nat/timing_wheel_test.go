@@ -0,0 +1,88 @@
+package nat
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestTimingWheelFiresAtDeadline(t *testing.T) {
+	var wheel timingWheel
+	wheel.current = 100
+
+	ticks := []uint64{101, 163, 164, 227, 4160, 4196, 5000, 100 + 4096*64 + 7}
+	for i := 0; i < 200; i++ {
+		ticks = append(ticks, 101+uint64(rand.Intn(300000)))
+	}
+
+	expected := make(map[*NATSession]uint64)
+	for i, tick := range ticks {
+		session := &NATSession{SessionID: string(rune('a' + i%26))}
+		expected[session] = tick
+		wheel.schedule(session, tick)
+	}
+
+	var maxTick uint64
+	for _, tick := range ticks {
+		if tick > maxTick {
+			maxTick = tick
+		}
+	}
+
+	fired := 0
+	for wheel.current < maxTick {
+		now := wheel.current + 1
+		for _, session := range wheel.advance(now, nil) {
+			if expected[session] != now {
+				t.Fatalf("Session scheduled for tick %d fired at tick %d", expected[session], now)
+			}
+			fired++
+		}
+	}
+	if fired != len(ticks) {
+		t.Errorf("Expected %d sessions to fire, got %d", len(ticks), fired)
+	}
+}
+
+func TestTimingWheelPastDeadline(t *testing.T) {
+	var wheel timingWheel
+	wheel.advance(50, nil)
+
+	session := &NATSession{SessionID: "late"}
+	wheel.schedule(session, 10)
+
+	fired := wheel.advance(51, nil)
+	if len(fired) != 1 || fired[0] != session {
+		t.Error("Session with a past deadline should fire on the next tick")
+	}
+}
+
+func TestSessionCleanupReschedulesActiveSessions(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+	handler.config = &Config{
+		SessionTimeout: &SessionTimeout{
+			TcpTimeout:      1,
+			CleanupInterval: 1,
+		},
+	}
+
+	dest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	idle := handler.createNATSession(xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 1000), dest, dest, "outbound", classInteractive)
+	active := handler.createNATSession(xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 1001), dest, dest, "outbound", classInteractive)
+
+	for i := 0; i < 6; i++ {
+		time.Sleep(500 * time.Millisecond)
+		active.touch()
+		handler.cleanupExpiredSessions()
+	}
+
+	if _, exists := handler.sessions.Load(idle.SessionID); exists {
+		t.Error("Idle session should expire")
+	}
+	if _, exists := handler.sessions.Load(active.SessionID); !exists {
+		t.Error("Active session should be rescheduled instead of expiring")
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package nat
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/xtls/xray-core/proxy"
+	"golang.org/x/sys/unix"
+)
+
+// connTOS returns the TOS byte of the socket of a client's connection: IP_TOS, or IPV6_TCLASS
+// for IPv6 clients. Linux reflects the TOS of the client's SYN onto accepted connections under
+// net.ipv4.tcp_reflect_tos; other connections report the TOS they are sent with.
+func connTOS(conn net.Conn) (uint32, bool) {
+	raw, _, _ := proxy.UnwrapRawConn(conn)
+	sc, ok := raw.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	level, opt := unix.IPPROTO_IP, unix.IP_TOS
+	if local, ok := addrPortOf(raw.LocalAddr()); ok && local.Addr().Is6() {
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	}
+	var tos int
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		tos, sockErr = unix.GetsockoptInt(int(fd), level, opt)
+	}); err != nil || sockErr != nil || tos < 0 {
+		return 0, false
+	}
+	return uint32(tos), true
+}
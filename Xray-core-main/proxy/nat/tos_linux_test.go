@@ -0,0 +1,49 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/xtls/xray-core/common/session"
+	"golang.org/x/sys/unix"
+)
+
+func TestFlowSockoptPreserveTOS(t *testing.T) {
+	listener := listenTCP(t)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn := <-accepted
+	defer conn.Close()
+	rawConn, _ := conn.(*net.TCPConn).SyscallConn()
+	rawConn.Control(func(fd uintptr) {
+		unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, 0x68)
+	})
+
+	rule := &NATRule{RuleId: "qos", Tos: 0xb8, PreserveTos: true}
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Conn: conn})
+	options := flowSockopt(ctx, rule).CustomSockopt
+	if len(options) != 8 {
+		t.Fatalf("Expected the tos of the rule followed by the tos of the client, got %v", options)
+	}
+	for _, custom := range options[4:] {
+		if custom.Value != "104" {
+			t.Errorf("Expected the tos of the client's connection, got %v", custom)
+		}
+	}
+	// Without a connection to read, the tos of the rule applies
+	if options := flowSockopt(context.Background(), rule).CustomSockopt; len(options) != 4 || options[0].Value != "184" {
+		t.Errorf("Expected the tos of the rule, got %v", options)
+	}
+}
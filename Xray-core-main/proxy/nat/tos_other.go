@@ -0,0 +1,10 @@
+//go:build !linux
+
+package nat
+
+import "net"
+
+// connTOS is unsupported outside Linux
+func connTOS(conn net.Conn) (uint32, bool) {
+	return 0, false
+}
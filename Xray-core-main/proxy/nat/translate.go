@@ -0,0 +1,301 @@
+package nat
+
+import (
+	"net"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// parseNetworkOrIP parses either a CIDR network or a single IP address.
+// A single IP address is returned as a host network (/32 or /128).
+func parseNetworkOrIP(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("invalid network ", s).Base(err)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.New("invalid IP address ", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// mapHostBits keeps the host bits of ip relative to from and applies them to the to network,
+// so 240.2.2.37 in 240.2.2.0/24 maps to 192.168.1.37 in 192.168.1.0/24.
+func mapHostBits(ip net.IP, from, to *net.IPNet) (net.IP, error) {
+	src := normalizeIP(ip, from.Mask)
+	dst := normalizeIP(to.IP, to.Mask)
+	if len(src) != len(from.Mask) || len(dst) != len(to.Mask) || len(src) != len(dst) {
+		return nil, errors.New("address family mismatch between ", from, " and ", to)
+	}
+	if !from.Contains(src) {
+		return nil, errors.New(ip, " is not in ", from)
+	}
+
+	out := make(net.IP, len(dst))
+	for i := range out {
+		host := src[i] &^ from.Mask[i]
+		if host&to.Mask[i] != 0 {
+			return nil, errors.New("network ", to, " is too small to map ", ip, " from ", from)
+		}
+		out[i] = dst[i]&to.Mask[i] | host
+	}
+	return out, nil
+}
+
+// translateRange maps ip into the real network of a plain range, keeping its host bits. ok is
+// false when ip is outside the virtual network or the networks are of different families, as
+// for IPv6 virtual networks embedding the real IPv4 address.
+func translateRange(ip net.IP, vrange *VirtualIPRange) (net.IP, bool, error) {
+	virtualNet, err := parseNetworkOrIP(vrange.VirtualNetwork)
+	if err != nil || !virtualNet.Contains(ip) {
+		return nil, false, nil
+	}
+	realNet, err := parseNetworkOrIP(vrange.RealNetwork)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(virtualNet.IP) != len(realNet.IP) {
+		return nil, false, nil
+	}
+	realIP, err := mapHostBits(ip, virtualNet, realNet)
+	if err != nil {
+		return nil, false, err
+	}
+	return realIP, true, nil
+}
+
+// normalizeIP returns ip in the byte length of mask (4 bytes for IPv4, 16 for IPv6).
+func normalizeIP(ip net.IP, mask net.IPMask) net.IP {
+	if len(mask) == net.IPv4len {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+		return ip
+	}
+	return ip.To16()
+}
+
+// embedIPv4 embeds an IPv4 address into an IPv6 prefix as described in RFC 6052 section 2.2.
+// Supported prefix lengths are 32, 40, 48, 56, 64 and 96.
+func embedIPv4(prefix *net.IPNet, ip net.IP) (net.IP, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, errors.New(ip, " is not an IPv4 address")
+	}
+	ones, bits := prefix.Mask.Size()
+	if bits != 8*net.IPv6len {
+		return nil, errors.New(prefix, " is not an IPv6 prefix")
+	}
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, errors.New("unsupported IPv4-embedding prefix length /", ones)
+	}
+
+	out := make(net.IP, net.IPv6len)
+	copy(out, prefix.IP.To16())
+	pos := ones / 8
+	for _, b := range ip4 {
+		// Bits 64 to 71 (the "u" octet) must be zero
+		if pos == 8 {
+			out[pos] = 0
+			pos++
+		}
+		out[pos] = b
+		pos++
+	}
+	return out, nil
+}
+
+// extractIPv4 returns the IPv4 address embedded into ip under prefix as described in RFC 6052
+// section 2.2, false when ip is not an address of the prefix
+func extractIPv4(prefix *net.IPNet, ip net.IP) (net.IP, bool) {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil || !prefix.Contains(ip16) {
+		return nil, false
+	}
+	ones, bits := prefix.Mask.Size()
+	if bits != 8*net.IPv6len {
+		return nil, false
+	}
+	out := make(net.IP, 0, net.IPv4len)
+	for pos := ones / 8; len(out) < net.IPv4len && pos < net.IPv6len; pos++ {
+		if pos == 8 {
+			continue
+		}
+		out = append(out, ip16[pos])
+	}
+	if len(out) != net.IPv4len {
+		return nil, false
+	}
+	return out, true
+}
+
+// translateNAT46 maps an IPv4 virtual destination into the real network of vrange and
+// embeds the result into the range's NAT46 prefix.
+func translateNAT46(ip net.IP, vrange *VirtualIPRange) (net.IP, error) {
+	_, prefix, err := net.ParseCIDR(vrange.Ipv4To6Prefix)
+	if err != nil {
+		return nil, errors.New("invalid NAT46 prefix ", vrange.Ipv4To6Prefix).Base(err)
+	}
+
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, errors.New(ip, " is not an IPv4 address")
+	}
+	if vrange.RealNetwork != "" {
+		virtualNet, err := parseNetworkOrIP(vrange.VirtualNetwork)
+		if err != nil {
+			return nil, err
+		}
+		realNet, err := parseNetworkOrIP(vrange.RealNetwork)
+		if err != nil {
+			return nil, err
+		}
+		if ip4, err = mapHostBits(ip4, virtualNet, realNet); err != nil {
+			return nil, err
+		}
+	}
+
+	return embedIPv4(prefix, ip4)
+}
+
+// translateNPTv6 performs stateless IPv6-to-IPv6 network prefix translation (RFC 6296).
+// The prefix bits of ip are replaced by those of to and one 16-bit word is adjusted so the
+// one's complement sum of the address, and therefore any transport checksum, is unchanged.
+// For prefixes up to /48 the subnet word is adjusted and the interface identifier is kept;
+// for /49 to /64 the RFC requires the adjustment to land in the first interface identifier
+// word that is not 0xFFFF.
+func translateNPTv6(ip net.IP, from, to *net.IPNet) (net.IP, error) {
+	fromOnes, fromBits := from.Mask.Size()
+	toOnes, toBits := to.Mask.Size()
+	if fromBits != 8*net.IPv6len || toBits != 8*net.IPv6len {
+		return nil, errors.New("NPTv6 requires IPv6 prefixes")
+	}
+	if fromOnes != toOnes || fromOnes > 64 {
+		return nil, errors.New("NPTv6 prefixes must have the same length of at most /64")
+	}
+
+	out, err := mapHostBits(ip, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment := onesComplementAdd(checksumSum(from.IP.To16()), ^checksumSum(to.IP.To16()))
+
+	index := 3
+	if fromOnes > 48 {
+		index = -1
+		for i := 4; i < 8; i++ {
+			if word16(out, i) != 0xFFFF {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errors.New("cannot apply NPTv6 to ", ip, ": no adjustable interface identifier word")
+		}
+	}
+
+	word := word16(out, index)
+	if word == 0xFFFF {
+		return nil, errors.New("cannot apply NPTv6 to ", ip, ": subnet 0xFFFF is not translatable")
+	}
+	word = onesComplementAdd(word, adjustment)
+	if word == 0xFFFF {
+		word = 0
+	}
+	out[2*index] = byte(word >> 8)
+	out[2*index+1] = byte(word)
+
+	return out, nil
+}
+
+func word16(ip net.IP, index int) uint16 {
+	return uint16(ip[2*index])<<8 | uint16(ip[2*index+1])
+}
+
+// checksumSum returns the one's complement sum of the 16-bit words of ip
+func checksumSum(ip net.IP) uint16 {
+	var sum uint16
+	for i := 0; i < len(ip)/2; i++ {
+		sum = onesComplementAdd(sum, word16(ip, i))
+	}
+	return sum
+}
+
+func onesComplementAdd(a, b uint16) uint16 {
+	sum := uint32(a) + uint32(b)
+	return uint16(sum&0xFFFF + sum>>16)
+}
+
+// virtualAddressOf maps a real address back to the virtual address clients use for it,
+// reversing static mappings, address leases, MAP rules, the PLAT prefix of the CLAT, literal
+// rules, range mappings and NPTv6.
+func (h *Handler) virtualAddressOf(ip net.IP) (net.IP, bool) {
+	if h.config == nil {
+		return nil, false
+	}
+
+	if virtualIP, ok := h.staticVirtualAddress(ip); ok {
+		return virtualIP, true
+	}
+	if virtualIP, ok := h.stun.virtualAddressOf(ip); ok {
+		return virtualIP, true
+	}
+	if virtualIP, ok := h.addresses.virtualAddressOf(ip); ok {
+		return virtualIP, true
+	}
+	if virtualIP, ok := h.mapRules.virtualAddressOf(ip); ok {
+		return virtualIP, true
+	}
+	if virtualIP, ok := h.clat.virtualAddressOf(ip); ok {
+		return virtualIP, true
+	}
+
+	for _, rule := range h.activeRules() {
+		realIP := net.ParseIP(rule.RealDestination)
+		virtualIP := net.ParseIP(rule.VirtualDestination)
+		if realIP != nil && virtualIP != nil && realIP.Equal(ip) {
+			return virtualIP, true
+		}
+	}
+
+	for _, vrange := range h.config.VirtualRanges {
+		if vrange.NpTv6VirtualPrefix != "" && vrange.NpTv6RealPrefix != "" {
+			_, virtualNet, err1 := net.ParseCIDR(vrange.NpTv6VirtualPrefix)
+			_, realNet, err2 := net.ParseCIDR(vrange.NpTv6RealPrefix)
+			if err1 == nil && err2 == nil && realNet.Contains(ip) {
+				if virtualIP, err := translateNPTv6(ip, realNet, virtualNet); err == nil {
+					return virtualIP, true
+				}
+			}
+		}
+		// The real address of a masquerade range stands for every virtual address of the range
+		if vrange.VirtualNetwork == "" || vrange.RealNetwork == "" || vrange.Masquerade {
+			continue
+		}
+		virtualNet, err := parseNetworkOrIP(vrange.VirtualNetwork)
+		if err != nil {
+			continue
+		}
+		realNet, err := parseNetworkOrIP(vrange.RealNetwork)
+		if err != nil || !realNet.Contains(ip) {
+			continue
+		}
+		if virtualIP, err := mapHostBits(ip, realNet, virtualNet); err == nil {
+			return virtualIP, true
+		}
+	}
+
+	return nil, false
+}
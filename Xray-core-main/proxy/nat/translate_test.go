@@ -0,0 +1,230 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestEmbedIPv4(t *testing.T) {
+	// Examples from RFC 6052 section 2.4
+	testCases := []struct {
+		prefix   string
+		expected string
+	}{
+		{prefix: "2001:db8::/32", expected: "2001:db8:c000:221::"},
+		{prefix: "2001:db8:100::/40", expected: "2001:db8:1c0:2:21::"},
+		{prefix: "2001:db8:122::/48", expected: "2001:db8:122:c000:2:2100::"},
+		{prefix: "2001:db8:122:300::/56", expected: "2001:db8:122:3c0:0:221::"},
+		{prefix: "2001:db8:122:344::/64", expected: "2001:db8:122:344:c0:2:2100:0"},
+		{prefix: "2001:db8:122:344::/96", expected: "2001:db8:122:344::c000:221"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			_, prefix, err := net.ParseCIDR(tc.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := embedIPv4(prefix, net.ParseIP("192.0.2.33"))
+			if err != nil {
+				t.Fatalf("Failed to embed IPv4: %v", err)
+			}
+			if !result.Equal(net.ParseIP(tc.expected)) {
+				t.Errorf("Expected %s, got %s", tc.expected, result)
+			}
+		})
+	}
+
+	_, invalid, _ := net.ParseCIDR("2001:db8::/80")
+	if _, err := embedIPv4(invalid, net.ParseIP("192.0.2.33")); err == nil {
+		t.Error("Expected error for unsupported prefix length /80")
+	}
+}
+
+func TestMapHostBits(t *testing.T) {
+	_, from, _ := net.ParseCIDR("240.2.2.0/24")
+	_, to, _ := net.ParseCIDR("192.168.1.0/24")
+
+	result, err := mapHostBits(net.ParseIP("240.2.2.37"), from, to)
+	if err != nil {
+		t.Fatalf("Failed to map host bits: %v", err)
+	}
+	if result.String() != "192.168.1.37" {
+		t.Errorf("Expected 192.168.1.37, got %s", result)
+	}
+
+	_, small, _ := net.ParseCIDR("192.168.1.0/28")
+	if _, err := mapHostBits(net.ParseIP("240.2.2.37"), from, small); err == nil {
+		t.Error("Expected error when real network is too small")
+	}
+
+	if _, err := mapHostBits(net.ParseIP("240.3.3.37"), from, to); err == nil {
+		t.Error("Expected error for address outside the virtual network")
+	}
+}
+
+func TestRangeTranslation(t *testing.T) {
+	handler := &Handler{
+		config: &Config{
+			VirtualRanges: []*VirtualIPRange{
+				{VirtualNetwork: "240.2.2.0/24", RealNetwork: "192.168.1.0/24"},
+				{VirtualNetwork: "240.4.0.0/16", RealNetwork: "10.0.0.0/8"},
+				{VirtualNetwork: "240.5.5.0/24", RealNetwork: "192.168.5.0/28"},
+			},
+		},
+	}
+
+	for virtual, real := range map[string]string{
+		"240.2.2.37":  "192.168.1.37",
+		"240.2.2.254": "192.168.1.254",
+		"240.4.3.7":   "10.0.3.7",
+	} {
+		dest := xnet.TCPDestination(xnet.ParseAddress(virtual), 443)
+		rule, shouldTransform := handler.shouldApplyNAT(context.Background(), dest)
+		if !shouldTransform {
+			t.Errorf("Expected %s to be translated", virtual)
+			continue
+		}
+		transformed, err := handler.applyDNAT(dest, rule)
+		if err != nil {
+			t.Errorf("DNAT transformation of %s failed: %v", virtual, err)
+			continue
+		}
+		if transformed.Address.String() != real || transformed.Port != 443 {
+			t.Errorf("Expected %s to map to %s:443, got %s", virtual, real, transformed.NetAddr())
+		}
+	}
+
+	// Host bits that do not fit into the real network leave the flow untranslated
+	if _, shouldTransform := handler.shouldApplyNAT(context.Background(), xnet.TCPDestination(xnet.ParseAddress("240.5.5.37"), 443)); shouldTransform {
+		t.Error("Expected no translation into a real network too small for the host bits")
+	}
+}
+
+func TestNAT46Translation(t *testing.T) {
+	handler := &Handler{
+		config: &Config{
+			VirtualRanges: []*VirtualIPRange{
+				{
+					VirtualNetwork: "240.3.3.0/24",
+					RealNetwork:    "192.168.1.0/24",
+					Ipv4To6Prefix:  "64:FF9B::/96",
+				},
+			},
+		},
+	}
+
+	dest := xnet.Destination{
+		Address: xnet.ParseAddress("240.3.3.20"),
+		Network: xnet.Network_TCP,
+		Port:    443,
+	}
+
+	rule, shouldTransform := handler.shouldApplyNAT(context.Background(), dest)
+	if !shouldTransform {
+		t.Fatal("Expected NAT46 transformation for IPv4 virtual destination")
+	}
+
+	transformed, err := handler.applyDNAT(dest, rule)
+	if err != nil {
+		t.Fatalf("DNAT transformation failed: %v", err)
+	}
+
+	if !transformed.Address.Family().IsIPv6() {
+		t.Fatalf("Expected IPv6 real destination, got %s", transformed.Address)
+	}
+	if !transformed.Address.IP().Equal(net.ParseIP("64:ff9b::192.168.1.20")) {
+		t.Errorf("Expected real destination 64:ff9b::c0a8:114, got %s", transformed.Address)
+	}
+	if transformed.Port != 443 {
+		t.Errorf("Expected port 443, got %d", transformed.Port)
+	}
+}
+
+func TestTranslateNPTv6(t *testing.T) {
+	// Example from RFC 6296 section 3.5
+	_, from, _ := net.ParseCIDR("fd01:203:405::/48")
+	_, to, _ := net.ParseCIDR("2001:db8:1::/48")
+
+	result, err := translateNPTv6(net.ParseIP("fd01:203:405:1::1234"), from, to)
+	if err != nil {
+		t.Fatalf("NPTv6 translation failed: %v", err)
+	}
+	if !result.Equal(net.ParseIP("2001:db8:1:d550::1234")) {
+		t.Errorf("Expected 2001:db8:1:d550::1234, got %s", result)
+	}
+	if checksumSum(result) != checksumSum(net.ParseIP("fd01:203:405:1::1234")) {
+		t.Error("Expected checksum-neutral mapping")
+	}
+
+	// Reverse translation restores the original address
+	reverse, err := translateNPTv6(result, to, from)
+	if err != nil {
+		t.Fatalf("Reverse NPTv6 translation failed: %v", err)
+	}
+	if !reverse.Equal(net.ParseIP("fd01:203:405:1::1234")) {
+		t.Errorf("Expected fd01:203:405:1::1234, got %s", reverse)
+	}
+
+	_, from64, _ := net.ParseCIDR("fd01:203:405:1::/64")
+	_, to64, _ := net.ParseCIDR("2001:db8:1:2::/64")
+	result, err = translateNPTv6(net.ParseIP("fd01:203:405:1::1234"), from64, to64)
+	if err != nil {
+		t.Fatalf("NPTv6 /64 translation failed: %v", err)
+	}
+	if !to64.Contains(result) {
+		t.Errorf("Expected %s to be in %s", result, to64)
+	}
+	if checksumSum(result) != checksumSum(net.ParseIP("fd01:203:405:1::1234")) {
+		t.Error("Expected checksum-neutral mapping for /64 prefixes")
+	}
+
+	_, mismatch, _ := net.ParseCIDR("2001:db8:1::/56")
+	if _, err := translateNPTv6(net.ParseIP("fd01:203:405:1::1234"), from, mismatch); err == nil {
+		t.Error("Expected error for mismatched prefix lengths")
+	}
+}
+
+func TestNPTv6Range(t *testing.T) {
+	handler := &Handler{
+		config: &Config{
+			VirtualRanges: []*VirtualIPRange{
+				{
+					NpTv6VirtualPrefix: "fd01:203:405::/48",
+					NpTv6RealPrefix:    "2001:db8:1::/48",
+				},
+			},
+		},
+	}
+
+	dest := xnet.Destination{
+		Address: xnet.ParseAddress("fd01:203:405:1::1234"),
+		Network: xnet.Network_UDP,
+		Port:    53,
+	}
+
+	rule, shouldTransform := handler.shouldApplyNAT(context.Background(), dest)
+	if !shouldTransform {
+		t.Fatal("Expected NPTv6 transformation for virtual prefix destination")
+	}
+
+	transformed, err := handler.applyDNAT(dest, rule)
+	if err != nil {
+		t.Fatalf("DNAT transformation failed: %v", err)
+	}
+	if !transformed.Address.IP().Equal(net.ParseIP("2001:db8:1:d550::1234")) {
+		t.Errorf("Expected real destination 2001:db8:1:d550::1234, got %s", transformed.Address)
+	}
+
+	outside := xnet.Destination{
+		Address: xnet.ParseAddress("fd01:203:406::1"),
+		Network: xnet.Network_UDP,
+		Port:    53,
+	}
+	if _, shouldTransform := handler.shouldApplyNAT(context.Background(), outside); shouldTransform {
+		t.Error("Should not apply NPTv6 outside the virtual prefix")
+	}
+}
@@ -0,0 +1,66 @@
+package nat
+
+import (
+	"strconv"
+	"strings"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// FiveTuple identifies a flow by its protocol and virtual source and destination endpoints
+type FiveTuple struct {
+	Network xnet.Network
+	Source  xnet.Destination
+	Dest    xnet.Destination
+}
+
+// NewFiveTuple builds the tuple of a flow from source to virtual destination.
+// The source is optional and may be the zero Destination when it is unknown.
+func NewFiveTuple(source, dest xnet.Destination) FiveTuple {
+	return FiveTuple{
+		Network: dest.Network,
+		Source:  source,
+		Dest:    dest,
+	}
+}
+
+// Key returns the canonical string form of the tuple, e.g. "tcp:10.0.0.2:51000->240.2.2.20:80"
+func (t FiveTuple) Key() string {
+	var b strings.Builder
+	b.WriteString(t.Network.SystemString())
+	b.WriteByte(':')
+	writeEndpoint(&b, t.Source)
+	b.WriteString("->")
+	writeEndpoint(&b, t.Dest)
+	return b.String()
+}
+
+// String implements fmt.Stringer
+func (t FiveTuple) String() string {
+	return t.Key()
+}
+
+func writeEndpoint(b *strings.Builder, dest xnet.Destination) {
+	if dest.Address == nil {
+		b.WriteString("*")
+	} else {
+		b.WriteString(dest.Address.String())
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.Itoa(int(dest.Port)))
+}
+
+// generateSessionID derives a session identifier from the flow tuple and a sequence number.
+// The sequence number keeps identifiers unique even when the same tuple is reused.
+func generateSessionID(tuple FiveTuple, seq uint64) string {
+	return tuple.Key() + "#" + strconv.FormatUint(seq, 10)
+}
+
+// LookupSession returns the most recent active session of the given flow tuple
+func (h *Handler) LookupSession(tuple FiveTuple) (*NATSession, bool) {
+	sessionID, ok := h.tupleIndex.Load(tuple.Key())
+	if !ok {
+		return nil, false
+	}
+	return h.sessions.Load(sessionID.(string))
+}
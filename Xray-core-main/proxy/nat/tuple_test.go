@@ -0,0 +1,57 @@
+package nat
+
+import (
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestFiveTupleKey(t *testing.T) {
+	tuple := NewFiveTuple(
+		xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 51000),
+		xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80),
+	)
+	if tuple.Key() != "tcp:10.0.0.2:51000->240.2.2.20:80" {
+		t.Errorf("Unexpected tuple key %s", tuple.Key())
+	}
+
+	unknownSource := NewFiveTuple(xnet.Destination{}, xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), 53))
+	if unknownSource.Key() != "udp:*:0->240.2.2.20:53" {
+		t.Errorf("Unexpected tuple key %s", unknownSource.Key())
+	}
+}
+
+func TestSessionIDCollisionFree(t *testing.T) {
+	handler := New()
+	defer handler.Close()
+
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), 51000)
+	virtualDest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	realDest := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+
+	// Two flows to the same destination within the same second
+	first := handler.createNATSession(source, virtualDest, realDest, "outbound", classInteractive)
+	second := handler.createNATSession(source, virtualDest, realDest, "outbound", classInteractive)
+	if first.SessionID == second.SessionID {
+		t.Fatalf("Expected distinct session IDs, both are %s", first.SessionID)
+	}
+
+	tuple := NewFiveTuple(source, virtualDest)
+	if found, ok := handler.LookupSession(tuple); !ok || found != second {
+		t.Error("Expected tuple lookup to return the latest session")
+	}
+
+	// Removing the older session must not tear down the newer one
+	handler.removeSession(first.SessionID)
+	if _, exists := handler.sessions.Load(second.SessionID); !exists {
+		t.Error("Removing the first session removed the second one")
+	}
+	if found, ok := handler.LookupSession(tuple); !ok || found != second {
+		t.Error("Expected tuple lookup to survive removal of an older session")
+	}
+
+	handler.removeSession(second.SessionID)
+	if _, ok := handler.LookupSession(tuple); ok {
+		t.Error("Expected no session after removing all sessions of the tuple")
+	}
+}
@@ -0,0 +1,446 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/uuid"
+)
+
+// Minimal UPnP Internet Gateway Device, version 1
+const (
+	upnpDeviceType  = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	upnpServiceType = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+	upnpDescriptionPath = "/rootDesc.xml"
+	upnpSCPDPath        = "/WANIPCn.xml"
+	upnpControlPath     = "/ctl/IPConn"
+
+	ssdpAddress = "239.255.255.250:1900"
+	ssdpMaxAge  = 1800
+
+	upnpMaxRequestSize = 64 * 1024
+)
+
+// UPnP control error codes
+const (
+	upnpInvalidAction        = 401
+	upnpInvalidArgs          = 402
+	upnpNotAuthorized        = 606
+	upnpNoSuchEntry          = 714
+	upnpWildcardExternalPort = 716
+	upnpConflict             = 718
+	upnpNoPortMapsAvailable  = 728
+)
+
+// upnpActions are the actions of the WANIPConnection service, with their input and output
+// arguments and the state variables those relate to
+var upnpActions = []struct {
+	name    string
+	inputs  [][2]string
+	outputs [][2]string
+}{
+	{
+		name: "AddPortMapping",
+		inputs: [][2]string{
+			{"NewRemoteHost", "RemoteHost"},
+			{"NewExternalPort", "ExternalPort"},
+			{"NewProtocol", "PortMappingProtocol"},
+			{"NewInternalPort", "InternalPort"},
+			{"NewInternalClient", "InternalClient"},
+			{"NewEnabled", "PortMappingEnabled"},
+			{"NewPortMappingDescription", "PortMappingDescription"},
+			{"NewLeaseDuration", "PortMappingLeaseDuration"},
+		},
+	},
+	{
+		name: "DeletePortMapping",
+		inputs: [][2]string{
+			{"NewRemoteHost", "RemoteHost"},
+			{"NewExternalPort", "ExternalPort"},
+			{"NewProtocol", "PortMappingProtocol"},
+		},
+	},
+	{
+		name:    "GetExternalIPAddress",
+		outputs: [][2]string{{"NewExternalIPAddress", "ExternalIPAddress"}},
+	},
+}
+
+var upnpStateVariables = [][2]string{
+	{"ExternalIPAddress", "string"},
+	{"RemoteHost", "string"},
+	{"ExternalPort", "ui2"},
+	{"PortMappingProtocol", "string"},
+	{"InternalPort", "ui2"},
+	{"InternalClient", "string"},
+	{"PortMappingEnabled", "boolean"},
+	{"PortMappingDescription", "string"},
+	{"PortMappingLeaseDuration", "ui4"},
+}
+
+// upnpServer is a minimal UPnP IGD of the port control server. It is discovered through SSDP
+// and opens, deletes and reports mappings through the SOAP actions of WANIPConnection.
+// Mappings are opened on the external port clients ask for and belong to the client address,
+// which must be the internal client of its mappings.
+type upnpServer struct {
+	pcp      *pcpServer
+	listener net.Listener
+	ssdp     *net.UDPConn
+	uuid     string
+	location string
+}
+
+func newUPnPServer(s *pcpServer, listen string) (*upnpServer, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, errors.New("failed to listen for UPnP on ", listen).Base(err)
+	}
+	// The device keeps its identifier across restarts as long as it listens on the same address
+	sum := sha256.Sum256([]byte("xray-nat-upnp:" + listener.Addr().String()))
+	id, _ := uuid.ParseBytes(sum[:16])
+	u := &upnpServer{
+		pcp:      s,
+		listener: listener,
+		uuid:     "uuid:" + id.String(),
+		location: "http://" + listener.Addr().String() + upnpDescriptionPath,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(upnpDescriptionPath, u.serveDescription)
+	mux.HandleFunc(upnpSCPDPath, u.serveSCPD)
+	mux.HandleFunc(upnpControlPath, u.serveControl)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	if u.ssdp, err = listenSSDP(tcpAddr.IP); err != nil {
+		// Clients may still be pointed at the description directly
+		errors.LogWarningInner(context.Background(), err, "NAT: UPnP discovery is unavailable")
+	} else {
+		go u.serveSSDP()
+	}
+	errors.LogInfo(context.Background(), "NAT: UPnP IGD listening on ", listener.Addr())
+	return u, nil
+}
+
+// listenSSDP joins the SSDP group on the interface of ip, or the default interface
+func listenSSDP(ip net.IP) (*net.UDPConn, error) {
+	group, _ := net.ResolveUDPAddr("udp4", ssdpAddress)
+	var iface *net.Interface
+	if interfaces, err := net.Interfaces(); err == nil && ip != nil && !ip.IsUnspecified() {
+		for i := range interfaces {
+			addrs, _ := interfaces[i].Addrs()
+			for _, addr := range addrs {
+				if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.Equal(ip) {
+					iface = &interfaces[i]
+				}
+			}
+		}
+	}
+	return net.ListenMulticastUDP("udp4", iface, group)
+}
+
+func (u *upnpServer) Close() error {
+	if u.ssdp != nil {
+		u.ssdp.Close()
+	}
+	return u.listener.Close()
+}
+
+func (u *upnpServer) serveSSDP() {
+	packet := make([]byte, 2048)
+	for {
+		n, from, err := u.ssdp.ReadFromUDP(packet)
+		if err != nil {
+			return
+		}
+		for _, response := range u.search(packet[:n]) {
+			u.ssdp.WriteToUDP(response, from)
+		}
+	}
+}
+
+// search answers an SSDP M-SEARCH request with a response for each search target of the
+// device it matches
+func (u *upnpServer) search(request []byte) [][]byte {
+	lines := strings.Split(string(request), "\r\n")
+	if len(lines) == 0 || !strings.HasPrefix(strings.ToUpper(lines[0]), "M-SEARCH * ") {
+		return nil
+	}
+	var target string
+	for _, line := range lines[1:] {
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "ST") {
+			target = strings.TrimSpace(value)
+		}
+	}
+
+	targets := []string{"upnp:rootdevice", u.uuid, upnpDeviceType,
+		"urn:schemas-upnp-org:device:WANDevice:1", "urn:schemas-upnp-org:device:WANConnectionDevice:1", upnpServiceType}
+	var responses [][]byte
+	for _, st := range targets {
+		if target != "ssdp:all" && target != st {
+			continue
+		}
+		usn := u.uuid
+		if st != u.uuid {
+			usn += "::" + st
+		}
+		responses = append(responses, []byte("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age="+strconv.Itoa(ssdpMaxAge)+"\r\n"+
+			"EXT:\r\n"+
+			"LOCATION: "+u.location+"\r\n"+
+			"SERVER: Xray UPnP/1.0\r\n"+
+			"ST: "+st+"\r\n"+
+			"USN: "+usn+"\r\n\r\n"))
+	}
+	return responses
+}
+
+func (u *upnpServer) serveDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>%s</deviceType><friendlyName>Xray NAT</friendlyName><manufacturer>Xray</manufacturer><modelName>NAT outbound</modelName><UDN>%s</UDN>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType><friendlyName>WAN Device</friendlyName><manufacturer>Xray</manufacturer><modelName>NAT outbound</modelName><UDN>%s-1</UDN>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType><friendlyName>WAN Connection Device</friendlyName><manufacturer>Xray</manufacturer><modelName>NAT outbound</modelName><UDN>%s-2</UDN>
+<serviceList><service>
+<serviceType>%s</serviceType><serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId><SCPDURL>%s</SCPDURL><controlURL>%s</controlURL><eventSubURL></eventSubURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>
+`, upnpDeviceType, u.uuid, u.uuid, u.uuid, upnpServiceType, upnpSCPDPath, upnpControlPath)
+}
+
+func (u *upnpServer) serveSCPD(w http.ResponseWriter, r *http.Request) {
+	var scpd strings.Builder
+	scpd.WriteString(`<?xml version="1.0"?>` + "\n" + `<scpd xmlns="urn:schemas-upnp-org:service-1-0">` +
+		"\n<specVersion><major>1</major><minor>0</minor></specVersion>\n<actionList>\n")
+	for _, action := range upnpActions {
+		scpd.WriteString("<action><name>" + action.name + "</name><argumentList>")
+		for _, argument := range action.inputs {
+			scpd.WriteString("<argument><name>" + argument[0] + "</name><direction>in</direction><relatedStateVariable>" + argument[1] + "</relatedStateVariable></argument>")
+		}
+		for _, argument := range action.outputs {
+			scpd.WriteString("<argument><name>" + argument[0] + "</name><direction>out</direction><relatedStateVariable>" + argument[1] + "</relatedStateVariable></argument>")
+		}
+		scpd.WriteString("</argumentList></action>\n")
+	}
+	scpd.WriteString("</actionList>\n<serviceStateTable>\n")
+	for _, variable := range upnpStateVariables {
+		scpd.WriteString(`<stateVariable sendEvents="no"><name>` + variable[0] + "</name><dataType>" + variable[1] + "</dataType></stateVariable>\n")
+	}
+	scpd.WriteString("</serviceStateTable>\n</scpd>\n")
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	io.WriteString(w, scpd.String())
+}
+
+// soapEnvelope is a SOAP request, of which only the arguments of the action are read
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName   xml.Name
+			Arguments []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+func (u *upnpServer) serveControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	var envelope soapEnvelope
+	if err := xml.NewDecoder(io.LimitReader(r.Body, upnpMaxRequestSize)).Decode(&envelope); err != nil {
+		upnpFault(w, upnpInvalidArgs, "Invalid Args")
+		return
+	}
+	action := envelope.Body.Action.XMLName.Local
+	if soapAction := strings.Trim(r.Header.Get("SOAPAction"), `"`); soapAction != "" && soapAction != upnpServiceType+"#"+action {
+		upnpFault(w, upnpInvalidAction, upnpErrorDescription(upnpInvalidAction))
+		return
+	}
+	arguments := make(map[string]string)
+	for _, argument := range envelope.Body.Action.Arguments {
+		arguments[argument.XMLName.Local] = strings.TrimSpace(argument.Value)
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	client := net.ParseIP(host)
+
+	var outputs [][2]string
+	var code int
+	switch action {
+	case "AddPortMapping":
+		code = u.addPortMapping(client, arguments)
+	case "DeletePortMapping":
+		code = u.deletePortMapping(client, arguments)
+	case "GetExternalIPAddress":
+		outputs = [][2]string{{"NewExternalIPAddress", u.pcp.externalIP.String()}}
+	default:
+		code = upnpInvalidAction
+	}
+	if code != 0 {
+		upnpFault(w, code, upnpErrorDescription(code))
+		return
+	}
+
+	var response bytes.Buffer
+	response.WriteString(`<?xml version="1.0"?>` + "\n" +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		"<u:" + action + `Response xmlns:u="` + upnpServiceType + `">`)
+	for _, output := range outputs {
+		response.WriteString("<" + output[0] + ">")
+		xml.EscapeText(&response, []byte(output[1]))
+		response.WriteString("</" + output[0] + ">")
+	}
+	response.WriteString("</u:" + action + "Response></s:Body></s:Envelope>\n")
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write(response.Bytes())
+}
+
+// upnpMapping parses the protocol and external port of a port mapping action
+func upnpMapping(arguments map[string]string) (xnet.Network, xnet.Port, bool) {
+	var network xnet.Network
+	switch strings.ToUpper(arguments["NewProtocol"]) {
+	case "TCP":
+		network = xnet.Network_TCP
+	case "UDP":
+		network = xnet.Network_UDP
+	default:
+		return network, 0, false
+	}
+	port, err := strconv.ParseUint(arguments["NewExternalPort"], 10, 16)
+	if err != nil {
+		return network, 0, false
+	}
+	return network, xnet.Port(port), true
+}
+
+// addPortMapping opens the mapping an AddPortMapping action asks for, returning the UPnP error
+// code on failure. IGD 1 leases of 0 are permanent; they are granted the longest lifetime.
+func (u *upnpServer) addPortMapping(client net.IP, arguments map[string]string) int {
+	s, h := u.pcp, u.pcp.handler
+	network, externalPort, ok := upnpMapping(arguments)
+	internalPort, err := strconv.ParseUint(arguments["NewInternalPort"], 10, 16)
+	lease, leaseErr := strconv.ParseUint(arguments["NewLeaseDuration"], 10, 32)
+	internalClient := net.ParseIP(arguments["NewInternalClient"])
+	if !ok || err != nil || internalPort == 0 || leaseErr != nil || internalClient == nil || client == nil {
+		return upnpInvalidArgs
+	}
+	if arguments["NewRemoteHost"] != "" {
+		// Mappings accept every remote host
+		return upnpInvalidArgs
+	}
+	if externalPort == 0 {
+		return upnpWildcardExternalPort
+	}
+	if !internalClient.Equal(client) {
+		return upnpNotAuthorized
+	}
+	if _, allowed := s.ports.indexOf(externalPort); !allowed {
+		return upnpConflict
+	}
+	if enabled := arguments["NewEnabled"]; enabled == "0" || strings.EqualFold(enabled, "false") {
+		// Disabling a mapping deletes it
+		if code := u.deletePortMapping(client, arguments); code != upnpNoSuchEntry {
+			return code
+		}
+		return 0
+	}
+
+	lifetime := uint32(lease)
+	if lifetime == 0 || lifetime > s.maxLifetime {
+		lifetime = s.maxLifetime
+	}
+	internal := xnet.Destination{Network: network, Address: xnet.IPAddress(client), Port: xnet.Port(internalPort)}
+	if m, found := h.lookupMapping(internal); found {
+		switch {
+		case m.owner != "upnp":
+			return upnpConflict
+		case m.external.Port == externalPort:
+			h.setMappingLifetime(m, time.Duration(lifetime)*time.Second)
+			return 0
+		}
+	} else if h.mappingsOf(client) >= s.maxMappings {
+		return upnpNoPortMapsAvailable
+	}
+	if _, err = h.openMapping(context.Background(), internal, s.mappingIP, s.externalIP, externalPort, time.Duration(lifetime)*time.Second, "upnp", "upnp"); err != nil {
+		errors.LogInfoInner(context.Background(), err, "NAT: UPnP failed to map ", internal)
+		return upnpConflict
+	}
+	return 0
+}
+
+// deletePortMapping deletes the mapping of the external port a DeletePortMapping action names
+func (u *upnpServer) deletePortMapping(client net.IP, arguments map[string]string) int {
+	h := u.pcp.handler
+	network, externalPort, ok := upnpMapping(arguments)
+	if !ok || client == nil {
+		return upnpInvalidArgs
+	}
+	code := upnpNoSuchEntry
+	h.mappings.Range(func(_, value any) bool {
+		m := value.(*inboundMapping)
+		if m.external.Network != network || m.external.Port != externalPort {
+			return true
+		}
+		if m.owner != "upnp" || !m.internal.Address.IP().Equal(client) {
+			code = upnpNotAuthorized
+			return false
+		}
+		h.removeSession(m.session.SessionID)
+		code = 0
+		return false
+	})
+	return code
+}
+
+func upnpErrorDescription(code int) string {
+	switch code {
+	case upnpInvalidAction:
+		return "Invalid Action"
+	case upnpInvalidArgs:
+		return "Invalid Args"
+	case upnpNotAuthorized:
+		return "Action not authorized"
+	case upnpNoSuchEntry:
+		return "NoSuchEntryInArray"
+	case upnpWildcardExternalPort:
+		return "WildCardNotPermittedInExtPort"
+	case upnpConflict:
+		return "ConflictInMappingEntry"
+	case upnpNoPortMapsAvailable:
+		return "NoPortMapsAvailable"
+	default:
+		return "Action Failed"
+	}
+}
+
+// upnpFault answers a control request with a UPnP error
+func upnpFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>
+`, code, description)
+}
@@ -0,0 +1,130 @@
+package nat
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newUPnPTestHandler(t *testing.T) (*Handler, string) {
+	handler := New()
+	if err := handler.Init(&Config{
+		SiteId:      "test-site",
+		PortControl: &PortControl{Listen: "127.0.0.1:0", MappingAddress: "127.0.0.1", UpnpListen: "127.0.0.1:0", MaxLifetime: 600},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return handler, "http://" + handler.portControl.upnp.listener.Addr().String()
+}
+
+// upnpCall invokes an action of WANIPConnection and returns the status and body of the response
+func upnpCall(t *testing.T, base, action string, arguments ...string) (int, string) {
+	t.Helper()
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:` + action + ` xmlns:u="` + upnpServiceType + `">`)
+	for i := 0; i+1 < len(arguments); i += 2 {
+		body.WriteString("<" + arguments[i] + ">" + arguments[i+1] + "</" + arguments[i] + ">")
+	}
+	body.WriteString("</u:" + action + "></s:Body></s:Envelope>")
+
+	request, _ := http.NewRequest(http.MethodPost, base+upnpControlPath, strings.NewReader(body.String()))
+	request.Header.Set("SOAPAction", `"`+upnpServiceType+"#"+action+`"`)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(data)
+}
+
+// freePort returns a TCP port that is free on the loopback address
+func freePort(t *testing.T) string {
+	listener := listenTCP(t)
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestUPnPPortMapping(t *testing.T) {
+	handler, base := newUPnPTestHandler(t)
+
+	response, err := http.Get(base + upnpDescriptionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	description, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(description), "<controlURL>"+upnpControlPath+"</controlURL>") {
+		t.Fatalf("Expected the control URL in the description, got %s", description)
+	}
+
+	if status, body := upnpCall(t, base, "GetExternalIPAddress"); status != http.StatusOK || !strings.Contains(body, "<NewExternalIPAddress>127.0.0.1</NewExternalIPAddress>") {
+		t.Errorf("Unexpected external address response %d %s", status, body)
+	}
+
+	internal := listenTCP(t)
+	internalPort := strconv.Itoa(internal.Addr().(*net.TCPAddr).Port)
+	externalPort := freePort(t)
+	mapping := func(client string) []string {
+		return []string{"NewRemoteHost", "", "NewExternalPort", externalPort, "NewProtocol", "TCP", "NewInternalPort", internalPort,
+			"NewInternalClient", client, "NewEnabled", "1", "NewPortMappingDescription", "test", "NewLeaseDuration", "0"}
+	}
+	if status, body := upnpCall(t, base, "AddPortMapping", mapping("127.0.0.2")...); status != http.StatusInternalServerError || !strings.Contains(body, "<errorCode>606</errorCode>") {
+		t.Errorf("Expected mappings for other clients to be refused, got %d %s", status, body)
+	}
+	if status, body := upnpCall(t, base, "AddPortMapping", mapping("127.0.0.1")...); status != http.StatusOK {
+		t.Fatalf("Unexpected AddPortMapping response %d %s", status, body)
+	}
+	mappings := handler.Mappings()
+	if len(mappings) != 1 || mappings[0].Protocol != "upnp" || mappings[0].External.Port.String() != externalPort {
+		t.Fatalf("Unexpected mappings %v", mappings)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", externalPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	accepted, err := internal.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Close()
+
+	if status, _ := upnpCall(t, base, "DeletePortMapping", "NewRemoteHost", "", "NewExternalPort", externalPort, "NewProtocol", "TCP"); status != http.StatusOK {
+		t.Errorf("Unexpected DeletePortMapping status %d", status)
+	}
+	if len(handler.Mappings()) != 0 {
+		t.Error("Expected the mapping to be deleted")
+	}
+	if status, body := upnpCall(t, base, "DeletePortMapping", "NewRemoteHost", "", "NewExternalPort", externalPort, "NewProtocol", "TCP"); status != http.StatusInternalServerError || !strings.Contains(body, "<errorCode>714</errorCode>") {
+		t.Errorf("Expected no such entry, got %d %s", status, body)
+	}
+}
+
+func TestUPnPSearch(t *testing.T) {
+	u := &upnpServer{uuid: "uuid:test", location: "http://240.2.2.1:5000" + upnpDescriptionPath}
+	search := func(target string) [][]byte {
+		return u.search([]byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: " + target + "\r\n\r\n"))
+	}
+
+	responses := search(upnpServiceType)
+	if len(responses) != 1 {
+		t.Fatalf("Expected a response for the service, got %d", len(responses))
+	}
+	for _, header := range []string{"LOCATION: http://240.2.2.1:5000/rootDesc.xml\r\n", "ST: " + upnpServiceType + "\r\n", "USN: uuid:test::" + upnpServiceType + "\r\n"} {
+		if !strings.Contains(string(responses[0]), header) {
+			t.Errorf("Expected %q in %q", header, responses[0])
+		}
+	}
+	if responses := search("ssdp:all"); len(responses) != 6 {
+		t.Errorf("Expected a response for every target, got %d", len(responses))
+	}
+	if responses := search("urn:schemas-upnp-org:device:MediaServer:1"); len(responses) != 0 {
+		t.Errorf("Expected no response for other devices, got %d", len(responses))
+	}
+}
@@ -0,0 +1,80 @@
+package nat
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// eventWatchers are the API clients watching the session events of a handler
+type eventWatchers struct {
+	sync.Mutex
+	count    atomic.Int32
+	closed   bool
+	channels map[chan *SessionEvent]struct{}
+	handler  *Handler
+}
+
+// watched reports whether any client watches the events, so events are only built when needed
+func (w *eventWatchers) watched() bool {
+	return w.count.Load() > 0
+}
+
+// publish hands event to every watcher, dropping it for those too slow to keep up
+func (w *eventWatchers) publish(event *SessionEvent) {
+	if !w.watched() {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	for events := range w.channels {
+		select {
+		case events <- event:
+		default:
+			atomic.AddInt64(&w.handler.eventDrops, 1)
+		}
+	}
+}
+
+// close ends the watches when the handler closes
+func (w *eventWatchers) close() {
+	w.Lock()
+	defer w.Unlock()
+	w.closed = true
+	for events := range w.channels {
+		close(events)
+		delete(w.channels, events)
+	}
+	w.count.Store(0)
+}
+
+// WatchSessionEvents returns the session create and teardown events from now on, until stop is
+// called or the handler closes, which closes the channel. Events are dropped while buffer
+// events wait to be received.
+func (h *Handler) WatchSessionEvents(buffer int) (events <-chan *SessionEvent, stop func()) {
+	w := &h.watchers
+	channel := make(chan *SessionEvent, buffer)
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		close(channel)
+		return channel, func() {}
+	}
+	if w.channels == nil {
+		w.channels = make(map[chan *SessionEvent]struct{})
+		w.handler = h
+	}
+	w.channels[channel] = struct{}{}
+	w.count.Add(1)
+	var once sync.Once
+	return channel, func() {
+		once.Do(func() {
+			w.Lock()
+			defer w.Unlock()
+			if _, ok := w.channels[channel]; ok {
+				delete(w.channels, channel)
+				close(channel)
+				w.count.Add(-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// webhookRetryDelay is the delay before the first retry of a failed webhook request, doubled
+// for each following one
+var webhookRetryDelay = 500 * time.Millisecond
+
+// webhookCloseTimeout bounds the delivery of the events still queued when the handler closes
+const webhookCloseTimeout = 5 * time.Second
+
+// sessionWebhook batches session events and POSTs them as JSON arrays to a webhook
+type sessionWebhook struct {
+	handler       *Handler
+	url           string
+	authorization string
+	client        *http.Client
+	batchSize     int
+	flush         time.Duration
+	retries       int
+	events        chan *SessionEvent
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+func newSessionWebhook(h *Handler, config *SessionWebhook) (*sessionWebhook, error) {
+	target, err := url.Parse(config.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, errors.New("session webhook requires an http or https URL, got ", config.Url)
+	}
+	w := &sessionWebhook{
+		handler:       h,
+		url:           config.Url,
+		authorization: config.Authorization,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		batchSize:     int(config.BatchSize),
+		flush:         time.Duration(config.FlushInterval) * time.Millisecond,
+		retries:       int(config.MaxRetries),
+		events:        make(chan *SessionEvent, 4096),
+		done:          make(chan struct{}),
+	}
+	if w.batchSize == 0 {
+		w.batchSize = 100
+	}
+	if w.flush == 0 {
+		w.flush = time.Second
+	}
+	if w.retries == 0 {
+		w.retries = 3
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Send queues an event; events are dropped while the queue is full
+func (w *sessionWebhook) Send(event *SessionEvent) {
+	select {
+	case w.events <- event:
+	default:
+		atomic.AddInt64(&w.handler.eventDrops, 1)
+		errors.LogDebug(context.Background(), "NAT session webhook queue full, dropping event")
+	}
+}
+
+func (w *sessionWebhook) run() {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Retries give up once the handler closed and the queue had its final chance
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return
+		}
+		timer := time.NewTimer(webhookCloseTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(w.flush)
+	defer ticker.Stop()
+
+	var pending []*SessionEvent
+	for {
+		select {
+		case event := <-w.events:
+			pending = append(pending, event)
+			if len(pending) >= w.batchSize {
+				pending = w.post(ctx, pending)
+			}
+		case <-ticker.C:
+			pending = w.post(ctx, pending)
+		case <-w.done:
+			for {
+				select {
+				case event := <-w.events:
+					pending = append(pending, event)
+				default:
+					for len(pending) > 0 {
+						pending = w.post(ctx, pending)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// post delivers the first batch of pending, retrying a failed request, and returns the events
+// left. The batch is dropped once the retries are exhausted or the webhook refuses it.
+func (w *sessionWebhook) post(ctx context.Context, pending []*SessionEvent) []*SessionEvent {
+	if len(pending) == 0 {
+		return pending
+	}
+	batch := pending[:min(len(pending), w.batchSize)]
+	body, err := json.Marshal(batch)
+	if err != nil {
+		errors.LogWarningInner(ctx, err, "failed to encode NAT session events")
+		return pending[len(batch):]
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := w.request(ctx, body)
+		if err == nil {
+			break
+		}
+		if !retry || attempt == w.retries || ctx.Err() != nil {
+			atomic.AddInt64(&w.handler.eventDrops, int64(len(batch)))
+			errors.LogWarningInner(ctx, err, "NAT session webhook dropped ", len(batch), " events")
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay *= 2
+	}
+	clear(batch)
+	return pending[len(batch):]
+}
+
+// request POSTs body to the webhook, reporting whether a failure is worth retrying: network
+// errors, throttling and server errors are, other refusals are not
+func (w *sessionWebhook) request(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if w.authorization != "" {
+		request.Header.Set("Authorization", w.authorization)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = errors.New("session webhook responded ", response.Status)
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500, err
+}
+
+// Close delivers the queued events, waiting for webhookCloseTimeout at most
+func (w *sessionWebhook) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
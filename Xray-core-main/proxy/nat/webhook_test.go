@@ -0,0 +1,150 @@
+package nat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func announceTestSession(h *Handler, port xnet.Port) *NATSession {
+	source := xnet.TCPDestination(xnet.ParseAddress("10.0.0.2"), port)
+	virtualDest := xnet.TCPDestination(xnet.ParseAddress("240.2.2.20"), 80)
+	realDest := xnet.TCPDestination(xnet.ParseAddress("192.168.1.20"), 80)
+	natSession := h.createNATSession(source, virtualDest, realDest, "outbound", classInteractive)
+	natSession.RuleID = "web"
+	h.announceSession(natSession)
+	return natSession
+}
+
+func TestSessionWebhook(t *testing.T) {
+	defer func(delay time.Duration) { webhookRetryDelay = delay }(webhookRetryDelay)
+	webhookRetryDelay = 10 * time.Millisecond
+
+	var access sync.Mutex
+	var requests int
+	var events []SessionEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access.Lock()
+		defer access.Unlock()
+		requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// The first request fails and is retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []SessionEvent
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil || len(batch) > 2 {
+			t.Errorf("Expected a batch of at most 2 events, got %d: %v", len(batch), err)
+		}
+		events = append(events, batch...)
+	}))
+	defer server.Close()
+
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "test-site", Limits: &ResourceLimits{MaxSessions: 2}, SessionWebhook: &SessionWebhook{
+		Url:           server.URL,
+		BatchSize:     2,
+		FlushInterval: 20,
+		Authorization: "Bearer secret",
+	}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The third session evicts the first
+	for port := range xnet.Port(3) {
+		announceTestSession(handler, 51000+port)
+	}
+	handler.FlushSessions("web")
+	// Closing delivers the events still queued
+	handler.Close()
+
+	access.Lock()
+	defer access.Unlock()
+	reasons := map[string]int{}
+	for _, event := range events {
+		reasons[event.Event+" "+event.Reason]++
+	}
+	if len(events) != 6 || reasons["create "] != 3 || reasons["teardown evicted"] != 1 || reasons["teardown flushed"] != 2 {
+		t.Errorf("Expected 3 creates, an eviction and 2 flushes, got %v", reasons)
+	}
+	if requests < 4 {
+		t.Errorf("Expected the failed batch to be retried, got %d requests", requests)
+	}
+	if drops := handler.Stats().EventDrops; drops != 0 {
+		t.Errorf("Expected no dropped events, got %d", drops)
+	}
+}
+
+func TestSessionWebhookRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "test-site", SessionWebhook: &SessionWebhook{Url: server.URL}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	announceTestSession(handler, 51000)
+	announceTestSession(handler, 51001)
+	start := time.Now()
+	handler.Close()
+	// A refused batch is dropped at once, not retried
+	if elapsed := time.Since(start); elapsed > webhookRetryDelay {
+		t.Errorf("Expected no retry of a refused batch, took %v", elapsed)
+	}
+	if drops := handler.Stats().EventDrops; drops != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", drops)
+	}
+
+	if err := New().Init(&Config{SiteId: "test-site", SessionWebhook: &SessionWebhook{Url: "udp://127.0.0.1:9"}}, nil); err == nil {
+		t.Error("Expected error for a webhook URL that is not http")
+	}
+}
+
+func TestWatchSessionEvents(t *testing.T) {
+	handler := New()
+	if err := handler.Init(&Config{SiteId: "test-site"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	events, stop := handler.WatchSessionEvents(1)
+	stopped, stopNow := handler.WatchSessionEvents(1)
+	stopNow()
+	if _, ok := <-stopped; ok {
+		t.Error("Expected a stopped watch to be closed")
+	}
+
+	natSession := announceTestSession(handler, 51000)
+	endSession(natSession, SessionEndExpired)
+	handler.removeSession(natSession.SessionID)
+	if event := <-events; event.Event != SessionEventCreate || event.SessionID != natSession.SessionID {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	// The teardown did not fit the buffer of the watcher
+	if drops := handler.Stats().EventDrops; drops != 1 {
+		t.Errorf("Expected the teardown to be dropped, got %d drops", drops)
+	}
+	natSession = announceTestSession(handler, 51001)
+	if event := <-events; event.Event != SessionEventCreate {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	endSession(natSession, SessionEndExpired)
+	handler.removeSession(natSession.SessionID)
+	if event := <-events; event.Reason != SessionEndExpired {
+		t.Errorf("Expected an expired teardown, got %+v", event)
+	}
+
+	handler.Close()
+	if _, ok := <-events; ok {
+		t.Error("Expected the watch to end with the handler")
+	}
+	stop()
+}
@@ -0,0 +1,147 @@
+package nat
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// WireGuard message types
+const (
+	wireGuardInitiation = 1
+	wireGuardResponse   = 2
+	wireGuardCookie     = 3
+	wireGuardTransport  = 4
+)
+
+// wireGuardTimeout is the Reject-After-Time of WireGuard: peers rekey at least this often, so a
+// mapping idle for shorter may still carry the tunnel
+const wireGuardTimeout = 180 * time.Second
+
+// maxWireGuardPins is the number of receiver indices a mapping stays pinned to, enough for the
+// keypairs a peer keeps across a rekey
+const maxWireGuardPins = 3
+
+// wireGuardPin identifies a WireGuard tunnel through a rule: the real endpoint of the peer and
+// the receiver index the peer chose for it, which survive a roaming client
+type wireGuardPin struct {
+	rule  string
+	peer  string
+	index uint32
+}
+
+// wireGuardMessage returns the type of a WireGuard message and its index, the receiver index of
+// cookie replies and transport data and the sender index of handshakes; false when payload is
+// not a WireGuard message
+func wireGuardMessage(payload []byte) (byte, uint32, bool) {
+	if len(payload) < 32 || payload[1] != 0 || payload[2] != 0 || payload[3] != 0 {
+		return 0, 0, false
+	}
+	switch payload[0] {
+	case wireGuardInitiation:
+		if len(payload) != 148 {
+			return 0, 0, false
+		}
+	case wireGuardResponse:
+		if len(payload) != 92 {
+			return 0, 0, false
+		}
+	case wireGuardCookie:
+		if len(payload) != 64 {
+			return 0, 0, false
+		}
+	case wireGuardTransport:
+		if len(payload)%16 != 0 {
+			return 0, 0, false
+		}
+	default:
+		return 0, 0, false
+	}
+	return payload[0], binary.LittleEndian.Uint32(payload[4:8]), true
+}
+
+// markWireGuard raises the timeout of a flow found to carry WireGuard to wireGuardTimeout
+func (h *Handler) markWireGuard(flow *udpFlow) {
+	if flow.session.wireGuard.Swap(true) {
+		return
+	}
+	timeout := h.sessionTimeout(flow.session)
+	flow.timer.SetTimeout(timeout)
+	h.sessions.Schedule(flow.session, flow.session.LastActivity().Add(timeout))
+	errors.LogDebug(flow.ctx, "UDP session ", flow.session.SessionID, " carries WireGuard, idle timeout ", timeout)
+}
+
+// pinWireGuard pins the mapping to the tunnel of a WireGuard transport datagram sent to real,
+// so a new source of the client continues the tunnel through the same mapping
+func (m *udpMapping) pinWireGuard(flow *udpFlow, payload []byte, real xnet.Destination) {
+	msgType, index, ok := wireGuardMessage(payload)
+	if !ok {
+		return
+	}
+	m.handler.markWireGuard(flow)
+	if msgType != wireGuardTransport {
+		return
+	}
+	pin := wireGuardPin{rule: m.rule.RuleId, peer: real.NetAddr(), index: index}
+
+	m.Lock()
+	defer m.Unlock()
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+	if n := len(m.pins); n > 0 && m.pins[n-1] == pin {
+		return
+	}
+	m.pins = append(m.pins, pin)
+	if len(m.pins) > maxWireGuardPins {
+		m.handler.wireGuardPins.CompareAndDelete(m.pins[0], m)
+		m.pins = m.pins[1:]
+	}
+	m.handler.wireGuardPins.Store(pin, m)
+}
+
+// roamedFrom returns the mapping another flow pinned to the WireGuard tunnel the first datagram
+// of flow continues, nil when it continues none
+func (m *udpMapping) roamedFrom(flow *udpFlow, mb buf.MultiBuffer) *udpMapping {
+	if mb.IsEmpty() {
+		return nil
+	}
+	b := mb[0]
+	msgType, index, ok := wireGuardMessage(b.Bytes())
+	if !ok || msgType != wireGuardTransport {
+		return nil
+	}
+	virtual := flow.session.VirtualDest
+	if b.UDP != nil {
+		virtual = *b.UDP
+	}
+	real, allowed := m.translate(virtual)
+	if !allowed {
+		return nil
+	}
+	pinned, ok := m.handler.wireGuardPins.Load(wireGuardPin{rule: m.rule.RuleId, peer: real.NetAddr(), index: index})
+	if !ok || pinned.(*udpMapping) == m {
+		return nil
+	}
+	return pinned.(*udpMapping)
+}
+
+// handOver makes the mapping relay flow in place of the flow it relays, whose session ends as
+// roamed; false when the mapping is closed
+func (m *udpMapping) handOver(flow *udpFlow) bool {
+	prev := m.flow.Load()
+	if prev == nil || prev == flow || !m.flow.CompareAndSwap(prev, flow) {
+		return false
+	}
+	h := m.handler
+	h.markWireGuard(flow)
+	errors.LogInfo(flow.ctx, "WireGuard peer ", prev.session.VirtualSource, " roamed to ", flow.session.VirtualSource, ", session ", flow.session.SessionID, " continues the UDP mapping of session ", prev.session.SessionID)
+	endSession(prev.session, SessionEndRoamed)
+	h.removeSession(prev.session.SessionID)
+	return true
+}
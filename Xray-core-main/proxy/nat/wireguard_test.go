@@ -0,0 +1,150 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// wireGuardDatagram returns a WireGuard message of msgType and size with index at the index field
+func wireGuardDatagram(msgType byte, size int, index uint32) []byte {
+	payload := make([]byte, size)
+	payload[0] = msgType
+	binary.LittleEndian.PutUint32(payload[4:8], index)
+	return payload
+}
+
+func TestWireGuardMessage(t *testing.T) {
+	for _, tt := range []struct {
+		payload []byte
+		ok      bool
+	}{
+		{wireGuardDatagram(wireGuardInitiation, 148, 7), true},
+		{wireGuardDatagram(wireGuardResponse, 92, 7), true},
+		{wireGuardDatagram(wireGuardCookie, 64, 7), true},
+		{wireGuardDatagram(wireGuardTransport, 32, 7), true},
+		{wireGuardDatagram(wireGuardTransport, 1440, 7), true},
+		{wireGuardDatagram(wireGuardInitiation, 149, 7), false},
+		{wireGuardDatagram(wireGuardTransport, 40, 7), false},
+		{wireGuardDatagram(5, 64, 7), false},
+		{[]byte("a datagram of another protocol"), false},
+	} {
+		msgType, index, ok := wireGuardMessage(tt.payload)
+		if ok != tt.ok || ok && (msgType != tt.payload[0] || index != 7) {
+			t.Errorf("Unexpected message %d, index %d, %v of %d bytes of type %d", msgType, index, ok, len(tt.payload), tt.payload[0])
+		}
+	}
+}
+
+func TestWireGuardRoaming(t *testing.T) {
+	server := listenUDP(t)
+	rule := &NATRule{RuleId: "tunnel", VirtualDestination: "240.2.2.20", RealDestination: "127.0.0.1", Protocol: "udp"}
+	handler := New()
+	defer handler.Close()
+	if err := handler.Init(&Config{
+		SiteId:         "test-site",
+		Rules:          []*NATRule{rule},
+		SessionTimeout: &SessionTimeout{UdpTimeout: 30},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	events, stop := handler.WatchSessionEvents(16)
+	defer stop()
+
+	virtual := xnet.UDPDestination(xnet.ParseAddress("240.2.2.20"), xnet.Port(server.LocalAddr().(*net.UDPAddr).Port))
+	type client struct {
+		uplink   *pipe.Writer
+		downlink *pipe.Reader
+		done     chan error
+	}
+	connect := func(source string) client {
+		uplinkReader, uplinkWriter := pipe.New()
+		downlinkReader, downlinkWriter := pipe.New()
+		c := client{uplink: uplinkWriter, downlink: downlinkReader, done: make(chan error, 1)}
+		ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Source: xnet.UDPDestination(xnet.ParseAddress(source), 51820)})
+		go func() {
+			c.done <- handler.handleNATOutbound(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, virtual, udpTestDialer{}, rule)
+		}()
+		t.Cleanup(func() {
+			uplinkWriter.Close()
+			<-c.done
+		})
+		return c
+	}
+	send := func(c client, payload []byte) *net.UDPAddr {
+		b := buf.New()
+		b.Write(payload)
+		b.UDP = &virtual
+		if err := c.uplink.WriteMultiBuffer(buf.MultiBuffer{b}); err != nil {
+			t.Fatal(err)
+		}
+		server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, mapped, err := server.ReadFromUDP(make([]byte, 2048))
+		if err != nil {
+			t.Fatalf("The peer did not receive the datagram: %v", err)
+		}
+		return mapped
+	}
+
+	// The peer chose receiver index 42 for the tunnel
+	first := connect("10.0.0.5")
+	mapped := send(first, wireGuardDatagram(wireGuardTransport, 64, 42))
+	sessions := handler.Sessions()
+	if len(sessions) != 1 || handler.sessionTimeout(sessions[0]) != wireGuardTimeout {
+		t.Fatalf("Expected the WireGuard session to idle for %v, got %v", wireGuardTimeout, sessions)
+	}
+	roamed := sessions[0].SessionID
+
+	// The client roams to a new source and continues the tunnel from the same real endpoint
+	second := connect("10.0.0.9")
+	if again := send(second, wireGuardDatagram(wireGuardTransport, 64, 42)); again.String() != mapped.String() {
+		t.Errorf("Expected the roamed client to keep the mapping %v, got %v", mapped, again)
+	}
+	if sessions := handler.Sessions(); len(sessions) != 1 || sessions[0].VirtualSource.Address.String() != "10.0.0.9" {
+		t.Errorf("Expected the session of the new source only, got %v", sessions)
+	}
+	select {
+	case err := <-first.done:
+		first.done <- err
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the flow of the old source to end")
+	}
+	timeout := time.After(2 * time.Second)
+	for reason := ""; reason != SessionEndRoamed; {
+		select {
+		case event := <-events:
+			if event.Event == SessionEventTeardown && event.SessionID == roamed {
+				reason = event.Reason
+				if reason != SessionEndRoamed {
+					t.Fatalf("Expected the old session to end as roamed, got %q", reason)
+				}
+			}
+		case <-timeout:
+			t.Fatal("Expected the teardown of the old session")
+		}
+	}
+
+	// Return traffic reaches the new source
+	if _, err := server.WriteToUDP(wireGuardDatagram(wireGuardTransport, 32, 7), mapped); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := second.downlink.ReadMultiBufferTimeout(2 * time.Second)
+	if err != nil || mb.IsEmpty() || mb.Len() != 32 {
+		t.Fatalf("Expected the reply at the new source: %v", err)
+	}
+	buf.ReleaseMulti(mb)
+
+	// Other traffic is keyed on the 5-tuple as before
+	other := connect("10.0.0.10")
+	if source := send(other, []byte("not wireguard")); source.String() == mapped.String() {
+		t.Error("Expected another flow to get a mapping of its own")
+	}
+}
@@ -113,10 +113,12 @@ func (d *DefaultSystemDialer) Dial(ctx context.Context, src net.Address, dest ne
 	}
 	dialer := &net.Dialer{
 		Timeout:         time.Second * 16,
-		LocalAddr:       resolveSrcAddr(dest.Network, src),
 		KeepAlive:       keepAlive,
 		KeepAliveConfig: keepAliveConfig,
 	}
+	if !hasBindAddr(sockopt) {
+		dialer.LocalAddr = resolveSrcAddr(dest.Network, src)
+	}
 
 	if sockopt != nil || len(d.controllers) > 0 {
 		if sockopt != nil && sockopt.TcpMptcp {
@@ -128,18 +130,22 @@ func (d *DefaultSystemDialer) Dial(ctx context.Context, src net.Address, dest ne
 					errors.LogInfoInner(ctx, err, "failed to apply external controller")
 				}
 			}
-			return c.Control(func(fd uintptr) {
+			var bindErr error
+			if err := c.Control(func(fd uintptr) {
 				if sockopt != nil {
 					if err := applyOutboundSocketOptions(network, address, fd, sockopt); err != nil {
 						errors.LogInfoInner(ctx, err, "failed to apply socket options")
 					}
-					if dest.Network == net.Network_UDP && hasBindAddr(sockopt) {
+					if hasBindAddr(sockopt) {
 						if err := bindAddr(fd, sockopt.BindAddress, sockopt.BindPort); err != nil {
-							errors.LogInfoInner(ctx, err, "failed to bind source address to ", sockopt.BindAddress)
+							bindErr = errors.New("failed to bind source address to ", net.IPAddress(sockopt.BindAddress), ":", sockopt.BindPort).Base(err)
 						}
 					}
 				}
-			})
+			}); err != nil {
+				return err
+			}
+			return bindErr
 		}
 	}
 